@@ -3,6 +3,8 @@ package agent
 import (
 	"regexp"
 	"time"
+
+	"github.com/buildkite/agent/v3/agent/plugin"
 )
 
 // AgentConfiguration is the run-time configuration for an agent that
@@ -16,24 +18,46 @@ type AgentConfiguration struct {
 	SocketsPath                 string
 	GitMirrorsPath              string
 	GitMirrorsLockTimeout       int
+	CheckoutRetryAttempts       int
+	CheckoutRetryBackoff        time.Duration
 	GitMirrorsSkipUpdate        bool
+	GitMirrorsWorktree          bool
+	EphemeralBuildDir           bool
 	PluginsPath                 string
+	PluginLockFile              string
+	PluginsAllowDrift           bool
+	VCS                         string
 	GitCheckoutFlags            string
 	GitCloneFlags               string
+	GitCloneBundle              string
 	GitCloneMirrorFlags         string
 	GitCleanFlags               string
 	GitFetchFlags               string
 	GitSubmodules               bool
+	GitSubmoduleCloneFlags      []string
+	GitSubmoduleCredentials     []string
 	AllowedRepositories         []*regexp.Regexp
 	AllowedPlugins              []*regexp.Regexp
+	PluginAllowlist             plugin.Allowlist // AllowedPlugins, with any SHAs plugins must resolve to
 	AllowedEnvironmentVariables []*regexp.Regexp
 	SSHKeyscan                  bool
 	CommandEval                 bool
+	CommandSandbox              string
+	CommandSandboxAllowPaths    []string
+	CommandEnvAllow             []string
+	CommandEnvDeny              []string
+	SoftFailExitStatuses        []string
+	SoftFailClassifier          string
+	ProtectedEnv                []string
+	ProtectedEnvEnforce         bool
 	PluginsEnabled              bool
 	PluginValidation            bool
 	LocalHooksEnabled           bool
 	StrictSingleHooks           bool
+	HookInterpreters            []string
 	RunInPty                    bool
+	PTYRows                     uint16
+	PTYCols                     uint16
 	KubernetesExec              bool
 
 	SigningJWKSFile  string // Where to find the key to sign pipeline uploads with (passed through to jobs, they might be uploading pipelines)
@@ -41,26 +65,44 @@ type AgentConfiguration struct {
 	SigningAWSKMSKey string // The KMS key ID to sign pipeline uploads with
 	DebugSigning     bool   // Whether to print step payloads when signing them
 
+	ArtifactEncryptionKeyFile   string // Where to find the key used to encrypt artifacts (passed through to jobs)
+	ArtifactEncryptionAWSKMSKey string // The KMS key ID used to encrypt artifacts (passed through to jobs)
+
 	VerificationJWKS             any    // The set of keys to verify jobs with
 	VerificationFailureBehaviour string // What to do if job verification fails (one of `block` or `warn`)
 
 	ANSITimestamps               bool
 	TimestampLines               bool
+	TimestampLinesFormat         string
+	CollapseInteractiveOutput    bool
 	HealthCheckAddr              string
 	DisconnectAfterJob           bool
 	DisconnectAfterIdleTimeout   int
 	CancelGracePeriod            int
 	SignalGracePeriod            time.Duration
+	HookTimeout                  time.Duration
 	EnableJobLogTmpfile          bool
 	JobLogPath                   string
+	JobLogFormat                 string
+	PhaseTimings                 string
+	DiagnosticsBundle            bool
+	JobLogSpoolPath              string
+	JobLogSpoolMaxSize           uint64
+	LogUploadMaxBandwidth        uint64
+	MaxJobLogSize                uint64
+	JobCgroupParent              string
+	JobMemoryLimit               uint64
+	JobCPULimit                  float64
 	WriteJobLogsToStdout         bool
 	LogFormat                    string
 	Shell                        string
 	Profile                      string
 	RedactedVars                 []string
 	AcquireJob                   string
+	AcquireJobs                  []string
 	TracingBackend               string
 	TracingServiceName           string
+	TracingHookOutputLimit       int
 	TraceContextEncoding         string
 	DisableWarningsFor           []string
 	AllowMultipartArtifactUpload bool
@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/buildkite/agent/v3/core"
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/shell"
+)
+
+// findAgentHooks returns the paths of the agent hooks called name, in the
+// hooks path and then each of the additional hooks paths.
+func (r *JobRunner) findAgentHooks(name string) []string {
+	dirs := append([]string{r.conf.AgentConfiguration.HooksPath}, r.conf.AgentConfiguration.AdditionalHooksPaths...)
+
+	var paths []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		p, err := hook.Find(dir, name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				r.agentLogger.Error("Error finding %q hook in %q: %v", name, dir, err)
+			}
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// agentJobHookEnv returns the env passed to the agent-pre-job and
+// agent-post-job hooks, describing the job.
+func (r *JobRunner) agentJobHookEnv() *env.Environment {
+	environ := env.New()
+	environ.Set("BUILDKITE_JOB_ID", r.conf.Job.ID)
+	for _, name := range []string{
+		"BUILDKITE_AGENT_NAME",
+		"BUILDKITE_BUILD_ID",
+		"BUILDKITE_BUILD_NUMBER",
+		"BUILDKITE_ORGANIZATION_SLUG",
+		"BUILDKITE_PIPELINE_SLUG",
+		"BUILDKITE_STEP_KEY",
+		"BUILDKITE_LABEL",
+	} {
+		if v, ok := r.conf.Job.Env[name]; ok {
+			environ.Set(name, v)
+		}
+	}
+	return environ
+}
+
+// executeAgentPreJobHooks runs the agent-pre-job hooks, in the agent rather
+// than the job executor, before the job is run. Like the pre-bootstrap hook,
+// if any of them fail the job is refused.
+func (r *JobRunner) executeAgentPreJobHooks(ctx context.Context) error {
+	environ := r.agentJobHookEnv()
+	environ.Set("BUILDKITE_HOOK_PHASE", "agent-pre-job")
+
+	for _, p := range r.findAgentHooks("agent-pre-job") {
+		if err := r.executeAgentJobHook(ctx, "agent-pre-job", p, environ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeAgentPostJobHooks runs the agent-post-job hooks once the job has
+// finished, with its exit status. The job has already finished, so failures
+// are only logged.
+func (r *JobRunner) executeAgentPostJobHooks(ctx context.Context, exit core.ProcessExit) {
+	environ := r.agentJobHookEnv()
+	environ.Set("BUILDKITE_HOOK_PHASE", "agent-post-job")
+	environ.Set("BUILDKITE_JOB_EXIT_STATUS", strconv.Itoa(exit.Status))
+	if exit.Signal != "" {
+		environ.Set("BUILDKITE_JOB_SIGNAL", exit.Signal)
+	}
+	if exit.SignalReason != "" {
+		environ.Set("BUILDKITE_JOB_SIGNAL_REASON", exit.SignalReason)
+	}
+
+	for _, p := range r.findAgentHooks("agent-post-job") {
+		// Keep going, so each hook gets a chance to clean up.
+		_ = r.executeAgentJobHook(ctx, "agent-post-job", p, environ)
+	}
+}
+
+// executeAgentJobHook runs a single agent job hook, logging its output to the
+// agent log.
+func (r *JobRunner) executeAgentJobHook(ctx context.Context, name, path string, environ *env.Environment) error {
+	r.agentLogger.Info("Running %s hook %q for job %s", name, path, r.conf.Job.ID)
+
+	sh, err := shell.New(
+		shell.WithStdout(LogWriter{l: r.agentLogger}),
+	)
+	if err != nil {
+		return err
+	}
+
+	script, err := sh.Script(path)
+	if err != nil {
+		r.agentLogger.Error("Finished %s hook %q: script not runnable: %v", name, path, err)
+		return err
+	}
+	if err := script.Run(ctx, shell.ShowPrompt(false), shell.WithExtraEnv(environ)); err != nil {
+		r.agentLogger.Error("Finished %s hook %q: %v", name, path, err)
+		return fmt.Errorf("%s hook %q: %w", name, path, err)
+	}
+	r.agentLogger.Info("Finished %s hook %q", name, path)
+	return nil
+}
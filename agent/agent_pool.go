@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/status"
@@ -32,6 +33,7 @@ func (ap *AgentPool) StartStatusServer(ctx context.Context, l logger.Logger, add
 	mux.HandleFunc("/", healthHandler(l))
 	mux.HandleFunc("/status", status.Handle)
 	mux.HandleFunc("/status.json", ap.statusJSONHandler(l))
+	mux.Handle("/metrics", ap.prometheusHandler())
 
 	for _, worker := range ap.workers {
 		mux.HandleFunc("/agent/"+strconv.Itoa(worker.spawnIndex), worker.healthHandler())
@@ -103,42 +105,73 @@ func (r *AgentPool) Stop(graceful bool) {
 
 func (ap *AgentPool) statusJSONHandler(l logger.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		type agentWorkerStatus struct {
-			Status       agentWorkerState `json:"status"`
-			CurrentJobID string           `json:"current_job_id,omitempty"`
-			ID           string           `json:"id"`
-			SpawnIndex   int              `json:"spawn_index"`
+		w.Header().Set("Content-Type", "application/json")
+
+		err := json.NewEncoder(w).Encode(ap.status(time.Now()))
+		if err != nil {
+			l.Error("Could not encode status.json response: %v", err)
 		}
+	}
+}
 
-		aggregateState := agentWorkerStateIdle
-		statuses := make([]agentWorkerStatus, 0, len(ap.workers))
-		for _, worker := range ap.workers {
-			// If any worker is busy, the aggregate state is busy
-			workerState := worker.getState()
-			if workerState == agentWorkerStateBusy {
-				aggregateState = agentWorkerStateBusy
-			}
-			statuses = append(statuses, agentWorkerStatus{
-				ID:           worker.agent.UUID,
-				Status:       workerState,
-				CurrentJobID: worker.getCurrentJobID(),
-				SpawnIndex:   worker.spawnIndex,
-			})
+// agentPoolStatus is the response served at /status.json.
+type agentPoolStatus struct {
+	Health          string              `json:"health"`
+	AggregateStatus agentWorkerState    `json:"aggregate_status"`
+	Workers         []agentWorkerStatus `json:"workers"`
+}
+
+type agentWorkerStatus struct {
+	Status                    agentWorkerState `json:"status"`
+	CurrentJobID              string           `json:"current_job_id,omitempty"`
+	CurrentJobStartedAt       *time.Time       `json:"current_job_started_at,omitempty"`
+	CurrentJobDurationSeconds float64          `json:"current_job_duration_seconds,omitempty"`
+	LastHeartbeatAt           *time.Time       `json:"last_heartbeat_at,omitempty"`
+	LastHeartbeatError        string           `json:"last_heartbeat_error,omitempty"`
+	ID                        string           `json:"id"`
+	SpawnIndex                int              `json:"spawn_index"`
+}
+
+// status reports the state of each worker in the pool as of now. The pool is
+// busy if any of its workers are.
+func (ap *AgentPool) status(now time.Time) agentPoolStatus {
+	aggregateState := agentWorkerStateIdle
+	statuses := make([]agentWorkerStatus, 0, len(ap.workers))
+	for _, worker := range ap.workers {
+		// If any worker is busy, the aggregate state is busy
+		workerState := worker.getState()
+		if workerState == agentWorkerStateBusy {
+			aggregateState = agentWorkerStateBusy
 		}
 
-		err := json.NewEncoder(w).Encode(struct {
-			Health          string              `json:"health"`
-			AggregateStatus agentWorkerState    `json:"aggregate_status"`
-			Workers         []agentWorkerStatus `json:"workers"`
-		}{
-			Health:          "ok",
-			AggregateStatus: aggregateState,
-			Workers:         statuses,
-		})
+		status := agentWorkerStatus{
+			ID:         worker.getAgentUUID(),
+			Status:     workerState,
+			SpawnIndex: worker.spawnIndex,
+		}
 
-		if err != nil {
-			l.Error("Could not encode status.json response: %v", err)
+		jobID, startedAt := worker.getCurrentJob()
+		if jobID != "" {
+			status.CurrentJobID = jobID
+			status.CurrentJobStartedAt = &startedAt
+			status.CurrentJobDurationSeconds = now.Sub(startedAt).Seconds()
+		}
+
+		lastHeartbeat, heartbeatErr := worker.getLastHeartbeat()
+		if !lastHeartbeat.IsZero() {
+			status.LastHeartbeatAt = &lastHeartbeat
+		}
+		if heartbeatErr != nil {
+			status.LastHeartbeatError = heartbeatErr.Error()
 		}
+
+		statuses = append(statuses, status)
+	}
+
+	return agentPoolStatus{
+		Health:          "ok",
+		AggregateStatus: aggregateState,
+		Workers:         statuses,
 	}
 }
 
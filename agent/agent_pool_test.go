@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentPoolStatus(t *testing.T) {
+	t.Parallel()
+
+	idle := &AgentWorker{
+		agent:      &api.AgentRegisterResponse{UUID: "idle-uuid"},
+		state:      agentWorkerStateIdle,
+		spawnIndex: 1,
+	}
+	heartbeat := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	idle.stats.lastHeartbeat = heartbeat
+
+	busy := &AgentWorker{
+		agent:      &api.AgentRegisterResponse{UUID: "busy-uuid"},
+		spawnIndex: 2,
+	}
+	busy.setBusy("job-uuid")
+	busy.stats.lastHeartbeatError = errors.New("connection refused")
+	startedAt := busy.currentJobStartedAt
+
+	pool := NewAgentPool([]*AgentWorker{idle, busy})
+	got := pool.status(startedAt.Add(90 * time.Second))
+
+	assert.Equal(t, agentWorkerStateBusy, got.AggregateStatus)
+	assert.Equal(t, []agentWorkerStatus{
+		{
+			ID:              "idle-uuid",
+			Status:          agentWorkerStateIdle,
+			SpawnIndex:      1,
+			LastHeartbeatAt: &heartbeat,
+		},
+		{
+			ID:                        "busy-uuid",
+			Status:                    agentWorkerStateBusy,
+			SpawnIndex:                2,
+			CurrentJobID:              "job-uuid",
+			CurrentJobStartedAt:       &startedAt,
+			CurrentJobDurationSeconds: 90,
+			LastHeartbeatError:        "connection refused",
+		},
+	}, got.Workers)
+
+	busy.setIdle()
+	assert.Equal(t, agentWorkerStateIdle, pool.status(time.Now()).AggregateStatus)
+}
+
+func TestAgentPoolStatusJSONHandler(t *testing.T) {
+	t.Parallel()
+
+	worker := &AgentWorker{
+		agent:      &api.AgentRegisterResponse{UUID: "worker-uuid"},
+		spawnIndex: 1,
+	}
+	worker.setBusy("job-uuid")
+
+	rec := httptest.NewRecorder()
+	NewAgentPool([]*AgentWorker{worker}).statusJSONHandler(logger.Discard)(rec, httptest.NewRequest("GET", "/status.json", nil))
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "busy", got["aggregate_status"])
+
+	workers := got["workers"].([]any)
+	require.Len(t, workers, 1)
+	status := workers[0].(map[string]any)
+	assert.Equal(t, "job-uuid", status["current_job_id"])
+	assert.Contains(t, status, "current_job_started_at")
+	assert.NotContains(t, status, "last_heartbeat_at")
+}
@@ -2,15 +2,21 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/core"
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/housekeeping"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
@@ -40,6 +46,35 @@ type AgentWorkerConfig struct {
 
 	// Stdout of the parent agent process. Used for job log stdout writing arg, for simpler containerized log collection.
 	AgentStdout io.Writer
+
+	// Removes old checkouts and git mirrors before running jobs, if disk
+	// housekeeping is enabled. Shared between all workers.
+	Housekeeper *housekeeping.Manager
+
+	// Limits how many jobs the workers run at once, if set. Shared between
+	// all workers.
+	JobLimiter *JobLimiter
+
+	// Assigns the host's GPUs to jobs, if enabled. Shared between all
+	// workers.
+	GPUAllocator *GPUAllocator
+
+	// Reports whether job acceptance was paused with `buildkite-agent pause`,
+	// if the Agent API is enabled. Shared between all workers.
+	Pauser Pauser
+
+	// The tags the workers registered with, if they can change while the
+	// agent runs. Shared between all workers.
+	Tags *RegistrationTags
+
+	// Registers a new agent with the given tags. Used to re-register when
+	// Tags change.
+	Register func(ctx context.Context, tags []string) (*api.AgentRegisterResponse, error)
+}
+
+// Pauser reports whether job acceptance has been paused.
+type Pauser interface {
+	Paused(ctx context.Context) (bool, error)
 }
 
 type agentStats struct {
@@ -97,20 +132,46 @@ type AgentWorker struct {
 	// JobRunner here
 	jobRunner jobRunner
 
+	// The jobs this worker has finished, for the status page
+	history jobHistory
+
 	// Stdout of the parent agent process. Used for job log stdout writing arg, for simpler containerized log collection.
 	agentStdout io.Writer
 
+	// Disk housekeeping, if enabled
+	housekeeper *housekeeping.Manager
+
+	// Limits on concurrent jobs across the pool, if any
+	jobLimiter *JobLimiter
+
+	// Assigns GPUs to jobs, if enabled
+	gpuAllocator *GPUAllocator
+
+	// Whether job acceptance was paused with `buildkite-agent pause`, if known
+	pauser Pauser
+
+	// The tags to register with, if they can change, the version of them
+	// this worker registered with, and how to register again
+	tags           *RegistrationTags
+	tagsVersion    int
+	register       func(ctx context.Context, tags []string) (*api.AgentRegisterResponse, error)
+	reregisterWait time.Time
+
 	// Are we doing something right now?
-	state        agentWorkerState
-	currentJobID string
-	stateMtx     sync.Mutex
+	state               agentWorkerState
+	currentJobID        string
+	currentJobStartedAt time.Time
+	currentJob          *api.Job
+	currentJobRunner    jobRunner
+	stateMtx            sync.Mutex
 }
 
 type agentWorkerState string
 
 const (
-	agentWorkerStateIdle agentWorkerState = "idle"
-	agentWorkerStateBusy agentWorkerState = "busy"
+	agentWorkerStateIdle   agentWorkerState = "idle"
+	agentWorkerStateBusy   agentWorkerState = "busy"
+	agentWorkerStatePaused agentWorkerState = "paused"
 )
 
 func (a *AgentWorker) setBusy(jobID string) {
@@ -118,6 +179,7 @@ func (a *AgentWorker) setBusy(jobID string) {
 	defer a.stateMtx.Unlock()
 	a.state = agentWorkerStateBusy
 	a.currentJobID = jobID
+	a.currentJobStartedAt = time.Now()
 }
 
 func (a *AgentWorker) setIdle() {
@@ -125,6 +187,119 @@ func (a *AgentWorker) setIdle() {
 	defer a.stateMtx.Unlock()
 	a.state = agentWorkerStateIdle
 	a.currentJobID = ""
+	a.currentJobStartedAt = time.Time{}
+	a.currentJob = nil
+	a.currentJobRunner = nil
+}
+
+// setJobRunner records the job runner running the current job, so the status
+// page can show how the job is going.
+func (a *AgentWorker) setJobRunner(job *api.Job, jr jobRunner) {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	a.currentJob = job
+	a.currentJobRunner = jr
+}
+
+// updatePaused pauses an idle worker while the pool is running as many jobs as
+// it may, or while job acceptance has been paused with `buildkite-agent pause`,
+// and unpauses it once neither applies. It returns why the worker is paused, or
+// an empty string if it isn't.
+func (a *AgentWorker) updatePaused(ctx context.Context) string {
+	reason := ""
+	switch {
+	case a.jobLimiter.full():
+		reason = "the most jobs allowed by --max-concurrent-jobs are running"
+	case a.pausedByUser(ctx):
+		reason = "job acceptance was paused with `buildkite-agent pause`"
+	}
+
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	switch {
+	case reason != "" && a.state == agentWorkerStateIdle:
+		a.logger.Info("Pausing, as %s", reason)
+		a.state = agentWorkerStatePaused
+	case reason == "" && a.state == agentWorkerStatePaused:
+		a.logger.Info("Resuming")
+		a.state = agentWorkerStateIdle
+	}
+	if a.state != agentWorkerStatePaused {
+		return ""
+	}
+	return reason
+}
+
+// pausedByUser reports whether job acceptance was paused with
+// `buildkite-agent pause`. If that can't be determined, the worker carries on.
+func (a *AgentWorker) pausedByUser(ctx context.Context) bool {
+	if a.pauser == nil {
+		return false
+	}
+	paused, err := a.pauser.Paused(ctx)
+	if err != nil {
+		a.logger.Debug("Couldn't check whether job acceptance is paused: %v", err)
+		return false
+	}
+	return paused
+}
+
+// tagsChanged reports whether the worker should re-register, as the tags have
+// changed since it registered.
+func (a *AgentWorker) tagsChanged() bool {
+	if a.tags == nil || a.register == nil || time.Now().Before(a.reregisterWait) {
+		return false
+	}
+	_, version := a.tags.Get()
+	return version != a.tagsVersion
+}
+
+// reregister registers a new agent with Buildkite using the current tags,
+// connects it, and disconnects the old agent. The worker then carries on as
+// the new agent. If that fails, it carries on as the old agent and tries again
+// later.
+func (a *AgentWorker) reregister(ctx context.Context, idleMonitor *IdleMonitor) {
+	tags, version := a.tags.Get()
+	a.logger.Info("Tags have changed, re-registering with Buildkite...")
+
+	reg, err := a.register(ctx, tags)
+	if err != nil {
+		a.logger.Error("Couldn't re-register with the new tags, will try again in %v: %v", reregisterRetryInterval, err)
+		a.reregisterWait = time.Now().Add(reregisterRetryInterval)
+		return
+	}
+
+	apiClient := a.apiClient.FromAgentRegisterResponse(reg)
+	client := &core.Client{APIClient: apiClient, Logger: a.logger}
+	if err := client.Connect(ctx); err != nil {
+		a.logger.Error("Couldn't connect with the new tags, will try again in %v: %v", reregisterRetryInterval, err)
+		a.reregisterWait = time.Now().Add(reregisterRetryInterval)
+		return
+	}
+
+	oldUUID, oldClient := a.agent.UUID, a.client
+	a.stateMtx.Lock()
+	a.agent, a.apiClient, a.client = reg, apiClient, client
+	a.stateMtx.Unlock()
+	a.tagsVersion = version
+
+	// The old agent no longer counts towards the idle monitor
+	idleMonitor.MarkBusy(oldUUID)
+
+	if err := oldClient.Disconnect(ctx); err != nil {
+		a.logger.Warn("Couldn't disconnect agent %s after re-registering: %v", oldUUID, err)
+	}
+	a.logger.Info("Re-registered with tags %q", tags)
+}
+
+// reregisterRetryInterval is how long to wait before trying to re-register
+// again, after it fails.
+const reregisterRetryInterval = time.Minute
+
+func (a *AgentWorker) getAgentUUID() string {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	return a.agent.UUID
 }
 
 func (a *AgentWorker) getState() agentWorkerState {
@@ -139,6 +314,26 @@ func (a *AgentWorker) getCurrentJobID() string {
 	return a.currentJobID
 }
 
+// getCurrentJob returns the ID of the job the worker is running and when it
+// started running it, or "" and the zero time if it isn't running one.
+func (a *AgentWorker) getCurrentJob() (string, time.Time) {
+	a.stateMtx.Lock()
+	defer a.stateMtx.Unlock()
+	return a.currentJobID, a.currentJobStartedAt
+}
+
+// getLastHeartbeat returns when the last successful heartbeat was sent, and
+// the error from the most recent heartbeat, if it failed.
+func (a *AgentWorker) getLastHeartbeat() (time.Time, error) {
+	a.stats.Lock()
+	defer a.stats.Unlock()
+	return a.stats.lastHeartbeat, a.stats.lastHeartbeatError
+}
+
+// errTagsChanged is returned by runPingLoop when the worker should re-register
+// with new tags.
+var errTagsChanged = errors.New("tags changed")
+
 type errUnrecoverable struct {
 	action   string
 	response *api.Response
@@ -166,7 +361,7 @@ func (e *errUnrecoverable) Unwrap() error {
 // Creates the agent worker and initializes its API Client
 func NewAgentWorker(l logger.Logger, a *api.AgentRegisterResponse, m *metrics.Collector, apiClient APIClient, c AgentWorkerConfig) *AgentWorker {
 	apiClient = apiClient.FromAgentRegisterResponse(a)
-	return &AgentWorker{
+	w := &AgentWorker{
 		logger:           l,
 		agent:            a,
 		metricsCollector: m,
@@ -182,15 +377,60 @@ func NewAgentWorker(l logger.Logger, a *api.AgentRegisterResponse, m *metrics.Co
 		cancelSig:          c.CancelSignal,
 		spawnIndex:         c.SpawnIndex,
 		agentStdout:        c.AgentStdout,
+		housekeeper:        c.Housekeeper,
+		jobLimiter:         c.JobLimiter,
+		gpuAllocator:       c.GPUAllocator,
+		pauser:             c.Pauser,
+		tags:               c.Tags,
+		register:           c.Register,
 		state:              agentWorkerStateIdle,
 	}
+	if w.tags != nil {
+		_, w.tagsVersion = w.tags.Get()
+	}
+	if w.housekeeper != nil {
+		w.housekeeper.AddAgent(a.Name)
+	}
+	return w
 }
 
 const workerStatusPart = `{{if le .LastPing.Seconds 2.0}}✅{{else}}❌{{end}} Last ping: {{.LastPing}} ago <br/>
 {{if le .LastHeartbeat.Seconds 60.0}}✅{{else}}❌{{end}} Last heartbeat: {{.LastHeartbeat}} ago<br/>
-{{if .LastHeartbeatError}}❌{{else}}✅{{end}} Last heartbeat error: {{printf "%v" .LastHeartbeatError}}`
+{{if .LastHeartbeatError}}❌{{else}}✅{{end}} Last heartbeat error: {{printf "%v" .LastHeartbeatError}}
+{{- define "job"}}{{if .URL}}<a href="{{.URL}}">{{.ID}}</a>{{else}}{{.ID}}{{end}}{{with .Label}} {{.}}{{end}}{{end}}
+{{- define "sections"}}{{if .}}<details><summary>{{len .}} sections</summary><table>
+{{range .}}<tr><td><code>{{.Header}}</code></td><td>{{.Duration}}</td></tr>
+{{end}}</table></details>{{end}}{{end}}
+{{- with .CurrentJob}}<br/>
+🏃 Running job {{template "job" .}} for {{.Duration}}{{with .CurrentSection}}, in <code>{{.Header}}</code> for {{.Duration}}{{end}}
+{{template "sections" .Sections}}
+{{- end}}
+{{- if .Jobs}}
+<table>
+<tr><th>Job</th><th>Started</th><th>Duration</th><th>Exit status</th><th>Sections</th></tr>
+{{range .Jobs}}<tr>
+<td>{{template "job" .}}</td>
+<td>{{.StartedAt.Format "2006-01-02 15:04:05 MST"}}</td>
+<td>{{.Duration}}</td>
+<td>{{if .Exited}}{{if eq .Exit.Status 0}}✅{{else}}❌{{end}} {{.Exit.Status}}{{with .Exit.Signal}} ({{.}}){{end}}{{with .Exit.SignalReason}} {{.}}{{end}}{{else}}❌ not run{{end}}{{with .Err}}: {{.}}{{end}}</td>
+<td>{{template "sections" .Sections}}</td>
+</tr>
+{{end}}</table>
+{{- end}}`
 
 func (a *AgentWorker) statusCallback(context.Context) (any, error) {
+	now := time.Now()
+
+	a.stateMtx.Lock()
+	var current *jobRecord
+	if a.currentJob != nil && a.currentJobRunner != nil {
+		current = newJobRecord(a.currentJob)
+		current.StartedAt = a.currentJobStartedAt
+		current.FinishedAt = now
+		current.Sections = a.currentJobRunner.Sections(now)
+	}
+	a.stateMtx.Unlock()
+
 	a.stats.Lock()
 	defer a.stats.Unlock()
 
@@ -199,11 +439,15 @@ func (a *AgentWorker) statusCallback(context.Context) (any, error) {
 		LastHeartbeat      time.Duration
 		LastHeartbeatError error
 		LastPing           time.Duration
+		CurrentJob         *jobRecord
+		Jobs               []jobRecord
 	}{
 		SpawnIndex:         a.spawnIndex,
 		LastHeartbeat:      time.Since(a.stats.lastHeartbeat),
 		LastHeartbeatError: a.stats.lastHeartbeatError,
 		LastPing:           time.Since(a.stats.lastPing),
+		CurrentJob:         current,
+		Jobs:               a.history.list(),
 	}, nil
 }
 
@@ -222,25 +466,51 @@ func (a *AgentWorker) Start(ctx context.Context, idleMonitor *IdleMonitor) error
 	}
 	defer a.metricsCollector.Stop()
 
-	// Use a context to run heartbeats for as long as the ping loop or job runs
-	heartbeatCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	go a.runHeartbeatLoop(heartbeatCtx)
-
 	// If the agent is booted in acquisition mode, then we don't need to
 	// bother about starting the ping loop.
-	if a.agentConfiguration.AcquireJob != "" {
+	if a.agentConfiguration.AcquireJob != "" || len(a.agentConfiguration.AcquireJobs) > 0 {
+		stopHeartbeats := a.startHeartbeats(ctx)
+		defer stopHeartbeats()
+
 		// When in acquisition mode, there can't be any agents, so
 		// there's really no point in letting the idle monitor know
 		// we're busy, but it's probably a good thing to do for good
 		// measure.
 		idleMonitor.MarkBusy(a.agent.UUID)
 
+		if len(a.agentConfiguration.AcquireJobs) > 0 {
+			return a.AcquireAndRunJobs(ctx, a.agentConfiguration.AcquireJobs)
+		}
 		return a.AcquireAndRunJob(ctx, a.agentConfiguration.AcquireJob)
 	}
 
-	return a.runPingLoop(ctx, idleMonitor)
+	for {
+		// Run heartbeats for as long as the ping loop runs
+		stopHeartbeats := a.startHeartbeats(ctx)
+		err := a.runPingLoop(ctx, idleMonitor)
+		stopHeartbeats()
+
+		if !errors.Is(err, errTagsChanged) {
+			return err
+		}
+		a.reregister(ctx, idleMonitor)
+	}
+}
+
+// startHeartbeats sends heartbeats in the background until the returned func
+// is called, which waits for them to stop.
+func (a *AgentWorker) startHeartbeats(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.runHeartbeatLoop(ctx)
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
 }
 
 func (a *AgentWorker) runHeartbeatLoop(ctx context.Context) {
@@ -299,9 +569,24 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 		a.stopMutex.Lock()
 		stopping := a.stopping
 		a.stopMutex.Unlock()
-		if !stopping {
+		if !stopping && a.tagsChanged() {
+			// Start re-registering, if this worker is idle
+			return errTagsChanged
+		}
+		if reason := a.updatePaused(ctx); reason != "" {
+			setStat("⏸️ Paused, as " + reason)
+		} else if stopping {
+			// Don't ask for more work
+		} else if reservation, ok := a.jobLimiter.tryReserve(); !ok {
+			// Another worker took the last place since updatePaused looked
+			setStat("⏸️ Paused, as the most jobs allowed by --max-concurrent-jobs are running")
+		} else {
+			// The reservation is carried into the job, if there is one
 			setStat("📡 Pinging Buildkite for work")
 			job, err := a.Ping(ctx)
+			if job == nil {
+				reservation.release()
+			}
 			if err != nil {
 				if errors.Is(err, &errUnrecoverable{}) {
 					a.logger.Error("%v", err)
@@ -316,8 +601,9 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 				setStat("💼 Accepting job")
 
 				// Runs the job, only errors if something goes wrong
-				if runErr := a.AcceptAndRunJob(ctx, job); runErr != nil {
+				if runErr := a.acceptAndRunJob(ctx, job, reservation); runErr != nil {
 					a.logger.Error("%v", runErr)
+					setStat("❌ Failed to accept or run job")
 				} else {
 					if a.agentConfiguration.DisconnectAfterJob {
 						a.logger.Info("Job finished. Disconnecting...")
@@ -337,7 +623,6 @@ func (a *AgentWorker) runPingLoop(ctx context.Context, idleMonitor *IdleMonitor)
 
 					continue
 				}
-				setStat("✅ Finished job")
 			}
 
 			// Handle disconnect after idle timeout (and deprecated disconnect-after-job-timeout)
@@ -442,6 +727,7 @@ func (a *AgentWorker) Heartbeat(ctx context.Context) error {
 
 	beat, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) (*api.Heartbeat, error) {
 		b, resp, err := a.apiClient.Heartbeat(ctx)
+		recordAPICall(a.metrics, "heartbeat", err)
 		if err != nil {
 			if resp != nil && !api.IsRetryableStatus(resp) {
 				a.Stop(false)
@@ -475,6 +761,7 @@ func (a *AgentWorker) Heartbeat(ctx context.Context) error {
 // Returns a job, or nil if none is found
 func (a *AgentWorker) Ping(ctx context.Context) (*api.Job, error) {
 	ping, resp, pingErr := a.apiClient.Ping(ctx)
+	recordAPICall(a.metrics, "ping", pingErr)
 	// wait a minute, where's my if err != nil block? TL;DR look for pingErr ~20 lines down
 	// the api client returns an error if the response code isn't a 2xx, but there's still information in resp and ping
 	// that we need to check out to do special handling for specific error codes or messages in the response body
@@ -555,12 +842,103 @@ func (a *AgentWorker) AcquireAndRunJob(ctx context.Context, jobId string) error
 		return fmt.Errorf("failed to acquire job: %w", err)
 	}
 
+	defer a.prepareCheckout(ctx, job)()
+
 	// Now that we've acquired the job, let's run it
 	return a.RunJob(ctx, job)
 }
 
+// AcquireAndRunJobs acquires and runs each of the jobs in turn, such as for an
+// external scheduler. Jobs that can't be acquired are skipped, and the errors
+// for them are returned once the rest have run. If the agent is stopped, the
+// remaining jobs are left for other agents.
+func (a *AgentWorker) AcquireAndRunJobs(ctx context.Context, jobIDs []string) error {
+	var errs []error
+	for i, jobID := range jobIDs {
+		select {
+		case <-a.stop:
+			a.logger.Info("Agent is stopping, so not acquiring the remaining %d job(s)", len(jobIDs)-i)
+			return errors.Join(errs...)
+		case <-ctx.Done():
+			return errors.Join(append(errs, ctx.Err())...)
+		default:
+		}
+
+		a.logger.Info("Acquiring job %s (%d of %d)", jobID, i+1, len(jobIDs))
+		if err := a.AcquireAndRunJob(ctx, jobID); err != nil {
+			a.logger.Error("Couldn't run job %s: %v", jobID, err)
+			errs = append(errs, fmt.Errorf("job %s: %w", jobID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Accepts a job and runs it, only returns an error if something goes wrong
 func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
+	reservation, err := a.jobLimiter.reserve(ctx)
+	if err != nil {
+		return fmt.Errorf("Waiting to run job %s: %w", job.ID, err)
+	}
+	return a.acceptAndRunJob(ctx, job, reservation)
+}
+
+// acceptAndRunJob is AcceptAndRunJob, for a job that has a place reserved
+// under the pool's job limits. The reservation is released once the job
+// finishes.
+func (a *AgentWorker) acceptAndRunJob(ctx context.Context, job *api.Job, reservation *jobReservation) error {
+	defer reservation.release()
+
+	// Before accepting the job, execute the pre-accept hook (if present) for it
+	// to tell us whether we should run it at all. The job has already been
+	// assigned to this agent, and can't be handed back, so a job the hook
+	// rejects is accepted and then refused, which fails it.
+	if hook, _ := hook.Find(a.agentConfiguration.HooksPath, "pre-accept"); hook != "" {
+		if err := a.executePreAcceptHook(ctx, hook, job); err != nil {
+			return a.acceptAndRefuseJob(ctx, job, fmt.Errorf("%w: %w", errPreAcceptHookRejected, err))
+		}
+	}
+
+	// Wait until running the job won't exceed the pool's concurrent job limits
+	// for the tags it targets.
+	if err := reservation.waitForTags(ctx, job); err != nil {
+		return fmt.Errorf("Waiting to run job %s: %w", job.ID, err)
+	}
+
+	// Likewise, wait for enough GPUs to be free for the job, and reserve them.
+	gpus, releaseGPUs, err := a.gpuAllocator.allocate(ctx, job)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("Waiting to run job %s: %w", job.ID, err)
+		}
+		// The job can never run on this host
+		return a.acceptAndRefuseJob(ctx, job, err)
+	}
+	defer releaseGPUs()
+
+	defer a.prepareCheckout(ctx, job)()
+
+	accepted, err := a.acceptJob(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	// Now that we've accepted the job, let's run it
+	return a.runJob(ctx, accepted, gpus, nil)
+}
+
+// acceptAndRefuseJob accepts a job that the agent won't run, and finishes it
+// with the agent_refused signal reason, with the reason in its log.
+func (a *AgentWorker) acceptAndRefuseJob(ctx context.Context, job *api.Job, reason error) error {
+	a.logger.Warn("Refusing job %s: %v", job.ID, reason)
+	accepted, err := a.acceptJob(ctx, job)
+	if err != nil {
+		return err
+	}
+	return a.runJob(ctx, accepted, nil, reason)
+}
+
+// acceptJob accepts a job that has been assigned to the agent.
+func (a *AgentWorker) acceptJob(ctx context.Context, job *api.Job) (*api.Job, error) {
 	a.logger.Info("Assigned job %s. Accepting...", job.ID)
 
 	// Accept the job. We'll retry on connection related issues, but if
@@ -573,6 +951,7 @@ func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
 
 	accepted, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) (*api.Job, error) {
 		accepted, _, err := a.apiClient.AcceptJob(ctx, job)
+		recordAPICall(a.metrics, "accept_job", err)
 		if err != nil {
 			if api.IsRetryableError(err) {
 				a.logger.Warn("%s (%s)", err, r)
@@ -586,14 +965,109 @@ func (a *AgentWorker) AcceptAndRunJob(ctx context.Context, job *api.Job) error {
 
 	// If `accepted` is nil, then the job was never accepted
 	if accepted == nil {
-		return fmt.Errorf("Failed to accept job: %w", err)
+		return nil, fmt.Errorf("Failed to accept job: %w", err)
 	}
+	return accepted, nil
+}
 
-	// Now that we've accepted the job, let's run it
-	return a.RunJob(ctx, accepted)
+// prepareCheckout makes room on disk for the job, if disk housekeeping is
+// enabled, before the job is accepted. The job's checkout is marked as in use
+// until the returned function is called.
+func (a *AgentWorker) prepareCheckout(ctx context.Context, job *api.Job) (release func()) {
+	if a.housekeeper == nil {
+		return func() {}
+	}
+
+	// Mark the job's checkout as in use first, so that it isn't removed to
+	// make room for itself.
+	release = a.housekeeper.Use(housekeeping.CheckoutDir(
+		a.agentConfiguration.BuildPath,
+		a.agent.Name,
+		job.Env["BUILDKITE_ORGANIZATION_SLUG"],
+		job.Env["BUILDKITE_PIPELINE_SLUG"],
+	))
+
+	if err := a.housekeeper.Clean(ctx); err != nil {
+		a.logger.Warn("Disk housekeeping failed: %v", err)
+	}
+	return release
+}
+
+// errPreAcceptHookRejected is why a job is refused when the pre-accept hook
+// fails.
+var errPreAcceptHookRejected = errors.New("pre-accept hook rejected the job")
+
+// executePreAcceptHook runs the pre-accept hook for a job that has been
+// assigned to the agent. The hook can't change the job: its env is provided
+// read-only, as a JSON file. A nil error means the job should be run.
+func (a *AgentWorker) executePreAcceptHook(ctx context.Context, hook string, job *api.Job) error {
+	a.logger.Info("Running pre-accept hook %q for job %s", hook, job.ID)
+
+	envJSON, err := json.Marshal(job.Env)
+	if err != nil {
+		return fmt.Errorf("marshalling job env: %w", err)
+	}
+	envFile, err := os.CreateTemp("", "job-env-json-")
+	if err != nil {
+		return fmt.Errorf("creating job env file: %w", err)
+	}
+	defer func() {
+		// Read-only files can't be removed on Windows
+		_ = os.Chmod(envFile.Name(), 0o600)
+		_ = os.Remove(envFile.Name())
+	}()
+	if _, err := envFile.Write(envJSON); err != nil {
+		envFile.Close()
+		return fmt.Errorf("writing job env file: %w", err)
+	}
+	if err := envFile.Close(); err != nil {
+		return fmt.Errorf("writing job env file: %w", err)
+	}
+	if err := os.Chmod(envFile.Name(), 0o400); err != nil {
+		return fmt.Errorf("making job env file read-only: %w", err)
+	}
+
+	sh, err := shell.New(
+		shell.WithStdout(LogWriter{l: a.logger}),
+	)
+	if err != nil {
+		return err
+	}
+
+	environ := env.New()
+	environ.Set("BUILDKITE_JOB_ID", job.ID)
+	environ.Set("BUILDKITE_ENV_JSON_FILE", envFile.Name())
+
+	script, err := sh.Script(hook)
+	if err != nil {
+		a.logger.Error("Finished pre-accept hook %q: script not runnable: %v", hook, err)
+		return err
+	}
+	if err := script.Run(ctx, shell.ShowPrompt(false), shell.WithExtraEnv(environ)); err != nil {
+		a.logger.Error("Finished pre-accept hook %q: job %s rejected: %v", hook, job.ID, err)
+		return err
+	}
+	a.logger.Info("Finished pre-accept hook %q: job %s accepted", hook, job.ID)
+	return nil
 }
 
 func (a *AgentWorker) RunJob(ctx context.Context, acceptResponse *api.Job) error {
+	gpus, release, err := a.gpuAllocator.allocate(ctx, acceptResponse)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("Failed to allocate GPUs for job %s: %w", acceptResponse.ID, err)
+		}
+		// The job can never run on this host
+		return a.runJob(ctx, acceptResponse, nil, err)
+	}
+	defer release()
+
+	return a.runJob(ctx, acceptResponse, gpus, nil)
+}
+
+// runJob runs a job that has been accepted, with the GPUs allocated to it (if
+// any). If refusal isn't nil, the job is refused rather than run.
+func (a *AgentWorker) runJob(ctx context.Context, acceptResponse *api.Job, gpus *GPUAllocation, refusal error) error {
 	a.setBusy(acceptResponse.ID)
 	defer a.setIdle()
 
@@ -617,18 +1091,33 @@ func (a *AgentWorker) RunJob(ctx context.Context, acceptResponse *api.Job) error
 		AgentConfiguration: a.agentConfiguration,
 		AgentStdout:        a.agentStdout,
 		KubernetesExec:     a.agentConfiguration.KubernetesExec,
+		GPUs:               gpus,
+		Refusal:            refusal,
 	})
 	if err != nil {
 		return fmt.Errorf("Failed to initialize job: %w", err)
 	}
 	a.jobRunner = jr
+	a.setJobRunner(acceptResponse, jr)
 	defer func() {
 		// No more job, no more runner.
 		a.jobRunner = nil
 	}()
 
 	// Start running the job
-	if err := jr.Run(ctx); err != nil {
+	startedAt := time.Now()
+	err = jr.Run(ctx)
+
+	// Remember how it went, for the status page
+	record := newJobRecord(acceptResponse)
+	record.StartedAt = startedAt
+	record.FinishedAt = time.Now()
+	record.Exit, record.Exited = jr.Exit()
+	record.Err = err
+	record.Sections = jr.Sections(record.FinishedAt)
+	a.history.add(*record)
+
+	if err != nil {
 		return fmt.Errorf("Failed to run job: %w", err)
 	}
 
@@ -2,19 +2,26 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/core"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -160,6 +167,58 @@ func TestAcquireJobReturnsWrappedError_WhenServerResponds422(t *testing.T) {
 	}
 }
 
+func TestAcquireAndRunJobs_SkipsJobsThatCantBeAcquired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var acquired []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		jobID, ok := strings.CutSuffix(strings.TrimPrefix(req.URL.Path, "/jobs/"), "/acquire")
+		if !ok {
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		acquired = append(acquired, jobID)
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	apiClient := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamas",
+	})
+
+	worker := &AgentWorker{
+		logger:    logger.Discard,
+		apiClient: apiClient,
+		client: &core.Client{
+			APIClient: apiClient,
+			Logger:    logger.Discard,
+		},
+		stop: make(chan struct{}),
+	}
+
+	jobIDs := []string{"job-1", "job-2"}
+	err := worker.AcquireAndRunJobs(ctx, jobIDs)
+	if !errors.Is(err, core.ErrJobAcquisitionRejected) {
+		t.Errorf("worker.AcquireAndRunJobs(ctx, %q) = %v, want core.ErrJobAcquisitionRejected", jobIDs, err)
+	}
+	for _, jobID := range jobIDs {
+		if err == nil || !strings.Contains(err.Error(), jobID) {
+			t.Errorf("worker.AcquireAndRunJobs(ctx, %q) = %v, want an error mentioning %s", jobIDs, err, jobID)
+		}
+	}
+	assert.Equal(t, jobIDs, acquired)
+
+	// Once the agent is stopping, no more jobs are acquired
+	acquired = nil
+	close(worker.stop)
+	if err := worker.AcquireAndRunJobs(ctx, jobIDs); err != nil {
+		t.Errorf("worker.AcquireAndRunJobs(ctx, %q) after stopping = %v, want nil", jobIDs, err)
+	}
+	assert.Empty(t, acquired)
+}
+
 func TestAcquireAndRunJobWaiting(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -224,3 +283,230 @@ func TestAcquireAndRunJobWaiting(t *testing.T) {
 	}
 	assert.Equal(t, expectedSleeps, retrySleeps)
 }
+
+func TestAcceptAndRunJob_PreAcceptHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pre-accept hook in this test is a shell script")
+	}
+
+	hooksDir := t.TempDir()
+	hookScript := `#!/bin/sh
+if grep -q '"BUILDKITE_BRANCH":"untrusted"' "$BUILDKITE_ENV_JSON_FILE"; then
+  echo "refusing job $BUILDKITE_JOB_ID from an untrusted branch"
+  exit 1
+fi
+`
+	if err := os.WriteFile(filepath.Join(hooksDir, "pre-accept"), []byte(hookScript), 0o700); err != nil {
+		t.Fatalf("os.WriteFile(pre-accept) error = %v", err)
+	}
+
+	tests := []struct {
+		branch           string
+		wantSignalReason string
+	}{
+		{branch: "main", wantSignalReason: ""},
+		{branch: "untrusted", wantSignalReason: SignalReasonAgentRefused},
+	}
+
+	for _, test := range tests {
+		t.Run(test.branch, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			job := &api.Job{
+				ID:                 "some-uuid",
+				Env:                map[string]string{"BUILDKITE_BRANCH": test.branch},
+				ChunksMaxSizeBytes: 1024,
+			}
+
+			var mu sync.Mutex
+			accepted, signalReason := false, ""
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch req.URL.Path {
+				case "/jobs/some-uuid/accept":
+					accepted = true
+					if test.wantSignalReason == "" {
+						// Stop here, rather than running the job
+						rw.WriteHeader(http.StatusUnprocessableEntity)
+						return
+					}
+					json.NewEncoder(rw).Encode(job)
+
+				case "/jobs/some-uuid/start", "/jobs/some-uuid/chunks":
+					rw.WriteHeader(http.StatusOK)
+
+				case "/jobs/some-uuid/finish":
+					var finish struct {
+						SignalReason string `json:"signal_reason"`
+					}
+					json.NewDecoder(req.Body).Decode(&finish)
+					signalReason = finish.SignalReason
+					rw.WriteHeader(http.StatusOK)
+
+				default:
+					http.Error(rw, "Not found", http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			apiClient := api.NewClient(logger.Discard, api.Config{
+				Endpoint: server.URL,
+				Token:    "llamas",
+			})
+
+			worker := &AgentWorker{
+				logger:    logger.Discard,
+				apiClient: apiClient,
+				agentConfiguration: AgentConfiguration{
+					HooksPath: hooksDir,
+					// A refused job never gets as far as the bootstrap
+					BootstrapScript: "false",
+					BuildPath:       t.TempDir(),
+				},
+				metrics: metrics.NewCollector(logger.Discard, metrics.CollectorConfig{}).Scope(metrics.Tags{}),
+				agent:   &api.AgentRegisterResponse{},
+			}
+
+			err := worker.AcceptAndRunJob(ctx, job)
+			if test.wantSignalReason == "" {
+				// The job is accepted, and then fails to run
+				if err == nil {
+					t.Fatalf("worker.AcceptAndRunJob(ctx, job) error = nil, want an error")
+				}
+			} else if err != nil {
+				t.Fatalf("worker.AcceptAndRunJob(ctx, job) error = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !accepted {
+				t.Errorf("job accepted = false, want true")
+			}
+			if signalReason != test.wantSignalReason {
+				t.Errorf("job finished with signal reason %q, want %q", signalReason, test.wantSignalReason)
+			}
+		})
+	}
+}
+
+type fakePauser struct {
+	paused bool
+	err    error
+}
+
+func (p *fakePauser) Paused(context.Context) (bool, error) {
+	return p.paused, p.err
+}
+
+func TestWorkerPausesWhenPausedByUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	pauser := &fakePauser{paused: true}
+	worker := &AgentWorker{
+		logger: logger.Discard,
+		pauser: pauser,
+		state:  agentWorkerStateIdle,
+	}
+
+	if reason := worker.updatePaused(ctx); reason == "" {
+		t.Errorf("worker.updatePaused(ctx) = \"\" while paused by the user, want a reason")
+	}
+	assert.Equal(t, agentWorkerStatePaused, worker.getState())
+
+	// If the pause state can't be found out, the worker should carry on
+	pauser.err = errors.New("leader went for a walk")
+	if reason := worker.updatePaused(ctx); reason != "" {
+		t.Errorf("worker.updatePaused(ctx) = %q when the pause state is unknown, want \"\"", reason)
+	}
+	assert.Equal(t, agentWorkerStateIdle, worker.getState())
+
+	pauser.paused, pauser.err = false, nil
+	if reason := worker.updatePaused(ctx); reason != "" {
+		t.Errorf("worker.updatePaused(ctx) = %q after resuming, want \"\"", reason)
+	}
+	assert.Equal(t, agentWorkerStateIdle, worker.getState())
+
+	// Busy workers aren't paused, even if job acceptance is
+	pauser.paused = true
+	worker.setBusy("some-uuid")
+	if reason := worker.updatePaused(ctx); reason != "" {
+		t.Errorf("worker.updatePaused(ctx) = %q while busy, want \"\"", reason)
+	}
+	assert.Equal(t, agentWorkerStateBusy, worker.getState())
+}
+
+func TestWorkerReregistersWhenTagsChange(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls = append(calls, req.URL.Path+" "+req.Header.Get("Authorization"))
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, `{}`)
+	}))
+	defer server.Close()
+
+	apiClient := api.NewClient(logger.Discard, api.Config{Endpoint: server.URL, Token: "registration-token"})
+	tags := NewRegistrationTags([]string{"queue=default"}, nil)
+
+	var registeredTags []string
+	worker := NewAgentWorker(
+		logger.Discard,
+		&api.AgentRegisterResponse{UUID: "old-uuid", AccessToken: "old-token"},
+		nil,
+		apiClient,
+		AgentWorkerConfig{
+			Tags: tags,
+			Register: func(ctx context.Context, tags []string) (*api.AgentRegisterResponse, error) {
+				registeredTags = tags
+				return &api.AgentRegisterResponse{UUID: "new-uuid", AccessToken: "new-token"}, nil
+			},
+		},
+	)
+
+	if worker.tagsChanged() {
+		t.Errorf("worker.tagsChanged() = true before the tags changed, want false")
+	}
+
+	tags.SetStatic([]string{"queue=default", "spot-termination=soon"})
+	if !worker.tagsChanged() {
+		t.Fatalf("worker.tagsChanged() = false after the tags changed, want true")
+	}
+
+	worker.reregister(ctx, NewIdleMonitor(1))
+
+	assert.Equal(t, []string{"queue=default", "spot-termination=soon"}, registeredTags)
+	assert.Equal(t, "new-uuid", worker.getAgentUUID())
+	assert.Equal(t, []string{"/connect Token new-token", "/disconnect Token old-token"}, calls)
+	if worker.tagsChanged() {
+		t.Errorf("worker.tagsChanged() = true after re-registering, want false")
+	}
+}
+
+func TestWorkerRetriesReregisteringLater(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	tags := NewRegistrationTags([]string{"queue=default"}, nil)
+	worker := &AgentWorker{
+		logger: logger.Discard,
+		agent:  &api.AgentRegisterResponse{UUID: "old-uuid"},
+		tags:   tags,
+		register: func(ctx context.Context, tags []string) (*api.AgentRegisterResponse, error) {
+			return nil, errors.New("registration token revoked")
+		},
+	}
+
+	tags.SetStatic([]string{"queue=other"})
+	worker.reregister(ctx, NewIdleMonitor(1))
+
+	assert.Equal(t, "old-uuid", worker.getAgentUUID())
+	if worker.tagsChanged() {
+		t.Errorf("worker.tagsChanged() = true straight after failing to re-register, want false until the retry interval passes")
+	}
+}
@@ -0,0 +1,14 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// AnnotationStateDir is where annotate --append-with-budget keeps track of
+// the annotations of a job. The job runner removes it when the job finishes.
+func AnnotationStateDir(jobID string) string {
+	return filepath.Join(os.TempDir(), "buildkite-annotations", fmt.Sprintf("%x", sha256.Sum256([]byte(jobID))))
+}
@@ -22,7 +22,7 @@ type APIClient interface {
 	FinishJob(context.Context, *api.Job) (*api.Response, error)
 	FromAgentRegisterResponse(*api.AgentRegisterResponse) *api.Client
 	FromPing(*api.Ping) *api.Client
-	GenerateGithubCodeAccessToken(context.Context, string, string) (string, *api.Response, error)
+	GenerateGithubCodeAccessToken(context.Context, string, *api.GithubCodeAccessTokenRequest) (*api.GithubCodeAccessTokenResponse, *api.Response, error)
 	GetJobState(context.Context, string) (*api.JobState, *api.Response, error)
 	GetMetaData(context.Context, string, string, string) (*api.MetaData, *api.Response, error)
 	GetSecret(context.Context, *api.GetSecretRequest) (*api.Secret, *api.Response, error)
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The Azure Instance Metadata Service, which is only reachable from Azure VMs.
+const azureIMDSEndpoint = "http://169.254.169.254"
+
+type AzureMetaData struct {
+	// Overrides the address of the Instance Metadata Service, for testing
+	Endpoint string
+}
+
+// azureCompute is the part of the Instance Metadata Service's response that
+// describes the VM.
+type azureCompute struct {
+	Name           string `json:"name"`
+	VMID           string `json:"vmId"`
+	VMSize         string `json:"vmSize"`
+	Location       string `json:"location"`
+	Zone           string `json:"zone"`
+	VMScaleSetName string `json:"vmScaleSetName"`
+	TagsList       []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"tagsList"`
+}
+
+// Get returns tags describing the VM. The zone and scale set name are only
+// included if the VM has them.
+func (e AzureMetaData) Get(ctx context.Context) (map[string]string, error) {
+	compute, err := e.compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{
+		"azure:vm-id":    compute.VMID,
+		"azure:vm-size":  compute.VMSize,
+		"azure:location": compute.Location,
+	}
+	if compute.Zone != "" {
+		result["azure:zone"] = compute.Zone
+	}
+	if compute.VMScaleSetName != "" {
+		result["azure:scale-set-name"] = compute.VMScaleSetName
+	}
+	return result, nil
+}
+
+// GetTags returns the VM's Azure tags.
+func (e AzureMetaData) GetTags(ctx context.Context) (map[string]string, error) {
+	compute, err := e.compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(compute.TagsList))
+	for _, tag := range compute.TagsList {
+		tags[tag.Name] = tag.Value
+	}
+	return tags, nil
+}
+
+func (e AzureMetaData) compute(ctx context.Context) (*azureCompute, error) {
+	var compute azureCompute
+	if err := e.get(ctx, "/metadata/instance/compute?api-version=2021-02-01", &compute); err != nil {
+		return nil, err
+	}
+	return &compute, nil
+}
+
+// azureScheduledEvents is the Instance Metadata Service's list of upcoming
+// maintenance events.
+type azureScheduledEvents struct {
+	Events []struct {
+		EventType string   `json:"EventType"`
+		Resources []string `json:"Resources"`
+		NotBefore string   `json:"NotBefore"`
+	} `json:"Events"`
+}
+
+// PreemptionNotice returns notice of preemption if a Preempt or Terminate event
+// has been scheduled for the VM.
+func (e AzureMetaData) PreemptionNotice(ctx context.Context) (*PreemptionNotice, error) {
+	var scheduled azureScheduledEvents
+	if err := e.get(ctx, "/metadata/scheduledevents?api-version=2020-07-01", &scheduled); err != nil {
+		return nil, err
+	}
+
+	var compute *azureCompute
+	for _, event := range scheduled.Events {
+		if event.EventType != "Preempt" && event.EventType != "Terminate" {
+			continue
+		}
+
+		// Events can be for other VMs in the same availability set or scale
+		// set, so check this VM is one of the ones affected
+		if len(event.Resources) > 0 {
+			if compute == nil {
+				c, err := e.compute(ctx)
+				if err != nil {
+					return nil, err
+				}
+				compute = c
+			}
+			if !slices.Contains(event.Resources, compute.Name) {
+				continue
+			}
+		}
+
+		notice := &PreemptionNotice{Provider: "azure", Action: strings.ToLower(event.EventType)}
+		if t, err := time.Parse(time.RFC1123, event.NotBefore); err == nil {
+			notice.Time = t
+		}
+		return notice, nil
+	}
+	return nil, nil
+}
+
+// get gets a path from the Instance Metadata Service, and decodes the JSON
+// response into v.
+func (e AzureMetaData) get(ctx context.Context, path string, v any) error {
+	endpoint := e.Endpoint
+	if endpoint == "" {
+		endpoint = azureIMDSEndpoint
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(endpoint, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+
+	// The Instance Metadata Service refuses requests made through a proxy
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{Proxy: nil},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Azure Instance Metadata Service returned %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding Azure Instance Metadata Service response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureMetaData(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/instance/compute" || r.Header.Get("Metadata") != "true" {
+			http.Error(w, "bad request: "+r.URL.Path, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{
+			"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+			"vmSize": "Standard_D2s_v3",
+			"location": "westus2",
+			"zone": "1",
+			"vmScaleSetName": "buildkite-agents",
+			"tagsList": [
+				{"name": "team", "value": "llamas"},
+				{"name": "spot", "value": "true"}
+			]
+		}`)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	azure := AzureMetaData{Endpoint: ts.URL}
+
+	metaData, err := azure.Get(ctx)
+	if err != nil {
+		t.Fatalf("AzureMetaData{}.Get(ctx) error = %v", err)
+	}
+	assert.Equal(t, map[string]string{
+		"azure:vm-id":          "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+		"azure:vm-size":        "Standard_D2s_v3",
+		"azure:location":       "westus2",
+		"azure:zone":           "1",
+		"azure:scale-set-name": "buildkite-agents",
+	}, metaData)
+
+	tags, err := azure.GetTags(ctx)
+	if err != nil {
+		t.Fatalf("AzureMetaData{}.GetTags(ctx) error = %v", err)
+	}
+	assert.Equal(t, map[string]string{"team": "llamas", "spot": "true"}, tags)
+}
+
+func TestAzureMetaDataOmitsMissingZoneAndScaleSet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"vmId": "vm", "vmSize": "Standard_B1s", "location": "eastus", "zone": "", "vmScaleSetName": ""}`)
+	}))
+	defer ts.Close()
+
+	metaData, err := AzureMetaData{Endpoint: ts.URL}.Get(context.Background())
+	if err != nil {
+		t.Fatalf("AzureMetaData{}.Get(ctx) error = %v", err)
+	}
+	assert.Equal(t, map[string]string{
+		"azure:vm-id":    "vm",
+		"azure:vm-size":  "Standard_B1s",
+		"azure:location": "eastus",
+	}, metaData)
+}
+
+func TestAzureMetaDataError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	if _, err := (AzureMetaData{Endpoint: ts.URL}).Get(context.Background()); err == nil {
+		t.Errorf("AzureMetaData{}.Get(ctx) error = nil, want an error for a 403 response")
+	}
+}
+
+func TestAzureMetaDataPreemptionNotice(t *testing.T) {
+	events := `{"Events": []}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/scheduledevents":
+			fmt.Fprint(w, events)
+		case "/metadata/instance/compute":
+			fmt.Fprint(w, `{"name": "agent-vm-1", "vmId": "vm"}`)
+		default:
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	azure := AzureMetaData{Endpoint: ts.URL}
+
+	tests := []struct {
+		name   string
+		events string
+		want   *PreemptionNotice
+	}{
+		{
+			name:   "no events",
+			events: `{"Events": []}`,
+		},
+		{
+			name:   "reboot",
+			events: `{"Events": [{"EventType": "Reboot", "Resources": ["agent-vm-1"]}]}`,
+		},
+		{
+			name:   "another VM preempted",
+			events: `{"Events": [{"EventType": "Preempt", "Resources": ["agent-vm-2"]}]}`,
+		},
+		{
+			name:   "this VM preempted",
+			events: `{"Events": [{"EventType": "Preempt", "Resources": ["agent-vm-2", "agent-vm-1"], "NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT"}]}`,
+			want: &PreemptionNotice{
+				Provider: "azure",
+				Action:   "preempt",
+				Time:     time.Date(2016, 9, 19, 18, 29, 47, 0, time.FixedZone("GMT", 0)),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		events = test.events
+		got, err := azure.PreemptionNotice(ctx)
+		if err != nil {
+			t.Fatalf("%s: AzureMetaData{}.PreemptionNotice(ctx) error = %v", test.name, err)
+		}
+		if test.want == nil {
+			assert.Nil(t, got, test.name)
+			continue
+		}
+		if assert.NotNil(t, got, test.name) {
+			assert.Equal(t, test.want.Action, got.Action, test.name)
+			assert.True(t, test.want.Time.Equal(got.Time), "%s: got time %v, want %v", test.name, got.Time, test.want.Time)
+		}
+	}
+}
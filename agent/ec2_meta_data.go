@@ -1,6 +1,14 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/buildkite/agent/v3/internal/awslib"
 )
@@ -58,6 +66,34 @@ func (e EC2MetaData) Get() (map[string]string, error) {
 	return metaData, nil
 }
 
+// PreemptionNotice returns the spot instance interruption notice, if the
+// instance has been given one.
+func (e EC2MetaData) PreemptionNotice(ctx context.Context) (*PreemptionNotice, error) {
+	c, err := newAWSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	action, err := c.GetMetadataWithContext(ctx, "spot/instance-action")
+	if err != nil {
+		// Until there's a notice, instance-action isn't found
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var notice struct {
+		Action string    `json:"action"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(action), &notice); err != nil {
+		return nil, fmt.Errorf("decoding spot instance action: %w", err)
+	}
+	return &PreemptionNotice{Provider: "ec2", Action: notice.Action, Time: notice.Time}, nil
+}
+
 func newAWSClient() (*ec2metadata.EC2Metadata, error) {
 	sess, err := awslib.Session()
 	if err != nil {
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"strings"
 
@@ -95,3 +96,16 @@ func parseRegionFromZone(zone string) (string, error) {
 	}
 	return zone[:index], nil
 }
+
+// PreemptionNotice returns notice of preemption if the instance is being
+// preempted.
+func (e GCPMetaData) PreemptionNotice(ctx context.Context) (*PreemptionNotice, error) {
+	preempted, err := metadata.GetWithContext(ctx, "instance/preempted")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(preempted), "true") {
+		return nil, nil
+	}
+	return &PreemptionNotice{Provider: "gcp", Action: "preempt"}, nil
+}
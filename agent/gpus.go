@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// GPU vendors, as detected by DetectGPUs
+const (
+	GPUVendorNVIDIA = "nvidia"
+	GPUVendorAMD    = "amd"
+)
+
+// errGPUsUnavailable is returned by GPUAllocator.tryAllocate when too few GPUs
+// are free to run the job right now.
+var errGPUsUnavailable = errors.New("not enough free GPUs")
+
+// GPU is a GPU on the host.
+type GPU struct {
+	Index int
+	Model string
+}
+
+// DetectGPUs lists the GPUs on the host with nvidia-smi or, failing that,
+// rocm-smi, and returns which vendor's they are.
+func DetectGPUs(ctx context.Context) (vendor string, gpus []GPU, err error) {
+	if path, err := exec.LookPath("nvidia-smi"); err == nil {
+		out, err := exec.CommandContext(ctx, path, "--query-gpu=index,name", "--format=csv,noheader").Output()
+		if err != nil {
+			return "", nil, fmt.Errorf("running nvidia-smi: %w", err)
+		}
+		gpus, err := parseNvidiaSMI(string(out))
+		return GPUVendorNVIDIA, gpus, err
+	}
+
+	if path, err := exec.LookPath("rocm-smi"); err == nil {
+		out, err := exec.CommandContext(ctx, path, "--showproductname", "--csv").Output()
+		if err != nil {
+			return "", nil, fmt.Errorf("running rocm-smi: %w", err)
+		}
+		gpus, err := parseROCmSMI(string(out))
+		return GPUVendorAMD, gpus, err
+	}
+
+	return "", nil, errors.New("neither nvidia-smi nor rocm-smi were found")
+}
+
+// parseNvidiaSMI parses the output of
+// nvidia-smi --query-gpu=index,name --format=csv,noheader.
+func parseNvidiaSMI(out string) ([]GPU, error) {
+	var gpus []GPU
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		index, model, ok := strings.Cut(line, ",")
+		if !ok {
+			return nil, fmt.Errorf("unexpected nvidia-smi output %q", line)
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(index))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected nvidia-smi GPU index %q", index)
+		}
+		gpus = append(gpus, GPU{Index: i, Model: strings.TrimSpace(model)})
+	}
+	return gpus, nil
+}
+
+// parseROCmSMI parses the output of rocm-smi --showproductname --csv, which
+// has a header row, then a row for each card named "card<index>".
+func parseROCmSMI(out string) ([]GPU, error) {
+	r := csv.NewReader(strings.NewReader(strings.TrimSpace(out)))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing rocm-smi output: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	modelCol := slices.Index(records[0], "Card series")
+	if modelCol < 0 {
+		modelCol = slices.Index(records[0], "Card model")
+	}
+
+	var gpus []GPU
+	for _, record := range records[1:] {
+		index, ok := strings.CutPrefix(record[0], "card")
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected rocm-smi device %q", record[0])
+		}
+		gpu := GPU{Index: i}
+		if modelCol >= 0 && modelCol < len(record) {
+			gpu.Model = strings.TrimSpace(record[modelCol])
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// GPUTags returns the agent tags describing the GPUs: the vendor, how many
+// there are, and their model (or models, if they differ).
+func GPUTags(vendor string, gpus []GPU) map[string]string {
+	var models []string
+	for _, gpu := range gpus {
+		if gpu.Model != "" && !slices.Contains(models, gpu.Model) {
+			models = append(models, gpu.Model)
+		}
+	}
+	tags := map[string]string{
+		"gpu_vendor": vendor,
+		"gpu_count":  strconv.Itoa(len(gpus)),
+	}
+	if len(models) > 0 {
+		slices.Sort(models)
+		tags["gpu_model"] = strings.Join(models, ",")
+	}
+	return tags
+}
+
+// GPUAllocation is the GPUs a job has been given.
+type GPUAllocation struct {
+	Vendor  string
+	Indices []int
+}
+
+// env returns the env vars that restrict the job to the allocated GPUs. The
+// indices are nvidia-smi's, which are in PCI bus order, and so CUDA is told to
+// number the GPUs the same way, rather than fastest first.
+func (g *GPUAllocation) env() map[string]string {
+	indices := make([]string, 0, len(g.Indices))
+	for _, i := range g.Indices {
+		indices = append(indices, strconv.Itoa(i))
+	}
+	list := strings.Join(indices, ",")
+
+	env := map[string]string{
+		"BUILDKITE_AGENT_GPUS": list,
+		"CUDA_VISIBLE_DEVICES": list,
+		"CUDA_DEVICE_ORDER":    "PCI_BUS_ID",
+	}
+	if g.Vendor == GPUVendorAMD {
+		env["ROCR_VISIBLE_DEVICES"] = list
+	}
+	return env
+}
+
+// GPUAllocator assigns the GPUs on the host to jobs, so that jobs run by
+// different workers don't use the same GPUs. It is shared between all of the
+// pool's workers. A nil GPUAllocator doesn't allocate anything.
+type GPUAllocator struct {
+	vendor string
+	gpus   []int
+
+	mu    sync.Mutex
+	inUse map[int]bool
+
+	// released is closed, and replaced, whenever GPUs are released.
+	released chan struct{}
+}
+
+// NewGPUAllocator returns a GPUAllocator for the GPUs, or nil if there are
+// none.
+func NewGPUAllocator(vendor string, gpus []GPU) *GPUAllocator {
+	if len(gpus) == 0 {
+		return nil
+	}
+	indices := make([]int, 0, len(gpus))
+	for _, gpu := range gpus {
+		indices = append(indices, gpu.Index)
+	}
+	slices.Sort(indices)
+	return &GPUAllocator{
+		vendor:   vendor,
+		gpus:     indices,
+		inUse:    make(map[int]bool),
+		released: make(chan struct{}),
+	}
+}
+
+// allocate is like tryAllocate, but waits for enough GPUs to be free. It still
+// returns an error straight away if the job can never run on this host.
+func (a *GPUAllocator) allocate(ctx context.Context, job *api.Job) (*GPUAllocation, func(), error) {
+	if a == nil {
+		return nil, func() {}, nil
+	}
+	for {
+		a.mu.Lock()
+		released := a.released
+		a.mu.Unlock()
+
+		gpus, release, err := a.tryAllocate(job)
+		if !errors.Is(err, errGPUsUnavailable) {
+			return gpus, release, err
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("%w: %w", err, context.Cause(ctx))
+		}
+	}
+}
+
+// tryAllocate reserves GPUs for the job: as many as its BUILDKITE_GPU_COUNT,
+// or one if it doesn't set it. The returned release func must be called once
+// the job finishes.
+func (a *GPUAllocator) tryAllocate(job *api.Job) (*GPUAllocation, func(), error) {
+	if a == nil {
+		return nil, func() {}, nil
+	}
+
+	count := 1
+	if v, ok := job.Env["BUILDKITE_GPU_COUNT"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid BUILDKITE_GPU_COUNT %q, must be a whole number", v)
+		}
+		count = n
+	}
+	if count > len(a.gpus) {
+		return nil, nil, fmt.Errorf("the job needs %d GPUs, but the host only has %d", count, len(a.gpus))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var indices []int
+	for _, i := range a.gpus {
+		if len(indices) == count {
+			break
+		}
+		if !a.inUse[i] {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) < count {
+		return nil, nil, fmt.Errorf("%w: the job needs %d, but only %d are free", errGPUsUnavailable, count, len(indices))
+	}
+	for _, i := range indices {
+		a.inUse[i] = true
+	}
+
+	var once sync.Once
+	return &GPUAllocation{Vendor: a.vendor, Indices: indices}, func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			for _, i := range indices {
+				delete(a.inUse, i)
+			}
+			close(a.released)
+			a.released = make(chan struct{})
+		})
+	}, nil
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseNvidiaSMI(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseNvidiaSMI("0, NVIDIA A100-SXM4-40GB\n1, NVIDIA A100-SXM4-40GB\n")
+	if err != nil {
+		t.Fatalf("parseNvidiaSMI(...) error = %v", err)
+	}
+	want := []GPU{
+		{Index: 0, Model: "NVIDIA A100-SXM4-40GB"},
+		{Index: 1, Model: "NVIDIA A100-SXM4-40GB"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("parseNvidiaSMI(...) diff (-got +want):\n%s", diff)
+	}
+
+	if _, err := parseNvidiaSMI("zero, NVIDIA A100\n"); err == nil {
+		t.Errorf("parseNvidiaSMI(bad index) error = nil, want an error")
+	}
+}
+
+func TestParseROCmSMI(t *testing.T) {
+	t.Parallel()
+
+	out := `device,Card series,Card model,Card vendor,Card SKU
+card0,Instinct MI210,0x0740,Advanced Micro Devices Inc. [AMD/ATI],D67301
+card1,Instinct MI210,0x0740,Advanced Micro Devices Inc. [AMD/ATI],D67301
+`
+	got, err := parseROCmSMI(out)
+	if err != nil {
+		t.Fatalf("parseROCmSMI(...) error = %v", err)
+	}
+	want := []GPU{
+		{Index: 0, Model: "Instinct MI210"},
+		{Index: 1, Model: "Instinct MI210"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("parseROCmSMI(...) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestGPUTags(t *testing.T) {
+	t.Parallel()
+
+	got := GPUTags(GPUVendorNVIDIA, []GPU{
+		{Index: 0, Model: "NVIDIA L4"},
+		{Index: 1, Model: "NVIDIA A100"},
+		{Index: 2, Model: "NVIDIA L4"},
+	})
+	want := map[string]string{
+		"gpu_vendor": "nvidia",
+		"gpu_count":  "3",
+		"gpu_model":  "NVIDIA A100,NVIDIA L4",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GPUTags(...) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestGPUAllocator(t *testing.T) {
+	t.Parallel()
+
+	a := NewGPUAllocator(GPUVendorAMD, []GPU{{Index: 2}, {Index: 0}, {Index: 1}})
+
+	oneGPU := &api.Job{Env: map[string]string{}}
+	twoGPUs := &api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "2"}}
+	noGPUs := &api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "0"}}
+
+	first, releaseFirst, err := a.tryAllocate(oneGPU)
+	if err != nil {
+		t.Fatalf("a.tryAllocate(oneGPU) error = %v", err)
+	}
+	if diff := cmp.Diff(first.Indices, []int{0}); diff != "" {
+		t.Errorf("a.tryAllocate(oneGPU) indices diff (-got +want):\n%s", diff)
+	}
+
+	second, releaseSecond, err := a.tryAllocate(twoGPUs)
+	if err != nil {
+		t.Fatalf("a.tryAllocate(twoGPUs) error = %v", err)
+	}
+	wantEnv := map[string]string{
+		"BUILDKITE_AGENT_GPUS": "1,2",
+		"CUDA_VISIBLE_DEVICES": "1,2",
+		"CUDA_DEVICE_ORDER":    "PCI_BUS_ID",
+		"ROCR_VISIBLE_DEVICES": "1,2",
+	}
+	if diff := cmp.Diff(second.env(), wantEnv); diff != "" {
+		t.Errorf("second allocation env diff (-got +want):\n%s", diff)
+	}
+
+	// Every GPU is in use.
+	if _, _, err := a.tryAllocate(oneGPU); !errors.Is(err, errGPUsUnavailable) {
+		t.Errorf("a.tryAllocate(oneGPU) with no free GPUs error = %v, want %v", err, errGPUsUnavailable)
+	}
+
+	// Jobs that don't need any GPUs can still run, and can't see any.
+	none, releaseNone, err := a.tryAllocate(noGPUs)
+	if err != nil {
+		t.Fatalf("a.tryAllocate(noGPUs) error = %v", err)
+	}
+	if got := none.env()["CUDA_VISIBLE_DEVICES"]; got != "" {
+		t.Errorf("CUDA_VISIBLE_DEVICES for a job with no GPUs = %q, want empty", got)
+	}
+	releaseNone()
+
+	// allocate waits for GPUs to be released.
+	waited := make(chan error)
+	go func() {
+		_, release, err := a.allocate(context.Background(), oneGPU)
+		if err == nil {
+			release()
+		}
+		waited <- err
+	}()
+
+	releaseSecond()
+	releaseSecond() // releasing twice doesn't free someone else's GPUs
+	if err := <-waited; err != nil {
+		t.Errorf("a.allocate(ctx, oneGPU) error = %v", err)
+	}
+	releaseFirst()
+
+	// but not for GPUs the host doesn't have.
+	if _, _, err := a.allocate(context.Background(), &api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "4"}}); err == nil {
+		t.Errorf("a.allocate(ctx, more GPUs than the host has) error = nil, want an error")
+	}
+
+	if _, _, err := a.tryAllocate(&api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "4"}}); err == nil || errors.Is(err, errGPUsUnavailable) {
+		t.Errorf("a.tryAllocate(more GPUs than the host has) error = %v, want a different error", err)
+	}
+	if _, _, err := a.tryAllocate(&api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "lots"}}); err == nil {
+		t.Errorf("a.tryAllocate(invalid BUILDKITE_GPU_COUNT) error = nil, want an error")
+	}
+
+	all, _, err := a.tryAllocate(&api.Job{Env: map[string]string{"BUILDKITE_GPU_COUNT": "3"}})
+	if err != nil {
+		t.Fatalf("a.tryAllocate(all GPUs) after releasing error = %v", err)
+	}
+	if diff := cmp.Diff(all.Indices, []int{0, 1, 2}); diff != "" {
+		t.Errorf("a.tryAllocate(all GPUs) indices diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestNilGPUAllocator(t *testing.T) {
+	t.Parallel()
+
+	var a *GPUAllocator
+	gpus, release, err := a.tryAllocate(&api.Job{})
+	if err != nil {
+		t.Fatalf("nil a.tryAllocate() error = %v", err)
+	}
+	if gpus != nil {
+		t.Errorf("nil a.tryAllocate() = %v, want nil", gpus)
+	}
+	release()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,6 +43,12 @@ type headerTimesStreamer struct {
 	//  - Channel close in Stop (jobRunner.Run goroutine)
 	//  - Channel receive in Upload (headerTimesStreamer.Run goroutine)
 	timesCh chan string
+
+	// The sections of the job log found so far, started by each header.
+	// Guarded by sectionsMu, since the status page reads them while the job
+	// runs.
+	sectionsMu sync.Mutex
+	sections   []jobSection
 }
 
 func newHeaderTimesStreamer(l logger.Logger, upload func(context.Context, int, int, map[string]string)) *headerTimesStreamer {
@@ -144,16 +151,32 @@ func (h *headerTimesStreamer) Scan(line string) bool {
 
 	h.logger.Debug("[HeaderTimesStreamer] Found header %q", line)
 
+	now := time.Now()
+	h.sectionsMu.Lock()
+	h.sections = append(h.sections, jobSection{
+		Header:    strings.TrimSpace(headerRE.ReplaceAllString(line, "")),
+		StartedAt: now,
+	})
+	h.sectionsMu.Unlock()
+
 	// Use mutex to prevent concurrently sending and closing the channel.
 	h.streamingMu.Lock()
 	defer h.streamingMu.Unlock()
 
 	if h.streaming {
-		h.timesCh <- time.Now().UTC().Format(time.RFC3339Nano)
+		h.timesCh <- now.UTC().Format(time.RFC3339Nano)
 	}
 	return true
 }
 
+// Sections returns the sections of the job log found so far, with the last
+// one running until end.
+func (h *headerTimesStreamer) Sections(end time.Time) []jobSection {
+	h.sectionsMu.Lock()
+	defer h.sectionsMu.Unlock()
+	return sectionTimings(h.sections, end)
+}
+
 // Stop stops the header time streamer. This should only be called after the
 // logs have stopped being generated (the process has ended).
 func (h *headerTimesStreamer) Stop() {
@@ -50,3 +50,42 @@ func TestWhenCachePathsSetInJobStep_CachePathsEnvVarIsSet(t *testing.T) {
 		t.Fatalf("runJob() error = %v", err)
 	}
 }
+
+func TestWhenStepKeySet_MetaDataStepScopeEnvVarIsSet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	job := &api.Job{
+		ID:                 "my-job-id",
+		ChunksMaxSizeBytes: 1024,
+		Env: map[string]string{
+			"BUILDKITE_STEP_KEY": "test-linux",
+		},
+		Token: "bkaj_job-token",
+	}
+
+	mb := mockBootstrap(t)
+	defer mb.CheckAndClose(t)
+
+	mb.Expect().Once().AndExitWith(0).AndCallFunc(func(c *bintest.Call) {
+		if got, want := c.GetEnv("BUILDKITE_METADATA_STEP_SCOPE"), "step:test-linux"; got != want {
+			t.Errorf("c.GetEnv(BUILDKITE_METADATA_STEP_SCOPE) = %q, want %q", got, want)
+		}
+		c.Exit(0)
+	})
+
+	// create a mock agent API
+	e := createTestAgentEndpoint()
+	server := e.server()
+	defer server.Close()
+
+	err := runJob(t, ctx, testRunJobConfig{
+		job:           job,
+		server:        server,
+		agentCfg:      agent.AgentConfiguration{},
+		mockBootstrap: mb,
+	})
+	if err != nil {
+		t.Fatalf("runJob() error = %v", err)
+	}
+}
@@ -348,3 +348,94 @@ func TestJobRunnerIgnoresPipelineChangesToProtectedVars(t *testing.T) {
 		mockBootstrap: mb,
 	})
 }
+
+func TestAgentJobHooks(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+
+	tests := []struct {
+		name           string
+		preJobExit     int
+		bootstrapExit  int
+		wantPostJobEnv string
+	}{
+		{
+			name:           "job runs",
+			preJobExit:     0,
+			bootstrapExit:  3,
+			wantPostJobEnv: "my-job-id llamas 3 \n",
+		},
+		{
+			name:           "pre-job hook refuses the job",
+			preJobExit:     1,
+			wantPostJobEnv: "my-job-id llamas -1 agent_refused\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			hooksDir := t.TempDir()
+			outDir := t.TempDir()
+			testMainPath, err := os.Executable()
+			assert.NilError(t, err)
+
+			hooks := map[string]string{
+				"agent-pre-job": fmt.Sprintf("#!/bin/sh\necho \"$BUILDKITE_JOB_ID $BUILDKITE_PIPELINE_SLUG\" > %q\nexit %d\n",
+					filepath.Join(outDir, "pre-job"), test.preJobExit),
+				"agent-post-job": fmt.Sprintf("#!/bin/sh\necho \"$BUILDKITE_JOB_ID $BUILDKITE_PIPELINE_SLUG $BUILDKITE_JOB_EXIT_STATUS $BUILDKITE_JOB_SIGNAL_REASON\" > %q\n",
+					filepath.Join(outDir, "post-job")),
+			}
+			for name, contents := range hooks {
+				// Write the hooks in a subprocess to avoid intermittent ETXTBSY errors on Linux
+				cmd := exec.Command(testMainPath, "write-exec", filepath.Join(hooksDir, name))
+				cmd.Stdin = strings.NewReader(contents)
+				assert.NilError(t, cmd.Run())
+			}
+
+			e := createTestAgentEndpoint()
+			server := e.server()
+			t.Cleanup(server.Close)
+
+			j := &api.Job{
+				ID:                 "my-job-id",
+				ChunksMaxSizeBytes: 1024,
+				Env: map[string]string{
+					"BUILDKITE_COMMAND":       "echo hello world",
+					"BUILDKITE_PIPELINE_SLUG": "llamas",
+				},
+				Token: "bkaj_job-token",
+			}
+
+			mb := mockBootstrap(t)
+			if test.preJobExit == 0 {
+				mb.Expect().Once().AndExitWith(test.bootstrapExit)
+			} else {
+				mb.Expect().NotCalled()
+			}
+			defer mb.CheckAndClose(t)
+
+			if err := runJob(t, ctx, testRunJobConfig{
+				job:           j,
+				server:        server,
+				agentCfg:      agent.AgentConfiguration{HooksPath: hooksDir},
+				mockBootstrap: mb,
+			}); err != nil {
+				t.Fatalf("runJob() error = %v", err)
+			}
+
+			preJob, err := os.ReadFile(filepath.Join(outDir, "pre-job"))
+			assert.NilError(t, err)
+			assert.Equal(t, string(preJob), "my-job-id llamas\n")
+
+			postJob, err := os.ReadFile(filepath.Join(outDir, "post-job"))
+			assert.NilError(t, err)
+			assert.Equal(t, string(postJob), test.wantPostJobEnv)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/kubernetes"
+	"github.com/dustin/go-humanize"
+)
+
+// cgroupRoot is where the cgroup v2 unified hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuMaxPeriod is the period, in microseconds, that a job's CPU limit is
+// enforced over. It's the kernel's default.
+const cpuMaxPeriod = 100000
+
+// agentCgroupName is the cgroup the agent moves itself into when jobs'
+// cgroups are created in the agent's own cgroup. The controllers can only be
+// enabled for the jobs' cgroups in a cgroup with no processes in it.
+const agentCgroupName = "buildkite-agent"
+
+// SetUpJobCgroups prepares parent, the cgroup v2 path (such as
+// /system.slice/buildkite-agent.service) that each job's cgroup will be
+// created in, so that the job memory and CPU limits can be set. If parent is
+// empty, the agent's own cgroup is used, and the agent moves itself into a
+// cgroup within it. It returns the directory of parent.
+func SetUpJobCgroups(parent string, memoryLimit bool, cpuLimit bool) (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", errors.New("job resource limits are only supported on Linux")
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("job resource limits need the cgroup v2 unified hierarchy mounted at %s: %w", cgroupRoot, err)
+	}
+
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	if parent == "" {
+		parent = own
+	}
+	dir := filepath.Join(cgroupRoot, filepath.Clean("/"+parent))
+
+	var controllers []string
+	if memoryLimit {
+		controllers = append(controllers, "memory")
+	}
+	if cpuLimit {
+		controllers = append(controllers, "cpu")
+	}
+
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("reading the controllers of cgroup %s: %w", parent, err)
+	}
+	for _, c := range controllers {
+		if !slices.Contains(strings.Fields(string(available)), c) {
+			return "", fmt.Errorf("the %s controller isn't available in cgroup %s, it needs to be delegated to the agent", c, parent)
+		}
+	}
+
+	// Processes can't be in a cgroup whose controllers are enabled for the
+	// cgroups within it, so the agent has to leave its own cgroup first.
+	if dir == filepath.Join(cgroupRoot, own) {
+		agentDir := filepath.Join(dir, agentCgroupName)
+		if err := os.Mkdir(agentDir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("creating cgroup for the agent: %w", err)
+		}
+		if err := writeCgroupFile(agentDir, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return "", fmt.Errorf("moving the agent into its own cgroup: %w", err)
+		}
+	}
+
+	enable := make([]string, 0, len(controllers))
+	for _, c := range controllers {
+		enable = append(enable, "+"+c)
+	}
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return "", fmt.Errorf("enabling controllers in cgroup %s: %w", parent, err)
+	}
+	return dir, nil
+}
+
+// ownCgroup returns the agent's cgroup v2 path, from /proc/self/cgroup.
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	return parseProcCgroup(data)
+}
+
+// parseProcCgroup returns the cgroup v2 path from the contents of a
+// /proc/<pid>/cgroup file.
+func parseProcCgroup(data []byte) (string, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if path, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("the agent isn't in a cgroup v2 cgroup")
+}
+
+// jobCgroup is the cgroup a job's process tree runs in.
+type jobCgroup struct {
+	dir         string
+	memoryLimit uint64
+	cpuLimit    float64
+}
+
+// newJobCgroup returns the cgroup for the job within the parent directory.
+func newJobCgroup(parent, jobID string, memoryLimit uint64, cpuLimit float64) *jobCgroup {
+	return &jobCgroup{
+		dir:         filepath.Join(parent, "buildkite-job-"+jobID),
+		memoryLimit: memoryLimit,
+		cpuLimit:    cpuLimit,
+	}
+}
+
+// create creates the cgroup and sets its limits.
+func (c *jobCgroup) create() error {
+	if err := os.Mkdir(c.dir, 0o755); err != nil {
+		return fmt.Errorf("creating cgroup: %w", err)
+	}
+	if c.memoryLimit > 0 {
+		if err := writeCgroupFile(c.dir, "memory.max", strconv.FormatUint(c.memoryLimit, 10)); err != nil {
+			return fmt.Errorf("setting memory limit: %w", err)
+		}
+	}
+	if c.cpuLimit > 0 {
+		quota := int64(c.cpuLimit * cpuMaxPeriod)
+		if err := writeCgroupFile(c.dir, "cpu.max", fmt.Sprintf("%d %d", quota, cpuMaxPeriod)); err != nil {
+			return fmt.Errorf("setting CPU limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeUsage writes the peak memory and the CPU time used by the job to w.
+func (c *jobCgroup) writeUsage(w io.Writer, duration time.Duration) error {
+	stats, err := kubernetes.ReadCgroupStats(c.dir)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if stats.OOMKills > 0 {
+		fmt.Fprintf(&b, "+++ ⚠️ %d of the job's processes were killed for using more than the job memory limit of %s\n", stats.OOMKills, humanize.IBytes(c.memoryLimit))
+	} else {
+		b.WriteString("~~~ Job resource usage\n")
+	}
+
+	memory := "unknown"
+	if stats.PeakMemoryBytes > 0 {
+		memory = humanize.IBytes(stats.PeakMemoryBytes)
+	}
+	if c.memoryLimit > 0 {
+		memory += " (limit " + humanize.IBytes(c.memoryLimit) + ")"
+	}
+	fmt.Fprintf(&b, "Peak memory: %s\n", memory)
+
+	cpu := stats.CPUTime.Round(time.Millisecond).String()
+	if duration > 0 {
+		cpu += fmt.Sprintf(" (%.2f CPUs on average", stats.CPUTime.Seconds()/duration.Seconds())
+		if c.cpuLimit > 0 {
+			cpu += fmt.Sprintf(", limit %g", c.cpuLimit)
+		}
+		cpu += ")"
+	}
+	fmt.Fprintf(&b, "CPU time: %s\n", cpu)
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// remove kills anything left running in the cgroup, then removes it.
+func (c *jobCgroup) remove() error {
+	// cgroup.kill was added in Linux 5.14.
+	if err := writeCgroupFile(c.dir, "cgroup.kill", "1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("killing processes left in cgroup: %w", err)
+	}
+
+	// Killed processes leave the cgroup asynchronously, so the cgroup may not
+	// be removable straight away.
+	var err error
+	for range 50 {
+		if err = os.Remove(c.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("removing cgroup: %w", err)
+}
+
+// writeCgroupFile writes value to the named file in the cgroup directory dir.
+// Files can't be created in cgroups, so it doesn't create the file.
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
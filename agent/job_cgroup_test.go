@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProcCgroup(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name, data, want string
+	}{
+		{
+			name: "unified",
+			data: "0::/system.slice/buildkite-agent.service\n",
+			want: "/system.slice/buildkite-agent.service",
+		},
+		{
+			name: "hybrid",
+			data: "4:memory:/system.slice/buildkite-agent.service\n1:name=systemd:/system.slice/buildkite-agent.service\n0::/system.slice/buildkite-agent.service\n",
+			want: "/system.slice/buildkite-agent.service",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseProcCgroup([]byte(test.data))
+			if err != nil {
+				t.Fatalf("parseProcCgroup(%q) error = %v", test.data, err)
+			}
+			if got != test.want {
+				t.Errorf("parseProcCgroup(%q) = %q, want %q", test.data, got, test.want)
+			}
+		})
+	}
+
+	if _, err := parseProcCgroup([]byte("4:memory:/\n1:name=systemd:/\n")); err == nil {
+		t.Errorf("parseProcCgroup(v1 only) error = nil, want an error")
+	}
+}
+
+func TestJobCgroupWriteUsage(t *testing.T) {
+	t.Parallel()
+
+	c := newJobCgroup(t.TempDir(), "01234567-89ab-cdef-0123-456789abcdef", 4<<30, 2)
+	if err := os.Mkdir(c.dir, 0o777); err != nil {
+		t.Fatalf("os.Mkdir(%q) error = %v", c.dir, err)
+	}
+	for name, content := range map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"cpu.stat":           "usage_usec 30000000\nuser_usec 20000000\nsystem_usec 10000000\n",
+		"memory.peak":        "1073741824\n",
+		"memory.events":      "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(c.dir, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", name, err)
+		}
+	}
+
+	var b strings.Builder
+	if err := c.writeUsage(&b, 20*time.Second); err != nil {
+		t.Fatalf("c.writeUsage(...) error = %v", err)
+	}
+	want := "~~~ Job resource usage\nPeak memory: 1.0 GiB (limit 4.0 GiB)\nCPU time: 30s (1.50 CPUs on average, limit 2)\n"
+	if got := b.String(); got != want {
+		t.Errorf("c.writeUsage(...) wrote %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(c.dir, "memory.events"), []byte("oom_kill 2\n"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(memory.events) error = %v", err)
+	}
+	b.Reset()
+	if err := c.writeUsage(&b, 20*time.Second); err != nil {
+		t.Fatalf("c.writeUsage(...) error = %v", err)
+	}
+	if got, want := b.String(), "+++ ⚠️ 2 of the job's processes were killed for using more than the job memory limit of 4.0 GiB\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("c.writeUsage(...) wrote %q, want it to start with %q", got, want)
+	}
+}
+
+func TestWriteCgroupFileDoesNotCreate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := writeCgroupFile(dir, "cgroup.kill", "1"); !os.IsNotExist(err) {
+		t.Errorf("writeCgroupFile(dir, missing file) error = %v, want a not-exist error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.kill")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(cgroup.kill) error = %v, want a not-exist error", err)
+	}
+}
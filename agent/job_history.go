@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/core"
+)
+
+// jobHistorySize is how many finished jobs each worker lists on the status
+// page.
+const jobHistorySize = 20
+
+// jobSection is a section of a job log, started by a header such as
+// "~~~ Running global environment hook". The sections show which phase or hook
+// a job is in, and how long each one took.
+type jobSection struct {
+	Header    string
+	StartedAt time.Time
+
+	// How long the section ran for, or has been running for if it is the
+	// last one and the job is still running.
+	Duration time.Duration
+}
+
+// jobRecord describes a job a worker has run, for the status page.
+type jobRecord struct {
+	ID         string
+	Label      string
+	URL        string
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// The exit of the job process. Exited is false if the job finished without
+	// running it, for example because it couldn't be started.
+	Exit   core.ProcessExit
+	Exited bool
+
+	// The error from running the job, if any
+	Err error
+
+	Sections []jobSection
+}
+
+// newJobRecord starts a record of the job.
+func newJobRecord(job *api.Job) *jobRecord {
+	record := &jobRecord{
+		ID:    job.ID,
+		Label: job.Env["BUILDKITE_LABEL"],
+	}
+	if buildURL := job.Env["BUILDKITE_BUILD_URL"]; buildURL != "" {
+		record.URL = buildURL + "#" + job.ID
+	}
+	return record
+}
+
+// Duration returns how long the job ran for, or has been running for.
+func (j jobRecord) Duration() time.Duration {
+	return j.FinishedAt.Sub(j.StartedAt).Round(time.Millisecond)
+}
+
+// CurrentSection returns the last section of the job log, or nil if there
+// are none yet.
+func (j jobRecord) CurrentSection() *jobSection {
+	if len(j.Sections) == 0 {
+		return nil
+	}
+	return &j.Sections[len(j.Sections)-1]
+}
+
+// jobHistory keeps the most recent jobs a worker has finished.
+type jobHistory struct {
+	mu   sync.Mutex
+	jobs []jobRecord
+}
+
+// add records a finished job, forgetting the oldest one if the history is
+// full.
+func (h *jobHistory) add(j jobRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.jobs = append(h.jobs, j)
+	if len(h.jobs) > jobHistorySize {
+		h.jobs = h.jobs[len(h.jobs)-jobHistorySize:]
+	}
+}
+
+// list returns the recorded jobs, most recently finished first.
+func (h *jobHistory) list() []jobRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	jobs := make([]jobRecord, 0, len(h.jobs))
+	for i := len(h.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, h.jobs[i])
+	}
+	return jobs
+}
+
+// sectionTimings works out the duration of each section from when the next one
+// started. The last section runs until end.
+func sectionTimings(sections []jobSection, end time.Time) []jobSection {
+	timed := make([]jobSection, len(sections))
+	for i, s := range sections {
+		next := end
+		if i+1 < len(sections) {
+			next = sections[i+1].StartedAt
+		}
+		s.Duration = next.Sub(s.StartedAt).Round(time.Millisecond)
+		timed[i] = s
+	}
+	return timed
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/core"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHistory_KeepsMostRecentFirst(t *testing.T) {
+	t.Parallel()
+
+	var h jobHistory
+	for i := range jobHistorySize + 5 {
+		h.add(jobRecord{ID: strings.Repeat("x", i)})
+	}
+
+	jobs := h.list()
+	require.Len(t, jobs, jobHistorySize)
+	assert.Equal(t, strings.Repeat("x", jobHistorySize+4), jobs[0].ID)
+	assert.Equal(t, strings.Repeat("x", 5), jobs[len(jobs)-1].ID)
+}
+
+func TestSectionTimings(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := sectionTimings([]jobSection{
+		{Header: "Running global environment hook", StartedAt: start},
+		{Header: "Preparing working directory", StartedAt: start.Add(2 * time.Second)},
+		{Header: "Running commands", StartedAt: start.Add(5 * time.Second)},
+	}, start.Add(65*time.Second))
+
+	assert.Equal(t, []jobSection{
+		{Header: "Running global environment hook", StartedAt: start, Duration: 2 * time.Second},
+		{Header: "Preparing working directory", StartedAt: start.Add(2 * time.Second), Duration: 3 * time.Second},
+		{Header: "Running commands", StartedAt: start.Add(5 * time.Second), Duration: time.Minute},
+	}, got)
+}
+
+func TestHeaderTimesStreamer_Sections(t *testing.T) {
+	t.Parallel()
+
+	h := newHeaderTimesStreamer(logger.Discard, nil)
+	h.Scan("~~~ Running global environment hook")
+	h.Scan("not a header")
+	h.Scan("\x1b[90m--- :package: Running commands\x1b[0m")
+
+	var headers []string
+	for _, s := range h.Sections(time.Now()) {
+		headers = append(headers, s.Header)
+	}
+	assert.Equal(t, []string{"Running global environment hook", ":package: Running commands"}, headers)
+}
+
+type fakeJobRunner struct {
+	sections []jobSection
+	exit     core.ProcessExit
+}
+
+func (f *fakeJobRunner) Run(context.Context) error { return nil }
+func (f *fakeJobRunner) CancelAndStop() error      { return nil }
+func (f *fakeJobRunner) Sections(end time.Time) []jobSection {
+	return sectionTimings(f.sections, end)
+}
+func (f *fakeJobRunner) Exit() (core.ProcessExit, bool) { return f.exit, true }
+
+func TestWorkerStatusShowsCurrentAndPastJobs(t *testing.T) {
+	t.Parallel()
+
+	worker := &AgentWorker{agent: &api.AgentRegisterResponse{UUID: "worker-uuid"}}
+
+	worker.history.add(jobRecord{
+		ID:         "old-job",
+		URL:        "https://buildkite.com/acme/app/builds/1#old-job",
+		StartedAt:  time.Now().Add(-time.Hour),
+		FinishedAt: time.Now().Add(-59 * time.Minute),
+		Exit:       core.ProcessExit{Status: 1},
+		Exited:     true,
+	})
+	worker.history.add(jobRecord{
+		ID:  "unstarted-job",
+		Err: errors.New("couldn't start job"),
+	})
+
+	job := &api.Job{
+		ID: "current-job",
+		Env: map[string]string{
+			"BUILDKITE_BUILD_URL": "https://buildkite.com/acme/app/builds/2",
+			"BUILDKITE_LABEL":     ":hammer: Build",
+		},
+	}
+	worker.setBusy(job.ID)
+	worker.setJobRunner(job, &fakeJobRunner{
+		sections: []jobSection{{Header: "Running pre-command hook", StartedAt: time.Now()}},
+	})
+
+	data, err := worker.statusCallback(context.Background())
+	require.NoError(t, err)
+
+	tmpl := template.Must(template.New("worker").Parse(workerStatusPart))
+	var sb strings.Builder
+	require.NoError(t, tmpl.Execute(&sb, data))
+	out := sb.String()
+
+	assert.Contains(t, out, `<a href="https://buildkite.com/acme/app/builds/2#current-job">current-job</a> :hammer: Build`)
+	assert.Contains(t, out, "<code>Running pre-command hook</code>")
+	assert.Contains(t, out, `<a href="https://buildkite.com/acme/app/builds/1#old-job">old-job</a>`)
+	assert.Contains(t, out, "❌ not run: couldn&#39;t start job")
+	assert.Less(t, strings.Index(out, "unstarted-job"), strings.Index(out, "old-job"), "most recent job should be listed first")
+}
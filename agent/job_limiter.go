@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// JobLimiter limits how many jobs the workers in an agent pool run at the same
+// time, in total and for jobs that target particular agent tags. It is shared
+// between all of the pool's workers. A nil JobLimiter doesn't limit anything.
+//
+// A worker reserves a place before it asks Buildkite for work, so no more jobs
+// are assigned to the pool than it may run. Once a job has been assigned, the
+// worker waits for places under the limits for the tags the job targets before
+// accepting it.
+type JobLimiter struct {
+	max    int
+	tagMax map[string]int
+
+	mu         sync.Mutex
+	running    int
+	tagRunning map[string]int
+
+	// changed is closed, and replaced, whenever a place is released.
+	changed chan struct{}
+}
+
+// NewJobLimiter returns a JobLimiter allowing at most max jobs to run at once
+// (0 for no limit), and at most tagMax[tag] jobs targeting each "key=value"
+// tag. It returns nil if there are no limits.
+func NewJobLimiter(max int, tagMax map[string]int) *JobLimiter {
+	if max <= 0 && len(tagMax) == 0 {
+		return nil
+	}
+	return &JobLimiter{
+		max:        max,
+		tagMax:     tagMax,
+		tagRunning: make(map[string]int),
+		changed:    make(chan struct{}),
+	}
+}
+
+// ParseTagJobLimits parses per-tag job limits, each in the form
+// "key=value:limit", such as "gpu=true:1".
+func ParseTagJobLimits(limits []string) (map[string]int, error) {
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	tagMax := make(map[string]int, len(limits))
+	for _, l := range limits {
+		tag, n, ok := cutLast(l, ":")
+		key, _, hasValue := strings.Cut(tag, "=")
+		if !ok || !hasValue || key == "" {
+			return nil, fmt.Errorf("job limit %q isn't in the form key=value:limit", l)
+		}
+		max, err := strconv.Atoi(n)
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("job limit %q must end with a positive whole number", l)
+		}
+		tagMax[tag] = max
+	}
+	return tagMax, nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// full reports whether as many jobs are running (or reserved) as are allowed in
+// total, in which case workers shouldn't ask for more work.
+func (l *JobLimiter) full() bool {
+	if l == nil || l.max <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running >= l.max
+}
+
+// tryReserve reserves a place for a job to run, if fewer jobs are running (or
+// reserved) than are allowed in total. The reservation must be released once
+// it's no longer needed, or the job it was used for finishes.
+func (l *JobLimiter) tryReserve() (*jobReservation, bool) {
+	if l == nil {
+		return nil, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.running >= l.max {
+		return nil, false
+	}
+	l.running++
+	return &jobReservation{limiter: l}, true
+}
+
+// reserve is like tryReserve, but waits for a place to be free.
+func (l *JobLimiter) reserve(ctx context.Context) (*jobReservation, error) {
+	if l == nil {
+		return nil, nil
+	}
+	for {
+		l.mu.Lock()
+		changed := l.changed
+		l.mu.Unlock()
+
+		if r, ok := l.tryReserve(); ok {
+			return r, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// jobReservation is a place reserved for a job under a JobLimiter's limits. A
+// nil jobReservation, from a nil JobLimiter, doesn't reserve anything.
+type jobReservation struct {
+	limiter *JobLimiter
+	tags    []string
+	once    sync.Once
+}
+
+// waitForTags waits until the job can run without exceeding the limits for the
+// tags it targets, and then reserves places for it under those limits too.
+func (r *jobReservation) waitForTags(ctx context.Context, job *api.Job) error {
+	if r == nil {
+		return nil
+	}
+	l := r.limiter
+
+	var tags []string
+	for tag := range l.tagMax {
+		if jobTargetsTag(job, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		full := ""
+		for _, tag := range tags {
+			if l.tagRunning[tag] >= l.tagMax[tag] {
+				full = tag
+				break
+			}
+		}
+		if full == "" {
+			for _, tag := range tags {
+				l.tagRunning[tag]++
+			}
+			r.tags = tags
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for fewer jobs with the tag %q to be running: %w", full, context.Cause(ctx))
+		}
+	}
+}
+
+// release gives up the reserved places. Releasing more than once has no
+// further effect.
+func (r *jobReservation) release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		l := r.limiter
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.running--
+		for _, tag := range r.tags {
+			l.tagRunning[tag]--
+		}
+		close(l.changed)
+		l.changed = make(chan struct{})
+	})
+}
+
+// jobTargetsTag reports whether the job's agent targeting rules include the
+// "key=value" tag. Buildkite provides them to the job as
+// BUILDKITE_AGENT_META_DATA_<KEY> environment variables.
+func jobTargetsTag(job *api.Job, tag string) bool {
+	key, value, _ := strings.Cut(tag, "=")
+	name := "BUILDKITE_AGENT_META_DATA_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+	v, ok := job.Env[name]
+	return ok && v == value
+}
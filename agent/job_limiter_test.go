@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTagJobLimits(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseTagJobLimits([]string{"gpu=true:1", "url=http://example.com:2"})
+	if err != nil {
+		t.Fatalf("ParseTagJobLimits(...) error = %v", err)
+	}
+	want := map[string]int{"gpu=true": 1, "url=http://example.com": 2}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ParseTagJobLimits(...) diff (-got +want):\n%s", diff)
+	}
+
+	for _, bad := range []string{"gpu", "gpu:1", "gpu=true", "=true:1", "gpu=true:0", "gpu=true:many"} {
+		if _, err := ParseTagJobLimits([]string{bad}); err == nil {
+			t.Errorf("ParseTagJobLimits([%q]) error = nil, want an error", bad)
+		}
+	}
+}
+
+func TestJobLimiter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	l := NewJobLimiter(2, map[string]int{"gpu=true": 1})
+
+	gpuJob := &api.Job{Env: map[string]string{"BUILDKITE_AGENT_META_DATA_GPU": "true"}}
+	otherJob := &api.Job{Env: map[string]string{"BUILDKITE_AGENT_META_DATA_QUEUE": "default"}}
+
+	gpuReservation, ok := l.tryReserve()
+	if !ok {
+		t.Fatalf("l.tryReserve() = false with no jobs running, want true")
+	}
+	if err := gpuReservation.waitForTags(ctx, gpuJob); err != nil {
+		t.Fatalf("gpuReservation.waitForTags(ctx, gpuJob) error = %v", err)
+	}
+	if l.full() {
+		t.Errorf("l.full() = true with 1 of 2 jobs running, want false")
+	}
+
+	// A second GPU job has to wait for the first to finish
+	secondReservation, ok := l.tryReserve()
+	if !ok {
+		t.Fatalf("l.tryReserve() = false with 1 of 2 jobs running, want true")
+	}
+	if !l.full() {
+		t.Errorf("l.full() = false with 2 of 2 places reserved, want true")
+	}
+	if _, ok := l.tryReserve(); ok {
+		t.Errorf("l.tryReserve() = true with 2 of 2 places reserved, want false")
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := secondReservation.waitForTags(shortCtx, gpuJob); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("secondReservation.waitForTags(shortCtx, gpuJob) error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	waited := make(chan error)
+	go func() { waited <- secondReservation.waitForTags(ctx, gpuJob) }()
+	gpuReservation.release()
+	gpuReservation.release() // releasing twice has no further effect
+	if err := <-waited; err != nil {
+		t.Errorf("secondReservation.waitForTags(ctx, gpuJob) error = %v", err)
+	}
+
+	otherReservation, ok := l.tryReserve()
+	if !ok {
+		t.Fatalf("l.tryReserve() = false with 1 of 2 jobs running, want true")
+	}
+	if err := otherReservation.waitForTags(ctx, otherJob); err != nil {
+		t.Errorf("otherReservation.waitForTags(ctx, otherJob) error = %v", err)
+	}
+
+	secondReservation.release()
+	otherReservation.release()
+	if l.full() {
+		t.Errorf("l.full() = true after releasing all jobs, want false")
+	}
+}
+
+func TestNilJobLimiterDoesNotLimit(t *testing.T) {
+	t.Parallel()
+
+	l := NewJobLimiter(0, nil)
+	if l != nil {
+		t.Fatalf("NewJobLimiter(0, nil) = %v, want nil", l)
+	}
+	if l.full() {
+		t.Errorf("nil JobLimiter full() = true, want false")
+	}
+	r, ok := l.tryReserve()
+	if !ok {
+		t.Fatalf("nil JobLimiter tryReserve() = false, want true")
+	}
+	if err := r.waitForTags(context.Background(), &api.Job{}); err != nil {
+		t.Fatalf("nil jobReservation waitForTags() error = %v", err)
+	}
+	r.release()
+}
+
+func TestWorkerPausesAtJobLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	accepted := false
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		accepted = true
+		http.Error(rw, "Not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	limiter := NewJobLimiter(1, nil)
+	worker := &AgentWorker{
+		logger:     logger.Discard,
+		apiClient:  api.NewClient(logger.Discard, api.Config{Endpoint: server.URL, Token: "llamas"}),
+		jobLimiter: limiter,
+		state:      agentWorkerStateIdle,
+	}
+
+	if reason := worker.updatePaused(ctx); reason != "" {
+		t.Errorf("worker.updatePaused(ctx) = %q with no jobs running, want \"\"", reason)
+	}
+
+	reservation, ok := limiter.tryReserve()
+	if !ok {
+		t.Fatalf("limiter.tryReserve() = false, want true")
+	}
+
+	if reason := worker.updatePaused(ctx); reason == "" {
+		t.Errorf("worker.updatePaused(ctx) = \"\" at the job limit, want a reason")
+	}
+	if got, want := worker.getState(), agentWorkerStatePaused; got != want {
+		t.Errorf("worker.getState() = %q, want %q", got, want)
+	}
+
+	// A job can't be accepted until there's a place for it
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := worker.AcceptAndRunJob(shortCtx, &api.Job{ID: "some-uuid"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("worker.AcceptAndRunJob(shortCtx, job) error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if accepted {
+		t.Errorf("job was accepted at the job limit")
+	}
+
+	reservation.release()
+	if reason := worker.updatePaused(ctx); reason != "" {
+		t.Errorf("worker.updatePaused(ctx) = %q after the job finished, want \"\"", reason)
+	}
+	if got, want := worker.getState(), agentWorkerStateIdle; got != want {
+		t.Errorf("worker.getState() = %q, want %q", got, want)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/buildkite/agent/v3/core"
 	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/experiments"
+	"github.com/buildkite/agent/v3/internal/redact"
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/buildkite/agent/v3/logger"
@@ -52,30 +53,114 @@ const (
 // Certain env can only be set by agent configuration.
 // We show the user a warning in the bootstrap if they use any of these at a job level.
 var ProtectedEnv = map[string]struct{}{
-	"BUILDKITE_AGENT_ACCESS_TOKEN":       {},
-	"BUILDKITE_AGENT_DEBUG":              {},
-	"BUILDKITE_AGENT_ENDPOINT":           {},
-	"BUILDKITE_AGENT_PID":                {},
-	"BUILDKITE_BIN_PATH":                 {},
-	"BUILDKITE_BUILD_PATH":               {},
-	"BUILDKITE_COMMAND_EVAL":             {},
-	"BUILDKITE_CONFIG_PATH":              {},
-	"BUILDKITE_CONTAINER_COUNT":          {},
-	"BUILDKITE_GIT_CLEAN_FLAGS":          {},
-	"BUILDKITE_GIT_CLONE_FLAGS":          {},
-	"BUILDKITE_GIT_CLONE_MIRROR_FLAGS":   {},
-	"BUILDKITE_GIT_FETCH_FLAGS":          {},
-	"BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT": {},
-	"BUILDKITE_GIT_MIRRORS_PATH":         {},
-	"BUILDKITE_GIT_MIRRORS_SKIP_UPDATE":  {},
-	"BUILDKITE_GIT_SUBMODULES":           {},
-	"BUILDKITE_HOOKS_PATH":               {},
-	"BUILDKITE_KUBERNETES_EXEC":          {},
-	"BUILDKITE_LOCAL_HOOKS_ENABLED":      {},
-	"BUILDKITE_PLUGINS_ENABLED":          {},
-	"BUILDKITE_PLUGINS_PATH":             {},
-	"BUILDKITE_SHELL":                    {},
-	"BUILDKITE_SSH_KEYSCAN":              {},
+	"BUILDKITE_AGENT_ACCESS_TOKEN":          {},
+	"BUILDKITE_AGENT_DEBUG":                 {},
+	"BUILDKITE_AGENT_ENDPOINT":              {},
+	"BUILDKITE_AGENT_PID":                   {},
+	"BUILDKITE_ALLOWED_PLUGINS":             {},
+	"BUILDKITE_ALLOWED_REPOSITORIES":        {},
+	"BUILDKITE_BIN_PATH":                    {},
+	"BUILDKITE_BUILD_PATH":                  {},
+	"BUILDKITE_CHECKOUT_RETRY_ATTEMPTS":     {},
+	"BUILDKITE_CHECKOUT_RETRY_BACKOFF":      {},
+	"BUILDKITE_COMMAND_ENV_ALLOW":           {},
+	"BUILDKITE_COMMAND_ENV_DENY":            {},
+	"BUILDKITE_COMMAND_EVAL":                {},
+	"BUILDKITE_COMMAND_SANDBOX":             {},
+	"BUILDKITE_COMMAND_SANDBOX_ALLOW_PATHS": {},
+	"BUILDKITE_CONFIG_PATH":                 {},
+	"BUILDKITE_CONTAINER_COUNT":             {},
+	"BUILDKITE_EPHEMERAL_BUILD_DIR":         {},
+	"BUILDKITE_GIT_CLEAN_FLAGS":             {},
+	"BUILDKITE_GIT_CLONE_FLAGS":             {},
+	"BUILDKITE_GIT_CLONE_MIRROR_FLAGS":      {},
+	"BUILDKITE_GIT_FETCH_FLAGS":             {},
+	"BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT":    {},
+	"BUILDKITE_GIT_MIRRORS_PATH":            {},
+	"BUILDKITE_GIT_MIRRORS_SKIP_UPDATE":     {},
+	"BUILDKITE_GIT_MIRRORS_WORKTREE":        {},
+	"BUILDKITE_GIT_SUBMODULES":              {},
+	"BUILDKITE_HOOK_INTERPRETERS":           {},
+	"BUILDKITE_HOOK_TIMEOUT":                {},
+	"BUILDKITE_HOOKS_PATH":                  {},
+	"BUILDKITE_JOB_LOG_FORMAT":              {},
+	"BUILDKITE_KUBERNETES_EXEC":             {},
+	"BUILDKITE_LOCAL_HOOKS_ENABLED":         {},
+	"BUILDKITE_PLUGIN_LOCK_FILE":            {},
+	"BUILDKITE_PLUGINS_ALLOW_DRIFT":         {},
+	"BUILDKITE_PLUGINS_ENABLED":             {},
+	"BUILDKITE_PLUGINS_PATH":                {},
+	"BUILDKITE_PROTECTED_ENV":               {},
+	"BUILDKITE_PROTECTED_ENV_ENFORCE":       {},
+	"BUILDKITE_SHELL":                       {},
+	"BUILDKITE_SOFT_FAIL_CLASSIFIER":        {},
+	"BUILDKITE_SOFT_FAIL_EXIT_STATUS":       {},
+	"BUILDKITE_SSH_KEYSCAN":                 {},
+}
+
+// Env that Buildkite sets on every job to describe it, rather than env from
+// the pipeline. The agent's protected-env patterns don't apply to these.
+var buildkiteJobEnv = map[string]struct{}{
+	"BUILDKITE":                                    {},
+	"BUILDKITE_AGENT_ID":                           {},
+	"BUILDKITE_AGENT_NAME":                         {},
+	"BUILDKITE_ARTIFACT_PATHS":                     {},
+	"BUILDKITE_BRANCH":                             {},
+	"BUILDKITE_BUILD_AUTHOR":                       {},
+	"BUILDKITE_BUILD_AUTHOR_EMAIL":                 {},
+	"BUILDKITE_BUILD_CREATOR":                      {},
+	"BUILDKITE_BUILD_CREATOR_EMAIL":                {},
+	"BUILDKITE_BUILD_CREATOR_TEAMS":                {},
+	"BUILDKITE_BUILD_ID":                           {},
+	"BUILDKITE_BUILD_NUMBER":                       {},
+	"BUILDKITE_BUILD_URL":                          {},
+	"BUILDKITE_CLEAN_CHECKOUT":                     {},
+	"BUILDKITE_CLUSTER_ID":                         {},
+	"BUILDKITE_COMMAND":                            {},
+	"BUILDKITE_COMMIT":                             {},
+	"BUILDKITE_GROUP_ID":                           {},
+	"BUILDKITE_GROUP_KEY":                          {},
+	"BUILDKITE_GROUP_LABEL":                        {},
+	"BUILDKITE_JOB_ID":                             {},
+	"BUILDKITE_LABEL":                              {},
+	"BUILDKITE_MESSAGE":                            {},
+	"BUILDKITE_ORGANIZATION_ID":                    {},
+	"BUILDKITE_ORGANIZATION_SLUG":                  {},
+	"BUILDKITE_PARALLEL_JOB":                       {},
+	"BUILDKITE_PARALLEL_JOB_COUNT":                 {},
+	"BUILDKITE_PIPELINE_DEFAULT_BRANCH":            {},
+	"BUILDKITE_PIPELINE_ID":                        {},
+	"BUILDKITE_PIPELINE_NAME":                      {},
+	"BUILDKITE_PIPELINE_PROVIDER":                  {},
+	"BUILDKITE_PIPELINE_SLUG":                      {},
+	"BUILDKITE_PIPELINE_TEAMS":                     {},
+	"BUILDKITE_PLUGINS":                            {},
+	"BUILDKITE_PROJECT_PROVIDER":                   {},
+	"BUILDKITE_PROJECT_SLUG":                       {},
+	"BUILDKITE_PULL_REQUEST":                       {},
+	"BUILDKITE_PULL_REQUEST_BASE_BRANCH":           {},
+	"BUILDKITE_PULL_REQUEST_DRAFT":                 {},
+	"BUILDKITE_PULL_REQUEST_LABELS":                {},
+	"BUILDKITE_PULL_REQUEST_REPO":                  {},
+	"BUILDKITE_REBUILT_FROM_BUILD_ID":              {},
+	"BUILDKITE_REBUILT_FROM_BUILD_NUMBER":          {},
+	"BUILDKITE_REPO":                               {},
+	"BUILDKITE_RETRY_COUNT":                        {},
+	"BUILDKITE_SCRIPT_PATH":                        {},
+	"BUILDKITE_SOURCE":                             {},
+	"BUILDKITE_STEP_ID":                            {},
+	"BUILDKITE_STEP_IDENTIFIER":                    {},
+	"BUILDKITE_STEP_KEY":                           {},
+	"BUILDKITE_TAG":                                {},
+	"BUILDKITE_TIMEOUT":                            {},
+	"BUILDKITE_TRIGGERED_FROM_BUILD_ID":            {},
+	"BUILDKITE_TRIGGERED_FROM_BUILD_NUMBER":        {},
+	"BUILDKITE_TRIGGERED_FROM_BUILD_PIPELINE_SLUG": {},
+	"BUILDKITE_UNBLOCKER":                          {},
+	"BUILDKITE_UNBLOCKER_EMAIL":                    {},
+	"BUILDKITE_UNBLOCKER_ID":                       {},
+	"BUILDKITE_UNBLOCKER_TEAMS":                    {},
+	"CI":                                           {},
 }
 
 type JobRunnerConfig struct {
@@ -108,11 +193,28 @@ type JobRunnerConfig struct {
 
 	// Stdout of the parent agent process. Used for job log stdout writing arg, for simpler containerized log collection.
 	AgentStdout io.Writer
+
+	// The GPUs allocated to the job, if GPUs are being allocated
+	GPUs *GPUAllocation
+
+	// Why the agent refuses to run the job, if it does. A job that has been
+	// assigned to the agent can't be handed back, so it's started and then
+	// finished straight away with the agent_refused signal reason, with this
+	// in its log.
+	Refusal error
 }
 
 type jobRunner interface {
 	Run(ctx context.Context) error
 	CancelAndStop() error
+
+	// Sections returns the sections of the job log found so far, with the
+	// last one running until end.
+	Sections(end time.Time) []jobSection
+
+	// Exit returns how the job finished, once Run has returned. It returns
+	// false if Run returned before the job started.
+	Exit() (core.ProcessExit, bool)
 }
 
 type JobRunner struct {
@@ -137,6 +239,13 @@ type JobRunner struct {
 	// The internal buffer of the process output
 	output *process.Buffer
 
+	// Truncates the job log, if MaxJobLogSize is set
+	logTruncator *logTruncator
+
+	// Collapses interactive output from the process, if
+	// CollapseInteractiveOutput is set
+	outputCollapser *outputCollapser
+
 	// The internal header time streamer
 	headerTimesStreamer *headerTimesStreamer
 
@@ -152,6 +261,10 @@ type JobRunner struct {
 	// When the job was started
 	startedAt time.Time
 
+	// How the job finished, and whether it has
+	exit     core.ProcessExit
+	finished bool
+
 	// If the agent is being stopped
 	stopped bool
 
@@ -161,6 +274,17 @@ type JobRunner struct {
 	// Files containing a copy of the job env
 	envShellFile *os.File
 	envJSONFile  *os.File
+
+	// A file the job's subprocesses append metrics to, if metrics are being
+	// collected
+	metricsFile string
+
+	// A copy of the tail of the job log to recover if the agent crashes, if
+	// JobLogSpoolPath is set
+	logSpool *jobLogSpool
+
+	// The cgroup the job runs in, if the job has memory or CPU limits
+	cgroup *jobCgroup
 }
 
 type jobAPI interface {
@@ -215,6 +339,7 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 			Concurrency:       3,
 			MaxChunkSizeBytes: r.conf.Job.ChunksMaxSizeBytes,
 			MaxSizeBytes:      r.conf.Job.LogMaxSizeBytes,
+			MaxBandwidthBytes: r.conf.AgentConfiguration.LogUploadMaxBandwidth,
 		},
 	)
 
@@ -242,6 +367,18 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 	r.agentLogger.Debug("[JobRunner] Created env file (JSON format): %s", file.Name())
 	r.envJSONFile = file
 
+	if r.conf.MetricsScope.Enabled() {
+		file, err = os.CreateTemp(tempDir, fmt.Sprintf("job-metrics-%s", r.conf.Job.ID))
+		if err != nil {
+			return r, err
+		}
+		if err := file.Close(); err != nil {
+			return r, err
+		}
+		r.agentLogger.Debug("[JobRunner] Created metrics file: %s", file.Name())
+		r.metricsFile = file.Name()
+	}
+
 	env, err := r.createEnvironment(ctx)
 	if err != nil {
 		return nil, err
@@ -272,9 +409,34 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 		outputWriter = io.MultiWriter(outputWriter, tmpFile)
 	}
 
+	// If the agent crashes, the next agent to start can recover the tail of
+	// the job log from the spool.
+	if conf.AgentConfiguration.JobLogSpoolPath != "" {
+		spool, err := newJobLogSpool(r.agentLogger, conf.AgentConfiguration.JobLogSpoolPath, conf.AgentConfiguration.JobLogSpoolMaxSize, r.conf.Job, r.apiClient.Config())
+		if err != nil {
+			r.agentLogger.Warn("[JobRunner] Couldn't create the job log spool, so the job log won't be recovered if the agent crashes: %v", err)
+		} else {
+			r.logSpool = spool
+			outputWriter = io.MultiWriter(outputWriter, spool)
+		}
+	}
+
+	// Stop runaway job logs from growing without limit.
+	if conf.AgentConfiguration.MaxJobLogSize > 0 {
+		r.logTruncator = newLogTruncator(outputWriter, conf.AgentConfiguration.MaxJobLogSize)
+		outputWriter = r.logTruncator
+	}
+
 	pr, pw := io.Pipe()
 
 	switch {
+	case conf.AgentConfiguration.JobLogFormat == "json":
+		// processWriter -> outputWriter
+
+		// The job executor timestamps each JSON line itself, and headers are
+		// encoded inside the JSON, so neither timestamps nor header times apply
+		allWriters = append(allWriters, outputWriter)
+
 	case conf.AgentConfiguration.ANSITimestamps:
 		// processWriter -> prefixer -> outputWriter
 
@@ -290,6 +452,7 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 		// because we need to know if the line is a header or not. It's a bummer.
 		allWriters = append(allWriters, pw)
 
+		timestamper := newLineTimestamper(conf.AgentConfiguration.TimestampLinesFormat, time.Now())
 		go func() {
 			// Use a scanner to process output line by line
 			err := process.NewScanner(r.agentLogger).ScanLines(pr, func(line string) {
@@ -299,7 +462,7 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 
 				// Prefix non-header log lines with timestamps
 				if !isHeaderOrExpansion {
-					line = fmt.Sprintf("[%s] %s", time.Now().UTC().Format(time.RFC3339), line)
+					line = timestamper.prefix(time.Now()) + line
 				}
 
 				// Write the log line to the buffer
@@ -350,6 +513,14 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 	// The writer that output from the process goes into
 	r.jobLogs = io.MultiWriter(allWriters...)
 
+	// Collapse progress bars and other redrawn output before it's logged. The
+	// agent's own messages go to jobLogs directly, and are never redrawn.
+	processOutput := r.jobLogs
+	if conf.AgentConfiguration.CollapseInteractiveOutput && conf.AgentConfiguration.JobLogFormat != "json" {
+		r.outputCollapser = newOutputCollapser(r.jobLogs)
+		processOutput = r.outputCollapser
+	}
+
 	// Copy the current processes ENV and merge in the new ones. We do this
 	// so the sub process gets PATH and stuff. We merge our path in over
 	// the top of the current one so the ENV from Buildkite and the agent
@@ -363,12 +534,19 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse BUILDKITE_CONTAINER_COUNT: %w", err)
 		}
+		// Which containers must finish before others start. By default, they
+		// run one after the other.
+		dependsOn, err := kubernetes.ParseContainerDependencies(os.Getenv("BUILDKITE_CONTAINER_DEPENDENCIES"), containerCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse BUILDKITE_CONTAINER_DEPENDENCIES: %w", err)
+		}
 		r.process = kubernetes.NewRunner(r.agentLogger, kubernetes.RunnerConfig{
-			Stdout:            r.jobLogs,
-			Stderr:            r.jobLogs,
+			Stdout:            processOutput,
+			Stderr:            processOutput,
 			ClientCount:       containerCount,
 			Env:               processEnv,
 			ClientLostTimeout: 30 * time.Second,
+			DependsOn:         dependsOn,
 		})
 	} else { // not Kubernetes
 		// The bootstrap-script gets parsed based on the operating system
@@ -377,22 +555,43 @@ func NewJobRunner(ctx context.Context, l logger.Logger, apiClient APIClient, con
 			return nil, fmt.Errorf("splitting bootstrap-script (%q) into tokens: %w", conf.AgentConfiguration.BootstrapScript, err)
 		}
 
+		// Run the job in its own cgroup to limit its memory and CPU
+		var cgroupPath string
+		if conf.AgentConfiguration.JobCgroupParent != "" {
+			r.cgroup = newJobCgroup(
+				conf.AgentConfiguration.JobCgroupParent,
+				r.conf.Job.ID,
+				conf.AgentConfiguration.JobMemoryLimit,
+				conf.AgentConfiguration.JobCPULimit,
+			)
+			cgroupPath = r.cgroup.dir
+		}
+
 		r.process = process.New(r.agentLogger, process.Config{
 			Path:              cmd[0],
 			Args:              cmd[1:],
 			Dir:               conf.AgentConfiguration.BuildPath,
 			Env:               processEnv,
 			PTY:               conf.AgentConfiguration.RunInPty,
-			Stdout:            r.jobLogs,
-			Stderr:            r.jobLogs,
+			PTYRows:           conf.AgentConfiguration.PTYRows,
+			PTYCols:           conf.AgentConfiguration.PTYCols,
+			Stdout:            processOutput,
+			Stderr:            processOutput,
 			InterruptSignal:   conf.CancelSignal,
 			SignalGracePeriod: conf.AgentConfiguration.SignalGracePeriod,
+			CgroupPath:        cgroupPath,
 		})
 	}
 
 	// Close the writer end of the pipe when the process finishes
 	go func() {
 		<-r.process.Done()
+		// Write the lines the collapser is holding before pw is closed
+		if r.outputCollapser != nil {
+			if err := r.outputCollapser.Flush(); err != nil {
+				r.agentLogger.Warn("[JobRunner] Error writing collapsed output: %v", err)
+			}
+		}
 		if err := pw.Close(); err != nil {
 			r.agentLogger.Error("%v", err)
 		}
@@ -417,6 +616,46 @@ func (r *JobRunner) normalizeVerificationBehavior(behavior string) (string, erro
 	}
 }
 
+// signalGracePeriod returns the signal grace period for the job. The job can
+// shorten the agent's with BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS, leaving its
+// pre-exit hooks longer to clean up before the cancel grace period ends, but
+// can't lengthen it.
+func (r *JobRunner) signalGracePeriod() time.Duration {
+	agentPeriod := r.conf.AgentConfiguration.SignalGracePeriod
+
+	value, ok := r.conf.Job.Env["BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"]
+	if !ok {
+		return agentPeriod
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		r.agentLogger.Warn("Ignoring the job's BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS=%q, as it isn't a number of seconds", value)
+		return agentPeriod
+	}
+
+	jobPeriod := time.Duration(seconds) * time.Second
+	if jobPeriod > agentPeriod {
+		r.agentLogger.Warn("Ignoring the job's BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS=%q, as it is longer than the agent's signal grace period of %v", value, agentPeriod)
+		return agentPeriod
+	}
+	return jobPeriod
+}
+
+// matchesProtectedEnv reports whether the job env var name matches one of the
+// agent's protected-env patterns. Names in ProtectedEnv are already ignored,
+// and names in buildkiteJobEnv are exempt.
+func matchesProtectedEnv(patterns []string, name string) bool {
+	if _, exists := ProtectedEnv[name]; exists {
+		return false
+	}
+	if _, exists := buildkiteJobEnv[name]; exists {
+		return false
+	}
+	matched, _ := redact.MatchAny(patterns, name)
+	return matched
+}
+
 // Creates the environment variables that will be used in the process and writes a flat environment file
 func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	// Create a clone of our jobs environment. We'll then set the
@@ -462,6 +701,9 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	if r.envJSONFile != nil {
 		env["BUILDKITE_ENV_JSON_FILE"] = r.envJSONFile.Name()
 	}
+	if r.metricsFile != "" {
+		env[metrics.JobFileEnv] = r.metricsFile
+	}
 
 	var ignoredEnv []string
 
@@ -472,6 +714,24 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 		}
 	}
 
+	// Also ignore any that match the agent's protected-env patterns, so the
+	// agent's own values (if any) are used instead. Variables Buildkite sets
+	// to describe the job are exempt, but any other BUILDKITE_ variable from
+	// the pipeline isn't.
+	if patterns := r.conf.AgentConfiguration.ProtectedEnv; len(patterns) > 0 {
+		for k := range r.conf.Job.Env {
+			if matchesProtectedEnv(patterns, k) {
+				delete(env, k)
+				ignoredEnv = append(ignoredEnv, k)
+			}
+		}
+	}
+
+	// Let meta-data commands namespace keys to this job's step
+	if stepKey := r.conf.Job.Env["BUILDKITE_STEP_KEY"]; stepKey != "" {
+		env["BUILDKITE_METADATA_STEP_SCOPE"] = "step:" + stepKey
+	}
+
 	cache := r.conf.Job.Step.Cache
 	if cache != nil && len(cache.Paths) > 0 {
 		env["BUILDKITE_AGENT_CACHE_PATHS"] = strings.Join(cache.Paths, ",")
@@ -482,6 +742,13 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 		env["BUILDKITE_IGNORED_ENV"] = strings.Join(ignoredEnv, ",")
 	}
 
+	// Restrict the job to the GPUs allocated to it
+	if r.conf.GPUs != nil {
+		for k, v := range r.conf.GPUs.env() {
+			env[k] = v
+		}
+	}
+
 	// Add the API configuration
 	apiConfig := r.apiClient.Config()
 	env["BUILDKITE_AGENT_ENDPOINT"] = apiConfig.Endpoint
@@ -511,12 +778,27 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	env["BUILDKITE_SOCKETS_PATH"] = r.conf.AgentConfiguration.SocketsPath
 	env["BUILDKITE_GIT_MIRRORS_PATH"] = r.conf.AgentConfiguration.GitMirrorsPath
 	env["BUILDKITE_GIT_MIRRORS_SKIP_UPDATE"] = fmt.Sprint(r.conf.AgentConfiguration.GitMirrorsSkipUpdate)
+	env["BUILDKITE_GIT_MIRRORS_WORKTREE"] = fmt.Sprint(r.conf.AgentConfiguration.GitMirrorsWorktree)
+	env["BUILDKITE_EPHEMERAL_BUILD_DIR"] = fmt.Sprint(r.conf.AgentConfiguration.EphemeralBuildDir)
+	env["BUILDKITE_JOB_LOG_FORMAT"] = r.conf.AgentConfiguration.JobLogFormat
 	env["BUILDKITE_HOOKS_PATH"] = r.conf.AgentConfiguration.HooksPath
 	env["BUILDKITE_ADDITIONAL_HOOKS_PATHS"] = strings.Join(r.conf.AgentConfiguration.AdditionalHooksPaths, ",")
 	env["BUILDKITE_PLUGINS_PATH"] = r.conf.AgentConfiguration.PluginsPath
+	env["BUILDKITE_ALLOWED_PLUGINS"] = strings.Join(r.conf.AgentConfiguration.PluginAllowlist.Strings(), ",")
+	env["BUILDKITE_ALLOWED_REPOSITORIES"] = joinPatterns(r.conf.AgentConfiguration.AllowedRepositories)
+	env["BUILDKITE_PLUGIN_LOCK_FILE"] = r.conf.AgentConfiguration.PluginLockFile
+	env["BUILDKITE_PLUGINS_ALLOW_DRIFT"] = fmt.Sprint(r.conf.AgentConfiguration.PluginsAllowDrift)
 	env["BUILDKITE_SSH_KEYSCAN"] = fmt.Sprint(r.conf.AgentConfiguration.SSHKeyscan)
 	env["BUILDKITE_GIT_SUBMODULES"] = fmt.Sprint(r.conf.AgentConfiguration.GitSubmodules)
 	env["BUILDKITE_COMMAND_EVAL"] = fmt.Sprint(r.conf.AgentConfiguration.CommandEval)
+	env["BUILDKITE_COMMAND_SANDBOX"] = r.conf.AgentConfiguration.CommandSandbox
+	env["BUILDKITE_COMMAND_SANDBOX_ALLOW_PATHS"] = strings.Join(r.conf.AgentConfiguration.CommandSandboxAllowPaths, ",")
+	env["BUILDKITE_COMMAND_ENV_ALLOW"] = strings.Join(r.conf.AgentConfiguration.CommandEnvAllow, ",")
+	env["BUILDKITE_COMMAND_ENV_DENY"] = strings.Join(r.conf.AgentConfiguration.CommandEnvDeny, ",")
+	env["BUILDKITE_SOFT_FAIL_EXIT_STATUS"] = strings.Join(r.conf.AgentConfiguration.SoftFailExitStatuses, ",")
+	env["BUILDKITE_SOFT_FAIL_CLASSIFIER"] = r.conf.AgentConfiguration.SoftFailClassifier
+	env["BUILDKITE_PROTECTED_ENV"] = strings.Join(r.conf.AgentConfiguration.ProtectedEnv, ",")
+	env["BUILDKITE_PROTECTED_ENV_ENFORCE"] = fmt.Sprint(r.conf.AgentConfiguration.ProtectedEnvEnforce)
 	env["BUILDKITE_PLUGINS_ENABLED"] = fmt.Sprint(r.conf.AgentConfiguration.PluginsEnabled)
 	env["BUILDKITE_LOCAL_HOOKS_ENABLED"] = fmt.Sprint(r.conf.AgentConfiguration.LocalHooksEnabled)
 	env["BUILDKITE_GIT_CHECKOUT_FLAGS"] = r.conf.AgentConfiguration.GitCheckoutFlags
@@ -525,12 +807,16 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	env["BUILDKITE_GIT_CLONE_MIRROR_FLAGS"] = r.conf.AgentConfiguration.GitCloneMirrorFlags
 	env["BUILDKITE_GIT_CLEAN_FLAGS"] = r.conf.AgentConfiguration.GitCleanFlags
 	env["BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT"] = strconv.Itoa(r.conf.AgentConfiguration.GitMirrorsLockTimeout)
+	env["BUILDKITE_CHECKOUT_RETRY_ATTEMPTS"] = strconv.Itoa(r.conf.AgentConfiguration.CheckoutRetryAttempts)
+	env["BUILDKITE_CHECKOUT_RETRY_BACKOFF"] = r.conf.AgentConfiguration.CheckoutRetryBackoff.String()
 	env["BUILDKITE_SHELL"] = r.conf.AgentConfiguration.Shell
 	env["BUILDKITE_AGENT_EXPERIMENT"] = strings.Join(experiments.Enabled(ctx), ",")
 	env["BUILDKITE_REDACTED_VARS"] = strings.Join(r.conf.AgentConfiguration.RedactedVars, ",")
 	env["BUILDKITE_STRICT_SINGLE_HOOKS"] = fmt.Sprint(r.conf.AgentConfiguration.StrictSingleHooks)
+	env["BUILDKITE_HOOK_INTERPRETERS"] = strings.Join(r.conf.AgentConfiguration.HookInterpreters, ",")
 	env["BUILDKITE_CANCEL_GRACE_PERIOD"] = strconv.Itoa(r.conf.AgentConfiguration.CancelGracePeriod)
-	env["BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"] = strconv.Itoa(int(r.conf.AgentConfiguration.SignalGracePeriod / time.Second))
+	env["BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"] = strconv.Itoa(int(r.signalGracePeriod() / time.Second))
+	env["BUILDKITE_HOOK_TIMEOUT"] = r.conf.AgentConfiguration.HookTimeout.String()
 	env["BUILDKITE_TRACE_CONTEXT_ENCODING"] = r.conf.AgentConfiguration.TraceContextEncoding
 
 	if r.conf.KubernetesExec {
@@ -541,11 +827,51 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 		env["BUILDKITE_NO_MULTIPART_ARTIFACT_UPLOAD"] = "true"
 	}
 
+	// Pass artifact encryption details through, so artifact uploads and
+	// downloads within the job are encrypted and decrypted
+	if r.conf.AgentConfiguration.ArtifactEncryptionKeyFile != "" {
+		env["BUILDKITE_ARTIFACT_ENCRYPTION_KEY_FILE"] = r.conf.AgentConfiguration.ArtifactEncryptionKeyFile
+	}
+
+	if r.conf.AgentConfiguration.ArtifactEncryptionAWSKMSKey != "" {
+		env["BUILDKITE_ARTIFACT_ENCRYPTION_AWS_KMS_KEY"] = r.conf.AgentConfiguration.ArtifactEncryptionAWSKMSKey
+	}
+
 	// propagate CancelSignal to bootstrap, unless it's the default SIGTERM
 	if r.conf.CancelSignal != process.SIGTERM {
 		env["BUILDKITE_CANCEL_SIGNAL"] = r.conf.CancelSignal.String()
 	}
 
+	// Only set if configured, so that pipelines can configure submodules
+	// themselves otherwise
+	if len(r.conf.AgentConfiguration.GitSubmoduleCloneFlags) > 0 {
+		env["BUILDKITE_GIT_SUBMODULE_CLONE_FLAGS"] = strings.Join(r.conf.AgentConfiguration.GitSubmoduleCloneFlags, ",")
+	}
+	if len(r.conf.AgentConfiguration.GitSubmoduleCredentials) > 0 {
+		env["BUILDKITE_GIT_SUBMODULE_CREDENTIALS"] = strings.Join(r.conf.AgentConfiguration.GitSubmoduleCredentials, ",")
+	}
+
+	// Only set if configured, so that pipelines can choose their own VCS
+	if r.conf.AgentConfiguration.VCS != "" {
+		env["BUILDKITE_VCS"] = r.conf.AgentConfiguration.VCS
+	}
+
+	// Only set if configured, so that pipelines can use their own bundles
+	if r.conf.AgentConfiguration.GitCloneBundle != "" {
+		env["BUILDKITE_GIT_CLONE_BUNDLE"] = r.conf.AgentConfiguration.GitCloneBundle
+	}
+
+	// Only set if configured, so that pipelines can enable phase timings
+	// for themselves otherwise
+	if r.conf.AgentConfiguration.PhaseTimings != "" {
+		env["BUILDKITE_PHASE_TIMINGS"] = r.conf.AgentConfiguration.PhaseTimings
+	}
+
+	// Likewise, pipelines can ask for a diagnostics bundle themselves
+	if r.conf.AgentConfiguration.DiagnosticsBundle {
+		env["BUILDKITE_DIAGNOSTICS_BUNDLE"] = "true"
+	}
+
 	// Whether to enable profiling in the bootstrap
 	if r.conf.AgentConfiguration.Profile != "" {
 		env["BUILDKITE_AGENT_PROFILE"] = r.conf.AgentConfiguration.Profile
@@ -556,6 +882,12 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	if !r.conf.AgentConfiguration.RunInPty {
 		env["BUILDKITE_PTY"] = "false"
 	}
+	if r.conf.AgentConfiguration.PTYRows != 0 {
+		env["BUILDKITE_PTY_ROWS"] = strconv.Itoa(int(r.conf.AgentConfiguration.PTYRows))
+	}
+	if r.conf.AgentConfiguration.PTYCols != 0 {
+		env["BUILDKITE_PTY_COLS"] = strconv.Itoa(int(r.conf.AgentConfiguration.PTYCols))
+	}
 
 	// pass through the KMS key ID for signing
 	if r.conf.AgentConfiguration.SigningAWSKMSKey != "" {
@@ -589,6 +921,7 @@ func (r *JobRunner) createEnvironment(ctx context.Context) ([]string, error) {
 	if r.conf.AgentConfiguration.TracingBackend != "" {
 		env["BUILDKITE_TRACING_BACKEND"] = r.conf.AgentConfiguration.TracingBackend
 		env["BUILDKITE_TRACING_SERVICE_NAME"] = r.conf.AgentConfiguration.TracingServiceName
+		env["BUILDKITE_TRACING_HOOK_OUTPUT_LIMIT"] = strconv.Itoa(r.conf.AgentConfiguration.TracingHookOutputLimit)
 	}
 
 	env["BUILDKITE_AGENT_DISABLE_WARNINGS_FOR"] = strings.Join(r.conf.AgentConfiguration.DisableWarningsFor, ",")
@@ -5,7 +5,9 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
 )
 
@@ -60,3 +62,68 @@ func TestValidateJobValue(t *testing.T) {
 		}
 	}
 }
+
+func TestJobRunnerSignalGracePeriod(t *testing.T) {
+	tests := []struct {
+		name   string
+		jobEnv map[string]string
+		want   time.Duration
+	}{
+		{
+			name: "not set by the job",
+			want: 9 * time.Second,
+		},
+		{
+			name:   "shortened by the job",
+			jobEnv: map[string]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS": "2"},
+			want:   2 * time.Second,
+		},
+		{
+			name:   "lengthened by the job",
+			jobEnv: map[string]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS": "60"},
+			want:   9 * time.Second,
+		},
+		{
+			name:   "not a number",
+			jobEnv: map[string]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS": "soon"},
+			want:   9 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &JobRunner{
+				agentLogger: logger.Discard,
+				conf: JobRunnerConfig{
+					Job:                &api.Job{Env: test.jobEnv},
+					AgentConfiguration: AgentConfiguration{SignalGracePeriod: 9 * time.Second},
+				},
+			}
+			if got := r.signalGracePeriod(); got != test.want {
+				t.Errorf("r.signalGracePeriod() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchesProtectedEnv(t *testing.T) {
+	patterns := []string{"*_TOKEN", "BUILDKITE_*"}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "GITHUB_TOKEN", want: true},
+		{name: "BUILDKITE_S3_ACCESS_URL", want: true},
+		{name: "BUILDKITE_BRANCH", want: false},
+		{name: "BUILDKITE_JOB_ID", want: false},
+		{name: "BUILDKITE_AGENT_ACCESS_TOKEN", want: false}, // in ProtectedEnv
+		{name: "PATH", want: false},
+	}
+
+	for _, test := range tests {
+		if got := matchesProtectedEnv(patterns, test.name); got != test.want {
+			t.Errorf("matchesProtectedEnv(%q, %q) = %t, want %t", patterns, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// Formats of the timestamps prepended to each line of job output by
+// --timestamp-lines
+const (
+	// TimestampLinesFormatRFC3339 is the time the line was written, to the
+	// second, such as "[2024-01-02T03:04:05Z]".
+	TimestampLinesFormatRFC3339 = "rfc3339"
+
+	// TimestampLinesFormatDelta is the time the line was written, to the
+	// millisecond, followed by how many seconds after the job started and
+	// after the previous line it was written, such as
+	// "[2024-01-02T03:04:05.678Z +62.345s +0.012s]".
+	TimestampLinesFormatDelta = "delta"
+)
+
+// TimestampLinesFormats lists the formats of --timestamp-lines.
+var TimestampLinesFormats = []string{TimestampLinesFormatRFC3339, TimestampLinesFormatDelta}
+
+// lineTimestamper formats the timestamps prepended to lines of job output.
+// Deltas are measured with the monotonic clock, so changes to the system
+// clock don't affect them.
+type lineTimestamper struct {
+	format   string
+	start    time.Time
+	previous time.Time
+}
+
+func newLineTimestamper(format string, start time.Time) *lineTimestamper {
+	return &lineTimestamper{format: format, start: start, previous: start}
+}
+
+// prefix returns the timestamp for a line written at now.
+func (t *lineTimestamper) prefix(now time.Time) string {
+	switch t.format {
+	case TimestampLinesFormatDelta:
+		sinceStart, sincePrevious := now.Sub(t.start), now.Sub(t.previous)
+		t.previous = now
+		return fmt.Sprintf("[%s +%.3fs +%.3fs] ",
+			now.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+			sinceStart.Seconds(),
+			sincePrevious.Seconds(),
+		)
+
+	default:
+		return "[" + now.UTC().Format(time.RFC3339) + "] "
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineTimestamperRFC3339(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 678_000_000, time.UTC)
+	ts := newLineTimestamper(TimestampLinesFormatRFC3339, start)
+
+	if got, want := ts.prefix(start.Add(time.Minute)), "[2024-01-02T03:05:05Z] "; got != want {
+		t.Errorf("ts.prefix(start+1m) = %q, want %q", got, want)
+	}
+}
+
+func TestLineTimestamperDelta(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 678_000_000, time.UTC)
+	ts := newLineTimestamper(TimestampLinesFormatDelta, start)
+
+	tests := []struct {
+		after time.Duration
+		want  string
+	}{
+		{after: 62345 * time.Millisecond, want: "[2024-01-02T03:05:08.023Z +62.345s +62.345s] "},
+		{after: 62357 * time.Millisecond, want: "[2024-01-02T03:05:08.035Z +62.357s +0.012s] "},
+		{after: 62357 * time.Millisecond, want: "[2024-01-02T03:05:08.035Z +62.357s +0.000s] "},
+	}
+	for _, test := range tests {
+		if got := ts.prefix(start.Add(test.after)); got != test.want {
+			t.Errorf("ts.prefix(start+%v) = %q, want %q", test.after, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/core"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+	"github.com/gofrs/flock"
+)
+
+// DefaultJobLogSpoolMaxSize is the default amount of each job log kept in the
+// job log spool.
+const DefaultJobLogSpoolMaxSize = 1024 * 1024 // 1 MiB
+
+// Files within each job's spool directory.
+const (
+	spoolMetaFile    = "job.json"
+	spoolLogFile     = "log"
+	spoolOldLogFile  = "log.1"
+	spoolLockFile    = "lock"
+	spoolFileMode    = 0o600
+	spoolDirFileMode = 0o700
+)
+
+// jobLogSpoolMeta describes the job that a spool belongs to, and how to upload
+// its log to it.
+type jobLogSpoolMeta struct {
+	JobID             string `json:"job_id"`
+	Endpoint          string `json:"endpoint"`
+	Token             string `json:"token"`
+	MaxChunkSizeBytes uint64 `json:"max_chunk_size_bytes"`
+
+	// The number of bytes of the job log before the current log file.
+	Offset uint64 `json:"offset"`
+}
+
+// jobLogSpool keeps a copy of the tail of a job log on disk while the job
+// runs, so that if the agent crashes or the host is terminated, the next agent
+// to start can upload it with RecoverJobLogSpools. The spool is split across
+// two files of up to half the maximum size each, and the older file is
+// discarded when the newer one fills up.
+//
+// The spool is locked while the job runs, so that agents sharing the spool
+// path don't recover each other's running jobs.
+type jobLogSpool struct {
+	logger  logger.Logger
+	dir     string
+	maxSize uint64
+	lock    *flock.Flock
+
+	mu   sync.Mutex
+	meta jobLogSpoolMeta
+	log  *os.File
+	size uint64 // the size of the current log file
+	err  error  // the first error writing to the spool, after which it stops
+}
+
+// newJobLogSpool creates a spool for the job in a directory within root.
+func newJobLogSpool(l logger.Logger, root string, maxSize uint64, job *api.Job, conf api.Config) (*jobLogSpool, error) {
+	dir := filepath.Join(root, job.ID)
+	if err := os.MkdirAll(dir, spoolDirFileMode); err != nil {
+		return nil, fmt.Errorf("creating job log spool directory: %w", err)
+	}
+
+	lock := flock.New(filepath.Join(dir, spoolLockFile))
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("locking job log spool: %w", err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("job log spool %s is locked by another agent", dir)
+	}
+
+	s := &jobLogSpool{
+		logger:  l,
+		dir:     dir,
+		maxSize: max(maxSize, 2),
+		lock:    lock,
+		meta: jobLogSpoolMeta{
+			JobID:             job.ID,
+			Endpoint:          conf.Endpoint,
+			Token:             conf.Token,
+			MaxChunkSizeBytes: job.ChunksMaxSizeBytes,
+		},
+	}
+
+	// A spool left behind by an earlier attempt at the same job isn't useful.
+	if err := os.Remove(filepath.Join(dir, spoolOldLogFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.close()
+		return nil, err
+	}
+	if err := s.openLog(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Write appends p to the spool. It never fails, so that problems with the
+// spool don't interrupt the job log. Instead, the first error is logged and
+// the spool stops.
+func (s *jobLogSpool) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil || s.log == nil {
+		return len(p), nil
+	}
+	if err := s.write(p); err != nil {
+		s.err = err
+		s.logger.Warn("Couldn't write to the job log spool in %s, so the job log won't be recovered if the agent crashes: %v", s.dir, err)
+	}
+	return len(p), nil
+}
+
+func (s *jobLogSpool) write(p []byte) error {
+	half := s.maxSize / 2
+
+	// If p doesn't fit in the current log file, start a new one. If p
+	// doesn't fit in a new one by itself, only its tail is kept.
+	if s.size+uint64(len(p)) > half {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		if excess := uint64(len(p)) - min(half, uint64(len(p))); excess > 0 {
+			if err := os.Remove(filepath.Join(s.dir, spoolOldLogFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			s.meta.Offset += excess
+			p = p[excess:]
+			if err := s.writeMeta(); err != nil {
+				return err
+			}
+		}
+	}
+
+	n, err := s.log.Write(p)
+	s.size += uint64(n)
+	return err
+}
+
+// rotate replaces the older log file with the current one, and starts a new
+// current log file.
+func (s *jobLogSpool) rotate() error {
+	if err := s.log.Close(); err != nil {
+		return err
+	}
+	s.log = nil
+	if err := os.Rename(filepath.Join(s.dir, spoolLogFile), filepath.Join(s.dir, spoolOldLogFile)); err != nil {
+		return err
+	}
+	s.meta.Offset += s.size
+	s.size = 0
+	return s.openLog()
+}
+
+// openLog creates a new, empty, current log file.
+func (s *jobLogSpool) openLog() error {
+	if err := s.writeMeta(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, spoolLogFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, spoolFileMode)
+	if err != nil {
+		return err
+	}
+	s.log = f
+	return nil
+}
+
+func (s *jobLogSpool) writeMeta() error {
+	data, err := json.Marshal(s.meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, spoolMetaFile), data, spoolFileMode)
+}
+
+// Remove discards the spool once the job log has been uploaded normally.
+func (s *jobLogSpool) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.log != nil {
+		s.log.Close() //nolint:errcheck // the spool is about to be removed
+		s.log = nil
+	}
+
+	// Remove the job details while the spool is still locked, so that it
+	// can't be mistaken for a crashed job's spool once it's unlocked.
+	if err := os.Remove(filepath.Join(s.dir, spoolMetaFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.close()
+		return err
+	}
+	s.close()
+	return os.RemoveAll(s.dir)
+}
+
+func (s *jobLogSpool) close() {
+	if s.log != nil {
+		s.log.Close() //nolint:errcheck // the spool is about to be removed
+		s.log = nil
+	}
+	s.lock.Unlock() //nolint:errcheck // best-effort unlock
+}
+
+// RecoverJobLogSpools uploads the log tails of jobs whose agents stopped
+// without finishing them, which are found in spool directories within root,
+// followed by a message explaining what happened. The conf is used to
+// configure the API client for each job, with the endpoint and token replaced
+// by the job's own. Spools are removed once they've been recovered, or if they
+// can't be.
+func RecoverJobLogSpools(ctx context.Context, l logger.Logger, root string, conf api.Config) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			l.Warn("Couldn't read the job log spool path %s: %v", root, err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+
+		// A spool that's still locked belongs to a job that's still running.
+		lock := flock.New(filepath.Join(dir, spoolLockFile))
+		locked, err := lock.TryLock()
+		if err != nil || !locked {
+			continue
+		}
+
+		// A spool without job details is being created or removed.
+		if _, err := os.Stat(filepath.Join(dir, spoolMetaFile)); err != nil {
+			lock.Unlock() //nolint:errcheck // best-effort unlock
+			continue
+		}
+
+		if err := recoverJobLogSpool(ctx, l, dir, conf); err != nil {
+			l.Warn("Couldn't recover the job log spool in %s: %v", dir, err)
+		}
+		lock.Unlock() //nolint:errcheck // the spool is removed next
+		if err := os.RemoveAll(dir); err != nil {
+			l.Warn("Couldn't remove the job log spool in %s: %v", dir, err)
+		}
+	}
+}
+
+func recoverJobLogSpool(ctx context.Context, l logger.Logger, dir string, conf api.Config) error {
+	data, err := os.ReadFile(filepath.Join(dir, spoolMetaFile))
+	if err != nil {
+		return err
+	}
+	var meta jobLogSpoolMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("parsing %s: %w", spoolMetaFile, err)
+	}
+
+	tail, err := readSpoolTail(dir)
+	if err != nil {
+		return err
+	}
+	total := meta.Offset + uint64(len(tail))
+
+	l.Info("Uploading the last %s of the log for job %s, which was interrupted when the agent stopped unexpectedly", humanize.IBytes(uint64(len(tail))), meta.JobID)
+
+	msg := []byte("\n+++ ⚠️ The agent running this job stopped unexpectedly\n")
+	msg = fmt.Appendf(msg, "The job log may be incomplete. These are the last %s of it, which were recovered from %s when an agent restarted:\n", humanize.IBytes(uint64(len(tail))), dir)
+	msg = append(msg, tail...)
+
+	conf.Endpoint = meta.Endpoint
+	conf.Token = meta.Token
+	client := &core.Client{APIClient: api.NewClient(l, conf), Logger: l}
+
+	// Sequence numbers and offsets must follow those already uploaded. Every
+	// uploaded chunk has at least one byte, so there can't have been more
+	// chunks than bytes.
+	return uploadRecoveredLog(ctx, client, meta.JobID, msg, total, total+1, meta.MaxChunkSizeBytes)
+}
+
+// readSpoolTail returns the contents of the older log file followed by the
+// current one.
+func readSpoolTail(dir string) ([]byte, error) {
+	var tail []byte
+	for _, name := range []string{spoolOldLogFile, spoolLogFile} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tail = append(tail, data...)
+	}
+	return tail, nil
+}
+
+// uploadRecoveredLog uploads data as chunks of at most maxChunkSize bytes,
+// starting at the given offset and sequence number.
+func uploadRecoveredLog(ctx context.Context, client *core.Client, jobID string, data []byte, offset, sequence, maxChunkSize uint64) error {
+	if maxChunkSize == 0 {
+		maxChunkSize = uint64(len(data))
+	}
+	for len(data) > 0 {
+		size := min(maxChunkSize, uint64(len(data)))
+		chunk := &api.Chunk{
+			Data:     data[:size],
+			Sequence: sequence,
+			Offset:   offset,
+			Size:     size,
+		}
+		if err := client.UploadChunk(ctx, jobID, chunk); err != nil {
+			return err
+		}
+		data = data[size:]
+		offset += size
+		sequence++
+	}
+	return nil
+}
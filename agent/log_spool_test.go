@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// crash leaves the spool behind as if the agent had stopped unexpectedly.
+func (s *jobLogSpool) crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+}
+
+func TestJobLogSpoolKeepsTail(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	job := &api.Job{ID: "my-job", ChunksMaxSizeBytes: 4}
+	spool, err := newJobLogSpool(logger.Discard, root, 8, job, api.Config{Endpoint: "https://example.com/v3", Token: "llamas"})
+	if err != nil {
+		t.Fatalf("newJobLogSpool(...) error = %v", err)
+	}
+	defer spool.crash()
+
+	for _, s := range []string{"abc", "def", "ghi", "j", "klmnopq"} {
+		if n, err := spool.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("spool.Write(%q) = (%d, %v), want (%d, nil)", s, n, err, len(s))
+		}
+	}
+
+	dir := filepath.Join(root, job.ID)
+	tail, err := readSpoolTail(dir)
+	if err != nil {
+		t.Fatalf("readSpoolTail(%q) error = %v", dir, err)
+	}
+	// Each file holds up to half the spool, and a write too big for one file
+	// only keeps as much as fits.
+	if got, want := string(tail), "nopq"; got != want {
+		t.Errorf("readSpoolTail(%q) = %q, want %q", dir, got, want)
+	}
+	if got, want := spool.meta.Offset, uint64(13); got != want {
+		t.Errorf("spool.meta.Offset = %d, want %d", got, want)
+	}
+}
+
+func TestJobLogSpoolRemove(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	spool, err := newJobLogSpool(logger.Discard, root, 1024, &api.Job{ID: "my-job"}, api.Config{})
+	if err != nil {
+		t.Fatalf("newJobLogSpool(...) error = %v", err)
+	}
+	if _, err := spool.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("spool.Write(...) error = %v", err)
+	}
+	if err := spool.Remove(); err != nil {
+		t.Fatalf("spool.Remove() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) error = %v", root, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("os.ReadDir(%q) = %v, want no entries", root, entries)
+	}
+}
+
+func TestRecoverJobLogSpools(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/jobs/crashed-job/chunks" {
+			t.Errorf("Unknown endpoint %s %s", req.Method, req.URL.Path)
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		if got, want := req.Header.Get("Authorization"), "Token job-token"; got != want {
+			t.Errorf("req.Header.Get(Authorization) = %q, want %q", got, want)
+		}
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader(req.Body) error = %v", err)
+			return
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Errorf("io.ReadAll(zr) error = %v", err)
+			return
+		}
+		mu.Lock()
+		got = append(got, req.URL.RawQuery+" "+string(data))
+		mu.Unlock()
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	conf := api.Config{Endpoint: server.URL, Token: "job-token"}
+
+	crashed, err := newJobLogSpool(logger.Discard, root, 1024, &api.Job{ID: "crashed-job", ChunksMaxSizeBytes: 1024}, conf)
+	if err != nil {
+		t.Fatalf("newJobLogSpool(...) error = %v", err)
+	}
+	if _, err := crashed.Write([]byte("~~~ Running commands\nhello\n")); err != nil {
+		t.Fatalf("crashed.Write(...) error = %v", err)
+	}
+	crashed.crash()
+
+	running, err := newJobLogSpool(logger.Discard, root, 1024, &api.Job{ID: "running-job"}, conf)
+	if err != nil {
+		t.Fatalf("newJobLogSpool(...) error = %v", err)
+	}
+	defer running.Remove() //nolint:errcheck // test cleanup
+
+	RecoverJobLogSpools(context.Background(), logger.Discard, root, api.Config{Endpoint: "https://example.com/v3", Token: "agent-token"})
+
+	crashedDir := filepath.Join(root, "crashed-job")
+	msg := "\n+++ ⚠️ The agent running this job stopped unexpectedly\n" +
+		"The job log may be incomplete. These are the last 27 B of it, which were recovered from " + crashedDir + " when an agent restarted:\n" +
+		"~~~ Running commands\nhello\n"
+	want := []string{
+		// The 27 bytes in the spool might have been uploaded already, so
+		// the recovered log follows them.
+		"sequence=28&offset=27&size=" + strconv.Itoa(len(msg)) + " " + msg,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("uploaded chunks diff (-got +want):\n%s", diff)
+	}
+
+	if _, err := os.Stat(crashedDir); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) error = %v, want not exist", crashedDir, err)
+	}
+	runningDir := filepath.Join(root, "running-job")
+	if _, err := os.Stat(filepath.Join(runningDir, spoolMetaFile)); err != nil {
+		t.Errorf("os.Stat(%q) error = %v, want the running job's spool to be left alone", runningDir, err)
+	}
+}
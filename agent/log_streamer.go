@@ -6,15 +6,24 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/status"
 	"github.com/dustin/go-humanize"
+	"golang.org/x/time/rate"
 )
 
 const defaultLogMaxSize = 1024 * 1024 * 1024 // 1 GiB
 
+// Chunks are resized so that each upload takes about targetChunkUploadTime,
+// but are never made smaller than minChunkSizeBytes.
+const (
+	targetChunkUploadTime = 1 * time.Second
+	minChunkSizeBytes     = 16 * 1024 // 16 KiB
+)
+
 // Returned from Process after Stop has been called.
 var errStreamerStopped = errors.New("streamer stopped")
 
@@ -28,6 +37,10 @@ type LogStreamerConfig struct {
 
 	// The maximum size of the log
 	MaxSizeBytes uint64
+
+	// The maximum rate at which to upload the log, in bytes per second.
+	// Zero means no limit.
+	MaxBandwidthBytes uint64
 }
 
 // LogStreamer divides job log output into chunks (Process), and log streamer
@@ -52,6 +65,12 @@ type LogStreamer struct {
 	// Total size in bytes of the log
 	bytes uint64
 
+	// The size of the next chunk, which adapts to how long uploads take
+	chunkSize atomic.Uint64
+
+	// Limits the upload bandwidth, if MaxBandwidthBytes is set
+	limiter *rate.Limiter
+
 	// Each chunk is assigned an order
 	order uint64
 
@@ -92,6 +111,11 @@ func (ls *LogStreamer) Start(ctx context.Context) error {
 		ls.conf.MaxSizeBytes = defaultLogMaxSize
 	}
 
+	ls.chunkSize.Store(ls.maxChunkSize())
+	if ls.conf.MaxBandwidthBytes > 0 {
+		ls.limiter = rate.NewLimiter(rate.Limit(ls.conf.MaxBandwidthBytes), int(ls.conf.MaxChunkSizeBytes))
+	}
+
 	ls.workerWG.Add(ls.conf.Concurrency)
 	for i := range ls.conf.Concurrency {
 		go ls.worker(ctx, i)
@@ -130,8 +154,11 @@ func (ls *LogStreamer) Process(ctx context.Context, output []byte) error {
 			//return fmt.Errorf("%w (%d > %d)", errLogExceededMaxSize, ls.bytes, ls.conf.MaxSizeBytes)
 		}
 
-		// The next chunk will be up to MaxChunkSizeBytes in size.
-		size := ls.conf.MaxChunkSizeBytes
+		// The next chunk will be up to the current chunk size.
+		size := ls.chunkSize.Load()
+		if size == 0 { // not started
+			size = ls.conf.MaxChunkSizeBytes
+		}
 		if lenout := uint64(len(output)); size > lenout {
 			size = lenout
 		}
@@ -201,14 +228,57 @@ func (ls *LogStreamer) worker(ctx context.Context, id int) {
 			return
 		}
 
+		// Wait for enough bandwidth to upload the chunk
+		if ls.limiter != nil {
+			setStat("🐢 Waiting for upload bandwidth")
+			if err := ls.limiter.WaitN(ctx, int(chunk.Size)); err != nil {
+				return
+			}
+		}
+
 		setStat("📨 Uploading chunk")
 
 		// Upload the chunk
+		start := time.Now()
 		err := ls.callback(ctx, chunk)
 		if err != nil {
 			atomic.AddInt32(&ls.chunksFailedCount, 1)
 
 			ls.logger.Error("Giving up on uploading chunk %d, this will result in only a partial build log on Buildkite", chunk.Sequence)
+			continue
 		}
+		ls.adaptChunkSize(chunk.Size, time.Since(start))
+	}
+}
+
+// maxChunkSize returns the largest chunk to upload. With a bandwidth limit,
+// chunks are no bigger than can be uploaded in targetChunkUploadTime.
+func (ls *LogStreamer) maxChunkSize() uint64 {
+	size := ls.conf.MaxChunkSizeBytes
+	if ls.conf.MaxBandwidthBytes > 0 {
+		size = min(size, uint64(float64(ls.conf.MaxBandwidthBytes)*targetChunkUploadTime.Seconds()))
+	}
+	return max(size, min(minChunkSizeBytes, ls.conf.MaxChunkSizeBytes))
+}
+
+// adaptChunkSize resizes future chunks so that each upload takes about
+// targetChunkUploadTime, based on how long the upload of a chunk took. On slow
+// networks, smaller chunks leave room between uploads for other requests, such
+// as heartbeats and artifact uploads.
+func (ls *LogStreamer) adaptChunkSize(size uint64, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
 	}
+	current := ls.chunkSize.Load()
+	estimate := uint64(float64(size) * float64(targetChunkUploadTime) / float64(elapsed))
+
+	// Small chunks say little about how fast the network is, so a quick
+	// upload only ever grows the chunk size.
+	next := max(current, estimate)
+	if elapsed > targetChunkUploadTime {
+		next = min(current, estimate)
+	}
+
+	next = min(max(next, minChunkSizeBytes), ls.maxChunkSize())
+	ls.chunkSize.Store(next)
 }
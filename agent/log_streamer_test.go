@@ -7,6 +7,7 @@ import (
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/logger"
@@ -94,3 +95,98 @@ func TestLogStreamer(t *testing.T) {
 		t.Errorf("after Stop: LogStreamer.Process(ctx, %q) err = %v, want %v", input, err, errStreamerStopped)
 	}
 }
+
+func TestLogStreamerMaxBandwidth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var got []string
+	callback := func(ctx context.Context, chunk *api.Chunk) error {
+		mu.Lock()
+		got = append(got, string(chunk.Data))
+		mu.Unlock()
+		return nil
+	}
+
+	ls := NewLogStreamer(logger.Discard, callback, LogStreamerConfig{
+		Concurrency:       3,
+		MaxChunkSizeBytes: 10,
+		MaxBandwidthBytes: 100,
+	})
+
+	if err := ls.Start(ctx); err != nil {
+		t.Fatalf("LogStreamer.Start(ctx) = %v", err)
+	}
+
+	start := time.Now()
+	input := "0123456789abcdefghijklmnopqrstuvwxyz!@#$%^&*()" // 46 bytes
+	if err := ls.Process(ctx, []byte(input)); err != nil {
+		t.Errorf("LogStreamer.Process(ctx, %q) = %v", input, err)
+	}
+	ls.Stop()
+
+	// The first 10 bytes can be sent straight away, but the other 36 bytes
+	// take at least 0.36s at 100 bytes per second.
+	if elapsed, want := time.Since(start), 350*time.Millisecond; elapsed < want {
+		t.Errorf("uploading %d bytes at 100 bytes/s took %v, want at least %v", len(input), elapsed, want)
+	}
+
+	sort.Strings(got)
+	want := []string{"%^&*()", "0123456789", "abcdefghij", "klmnopqrst", "uvwxyz!@#$"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("LogStreamer chunks diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestLogStreamerAdaptChunkSize(t *testing.T) {
+	t.Parallel()
+
+	ls := NewLogStreamer(logger.Discard, nil, LogStreamerConfig{
+		MaxChunkSizeBytes: 1024 * 1024,
+	})
+	ls.chunkSize.Store(ls.maxChunkSize())
+
+	for _, test := range []struct {
+		name    string
+		size    uint64
+		elapsed time.Duration
+		want    uint64
+	}{
+		{
+			name:    "slow upload shrinks chunks",
+			size:    1024 * 1024,
+			elapsed: 4 * time.Second,
+			want:    256 * 1024,
+		},
+		{
+			name:    "quick upload of a small chunk leaves chunks alone",
+			size:    100,
+			elapsed: time.Millisecond,
+			want:    256 * 1024,
+		},
+		{
+			name:    "quick upload of a big chunk grows chunks",
+			size:    256 * 1024,
+			elapsed: 500 * time.Millisecond,
+			want:    512 * 1024,
+		},
+		{
+			name:    "chunks don't grow past the maximum",
+			size:    512 * 1024,
+			elapsed: 10 * time.Millisecond,
+			want:    1024 * 1024,
+		},
+		{
+			name:    "chunks don't shrink past the minimum",
+			size:    1024 * 1024,
+			elapsed: time.Hour,
+			want:    minChunkSizeBytes,
+		},
+	} {
+		ls.adaptChunkSize(test.size, test.elapsed)
+		if got := ls.chunkSize.Load(); got != test.want {
+			t.Errorf("%s: after adaptChunkSize(%d, %v), chunkSize = %d, want %d", test.name, test.size, test.elapsed, got, test.want)
+		}
+	}
+}
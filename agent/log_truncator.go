@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// Once the job log is truncated, up to this many of the most recent lines
+	// (and no more than truncatedLogTailBytes) are kept to show at the end.
+	truncatedLogTailLines = 1000
+	truncatedLogTailBytes = 1024 * 1024 // 1 MiB
+
+	// Lines longer than this are split while the log is truncated, so that
+	// a runaway line can't use unbounded memory.
+	truncatedLogMaxLineBytes = 64 * 1024 // 64 KiB
+)
+
+// bkTimestampRE matches the timestamp that prefixes each line of the job log
+// when ANSI timestamps are enabled.
+var bkTimestampRE = regexp.MustCompile("^\x1b_bk;t=\\d+\x07")
+
+// logTruncator passes job log output through to w until max bytes have been
+// written. After that, it writes a notice, and then only group headers, while
+// keeping a rolling tail of the rest of the log. The tail is written by Flush,
+// at the end of the job.
+type logTruncator struct {
+	w   io.Writer
+	max uint64
+
+	mu        sync.Mutex
+	written   uint64   // bytes passed through before truncation
+	midLine   bool     // whether the bytes passed through end partway through a line
+	truncated bool     // whether the limit has been reached
+	partial   []byte   // an incomplete line, once truncated
+	tail      [][]byte // the most recent lines that weren't written
+	tailBytes int      // the total size of tail
+	omitted   uint64   // the bytes that weren't written, or kept in the tail
+}
+
+func newLogTruncator(w io.Writer, max uint64) *logTruncator {
+	return &logTruncator{w: w, max: max}
+}
+
+// Write implements io.Writer.
+func (t *logTruncator) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(p)
+	if !t.truncated {
+		if remaining := t.max - t.written; uint64(len(p)) <= remaining {
+			t.written += uint64(len(p))
+			if len(p) > 0 {
+				t.midLine = p[len(p)-1] != '\n'
+			}
+			return t.w.Write(p)
+		}
+
+		// Write up to the end of the last line that fits, so the notice
+		// starts on a line of its own.
+		remaining := p[:t.max-t.written]
+		cut := bytes.LastIndexByte(remaining, '\n') + 1
+		if _, err := t.w.Write(p[:cut]); err != nil {
+			return 0, err
+		}
+		p = p[cut:]
+		t.truncated = true
+
+		notice := ""
+		if t.midLine && cut == 0 {
+			notice = "\n"
+		}
+		notice += fmt.Sprintf("+++ ⚠️ The job log has exceeded the maximum size of %s\n"+
+			"Only group headers will be shown from here on, followed by the end of the log when the job finishes.\n",
+			humanize.IBytes(t.max))
+		if _, err := io.WriteString(t.w, notice); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.partial = append(t.partial, p...)
+			if len(t.partial) >= truncatedLogMaxLineBytes {
+				if err := t.line(append(t.partial, '\n')); err != nil {
+					return 0, err
+				}
+				t.partial = nil
+			}
+			break
+		}
+		line := append(t.partial, p[:i+1]...)
+		t.partial = nil
+		p = p[i+1:]
+		if err := t.line(line); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// line handles a complete line once the log is truncated.
+func (t *logTruncator) line(line []byte) error {
+	if isLogHeader(line) {
+		_, err := t.w.Write(line)
+		return err
+	}
+
+	t.tail = append(t.tail, bytes.Clone(line))
+	t.tailBytes += len(line)
+	for len(t.tail) > truncatedLogTailLines || t.tailBytes > truncatedLogTailBytes {
+		t.omitted += uint64(len(t.tail[0]))
+		t.tailBytes -= len(t.tail[0])
+		t.tail[0] = nil
+		t.tail = t.tail[1:]
+	}
+	return nil
+}
+
+// Flush writes the tail of the log, if it was truncated. It should be called
+// once the job has finished.
+func (t *logTruncator) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.truncated {
+		return nil
+	}
+	if len(t.partial) > 0 {
+		if err := t.line(append(t.partial, '\n')); err != nil {
+			return err
+		}
+		t.partial = nil
+	}
+	if len(t.tail) == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(t.w, "+++ ⚠️ End of the job log (%s omitted)\n", humanize.IBytes(t.omitted)); err != nil {
+		return err
+	}
+	for _, line := range t.tail {
+		if _, err := t.w.Write(line); err != nil {
+			return err
+		}
+	}
+	t.tail, t.tailBytes, t.omitted = nil, 0, 0
+	return nil
+}
+
+// isLogHeader reports whether line is a group header (or a header expansion),
+// ignoring any timestamp or colours.
+func isLogHeader(line []byte) bool {
+	s := bkTimestampRE.ReplaceAllString(string(line), "")
+	s = ansiColourRE.ReplaceAllString(s, "")
+	return headerRE.MatchString(s) || headerExpansionRE.MatchString(s)
+}
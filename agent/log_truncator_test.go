@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogTruncator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		max    uint64
+		writes []string
+		want   string
+	}{
+		{
+			name:   "under the limit",
+			max:    100,
+			writes: []string{"~~~ Running commands\n", "hello\n", "world\n"},
+			want:   "~~~ Running commands\nhello\nworld\n",
+		},
+		{
+			name: "over the limit",
+			max:  30,
+			writes: []string{
+				"~~~ Running commands\n", "hello\nwo", "rld\n",
+				"--- Tests\n", "one\n", "two\n",
+				"\x1b_bk;t=1700000000000\x07\x1b[33m+++ Failures\n", "three\n", "fo", "ur",
+			},
+			want: "~~~ Running commands\nhello\nwo\n" +
+				"+++ ⚠️ The job log has exceeded the maximum size of 30 B\n" +
+				"Only group headers will be shown from here on, followed by the end of the log when the job finishes.\n" +
+				"--- Tests\n" +
+				"\x1b_bk;t=1700000000000\x07\x1b[33m+++ Failures\n" +
+				"+++ ⚠️ End of the job log (0 B omitted)\n" +
+				"rld\none\ntwo\nthree\nfour\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got strings.Builder
+			lt := newLogTruncator(&got, test.max)
+			for _, w := range test.writes {
+				if n, err := lt.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("lt.Write(%q) = (%d, %v), want (%d, nil)", w, n, err, len(w))
+				}
+			}
+			if err := lt.Flush(); err != nil {
+				t.Fatalf("lt.Flush() error = %v", err)
+			}
+
+			if diff := cmp.Diff(got.String(), test.want); diff != "" {
+				t.Errorf("truncated log diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLogTruncatorKeepsRollingTail(t *testing.T) {
+	t.Parallel()
+
+	var got strings.Builder
+	lt := newLogTruncator(&got, 1)
+	for i := range truncatedLogTailLines + 10 {
+		fmt.Fprintf(lt, "line %d\n", i)
+	}
+	if err := lt.Flush(); err != nil {
+		t.Fatalf("lt.Flush() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(got.String(), "\n"), "\n")
+	if got, want := lines[2], "+++ ⚠️ End of the job log (70 B omitted)"; got != want {
+		t.Errorf("lines[2] = %q, want %q", got, want)
+	}
+	if got, want := len(lines), 3+truncatedLogTailLines; got != want {
+		t.Errorf("len(lines) = %d, want %d", got, want)
+	}
+	if got, want := lines[3], "line 10"; got != want {
+		t.Errorf("lines[3] = %q, want %q", got, want)
+	}
+}
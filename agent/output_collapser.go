@@ -0,0 +1,348 @@
+package agent
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Lines are held back until they haven't changed for this long, in case
+	// they are redrawn, so they are written in their final state.
+	collapseHoldTime = 2 * time.Second
+
+	// At most this many of the most recent lines are held back. Redrawing
+	// further up than this can't be collapsed.
+	collapseMaxHeldLines = 200
+
+	// Lines longer than this are written out as they are, so a line that is
+	// never ended can't use unbounded memory.
+	collapseMaxLineCells = 64 * 1024
+)
+
+// outputCollapser collapses interactive output, such as progress bars redrawn
+// with carriage returns and the cursor movements used by docker pull, into its
+// final state. It interprets the output the way a terminal would, for the most
+// recent lines, and writes each line to w once it stops changing.
+//
+// Colours and other escape sequences that don't move the cursor are kept.
+// Those that do are applied and removed.
+type outputCollapser struct {
+	w   io.Writer
+	now func() time.Time
+
+	mu      sync.Mutex
+	lines   []*collapseLine // the held lines, starting with the oldest
+	row     int             // the cursor's line, an index into lines
+	col     int             // the cursor's column
+	pending string          // escape sequences to attach to the next character
+	partial []byte          // an incomplete UTF-8 character or escape sequence
+	timer   *time.Timer     // writes lines that haven't changed recently
+	err     error           // the first error writing to w
+}
+
+// collapseLine is a line of output, as it would appear on a terminal.
+type collapseLine struct {
+	cells    []string // each character, with any escape sequences before it
+	tail     string   // escape sequences after the last character
+	ended    bool     // whether the line has been ended with a newline
+	modified time.Time
+}
+
+func newOutputCollapser(w io.Writer) *outputCollapser {
+	return &outputCollapser{w: w, now: time.Now}
+}
+
+// Write implements io.Writer.
+func (c *outputCollapser) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	now := c.now()
+	data := append(c.partial, p...)
+	c.partial = nil
+	for len(data) > 0 {
+		n := c.consume(data, now)
+		if n == 0 {
+			// An incomplete character or escape sequence
+			c.partial = append([]byte(nil), data...)
+			break
+		}
+		data = data[n:]
+	}
+
+	c.writeSettled(now)
+	c.scheduleLocked()
+	return len(p), c.err
+}
+
+// Flush writes out every held line, including the incomplete one the cursor is
+// on, if it isn't empty.
+func (c *outputCollapser) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.partial) > 0 {
+		c.pending += string(c.partial)
+		c.partial = nil
+	}
+	if c.pending != "" {
+		c.line(c.row).tail += c.pending
+		c.pending = ""
+	}
+	for len(c.lines) > 0 {
+		l := c.lines[0]
+		if !l.ended && len(l.cells) == 0 && l.tail == "" {
+			break
+		}
+		c.writeLine(l, l.ended || len(c.lines) > 1)
+		c.lines = c.lines[1:]
+		c.row--
+	}
+	c.lines, c.row, c.col = nil, 0, 0
+	return c.err
+}
+
+// consume interprets the start of data, and returns how many bytes it used, or
+// 0 if data starts with an incomplete character or escape sequence.
+func (c *outputCollapser) consume(data []byte, now time.Time) int {
+	switch b := data[0]; b {
+	case '\n':
+		l := c.line(c.row)
+		l.tail += c.pending
+		l.ended = true
+		l.modified = now
+		c.pending = ""
+		c.row++
+		c.col = 0
+		return 1
+
+	case '\r':
+		c.col = 0
+		return 1
+
+	case '\b':
+		c.col = max(c.col-1, 0)
+		return 1
+
+	case 0x1b:
+		return c.consumeEscape(data, now)
+
+	default:
+		if b < 0x20 && b != '\t' {
+			// Other control characters don't show up
+			return 1
+		}
+		if !utf8.FullRune(data) {
+			return 0
+		}
+		_, size := utf8.DecodeRune(data)
+		c.put(string(data[:size]), now)
+		return size
+	}
+}
+
+// consumeEscape interprets the escape sequence at the start of data.
+func (c *outputCollapser) consumeEscape(data []byte, now time.Time) int {
+	if len(data) < 2 {
+		return 0
+	}
+
+	switch data[1] {
+	case '[': // CSI: parameters, intermediates, then a final byte
+		i := 2
+		for i < len(data) && (data[i] < 0x40 || data[i] > 0x7e) {
+			i++
+		}
+		if i == len(data) {
+			return 0
+		}
+		c.csi(string(data[2:i]), data[i], string(data[:i+1]), now)
+		return i + 1
+
+	case ']', '_', 'P', '^': // OSC, APC, DCS, PM: until BEL or ST
+		for i := 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				c.pending += string(data[:i+1])
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				c.pending += string(data[:i+2])
+				return i + 2
+			}
+		}
+		return 0
+
+	default:
+		// Two-byte sequences, such as saving the cursor, are dropped.
+		return 2
+	}
+}
+
+// csi applies a control sequence that moves the cursor or erases, and keeps
+// the others (such as colours) to write with the next character.
+func (c *outputCollapser) csi(params string, final byte, seq string, now time.Time) {
+	if strings.HasPrefix(params, "?") {
+		// Private modes, such as hiding the cursor, don't affect the log.
+		return
+	}
+
+	n := 1
+	if v, err := strconv.Atoi(params); err == nil && v > 0 {
+		n = v
+	}
+
+	switch final {
+	case 'A': // Cursor up
+		c.row = max(c.row-n, 0)
+	case 'B': // Cursor down
+		c.row += n
+		c.line(c.row)
+	case 'C': // Cursor forward
+		// The column is capped at the longest line, so that moving far off to
+		// the right can't make put pad the line with unbounded spaces.
+		c.col = min(c.col+n, collapseMaxLineCells-1)
+	case 'D': // Cursor back
+		c.col = max(c.col-n, 0)
+	case 'E': // Cursor to the start of a following line
+		c.row += n
+		c.line(c.row)
+		c.col = 0
+	case 'F': // Cursor to the start of a preceding line
+		c.row = max(c.row-n, 0)
+		c.col = 0
+	case 'G': // Cursor to a column
+		c.col = min(n, collapseMaxLineCells) - 1
+
+	case 'K': // Erase in line
+		l := c.line(c.row)
+		switch params {
+		case "", "0":
+			if c.col < len(l.cells) {
+				l.cells = l.cells[:c.col]
+			}
+			l.tail = ""
+		case "1":
+			for i := 0; i <= c.col && i < len(l.cells); i++ {
+				l.cells[i] = " "
+			}
+		case "2":
+			l.cells, l.tail = l.cells[:0], ""
+		}
+		l.modified = now
+
+	case 'J': // Erase in display
+		switch params {
+		case "", "0":
+			l := c.line(c.row)
+			if c.col < len(l.cells) {
+				l.cells = l.cells[:c.col]
+			}
+			l.modified = now
+			c.lines = c.lines[:c.row+1]
+		case "2", "3":
+			for _, l := range c.lines {
+				l.cells = l.cells[:0]
+				l.modified = now
+			}
+		}
+
+	case 'm': // Colours and other graphic renditions
+		c.pending += seq
+
+	default:
+		// Anything else, such as scrolling, is dropped.
+	}
+}
+
+// put writes a character at the cursor.
+func (c *outputCollapser) put(ch string, now time.Time) {
+	l := c.line(c.row)
+	if c.pending != "" {
+		ch = c.pending + ch
+		c.pending = ""
+	}
+	for len(l.cells) < c.col {
+		l.cells = append(l.cells, " ")
+	}
+	if c.col < len(l.cells) {
+		l.cells[c.col] = ch
+	} else {
+		l.cells = append(l.cells, ch)
+	}
+	l.modified = now
+	c.col++
+
+	if len(l.cells) >= collapseMaxLineCells {
+		l.ended = true
+		c.row++
+		c.col = 0
+	}
+}
+
+// line returns the held line at row, adding lines if needed.
+func (c *outputCollapser) line(row int) *collapseLine {
+	for len(c.lines) <= row {
+		c.lines = append(c.lines, &collapseLine{})
+	}
+	return c.lines[row]
+}
+
+// writeSettled writes the oldest held lines, while they have ended, and are
+// too far above the cursor or haven't changed recently.
+func (c *outputCollapser) writeSettled(now time.Time) {
+	for len(c.lines) > 0 && c.row > 0 {
+		l := c.lines[0]
+		if !l.ended {
+			break
+		}
+		if c.row < collapseMaxHeldLines && now.Sub(l.modified) < collapseHoldTime {
+			break
+		}
+		c.writeLine(l, true)
+		c.lines = c.lines[1:]
+		c.row--
+	}
+}
+
+// writeLine writes a line to w.
+func (c *outputCollapser) writeLine(l *collapseLine, newline bool) {
+	if c.err != nil {
+		return
+	}
+	var b strings.Builder
+	for _, cell := range l.cells {
+		b.WriteString(cell)
+	}
+	b.WriteString(l.tail)
+	if newline {
+		b.WriteByte('\n')
+	}
+	_, c.err = io.WriteString(c.w, b.String())
+}
+
+// scheduleLocked makes sure held lines that have ended are written once they
+// settle, even if nothing more is written. c.mu must be held.
+func (c *outputCollapser) scheduleLocked() {
+	if c.timer != nil || c.row == 0 {
+		return
+	}
+	c.timer = time.AfterFunc(collapseHoldTime, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.timer = nil
+		c.writeSettled(c.now())
+		c.scheduleLocked()
+	})
+}
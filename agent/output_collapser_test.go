@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutputCollapser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "plain lines",
+			writes: []string{"hello\n", "wor", "ld\n", "no newline"},
+			want:   "hello\nworld\nno newline",
+		},
+		{
+			name:   "carriage return progress bar",
+			writes: []string{"Downloading  10%\r", "Downloading  50%\r", "Downloading 100%\n", "done\n"},
+			want:   "Downloading 100%\ndone\n",
+		},
+		{
+			name:   "shorter redraw with erase",
+			writes: []string{"fetching foo/bar\r\x1b[K", "ok\n"},
+			want:   "ok\n",
+		},
+		{
+			name: "cursor up redraws",
+			writes: []string{
+				"layer1: Waiting\nlayer2: Waiting\n",
+				"\x1b[2A\x1b[2Klayer1: Pulling\n\x1b[1B",
+				"\x1b[1A\x1b[2Klayer2: Pull complete\n",
+				"\x1b[2A\x1b[2Klayer1: Pull complete\n\x1b[1B",
+				"Status: Downloaded\n",
+			},
+			want: "layer1: Pull complete\nlayer2: Pull complete\nStatus: Downloaded\n",
+		},
+		{
+			name:   "colours and timestamps kept",
+			writes: []string{"\x1b_bk;t=1700000000000\x07\x1b[32mok\x1b[0m\n"},
+			want:   "\x1b_bk;t=1700000000000\x07\x1b[32mok\x1b[0m\n",
+		},
+		{
+			name:   "cursor visibility dropped",
+			writes: []string{"\x1b[?25lspinner |\b/\b-\b\\\b", "✓\x1b[?25h\n"},
+			want:   "spinner ✓\n",
+		},
+		{
+			name:   "split escape sequence and character",
+			writes: []string{"a\x1b[3", "1mb\xe2\x9c", "\x93\n"},
+			want:   "a\x1b[31mb✓\n",
+		},
+		{
+			name:   "cursor forward past the longest line",
+			writes: []string{"\x1b[999999999Cx"},
+			want:   strings.Repeat(" ", collapseMaxLineCells-1) + "x\n",
+		},
+		{
+			name:   "cursor to a column past the longest line",
+			writes: []string{"\x1b[999999999Gx"},
+			want:   strings.Repeat(" ", collapseMaxLineCells-1) + "x\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got strings.Builder
+			c := newOutputCollapser(&got)
+			for _, w := range test.writes {
+				if n, err := c.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("c.Write(%q) = (%d, %v), want (%d, nil)", w, n, err, len(w))
+				}
+			}
+			if err := c.Flush(); err != nil {
+				t.Fatalf("c.Flush() error = %v", err)
+			}
+
+			if diff := cmp.Diff(got.String(), test.want); diff != "" {
+				t.Errorf("collapsed output diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOutputCollapserWritesSettledLines(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var got strings.Builder
+	c := newOutputCollapser(&got)
+	c.now = func() time.Time { return now }
+
+	if _, err := c.Write([]byte("one\ntwo\n")); err != nil {
+		t.Fatalf("c.Write(...) error = %v", err)
+	}
+	if got.Len() != 0 {
+		t.Errorf("after writing recent lines, got %q, want nothing", got.String())
+	}
+
+	now = now.Add(collapseHoldTime)
+	if _, err := c.Write([]byte("thr")); err != nil {
+		t.Fatalf("c.Write(...) error = %v", err)
+	}
+	if got, want := got.String(), "one\ntwo\n"; got != want {
+		t.Errorf("after the hold time, got %q, want %q", got, want)
+	}
+
+	if _, err := c.Write([]byte(strings.Repeat("x\n", collapseMaxHeldLines))); err != nil {
+		t.Fatalf("c.Write(...) error = %v", err)
+	}
+	if got, want := got.String(), "one\ntwo\nthrx\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("after many lines, got %q, want it to start with %q", got, want)
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush() error = %v", err)
+	}
+	if got, want := got.String(), "one\ntwo\nthrx\n"+strings.Repeat("x\n", collapseMaxHeldLines-1); got != want {
+		t.Errorf("after c.Flush(), got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AllowedPlugin is an entry in an agent's list of allowed plugins. It is
+// written as a regular expression matching plugin sources, optionally followed
+// by "@" and the SHA that matching plugins must resolve to: the commit for
+// plugins in git repositories, or the SHA-256 checksum for plugin archives and
+// OCI plugins. For example:
+//
+//	^github.com/buildkite-plugins/docker-buildkite-plugin#v5.*$
+//	^github.com/my-org/my-buildkite-plugin#v1.0.0$@3f786850e387550fdab836ed7e6dc881de23001b
+type AllowedPlugin struct {
+	Pattern *regexp.Regexp
+	SHA     string
+}
+
+// ParseAllowedPlugin parses an entry in the list of allowed plugins.
+func ParseAllowedPlugin(s string) (*AllowedPlugin, error) {
+	pattern, sha := s, ""
+	if i := strings.LastIndex(s, "@"); i >= 0 && isSHA(s[i+1:]) {
+		pattern, sha = s[:i], strings.ToLower(s[i+1:])
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &AllowedPlugin{Pattern: re, SHA: sha}, nil
+}
+
+// String returns the entry in the form parsed by ParseAllowedPlugin.
+func (a *AllowedPlugin) String() string {
+	if a.SHA == "" {
+		return a.Pattern.String()
+	}
+	return a.Pattern.String() + "@" + a.SHA
+}
+
+// isSHA reports whether s looks like a SHA-1 or SHA-256 hash in hex.
+func isSHA(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Allowlist is an agent's list of allowed plugins. An empty Allowlist allows
+// all plugins.
+type Allowlist []*AllowedPlugin
+
+// ParseAllowlist parses each entry with ParseAllowedPlugin.
+func ParseAllowlist(entries []string) (Allowlist, error) {
+	list := make(Allowlist, 0, len(entries))
+	for _, s := range entries {
+		a, err := ParseAllowedPlugin(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// Strings returns each entry in the form parsed by ParseAllowedPlugin.
+func (l Allowlist) Strings() []string {
+	s := make([]string, 0, len(l))
+	for _, a := range l {
+		s = append(s, a.String())
+	}
+	return s
+}
+
+// Check returns an error if the plugin isn't allowed. Otherwise it returns the
+// SHAs the plugin must resolve to, or nil if it may resolve to anything.
+func (l Allowlist) Check(p *Plugin) (shas []string, err error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	source := p.Source()
+	matched := false
+	for _, a := range l {
+		if !a.Pattern.MatchString(source) {
+			continue
+		}
+		if a.SHA == "" {
+			// At least one matching entry doesn't pin the plugin
+			return nil, nil
+		}
+		matched = true
+		shas = append(shas, a.SHA)
+	}
+
+	if !matched {
+		return nil, fmt.Errorf("%s has no match in %s", source, l)
+	}
+	return shas, nil
+}
+
+// Source returns the plugin's location and version, in the same form as
+// pipeline plugin sources, without any credentials.
+func (p *Plugin) Source() string {
+	s := p.Label()
+	if p.Scheme != "" {
+		s = p.Scheme + "://" + s
+	}
+	return s
+}
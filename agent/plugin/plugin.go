@@ -4,6 +4,7 @@
 package plugin
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/url"
@@ -146,6 +147,19 @@ func (p *Plugin) Name() string {
 	parts := strings.Split(location, "/")
 	name := parts[len(parts)-1]
 
+	// Archive file names and OCI references also carry an extension or tag,
+	// and often a version, none of which are part of the name. By convention
+	// they're named like <name>-buildkite-plugin-<version>.tar.gz.
+	if p.IsArchive() || p.IsOCI() {
+		name, _, _ = strings.Cut(name, ":")
+		for _, ext := range archiveExtensions {
+			name = strings.TrimSuffix(name, ext)
+		}
+		if i := strings.Index(name, "-buildkite-plugin"); i > 0 {
+			name = name[:i]
+		}
+	}
+
 	// Clean up the name
 	name = strings.ToLower(name)
 	name = whitespaceRE.ReplaceAllString(name, " ")
@@ -295,6 +309,72 @@ func (p *Plugin) ConfigurationToEnvironment() (*env.Environment, error) {
 	return env.FromSlice(envSlice), err
 }
 
+// archiveExtensions are the file extensions of plugin archives that can be
+// downloaded over HTTP(S).
+var archiveExtensions = []string{".tar.gz", ".tgz"}
+
+// IsArchive reports whether the plugin is a tarball to be downloaded over
+// HTTP(S), rather than a git repository.
+func (p *Plugin) IsArchive() bool {
+	if p.Scheme != "http" && p.Scheme != "https" {
+		return false
+	}
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(p.Location, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOCI reports whether the plugin is an artifact in an OCI registry, e.g.
+// oci://ghcr.io/my-org/my-buildkite-plugin:v1.0.0.
+func (p *Plugin) IsOCI() bool {
+	return p.Scheme == "oci"
+}
+
+// Checksum returns the digest that a plugin archive or OCI artifact must
+// match, given as the plugin version (e.g. "sha256:abc123..."). ok is false if
+// the version isn't a digest.
+func (p *Plugin) Checksum() (algorithm, digest string, ok bool) {
+	algorithm, digest, ok = strings.Cut(p.Version, ":")
+	if !ok || algorithm != "sha256" || len(digest) != 64 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", "", false
+	}
+	return algorithm, strings.ToLower(digest), true
+}
+
+// ArchiveURL returns the URL to download a plugin archive from, including any
+// credentials.
+func (p *Plugin) ArchiveURL() string {
+	s := p.Location
+	if p.Authentication != "" {
+		s = p.Authentication + "@" + s
+	}
+	return p.Scheme + "://" + s
+}
+
+// OCIReference returns the registry host, repository and reference (a tag,
+// or the digest the plugin is pinned to) of an OCI plugin.
+func (p *Plugin) OCIReference() (registry, repository, reference string, err error) {
+	registry, rest, ok := strings.Cut(p.Location, "/")
+	if !ok || rest == "" {
+		return "", "", "", fmt.Errorf("Incomplete OCI plugin reference %q", p.Location)
+	}
+
+	repository, reference = rest, "latest"
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		repository, reference = rest[:i], rest[i+1:]
+	}
+	if _, _, ok := p.Checksum(); ok {
+		reference = p.Version
+	}
+	return registry, repository, reference, nil
+}
+
 // Label returns a pretty name for the plugin.
 func (p *Plugin) Label() string {
 	if p.Version == "" {
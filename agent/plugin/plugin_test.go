@@ -591,3 +591,89 @@ func duplicatePluginFromConfig(cfgJSON1, cfgJSON2 string) ([]*Plugin, error) {
 
 	return plugins, nil
 }
+
+func TestArchiveAndOCIPlugins(t *testing.T) {
+	t.Parallel()
+
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		location      string
+		wantArchive   bool
+		wantOCI       bool
+		wantName      string
+		wantChecksum  bool
+		wantReference [3]string
+	}{
+		{
+			location:     "https://example.com/plugins/docker-compose-buildkite-plugin-v1.0.0.tar.gz#" + digest,
+			wantArchive:  true,
+			wantName:     "docker-compose",
+			wantChecksum: true,
+		},
+		{
+			location:    "http://example.com/plugins/test.tgz",
+			wantArchive: true,
+			wantName:    "test",
+		},
+		{
+			location:    "https://github.com/buildkite-plugins/docker-compose-buildkite-plugin",
+			wantName:    "docker-compose",
+			wantArchive: false,
+		},
+		{
+			location:      "oci://ghcr.io/my-org/docker-compose-buildkite-plugin:v1.0.0",
+			wantOCI:       true,
+			wantName:      "docker-compose",
+			wantReference: [3]string{"ghcr.io", "my-org/docker-compose-buildkite-plugin", "v1.0.0"},
+		},
+		{
+			location:      "oci://localhost:5000/test-buildkite-plugin",
+			wantOCI:       true,
+			wantName:      "test",
+			wantReference: [3]string{"localhost:5000", "test-buildkite-plugin", "latest"},
+		},
+		{
+			location:      "oci://ghcr.io/my-org/test:v1#" + digest,
+			wantOCI:       true,
+			wantName:      "test",
+			wantChecksum:  true,
+			wantReference: [3]string{"ghcr.io", "my-org/test", digest},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.location, func(t *testing.T) {
+			t.Parallel()
+
+			plugins, err := CreateFromJSON(fmt.Sprintf(`[{%q: {}}]`, tc.location))
+			if err != nil {
+				t.Fatalf("CreateFromJSON error = %v", err)
+			}
+			p := plugins[0]
+
+			if got, want := p.IsArchive(), tc.wantArchive; got != want {
+				t.Errorf("p.IsArchive() = %t, want %t", got, want)
+			}
+			if got, want := p.IsOCI(), tc.wantOCI; got != want {
+				t.Errorf("p.IsOCI() = %t, want %t", got, want)
+			}
+			if got, want := p.Name(), tc.wantName; got != want {
+				t.Errorf("p.Name() = %q, want %q", got, want)
+			}
+			if _, _, got := p.Checksum(); got != tc.wantChecksum {
+				t.Errorf("p.Checksum() ok = %t, want %t", got, tc.wantChecksum)
+			}
+			if !tc.wantOCI {
+				return
+			}
+			registry, repository, reference, err := p.OCIReference()
+			if err != nil {
+				t.Fatalf("p.OCIReference() error = %v", err)
+			}
+			if diff := cmp.Diff([3]string{registry, repository, reference}, tc.wantReference); diff != "" {
+				t.Errorf("p.OCIReference() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/status"
+)
+
+// How often to check for notice of preemption. EC2 gives two minutes notice,
+// and recommends checking every five seconds.
+const preemptionCheckInterval = 5 * time.Second
+
+// PreemptionNotice is notice from a cloud provider that the instance the agent
+// runs on is about to be preempted or terminated.
+type PreemptionNotice struct {
+	// The cloud provider that gave notice
+	Provider string
+
+	// What is going to happen to the instance, e.g. "terminate"
+	Action string
+
+	// When it will happen, if known
+	Time time.Time
+}
+
+func (n *PreemptionNotice) String() string {
+	if n.Time.IsZero() {
+		return fmt.Sprintf("%s: instance will %s", n.Provider, n.Action)
+	}
+	return fmt.Sprintf("%s: instance will %s at %s", n.Provider, n.Action, n.Time.Format(time.RFC3339))
+}
+
+// PreemptionChecker checks a cloud provider for notice that the instance is
+// about to be preempted. It returns nil if there is no notice yet.
+type PreemptionChecker interface {
+	PreemptionNotice(ctx context.Context) (*PreemptionNotice, error)
+}
+
+// WatchForPreemption checks with each of the checkers every few seconds, and
+// returns the first notice of preemption. Errors from the checkers are logged,
+// and don't stop the watch. It returns nil if ctx ends first.
+func WatchForPreemption(ctx context.Context, l logger.Logger, checkers []PreemptionChecker) *PreemptionNotice {
+	return watchForPreemption(ctx, l, checkers, preemptionCheckInterval)
+}
+
+func watchForPreemption(ctx context.Context, l logger.Logger, checkers []PreemptionChecker, interval time.Duration) *PreemptionNotice {
+	ctx, setStatus, done := status.AddSimpleItem(ctx, "Preemption Watcher")
+	defer done()
+	setStatus("👀 Watching for notice of preemption")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, c := range checkers {
+			notice, err := c.PreemptionNotice(ctx)
+			if err != nil {
+				l.Debug("Couldn't check for notice of preemption: %v", err)
+				continue
+			}
+			if notice != nil {
+				setStatus(fmt.Sprintf("⚠️ %s", notice))
+				return notice
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePreemptionChecker struct {
+	checks int
+	after  int
+	err    error
+}
+
+func (f *fakePreemptionChecker) PreemptionNotice(context.Context) (*PreemptionNotice, error) {
+	f.checks++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.checks < f.after {
+		return nil, nil
+	}
+	return &PreemptionNotice{Provider: "fake", Action: "terminate"}, nil
+}
+
+func TestWatchForPreemption(t *testing.T) {
+	t.Parallel()
+
+	broken := &fakePreemptionChecker{err: errors.New("metadata service unreachable")}
+	checker := &fakePreemptionChecker{after: 3}
+
+	notice := watchForPreemption(context.Background(), logger.Discard, []PreemptionChecker{broken, checker}, time.Millisecond)
+
+	assert.Equal(t, &PreemptionNotice{Provider: "fake", Action: "terminate"}, notice)
+	assert.Equal(t, 3, checker.checks)
+	assert.Equal(t, 3, broken.checks)
+}
+
+func TestWatchForPreemptionStopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	checker := &fakePreemptionChecker{after: 1000}
+	assert.Nil(t, watchForPreemption(ctx, logger.Discard, []PreemptionChecker{checker}, time.Hour))
+}
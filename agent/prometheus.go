@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/buildkite/agent/v3/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const promNamespace = "buildkite_agent"
+
+// Prometheus metrics recorded by all workers in the process. They're exposed
+// by the health check server at /metrics.
+var (
+	promJobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: promNamespace,
+		Name:      "job_duration_seconds",
+		Help:      "How long jobs ran for, by whether they passed or failed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 15), // 1s to ~4.5h
+	}, []string{"result"})
+
+	promJobDispatchLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: promNamespace,
+		Name:      "job_dispatch_latency_seconds",
+		Help:      "How long jobs waited between becoming runnable and starting on this agent.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 14), // 0.25s to ~34m
+	})
+
+	promAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Name:      "api_requests_total",
+		Help:      "Calls made to the Buildkite Agent API, by action.",
+	}, []string{"action"})
+
+	promAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Name:      "api_errors_total",
+		Help:      "Calls to the Buildkite Agent API that failed, by action.",
+	}, []string{"action"})
+)
+
+// recordAPICall counts a call to the Agent API, and whether it failed, in both
+// Prometheus and the metrics scope.
+func recordAPICall(scope *metrics.Scope, action string, err error) {
+	promAPIRequests.WithLabelValues(action).Inc()
+	if err != nil {
+		promAPIErrors.WithLabelValues(action).Inc()
+		scope.Count("api.errors", 1, metrics.Tags{"action": action})
+	}
+}
+
+// workerCollector is a prometheus.Collector reporting the state of the pool's
+// workers when scraped.
+type workerCollector struct {
+	pool *AgentPool
+
+	workers     *prometheus.Desc
+	runningJobs *prometheus.Desc
+}
+
+func newWorkerCollector(pool *AgentPool) *workerCollector {
+	return &workerCollector{
+		pool: pool,
+		workers: prometheus.NewDesc(
+			prometheus.BuildFQName(promNamespace, "", "workers"),
+			"Number of agent workers, by state.",
+			[]string{"state"}, nil,
+		),
+		runningJobs: prometheus.NewDesc(
+			prometheus.BuildFQName(promNamespace, "", "running_jobs"),
+			"Number of jobs currently running.",
+			nil, nil,
+		),
+	}
+}
+
+func (c *workerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.workers
+	ch <- c.runningJobs
+}
+
+func (c *workerCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[agentWorkerState]int{
+		agentWorkerStateIdle:   0,
+		agentWorkerStateBusy:   0,
+		agentWorkerStatePaused: 0,
+	}
+	for _, worker := range c.pool.workers {
+		counts[worker.getState()]++
+	}
+	for state, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.workers, prometheus.GaugeValue, float64(n), string(state))
+	}
+	ch <- prometheus.MustNewConstMetric(c.runningJobs, prometheus.GaugeValue, float64(counts[agentWorkerStateBusy]))
+}
+
+// prometheusHandler returns a handler serving the agent's metrics, along with
+// the usual Go runtime and process metrics, in the Prometheus exposition format.
+func (ap *AgentPool) prometheusHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newWorkerCollector(ap),
+		promJobDuration,
+		promJobDispatchLatency,
+		promAPIRequests,
+		promAPIErrors,
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	t.Parallel()
+
+	busy := &AgentWorker{state: agentWorkerStateBusy}
+	idle := &AgentWorker{state: agentWorkerStateIdle}
+	pool := NewAgentPool([]*AgentWorker{busy, idle, idle})
+
+	recordAPICall(nil, "prometheus_test", nil)
+	recordAPICall(nil, "prometheus_test", errors.New("llama overload"))
+
+	rec := httptest.NewRecorder()
+	pool.prometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatalf("io.ReadAll(rec.Result().Body) error = %v", err)
+	}
+
+	for _, want := range []string{
+		`buildkite_agent_workers{state="busy"} 1`,
+		`buildkite_agent_workers{state="idle"} 2`,
+		`buildkite_agent_running_jobs 1`,
+		`buildkite_agent_api_requests_total{action="prometheus_test"} 2`,
+		`buildkite_agent_api_errors_total{action="prometheus_test"} 1`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("GET /metrics body does not contain %q:\n%s", want, body)
+		}
+	}
+}
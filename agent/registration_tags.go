@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+)
+
+// RegistrationTags holds the tags that the workers in an agent pool register
+// with. They can change while the agent runs, in which case idle workers
+// re-register with Buildkite so it sees the new tags. It is shared between all
+// of the pool's workers.
+//
+// The tags are made up of static tags (from --tags and --queue, or set using
+// the Agent API) and tags fetched from other sources, such as EC2 or GCP.
+type RegistrationTags struct {
+	mu      sync.Mutex
+	static  []string
+	fetched []string
+	version int
+}
+
+// NewRegistrationTags returns RegistrationTags starting with the given static
+// and fetched tags.
+func NewRegistrationTags(static, fetched []string) *RegistrationTags {
+	return &RegistrationTags{
+		static:  static,
+		fetched: fetched,
+	}
+}
+
+// Get returns the current tags, and a version number that changes whenever
+// they do.
+func (t *RegistrationTags) Get() (tags []string, version int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tags = make([]string, 0, len(t.static)+len(t.fetched))
+	tags = append(tags, t.static...)
+	tags = append(tags, t.fetched...)
+	return tags, t.version
+}
+
+// SetStatic replaces the static tags. It reports whether the tags changed.
+func (t *RegistrationTags) SetStatic(static []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.set(static, t.fetched)
+}
+
+// setFetched replaces the fetched tags. It reports whether the tags changed.
+func (t *RegistrationTags) setFetched(fetched []string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.set(t.static, fetched)
+}
+
+// set replaces the tags, bumping the version if they differ, ignoring order,
+// from the current tags. t.mu must be held.
+func (t *RegistrationTags) set(static, fetched []string) bool {
+	oldTags := append(slices.Clone(t.static), t.fetched...)
+	newTags := append(slices.Clone(static), fetched...)
+	slices.Sort(oldTags)
+	slices.Sort(newTags)
+
+	t.static, t.fetched = static, fetched
+	if slices.Equal(oldTags, newTags) {
+		return false
+	}
+	t.version++
+	return true
+}
+
+// Refresh fetches tags again from the sources in conf every interval, until
+// the context is done. conf.Tags is ignored, as those are the static tags. If
+// any source fails, the tags are left as they were rather than losing the
+// tags from that source.
+func (t *RegistrationTags) Refresh(ctx context.Context, l logger.Logger, conf FetchTagsConfig, interval time.Duration) {
+	conf.Tags = nil
+	f := newTagFetcher(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Debug("Refreshing tags...")
+			fetched, err := f.fetch(ctx, l, conf)
+			if err != nil {
+				l.Warn("Not updating tags, as some couldn't be fetched: %v", err)
+				continue
+			}
+			if t.setFetched(fetched) {
+				l.Info("Tags have changed, agents will re-register with Buildkite when idle")
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistrationTags(t *testing.T) {
+	t.Parallel()
+
+	tags := NewRegistrationTags([]string{"queue=default"}, []string{"aws:instance-life-cycle=spot"})
+
+	got, version := tags.Get()
+	want := []string{"queue=default", "aws:instance-life-cycle=spot"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("tags.Get() diff (-got +want):\n%s", diff)
+	}
+
+	// The same tags in a different order aren't a change
+	if tags.setFetched([]string{"aws:instance-life-cycle=spot"}) {
+		t.Errorf("tags.setFetched(same tags) = true, want false")
+	}
+	if tags.SetStatic([]string{"queue=default"}) {
+		t.Errorf("tags.SetStatic(same tags) = true, want false")
+	}
+	if _, v := tags.Get(); v != version {
+		t.Errorf("tags.Get() version = %d after setting the same tags, want %d", v, version)
+	}
+
+	if !tags.setFetched([]string{"aws:instance-life-cycle=spot", "spot-termination=soon"}) {
+		t.Errorf("tags.setFetched(new tags) = false, want true")
+	}
+	if !tags.SetStatic([]string{"queue=draining"}) {
+		t.Errorf("tags.SetStatic(new tags) = false, want true")
+	}
+
+	got, newVersion := tags.Get()
+	want = []string{"queue=draining", "aws:instance-life-cycle=spot", "spot-termination=soon"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("tags.Get() diff (-got +want):\n%s", diff)
+	}
+	if newVersion == version {
+		t.Errorf("tags.Get() version = %d after changing the tags, want a different version", newVersion)
+	}
+}
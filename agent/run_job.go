@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/kubernetes"
+	"github.com/buildkite/agent/v3/lock"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/metrics"
 	"github.com/buildkite/agent/v3/process"
@@ -41,6 +43,14 @@ const (
 	//
 	// We should consider adding new fields 'not_run_reason' and 'not_run_details' instead of adding
 	// more signal reasons.
+
+	// SignalReasonOOMKilled is for jobs where a Kubernetes container had
+	// processes killed for running out of memory, so that retry rules can
+	// tell them apart from other failures.
+	//
+	// Note: like the others, it needs to be in the Job::Event::SignalReason
+	// enum for Buildkite to accept it.
+	SignalReasonOOMKilled = "oom_killed"
 )
 
 type missingKeyError struct {
@@ -63,9 +73,13 @@ func (r *JobRunner) Run(ctx context.Context) error {
 	// Start the build in the Buildkite Agent API. This is the first thing
 	// we do so if it fails, we don't have to worry about cleaning things
 	// up like started log streamer workers, and so on.
-	if err := r.client.StartJob(ctx, r.conf.Job, r.startedAt); err != nil {
+	err := r.client.StartJob(ctx, r.conf.Job, r.startedAt)
+	recordAPICall(r.conf.MetricsScope, "start_job", err)
+	if err != nil {
+		r.removeLogSpool()
 		return err
 	}
+	r.conf.MetricsScope.Count("jobs.started", 1)
 
 	// If this agent successfully grabs the job from the API, publish metric for
 	// how long this job was in the queue for, if we can calculate that
@@ -75,6 +89,7 @@ func (r *JobRunner) Run(ctx context.Context) error {
 			r.agentLogger.Error("Metric submission failed to parse %s", r.conf.Job.RunnableAt)
 		} else {
 			r.conf.MetricsScope.Timing("queue.duration", r.startedAt.Sub(runnableAt))
+			promJobDispatchLatency.Observe(r.startedAt.Sub(runnableAt).Seconds())
 		}
 	}
 
@@ -83,6 +98,7 @@ func (r *JobRunner) Run(ctx context.Context) error {
 
 	// Start the log streamer. Launches multiple goroutines.
 	if err := r.logStreamer.Start(ctx); err != nil {
+		r.removeLogSpool()
 		return err
 	}
 
@@ -101,6 +117,15 @@ func (r *JobRunner) Run(ctx context.Context) error {
 
 	job := r.conf.Job
 
+	if r.conf.Refusal != nil {
+		fmt.Fprintf(r.jobLogs, "This agent refused to run this job: %v\n", r.conf.Refusal)
+		r.agentLogger.Error("Refused job %s: %v", job.ID, r.conf.Refusal)
+
+		exit.Status = -1
+		exit.SignalReason = SignalReasonAgentRefused
+		return nil
+	}
+
 	if r.conf.JWKS == nil && job.Step.Signature != nil {
 		r.verificationFailureLogs(
 			VerificationBehaviourBlock,
@@ -167,6 +192,17 @@ func (r *JobRunner) Run(ctx context.Context) error {
 		}
 	}
 
+	// Let the agent-pre-job hooks (if any) prepare the host for the job, or
+	// refuse it.
+	if err := r.executeAgentPreJobHooks(ctx); err != nil {
+		fmt.Fprintln(r.jobLogs, "agent-pre-job hook refused this job, see the buildkite-agent logs for more details")
+
+		exit.Status = -1
+		exit.SignalReason = SignalReasonAgentRefused
+
+		return nil
+	}
+
 	// Kick off log streaming and job status checking when the process starts.
 	wg.Add(2)
 	go r.streamJobLogsAfterProcessStart(cctx, &wg)
@@ -230,6 +266,16 @@ func validateJobValue(allowedPatterns []*regexp.Regexp, jobValue string) error {
 	return fmt.Errorf("%s has no match in %s", jobValue, allowedPatterns)
 }
 
+// joinPatterns joins the patterns into a comma-separated list, for passing
+// to the executor.
+func joinPatterns(patterns []*regexp.Regexp) string {
+	s := make([]string, 0, len(patterns))
+	for _, re := range patterns {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ",")
+}
+
 // validatePlugins unmarshal and validates the plugins, if the list of allowed plugins is set.
 // Disabled plugins or errors in json.Unmarshal will by-pass the plugin verification.
 func (r *JobRunner) validatePlugins() error {
@@ -287,6 +333,20 @@ func (r *JobRunner) verificationFailureLogs(behavior string, err error) {
 
 func (r *JobRunner) runJob(ctx context.Context) core.ProcessExit {
 	exit := core.ProcessExit{}
+
+	if r.cgroup != nil {
+		if err := r.cgroup.create(); err != nil {
+			fmt.Fprintf(r.jobLogs, "Error creating a cgroup for the job: %s\n", err)
+			r.removeCgroup()
+			return core.ProcessExit{
+				Status:       -1,
+				SignalReason: SignalReasonProcessRunError,
+			}
+		}
+		defer r.removeCgroup()
+	}
+	processStartedAt := time.Now()
+
 	// Run the process. This will block until it finishes.
 	if err := r.process.Run(ctx); err != nil {
 		// Send the error to job logs
@@ -302,7 +362,10 @@ func (r *JobRunner) runJob(ctx context.Context) core.ProcessExit {
 	// start. Normally such errors are hidden in the Kubernetes events. Let's feed them up
 	// to the user as they may be the caused by errors in the pipeline definition.
 	k8sProcess, isK8s := r.process.(*kubernetes.Runner)
+	oomKilled := false
 	if isK8s && !r.stopped {
+		oomKilled = k8sProcess.AnyClientOOMKilled()
+
 		switch {
 		case r.cancelled && k8sProcess.AnyClientIn(kubernetes.StateNotYetConnected):
 			fmt.Fprint(r.jobLogs, `+++ Unknown container exit status
@@ -314,6 +377,16 @@ One or more containers connected to the agent, but then stopped communicating wi
 `)
 		}
 
+		if err := k8sProcess.WriteSummary(r.jobLogs); err != nil {
+			r.agentLogger.Warn("Failed to write container summary to job log: %v", err)
+		}
+
+	}
+
+	if r.cgroup != nil {
+		if err := r.cgroup.writeUsage(r.jobLogs, time.Since(processStartedAt)); err != nil {
+			r.agentLogger.Warn("Failed to write the job's resource usage to the job log: %v", err)
+		}
 	}
 
 	// Collect the finished process' exit status
@@ -340,6 +413,10 @@ One or more containers connected to the agent, but then stopped communicating wi
 				exit.Status = 1
 			}
 		}
+
+	case oomKilled:
+		// A container had processes killed for running out of memory
+		exit.SignalReason = SignalReasonOOMKilled
 	}
 
 	return exit
@@ -347,6 +424,22 @@ One or more containers connected to the agent, but then stopped communicating wi
 
 func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit core.ProcessExit) {
 	finishedAt := time.Now()
+	r.exit, r.finished = exit, true
+
+	// Write any output the collapser is still holding, in case the process
+	// never finished.
+	if r.outputCollapser != nil {
+		if err := r.outputCollapser.Flush(); err != nil {
+			r.agentLogger.Warn("[JobRunner] Error writing collapsed output: %v", err)
+		}
+	}
+
+	// Write the end of the job log, if it was truncated.
+	if r.logTruncator != nil {
+		if err := r.logTruncator.Flush(); err != nil {
+			r.agentLogger.Warn("[JobRunner] Error writing the end of the truncated job log: %v", err)
+		}
+	}
 
 	// Flush the job logs. If the process is never started, then logs from prior to the attempt to
 	// start the process will still be buffered. Also, there may still be logs in the buffer that
@@ -368,6 +461,9 @@ func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit core.P
 	r.agentLogger.Debug("[JobRunner] Waiting for all other routines to finish")
 	wg.Wait()
 
+	// The job executor has exited, so release any locks it didn't
+	r.releaseJobLocks(ctx)
+
 	// Remove the env file, if any
 	for _, f := range []*os.File{r.envShellFile, r.envJSONFile} {
 		if f == nil {
@@ -380,24 +476,115 @@ func (r *JobRunner) cleanup(ctx context.Context, wg *sync.WaitGroup, exit core.P
 		r.agentLogger.Debug("[JobRunner] Deleted env file: %s", f.Name())
 	}
 
+	// Remove what annotate --append-with-budget kept about the job's
+	// annotations
+	if err := os.RemoveAll(AnnotationStateDir(r.conf.Job.ID)); err != nil {
+		r.agentLogger.Warn("[JobRunner] Error cleaning up annotation state: %s", err)
+	}
+
 	// Write some metrics about the job run
 	jobMetrics := r.conf.MetricsScope.With(metrics.Tags{"exit_code": strconv.Itoa(exit.Status)})
 
+	// Report what the executor and the job's commands recorded, such as
+	// phase durations and artifact bytes uploaded
+	if r.metricsFile != "" {
+		if err := jobMetrics.ReportJobFile(r.metricsFile); err != nil {
+			r.agentLogger.Warn("[JobRunner] Error reporting job metrics: %s", err)
+		}
+		if err := os.Remove(r.metricsFile); err != nil {
+			r.agentLogger.Warn("[JobRunner] Error cleaning up metrics file: %s", err)
+		}
+	}
+
 	if exit.Status == 0 {
 		jobMetrics.Timing("jobs.duration.success", finishedAt.Sub(r.startedAt))
 		jobMetrics.Count("jobs.success", 1)
+		promJobDuration.WithLabelValues("success").Observe(finishedAt.Sub(r.startedAt).Seconds())
 	} else {
 		jobMetrics.Timing("jobs.duration.error", finishedAt.Sub(r.startedAt))
 		jobMetrics.Count("jobs.failed", 1)
+		promJobDuration.WithLabelValues("failed").Observe(finishedAt.Sub(r.startedAt).Seconds())
 	}
 
 	// Finish the build in the Buildkite Agent API
 	// Once we tell the API we're finished it might assign us new work, so make sure everything else is done first.
 	r.client.FinishJob(ctx, r.conf.Job, finishedAt, exit, r.logStreamer.FailedChunks())
 
+	// The job log has been uploaded, so it won't need to be recovered
+	r.removeLogSpool()
+
+	// The agent won't start another job until this returns, so the
+	// agent-post-job hooks can clean up after this one.
+	r.executeAgentPostJobHooks(ctx, exit)
+
 	r.agentLogger.Info("Finished job %s", r.conf.Job.ID)
 }
 
+// releaseJobLocks releases the agent API locks that the job executor held on
+// behalf of the job, such as for its local concurrency group, and didn't
+// release itself, such as because it was killed.
+func (r *JobRunner) releaseJobLocks(ctx context.Context) {
+	if !experiments.IsEnabled(ctx, experiments.AgentAPI) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	client, err := lock.NewClient(ctx, r.conf.AgentConfiguration.SocketsPath)
+	if err != nil {
+		r.agentLogger.Warn("[JobRunner] Couldn't connect to the agent API to release the job's locks: %v", err)
+		return
+	}
+	statuses, err := client.List(ctx)
+	if err != nil {
+		r.agentLogger.Warn("[JobRunner] Couldn't list the agent API's locks to release the job's locks: %v", err)
+		return
+	}
+
+	token := lock.JobToken(r.conf.Job.ID)
+	for _, st := range statuses {
+		if st.Value != token {
+			continue
+		}
+		if err := client.Unlock(ctx, st.Key, token); err != nil {
+			r.agentLogger.Warn("[JobRunner] Couldn't release lock %q held by the job: %v", st.Key, err)
+			continue
+		}
+		r.agentLogger.Info("[JobRunner] Released lock %q, which the job didn't release", st.Key)
+	}
+}
+
+// removeCgroup removes the job's cgroup, killing anything the job left
+// running in it.
+func (r *JobRunner) removeCgroup() {
+	if err := r.cgroup.remove(); err != nil {
+		r.agentLogger.Warn("[JobRunner] Error removing the job's cgroup: %v", err)
+	}
+}
+
+// Sections returns the sections of the job log found so far, with the last
+// one running until end.
+func (r *JobRunner) Sections(end time.Time) []jobSection {
+	return r.headerTimesStreamer.Sections(end)
+}
+
+// Exit returns how the job finished, once Run has returned. It returns false
+// if Run returned before the job started.
+func (r *JobRunner) Exit() (core.ProcessExit, bool) {
+	return r.exit, r.finished
+}
+
+// removeLogSpool removes the job log spool, if there is one.
+func (r *JobRunner) removeLogSpool() {
+	if r.logSpool == nil {
+		return
+	}
+	if err := r.logSpool.Remove(); err != nil {
+		r.agentLogger.Warn("[JobRunner] Error removing job log spool: %v", err)
+	}
+}
+
 // streamJobLogsAfterProcessStart waits for the process to start, then grabs the job output
 // every few seconds and sends it back to Buildkite.
 func (r *JobRunner) streamJobLogsAfterProcessStart(ctx context.Context, wg *sync.WaitGroup) {
@@ -18,24 +18,33 @@ import (
 type FetchTagsConfig struct {
 	Tags []string
 
-	TagsFromK8s               bool
-	TagsFromEC2MetaData       bool
-	TagsFromEC2MetaDataPaths  []string
-	TagsFromEC2Tags           bool
-	TagsFromECSMetaData       bool
-	TagsFromGCPMetaData       bool
-	TagsFromGCPMetaDataPaths  []string
-	TagsFromGCPLabels         bool
-	TagsFromHost              bool
-	WaitForEC2TagsTimeout     time.Duration
-	WaitForEC2MetaDataTimeout time.Duration
-	WaitForECSMetaDataTimeout time.Duration
-	WaitForGCPLabelsTimeout   time.Duration
+	TagsFromK8s                 bool
+	TagsFromEC2MetaData         bool
+	TagsFromEC2MetaDataPaths    []string
+	TagsFromEC2Tags             bool
+	TagsFromECSMetaData         bool
+	TagsFromGCPMetaData         bool
+	TagsFromGCPMetaDataPaths    []string
+	TagsFromGCPLabels           bool
+	TagsFromAzureMetaData       bool
+	TagsFromAzureTags           bool
+	TagsFromHost                bool
+	TagsFromGPUs                bool
+	WaitForEC2TagsTimeout       time.Duration
+	WaitForEC2MetaDataTimeout   time.Duration
+	WaitForECSMetaDataTimeout   time.Duration
+	WaitForGCPLabelsTimeout     time.Duration
+	WaitForAzureMetaDataTimeout time.Duration
+	WaitForAzureTagsTimeout     time.Duration
 }
 
 // FetchTags loads tags from a variety of sources
 func FetchTags(ctx context.Context, l logger.Logger, conf FetchTagsConfig) []string {
-	f := &tagFetcher{
+	return newTagFetcher(ctx).Fetch(ctx, l, conf)
+}
+
+func newTagFetcher(ctx context.Context) *tagFetcher {
+	return &tagFetcher{
 		k8s: func() (map[string]string, error) {
 			return K8sTagsFromEnv(os.Environ())
 		},
@@ -60,28 +69,52 @@ func FetchTags(ctx context.Context, l logger.Logger, conf FetchTagsConfig) []str
 		gcpLabels: func() (map[string]string, error) {
 			return GCPLabels{}.Get(ctx)
 		},
+		azureMetaDataDefault: func() (map[string]string, error) {
+			return AzureMetaData{}.Get(ctx)
+		},
+		azureTags: func() (map[string]string, error) {
+			return AzureMetaData{}.GetTags(ctx)
+		},
+		gpus: func() (map[string]string, error) {
+			vendor, gpus, err := DetectGPUs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return GPUTags(vendor, gpus), nil
+		},
 	}
-	return f.Fetch(ctx, l, conf)
 }
 
 type tagFetcher struct {
-	k8s                func() (map[string]string, error)
-	ec2MetaDataDefault func() (map[string]string, error)
-	ec2MetaDataPaths   func(map[string]string) (map[string]string, error)
-	ec2Tags            func() (map[string]string, error)
-	ecsMetaDataDefault func() (map[string]string, error)
-	gcpMetaDataDefault func() (map[string]string, error)
-	gcpMetaDataPaths   func(map[string]string) (map[string]string, error)
-	gcpLabels          func() (map[string]string, error)
+	k8s                  func() (map[string]string, error)
+	ec2MetaDataDefault   func() (map[string]string, error)
+	ec2MetaDataPaths     func(map[string]string) (map[string]string, error)
+	ec2Tags              func() (map[string]string, error)
+	ecsMetaDataDefault   func() (map[string]string, error)
+	gcpMetaDataDefault   func() (map[string]string, error)
+	gcpMetaDataPaths     func(map[string]string) (map[string]string, error)
+	gcpLabels            func() (map[string]string, error)
+	azureMetaDataDefault func() (map[string]string, error)
+	azureTags            func() (map[string]string, error)
+	gpus                 func() (map[string]string, error)
 }
 
 func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsConfig) []string {
+	tags, _ := t.fetch(ctx, l, conf)
+	return tags
+}
+
+// fetch loads tags like Fetch, but also returns any errors that prevented tags
+// from being loaded from a source.
+func (t *tagFetcher) fetch(ctx context.Context, l logger.Logger, conf FetchTagsConfig) ([]string, error) {
 	tags := conf.Tags
+	var errs []error
 
 	if conf.TagsFromK8s {
 		k8sTags, err := t.k8s()
 		if err != nil {
 			l.Warn("Could not fetch tags from k8s: %s", err)
+			errs = append(errs, err)
 		}
 		for tag, value := range k8sTags {
 			tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
@@ -93,6 +126,7 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		hostname, err := os.Hostname()
 		if err != nil {
 			l.Warn("Failed to find hostname: %v", err)
+			errs = append(errs, err)
 		}
 
 		tags = append(tags,
@@ -107,6 +141,19 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		}
 	}
 
+	// Load tags describing the host's GPUs
+	if conf.TagsFromGPUs {
+		gpuTags, err := t.gpus()
+		if err != nil {
+			// Don't blow up if we can't find them, just show a nasty error.
+			l.Error("Failed to find GPUs: %v", err)
+			errs = append(errs, err)
+		}
+		for tag, value := range gpuTags {
+			tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
+		}
+	}
+
 	// Attempt to add the default EC2 meta-data tags
 	if conf.TagsFromEC2MetaData {
 		l.Info("Fetching EC2 meta-data...")
@@ -133,6 +180,7 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		// Don't blow up if we can't find them, just show a nasty error.
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to fetch EC2 meta-data: %s", err.Error()))
+			errs = append(errs, err)
 		}
 	}
 
@@ -141,12 +189,14 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		paths, err := parseTagValuePathPairs(conf.TagsFromEC2MetaDataPaths)
 		if err != nil {
 			l.Error(fmt.Sprintf("Error parsing meta-data tag and path pairs: %s", err.Error()))
+			errs = append(errs, err)
 		}
 
 		ec2Tags, err := t.ec2MetaDataPaths(paths)
 		if err != nil {
 			// Don't blow up if we can't find them, just show a nasty error.
 			l.Error(fmt.Sprintf("Failed to fetch EC2 meta-data: %s", err.Error()))
+			errs = append(errs, err)
 		} else {
 			for tag, value := range ec2Tags {
 				tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
@@ -184,6 +234,7 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		// Don't blow up if we can't find them, just show a nasty error.
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to find EC2 tags: %s", err.Error()))
+			errs = append(errs, err)
 		}
 	}
 
@@ -213,6 +264,7 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		// Don't blow up if we can't find them, just show a nasty error.
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to fetch ECS meta-data: %s", err.Error()))
+			errs = append(errs, err)
 		}
 	}
 
@@ -242,6 +294,7 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		// Don't blow up if we can't find them, just show a nasty error.
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to fetch GCP meta-data: %s", err.Error()))
+			errs = append(errs, err)
 		}
 	}
 
@@ -250,12 +303,14 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		paths, err := parseTagValuePathPairs(conf.TagsFromGCPMetaDataPaths)
 		if err != nil {
 			l.Error(fmt.Sprintf("Error parsing meta-data tag and path pairs: %s", err.Error()))
+			errs = append(errs, err)
 		}
 
 		gcpTags, err := t.gcpMetaDataPaths(paths)
 		if err != nil {
 			// Don't blow up if we can't find them, just show a nasty error.
 			l.Error(fmt.Sprintf("Failed to fetch Google Cloud meta-data: %s", err.Error()))
+			errs = append(errs, err)
 		} else {
 			for tag, value := range gcpTags {
 				tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
@@ -290,10 +345,74 @@ func (t *tagFetcher) Fetch(ctx context.Context, l logger.Logger, conf FetchTagsC
 		// Don't blow up if we can't find them, just show a nasty error.
 		if err != nil {
 			l.Error(fmt.Sprintf("Failed to find GCP instance labels: %s", err.Error()))
+			errs = append(errs, err)
 		}
 	}
 
-	return tags
+	// Attempt to add the default Azure meta-data tags
+	if conf.TagsFromAzureMetaData {
+		l.Info("Fetching Azure meta-data...")
+
+		err := roko.NewRetrier(
+			roko.WithMaxAttempts(5),
+			roko.WithStrategy(roko.Constant(conf.WaitForAzureMetaDataTimeout/5)),
+			roko.WithJitter(),
+		).DoWithContext(ctx, func(r *roko.Retrier) error {
+			azureTags, err := t.azureMetaDataDefault()
+			if err != nil {
+				l.Warn("%s (%s)", err, r)
+			} else {
+				l.Info("Successfully fetched Azure meta-data")
+				for tag, value := range azureTags {
+					tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
+				}
+				r.Break()
+			}
+			return err
+		})
+
+		// Don't blow up if we can't find them, just show a nasty error.
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to fetch Azure meta-data: %s", err.Error()))
+			errs = append(errs, err)
+		}
+	}
+
+	// Attempt to add the Azure tags
+	if conf.TagsFromAzureTags {
+		l.Info("Fetching Azure tags...")
+
+		err := roko.NewRetrier(
+			roko.WithMaxAttempts(5),
+			roko.WithStrategy(roko.Constant(conf.WaitForAzureTagsTimeout/5)),
+			roko.WithJitter(),
+		).DoWithContext(ctx, func(r *roko.Retrier) error {
+			azureTags, err := t.azureTags()
+			// Like EC2 tags, Azure tags can take a while to be applied to a
+			// new VM. This error will cause retries.
+			if err == nil && len(azureTags) == 0 {
+				err = errors.New("Azure tags are empty")
+			}
+			if err != nil {
+				l.Warn("%s (%s)", err, r)
+			} else {
+				l.Info("Successfully fetched Azure tags")
+				for tag, value := range azureTags {
+					tags = append(tags, fmt.Sprintf("%s=%s", tag, value))
+				}
+				r.Break()
+			}
+			return err
+		})
+
+		// Don't blow up if we can't find them, just show a nasty error.
+		if err != nil {
+			l.Error(fmt.Sprintf("Failed to find Azure tags: %s", err.Error()))
+			errs = append(errs, err)
+		}
+	}
+
+	return tags, errors.Join(errs...)
 }
 
 func parseTagValuePathPairs(paths []string) (map[string]string, error) {
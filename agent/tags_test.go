@@ -140,6 +140,31 @@ func TestFetchingTagsFromGCP(t *testing.T) {
 		[]string{"llamas", "rock", "gcp:instance-id=my-instance", "gcp:zone=blah", "custom_tag=true"})
 }
 
+func TestFetchingTagsFromAzure(t *testing.T) {
+	fetcher := &tagFetcher{
+		azureMetaDataDefault: func() (map[string]string, error) {
+			return map[string]string{
+				"azure:vm-id":    "02aab8a4-74ef-476e-8182-f6d2ba4166a6",
+				"azure:location": "westus2",
+			}, nil
+		},
+		azureTags: func() (map[string]string, error) {
+			return map[string]string{
+				"custom_tag": "true",
+			}, nil
+		},
+	}
+
+	tags := fetcher.Fetch(context.Background(), logger.Discard, FetchTagsConfig{
+		Tags:                  []string{"llamas", "rock"},
+		TagsFromAzureMetaData: true,
+		TagsFromAzureTags:     true,
+	})
+
+	assert.ElementsMatch(t, tags,
+		[]string{"llamas", "rock", "azure:vm-id=02aab8a4-74ef-476e-8182-f6d2ba4166a6", "azure:location=westus2", "custom_tag=true"})
+}
+
 func TestFetchingTagsFromAllSources(t *testing.T) {
 	fetcher := &tagFetcher{
 		gcpMetaDataDefault: func() (map[string]string, error) {
@@ -165,6 +190,12 @@ func TestFetchingTagsFromAllSources(t *testing.T) {
 			assert.Equal(t, paths, map[string]string{"tag": "some/ec2/value"})
 			return map[string]string{`ec2_metadata_paths`: "true"}, nil
 		},
+		azureMetaDataDefault: func() (map[string]string, error) {
+			return map[string]string{`azure_metadata`: "true"}, nil
+		},
+		azureTags: func() (map[string]string, error) {
+			return map[string]string{`azure_tags`: "true"}, nil
+		},
 	}
 
 	tags := fetcher.Fetch(context.Background(), logger.Discard, FetchTagsConfig{
@@ -177,6 +208,8 @@ func TestFetchingTagsFromAllSources(t *testing.T) {
 		TagsFromECSMetaData:      true,
 		TagsFromEC2MetaDataPaths: []string{"tag=some/ec2/value"},
 		TagsFromEC2Tags:          true,
+		TagsFromAzureMetaData:    true,
+		TagsFromAzureTags:        true,
 	})
 
 	hostname, err := os.Hostname()
@@ -193,6 +226,24 @@ func TestFetchingTagsFromAllSources(t *testing.T) {
 	assert.Contains(t, tags, "ec2_metadata=true")
 	assert.Contains(t, tags, "ecs_metadata=true")
 	assert.Contains(t, tags, "ec2_metadata_paths=true")
+	assert.Contains(t, tags, "azure_metadata=true")
+	assert.Contains(t, tags, "azure_tags=true")
 	assert.Contains(t, tags, "hostname="+hostname)
 	assert.Contains(t, tags, "os="+runtime.GOOS)
 }
+
+func TestFetchingTagsFromGPUs(t *testing.T) {
+	fetcher := &tagFetcher{
+		gpus: func() (map[string]string, error) {
+			return GPUTags(GPUVendorNVIDIA, []GPU{{Index: 0, Model: "NVIDIA L4"}}), nil
+		},
+	}
+
+	tags := fetcher.Fetch(context.Background(), logger.Discard, FetchTagsConfig{
+		Tags:         []string{"llamas"},
+		TagsFromGPUs: true,
+	})
+
+	assert.ElementsMatch(t, tags,
+		[]string{"llamas", "gpu_vendor=nvidia", "gpu_count=1", "gpu_model=NVIDIA L4"})
+}
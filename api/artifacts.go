@@ -48,6 +48,10 @@ type Artifact struct {
 
 	// A specific Content-Type to use on upload
 	ContentType string `json:"content_type,omitempty"`
+
+	// Key/value tags given when the artifact was uploaded. Not confirmed to
+	// be stored or returned by Buildkite (see artifact.ErrNoArtifactMetadata).
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 type ArtifactBatch struct {
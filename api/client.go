@@ -30,7 +30,8 @@ const (
 // Config is configuration for the API Client
 type Config struct {
 	// Endpoint for API requests. Defaults to the public Buildkite Agent API.
-	// The URL should always be specified with a trailing slash.
+	// The URL should always be specified with a trailing slash. Several
+	// endpoints can be separated by commas, to fail over between them.
 	Endpoint string
 
 	// The authentication token to use, either a registration or access token
@@ -96,6 +97,15 @@ func NewClient(l logger.Logger, conf Config) *Client {
 	}
 }
 
+// primaryEndpoint returns the first of the client's endpoints, which requests
+// are made to unless it's unhealthy.
+func (c *Client) primaryEndpoint() string {
+	if endpoints := splitEndpoints(c.conf.Endpoint); len(endpoints) > 0 {
+		return endpoints[0]
+	}
+	return c.conf.Endpoint
+}
+
 // Config returns the internal configuration for the Client
 func (c *Client) Config() Config {
 	return c.conf
@@ -111,7 +121,7 @@ func (c *Client) FromAgentRegisterResponse(resp *AgentRegisterResponse) *Client
 
 	// If Buildkite told us to use a new Endpoint, respect that
 	if resp.Endpoint != "" {
-		conf.Endpoint = resp.Endpoint
+		conf.Endpoint = replacePrimaryEndpoint(conf.Endpoint, resp.Endpoint)
 	}
 
 	return NewClient(c.logger, conf)
@@ -123,7 +133,7 @@ func (c *Client) FromPing(resp *Ping) *Client {
 
 	// If Buildkite told us to use a new Endpoint, respect that
 	if resp.Endpoint != "" {
-		conf.Endpoint = resp.Endpoint
+		conf.Endpoint = replacePrimaryEndpoint(conf.Endpoint, resp.Endpoint)
 	}
 
 	return NewClient(c.logger, conf)
@@ -145,7 +155,7 @@ func (c *Client) newRequest(
 	body any,
 	headers ...Header,
 ) (*http.Request, error) {
-	u := joinURLPath(c.conf.Endpoint, urlStr)
+	u := joinURLPath(c.primaryEndpoint(), urlStr)
 
 	buf := new(bytes.Buffer)
 	if body != nil {
@@ -187,7 +197,7 @@ func (c *Client) newRequest(
 // of the Client. Relative URLs should always be specified without a preceding
 // slash.
 func (c *Client) newFormRequest(ctx context.Context, method, urlStr string, body *bytes.Buffer) (*http.Request, error) {
-	u := joinURLPath(c.conf.Endpoint, urlStr)
+	u := joinURLPath(c.primaryEndpoint(), urlStr)
 
 	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
@@ -219,7 +229,11 @@ func newResponse(r *http.Response) *Response {
 // interface, the raw response body will be written to v, without attempting to
 // first decode it.
 func (c *Client) doRequest(req *http.Request, v any) (*Response, error) {
+	return c.doRequestWithFailover(req, v)
+}
 
+// doRequestOnce sends the request to the endpoint it's for.
+func (c *Client) doRequestOnce(req *http.Request, v any) (*Response, error) {
 	resp, err := agenthttp.Do(c.logger, c.client, req,
 		agenthttp.WithDebugHTTP(c.conf.DebugHTTP),
 		agenthttp.WithTraceHTTP(c.conf.TraceHTTP),
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config.Endpoint can list several endpoints, separated by commas, such as
+// regional or proxied routes to the Agent API. Requests go to the first
+// healthy endpoint, and fail over to the others if it can't be reached or, for
+// idempotent requests, is unavailable. Endpoints that fail are marked unhealthy, and aren't preferred
+// again until they have backed off for a while.
+const (
+	endpointBackoffBase = 5 * time.Second
+	endpointBackoffMax  = 5 * time.Minute
+)
+
+// splitEndpoints returns the endpoints in a (possibly comma-separated)
+// endpoint, in order of preference.
+func splitEndpoints(endpoint string) []string {
+	var endpoints []string
+	for _, e := range strings.Split(endpoint, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// replacePrimaryEndpoint returns the (possibly comma-separated) endpoint with
+// the first endpoint replaced, for when Buildkite says to use a different
+// endpoint. The others remain as alternatives.
+func replacePrimaryEndpoint(endpoint, primary string) string {
+	endpoints := splitEndpoints(endpoint)
+	if len(endpoints) == 0 {
+		return primary
+	}
+	endpoints[0] = primary
+	return strings.Join(slices.Compact(endpoints), ",")
+}
+
+type endpointState struct {
+	failures int
+	retryAt  time.Time
+}
+
+// endpointHealth tracks endpoints that have failed. It is shared by all
+// clients, as clients are recreated for new tokens and endpoints, and an
+// endpoint that's down for one is down for all of them.
+type endpointHealth struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	states map[string]*endpointState
+}
+
+var health = &endpointHealth{now: time.Now}
+
+// failed marks the endpoint unhealthy, and backs it off exponentially.
+func (h *endpointHealth) failed(endpoint string) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.states == nil {
+		h.states = make(map[string]*endpointState)
+	}
+	s := h.states[endpoint]
+	if s == nil {
+		s = &endpointState{}
+		h.states[endpoint] = s
+	}
+	s.failures++
+	backoff := endpointBackoffMax
+	if s.failures <= 10 {
+		backoff = min(endpointBackoffBase<<(s.failures-1), endpointBackoffMax)
+	}
+	s.retryAt = h.now().Add(backoff)
+	return backoff
+}
+
+// succeeded marks the endpoint healthy.
+func (h *endpointHealth) succeeded(endpoint string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.states, endpoint)
+}
+
+// order returns the endpoints in the order to try them: healthy endpoints
+// (including those that have finished backing off) in their original order,
+// then the rest in the order they'll finish backing off.
+func (h *endpointHealth) order(endpoints []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+
+	ordered := slices.Clone(endpoints)
+	retryAt := func(e string) time.Time {
+		if s := h.states[e]; s != nil && s.retryAt.After(now) {
+			return s.retryAt
+		}
+		return time.Time{}
+	}
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		return retryAt(a).Compare(retryAt(b))
+	})
+	return ordered
+}
+
+// shouldFailover reports whether a request that got the response or error
+// might succeed at another endpoint. Requests that aren't idempotent, such as
+// POSTs, are only tried again if they were never sent, since the endpoint may
+// have acted on them even though it failed.
+func shouldFailover(req *http.Request, resp *Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if !isIdempotent(req.Method) {
+		return resp == nil && notSent(err)
+	}
+	if resp == nil {
+		// Couldn't connect, or the connection failed
+		return err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isIdempotent reports whether requests with the method can be made more than
+// once with the same effect as making them once (RFC 9110, section 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// notSent reports whether err means the request never reached the endpoint,
+// because its name couldn't be resolved, or it couldn't be connected to.
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// withEndpoint returns a copy of the request, to the same path at a different
+// endpoint.
+func withEndpoint(req *http.Request, endpoint, path string) (*http.Request, error) {
+	u, err := url.Parse(joinURLPath(endpoint, path))
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	r := req.Clone(req.Context())
+	r.URL = u
+	r.Host = ""
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// doRequestWithFailover sends the request to the healthiest of the client's
+// endpoints, failing over to the others as needed.
+func (c *Client) doRequestWithFailover(req *http.Request, v any) (*Response, error) {
+	endpoints := splitEndpoints(c.conf.Endpoint)
+	if len(endpoints) < 2 {
+		return c.doRequestOnce(req, v)
+	}
+
+	// Requests are made to the primary endpoint, so find the path relative to
+	// it
+	reqURL := req.URL.String()
+	primary := strings.TrimRight(endpoints[0], "/") + "/"
+	path, ok := strings.CutPrefix(reqURL, primary)
+	if !ok {
+		return c.doRequestOnce(req, v)
+	}
+
+	var (
+		resp *Response
+		err  error
+	)
+	ordered := health.order(endpoints)
+	for i, endpoint := range ordered {
+		r, rerr := withEndpoint(req, endpoint, path)
+		if rerr != nil {
+			return nil, rerr
+		}
+
+		resp, err = c.doRequestOnce(r, v)
+		if !shouldFailover(r, resp, err) {
+			if err == nil || resp != nil {
+				health.succeeded(endpoint)
+			}
+			return resp, err
+		}
+
+		backoff := health.failed(endpoint)
+		if i+1 < len(ordered) {
+			c.logger.Warn("Buildkite API endpoint %s failed (%v), trying %s instead. It won't be preferred for %v",
+				endpoint, failureReason(resp, err), ordered[i+1], backoff)
+		}
+	}
+	return resp, err
+}
+
+func failureReason(resp *Response, err error) string {
+	if resp != nil {
+		return resp.Status
+	}
+	return err.Error()
+}
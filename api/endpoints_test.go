@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitEndpoints(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		endpoint string
+		want     []string
+	}{
+		{"", nil},
+		{"https://agent.buildkite.com/v3", []string{"https://agent.buildkite.com/v3"}},
+		{
+			endpoint: "https://agent.buildkite.com/v3, https://proxy.example.com/v3,,",
+			want:     []string{"https://agent.buildkite.com/v3", "https://proxy.example.com/v3"},
+		},
+	} {
+		if diff := cmp.Diff(splitEndpoints(test.endpoint), test.want); diff != "" {
+			t.Errorf("splitEndpoints(%q) diff (-got +want):\n%s", test.endpoint, diff)
+		}
+	}
+}
+
+func TestReplacePrimaryEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		endpoint, primary, want string
+	}{
+		{"", "https://a.example.com/v3", "https://a.example.com/v3"},
+		{"https://b.example.com/v3", "https://a.example.com/v3", "https://a.example.com/v3"},
+		{"https://b.example.com/v3,https://c.example.com/v3", "https://a.example.com/v3", "https://a.example.com/v3,https://c.example.com/v3"},
+		{"https://b.example.com/v3,https://a.example.com/v3", "https://a.example.com/v3", "https://a.example.com/v3"},
+	} {
+		if got := replacePrimaryEndpoint(test.endpoint, test.primary); got != test.want {
+			t.Errorf("replacePrimaryEndpoint(%q, %q) = %q, want %q", test.endpoint, test.primary, got, test.want)
+		}
+	}
+}
+
+func TestEndpointHealthOrder(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	h := &endpointHealth{now: func() time.Time { return now }}
+	endpoints := []string{"a", "b", "c"}
+
+	h.failed("a")
+	h.failed("a") // backs off for longer than b
+	h.failed("b")
+	if diff := cmp.Diff(h.order(endpoints), []string{"c", "b", "a"}); diff != "" {
+		t.Errorf("h.order(%q) after failures diff (-got +want):\n%s", endpoints, diff)
+	}
+
+	// b finishes backing off first
+	now = now.Add(endpointBackoffBase)
+	if diff := cmp.Diff(h.order(endpoints), []string{"b", "c", "a"}); diff != "" {
+		t.Errorf("h.order(%q) after b backs off diff (-got +want):\n%s", endpoints, diff)
+	}
+
+	h.succeeded("a")
+	if diff := cmp.Diff(h.order(endpoints), []string{"a", "b", "c"}); diff != "" {
+		t.Errorf("h.order(%q) after a succeeds diff (-got +want):\n%s", endpoints, diff)
+	}
+
+	for range 20 {
+		if got := h.failed("c"); got > endpointBackoffMax {
+			t.Fatalf("h.failed(c) = %v, want at most %v", got, endpointBackoffMax)
+		}
+	}
+}
+
+func TestClientFailsOverBetweenEndpoints(t *testing.T) {
+	t.Parallel()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	var unavailableHits atomic.Int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		unavailableHits.Add(1)
+		http.Error(rw, `{"message":"down for maintenance"}`, http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || req.URL.Path != "/v3/jobs/job-1" {
+			http.Error(rw, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(rw, `{"state":"running"}`)
+	}))
+	defer healthy.Close()
+
+	c := NewClient(logger.Discard, Config{
+		Endpoint: down.URL + "/v3," + unavailable.URL + "/v3," + healthy.URL + "/v3",
+		Token:    "llamas",
+	})
+
+	ctx := context.Background()
+	for i := range 2 {
+		state, _, err := c.GetJobState(ctx, "job-1")
+		if err != nil {
+			t.Fatalf("c.GetJobState(ctx, job-1) #%d error = %v", i, err)
+		}
+		if got, want := state.State, "running"; got != want {
+			t.Errorf("c.GetJobState(ctx, job-1) #%d state.State = %q, want %q", i, got, want)
+		}
+	}
+
+	// The second request should go straight to the healthy endpoint
+	if got, want := unavailableHits.Load(), int32(1); got != want {
+		t.Errorf("requests to the unavailable endpoint = %d, want %d", got, want)
+	}
+}
+
+func TestClientOnlyFailsOverPostsThatWerentSent(t *testing.T) {
+	t.Parallel()
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, `{"message":"down for maintenance"}`, http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	var healthyHits atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		healthyHits.Add(1)
+		var regReq AgentRegisterRequest
+		if err := json.NewDecoder(req.Body).Decode(&regReq); err != nil || regReq.Name != "agent-1" {
+			http.Error(rw, fmt.Sprintf(`{"message":"bad request body: %v"}`, err), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(rw, `{"name":"agent-1"}`)
+	}))
+	defer healthy.Close()
+
+	ctx := context.Background()
+
+	// Couldn't connect, so the request can go to the next endpoint
+	c := NewClient(logger.Discard, Config{
+		Endpoint: down.URL + "/v3," + healthy.URL + "/v3",
+		Token:    "llamas",
+	})
+	if _, _, err := c.Register(ctx, &AgentRegisterRequest{Name: "agent-1"}); err != nil {
+		t.Errorf("c.Register(ctx, {Name: agent-1}) with the first endpoint down error = %v", err)
+	}
+
+	// The request reached the endpoint, which may have acted on it
+	c = NewClient(logger.Discard, Config{
+		Endpoint: unavailable.URL + "/v3," + healthy.URL + "/v3",
+		Token:    "llamas",
+	})
+	if _, _, err := c.Register(ctx, &AgentRegisterRequest{Name: "agent-1"}); err == nil {
+		t.Errorf("c.Register(ctx, {Name: agent-1}) with the first endpoint unavailable error = nil, want an error")
+	}
+
+	if got, want := healthyHits.Load(), int32(1); got != want {
+		t.Errorf("requests to the healthy endpoint = %d, want %d", got, want)
+	}
+}
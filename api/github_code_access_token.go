@@ -15,14 +15,20 @@ type GithubCodeAccessTokenRequest struct {
 
 type GithubCodeAccessTokenResponse struct {
 	Token string `json:"token,omitempty"`
+
+	// ExpiresAt is when the token expires. It is zero if Buildkite didn't say.
+	//
+	// Note: expires_at hasn't been confirmed against the Buildkite API, so
+	// callers need to cope with it being missing.
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func (c *Client) GenerateGithubCodeAccessToken(ctx context.Context, repoURL, jobID string) (string, *Response, error) {
+func (c *Client) GenerateGithubCodeAccessToken(ctx context.Context, jobID string, ghReq *GithubCodeAccessTokenRequest) (*GithubCodeAccessTokenResponse, *Response, error) {
 	u := fmt.Sprintf("jobs/%s/github_code_access_token", railsPathEscape(jobID))
 
-	req, err := c.newRequest(ctx, http.MethodPost, u, GithubCodeAccessTokenRequest{RepoURL: repoURL})
+	req, err := c.newRequest(ctx, http.MethodPost, u, ghReq)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
 
 	r := roko.NewRetrier(
@@ -56,8 +62,8 @@ func (c *Client) GenerateGithubCodeAccessToken(ctx context.Context, repoURL, job
 	})
 
 	if err != nil {
-		return "", resp, err
+		return nil, resp, err
 	}
 
-	return g.Token, resp, nil
+	return &g, resp, nil
 }
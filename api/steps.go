@@ -41,6 +41,21 @@ type StepUpdate struct {
 	Attribute       string `json:"attribute,omitempty"`
 	Value           string `json:"value,omitempty"`
 	Append          bool   `json:"append,omitempty"`
+
+	// Updates changes several attributes in the one request, instead of
+	// Attribute, Value and Append.
+	//
+	// Note: batched updates haven't been confirmed against the Buildkite API.
+	// A server that doesn't support them will reject the request, as it has
+	// no attribute, rather than apply some of the changes.
+	Updates []StepAttributeUpdate `json:"updates,omitempty"`
+}
+
+// StepAttributeUpdate is a change to one attribute in a batched StepUpdate
+type StepAttributeUpdate struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	Append    bool   `json:"append,omitempty"`
 }
 
 // StepUpdate updates a step
@@ -18,17 +18,21 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/agent/plugin"
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/core"
 	"github.com/buildkite/agent/v3/internal/agentapi"
 	"github.com/buildkite/agent/v3/internal/awslib"
 	awssigner "github.com/buildkite/agent/v3/internal/cryptosigner/aws"
 	"github.com/buildkite/agent/v3/internal/experiments"
+	"github.com/buildkite/agent/v3/internal/housekeeping"
+	"github.com/buildkite/agent/v3/internal/job"
 	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/internal/osutil"
 	"github.com/buildkite/agent/v3/internal/shell"
@@ -39,6 +43,7 @@ import (
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/agent/v3/version"
 	"github.com/buildkite/shellwords"
+	"github.com/dustin/go-humanize"
 	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/urfave/cli"
 	"golang.org/x/exp/maps"
@@ -88,6 +93,9 @@ type AgentStartConfig struct {
 	RedactedVars      []string `cli:"redacted-vars" normalize:"list"`
 	CancelSignal      string   `cli:"cancel-signal"`
 
+	MaxConcurrentJobs       int      `cli:"max-concurrent-jobs"`
+	MaxConcurrentJobsPerTag []string `cli:"max-concurrent-jobs-per-tag" normalize:"list"`
+
 	SigningJWKSKeyID string `cli:"signing-jwks-key-id"`
 
 	SigningJWKSFile  string `cli:"signing-jwks-file" normalize:"filepath"`
@@ -97,14 +105,29 @@ type AgentStartConfig struct {
 	VerificationJWKSFile        string `cli:"verification-jwks-file" normalize:"filepath"`
 	VerificationFailureBehavior string `cli:"verification-failure-behavior"`
 
-	AcquireJob                 string `cli:"acquire-job"`
-	DisconnectAfterJob         bool   `cli:"disconnect-after-job"`
-	DisconnectAfterIdleTimeout int    `cli:"disconnect-after-idle-timeout"`
-	CancelGracePeriod          int    `cli:"cancel-grace-period"`
-	SignalGracePeriodSeconds   int    `cli:"signal-grace-period-seconds"`
+	AcquireJob                 string        `cli:"acquire-job"`
+	AcquireJobs                []string      `cli:"acquire-jobs" normalize:"list"`
+	DisconnectAfterJob         bool          `cli:"disconnect-after-job"`
+	DisconnectAfterIdleTimeout int           `cli:"disconnect-after-idle-timeout"`
+	CancelGracePeriod          int           `cli:"cancel-grace-period"`
+	SignalGracePeriodSeconds   int           `cli:"signal-grace-period-seconds"`
+	HookTimeout                time.Duration `cli:"hook-timeout"`
+	PreemptionNotices          []string      `cli:"preemption-notices" normalize:"list"`
 
 	EnableJobLogTmpfile bool   `cli:"enable-job-log-tmpfile"`
 	JobLogPath          string `cli:"job-log-path" normalize:"filepath"`
+	JobLogFormat        string `cli:"job-log-format"`
+	PhaseTimings        string `cli:"phase-timings"`
+	DiagnosticsBundle   bool   `cli:"diagnostics-bundle"`
+	JobLogSpoolPath     string `cli:"job-log-spool-path" normalize:"filepath"`
+	JobLogSpoolMaxSize  string `cli:"job-log-spool-max-size"`
+
+	LogUploadMaxBandwidth string `cli:"log-upload-max-bandwidth"`
+	MaxJobLogSize         string `cli:"max-job-log-size"`
+
+	JobCgroupParent string `cli:"job-cgroup-parent"`
+	JobMemoryLimit  string `cli:"job-memory-limit"`
+	JobCPULimit     string `cli:"job-cpu-limit"`
 
 	LogFormat            string   `cli:"log-format"`
 	WriteJobLogsToStdout bool     `cli:"write-job-logs-to-stdout"`
@@ -115,41 +138,80 @@ type AgentStartConfig struct {
 	AdditionalHooksPaths []string `cli:"additional-hooks-paths" normalize:"list"`
 	SocketsPath          string   `cli:"sockets-path" normalize:"filepath"`
 	PluginsPath          string   `cli:"plugins-path" normalize:"filepath"`
+	PluginLockFile       string   `cli:"plugin-lock-file" normalize:"filepath"`
+	PluginsAllowDrift    bool     `cli:"plugins-allow-drift"`
 
 	Shell           string `cli:"shell"`
 	BootstrapScript string `cli:"bootstrap-script" normalize:"commandpath"`
 	NoPTY           bool   `cli:"no-pty"`
-
-	NoANSITimestamps bool `cli:"no-ansi-timestamps"`
-	TimestampLines   bool `cli:"timestamp-lines"`
-
-	Queue                     string   `cli:"queue"`
-	Tags                      []string `cli:"tags" normalize:"list"`
-	TagsFromEC2MetaData       bool     `cli:"tags-from-ec2-meta-data"`
-	TagsFromEC2MetaDataPaths  []string `cli:"tags-from-ec2-meta-data-paths" normalize:"list"`
-	TagsFromEC2Tags           bool     `cli:"tags-from-ec2-tags"`
-	TagsFromECSMetaData       bool     `cli:"tags-from-ecs-meta-data"`
-	TagsFromGCPMetaData       bool     `cli:"tags-from-gcp-meta-data"`
-	TagsFromGCPMetaDataPaths  []string `cli:"tags-from-gcp-meta-data-paths" normalize:"list"`
-	TagsFromGCPLabels         bool     `cli:"tags-from-gcp-labels"`
-	TagsFromHost              bool     `cli:"tags-from-host"`
-	WaitForEC2TagsTimeout     string   `cli:"wait-for-ec2-tags-timeout"`
-	WaitForEC2MetaDataTimeout string   `cli:"wait-for-ec2-meta-data-timeout"`
-	WaitForECSMetaDataTimeout string   `cli:"wait-for-ecs-meta-data-timeout"`
-	WaitForGCPLabelsTimeout   string   `cli:"wait-for-gcp-labels-timeout"`
-
+	PTYRows         int    `cli:"pty-rows"`
+	PTYCols         int    `cli:"pty-cols"`
+
+	NoANSITimestamps     bool   `cli:"no-ansi-timestamps"`
+	TimestampLines       bool   `cli:"timestamp-lines"`
+	TimestampLinesFormat string `cli:"timestamp-lines-format"`
+
+	CollapseInteractiveOutput bool `cli:"collapse-interactive-output"`
+
+	Queue                       string   `cli:"queue"`
+	Tags                        []string `cli:"tags" normalize:"list"`
+	TagsFromEC2MetaData         bool     `cli:"tags-from-ec2-meta-data"`
+	TagsFromEC2MetaDataPaths    []string `cli:"tags-from-ec2-meta-data-paths" normalize:"list"`
+	TagsFromEC2Tags             bool     `cli:"tags-from-ec2-tags"`
+	TagsFromECSMetaData         bool     `cli:"tags-from-ecs-meta-data"`
+	TagsFromGCPMetaData         bool     `cli:"tags-from-gcp-meta-data"`
+	TagsFromGCPMetaDataPaths    []string `cli:"tags-from-gcp-meta-data-paths" normalize:"list"`
+	TagsFromGCPLabels           bool     `cli:"tags-from-gcp-labels"`
+	TagsFromAzureMetaData       bool     `cli:"tags-from-azure-meta-data"`
+	TagsFromAzureTags           bool     `cli:"tags-from-azure-tags"`
+	TagsFromHost                bool     `cli:"tags-from-host"`
+	TagsFromGPUs                bool     `cli:"tags-from-gpus"`
+	AllocateGPUs                bool     `cli:"allocate-gpus"`
+	WaitForEC2TagsTimeout       string   `cli:"wait-for-ec2-tags-timeout"`
+	WaitForEC2MetaDataTimeout   string   `cli:"wait-for-ec2-meta-data-timeout"`
+	WaitForECSMetaDataTimeout   string   `cli:"wait-for-ecs-meta-data-timeout"`
+	WaitForGCPLabelsTimeout     string   `cli:"wait-for-gcp-labels-timeout"`
+	WaitForAzureMetaDataTimeout string   `cli:"wait-for-azure-meta-data-timeout"`
+	WaitForAzureTagsTimeout     string   `cli:"wait-for-azure-tags-timeout"`
+
+	TagsRefreshInterval time.Duration `cli:"tags-refresh-interval"`
+
+	VCS                   string `cli:"vcs"`
 	GitCheckoutFlags      string `cli:"git-checkout-flags"`
 	GitCloneFlags         string `cli:"git-clone-flags"`
+	GitCloneBundle        string `cli:"git-clone-bundle"`
 	GitCloneMirrorFlags   string `cli:"git-clone-mirror-flags"`
 	GitCleanFlags         string `cli:"git-clean-flags"`
 	GitFetchFlags         string `cli:"git-fetch-flags"`
 	GitMirrorsPath        string `cli:"git-mirrors-path" normalize:"filepath"`
 	GitMirrorsLockTimeout int    `cli:"git-mirrors-lock-timeout"`
 	GitMirrorsSkipUpdate  bool   `cli:"git-mirrors-skip-update"`
+	GitMirrorsWorktree    bool   `cli:"git-mirrors-worktree"`
+	EphemeralBuildDir     bool   `cli:"ephemeral-build-dir"`
+	GitMirrorsMaxSize     string `cli:"git-mirrors-max-size"`
+	BuildPathMaxSize      string `cli:"build-path-max-size"`
+	DiskFreeMinimum       string `cli:"disk-free-minimum"`
 	NoGitSubmodules       bool   `cli:"no-git-submodules"`
 
+	GitMirrorsMaintenanceInterval time.Duration `cli:"git-mirrors-maintenance-interval"`
+	GitMirrorsMaintenanceMinSize  string        `cli:"git-mirrors-maintenance-min-size"`
+
+	GitSubmoduleCloneFlags  []string `cli:"git-submodule-clone-flags" normalize:"list"`
+	GitSubmoduleCredentials []string `cli:"git-submodule-credentials" normalize:"list"`
+
+	CheckoutRetryAttempts int           `cli:"checkout-retry-attempts"`
+	CheckoutRetryBackoff  time.Duration `cli:"checkout-retry-backoff"`
+
 	NoSSHKeyscan        bool     `cli:"no-ssh-keyscan"`
 	NoCommandEval       bool     `cli:"no-command-eval"`
+	CommandSandbox      string   `cli:"command-sandbox"`
+	CommandSandboxAllow []string `cli:"command-sandbox-allow-paths" normalize:"list"`
+	CommandEnvAllow     []string `cli:"command-env-allow" normalize:"list"`
+	CommandEnvDeny      []string `cli:"command-env-deny" normalize:"list"`
+	SoftFailExitStatus  []string `cli:"soft-fail-exit-status" normalize:"list"`
+	SoftFailClassifier  string   `cli:"soft-fail-classifier" normalize:"filepath"`
+	ProtectedEnv        []string `cli:"protected-env" normalize:"list"`
+	ProtectedEnvEnforce bool     `cli:"protected-env-enforce"`
 	NoLocalHooks        bool     `cli:"no-local-hooks"`
 	NoPlugins           bool     `cli:"no-plugins"`
 	NoPluginValidation  bool     `cli:"no-plugin-validation"`
@@ -165,8 +227,10 @@ type AgentStartConfig struct {
 	MetricsDatadog              bool   `cli:"metrics-datadog"`
 	MetricsDatadogHost          string `cli:"metrics-datadog-host"`
 	MetricsDatadogDistributions bool   `cli:"metrics-datadog-distributions"`
+	MetricsOTLPEndpoint         string `cli:"metrics-otlp-endpoint"`
 	TracingBackend              string `cli:"tracing-backend"`
 	TracingServiceName          string `cli:"tracing-service-name"`
+	TracingHookOutputLimit      int    `cli:"tracing-hook-output-limit"`
 
 	// Global flags
 	Debug                     bool     `cli:"debug"`
@@ -175,16 +239,23 @@ type AgentStartConfig struct {
 	Experiments               []string `cli:"experiment" normalize:"list"`
 	Profile                   string   `cli:"profile"`
 	StrictSingleHooks         bool     `cli:"strict-single-hooks"`
+	HookInterpreters          []string `cli:"hook-interpreters" normalize:"list"`
 	KubernetesExec            bool     `cli:"kubernetes-exec"`
 	TraceContextEncoding      string   `cli:"trace-context-encoding"`
 	NoMultipartArtifactUpload bool     `cli:"no-multipart-artifact-upload"`
 
+	ArtifactEncryptionKeyFile   string `cli:"artifact-encryption-key-file" normalize:"filepath"`
+	ArtifactEncryptionAWSKMSKey string `cli:"artifact-encryption-aws-kms-key"`
+
 	// API config
-	DebugHTTP bool   `cli:"debug-http"`
-	TraceHTTP bool   `cli:"trace-http"`
-	Token     string `cli:"token" validate:"required"`
-	Endpoint  string `cli:"endpoint" validate:"required"`
-	NoHTTP2   bool   `cli:"no-http2"`
+	DebugHTTP  bool   `cli:"debug-http"`
+	TraceHTTP  bool   `cli:"trace-http"`
+	Token      string `cli:"token" validate:"required"`
+	Endpoint   string `cli:"endpoint" validate:"required"`
+	NoHTTP2    bool   `cli:"no-http2"`
+	ProxyURL   string `cli:"proxy-url"`
+	NoProxy    string `cli:"no-proxy"`
+	CACertFile string `cli:"ca-cert-file" normalize:"filepath"`
 
 	// Deprecated
 	NoSSHFingerprintVerification bool     `cli:"no-automatic-ssh-fingerprint-verification" deprecated-and-renamed-to:"NoSSHKeyscan"`
@@ -197,6 +268,12 @@ type AgentStartConfig struct {
 	DisconnectAfterJobTimeout    int      `cli:"disconnect-after-job-timeout" deprecated:"Use disconnect-after-idle-timeout instead"`
 }
 
+// acquiring reports whether the agent is being started to run specific jobs,
+// rather than ones Buildkite dispatches to it.
+func (asc AgentStartConfig) acquiring() bool {
+	return asc.AcquireJob != "" || len(asc.AcquireJobs) > 0
+}
+
 func (asc AgentStartConfig) Features(ctx context.Context) []string {
 	if asc.NoFeatureReporting {
 		return []string{}
@@ -208,7 +285,7 @@ func (asc AgentStartConfig) Features(ctx context.Context) []string {
 		features = append(features, "git-mirrors")
 	}
 
-	if asc.AcquireJob != "" {
+	if asc.acquiring() {
 		features = append(features, "acquire-job")
 	}
 
@@ -329,6 +406,12 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Start this agent and only run the specified job, disconnecting after it's finished",
 			EnvVar: "BUILDKITE_AGENT_ACQUIRE_JOB",
 		},
+		cli.StringSliceFlag{
+			Name:   "acquire-jobs",
+			Value:  &cli.StringSlice{},
+			Usage:  "Start this agent and only run the specified jobs, one after another, disconnecting after they've all finished. Specify ′-′ to read the job IDs from standard input, separated by commas or whitespace",
+			EnvVar: "BUILDKITE_AGENT_ACQUIRE_JOBS",
+		},
 		cli.BoolFlag{
 			Name:   "disconnect-after-job",
 			Usage:  "Disconnect the agent after running exactly one job. When used in conjunction with the ′--spawn′ flag, each worker booted will run exactly one job",
@@ -341,6 +424,12 @@ var AgentStartCommand = cli.Command{
 			EnvVar: "BUILDKITE_AGENT_DISCONNECT_AFTER_IDLE_TIMEOUT",
 		},
 		cancelGracePeriodFlag,
+		cli.StringSliceFlag{
+			Name:   "preemption-notices",
+			Value:  &cli.StringSlice{},
+			Usage:  "Watch for notice that the instance is about to be preempted, from any of ec2 (spot instance interruptions), gcp (preemption), and azure (scheduled Preempt and Terminate events). On notice, the agent stops accepting jobs and cancels running jobs, which finish with the signal reason agent_stop. They're only retried if their automatic retry rules match that signal reason",
+			EnvVar: "BUILDKITE_AGENT_PREEMPTION_NOTICES",
+		},
 		cli.BoolFlag{
 			Name:   "enable-job-log-tmpfile",
 			Usage:  "Store the job logs in a temporary file ′BUILDKITE_JOB_LOG_TMPFILE′ that is accessible during the job and removed at the end of the job",
@@ -351,6 +440,64 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Location to store job logs created by configuring ′enable-job-log-tmpfile`, by default job log will be stored in TempDir",
 			EnvVar: "BUILDKITE_JOB_LOG_PATH",
 		},
+		cli.StringFlag{
+			Name:   "job-log-spool-path",
+			Usage:  "Location to keep a copy of the tail of each running job's log. If the agent stops unexpectedly, the next agent to start with the same spool path uploads it to the job",
+			EnvVar: "BUILDKITE_JOB_LOG_SPOOL_PATH",
+		},
+		cli.StringFlag{
+			Name:   "job-log-spool-max-size",
+			Value:  "1MiB",
+			Usage:  "The maximum amount of each job's log to keep in the job log spool (e.g. \"1MiB\")",
+			EnvVar: "BUILDKITE_JOB_LOG_SPOOL_MAX_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "log-upload-max-bandwidth",
+			Value:  "",
+			Usage:  "The maximum rate at which to upload each job's log, in bytes per second (e.g. \"512KiB\"). By default there is no limit",
+			EnvVar: "BUILDKITE_LOG_UPLOAD_MAX_BANDWIDTH",
+		},
+		cli.StringFlag{
+			Name:   "max-job-log-size",
+			Value:  "",
+			Usage:  "The maximum size of each job's log (e.g. \"100MiB\"). Beyond this, only group headers are uploaded, followed by the end of the log when the job finishes. By default there is no limit",
+			EnvVar: "BUILDKITE_MAX_JOB_LOG_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "job-memory-limit",
+			Value:  "",
+			Usage:  "The most memory each job's processes can use between them (e.g. \"4GiB\"). Jobs are run in their own cgroup to enforce it, which needs Linux with cgroup v2, and the peak memory used is written to the end of the job log. By default there is no limit",
+			EnvVar: "BUILDKITE_JOB_MEMORY_LIMIT",
+		},
+		cli.StringFlag{
+			Name:   "job-cpu-limit",
+			Value:  "",
+			Usage:  "How many CPUs each job's processes can use between them (e.g. \"1.5\"). Jobs are run in their own cgroup to enforce it, which needs Linux with cgroup v2, and the CPU time used is written to the end of the job log. By default there is no limit",
+			EnvVar: "BUILDKITE_JOB_CPU_LIMIT",
+		},
+		cli.StringFlag{
+			Name:   "job-cgroup-parent",
+			Value:  "",
+			Usage:  "The cgroup v2 path (e.g. \"/buildkite.slice\") to create each job's cgroup in, when --job-memory-limit or --job-cpu-limit is set. It needs the memory and cpu controllers delegated to the agent. Defaults to the agent's own cgroup, in which case the agent moves itself into a buildkite-agent cgroup within it",
+			EnvVar: "BUILDKITE_JOB_CGROUP_PARENT",
+		},
+		cli.StringFlag{
+			Name:   "job-log-format",
+			Usage:  "The format of job output: 'text', or 'json' to write each line as a JSON object with a timestamp, the milliseconds since the job started and since the previous line, stream, phase, hook and group",
+			EnvVar: "BUILDKITE_JOB_LOG_FORMAT",
+			Value:  "text",
+		},
+		cli.StringFlag{
+			Name:   "phase-timings",
+			Value:  "",
+			Usage:  "Record how long each phase of each job took once it finishes: ′meta-data′ to record them in build meta-data under ′buildkite:timings:<job id>′, or ′annotation′ to also show them in a build annotation",
+			EnvVar: "BUILDKITE_PHASE_TIMINGS",
+		},
+		cli.BoolFlag{
+			Name:   "diagnostics-bundle",
+			Usage:  "Upload a bundle of diagnostics (processes, disk usage, recent system logs, and the redacted environment) as artifacts under ′buildkite-diagnostics/′ when a job is cancelled or times out",
+			EnvVar: "BUILDKITE_DIAGNOSTICS_BUNDLE",
+		},
 		cli.BoolFlag{
 			Name:   "write-job-logs-to-stdout",
 			Usage:  "Writes job logs to the agent process' stdout. This simplifies log collection if running agents in Docker.",
@@ -359,7 +506,7 @@ var AgentStartCommand = cli.Command{
 		cli.StringFlag{
 			Name:   "shell",
 			Value:  DefaultShell(),
-			Usage:  "The shell command used to interpret build commands, e.g /bin/bash -e -c",
+			Usage:  "The shell command used to interpret build commands, e.g /bin/bash -e -c, or pwsh -Command to also run .ps1 hooks with PowerShell",
 			EnvVar: "BUILDKITE_SHELL",
 		},
 		cli.StringFlag{
@@ -378,6 +525,16 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Include tags from the host (hostname, machine-id, os)",
 			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_HOST",
 		},
+		cli.BoolFlag{
+			Name:   "tags-from-gpus",
+			Usage:  "Include tags describing the host's GPUs (gpu_vendor, gpu_count, and gpu_model), found with nvidia-smi or rocm-smi",
+			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_GPUS",
+		},
+		cli.BoolFlag{
+			Name:   "allocate-gpus",
+			Usage:  "Give each job its own GPUs, found with nvidia-smi or rocm-smi, and restrict it to them with CUDA_VISIBLE_DEVICES. Jobs get one GPU unless they set BUILDKITE_GPU_COUNT, and wait to be accepted until enough GPUs are free. Jobs that need more GPUs than the host has are failed",
+			EnvVar: "BUILDKITE_ALLOCATE_GPUS",
+		},
 		cli.StringSliceFlag{
 			Name:   "tags-from-ec2-meta-data",
 			Value:  &cli.StringSlice{},
@@ -417,6 +574,16 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Include the host's Google Cloud instance labels as tags",
 			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_GCP_LABELS",
 		},
+		cli.BoolFlag{
+			Name:   "tags-from-azure-meta-data",
+			Usage:  "Include the default set of host Azure VM meta-data as tags (vm-id, vm-size, location, zone, and scale-set-name)",
+			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_AZURE_META_DATA",
+		},
+		cli.BoolFlag{
+			Name:   "tags-from-azure-tags",
+			Usage:  "Include the host's Azure VM tags as tags",
+			EnvVar: "BUILDKITE_AGENT_TAGS_FROM_AZURE_TAGS",
+		},
 		cli.DurationFlag{
 			Name:   "wait-for-ec2-tags-timeout",
 			Usage:  "The amount of time to wait for tags from EC2 before proceeding",
@@ -441,6 +608,29 @@ var AgentStartCommand = cli.Command{
 			EnvVar: "BUILDKITE_AGENT_WAIT_FOR_GCP_LABELS_TIMEOUT",
 			Value:  time.Second * 10,
 		},
+		cli.DurationFlag{
+			Name:   "wait-for-azure-meta-data-timeout",
+			Usage:  "The amount of time to wait for meta-data from Azure before proceeding",
+			EnvVar: "BUILDKITE_AGENT_WAIT_FOR_AZURE_META_DATA_TIMEOUT",
+			Value:  time.Second * 10,
+		},
+		cli.DurationFlag{
+			Name:   "wait-for-azure-tags-timeout",
+			Usage:  "The amount of time to wait for tags from Azure before proceeding",
+			EnvVar: "BUILDKITE_AGENT_WAIT_FOR_AZURE_TAGS_TIMEOUT",
+			Value:  time.Second * 10,
+		},
+		cli.DurationFlag{
+			Name:   "tags-refresh-interval",
+			Usage:  "How often to fetch tags from EC2, ECS, GCP, Azure, Kubernetes and the host again, re-registering idle agents with Buildkite if they have changed. 0 disables refreshing",
+			EnvVar: "BUILDKITE_AGENT_TAGS_REFRESH_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "vcs",
+			Value:  "",
+			Usage:  "The version control system to check out the repository with when there's no checkout hook: ′git′ (the default), ′hg′, ′p4′ or ′tar′",
+			EnvVar: "BUILDKITE_VCS",
+		},
 		cli.StringFlag{
 			Name:   "git-checkout-flags",
 			Value:  "-f",
@@ -453,6 +643,12 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Flags to pass to the \"git clone\" command",
 			EnvVar: "BUILDKITE_GIT_CLONE_FLAGS",
 		},
+		cli.StringFlag{
+			Name:   "git-clone-bundle",
+			Value:  "",
+			Usage:  "A bundle URI (such as ′https://example.com/repo.bundle′), or the path to a bundle file, to seed new clones of the repository from, so that only newer objects are fetched from the repository itself. Bundle URIs need git 2.38 or later",
+			EnvVar: "BUILDKITE_GIT_CLONE_BUNDLE",
+		},
 		cli.StringFlag{
 			Name:   "git-clean-flags",
 			Value:  "-ffxdq",
@@ -487,11 +683,53 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Seconds to lock a git mirror during clone, should exceed your longest checkout",
 			EnvVar: "BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT",
 		},
+		CheckoutRetryAttemptsFlag,
+		CheckoutRetryBackoffFlag,
 		cli.BoolFlag{
 			Name:   "git-mirrors-skip-update",
 			Usage:  "Skip updating the Git mirror",
 			EnvVar: "BUILDKITE_GIT_MIRRORS_SKIP_UPDATE",
 		},
+		cli.StringFlag{
+			Name:   "git-mirrors-max-size",
+			Value:  "",
+			Usage:  "The maximum total size of the git mirrors (e.g. \"50GiB\"). The least recently used mirrors are removed before running a job when this is exceeded",
+			EnvVar: "BUILDKITE_GIT_MIRRORS_MAX_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "build-path-max-size",
+			Value:  "",
+			Usage:  "The maximum total size of the checkouts in the build path (e.g. \"100GiB\"). The least recently used checkouts are removed before running a job when this is exceeded",
+			EnvVar: "BUILDKITE_BUILD_PATH_MAX_SIZE",
+		},
+		cli.StringFlag{
+			Name:   "disk-free-minimum",
+			Value:  "",
+			Usage:  "The minimum free disk space to maintain for the build path and git mirrors (e.g. \"10GB\"). The least recently used checkouts and mirrors are removed before running a job when there is less",
+			EnvVar: "BUILDKITE_DISK_FREE_MINIMUM",
+		},
+		cli.DurationFlag{
+			Name:   "git-mirrors-maintenance-interval",
+			Value:  0,
+			Usage:  "How often to prune stale refs from each git mirror and run git gc on it, while the agent is idle (e.g. \"24h\"). Maintenance is stopped if a job starts. Disabled by default. Requires --git-mirrors-path",
+			EnvVar: "BUILDKITE_GIT_MIRRORS_MAINTENANCE_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "git-mirrors-maintenance-min-size",
+			Value:  "",
+			Usage:  "Git mirrors smaller than this (e.g. \"1GiB\") aren't maintained",
+			EnvVar: "BUILDKITE_GIT_MIRRORS_MAINTENANCE_MIN_SIZE",
+		},
+		cli.BoolFlag{
+			Name:   "git-mirrors-worktree",
+			Usage:  "Check out each job as a git worktree of the mirror, instead of cloning with --reference. Requires --git-mirrors-path",
+			EnvVar: "BUILDKITE_GIT_MIRRORS_WORKTREE",
+		},
+		cli.BoolFlag{
+			Name:   "ephemeral-build-dir",
+			Usage:  "Mount a tmpfs over each job's checkout directory, which is discarded when the job finishes. Git mirrors are kept. Only supported on Linux, and requires the agent to be able to mount filesystems",
+			EnvVar: "BUILDKITE_EPHEMERAL_BUILD_DIR",
+		},
 		cli.StringFlag{
 			Name:   "bootstrap-script",
 			Value:  "",
@@ -528,6 +766,17 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Directory where the plugins are saved to",
 			EnvVar: "BUILDKITE_PLUGINS_PATH",
 		},
+		cli.StringFlag{
+			Name:   "plugin-lock-file",
+			Value:  "",
+			Usage:  "Path to a file recording the commit each plugin version resolved to, per pipeline. Jobs fail if a previously recorded plugin version resolves to a different commit",
+			EnvVar: "BUILDKITE_PLUGIN_LOCK_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "plugins-allow-drift",
+			Usage:  "Warn instead of failing when a plugin version resolves to a different commit than the one recorded in --plugin-lock-file, and update the record",
+			EnvVar: "BUILDKITE_PLUGINS_ALLOW_DRIFT",
+		},
 		cli.BoolFlag{
 			Name:   "no-ansi-timestamps",
 			Usage:  "Do not insert ANSI timestamp codes at the start of each line of job output",
@@ -538,9 +787,20 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Prepend timestamps on each line of job output. Has no effect unless --no-ansi-timestamps is also used",
 			EnvVar: "BUILDKITE_TIMESTAMP_LINES",
 		},
+		cli.StringFlag{
+			Name:   "timestamp-lines-format",
+			Value:  agent.TimestampLinesFormatRFC3339,
+			Usage:  "The format of the timestamps prepended by --timestamp-lines: 'rfc3339' for the time to the second, or 'delta' for the time to the millisecond followed by the seconds since the job started and since the previous line (e.g. \"[2024-01-02T03:04:05.678Z +62.345s +0.012s]\")",
+			EnvVar: "BUILDKITE_TIMESTAMP_LINES_FORMAT",
+		},
+		cli.BoolFlag{
+			Name:   "collapse-interactive-output",
+			Usage:  "Collapse progress bars and other output redrawn with carriage returns and cursor movement (such as from npm, pip and docker pull) into its final state before it's sent to Buildkite. Output is held back until it stops changing, for up to 2 seconds. Has no effect with --job-log-format json",
+			EnvVar: "BUILDKITE_COLLAPSE_INTERACTIVE_OUTPUT",
+		},
 		cli.StringFlag{
 			Name:   "health-check-addr",
-			Usage:  "Start an HTTP server on this addr:port that returns whether the agent is healthy, disabled by default",
+			Usage:  "Start an HTTP server on this addr:port that returns whether the agent is healthy, serves a status page with each worker's current and recent jobs at /status (and as JSON at /status.json), and serves Prometheus metrics at /metrics, disabled by default",
 			EnvVar: "BUILDKITE_AGENT_HEALTH_CHECK_ADDR",
 		},
 		cli.BoolFlag{
@@ -548,6 +808,16 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Do not run jobs within a pseudo terminal",
 			EnvVar: "BUILDKITE_NO_PTY",
 		},
+		cli.IntFlag{
+			Name:   "pty-rows",
+			Usage:  "The number of rows of the pseudo terminal that jobs run in. Jobs can resize it with the Job API (default: 100)",
+			EnvVar: "BUILDKITE_PTY_ROWS",
+		},
+		cli.IntFlag{
+			Name:   "pty-cols",
+			Usage:  "The number of columns of the pseudo terminal that jobs run in. Jobs can resize it with the Job API (default: 160)",
+			EnvVar: "BUILDKITE_PTY_COLS",
+		},
 		cli.BoolFlag{
 			Name:   "no-ssh-keyscan",
 			Usage:  "Don't automatically run ssh-keyscan before checkout",
@@ -558,6 +828,43 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Don't allow this agent to run arbitrary console commands, including plugins",
 			EnvVar: "BUILDKITE_NO_COMMAND_EVAL",
 		},
+		cli.StringFlag{
+			Name:   "command-sandbox",
+			Value:  "",
+			Usage:  "Run the command phase of each job in a sandbox that can't read the agent's configuration, hooks, plugins, sockets or env files. The only sandbox currently supported is ′bwrap′ (bubblewrap), on Linux",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-sandbox-allow-paths",
+			Value:  &cli.StringSlice{},
+			Usage:  "Paths that commands in the --command-sandbox can read and write, even if they would otherwise be hidden",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX_ALLOW_PATHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-env-allow",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names to pass to the command phase of each job. If set, variables that don't match are removed. Hooks still get every variable",
+			EnvVar: "BUILDKITE_COMMAND_ENV_ALLOW",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-env-deny",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names to remove from the command phase of each job, for example ′BUILDKITE_AGENT_ACCESS_TOKEN′. Hooks still get every variable. Commands that run buildkite-agent need the access token",
+			EnvVar: "BUILDKITE_COMMAND_ENV_DENY",
+		},
+		SoftFailExitStatusFlag,
+		SoftFailClassifierFlag,
+		cli.StringSliceFlag{
+			Name:   "protected-env",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names that can't be overridden by the pipeline, by hooks, or through the Job API. Pipeline values are ignored (apart from BUILDKITE_ variables, which Buildkite provides), hook changes are reverted, and Job API changes are refused",
+			EnvVar: "BUILDKITE_PROTECTED_ENV",
+		},
+		cli.BoolFlag{
+			Name:   "protected-env-enforce",
+			Usage:  "Fail the job, rather than warning, if the pipeline or a hook tries to override a protected environment variable",
+			EnvVar: "BUILDKITE_PROTECTED_ENV_ENFORCE",
+		},
 		cli.BoolFlag{
 			Name:   "no-plugins",
 			Usage:  "Don't allow this agent to load plugins",
@@ -578,6 +885,18 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Don't automatically checkout git submodules",
 			EnvVar: "BUILDKITE_NO_GIT_SUBMODULES,BUILDKITE_DISABLE_GIT_SUBMODULES",
 		},
+		cli.StringSliceFlag{
+			Name:   "git-submodule-clone-flags",
+			Value:  &cli.StringSlice{},
+			Usage:  "Comma separated ′<url prefix>=<flags>′ rules giving flags to pass to ′git submodule update′ for the submodules whose URLs start with the prefix, such as ′https://github.com/org/big-repo=--depth 1′. The first matching rule applies, and each submodule is updated separately when there are any rules",
+			EnvVar: "BUILDKITE_GIT_SUBMODULE_CLONE_FLAGS",
+		},
+		cli.StringSliceFlag{
+			Name:   "git-submodule-credentials",
+			Value:  &cli.StringSlice{},
+			Usage:  "Comma separated ′<url>=[<username>:]<env var>′ rules that have git use the token in the environment variable for HTTPS submodules at the URL (or on the host, if the URL has no path), such as ′https://gitlab.example.com=oauth2:GITLAB_TOKEN′. The username defaults to ′x-access-token′. Requires git 2.31 or later",
+			EnvVar: "BUILDKITE_GIT_SUBMODULE_CREDENTIALS",
+		},
 		cli.BoolFlag{
 			Name:   "no-feature-reporting",
 			Usage:  "Disables sending a list of enabled features back to the Buildkite mothership. We use this information to measure feature usage, but if you're not comfortable sharing that information then that's totally okay :)",
@@ -603,7 +922,7 @@ var AgentStartCommand = cli.Command{
 		cli.StringSliceFlag{
 			Name:   "allowed-plugins",
 			Value:  &cli.StringSlice{},
-			Usage:  `A comma-separated list of regular expressions representing plugins the agent is allowed to use (for example, "^buildkite-plugins/.*$" or "^/var/lib/buildkite-plugins/.*")`,
+			Usage:  `A comma-separated list of regular expressions representing plugins the agent is allowed to use (for example, "^buildkite-plugins/.*$" or "^/var/lib/buildkite-plugins/.*"). Append "@<sha>" to a pattern to also require matching plugins to resolve to that commit, or to that SHA-256 checksum for plugin archives`,
 			EnvVar: "BUILDKITE_ALLOWED_PLUGINS",
 		},
 		cli.BoolFlag{
@@ -622,6 +941,11 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Use Datadog Distributions for Timing metrics",
 			EnvVar: "BUILDKITE_METRICS_DATADOG_DISTRIBUTIONS",
 		},
+		cli.StringFlag{
+			Name:   "metrics-otlp-endpoint",
+			Usage:  "Export metrics to this OpenTelemetry (OTLP) gRPC endpoint, such as http://localhost:4317",
+			EnvVar: "BUILDKITE_METRICS_OTLP_ENDPOINT",
+		},
 		cli.StringFlag{
 			Name:   "log-format",
 			Usage:  "The format to use for the logger output",
@@ -645,8 +969,20 @@ var AgentStartCommand = cli.Command{
 			Usage:  "Assign priorities to every spawned agent (when using --spawn or --spawn-per-cpu) equal to the agent's index",
 			EnvVar: "BUILDKITE_AGENT_SPAWN_WITH_PRIORITY",
 		},
+		cli.IntFlag{
+			Name:   "max-concurrent-jobs",
+			Usage:  "The most jobs the spawned agents may run at the same time. While the limit is reached, idle agents are paused and don't ask for work. The default of 0 is no limit",
+			EnvVar: "BUILDKITE_AGENT_MAX_CONCURRENT_JOBS",
+		},
+		cli.StringSliceFlag{
+			Name:   "max-concurrent-jobs-per-tag",
+			Value:  &cli.StringSlice{},
+			Usage:  "Limits on how many jobs targeting an agent tag the spawned agents may run at the same time, such as ′gpu=true:1′. A job over a limit waits to be accepted until another job targeting the tag finishes",
+			EnvVar: "BUILDKITE_AGENT_MAX_CONCURRENT_JOBS_PER_TAG",
+		},
 		cancelSignalFlag,
 		signalGracePeriodSecondsFlag,
+		hookTimeoutFlag,
 		cli.StringFlag{
 			Name:   "tracing-backend",
 			Usage:  `Enable tracing for build jobs by specifying a backend, "datadog" or "opentelemetry"`,
@@ -659,6 +995,12 @@ var AgentStartCommand = cli.Command{
 			EnvVar: "BUILDKITE_TRACING_SERVICE_NAME",
 			Value:  "buildkite-agent",
 		},
+		cli.IntFlag{
+			Name:   "tracing-hook-output-limit",
+			Usage:  "Record up to this many bytes of each hook's stdout and stderr, redacted, as events on the hook's trace span. The default of 0 records no hook output.",
+			EnvVar: "BUILDKITE_TRACING_HOOK_OUTPUT_LIMIT",
+			Value:  0,
+		},
 		cli.StringFlag{
 			Name:   "verification-jwks-file",
 			Usage:  "Path to a file containing a JSON Web Key Set (JWKS), used to verify job signatures. ",
@@ -700,6 +1042,9 @@ var AgentStartCommand = cli.Command{
 		AgentRegisterTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 		TraceHTTPFlag,
 
@@ -711,9 +1056,12 @@ var AgentStartCommand = cli.Command{
 		ProfileFlag,
 		RedactedVars,
 		StrictSingleHooksFlag,
+		HookInterpretersFlag,
 		KubernetesExecFlag,
 		TraceContextEncodingFlag,
 		NoMultipartArtifactUploadFlag,
+		ArtifactEncryptionKeyFileFlag,
+		ArtifactEncryptionAWSKMSKeyFlag,
 
 		// Deprecated flags which will be removed in v4
 		cli.StringSliceFlag{
@@ -881,6 +1229,24 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
+		var azureMetaDataTimeout time.Duration
+		if t := cfg.WaitForAzureMetaDataTimeout; t != "" {
+			var err error
+			azureMetaDataTimeout, err = time.ParseDuration(t)
+			if err != nil {
+				return fmt.Errorf("failed to parse azure meta-data timeout: %w", err)
+			}
+		}
+
+		var azureTagsTimeout time.Duration
+		if t := cfg.WaitForAzureTagsTimeout; t != "" {
+			var err error
+			azureTagsTimeout, err = time.ParseDuration(t)
+			if err != nil {
+				return fmt.Errorf("failed to parse azure tags timeout: %w", err)
+			}
+		}
+
 		signalGracePeriod, err := signalGracePeriod(cfg.CancelGracePeriod, cfg.SignalGracePeriodSeconds)
 		if err != nil {
 			return err
@@ -894,6 +1260,7 @@ var AgentStartCommand = cli.Command{
 			Datadog:              cfg.MetricsDatadog,
 			DatadogHost:          cfg.MetricsDatadogHost,
 			DatadogDistributions: cfg.MetricsDatadogDistributions,
+			OTLPEndpoint:         cfg.MetricsOTLPEndpoint,
 		})
 
 		// Sense check supported tracing backends, we don't want bootstrapped jobs to silently have no tracing
@@ -905,12 +1272,8 @@ var AgentStartCommand = cli.Command{
 			)
 		}
 
-		if experiments.IsEnabled(ctx, experiments.AgentAPI) {
-			shutdown, err := runAgentAPI(ctx, l, cfg.SocketsPath)
-			if err != nil {
-				return err
-			}
-			defer shutdown()
+		if cfg.TracingHookOutputLimit < 0 {
+			return fmt.Errorf("tracing-hook-output-limit must not be negative, got %d", cfg.TracingHookOutputLimit)
 		}
 
 		// if the agent is provided a KMS key ID, it should use the KMS signer, otherwise
@@ -976,6 +1339,75 @@ var AgentStartCommand = cli.Command{
 			}
 		}
 
+		preemptionCheckers, err := newPreemptionCheckers(cfg.PreemptionNotices)
+		if err != nil {
+			return err
+		}
+
+		if cfg.AcquireJob != "" && len(cfg.AcquireJobs) > 0 {
+			return errors.New("acquire-job and acquire-jobs can't be used at the same time")
+		}
+		if slices.Equal(cfg.AcquireJobs, []string{"-"}) {
+			cfg.AcquireJobs, err = readJobIDs(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("reading job IDs to acquire from standard input: %w", err)
+			}
+			if len(cfg.AcquireJobs) == 0 {
+				return errors.New("no job IDs to acquire were given on standard input")
+			}
+		}
+
+		var jobLogSpoolMaxSize uint64 = agent.DefaultJobLogSpoolMaxSize
+		if cfg.JobLogSpoolMaxSize != "" {
+			jobLogSpoolMaxSize, err = humanize.ParseBytes(cfg.JobLogSpoolMaxSize)
+			if err != nil {
+				return fmt.Errorf("invalid --job-log-spool-max-size %q: %w", cfg.JobLogSpoolMaxSize, err)
+			}
+		}
+
+		var logUploadMaxBandwidth, maxJobLogSize, jobMemoryLimit uint64
+		for _, size := range []struct {
+			flag, value string
+			dst         *uint64
+		}{
+			{"log-upload-max-bandwidth", cfg.LogUploadMaxBandwidth, &logUploadMaxBandwidth},
+			{"max-job-log-size", cfg.MaxJobLogSize, &maxJobLogSize},
+			{"job-memory-limit", cfg.JobMemoryLimit, &jobMemoryLimit},
+		} {
+			if size.value == "" {
+				continue
+			}
+			*size.dst, err = humanize.ParseBytes(size.value)
+			if err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", size.flag, size.value, err)
+			}
+		}
+
+		ptyRows, ptyCols, err := parsePTYSize(cfg.PTYRows, cfg.PTYCols)
+		if err != nil {
+			return err
+		}
+
+		var jobCPULimit float64
+		if cfg.JobCPULimit != "" {
+			jobCPULimit, err = strconv.ParseFloat(cfg.JobCPULimit, 64)
+			if err != nil || jobCPULimit <= 0 {
+				return fmt.Errorf("invalid --job-cpu-limit %q, must be a number of CPUs greater than 0", cfg.JobCPULimit)
+			}
+		}
+
+		var jobCgroupParent string
+		if jobMemoryLimit > 0 || jobCPULimit > 0 {
+			if cfg.KubernetesExec {
+				return errors.New("--job-memory-limit and --job-cpu-limit can't be used with --kubernetes-exec, set resource limits in the pod spec instead")
+			}
+			jobCgroupParent, err = agent.SetUpJobCgroups(cfg.JobCgroupParent, jobMemoryLimit > 0, jobCPULimit > 0)
+			if err != nil {
+				return fmt.Errorf("couldn't set up cgroups for job resource limits: %w", err)
+			}
+			l.Info("Running jobs in cgroups in %s", jobCgroupParent)
+		}
+
 		// AgentConfiguration is the runtime configuration for an agent
 		agentConf := agent.AgentConfiguration{
 			BootstrapScript:              cfg.BootstrapScript,
@@ -983,43 +1415,82 @@ var AgentStartCommand = cli.Command{
 			SocketsPath:                  cfg.SocketsPath,
 			GitMirrorsPath:               cfg.GitMirrorsPath,
 			GitMirrorsLockTimeout:        cfg.GitMirrorsLockTimeout,
+			CheckoutRetryAttempts:        cfg.CheckoutRetryAttempts,
+			CheckoutRetryBackoff:         cfg.CheckoutRetryBackoff,
 			GitMirrorsSkipUpdate:         cfg.GitMirrorsSkipUpdate,
+			GitMirrorsWorktree:           cfg.GitMirrorsWorktree,
+			EphemeralBuildDir:            cfg.EphemeralBuildDir,
 			HooksPath:                    cfg.HooksPath,
 			AdditionalHooksPaths:         cfg.AdditionalHooksPaths,
 			PluginsPath:                  cfg.PluginsPath,
+			PluginLockFile:               cfg.PluginLockFile,
+			PluginsAllowDrift:            cfg.PluginsAllowDrift,
+			VCS:                          cfg.VCS,
 			GitCheckoutFlags:             cfg.GitCheckoutFlags,
 			GitCloneFlags:                cfg.GitCloneFlags,
+			GitCloneBundle:               cfg.GitCloneBundle,
 			GitCloneMirrorFlags:          cfg.GitCloneMirrorFlags,
 			GitCleanFlags:                cfg.GitCleanFlags,
 			GitFetchFlags:                cfg.GitFetchFlags,
 			GitSubmodules:                !cfg.NoGitSubmodules,
+			GitSubmoduleCloneFlags:       cfg.GitSubmoduleCloneFlags,
+			GitSubmoduleCredentials:      cfg.GitSubmoduleCredentials,
 			SSHKeyscan:                   !cfg.NoSSHKeyscan,
 			CommandEval:                  !cfg.NoCommandEval,
+			CommandSandbox:               cfg.CommandSandbox,
+			CommandSandboxAllowPaths:     cfg.CommandSandboxAllow,
+			CommandEnvAllow:              cfg.CommandEnvAllow,
+			CommandEnvDeny:               cfg.CommandEnvDeny,
+			SoftFailExitStatuses:         cfg.SoftFailExitStatus,
+			SoftFailClassifier:           cfg.SoftFailClassifier,
+			ProtectedEnv:                 cfg.ProtectedEnv,
+			ProtectedEnvEnforce:          cfg.ProtectedEnvEnforce,
 			PluginsEnabled:               !cfg.NoPlugins,
 			PluginValidation:             !cfg.NoPluginValidation,
 			LocalHooksEnabled:            !cfg.NoLocalHooks,
 			AllowedEnvironmentVariables:  allowedEnvironmentVariables,
 			StrictSingleHooks:            cfg.StrictSingleHooks,
+			HookInterpreters:             cfg.HookInterpreters,
 			RunInPty:                     !cfg.NoPTY,
+			PTYRows:                      ptyRows,
+			PTYCols:                      ptyCols,
 			ANSITimestamps:               !cfg.NoANSITimestamps,
 			TimestampLines:               cfg.TimestampLines,
+			TimestampLinesFormat:         cfg.TimestampLinesFormat,
+			CollapseInteractiveOutput:    cfg.CollapseInteractiveOutput,
 			DisconnectAfterJob:           cfg.DisconnectAfterJob,
 			DisconnectAfterIdleTimeout:   cfg.DisconnectAfterIdleTimeout,
 			CancelGracePeriod:            cfg.CancelGracePeriod,
 			SignalGracePeriod:            signalGracePeriod,
+			HookTimeout:                  cfg.HookTimeout,
 			EnableJobLogTmpfile:          cfg.EnableJobLogTmpfile,
 			JobLogPath:                   cfg.JobLogPath,
+			JobLogFormat:                 cfg.JobLogFormat,
+			PhaseTimings:                 cfg.PhaseTimings,
+			DiagnosticsBundle:            cfg.DiagnosticsBundle,
+			JobLogSpoolPath:              cfg.JobLogSpoolPath,
+			JobLogSpoolMaxSize:           jobLogSpoolMaxSize,
+			LogUploadMaxBandwidth:        logUploadMaxBandwidth,
+			MaxJobLogSize:                maxJobLogSize,
+			JobCgroupParent:              jobCgroupParent,
+			JobMemoryLimit:               jobMemoryLimit,
+			JobCPULimit:                  jobCPULimit,
 			WriteJobLogsToStdout:         cfg.WriteJobLogsToStdout,
 			LogFormat:                    cfg.LogFormat,
 			Shell:                        cfg.Shell,
 			RedactedVars:                 cfg.RedactedVars,
 			AcquireJob:                   cfg.AcquireJob,
+			AcquireJobs:                  cfg.AcquireJobs,
 			TracingBackend:               cfg.TracingBackend,
 			TracingServiceName:           cfg.TracingServiceName,
+			TracingHookOutputLimit:       cfg.TracingHookOutputLimit,
 			TraceContextEncoding:         cfg.TraceContextEncoding,
 			AllowMultipartArtifactUpload: !cfg.NoMultipartArtifactUpload,
 			KubernetesExec:               cfg.KubernetesExec,
 
+			ArtifactEncryptionKeyFile:   cfg.ArtifactEncryptionKeyFile,
+			ArtifactEncryptionAWSKMSKey: cfg.ArtifactEncryptionAWSKMSKey,
+
 			SigningJWKSFile:  cfg.SigningJWKSFile,
 			SigningJWKSKeyID: cfg.SigningJWKSKeyID,
 			SigningAWSKMSKey: cfg.SigningAWSKMSKey,
@@ -1057,6 +1528,38 @@ var AgentStartCommand = cli.Command{
 			return fmt.Errorf("invalid log format %q. Only 'text' or 'json' are allowed.", cfg.LogFormat)
 		}
 
+		if cfg.JobLogFormat != "text" && cfg.JobLogFormat != "json" {
+			return fmt.Errorf("invalid job log format %q. Only 'text' or 'json' are allowed.", cfg.JobLogFormat)
+		}
+
+		if !slices.Contains(agent.TimestampLinesFormats, cfg.TimestampLinesFormat) {
+			return fmt.Errorf("invalid --timestamp-lines-format %q, must be one of %q", cfg.TimestampLinesFormat, agent.TimestampLinesFormats)
+		}
+
+		if _, err := hook.ParseInterpreters(cfg.HookInterpreters); err != nil {
+			return err
+		}
+
+		if err := job.ValidateCommandSandbox(cfg.CommandSandbox); err != nil {
+			return err
+		}
+
+		if err := job.ValidatePhaseTimings(cfg.PhaseTimings); err != nil {
+			return err
+		}
+
+		if err := job.ValidateVCS(cfg.VCS); err != nil {
+			return err
+		}
+
+		if err := job.ValidateGitSubmoduleConfig(cfg.GitSubmoduleCloneFlags, cfg.GitSubmoduleCredentials); err != nil {
+			return err
+		}
+
+		if _, err := parseSoftFailExitStatuses(cfg.SoftFailExitStatus); err != nil {
+			return err
+		}
+
 		l.Notice("Starting buildkite-agent v%s with PID: %s", version.Version(), strconv.Itoa(os.Getpid()))
 		l.Notice("The agent source code can be found here: https://github.com/buildkite/agent")
 		l.Notice("For questions and support, email us at: hello@buildkite.com")
@@ -1114,13 +1617,14 @@ var AgentStartCommand = cli.Command{
 		if len(cfg.AllowedPlugins) > 0 {
 			agentConf.AllowedPlugins = make([]*regexp.Regexp, 0, len(cfg.AllowedPlugins))
 			for _, v := range cfg.AllowedPlugins {
-				r, err := regexp.Compile(v)
+				a, err := plugin.ParseAllowedPlugin(v)
 				if err != nil {
 					l.Fatal("Regex %s in allowed-plugins failed to compile: %v", v, err)
 				}
-				agentConf.AllowedPlugins = append(agentConf.AllowedPlugins, r)
+				agentConf.AllowedPlugins = append(agentConf.AllowedPlugins, a.Pattern)
+				agentConf.PluginAllowlist = append(agentConf.PluginAllowlist, a)
 			}
-			l.Info("Allowed plugins patterns: %q", agentConf.AllowedPlugins)
+			l.Info("Allowed plugins: %q", agentConf.PluginAllowlist)
 		}
 
 		cancelSig, err := process.ParseSignal(cfg.CancelSignal)
@@ -1128,32 +1632,53 @@ var AgentStartCommand = cli.Command{
 			return fmt.Errorf("failed to parse cancel-signal: %w", err)
 		}
 
-		tags := agent.FetchTags(ctx, l, agent.FetchTagsConfig{
-			Tags:                      cfg.Tags,
-			TagsFromK8s:               cfg.KubernetesExec,
-			TagsFromEC2MetaData:       (cfg.TagsFromEC2MetaData || cfg.TagsFromEC2),
-			TagsFromEC2MetaDataPaths:  cfg.TagsFromEC2MetaDataPaths,
-			TagsFromEC2Tags:           cfg.TagsFromEC2Tags,
-			TagsFromECSMetaData:       cfg.TagsFromECSMetaData,
-			TagsFromGCPMetaData:       (cfg.TagsFromGCPMetaData || cfg.TagsFromGCP),
-			TagsFromGCPMetaDataPaths:  cfg.TagsFromGCPMetaDataPaths,
-			TagsFromGCPLabels:         cfg.TagsFromGCPLabels,
-			TagsFromHost:              cfg.TagsFromHost,
-			WaitForEC2TagsTimeout:     ec2TagTimeout,
-			WaitForEC2MetaDataTimeout: ec2MetaDataTimeout,
-			WaitForECSMetaDataTimeout: ecsMetaDataTimeout,
-			WaitForGCPLabelsTimeout:   gcpLabelsTimeout,
-		})
+		fetchTagsConf := agent.FetchTagsConfig{
+			TagsFromK8s:                 cfg.KubernetesExec,
+			TagsFromEC2MetaData:         (cfg.TagsFromEC2MetaData || cfg.TagsFromEC2),
+			TagsFromEC2MetaDataPaths:    cfg.TagsFromEC2MetaDataPaths,
+			TagsFromEC2Tags:             cfg.TagsFromEC2Tags,
+			TagsFromECSMetaData:         cfg.TagsFromECSMetaData,
+			TagsFromGCPMetaData:         (cfg.TagsFromGCPMetaData || cfg.TagsFromGCP),
+			TagsFromGCPMetaDataPaths:    cfg.TagsFromGCPMetaDataPaths,
+			TagsFromGCPLabels:           cfg.TagsFromGCPLabels,
+			TagsFromAzureMetaData:       cfg.TagsFromAzureMetaData,
+			TagsFromAzureTags:           cfg.TagsFromAzureTags,
+			TagsFromHost:                cfg.TagsFromHost,
+			TagsFromGPUs:                cfg.TagsFromGPUs,
+			WaitForEC2TagsTimeout:       ec2TagTimeout,
+			WaitForEC2MetaDataTimeout:   ec2MetaDataTimeout,
+			WaitForECSMetaDataTimeout:   ecsMetaDataTimeout,
+			WaitForGCPLabelsTimeout:     gcpLabelsTimeout,
+			WaitForAzureMetaDataTimeout: azureMetaDataTimeout,
+			WaitForAzureTagsTimeout:     azureTagsTimeout,
+		}
+		fetchedTags := agent.FetchTags(ctx, l, fetchTagsConf)
 
 		// Munge the value from --queue (if it exists) into the tags slice
-		if cfg.Queue != "" {
-			i := slices.IndexFunc(tags, func(s string) bool {
-				return strings.HasPrefix(strings.TrimSpace(s), "queue=")
-			})
-			if i != -1 {
-				l.Fatal("Queue must be present in only one of the --tags or the --queue flags")
+		staticTags, err := withQueueTag(cfg.Tags, cfg.Queue)
+		if err != nil || (cfg.Queue != "" && slices.IndexFunc(fetchedTags, isQueueTag) != -1) {
+			l.Fatal("Queue must be present in only one of the --tags or the --queue flags")
+		}
+		regTags := agent.NewRegistrationTags(staticTags, fetchedTags)
+		tags, _ := regTags.Get()
+
+		if cfg.TagsRefreshInterval < 0 {
+			return fmt.Errorf("tags-refresh-interval must not be negative, got %v", cfg.TagsRefreshInterval)
+		}
+		if cfg.TagsRefreshInterval > 0 {
+			go regTags.Refresh(ctx, l, fetchTagsConf, cfg.TagsRefreshInterval)
+		}
+
+		// Workers check with the leader agent whether job acceptance has been
+		// paused with `buildkite-agent pause`, if the Agent API is enabled.
+		var pauser agent.Pauser
+		if experiments.IsEnabled(ctx, experiments.AgentAPI) {
+			shutdown, err := runAgentAPI(ctx, l, cfg.SocketsPath, agentTagSetter{tags: regTags, queue: cfg.Queue})
+			if err != nil {
+				return err
 			}
-			tags = append(tags, "queue="+cfg.Queue)
+			defer shutdown()
+			pauser = leaderPauser{path: agentapi.LeaderPath(cfg.SocketsPath)}
 		}
 
 		// confirm the BuildPath is exists. The bootstrap is going to write to it when a job executes,
@@ -1179,7 +1704,7 @@ var AgentStartCommand = cli.Command{
 			// We only want this agent to be ignored in Buildkite
 			// dispatches if it's being booted to acquire a
 			// specific job.
-			IgnoreInDispatches: cfg.AcquireJob != "",
+			IgnoreInDispatches: cfg.acquiring(),
 			Features:           cfg.Features(ctx),
 		}
 
@@ -1192,10 +1717,40 @@ var AgentStartCommand = cli.Command{
 
 		// Spawning multiple agents doesn't work if the agent is being
 		// booted in acquisition mode
-		if cfg.Spawn > 1 && cfg.AcquireJob != "" {
+		if cfg.Spawn > 1 && cfg.acquiring() {
 			return errors.New("You can't spawn multiple agents and acquire a job at the same time")
 		}
 
+		housekeeper, err := newHousekeeper(l, cfg)
+		if err != nil {
+			return err
+		}
+		if housekeeper != nil {
+			go housekeeper.Run(ctx, housekeeping.DefaultInterval)
+		}
+
+		if cfg.MaxConcurrentJobs < 0 {
+			return fmt.Errorf("--max-concurrent-jobs must not be negative, got %d", cfg.MaxConcurrentJobs)
+		}
+		tagJobLimits, err := agent.ParseTagJobLimits(cfg.MaxConcurrentJobsPerTag)
+		if err != nil {
+			return fmt.Errorf("invalid --max-concurrent-jobs-per-tag: %w", err)
+		}
+		jobLimiter := agent.NewJobLimiter(cfg.MaxConcurrentJobs, tagJobLimits)
+
+		var gpuAllocator *agent.GPUAllocator
+		if cfg.AllocateGPUs {
+			vendor, gpus, err := agent.DetectGPUs(ctx)
+			if err != nil {
+				return fmt.Errorf("couldn't find GPUs to allocate: %w", err)
+			}
+			if len(gpus) == 0 {
+				return errors.New("couldn't find any GPUs to allocate")
+			}
+			l.Info("Allocating %d %s GPUs to jobs", len(gpus), vendor)
+			gpuAllocator = agent.NewGPUAllocator(vendor, gpus)
+		}
+
 		var workers []*agent.AgentWorker
 
 		for i := 1; i <= cfg.Spawn; i++ {
@@ -1225,6 +1780,14 @@ var AgentStartCommand = cli.Command{
 				return err
 			}
 
+			// Used to register again if the tags change
+			spawnReq := registerReq
+			register := func(ctx context.Context, tags []string) (*api.AgentRegisterResponse, error) {
+				req := spawnReq
+				req.Tags = tags
+				return client.Register(ctx, req)
+			}
+
 			// Create an agent worker to run the agent
 			workers = append(workers, agent.NewAgentWorker(
 				l.WithFields(logger.StringField("agent", ag.Name)),
@@ -1238,6 +1801,12 @@ var AgentStartCommand = cli.Command{
 					Debug:              cfg.Debug,
 					DebugHTTP:          cfg.DebugHTTP,
 					SpawnIndex:         i,
+					Housekeeper:        housekeeper,
+					JobLimiter:         jobLimiter,
+					GPUAllocator:       gpuAllocator,
+					Pauser:             pauser,
+					Tags:               regTags,
+					Register:           register,
 					AgentStdout:        os.Stdout,
 				},
 			))
@@ -1258,6 +1827,31 @@ var AgentStartCommand = cli.Command{
 		signals := handlePoolSignals(ctx, l, pool)
 		defer close(signals)
 
+		// Stop the agent, cancelling running jobs, if the instance is about
+		// to be preempted.
+		//
+		// Note: the agent API has no way to mark a job to be retried, so jobs
+		// stopped here are only retried if their automatic retry rules match
+		// the agent_stop signal reason. Their artifact_paths are uploaded
+		// during the cancel grace period, as for any cancelled job, but
+		// nothing makes that finish before the instance goes away.
+		if len(preemptionCheckers) > 0 {
+			go func() {
+				notice := agent.WatchForPreemption(ctx, l, preemptionCheckers)
+				if notice == nil {
+					return
+				}
+				l.Warn("Received notice of preemption (%s), stopping running jobs and the agent(s)", notice)
+				if !notice.Time.IsZero() {
+					if left, grace := time.Until(notice.Time), time.Duration(cfg.CancelGracePeriod)*time.Second; left < grace {
+						l.Warn("The instance will %s in %v, before the cancel grace period of %v is up, so running jobs may not finish uploading their logs and artifacts",
+							notice.Action, left.Round(time.Second), grace)
+					}
+				}
+				pool.Stop(false)
+			}()
+		}
+
 		l.Info("Starting %d Agent(s)", cfg.Spawn)
 		l.Info("You can press Ctrl-C to stop the agents")
 
@@ -1266,6 +1860,12 @@ var AgentStartCommand = cli.Command{
 			pool.StartStatusServer(ctx, l, cfg.HealthCheckAddr)
 		}
 
+		// Upload what's left of the logs of jobs that were running when an
+		// agent last stopped unexpectedly
+		if cfg.JobLogSpoolPath != "" {
+			go agent.RecoverJobLogSpools(ctx, l, cfg.JobLogSpoolPath, apiClient.Config())
+		}
+
 		err = pool.Start(ctx)
 		if errors.Is(err, core.ErrJobAcquisitionRejected) {
 			// If the agent tried to acquire a job, but it couldn't because the job was already taken, we should exit with a
@@ -1343,6 +1943,70 @@ func parseAndValidateJWKS(ctx context.Context, keysetType, path string) (jwk.Set
 	return jwks, nil
 }
 
+// newHousekeeper returns a disk housekeeping manager for the size limits and
+// mirror maintenance in the config, or nil if there are none.
+func newHousekeeper(l logger.Logger, cfg AgentStartConfig) (*housekeeping.Manager, error) {
+	var conf housekeeping.Config
+	for _, limit := range []struct {
+		flag, value string
+		dst         *uint64
+	}{
+		{"build-path-max-size", cfg.BuildPathMaxSize, &conf.BuildPathMaxSize},
+		{"git-mirrors-max-size", cfg.GitMirrorsMaxSize, &conf.GitMirrorsMaxSize},
+		{"disk-free-minimum", cfg.DiskFreeMinimum, &conf.DiskFreeMinimum},
+		{"git-mirrors-maintenance-min-size", cfg.GitMirrorsMaintenanceMinSize, &conf.GitMirrorsMaintenanceMinSize},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		size, err := humanize.ParseBytes(limit.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s %q: %w", limit.flag, limit.value, err)
+		}
+		*limit.dst = size
+	}
+
+	if cfg.GitMirrorsMaintenanceInterval < 0 {
+		return nil, fmt.Errorf("--git-mirrors-maintenance-interval must not be negative, got %v", cfg.GitMirrorsMaintenanceInterval)
+	}
+	conf.GitMirrorsMaintenanceInterval = cfg.GitMirrorsMaintenanceInterval
+
+	if !conf.Enabled() {
+		return nil, nil
+	}
+	if conf.GitMirrorsMaxSize > 0 && cfg.GitMirrorsPath == "" {
+		return nil, errors.New("--git-mirrors-max-size requires --git-mirrors-path")
+	}
+	if conf.GitMirrorsMaintenanceInterval > 0 && cfg.GitMirrorsPath == "" {
+		return nil, errors.New("--git-mirrors-maintenance-interval requires --git-mirrors-path")
+	}
+
+	conf.BuildPath = cfg.BuildPath
+	conf.GitMirrorsPath = cfg.GitMirrorsPath
+
+	l.Info("Disk housekeeping is enabled for %s", cfg.BuildPath)
+	return housekeeping.New(l, conf), nil
+}
+
+// newPreemptionCheckers returns checkers for notice of preemption from each of the
+// named cloud providers.
+func newPreemptionCheckers(providers []string) ([]agent.PreemptionChecker, error) {
+	var checkers []agent.PreemptionChecker
+	for _, provider := range providers {
+		switch provider {
+		case "ec2":
+			checkers = append(checkers, agent.EC2MetaData{})
+		case "gcp":
+			checkers = append(checkers, agent.GCPMetaData{})
+		case "azure":
+			checkers = append(checkers, agent.AzureMetaData{})
+		default:
+			return nil, fmt.Errorf("invalid --preemption-notices %q. Must be one of: ec2, gcp, azure", provider)
+		}
+	}
+	return checkers, nil
+}
+
 func handlePoolSignals(ctx context.Context, l logger.Logger, pool *agent.AgentPool) chan os.Signal {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt,
@@ -1473,16 +2137,49 @@ func defaultSocketsPath() string {
 	return filepath.Join(home, ".buildkite-agent", "sockets")
 }
 
+// withQueueTag adds the queue tag from --queue, if set, to the tags. It returns
+// an error if the tags already have a queue tag.
+func withQueueTag(tags []string, queue string) ([]string, error) {
+	if queue == "" {
+		return tags, nil
+	}
+	if slices.IndexFunc(tags, isQueueTag) != -1 {
+		return nil, errors.New("queue must be present in only one of the tags or the --queue flag")
+	}
+	return append(slices.Clip(tags), "queue="+queue), nil
+}
+
+func isQueueTag(tag string) bool {
+	return strings.HasPrefix(strings.TrimSpace(tag), "queue=")
+}
+
+// agentTagSetter sets the static tags of the agent from the Agent API. The
+// queue tag from --queue is kept.
+type agentTagSetter struct {
+	tags  *agent.RegistrationTags
+	queue string
+}
+
+func (s agentTagSetter) SetTags(tags []string) ([]string, error) {
+	static, err := withQueueTag(tags, s.queue)
+	if err != nil {
+		return nil, err
+	}
+	s.tags.SetStatic(static)
+	all, _ := s.tags.Get()
+	return all, nil
+}
+
 // runAgentAPI runs an API socket that can be used to interact with this
 // (top-level) agent. It returns a shutdown function.
-func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string) (func(), error) {
+func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string, tags agentapi.TagSetter) (func(), error) {
 	path := agentapi.DefaultSocketPath(socketsPath)
 	// There should be only one Agent API socket per agent process.
 	// If a previous agent crashed and left behind a socket, we can
 	// remove it.
 	os.Remove(path)
 
-	svr, err := agentapi.NewServer(path, l)
+	svr, err := agentapi.NewServer(path, l, tags)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create Agent API server: %w", err)
 	}
@@ -1508,6 +2205,22 @@ func runAgentAPI(ctx context.Context, l logger.Logger, socketsPath string) (func
 	}, nil
 }
 
+// leaderPauser asks the leader agent whether job acceptance has been paused.
+type leaderPauser struct {
+	path string
+}
+
+func (p leaderPauser) Paused(ctx context.Context) (bool, error) {
+	ctx, canc := context.WithTimeout(ctx, time.Second)
+	defer canc()
+
+	cl, err := agentapi.NewClient(ctx, p.path)
+	if err != nil {
+		return false, err
+	}
+	return cl.Paused(ctx)
+}
+
 // leaderPinger pings the leader socket for liveness, and takes over if it
 // fails.
 func leaderPinger(ctx context.Context, l logger.Logger, path, leaderPath string) {
@@ -1535,9 +2248,21 @@ func leaderPinger(ctx context.Context, l logger.Logger, path, leaderPath string)
 	for range time.Tick(100 * time.Millisecond) {
 		if err := pingLeader(); err != nil {
 			l.Warn("Agent API: Leader ping failed, staging coup: %v", err)
-			l.Warn("Agent API: Leader state (locks) has been lost!")
+			l.Warn("Agent API: Leader state (locks, pause) has been lost!")
 			os.Remove(leaderPath)
 			os.Symlink(path, leaderPath)
 		}
 	}
 }
+
+// readJobIDs reads job IDs separated by commas or whitespace, such as one per
+// line.
+func readJobIDs(r io.Reader) ([]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(b), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}), nil
+}
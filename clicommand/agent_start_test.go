@@ -4,8 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/stretchr/testify/assert"
 )
@@ -197,3 +199,28 @@ func TestAgentShutdownHook(t *testing.T) {
 		assert.Equal(t, []string{}, log.Messages)
 	})
 }
+
+func TestReadJobIDs(t *testing.T) {
+	t.Parallel()
+
+	input := "job-1\njob-2,job-3\n\n  job-4\t\n"
+	got, err := readJobIDs(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readJobIDs(%q) error = %v", input, err)
+	}
+	assert.Equal(t, []string{"job-1", "job-2", "job-3", "job-4"}, got)
+}
+
+func TestNewPreemptionCheckers(t *testing.T) {
+	t.Parallel()
+
+	got, err := newPreemptionCheckers([]string{"ec2", "gcp", "azure"})
+	if err != nil {
+		t.Fatalf("newPreemptionCheckers(ec2, gcp, azure) error = %v", err)
+	}
+	assert.Equal(t, []agent.PreemptionChecker{agent.EC2MetaData{}, agent.GCPMetaData{}, agent.AzureMetaData{}}, got)
+
+	if _, err := newPreemptionCheckers([]string{"ec2", "digitalocean"}); err == nil {
+		t.Errorf("newPreemptionCheckers(ec2, digitalocean) error = nil, want an error")
+	}
+}
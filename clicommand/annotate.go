@@ -7,6 +7,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/stdin"
 	"github.com/buildkite/agent/v3/logger"
@@ -46,20 +47,32 @@ leave context blank, it will use the default context.
 You can also update only the style of an existing annotation by omitting the
 body entirely and providing a new style value.
 
+If a job appends to an annotation repeatedly, such as in a loop, the annotation
+can grow past the maximum size, at which point annotating fails. With
+--append-with-budget, the body is appended as with --append until the
+annotation would be too big. Then the oldest appended sections are removed,
+and replaced with a note saying so, and a warning is logged. Since annotation
+contexts are shared by the whole build, the job ID is added to the context
+(e.g. "progress-<job id>"), so that each job only ever removes content it
+appended itself. If the annotation can't be updated because it is too big, a
+warning is logged rather than failing the job.
+
 Example:
 
     $ buildkite-agent annotate "All tests passed! :your-emoji: like :rocket:"
     $ cat annotation.md | buildkite-agent annotate --style "warning"
     $ buildkite-agent annotate --style "success" --context "junit"
-    $ ./script/dynamic_annotation_generator | buildkite-agent annotate --style "success"`
+    $ ./script/dynamic_annotation_generator | buildkite-agent annotate --style "success"
+    $ tail -n 20 build.log | buildkite-agent annotate --context "progress" --append-with-budget`
 
 type AnnotateConfig struct {
-	Body     string `cli:"arg:0" label:"annotation body"`
-	Style    string `cli:"style"`
-	Context  string `cli:"context"`
-	Append   bool   `cli:"append"`
-	Priority int    `cli:"priority"`
-	Job      string `cli:"job" validate:"required"`
+	Body             string `cli:"arg:0" label:"annotation body"`
+	Style            string `cli:"style"`
+	Context          string `cli:"context"`
+	Append           bool   `cli:"append"`
+	AppendWithBudget bool   `cli:"append-with-budget"`
+	Priority         int    `cli:"priority"`
+	Job              string `cli:"job" validate:"required"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -73,6 +86,9 @@ type AnnotateConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var AnnotateCommand = cli.Command{
@@ -95,6 +111,11 @@ var AnnotateCommand = cli.Command{
 			Usage:  "Append to the body of an existing annotation",
 			EnvVar: "BUILDKITE_ANNOTATION_APPEND",
 		},
+		cli.BoolFlag{
+			Name:   "append-with-budget",
+			Usage:  "Append to this job's annotation for the context (the context with the job ID added), removing the oldest sections if it would exceed the maximum size",
+			EnvVar: "BUILDKITE_ANNOTATION_APPEND_WITH_BUDGET",
+		},
 		cli.IntFlag{
 			Name:   "priority",
 			Usage:  "The priority of the annotation (′1′ to ′10′). Annotations with a priority of ′10′ are shown first, while annotations with a priority of ′1′ are shown last.",
@@ -112,6 +133,9 @@ var AnnotateCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -151,6 +175,28 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 		body = string(stdin[:])
 	}
 
+	// With a budget, the body is cut down to fit instead.
+	var budget *annotationBudget
+	annotationContext := cfg.Context
+	appendBody := cfg.Append
+	if cfg.AppendWithBudget {
+		annotationContext = jobAnnotationContext(cfg.Context, cfg.Job)
+		l.Debug("Appending to annotation context %q, which belongs to this job", annotationContext)
+	}
+	if cfg.AppendWithBudget && body != "" {
+		var err error
+		budget, err = loadAnnotationBudget(agent.AnnotationStateDir(cfg.Job), annotationContext)
+		if err != nil {
+			return err
+		}
+		var replace bool
+		body, replace = budget.add(body, maxBodySize)
+		if replace {
+			l.Warn("Removed earlier sections of annotation %q to keep it under %dB", annotationContext, maxBodySize)
+		}
+		appendBody = !replace
+	}
+
 	if bodySize := len(body); bodySize > maxBodySize {
 		return fmt.Errorf("annotation body size (%dB) exceeds maximum (%dB)", bodySize, maxBodySize)
 	}
@@ -162,12 +208,13 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 	annotation := &api.Annotation{
 		Body:     body,
 		Style:    cfg.Style,
-		Context:  cfg.Context,
-		Append:   cfg.Append,
+		Context:  annotationContext,
+		Append:   appendBody,
 		Priority: cfg.Priority,
 	}
 
 	// Retry the annotation a few times before giving up
+	var status int
 	if err := roko.NewRetrier(
 		roko.WithMaxAttempts(5),
 		roko.WithStrategy(roko.Constant(1*time.Second)),
@@ -175,9 +222,12 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 	).DoWithContext(ctx, func(r *roko.Retrier) error {
 		// Attempt to create the annotation
 		resp, err := client.Annotate(ctx, cfg.Job, annotation)
+		if resp != nil {
+			status = resp.StatusCode
+		}
 
 		// Don't bother retrying if the response was one of these statuses
-		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400 || resp.StatusCode == 413 || resp.StatusCode == 422) {
 			r.Break()
 			return err
 		}
@@ -189,10 +239,22 @@ func annotate(ctx context.Context, cfg AnnotateConfig, l logger.Logger) error {
 		}
 		return nil
 	}); err != nil {
+		// A budgeted annotation is best effort, so one that has outgrown what
+		// Buildkite accepts shouldn't fail the job.
+		if cfg.AppendWithBudget && (status == 400 || status == 413 || status == 422) {
+			l.Warn("Couldn't append to annotation %q, it may be too big: %v", annotationContext, err)
+			return nil
+		}
 		return fmt.Errorf("failed to annotate build: %w", err)
 	}
 
 	l.Debug("Successfully annotated build")
 
+	if budget != nil {
+		if err := budget.save(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,108 @@
+package clicommand
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// Placed at the top of an annotation appended to with --append-with-budget,
+// once earlier sections have been removed to keep it under the size limit.
+const annotationTruncatedMarker = "_Earlier sections of this annotation were removed to keep it under the size limit._\n\n"
+
+// annotationBudget keeps track of the sections appended to an annotation with
+// --append-with-budget, so that the oldest can be removed when the annotation
+// gets too big. The Agent API can't return an annotation's current body, so
+// the sections are kept in a file, one per job and annotation context, in a
+// directory for the job that the job runner removes when the job finishes.
+type annotationBudget struct {
+	path string
+
+	Sections []string `json:"sections"`
+}
+
+// jobAnnotationContext returns the context that --append-with-budget annotates
+// for the job. Contexts are shared by every job in the build, but the budget
+// only knows about the sections appended by this job, so replacing the body of
+// a shared annotation could remove other jobs' content. Adding the job ID
+// gives the job an annotation of its own.
+func jobAnnotationContext(annotationContext, jobID string) string {
+	if annotationContext == "" {
+		annotationContext = "default"
+	}
+	return annotationContext + "-" + jobID
+}
+
+// loadAnnotationBudget loads the budget for an annotation from the job's
+// state directory, which is empty if nothing has been appended to it yet.
+func loadAnnotationBudget(dir, annotationContext string) (*annotationBudget, error) {
+	name := fmt.Sprintf("%x.json", sha256.Sum256([]byte(annotationContext)))
+	b := &annotationBudget{path: filepath.Join(dir, name)}
+
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading annotation state: %w", err)
+	}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("parsing annotation state %s: %w", b.path, err)
+	}
+	return b, nil
+}
+
+// add appends a section to the annotation. If the annotation still fits
+// within limit bytes, it returns the section to append. Otherwise it removes
+// the oldest sections (and if need be, the start of the newest) and returns
+// the whole body to replace the annotation with.
+func (b *annotationBudget) add(section string, limit int) (body string, replace bool) {
+	b.Sections = append(b.Sections, section)
+
+	total := 0
+	for _, s := range b.Sections {
+		total += len(s)
+	}
+	if total <= limit {
+		return section, false
+	}
+
+	limit -= len(annotationTruncatedMarker)
+	for total > limit && len(b.Sections) > 1 {
+		total -= len(b.Sections[0])
+		b.Sections = b.Sections[1:]
+	}
+	if last := b.Sections[0]; len(last) > limit {
+		// Keep the end of the section, starting at a character boundary.
+		start := len(last) - limit
+		for start < len(last) && !utf8.RuneStart(last[start]) {
+			start++
+		}
+		b.Sections[0] = last[start:]
+	}
+
+	body = annotationTruncatedMarker
+	for _, s := range b.Sections {
+		body += s
+	}
+	return body, true
+}
+
+// save writes the budget back to its file.
+func (b *annotationBudget) save() error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("marshalling annotation state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+		return fmt.Errorf("creating annotation state directory: %w", err)
+	}
+	if err := os.WriteFile(b.path, data, 0o600); err != nil {
+		return fmt.Errorf("writing annotation state: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package clicommand
 
 import (
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -52,3 +53,108 @@ func TestAnnotateMaxBodySize(t *testing.T) {
 	err := annotate(ctx, cfg, l)
 	assert.Error(t, err, "Annotation body size (1048577) exceeds maximum (1048576)")
 }
+
+func TestAnnotationBudget(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	// Leaves room for 100 bytes of sections once the marker has been added.
+	limit := len(annotationTruncatedMarker) + 100
+	a, b, c := strings.Repeat("a", 60), strings.Repeat("b", 50), strings.Repeat("c", 80)
+
+	budget, err := loadAnnotationBudget(dir, "progress")
+	assert.NoError(t, err)
+
+	// Sections are appended while they fit.
+	body, replace := budget.add(a, limit)
+	assert.Equal(t, a, body)
+	assert.False(t, replace)
+	assert.NoError(t, budget.save())
+
+	// The budget is kept between runs.
+	budget, err = loadAnnotationBudget(dir, "progress")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{a}, budget.Sections)
+
+	body, replace = budget.add(b, limit)
+	assert.Equal(t, b, body)
+	assert.False(t, replace)
+
+	// Once they don't, the oldest sections are removed.
+	body, replace = budget.add(c, limit)
+	assert.Equal(t, annotationTruncatedMarker+c, body)
+	assert.True(t, replace)
+	assert.Equal(t, []string{c}, budget.Sections)
+
+	// A section too big on its own keeps its end, from a character boundary.
+	body, replace = budget.add(strings.Repeat("é", 100)+strings.Repeat("d", 99), limit)
+	assert.Equal(t, annotationTruncatedMarker+strings.Repeat("d", 99), body)
+	assert.True(t, replace)
+
+	// Other contexts have their own budgets.
+	other, err := loadAnnotationBudget(dir, "other")
+	assert.NoError(t, err)
+	assert.Empty(t, other.Sections)
+}
+
+func TestAnnotateWithBudgetTruncates(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var got []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var annotation map[string]any
+		if err := json.NewDecoder(req.Body).Decode(&annotation); err != nil {
+			t.Errorf("decoding annotation: %v", err)
+		}
+		got = append(got, annotation)
+		io.WriteString(rw, `{}`)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := AnnotateConfig{
+		Job:              "jobid",
+		Context:          "progress",
+		AppendWithBudget: true,
+		AgentAccessToken: "agentaccesstoken",
+		Endpoint:         server.URL,
+	}
+
+	section := strings.Repeat("a", maxBodySize/2)
+	for range 3 {
+		cfg.Body = section
+		assert.NoError(t, annotate(ctx, cfg, logger.NewBuffer()))
+	}
+
+	if assert.Len(t, got, 3) {
+		for _, annotation := range got {
+			assert.Equal(t, "progress-jobid", annotation["context"])
+		}
+		assert.Equal(t, true, got[0]["append"])
+		assert.Equal(t, true, got[1]["append"])
+		assert.Nil(t, got[2]["append"])
+		assert.Equal(t, annotationTruncatedMarker+section, got[2]["body"])
+	}
+}
+
+func TestAnnotateWithBudgetWarnsWhenTooBig(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		io.WriteString(rw, `{"message":"Body is too large"}`)
+	}))
+	defer server.Close()
+
+	cfg := AnnotateConfig{
+		Body:             "abc",
+		Job:              "jobid",
+		AppendWithBudget: true,
+		AgentAccessToken: "agentaccesstoken",
+		Endpoint:         server.URL,
+	}
+	l := logger.NewBuffer()
+
+	assert.NoError(t, annotate(context.Background(), cfg, l))
+	assert.Contains(t, strings.Join(l.Messages, "\n"), `[warn] Couldn't append to annotation "default-jobid"`)
+}
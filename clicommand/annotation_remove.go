@@ -42,6 +42,9 @@ type AnnotationRemoveConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var AnnotationRemoveCommand = cli.Command{
@@ -66,6 +69,9 @@ var AnnotationRemoveCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
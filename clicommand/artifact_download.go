@@ -2,7 +2,10 @@ package clicommand
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/artifact"
@@ -16,7 +19,8 @@ const downloadHelpDescription = `Usage:
 Description:
 
 Downloads artifacts matching <query> from Buildkite to <destination>
-directory on the local machine.
+directory on the local machine. If <destination> is '-', or --stdout is
+given, the one artifact matching <query> is written to stdout instead.
 
 Note: You need to ensure that your search query is surrounded by quotes if
 using a wild card as the built-in shell path globbing will expand the wild
@@ -44,14 +48,50 @@ jobs, you can target the particular job you want to download the artifact from:
 
     $ buildkite-agent artifact download "pkg/*.tar.gz" . --step "tests" --build xxx
 
-You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)`
+You can also use the step's jobs id (provided by the environment variable $BUILDKITE_JOB_ID)
+
+To only download artifacts that were uploaded with some metadata, such as the
+variant of a multi-variant build, filter by it:
+
+    $ buildkite-agent artifact download "pkg/*.tar.gz" . --metadata variant=release --metadata arch=arm64
+
+A single artifact can be written to stdout, to pipe it to another command
+without saving it first. Encrypted artifacts are decrypted as they are
+written:
+
+    $ buildkite-agent artifact download build.tar.zst - | tar --zstd -x
+
+Archives (.tar, .tar.gz, .tgz, .tar.zst and .tzst) can be extracted into
+<destination> as they are downloaded, so the archive itself never takes up
+disk space. Any other artifacts that match are downloaded as usual:
+
+    $ buildkite-agent artifact download "build/*.tar.zst" . --extract
+
+Large artifacts can be downloaded in parallel chunks, and resumed if the
+download is interrupted:
+
+    $ buildkite-agent artifact download "pkg/*.tar.gz" . --download-concurrency 8 --resume
+
+Artifacts that were encrypted on upload are decrypted automatically. Artifacts
+encrypted with a key file require the same key file to be provided:
+
+    $ buildkite-agent artifact download --artifact-encryption-key-file /etc/buildkite-agent/artifact.key "pkg/*.tar.gz" .`
 
 type ArtifactDownloadConfig struct {
-	Query              string `cli:"arg:0" label:"artifact search query" validate:"required"`
-	Destination        string `cli:"arg:1" label:"artifact download path" validate:"required"`
-	Step               string `cli:"step"`
-	Build              string `cli:"build" validate:"required"`
-	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
+	Query              string   `cli:"arg:0" label:"artifact search query" validate:"required"`
+	Destination        string   `cli:"arg:1" label:"artifact download path"`
+	Step               string   `cli:"step"`
+	Build              string   `cli:"build" validate:"required"`
+	IncludeRetriedJobs bool     `cli:"include-retried-jobs"`
+	Metadata           []string `cli:"metadata"`
+	Stdout             bool     `cli:"stdout"`
+	Extract            bool     `cli:"extract"`
+
+	DownloadConcurrency int  `cli:"download-concurrency"`
+	Resume              bool `cli:"resume"`
+
+	// Encryption flags
+	EncryptionKeyFile string `cli:"artifact-encryption-key-file" normalize:"filepath"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -66,6 +106,9 @@ type ArtifactDownloadConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var ArtifactDownloadCommand = cli.Command{
@@ -89,11 +132,38 @@ var ArtifactDownloadCommand = cli.Command{
 			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
 			Usage:  "Include artifacts from retried jobs in the search",
 		},
+		cli.StringSliceFlag{
+			Name:  "metadata",
+			Value: &cli.StringSlice{},
+			Usage: "Only download artifacts uploaded with this metadata, given as key=value. Can be given more than once, and artifacts must have all of them",
+		},
+		cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "Write the artifact to stdout instead of saving it. The query must match exactly one artifact. The same as a destination of '-'",
+		},
+		cli.BoolFlag{
+			Name:  "extract",
+			Usage: "Extract tar archives into the destination as they are downloaded, instead of saving them. Chunked and resumed downloads aren't used for archives",
+		},
+		cli.IntFlag{
+			Name:   "download-concurrency",
+			Value:  1,
+			EnvVar: "BUILDKITE_ARTIFACT_DOWNLOAD_CONCURRENCY",
+			Usage:  "The number of chunks of each artifact to download in parallel using range requests. Values above 1 enable chunked downloads",
+		},
+		cli.BoolFlag{
+			Name:   "resume",
+			EnvVar: "BUILDKITE_ARTIFACT_DOWNLOAD_RESUME",
+			Usage:  "Keep partially downloaded artifacts when a download fails, and resume them on the next attempt. Not supported for Azure Blob Storage",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 		TraceHTTPFlag,
 
@@ -103,15 +173,37 @@ var ArtifactDownloadCommand = cli.Command{
 		LogLevelFlag,
 		ExperimentsFlag,
 		ProfileFlag,
+		ArtifactEncryptionKeyFileFlag,
 	},
 	Action: func(c *cli.Context) error {
 		ctx := context.Background()
 		ctx, cfg, l, _, done := setupLoggerAndConfig[ArtifactDownloadConfig](ctx, c)
 		defer done()
 
+		var stdout io.Writer
+		switch {
+		case cfg.Stdout || cfg.Destination == "-":
+			if cfg.Extract {
+				return errors.New("--extract can't be used when writing the artifact to stdout")
+			}
+			stdout = os.Stdout
+		case cfg.Destination == "":
+			return errors.New("missing artifact download path")
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
+		encryption, err := loadArtifactEncryptionConfig(cfg.EncryptionKeyFile, "")
+		if err != nil {
+			return fmt.Errorf("failed to load artifact encryption config: %w", err)
+		}
+
+		metadata, err := artifact.ParseMetadata(cfg.Metadata)
+		if err != nil {
+			return err
+		}
+
 		// Setup the downloader
 		downloader := artifact.NewDownloader(l, client, artifact.DownloaderConfig{
 			Query:              cfg.Query,
@@ -119,9 +211,15 @@ var ArtifactDownloadCommand = cli.Command{
 			BuildID:            cfg.Build,
 			Step:               cfg.Step,
 			IncludeRetriedJobs: cfg.IncludeRetriedJobs,
+			Metadata:           metadata,
+			Stdout:             stdout,
+			Extract:            cfg.Extract,
+			Concurrency:        cfg.DownloadConcurrency,
+			Resume:             cfg.Resume,
 			DebugHTTP:          cfg.DebugHTTP,
 			TraceHTTP:          cfg.TraceHTTP,
 			DisableHTTP2:       cfg.NoHTTP2,
+			Encryption:         encryption,
 		})
 
 		// Download the artifacts
@@ -0,0 +1,67 @@
+package clicommand
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/buildkite/agent/v3/internal/artifact"
+	"github.com/buildkite/agent/v3/internal/awslib"
+)
+
+// loadArtifactEncryptionConfig builds the artifact encryption config from the
+// key file and KMS key flags. The KMS client is always configured (lazily) so
+// that artifacts encrypted with KMS can be decrypted on download without any
+// extra flags.
+func loadArtifactEncryptionConfig(keyFile, kmsKey string) (*artifact.EncryptionConfig, error) {
+	conf := &artifact.EncryptionConfig{
+		KMSKeyID: kmsKey,
+		KMS:      &lazyKMSClient{},
+	}
+
+	if keyFile != "" {
+		key, err := artifact.LoadEncryptionKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Key = key
+	}
+
+	return conf, nil
+}
+
+// lazyKMSClient only loads AWS config the first time KMS is actually needed,
+// so that agents not running in AWS aren't affected.
+type lazyKMSClient struct {
+	once   sync.Once
+	client *kms.Client
+	err    error
+}
+
+func (c *lazyKMSClient) load(ctx context.Context) (*kms.Client, error) {
+	c.once.Do(func() {
+		awscfg, err := awslib.GetConfigV2(ctx)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.client = kms.NewFromConfig(awscfg)
+	})
+	return c.client, c.err
+}
+
+func (c *lazyKMSClient) GenerateDataKey(ctx context.Context, in *kms.GenerateDataKeyInput, opts ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	client, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.GenerateDataKey(ctx, in, opts...)
+}
+
+func (c *lazyKMSClient) Decrypt(ctx context.Context, in *kms.DecryptInput, opts ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	client, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Decrypt(ctx, in, opts...)
+}
@@ -2,7 +2,9 @@ package clicommand
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -36,13 +38,32 @@ jobs, you can target the particular job you want to search the artifacts from us
 
     $ buildkite-agent artifact search "pkg/*.tar.gz" --step "tests" --build xxx
 
-You can also use the step's job id (provided by the environment variable $BUILDKITE_JOB_ID)
+You can also use the step's key, or its job id (provided by the environment
+variable $BUILDKITE_JOB_ID).
+
+Multiple queries can be separated with a semicolon, and artifacts matching any
+of them are returned:
+
+    $ buildkite-agent artifact search "pkg/*.tar.gz;pkg/*.zip" --build xxx
+
+By default only artifacts that have finished uploading are found. Use --state to
+find artifacts in another state, such as those whose upload failed:
+
+    $ buildkite-agent artifact search "*" --state error
 
 Output formatting can be altered with the -format flag as follows:
 
     $ buildkite-agent artifact search "*" -format "%p\n"
 
-The above will return a list of filenames separated by newline.`
+The above will return a list of filenames separated by newline.
+
+For use in scripts, '--format json' returns the results as a JSON array:
+
+    $ buildkite-agent artifact search "*" --format json
+
+To only find artifacts that were uploaded with some metadata, filter by it:
+
+    $ buildkite-agent artifact search "pkg/*" --metadata variant=release --metadata arch=arm64`
 
 const artifactSearchHelpTemplate = `{{.Description}}
 
@@ -66,15 +87,35 @@ Format specifiers:
 
   %T    SHA256 checksum of the artifact
 
-  %u    Download URL for the artifact, though consider using 'buildkite-agent artifact download' instead`
+  %u    Download URL for the artifact, though consider using 'buildkite-agent artifact download' instead
+
+  %m    Metadata of the artifact, as comma-separated key=value pairs sorted by key`
+
+// The artifact states that can be searched for.
+var artifactSearchStates = []string{"new", "error", "finished", "deleted"}
+
+// artifactSearchResult is how an artifact is output by --format json.
+type artifactSearchResult struct {
+	ID        string            `json:"id"`
+	Path      string            `json:"path"`
+	JobID     string            `json:"job_id"`
+	FileSize  int64             `json:"file_size"`
+	Sha1Sum   string            `json:"sha1sum"`
+	Sha256Sum string            `json:"sha256sum,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
 
 type ArtifactSearchConfig struct {
-	Query              string `cli:"arg:0" label:"artifact search query" validate:"required"`
-	Step               string `cli:"step"`
-	Build              string `cli:"build" validate:"required"`
-	IncludeRetriedJobs bool   `cli:"include-retried-jobs"`
-	AllowEmptyResults  bool   `cli:"allow-empty-results"`
-	PrintFormat        string `cli:"format"`
+	Query              string   `cli:"arg:0" label:"artifact search query" validate:"required"`
+	Step               string   `cli:"step"`
+	Build              string   `cli:"build" validate:"required"`
+	State              string   `cli:"state"`
+	IncludeRetriedJobs bool     `cli:"include-retried-jobs"`
+	Metadata           []string `cli:"metadata"`
+	AllowEmptyResults  bool     `cli:"allow-empty-results"`
+	PrintFormat        string   `cli:"format"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -88,6 +129,9 @@ type ArtifactSearchConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var ArtifactSearchCommand = cli.Command{
@@ -99,7 +143,7 @@ var ArtifactSearchCommand = cli.Command{
 		cli.StringFlag{
 			Name:  "step",
 			Value: "",
-			Usage: "Scope the search to a particular step by using either its name or job ID",
+			Usage: "Scope the search to a particular step by using either its key, name or job ID",
 		},
 		cli.StringFlag{
 			Name:   "build",
@@ -107,11 +151,21 @@ var ArtifactSearchCommand = cli.Command{
 			EnvVar: "BUILDKITE_BUILD_ID",
 			Usage:  "The build that the artifacts were uploaded to",
 		},
+		cli.StringFlag{
+			Name:  "state",
+			Value: "finished",
+			Usage: "Only find artifacts in this state, one of new, error, finished or deleted",
+		},
 		cli.BoolFlag{
 			Name:   "include-retried-jobs",
 			EnvVar: "BUILDKITE_AGENT_INCLUDE_RETRIED_JOBS",
 			Usage:  "Include artifacts from retried jobs in the search",
 		},
+		cli.StringSliceFlag{
+			Name:  "metadata",
+			Value: &cli.StringSlice{},
+			Usage: "Only find artifacts uploaded with this metadata, given as key=value. Can be given more than once, and artifacts must have all of them",
+		},
 		cli.BoolFlag{
 			Name:  "allow-empty-results",
 			Usage: "By default, searches exit 1 if there are no results. If this flag is set, searches will exit 0 with an empty set",
@@ -119,13 +173,16 @@ var ArtifactSearchCommand = cli.Command{
 		cli.StringFlag{
 			Name:  "format",
 			Value: "%j %p %c\n",
-			Usage: "Output formatting of results. See below for listing of available format specifiers.",
+			Usage: "Output formatting of results, or 'json' for a JSON array. See below for listing of available format specifiers.",
 		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -140,12 +197,26 @@ var ArtifactSearchCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[ArtifactSearchConfig](ctx, c)
 		defer done()
 
+		if !slices.Contains(artifactSearchStates, cfg.State) {
+			return fmt.Errorf("invalid --state %q, must be one of %s", cfg.State, strings.Join(artifactSearchStates, ", "))
+		}
+
+		metadata, err := artifact.ParseMetadata(cfg.Metadata)
+		if err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
 		// Setup the searcher and try get the artifacts
 		searcher := artifact.NewSearcher(l, client, cfg.Build)
-		artifacts, err := searcher.Search(ctx, cfg.Query, cfg.Step, cfg.IncludeRetriedJobs, true)
+		queries := strings.Split(cfg.Query, artifact.ArtifactPathDelimiter)
+		artifacts, err := searcher.SearchPatterns(ctx, queries, cfg.Step, cfg.State, cfg.IncludeRetriedJobs, true)
+		if err != nil {
+			return err
+		}
+		artifacts, err = artifact.FilterByMetadata(artifacts, metadata)
 		if err != nil {
 			return err
 		}
@@ -157,6 +228,27 @@ var ArtifactSearchCommand = cli.Command{
 			l.Info("No matches found for %q", cfg.Query)
 		}
 
+		if cfg.PrintFormat == "json" {
+			results := make([]artifactSearchResult, 0, len(artifacts))
+			for _, artifact := range artifacts {
+				results = append(results, artifactSearchResult{
+					ID:        artifact.ID,
+					Path:      artifact.Path,
+					JobID:     artifact.JobID,
+					FileSize:  artifact.FileSize,
+					Sha1Sum:   artifact.Sha1Sum,
+					Sha256Sum: artifact.Sha256Sum,
+					URL:       artifact.URL,
+					CreatedAt: artifact.CreatedAt,
+					Metadata:  artifact.Metadata,
+				})
+			}
+			if err := json.NewEncoder(c.App.Writer).Encode(results); err != nil {
+				return fmt.Errorf("error marshalling JSON: %w", err)
+			}
+			return nil
+		}
+
 		for _, artifact := range artifacts {
 			r := strings.NewReplacer(
 				"%p", artifact.Path,
@@ -167,6 +259,7 @@ var ArtifactSearchCommand = cli.Command{
 				"%T", artifact.Sha256Sum,
 				"%u", artifact.URL,
 				"%i", artifact.ID,
+				"%m", formatArtifactMetadata(artifact.Metadata),
 			)
 			if _, err := fmt.Fprint(c.App.Writer, r.Replace(cfg.PrintFormat)); err != nil {
 				return err
@@ -176,3 +269,13 @@ var ArtifactSearchCommand = cli.Command{
 		return nil
 	},
 }
+
+// formatArtifactMetadata formats metadata for the %m format specifier.
+func formatArtifactMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
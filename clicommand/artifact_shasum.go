@@ -68,6 +68,9 @@ type ArtifactShasumConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var ArtifactShasumCommand = cli.Command{
@@ -100,6 +103,9 @@ var ArtifactShasumCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -0,0 +1,227 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/artifact"
+	"github.com/urfave/cli"
+)
+
+const syncHelpDescription = `Usage:
+
+    buildkite-agent artifact sync [options] <directory>
+
+Description:
+
+Compares the files within <directory> against the artifacts already uploaded
+for the job, by path and checksum, and only transfers what has changed.
+
+Local files that are missing from the job's artifacts, or whose contents
+differ, are uploaded. Artifacts that are missing locally are downloaded. If a
+file exists in both places but differs, the local copy is uploaded.
+
+<directory> should be relative to the current working directory, in the same
+way as the paths passed to 'buildkite-agent artifact upload'.
+
+Example:
+
+    $ buildkite-agent artifact sync build/cache
+
+Only upload changed files, and show what would happen without doing it:
+
+    $ buildkite-agent artifact sync --direction upload --dry-run build/cache
+
+Artifacts can be synced with another job in the same build using --step:
+
+    $ buildkite-agent artifact sync --step "build" --direction download build/cache
+
+Changed files can be encrypted before they are uploaded, and encrypted
+artifacts are decrypted as they are downloaded, as with 'buildkite-agent
+artifact upload' and 'buildkite-agent artifact download'. The checksums of
+encrypted artifacts are of their encrypted contents, so an encrypted artifact
+never matches the local file, and files that exist in both places are always
+uploaded again.`
+
+type ArtifactSyncConfig struct {
+	Directory   string `cli:"arg:0" label:"sync directory" validate:"required"`
+	Job         string `cli:"job" validate:"required"`
+	Build       string `cli:"build" validate:"required"`
+	Step        string `cli:"step"`
+	Destination string `cli:"destination"`
+	Direction   string `cli:"direction"`
+	DryRun      bool   `cli:"dry-run"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	TraceHTTP        bool   `cli:"trace-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
+
+	// Uploader flags
+	NoMultipartUpload bool `cli:"no-multipart-artifact-upload"`
+
+	// Encryption flags
+	EncryptionKeyFile   string `cli:"artifact-encryption-key-file" normalize:"filepath"`
+	EncryptionAWSKMSKey string `cli:"artifact-encryption-aws-kms-key"`
+}
+
+var ArtifactSyncCommand = cli.Command{
+	Name:        "sync",
+	Usage:       "Uploads and downloads only the artifacts that have changed",
+	Description: syncHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "Which job the artifacts should be uploaded to",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			EnvVar: "BUILDKITE_BUILD_ID",
+			Usage:  "The build that the artifacts were uploaded to",
+		},
+		cli.StringFlag{
+			Name:  "step",
+			Value: "",
+			Usage: "Compare against the artifacts of a particular step, by its key, label or job ID. Defaults to the job given by --job",
+		},
+		cli.StringFlag{
+			Name:   "destination",
+			Value:  "",
+			Usage:  "Where changed files should be uploaded to. See 'buildkite-agent artifact upload --help'",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_DESTINATION",
+		},
+		cli.StringFlag{
+			Name:  "direction",
+			Value: "both",
+			Usage: "Which way to sync: 'upload', 'download' or 'both'",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Print what would be uploaded and downloaded, without transferring anything",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
+		DebugHTTPFlag,
+		TraceHTTPFlag,
+
+		// Encryption flags
+		ArtifactEncryptionKeyFileFlag,
+		ArtifactEncryptionAWSKMSKeyFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+		NoMultipartArtifactUploadFlag,
+	},
+	Action: func(c *cli.Context) error {
+		ctx := context.Background()
+		ctx, cfg, l, _, done := setupLoggerAndConfig[ArtifactSyncConfig](ctx, c)
+		defer done()
+
+		var upload, download bool
+		switch cfg.Direction {
+		case "upload":
+			upload = true
+		case "download":
+			download = true
+		case "both", "":
+			upload, download = true, true
+		default:
+			return fmt.Errorf("invalid --direction %q: must be one of upload, download or both", cfg.Direction)
+		}
+
+		scope := cfg.Step
+		if scope == "" {
+			scope = cfg.Job
+		}
+
+		// Create the API client
+		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+
+		plan, err := artifact.PlanSync(ctx, l, client, cfg.Build, scope, cfg.Directory)
+		if err != nil {
+			return fmt.Errorf("failed to compare artifacts: %w", err)
+		}
+
+		l.Info("%d files unchanged, %d to upload, %d to download", plan.Unchanged, len(plan.Upload), len(plan.Download))
+
+		if cfg.DryRun {
+			if upload {
+				for _, path := range plan.Upload {
+					fmt.Fprintf(c.App.Writer, "upload %s\n", path)
+				}
+			}
+			if download {
+				for _, a := range plan.Download {
+					fmt.Fprintf(c.App.Writer, "download %s\n", a.Path)
+				}
+			}
+			return nil
+		}
+
+		encryption, err := loadArtifactEncryptionConfig(cfg.EncryptionKeyFile, cfg.EncryptionAWSKMSKey)
+		if err != nil {
+			return fmt.Errorf("failed to load artifact encryption config: %w", err)
+		}
+
+		if upload && len(plan.Upload) > 0 {
+			uploader := artifact.NewUploader(l, client, artifact.UploaderConfig{
+				JobID: cfg.Job,
+				// The paths are of files that were found by walking the
+				// directory, so shouldn't be taken as globs.
+				LiteralPaths:   plan.Upload,
+				Destination:    cfg.Destination,
+				DebugHTTP:      cfg.DebugHTTP,
+				TraceHTTP:      cfg.TraceHTTP,
+				DisableHTTP2:   cfg.NoHTTP2,
+				AllowMultipart: !cfg.NoMultipartUpload,
+				Encryption:     encryption,
+			})
+			if err := uploader.Upload(ctx); err != nil {
+				return fmt.Errorf("failed to upload artifacts: %w", err)
+			}
+		}
+
+		if download && len(plan.Download) > 0 {
+			downloader := artifact.NewDownloader(l, client, artifact.DownloaderConfig{
+				BuildID:      cfg.Build,
+				Step:         scope,
+				DebugHTTP:    cfg.DebugHTTP,
+				TraceHTTP:    cfg.TraceHTTP,
+				DisableHTTP2: cfg.NoHTTP2,
+				Encryption:   encryption,
+			})
+			// Artifact paths already include the sync directory.
+			if err := downloader.DownloadArtifacts(ctx, plan.Download, "."); err != nil {
+				return fmt.Errorf("failed to download artifacts: %w", err)
+			}
+		}
+
+		return nil
+	},
+}
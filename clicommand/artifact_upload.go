@@ -2,10 +2,14 @@ package clicommand
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/artifact"
+	"github.com/buildkite/agent/v3/metrics"
 	"github.com/urfave/cli"
 )
 
@@ -21,8 +25,8 @@ You need to ensure that the paths are surrounded by quotes otherwise the
 built-in shell path globbing will provide the files, which is currently not
 supported.
 
-You can specify an alternate destination on Amazon S3, Google Cloud Storage
-or Artifactory as per the examples below. This may be specified in the
+You can specify an alternate destination on Amazon S3, Google Cloud Storage,
+Artifactory or Azure Blob Storage as per the examples below. This may be specified in the
 'destination' argument, or in the 'BUILDKITE_ARTIFACT_UPLOAD_DESTINATION'
 environment variable.  Otherwise, artifacts are uploaded to a
 Buildkite-managed Amazon S3 bucket, where they’re retained for six months.
@@ -31,6 +35,18 @@ Example:
 
     $ buildkite-agent artifact upload "log/**/*.log"
 
+Multiple patterns are separated by semicolons. Braces match any of their
+comma-separated alternatives, a directory uploads everything within it, and a
+pattern starting with '!' excludes the files it matches:
+
+    $ buildkite-agent artifact upload "coverage/;dist/*.{tar.gz,zip};!(**/*.tmp)"
+
+To label artifacts, such as the variants of a multi-variant build, store
+metadata with them, which 'artifact search' and 'artifact download' can filter
+by:
+
+    $ buildkite-agent artifact upload "pkg/*.tar.gz" --metadata variant=release --metadata arch=arm64
+
 You can also upload directly to Amazon S3 if you'd like to host your own artifacts:
 
     $ export BUILDKITE_S3_ACCESS_KEY_ID=xxx
@@ -43,6 +59,16 @@ You can use Amazon IAM assumed roles by specifying the session token:
 
     $ export BUILDKITE_S3_SESSION_TOKEN=zzz
 
+To upload to an S3-compatible service, such as Cloudflare R2 or MinIO, set its
+endpoint. Path-style addressing is used unless BUILDKITE_S3_FORCE_PATH_STYLE is
+false, and server-side encryption headers are only sent if
+BUILDKITE_S3_SSE_ENABLED is true. Checksums can be sent using one of CRC32,
+CRC32C, SHA1 or SHA256, if the service supports it:
+
+    $ export BUILDKITE_S3_ENDPOINT=https://xxx.r2.cloudflarestorage.com
+    $ export BUILDKITE_S3_CHECKSUM_ALGORITHM=CRC32 # default is none
+    $ buildkite-agent artifact upload "log/**/*.log" s3://name-of-your-bucket/$BUILDKITE_JOB_ID
+
 Or upload directly to Google Cloud Storage:
 
     $ export BUILDKITE_GS_ACL=private
@@ -55,22 +81,89 @@ Or upload directly to Artifactory:
     $ export BUILDKITE_ARTIFACTORY_PASSWORD=xxx
     $ buildkite-agent artifact upload "log/**/*.log" rt://name-of-your-artifactory-repo/$BUILDKITE_JOB_ID
 
+To get the password or an access token from a command instead, which is run
+again if Artifactory rejects it, so it can be rotated, set a credential helper.
+Without BUILDKITE_ARTIFACTORY_USER, its output is sent as an access token. To
+skip uploading files Artifactory already has a copy of, deploy them by
+checksum first:
+
+    $ export BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER="/usr/local/bin/artifactory-token"
+    $ export BUILDKITE_ARTIFACTORY_CHECKSUM_DEPLOY=true
+    $ buildkite-agent artifact upload "dist/*" rt://name-of-your-artifactory-repo/$BUILDKITE_JOB_ID
+
+When Artifactory throttles uploads, they're tried again after the time it asks
+for.
+
+Or upload directly to Azure Blob Storage, authenticating with a SAS token,
+a connection string, a shared access key, or a managed identity:
+
+    $ export BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT=my-storage-account
+    $ export BUILDKITE_AZURE_BLOB_SAS_TOKEN=sv=...
+    $ buildkite-agent artifact upload "log/**/*.log" azblob://my-container/$BUILDKITE_JOB_ID
+
+To use a user-assigned managed identity, set its client ID instead of a token:
+
+    $ export BUILDKITE_AZURE_BLOB_MANAGED_IDENTITY_CLIENT_ID=xxx
+
+Without any of these, the default Azure credential chain is used, which
+includes any system-assigned managed identity.
+
 By default, symlinks to directories will not be explored when resolving the glob, but symlinks to
 files will be uploaded as the linked files. To ignore symlinks to files use:
 
     $ buildkite-agent artifact upload --upload-skip-symlinks "log/**/*.log"
 
-Note: uploading symlinks to files without following them is not supported.
-If you need to preserve them in a directory, we recommend creating a tar archive:
+To explore symlinks to directories too, use --follow-symlinks. A symlink that
+links to a directory containing it would be explored forever, so finding a
+file through one is an error, as is a symlink that can't be followed.
+
+To keep symlinks as symlinks instead, use --preserve-symlinks. As symlinks
+can't be uploaded on their own, they are kept in a bundle, so --compress must
+be used too. 'buildkite-agent artifact download' recreates them when it
+extracts the bundle:
+
+    $ buildkite-agent artifact upload --compress zstd --preserve-symlinks "dist/**/*"
+
+Hard links to the same file are uploaded as separate artifacts, or kept as hard
+links in a bundle. The holes in sparse files are uploaded as zeros, but a
+bundle compresses them, and they are recreated when the bundle is extracted.
+
+To encrypt artifacts before they leave the agent, provide a 256-bit key file or
+an AWS KMS key. Encrypted artifacts are transparently decrypted by
+'buildkite-agent artifact download':
+
+    $ buildkite-agent artifact upload --artifact-encryption-key-file /etc/buildkite-agent/artifact.key "log/**/*.log" s3://name-of-your-s3-bucket/$BUILDKITE_JOB_ID
+    $ buildkite-agent artifact upload --artifact-encryption-aws-kms-key alias/artifacts "log/**/*.log"
+
+Uploading many small files can be slow, as each is uploaded separately. To
+bundle all the files into a single tar archive compressed with gzip or zstd,
+use --compress. The archive is named after the directory the files have in
+common, with a unique suffix so that it doesn't replace an earlier one (here,
+something like log-1a2b3c4d.tar.zst). 'buildkite-agent artifact download'
+looks inside bundles for the files that match its query, and extracts them
+transparently:
+
+    $ buildkite-agent artifact upload --compress zstd "log/**/*.log"
+
+Files are uploaded as they are found, so uploads of many files start straight
+away. The progress of long uploads is logged every 10 seconds. To upload more
+(or fewer) files at once, set the upload concurrency:
+
+    $ buildkite-agent artifact upload --upload-concurrency 32 "log/**/*.log"
+
+Note: uploading symlinks to files without following them is only supported in
+bundles. To preserve them without --compress, we recommend creating a tar
+archive:
 
     $ tar -cvf log.tar log/**/*
     $ buildkite-agent upload log.tar`
 
 type ArtifactUploadConfig struct {
-	UploadPaths string `cli:"arg:0" label:"upload paths" validate:"required"`
-	Destination string `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION"`
-	Job         string `cli:"job" validate:"required"`
-	ContentType string `cli:"content-type"`
+	UploadPaths string   `cli:"arg:0" label:"upload paths" validate:"required"`
+	Destination string   `cli:"arg:1" label:"destination" env:"BUILDKITE_ARTIFACT_UPLOAD_DESTINATION"`
+	Job         string   `cli:"job" validate:"required"`
+	ContentType string   `cli:"content-type"`
+	Metadata    []string `cli:"metadata"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -85,14 +178,22 @@ type ArtifactUploadConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 
 	// Uploader flags
-	GlobResolveFollowSymlinks bool `cli:"glob-resolve-follow-symlinks"`
-	UploadSkipSymlinks        bool `cli:"upload-skip-symlinks"`
-	NoMultipartUpload         bool `cli:"no-multipart-artifact-upload"`
-
-	// deprecated
-	FollowSymlinks bool `cli:"follow-symlinks" deprecated-and-renamed-to:"GlobResolveFollowSymlinks"`
+	GlobResolveFollowSymlinks bool   `cli:"glob-resolve-follow-symlinks"`
+	UploadSkipSymlinks        bool   `cli:"upload-skip-symlinks"`
+	FollowSymlinks            bool   `cli:"follow-symlinks"`
+	PreserveSymlinks          bool   `cli:"preserve-symlinks"`
+	NoMultipartUpload         bool   `cli:"no-multipart-artifact-upload"`
+	Compress                  string `cli:"compress"`
+	UploadConcurrency         int    `cli:"upload-concurrency"`
+
+	// Encryption flags
+	EncryptionKeyFile   string `cli:"artifact-encryption-key-file" normalize:"filepath"`
+	EncryptionAWSKMSKey string `cli:"artifact-encryption-aws-kms-key"`
 }
 
 var ArtifactUploadCommand = cli.Command{
@@ -112,6 +213,12 @@ var ArtifactUploadCommand = cli.Command{
 			Usage:  "A specific Content-Type to set for the artifacts (otherwise detected)",
 			EnvVar: "BUILDKITE_ARTIFACT_CONTENT_TYPE",
 		},
+		cli.StringSliceFlag{
+			Name:   "metadata",
+			Value:  &cli.StringSlice{},
+			Usage:  "Metadata to store with each artifact, given as key=value, which 'artifact search' and 'artifact download' can filter by. Can be given more than once",
+			EnvVar: "BUILDKITE_ARTIFACT_METADATA",
+		},
 		cli.BoolFlag{
 			Name:   "glob-resolve-follow-symlinks",
 			Usage:  "Follow symbolic links to directories while resolving globs. Note: this will not prevent symlinks to files from being uploaded. Use --upload-skip-symlinks to do that",
@@ -122,16 +229,36 @@ var ArtifactUploadCommand = cli.Command{
 			Usage:  "After the glob has been resolved to a list of files to upload, skip uploading those that are symlinks to files",
 			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_SKIP_SYMLINKS",
 		},
-		cli.BoolFlag{ // Deprecated
+		cli.StringFlag{
+			Name:   "compress",
+			Value:  "",
+			Usage:  "Bundle the files into a single archive compressed with this format (one of gzip or zstd) before uploading",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_COMPRESS",
+		},
+		cli.IntFlag{
+			Name:   "upload-concurrency",
+			Value:  0,
+			Usage:  "The number of artifacts (or parts of artifacts) to upload in parallel. Defaults to the number of CPUs",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_CONCURRENCY",
+		},
+		cli.BoolFlag{
 			Name:   "follow-symlinks",
-			Usage:  "Follow symbolic links while resolving globs. Note this argument is deprecated. Use `--glob-resolve-follow-symlinks` instead",
+			Usage:  "Follow symbolic links to directories while resolving globs, and upload symlinks to files as the linked files. The same as --glob-resolve-follow-symlinks",
 			EnvVar: "BUILDKITE_AGENT_ARTIFACT_SYMLINKS",
 		},
+		cli.BoolFlag{
+			Name:   "preserve-symlinks",
+			Usage:  "Keep symbolic links as symbolic links in the bundle, rather than following them. Requires --compress",
+			EnvVar: "BUILDKITE_ARTIFACT_UPLOAD_PRESERVE_SYMLINKS",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 		TraceHTTPFlag,
 
@@ -142,31 +269,63 @@ var ArtifactUploadCommand = cli.Command{
 		ExperimentsFlag,
 		ProfileFlag,
 		NoMultipartArtifactUploadFlag,
+		ArtifactEncryptionKeyFileFlag,
+		ArtifactEncryptionAWSKMSKeyFlag,
 	},
 	Action: func(c *cli.Context) error {
 		ctx := context.Background()
 		ctx, cfg, l, _, done := setupLoggerAndConfig[ArtifactUploadConfig](ctx, c)
 		defer done()
 
+		if cfg.Compress != "" && !slices.Contains(artifact.CompressionFormats, cfg.Compress) {
+			return fmt.Errorf("invalid --compress %q, must be one of %s", cfg.Compress, strings.Join(artifact.CompressionFormats, ", "))
+		}
+
+		followSymlinks := cfg.GlobResolveFollowSymlinks || cfg.FollowSymlinks
+		if cfg.PreserveSymlinks {
+			switch {
+			case cfg.Compress == "":
+				return errors.New("--preserve-symlinks requires --compress, as symlinks can only be uploaded in a bundle")
+			case followSymlinks:
+				return errors.New("--preserve-symlinks can't be used with --follow-symlinks or --glob-resolve-follow-symlinks")
+			case cfg.UploadSkipSymlinks:
+				return errors.New("--preserve-symlinks can't be used with --upload-skip-symlinks")
+			}
+		}
+
+		metadata, err := artifact.ParseMetadata(cfg.Metadata)
+		if err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
+		encryption, err := loadArtifactEncryptionConfig(cfg.EncryptionKeyFile, cfg.EncryptionAWSKMSKey)
+		if err != nil {
+			return fmt.Errorf("failed to load artifact encryption config: %w", err)
+		}
+
 		// Setup the uploader
 		uploader := artifact.NewUploader(l, client, artifact.UploaderConfig{
 			JobID:        cfg.Job,
 			Paths:        cfg.UploadPaths,
 			Destination:  cfg.Destination,
 			ContentType:  cfg.ContentType,
+			Metadata:     metadata,
 			DebugHTTP:    cfg.DebugHTTP,
 			TraceHTTP:    cfg.TraceHTTP,
 			DisableHTTP2: cfg.NoHTTP2,
 
 			AllowMultipart: !cfg.NoMultipartUpload,
+			Concurrency:    cfg.UploadConcurrency,
 
-			// If the deprecated flag was set to true, pretend its replacement was set to true too
-			// this works as long as the user only sets one of the two flags
-			GlobResolveFollowSymlinks: (cfg.GlobResolveFollowSymlinks || cfg.FollowSymlinks),
+			GlobResolveFollowSymlinks: followSymlinks,
 			UploadSkipSymlinks:        cfg.UploadSkipSymlinks,
+			PreserveSymlinks:          cfg.PreserveSymlinks,
+
+			Encryption:  encryption,
+			Compression: cfg.Compress,
 		})
 
 		// Upload the artifacts
@@ -174,6 +333,12 @@ var ArtifactUploadCommand = cli.Command{
 			return fmt.Errorf("failed to upload artifacts: %w", err)
 		}
 
+		// The agent reports this once the job finishes, if it's collecting
+		// metrics
+		if err := metrics.AppendJobCount("artifacts.uploaded_bytes", uploader.UploadedBytes(), nil); err != nil {
+			l.Warn("Couldn't record artifact upload metrics: %v", err)
+		}
+
 		return nil
 	},
 }
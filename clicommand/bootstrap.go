@@ -2,14 +2,20 @@ package clicommand
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/buildkite/agent/v3/internal/job"
+	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/urfave/cli"
@@ -44,64 +50,97 @@ Example:
     $ buildkite-agent bootstrap --build-path builds`
 
 type BootstrapConfig struct {
-	Command                      string   `cli:"command"`
-	JobID                        string   `cli:"job" validate:"required"`
-	Repository                   string   `cli:"repository" validate:"required"`
-	Commit                       string   `cli:"commit" validate:"required"`
-	Branch                       string   `cli:"branch" validate:"required"`
-	Tag                          string   `cli:"tag"`
-	RefSpec                      string   `cli:"refspec"`
-	Plugins                      string   `cli:"plugins"`
-	PullRequest                  string   `cli:"pullrequest"`
-	GitSubmodules                bool     `cli:"git-submodules"`
-	SSHKeyscan                   bool     `cli:"ssh-keyscan"`
-	AgentName                    string   `cli:"agent" validate:"required"`
-	Queue                        string   `cli:"queue"`
-	OrganizationSlug             string   `cli:"organization" validate:"required"`
-	PipelineSlug                 string   `cli:"pipeline" validate:"required"`
-	PipelineProvider             string   `cli:"pipeline-provider" validate:"required"`
-	AutomaticArtifactUploadPaths string   `cli:"artifact-upload-paths"`
-	ArtifactUploadDestination    string   `cli:"artifact-upload-destination"`
-	CleanCheckout                bool     `cli:"clean-checkout"`
-	GitCheckoutFlags             string   `cli:"git-checkout-flags"`
-	GitCloneFlags                string   `cli:"git-clone-flags"`
-	GitFetchFlags                string   `cli:"git-fetch-flags"`
-	GitCloneMirrorFlags          string   `cli:"git-clone-mirror-flags"`
-	GitCleanFlags                string   `cli:"git-clean-flags"`
-	GitMirrorsPath               string   `cli:"git-mirrors-path" normalize:"filepath"`
-	GitMirrorsLockTimeout        int      `cli:"git-mirrors-lock-timeout"`
-	GitMirrorsSkipUpdate         bool     `cli:"git-mirrors-skip-update"`
-	GitSubmoduleCloneConfig      []string `cli:"git-submodule-clone-config"`
-	BinPath                      string   `cli:"bin-path" normalize:"filepath"`
-	BuildPath                    string   `cli:"build-path" normalize:"filepath"`
-	HooksPath                    string   `cli:"hooks-path" normalize:"filepath"`
-	AdditionalHooksPaths         []string `cli:"additional-hooks-paths" normalize:"list"`
-	SocketsPath                  string   `cli:"sockets-path" normalize:"filepath"`
-	PluginsPath                  string   `cli:"plugins-path" normalize:"filepath"`
-	CommandEval                  bool     `cli:"command-eval"`
-	PluginsEnabled               bool     `cli:"plugins-enabled"`
-	PluginValidation             bool     `cli:"plugin-validation"`
-	PluginsAlwaysCloneFresh      bool     `cli:"plugins-always-clone-fresh"`
-	LocalHooksEnabled            bool     `cli:"local-hooks-enabled"`
-	StrictSingleHooks            bool     `cli:"strict-single-hooks"`
-	PTY                          bool     `cli:"pty"`
-	LogLevel                     string   `cli:"log-level"`
-	Debug                        bool     `cli:"debug"`
-	Shell                        string   `cli:"shell"`
-	Experiments                  []string `cli:"experiment" normalize:"list"`
-	Phases                       []string `cli:"phases" normalize:"list"`
-	Profile                      string   `cli:"profile"`
-	CancelSignal                 string   `cli:"cancel-signal"`
-	CancelGracePeriod            int      `cli:"cancel-grace-period"`
-	SignalGracePeriodSeconds     int      `cli:"signal-grace-period-seconds"`
-	RedactedVars                 []string `cli:"redacted-vars" normalize:"list"`
-	TracingBackend               string   `cli:"tracing-backend"`
-	TracingServiceName           string   `cli:"tracing-service-name"`
-	TraceContextEncoding         string   `cli:"trace-context-encoding"`
-	NoJobAPI                     bool     `cli:"no-job-api"`
-	DisableWarningsFor           []string `cli:"disable-warnings-for" normalize:"list"`
-	KubernetesExec               bool     `cli:"kubernetes-exec"`
-	KubernetesContainerID        int      `cli:"kubernetes-container-id"`
+	Command                      string        `cli:"command"`
+	JobID                        string        `cli:"job" validate:"required"`
+	Repository                   string        `cli:"repository" validate:"required"`
+	Commit                       string        `cli:"commit" validate:"required"`
+	Branch                       string        `cli:"branch" validate:"required"`
+	Tag                          string        `cli:"tag"`
+	RefSpec                      string        `cli:"refspec"`
+	Plugins                      string        `cli:"plugins"`
+	PullRequest                  string        `cli:"pullrequest"`
+	GitSubmodules                bool          `cli:"git-submodules"`
+	GitLFSSkip                   bool          `cli:"git-lfs-skip"`
+	SSHKeyscan                   bool          `cli:"ssh-keyscan"`
+	AgentName                    string        `cli:"agent" validate:"required"`
+	Queue                        string        `cli:"queue"`
+	OrganizationSlug             string        `cli:"organization" validate:"required"`
+	PipelineSlug                 string        `cli:"pipeline" validate:"required"`
+	PipelineProvider             string        `cli:"pipeline-provider" validate:"required"`
+	AutomaticArtifactUploadPaths string        `cli:"artifact-upload-paths"`
+	ArtifactUploadDestination    string        `cli:"artifact-upload-destination"`
+	CleanCheckout                bool          `cli:"clean-checkout"`
+	SkipCheckout                 bool          `cli:"skip-checkout"`
+	VCS                          string        `cli:"vcs"`
+	GitCheckoutFlags             string        `cli:"git-checkout-flags"`
+	GitCloneFlags                string        `cli:"git-clone-flags"`
+	GitCloneBundle               string        `cli:"git-clone-bundle"`
+	GitFetchFlags                string        `cli:"git-fetch-flags"`
+	GitCloneMirrorFlags          string        `cli:"git-clone-mirror-flags"`
+	GitCleanFlags                string        `cli:"git-clean-flags"`
+	GitMirrorsPath               string        `cli:"git-mirrors-path" normalize:"filepath"`
+	GitMirrorsLockTimeout        int           `cli:"git-mirrors-lock-timeout"`
+	CheckoutRetryAttempts        int           `cli:"checkout-retry-attempts"`
+	CheckoutRetryBackoff         time.Duration `cli:"checkout-retry-backoff"`
+	GitMirrorsSkipUpdate         bool          `cli:"git-mirrors-skip-update"`
+	GitMirrorsWorktree           bool          `cli:"git-mirrors-worktree"`
+	EphemeralBuildDir            bool          `cli:"ephemeral-build-dir"`
+	GitSubmoduleCloneConfig      []string      `cli:"git-submodule-clone-config"`
+	GitSubmoduleCloneFlags       []string      `cli:"git-submodule-clone-flags"`
+	GitSubmoduleCredentials      []string      `cli:"git-submodule-credentials"`
+	BinPath                      string        `cli:"bin-path" normalize:"filepath"`
+	BuildPath                    string        `cli:"build-path" normalize:"filepath"`
+	HooksPath                    string        `cli:"hooks-path" normalize:"filepath"`
+	AdditionalHooksPaths         []string      `cli:"additional-hooks-paths" normalize:"list"`
+	SocketsPath                  string        `cli:"sockets-path" normalize:"filepath"`
+	PluginsPath                  string        `cli:"plugins-path" normalize:"filepath"`
+	CommandEval                  bool          `cli:"command-eval"`
+	CommandSandbox               string        `cli:"command-sandbox"`
+	CommandSandboxAllowPaths     []string      `cli:"command-sandbox-allow-paths" normalize:"list"`
+	CommandEnvAllow              []string      `cli:"command-env-allow" normalize:"list"`
+	CommandEnvDeny               []string      `cli:"command-env-deny" normalize:"list"`
+	CommandRetry                 int           `cli:"command-retry"`
+	CommandRetryExitStatus       []string      `cli:"command-retry-exit-status" normalize:"list"`
+	SoftFailExitStatus           []string      `cli:"soft-fail-exit-status" normalize:"list"`
+	SoftFailClassifier           string        `cli:"soft-fail-classifier" normalize:"filepath"`
+	ProtectedEnv                 []string      `cli:"protected-env" normalize:"list"`
+	ProtectedEnvEnforce          bool          `cli:"protected-env-enforce"`
+	PluginsEnabled               bool          `cli:"plugins-enabled"`
+	PluginValidation             bool          `cli:"plugin-validation"`
+	PluginsAlwaysCloneFresh      bool          `cli:"plugins-always-clone-fresh"`
+	PluginLockFile               string        `cli:"plugin-lock-file" normalize:"filepath"`
+	PluginsAllowDrift            bool          `cli:"plugins-allow-drift"`
+	AllowedPlugins               []string      `cli:"allowed-plugins" normalize:"list"`
+	AllowedRepositories          []string      `cli:"allowed-repositories" normalize:"list"`
+	LocalHooksEnabled            bool          `cli:"local-hooks-enabled"`
+	StrictSingleHooks            bool          `cli:"strict-single-hooks"`
+	HookInterpreters             []string      `cli:"hook-interpreters" normalize:"list"`
+	PTY                          bool          `cli:"pty"`
+	PTYRows                      int           `cli:"pty-rows"`
+	PTYCols                      int           `cli:"pty-cols"`
+	JobLogFormat                 string        `cli:"job-log-format"`
+	PhaseTimings                 string        `cli:"phase-timings"`
+	DiagnosticsBundle            bool          `cli:"diagnostics-bundle"`
+	LogLevel                     string        `cli:"log-level"`
+	Debug                        bool          `cli:"debug"`
+	Shell                        string        `cli:"shell"`
+	Experiments                  []string      `cli:"experiment" normalize:"list"`
+	Phases                       []string      `cli:"phases" normalize:"list"`
+	Profile                      string        `cli:"profile"`
+	CancelSignal                 string        `cli:"cancel-signal"`
+	CancelGracePeriod            int           `cli:"cancel-grace-period"`
+	SignalGracePeriodSeconds     int           `cli:"signal-grace-period-seconds"`
+	HookTimeout                  time.Duration `cli:"hook-timeout"`
+	RedactedVars                 []string      `cli:"redacted-vars" normalize:"list"`
+	TracingBackend               string        `cli:"tracing-backend"`
+	TracingServiceName           string        `cli:"tracing-service-name"`
+	TracingHookOutputLimit       int           `cli:"tracing-hook-output-limit"`
+	TraceContextEncoding         string        `cli:"trace-context-encoding"`
+	NoJobAPI                     bool          `cli:"no-job-api"`
+	DisableWarningsFor           []string      `cli:"disable-warnings-for" normalize:"list"`
+	KubernetesExec               bool          `cli:"kubernetes-exec"`
+	KubernetesContainerID        int           `cli:"kubernetes-container-id"`
+	KubernetesLogPrefix          string        `cli:"kubernetes-log-prefix"`
 }
 
 var BootstrapCommand = cli.Command{
@@ -210,6 +249,17 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Whether or not the bootstrap should remove the existing repository before running the command",
 			EnvVar: "BUILDKITE_CLEAN_CHECKOUT",
 		},
+		cli.BoolFlag{
+			Name:   "skip-checkout",
+			Usage:  "Skip checking out the repository. The checkout directory is still created and used as the working directory",
+			EnvVar: "BUILDKITE_SKIP_CHECKOUT",
+		},
+		cli.StringFlag{
+			Name:   "vcs",
+			Value:  "",
+			Usage:  "The version control system to check out the repository with when there's no checkout hook: ′git′ (the default), ′hg′, ′p4′ or ′tar′",
+			EnvVar: "BUILDKITE_VCS",
+		},
 		cli.StringFlag{
 			Name:   "git-checkout-flags",
 			Value:  "-f",
@@ -222,6 +272,12 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Flags to pass to \"git clone\" command",
 			EnvVar: "BUILDKITE_GIT_CLONE_FLAGS",
 		},
+		cli.StringFlag{
+			Name:   "git-clone-bundle",
+			Value:  "",
+			Usage:  "A bundle URI (such as ′https://example.com/repo.bundle′), or the path to a bundle file, to seed new clones of the repository from, so that only newer objects are fetched from the repository itself. Bundle URIs need git 2.38 or later",
+			EnvVar: "BUILDKITE_GIT_CLONE_BUNDLE",
+		},
 		cli.StringFlag{
 			Name:   "git-clone-mirror-flags",
 			Value:  "-v",
@@ -246,6 +302,18 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Comma separated key=value git config pairs applied before git submodule clone commands. For example, ′update --init′. If the config is needed to be applied to all git commands, supply it in a global git config file for the system that the agent runs in instead.",
 			EnvVar: "BUILDKITE_GIT_SUBMODULE_CLONE_CONFIG",
 		},
+		cli.StringSliceFlag{
+			Name:   "git-submodule-clone-flags",
+			Value:  &cli.StringSlice{},
+			Usage:  "Comma separated ′<url prefix>=<flags>′ rules giving flags to pass to ′git submodule update′ for the submodules whose URLs start with the prefix, such as ′https://github.com/org/big-repo=--depth 1′. The first matching rule applies, and each submodule is updated separately when there are any rules",
+			EnvVar: "BUILDKITE_GIT_SUBMODULE_CLONE_FLAGS",
+		},
+		cli.StringSliceFlag{
+			Name:   "git-submodule-credentials",
+			Value:  &cli.StringSlice{},
+			Usage:  "Comma separated ′<url>=[<username>:]<env var>′ rules that have git use the token in the environment variable for HTTPS submodules at the URL (or on the host, if the URL has no path), such as ′https://gitlab.example.com=oauth2:GITLAB_TOKEN′. The username defaults to ′x-access-token′. Requires git 2.31 or later",
+			EnvVar: "BUILDKITE_GIT_SUBMODULE_CREDENTIALS",
+		},
 		cli.StringFlag{
 			Name:   "git-mirrors-path",
 			Value:  "",
@@ -258,11 +326,23 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Seconds to lock a git mirror during clone, should exceed your longest checkout",
 			EnvVar: "BUILDKITE_GIT_MIRRORS_LOCK_TIMEOUT",
 		},
+		CheckoutRetryAttemptsFlag,
+		CheckoutRetryBackoffFlag,
 		cli.BoolFlag{
 			Name:   "git-mirrors-skip-update",
 			Usage:  "Skip updating the Git mirror",
 			EnvVar: "BUILDKITE_GIT_MIRRORS_SKIP_UPDATE",
 		},
+		cli.BoolFlag{
+			Name:   "git-mirrors-worktree",
+			Usage:  "Check out the job as a git worktree of the mirror, instead of cloning with --reference",
+			EnvVar: "BUILDKITE_GIT_MIRRORS_WORKTREE",
+		},
+		cli.BoolFlag{
+			Name:   "ephemeral-build-dir",
+			Usage:  "Mount a tmpfs over the checkout directory, and discard it when the job finishes (Linux only)",
+			EnvVar: "BUILDKITE_EPHEMERAL_BUILD_DIR",
+		},
 		cli.StringFlag{
 			Name:   "bin-path",
 			Value:  "",
@@ -304,6 +384,55 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Allow running of arbitrary commands",
 			EnvVar: "BUILDKITE_COMMAND_EVAL",
 		},
+		cli.StringFlag{
+			Name:   "command-sandbox",
+			Value:  "",
+			Usage:  "Run the command phase in a sandbox. The only sandbox currently supported is ′bwrap′ (bubblewrap), on Linux",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-sandbox-allow-paths",
+			Value:  &cli.StringSlice{},
+			Usage:  "Paths that commands in the --command-sandbox can read and write, even if they would otherwise be hidden",
+			EnvVar: "BUILDKITE_COMMAND_SANDBOX_ALLOW_PATHS",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-env-allow",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names to pass to the command phase of each job. If set, variables that don't match are removed. Hooks still get every variable",
+			EnvVar: "BUILDKITE_COMMAND_ENV_ALLOW",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-env-deny",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names to remove from the command phase of each job, for example ′BUILDKITE_AGENT_ACCESS_TOKEN′. Hooks still get every variable. Commands that run buildkite-agent need the access token",
+			EnvVar: "BUILDKITE_COMMAND_ENV_DENY",
+		},
+		cli.IntFlag{
+			Name:   "command-retry",
+			Value:  0,
+			Usage:  "How many times to run the command phase again if the command fails, without retrying the whole job. Each attempt gets its own group in the job log, and the attempt number is in ′BUILDKITE_COMMAND_ATTEMPT′",
+			EnvVar: "BUILDKITE_COMMAND_RETRY_ATTEMPTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "command-retry-exit-status",
+			Value:  &cli.StringSlice{},
+			Usage:  "The exit statuses of the command that --command-retry retries, for example ′1,255′. By default, or with ′*′, any non-zero exit status is retried",
+			EnvVar: "BUILDKITE_COMMAND_RETRY_EXIT_STATUS",
+		},
+		SoftFailExitStatusFlag,
+		SoftFailClassifierFlag,
+		cli.StringSliceFlag{
+			Name:   "protected-env",
+			Value:  &cli.StringSlice{},
+			Usage:  "Patterns of environment variable names that can't be overridden by the pipeline, by hooks, or through the Job API. Pipeline values are ignored (apart from BUILDKITE_ variables, which Buildkite provides), hook changes are reverted, and Job API changes are refused",
+			EnvVar: "BUILDKITE_PROTECTED_ENV",
+		},
+		cli.BoolFlag{
+			Name:   "protected-env-enforce",
+			Usage:  "Fail the job, rather than warning, if the pipeline or a hook tries to override a protected environment variable",
+			EnvVar: "BUILDKITE_PROTECTED_ENV_ENFORCE",
+		},
 		cli.BoolTFlag{
 			Name:   "plugins-enabled",
 			Usage:  "Allow plugins to be run",
@@ -319,6 +448,29 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Always make a new clone of plugin source, even if already present",
 			EnvVar: "BUILDKITE_PLUGINS_ALWAYS_CLONE_FRESH",
 		},
+		cli.StringFlag{
+			Name:   "plugin-lock-file",
+			Value:  "",
+			Usage:  "Path to a file recording the commit each plugin version resolved to",
+			EnvVar: "BUILDKITE_PLUGIN_LOCK_FILE",
+		},
+		cli.BoolFlag{
+			Name:   "plugins-allow-drift",
+			Usage:  "Allow plugin versions to resolve to a different commit than the one recorded in the plugin lock file",
+			EnvVar: "BUILDKITE_PLUGINS_ALLOW_DRIFT",
+		},
+		cli.StringSliceFlag{
+			Name:   "allowed-plugins",
+			Value:  &cli.StringSlice{},
+			Usage:  "Regular expressions matching the plugins that can be used, each optionally followed by @<sha> to require matching plugins to resolve to that commit or checksum",
+			EnvVar: "BUILDKITE_ALLOWED_PLUGINS",
+		},
+		cli.StringSliceFlag{
+			Name:   "allowed-repositories",
+			Value:  &cli.StringSlice{},
+			Usage:  "Regular expressions matching the repositories that can be checked out",
+			EnvVar: "BUILDKITE_ALLOWED_REPOSITORIES",
+		},
 		cli.BoolTFlag{
 			Name:   "local-hooks-enabled",
 			Usage:  "Allow local hooks to be run",
@@ -334,11 +486,42 @@ var BootstrapCommand = cli.Command{
 			Usage:  "Enable git submodules",
 			EnvVar: "BUILDKITE_GIT_SUBMODULES",
 		},
+		cli.BoolFlag{
+			Name:   "git-lfs-skip",
+			Usage:  "Skip fetching and checking out Git LFS objects, even if the repository uses them",
+			EnvVar: "BUILDKITE_GIT_LFS_SKIP",
+		},
 		cli.BoolTFlag{
 			Name:   "pty",
 			Usage:  "Run jobs within a pseudo terminal",
 			EnvVar: "BUILDKITE_PTY",
 		},
+		cli.IntFlag{
+			Name:   "pty-rows",
+			Usage:  "The number of rows of the pseudo terminal that jobs run in. Jobs can resize it with the Job API (default: 100)",
+			EnvVar: "BUILDKITE_PTY_ROWS",
+		},
+		cli.IntFlag{
+			Name:   "pty-cols",
+			Usage:  "The number of columns of the pseudo terminal that jobs run in. Jobs can resize it with the Job API (default: 160)",
+			EnvVar: "BUILDKITE_PTY_COLS",
+		},
+		cli.StringFlag{
+			Name:   "job-log-format",
+			Usage:  "The format of job output: 'text', or 'json' for JSON lines",
+			EnvVar: "BUILDKITE_JOB_LOG_FORMAT",
+			Value:  "text",
+		},
+		cli.StringFlag{
+			Name:   "phase-timings",
+			Usage:  "Record how long each phase of the job took once it finishes: ′meta-data′ to record them in build meta-data, or ′annotation′ to also show them in a build annotation",
+			EnvVar: "BUILDKITE_PHASE_TIMINGS",
+		},
+		cli.BoolFlag{
+			Name:   "diagnostics-bundle",
+			Usage:  "Upload a bundle of diagnostics as artifacts if the job is cancelled, times out, or has a hook time out",
+			EnvVar: "BUILDKITE_DIAGNOSTICS_BUNDLE",
+		},
 		cli.StringFlag{
 			Name:   "shell",
 			Usage:  "The shell to use to interpret build commands",
@@ -362,6 +545,12 @@ var BootstrapCommand = cli.Command{
 			EnvVar: "BUILDKITE_TRACING_SERVICE_NAME",
 			Value:  "buildkite-agent",
 		},
+		cli.IntFlag{
+			Name:   "tracing-hook-output-limit",
+			Usage:  "The maximum number of bytes of each hook's stdout and stderr to record on the hook's trace span.",
+			EnvVar: "BUILDKITE_TRACING_HOOK_OUTPUT_LIMIT",
+			Value:  0,
+		},
 		cli.BoolFlag{
 			Name:   "no-job-api",
 			Usage:  "Disables the Job API, which gives commands in jobs some abilities to introspect and mutate the state of the job.",
@@ -379,9 +568,18 @@ var BootstrapCommand = cli.Command{
 				"used to identify this container within the pod",
 			EnvVar: "BUILDKITE_CONTAINER_ID",
 		},
+		cli.StringFlag{
+			Name: "kubernetes-log-prefix",
+			Usage: "This is intended to be used only by the Buildkite k8s stack " +
+				"(github.com/buildkite/agent-stack-k8s); it sets a prefix for each " +
+				"line of this container's output in the job log, such as the " +
+				"container name, to tell apart containers that run in parallel",
+			EnvVar: "BUILDKITE_KUBERNETES_LOG_PREFIX",
+		},
 		cancelSignalFlag,
 		cancelGracePeriodFlag,
 		signalGracePeriodSecondsFlag,
+		hookTimeoutFlag,
 
 		// Global flags
 		DebugFlag,
@@ -390,6 +588,7 @@ var BootstrapCommand = cli.Command{
 		ProfileFlag,
 		RedactedVars,
 		StrictSingleHooksFlag,
+		HookInterpretersFlag,
 		KubernetesExecFlag,
 		TraceContextEncodingFlag,
 	},
@@ -403,6 +602,22 @@ var BootstrapCommand = cli.Command{
 		if runtime.GOOS == "windows" {
 			runInPty = false
 		}
+		ptyRows, ptyCols, err := parsePTYSize(cfg.PTYRows, cfg.PTYCols)
+		if err != nil {
+			return err
+		}
+
+		if cfg.CommandRetry < 0 {
+			return fmt.Errorf("invalid --command-retry %d, must not be negative", cfg.CommandRetry)
+		}
+		commandRetryExitStatuses, err := parseExitStatuses(cfg.CommandRetryExitStatus)
+		if err != nil {
+			return fmt.Errorf("invalid --command-retry-exit-status: %w", err)
+		}
+		softFailExitStatuses, err := parseSoftFailExitStatuses(cfg.SoftFailExitStatus)
+		if err != nil {
+			return err
+		}
 
 		// Validate phases
 		for _, phase := range cfg.Phases {
@@ -414,6 +629,25 @@ var BootstrapCommand = cli.Command{
 			}
 		}
 
+		switch cfg.JobLogFormat {
+		case "", "text", "json":
+			// Valid job log format
+		default:
+			return fmt.Errorf("invalid job log format %q", cfg.JobLogFormat)
+		}
+
+		if err := job.ValidatePhaseTimings(cfg.PhaseTimings); err != nil {
+			return err
+		}
+
+		if err := job.ValidateVCS(cfg.VCS); err != nil {
+			return err
+		}
+
+		if err := job.ValidateGitSubmoduleConfig(cfg.GitSubmoduleCloneFlags, cfg.GitSubmoduleCredentials); err != nil {
+			return err
+		}
+
 		cancelSig, err := process.ParseSignal(cfg.CancelSignal)
 		if err != nil {
 			return fmt.Errorf("failed to parse cancel-signal: %w", err)
@@ -424,6 +658,11 @@ var BootstrapCommand = cli.Command{
 			return err
 		}
 
+		hookInterpreters, err := hook.ParseInterpreters(cfg.HookInterpreters)
+		if err != nil {
+			return err
+		}
+
 		traceContextCodec, err := tracetools.ParseEncoding(cfg.TraceContextEncoding)
 		if err != nil {
 			return fmt.Errorf("while parsing trace context encoding: %v", err)
@@ -440,21 +679,42 @@ var BootstrapCommand = cli.Command{
 			SocketsPath:                  cfg.SocketsPath,
 			CancelSignal:                 cancelSig,
 			SignalGracePeriod:            signalGracePeriod,
+			HookTimeout:                  cfg.HookTimeout,
 			CleanCheckout:                cfg.CleanCheckout,
+			SkipCheckout:                 cfg.SkipCheckout,
 			Command:                      cfg.Command,
 			CommandEval:                  cfg.CommandEval,
+			CommandSandbox:               cfg.CommandSandbox,
+			CommandSandboxAllowPaths:     cfg.CommandSandboxAllowPaths,
+			CommandEnvAllow:              cfg.CommandEnvAllow,
+			CommandEnvDeny:               cfg.CommandEnvDeny,
+			CommandRetryAttempts:         cfg.CommandRetry,
+			CommandRetryExitStatuses:     commandRetryExitStatuses,
+			SoftFailExitStatuses:         softFailExitStatuses,
+			SoftFailClassifier:           cfg.SoftFailClassifier,
+			ProtectedEnv:                 cfg.ProtectedEnv,
+			ProtectedEnvEnforce:          cfg.ProtectedEnvEnforce,
 			Commit:                       cfg.Commit,
 			Debug:                        cfg.Debug,
+			VCS:                          cfg.VCS,
 			GitCheckoutFlags:             cfg.GitCheckoutFlags,
 			GitCleanFlags:                cfg.GitCleanFlags,
 			GitCloneFlags:                cfg.GitCloneFlags,
+			GitCloneBundle:               cfg.GitCloneBundle,
 			GitCloneMirrorFlags:          cfg.GitCloneMirrorFlags,
 			GitFetchFlags:                cfg.GitFetchFlags,
 			GitMirrorsLockTimeout:        cfg.GitMirrorsLockTimeout,
+			CheckoutRetryAttempts:        cfg.CheckoutRetryAttempts,
+			CheckoutRetryBackoff:         cfg.CheckoutRetryBackoff,
 			GitMirrorsPath:               cfg.GitMirrorsPath,
 			GitMirrorsSkipUpdate:         cfg.GitMirrorsSkipUpdate,
+			GitMirrorsWorktree:           cfg.GitMirrorsWorktree,
+			EphemeralBuildDir:            cfg.EphemeralBuildDir,
 			GitSubmodules:                cfg.GitSubmodules,
+			GitLFSSkip:                   cfg.GitLFSSkip,
 			GitSubmoduleCloneConfig:      cfg.GitSubmoduleCloneConfig,
+			GitSubmoduleCloneFlags:       cfg.GitSubmoduleCloneFlags,
+			GitSubmoduleCredentials:      cfg.GitSubmoduleCredentials,
 			HooksPath:                    cfg.HooksPath,
 			AdditionalHooksPaths:         cfg.AdditionalHooksPaths,
 			JobID:                        cfg.JobID,
@@ -467,6 +727,10 @@ var BootstrapCommand = cli.Command{
 			Plugins:                      cfg.Plugins,
 			PluginsEnabled:               cfg.PluginsEnabled,
 			PluginsAlwaysCloneFresh:      cfg.PluginsAlwaysCloneFresh,
+			PluginLockFile:               cfg.PluginLockFile,
+			PluginsAllowDrift:            cfg.PluginsAllowDrift,
+			AllowedPlugins:               cfg.AllowedPlugins,
+			AllowedRepositories:          cfg.AllowedRepositories,
 			PluginsPath:                  cfg.PluginsPath,
 			PullRequest:                  cfg.PullRequest,
 			Queue:                        cfg.Queue,
@@ -474,17 +738,25 @@ var BootstrapCommand = cli.Command{
 			RefSpec:                      cfg.RefSpec,
 			Repository:                   cfg.Repository,
 			RunInPty:                     runInPty,
+			PTYRows:                      ptyRows,
+			PTYCols:                      ptyCols,
+			JobLogFormat:                 cfg.JobLogFormat,
+			PhaseTimings:                 cfg.PhaseTimings,
+			DiagnosticsBundle:            cfg.DiagnosticsBundle,
 			SSHKeyscan:                   cfg.SSHKeyscan,
 			Shell:                        cfg.Shell,
 			StrictSingleHooks:            cfg.StrictSingleHooks,
+			HookInterpreters:             hookInterpreters,
 			Tag:                          cfg.Tag,
 			TracingBackend:               cfg.TracingBackend,
 			TracingServiceName:           cfg.TracingServiceName,
+			TracingHookOutputLimit:       cfg.TracingHookOutputLimit,
 			TraceContextCodec:            traceContextCodec,
 			JobAPI:                       !cfg.NoJobAPI,
 			DisabledWarnings:             cfg.DisableWarningsFor,
 			KubernetesExec:               cfg.KubernetesExec,
 			KubernetesContainerID:        cfg.KubernetesContainerID,
+			KubernetesLogPrefix:          cfg.KubernetesLogPrefix,
 		})
 
 		cctx, cancel := context.WithCancel(ctx)
@@ -547,3 +819,48 @@ var BootstrapCommand = cli.Command{
 		return &SilentExitError{code: exitCode}
 	},
 }
+
+// parsePTYSize checks the --pty-rows and --pty-cols flags. Zero means the
+// default size.
+func parsePTYSize(rows, cols int) (uint16, uint16, error) {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{{"pty-rows", rows}, {"pty-cols", cols}} {
+		if f.value < 0 || f.value > math.MaxUint16 {
+			return 0, 0, fmt.Errorf("invalid --%s %d, must be between 1 and %d", f.name, f.value, math.MaxUint16)
+		}
+	}
+	return uint16(rows), uint16(cols), nil
+}
+
+// parseExitStatuses parses a list of exit statuses. "*" means any non-zero
+// exit status, which is returned as an empty list.
+func parseExitStatuses(list []string) ([]int, error) {
+	var statuses []int
+	for _, s := range list {
+		if s == "*" {
+			return nil, nil
+		}
+		status, err := strconv.Atoi(s)
+		if err != nil || status < 1 || status > 255 {
+			return nil, fmt.Errorf("%q is not an exit status between 1 and 255", s)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// parseSoftFailExitStatuses checks the --soft-fail-exit-status flag. Unlike
+// other lists of exit statuses, "*" isn't allowed, as it would soft fail every
+// job that fails.
+func parseSoftFailExitStatuses(list []string) ([]int, error) {
+	if slices.Contains(list, "*") {
+		return nil, errors.New("invalid --soft-fail-exit-status: \"*\" isn't allowed, list the exit statuses to soft fail")
+	}
+	statuses, err := parseExitStatuses(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --soft-fail-exit-status: %w", err)
+	}
+	return statuses, nil
+}
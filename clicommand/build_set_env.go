@@ -0,0 +1,158 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/go-pipeline"
+	"github.com/buildkite/go-pipeline/ordered"
+	"github.com/urfave/cli"
+)
+
+// buildEnvMetaDataKeyPrefix is prefixed to the name of each variable set with
+// build set-env to make its build meta-data key.
+const buildEnvMetaDataKeyPrefix = "buildkite:env:"
+
+const buildSetEnvDescription = `Usage:
+
+    buildkite-agent build set-env <name>=<value>... [options...]
+
+Description:
+
+Set environment variables for the steps uploaded later in the build.
+
+The variables are kept in build meta-data under ′buildkite:env:<name>′, and
+′buildkite-agent pipeline upload′ adds them to the env of each pipeline it
+uploads afterwards, unless the pipeline sets them itself. Steps that have
+already been uploaded aren't changed. Like other meta-data, values can't be
+empty.
+
+Example:
+
+    $ buildkite-agent build set-env "SHARD_COUNT=8"
+    $ buildkite-agent build set-env "RELEASE=true" "RELEASE_VERSION=1.2.3"`
+
+type BuildSetEnvConfig struct {
+	Job string `cli:"job" validate:"required"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+
+	// API config
+	DebugHTTP        bool   `cli:"debug-http"`
+	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
+	Endpoint         string `cli:"endpoint" validate:"required"`
+	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
+}
+
+var BuildSetEnvCommand = cli.Command{
+	Name:        "set-env",
+	Usage:       "Set environment variables for steps uploaded later in the build",
+	Description: buildSetEnvDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "job",
+			Value:  "",
+			Usage:  "Which job's build should the variables be set on",
+			EnvVar: "BUILDKITE_JOB_ID",
+		},
+
+		// API Flags
+		AgentAccessTokenFlag,
+		EndpointFlag,
+		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
+		DebugHTTPFlag,
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) error {
+		ctx := context.Background()
+		ctx, cfg, l, _, done := setupLoggerAndConfig[BuildSetEnvConfig](ctx, c)
+		defer done()
+
+		metaData, err := parseBuildEnv(c.Args())
+		if err != nil {
+			return err
+		}
+
+		return setBuildEnv(ctx, cfg, l, metaData)
+	},
+}
+
+// parseBuildEnv turns name=value arguments into meta-data.
+func parseBuildEnv(args []string) ([]*api.MetaData, error) {
+	if len(args) == 0 {
+		return nil, errors.New("at least one name=value pair is required")
+	}
+
+	metaData := make([]*api.MetaData, 0, len(args))
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid argument %q, must be in the form name=value", arg)
+		}
+		m := &api.MetaData{Key: buildEnvMetaDataKeyPrefix + name, Value: value}
+		if err := validateMetaData(m); err != nil {
+			return nil, err
+		}
+		metaData = append(metaData, m)
+	}
+	return metaData, nil
+}
+
+func setBuildEnv(ctx context.Context, cfg BuildSetEnvConfig, l logger.Logger, metaData []*api.MetaData) error {
+	// Create the API client
+	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+
+	if err := forEachMetaData(len(metaData), func(i int) error {
+		if err := setMetaData(ctx, l, client, cfg.Job, metaData[i]); err != nil {
+			return fmt.Errorf("%q: %w", strings.TrimPrefix(metaData[i].Key, buildEnvMetaDataKeyPrefix), err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to set build env: %w", err)
+	}
+
+	return nil
+}
+
+// addBuildEnv adds the variables set with build set-env, found in the build's
+// meta-data, to the env of the pipeline. Variables the pipeline sets itself
+// are left alone. It returns the names of the variables it added.
+func addBuildEnv(p *pipeline.Pipeline, metaData []*api.MetaData) []string {
+	var added []string
+	for _, m := range metaData {
+		name, ok := strings.CutPrefix(m.Key, buildEnvMetaDataKeyPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if p.Env == nil {
+			p.Env = ordered.NewMap[string, string](len(metaData))
+		}
+		if p.Env.Contains(name) {
+			continue
+		}
+		p.Env.Set(name, m.Value)
+		added = append(added, name)
+	}
+	return added
+}
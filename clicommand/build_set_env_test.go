@@ -0,0 +1,109 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/go-pipeline"
+	"github.com/buildkite/go-pipeline/ordered"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseBuildEnv(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseBuildEnv([]string{"SHARD_COUNT=8", "QUERY=a=b"})
+	if err != nil {
+		t.Fatalf("parseBuildEnv() error = %v", err)
+	}
+	want := []*api.MetaData{
+		{Key: "buildkite:env:SHARD_COUNT", Value: "8"},
+		{Key: "buildkite:env:QUERY", Value: "a=b"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("parseBuildEnv() diff (-got +want):\n%s", diff)
+	}
+
+	for _, args := range [][]string{nil, {"NO_VALUE"}, {"=value"}, {"EMPTY="}} {
+		if _, err := parseBuildEnv(args); err == nil {
+			t.Errorf("parseBuildEnv(%q) error = nil, want an error", args)
+		}
+	}
+}
+
+func TestSetBuildEnv(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got []*api.MetaData
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/jobs/job-id/data/set" {
+			t.Errorf("request path = %q, want /jobs/job-id/data/set", req.URL.Path)
+		}
+		m := new(api.MetaData)
+		if err := json.NewDecoder(req.Body).Decode(m); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		mu.Lock()
+		got = append(got, m)
+		mu.Unlock()
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := BuildSetEnvConfig{
+		Job:              "job-id",
+		AgentAccessToken: "agentaccesstoken",
+		Endpoint:         server.URL,
+	}
+	metaData := []*api.MetaData{
+		{Key: "buildkite:env:A", Value: "1"},
+		{Key: "buildkite:env:B", Value: "2"},
+	}
+	if err := setBuildEnv(context.Background(), cfg, logger.NewBuffer(), metaData); err != nil {
+		t.Fatalf("setBuildEnv() error = %v", err)
+	}
+	// The keys are set concurrently.
+	slices.SortFunc(got, func(a, b *api.MetaData) int { return strings.Compare(a.Key, b.Key) })
+	if diff := cmp.Diff(got, metaData); diff != "" {
+		t.Errorf("meta-data set diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestAddBuildEnv(t *testing.T) {
+	t.Parallel()
+
+	p := &pipeline.Pipeline{
+		Env: ordered.MapFromItems(ordered.TupleSS{Key: "SHARD_COUNT", Value: "4"}),
+	}
+	metaData := []*api.MetaData{
+		{Key: "buildkite:env:SHARD_COUNT", Value: "8"},
+		{Key: "buildkite:env:RELEASE", Value: "true"},
+		{Key: "release-notes", Value: "not env"},
+	}
+
+	added := addBuildEnv(p, metaData)
+	if diff := cmp.Diff(added, []string{"RELEASE"}); diff != "" {
+		t.Errorf("addBuildEnv() diff (-got +want):\n%s", diff)
+	}
+	want := map[string]string{"SHARD_COUNT": "4", "RELEASE": "true"}
+	if diff := cmp.Diff(p.Env.ToMap(), want); diff != "" {
+		t.Errorf("pipeline env diff (-got +want):\n%s", diff)
+	}
+
+	empty := &pipeline.Pipeline{}
+	addBuildEnv(empty, metaData)
+	if got, _ := empty.Env.Get("RELEASE"); got != "true" {
+		t.Errorf("pipeline without env: RELEASE = %q, want %q", got, "true")
+	}
+}
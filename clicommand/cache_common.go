@@ -0,0 +1,43 @@
+package clicommand
+
+import (
+	"fmt"
+
+	"github.com/buildkite/agent/v3/internal/cache"
+	"github.com/urfave/cli"
+)
+
+// Flags used by all cache subcommands.
+var cacheCommonFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "backend",
+		Value:  "",
+		Usage:  "Where caches are kept: an s3://bucket/prefix, a gs://bucket/prefix, or a directory",
+		EnvVar: "BUILDKITE_CACHE_BACKEND",
+	},
+	cli.StringFlag{
+		Name:   "key",
+		Value:  "",
+		Usage:  "The cache key, which may use the checksum and env template functions",
+		EnvVar: "BUILDKITE_CACHE_KEY",
+	},
+	cli.StringSliceFlag{
+		Name:   "fallback-key",
+		Value:  &cli.StringSlice{},
+		Usage:  "Keys to use if there is no cache for --key, such as one for the branch, in order of preference",
+		EnvVar: "BUILDKITE_CACHE_FALLBACK_KEYS",
+	},
+}
+
+// expandCacheKeys expands the primary and fallback cache key templates.
+func expandCacheKeys(key string, fallbackKeys []string) ([]string, error) {
+	keys := make([]string, 0, 1+len(fallbackKeys))
+	for _, tmpl := range append([]string{key}, fallbackKeys...) {
+		k, err := cache.ExpandKey(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -0,0 +1,90 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/agent/v3/internal/cache"
+	"github.com/urfave/cli"
+)
+
+const cacheRestoreHelpDescription = `Usage:
+
+    buildkite-agent cache restore [options...]
+
+Description:
+
+Restores files and directories saved with ′buildkite-agent cache save′, from
+the cache for the key, or if there is none, from the cache for the first of the
+fallback keys that has one. Keys are templates, as for ′cache save′.
+
+Only the paths given are restored, and only if they were saved to the cache
+with the same --path. Restored paths replace any existing files at those paths. Each path is only
+replaced once the whole cache has been downloaded and extracted, so a failed
+restore leaves the existing files as they were.
+
+A cache miss isn't an error. The key that was restored, if any, is printed, so
+that scripts can tell whether the cache was an exact match.
+
+Example:
+
+    $ buildkite-agent cache restore \
+        --backend s3://my-cache-bucket/my-pipeline \
+        --key 'node-{{ checksum "package-lock.json" }}' \
+        --fallback-key 'node-{{ env "BUILDKITE_BRANCH" }}' \
+        --fallback-key 'node-main' \
+        --path node_modules`
+
+type CacheRestoreConfig struct {
+	Backend      string   `cli:"backend" validate:"required"`
+	Key          string   `cli:"key" validate:"required"`
+	FallbackKeys []string `cli:"fallback-key" normalize:"list"`
+	Paths        []string `cli:"path" normalize:"list" validate:"required"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var CacheRestoreCommand = cli.Command{
+	Name:        "restore",
+	Usage:       "Restores files and directories from the cache",
+	Description: cacheRestoreHelpDescription,
+	Flags: append(append(globalFlags(), cacheCommonFlags...),
+		cli.StringSliceFlag{
+			Name:   "path",
+			Value:  &cli.StringSlice{},
+			Usage:  "A file or directory to restore from the cache, as it was given to ′cache save′. May be given more than once",
+			EnvVar: "BUILDKITE_CACHE_PATHS",
+		},
+	),
+	Action: func(c *cli.Context) error {
+		ctx, cfg, l, _, done := setupLoggerAndConfig[CacheRestoreConfig](context.Background(), c)
+		defer done()
+
+		keys, err := expandCacheKeys(cfg.Key, cfg.FallbackKeys)
+		if err != nil {
+			return err
+		}
+
+		store, err := cache.NewStore(ctx, l, cfg.Backend)
+		if err != nil {
+			return fmt.Errorf("couldn't set up cache backend: %w", err)
+		}
+
+		key, err := cache.New(l, store).Restore(ctx, keys, cfg.Paths)
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			l.Info("No cache found for %q", keys)
+			return nil
+		}
+
+		_, err = fmt.Fprintln(c.App.Writer, key)
+		return err
+	},
+}
@@ -0,0 +1,91 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/cache"
+	"github.com/urfave/cli"
+)
+
+const cacheSaveHelpDescription = `Usage:
+
+    buildkite-agent cache save [options...]
+
+Description:
+
+Saves files and directories to the cache, so that later jobs can restore them
+with ′buildkite-agent cache restore′. This is usually used for dependencies, or
+the output of slow build steps.
+
+The cache key is a template. The ′checksum′ function returns the checksum of
+the files matching one or more glob patterns, such as lockfiles, and the ′env′
+function returns the value of an environment variable. If there is already a
+cache for the key, nothing is saved.
+
+Fallback keys are updated to refer to the saved cache, so that they can be used
+to restore a recent cache when there is none for the key, such as the latest
+cache on a branch.
+
+Identical caches are only stored once, however many keys refer to them. Caches
+with a --ttl expire, and are not restored after that long.
+
+Example:
+
+    $ buildkite-agent cache save \
+        --backend s3://my-cache-bucket/my-pipeline \
+        --key 'node-{{ checksum "package-lock.json" }}' \
+        --fallback-key 'node-{{ env "BUILDKITE_BRANCH" }}' \
+        --path node_modules \
+        --ttl 168h`
+
+type CacheSaveConfig struct {
+	Backend      string        `cli:"backend" validate:"required"`
+	Key          string        `cli:"key" validate:"required"`
+	FallbackKeys []string      `cli:"fallback-key" normalize:"list"`
+	Paths        []string      `cli:"path" normalize:"list" validate:"required"`
+	TTL          time.Duration `cli:"ttl"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var CacheSaveCommand = cli.Command{
+	Name:        "save",
+	Usage:       "Saves files and directories to the cache",
+	Description: cacheSaveHelpDescription,
+	Flags: append(append(globalFlags(), cacheCommonFlags...),
+		cli.StringSliceFlag{
+			Name:   "path",
+			Value:  &cli.StringSlice{},
+			Usage:  "A file or directory to save to the cache. May be given more than once",
+			EnvVar: "BUILDKITE_CACHE_PATHS",
+		},
+		cli.DurationFlag{
+			Name:   "ttl",
+			Usage:  "How long the cache is kept for, or 0 to keep it until the backend removes it",
+			EnvVar: "BUILDKITE_CACHE_TTL",
+		},
+	),
+	Action: func(c *cli.Context) error {
+		ctx, cfg, l, _, done := setupLoggerAndConfig[CacheSaveConfig](context.Background(), c)
+		defer done()
+
+		keys, err := expandCacheKeys(cfg.Key, cfg.FallbackKeys)
+		if err != nil {
+			return err
+		}
+
+		store, err := cache.NewStore(ctx, l, cfg.Backend)
+		if err != nil {
+			return fmt.Errorf("couldn't set up cache backend: %w", err)
+		}
+
+		return cache.New(l, store).Save(ctx, keys, cfg.Paths, cfg.TTL)
+	},
+}
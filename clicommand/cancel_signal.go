@@ -30,10 +30,21 @@ var (
 		Usage: "The number of seconds given to a subprocess to handle being sent ′cancel-signal′. " +
 			"After this period has elapsed, SIGKILL will be sent. " +
 			"Negative values are taken relative to ′cancel-grace-period′. " +
-			"The default is ′cancel-grace-period′ - 1.",
+			"The default is ′cancel-grace-period′ - 1. " +
+			"Jobs can shorten it, but not lengthen it, by setting BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS, " +
+			"leaving pre-exit hooks longer to clean up.",
 		EnvVar: "BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS",
 		Value:  -1,
 	}
+	hookTimeoutFlag = cli.DurationFlag{
+		Name: "hook-timeout",
+		Usage: "The maximum time each hook (other than command hooks) may run for, such as ′10m′. " +
+			"Hooks that run for longer are sent ′cancel-signal′, then SIGKILL after ′signal-grace-period-seconds′. " +
+			"Individual hooks can be given a shorter timeout with an environment variable named for the hook, " +
+			"such as ′BUILDKITE_HOOK_TIMEOUT_PRE_COMMAND=5m′. The default is no timeout, " +
+			"except for on-cancel hooks, which run before a cancelled job's command is interrupted, and default to 5s between them.",
+		EnvVar: "BUILDKITE_HOOK_TIMEOUT",
+	}
 )
 
 // signalGracePeriod computes the signal grace period based on the various
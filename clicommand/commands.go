@@ -25,6 +25,7 @@ var BuildkiteAgentCommands = []cli.Command{
 			ArtifactDownloadCommand,
 			ArtifactSearchCommand,
 			ArtifactShasumCommand,
+			ArtifactSyncCommand,
 		},
 	},
 	{
@@ -32,14 +33,26 @@ var BuildkiteAgentCommands = []cli.Command{
 		Usage: "Interact with a Buildkite build",
 		Subcommands: []cli.Command{
 			BuildCancelCommand,
+			BuildSetEnvCommand,
+		},
+	},
+	{
+		Name:  "cache",
+		Usage: "Save and restore files and directories between jobs",
+		Subcommands: []cli.Command{
+			CacheSaveCommand,
+			CacheRestoreCommand,
 		},
 	},
 	{
 		Name:  "env",
 		Usage: "Process environment subcommands",
 		Subcommands: []cli.Command{
+			EnvDiffCommand,
 			EnvDumpCommand,
+			EnvExportCommand,
 			EnvGetCommand,
+			EnvLintCommand,
 			EnvSetCommand,
 			EnvUnsetCommand,
 		},
@@ -54,6 +67,7 @@ var BuildkiteAgentCommands = []cli.Command{
 			LockDoneCommand,
 			LockGetCommand,
 			LockReleaseCommand,
+			LockStatusCommand,
 		},
 	},
 	{
@@ -80,13 +94,16 @@ var BuildkiteAgentCommands = []cli.Command{
 			OIDCRequestTokenCommand,
 		},
 	},
+	PauseCommand,
 	{
 		Name:  "pipeline",
 		Usage: "Make changes to the pipeline of the currently running build",
 		Subcommands: []cli.Command{
 			PipelineUploadCommand,
+			PipelineRenderCommand,
 		},
 	},
+	ResumeCommand,
 	{
 		Name:  "secret",
 		Usage: "Interact with Pipelines Secrets",
@@ -103,6 +120,13 @@ var BuildkiteAgentCommands = []cli.Command{
 			StepCancelCommand,
 		},
 	},
+	{
+		Name:  "test-collector",
+		Usage: "Upload test results to Buildkite Test Analytics",
+		Subcommands: []cli.Command{
+			TestCollectorUploadCommand,
+		},
+	},
 	{
 		Name:  "tool",
 		Usage: "Utility commands, intended for users and operators of the agent to run directly on their machines, and not as part of a Buildkite job",
@@ -21,11 +21,18 @@ var commandConfigPairs = []configCommandPair{
 	{Config: ArtifactDownloadConfig{}, Command: ArtifactDownloadCommand},
 	{Config: ArtifactSearchConfig{}, Command: ArtifactSearchCommand},
 	{Config: ArtifactShasumConfig{}, Command: ArtifactShasumCommand},
+	{Config: ArtifactSyncConfig{}, Command: ArtifactSyncCommand},
 	{Config: ArtifactUploadConfig{}, Command: ArtifactUploadCommand},
 	{Config: BuildCancelConfig{}, Command: BuildCancelCommand},
+	{Config: BuildSetEnvConfig{}, Command: BuildSetEnvCommand},
 	{Config: BootstrapConfig{}, Command: BootstrapCommand},
+	{Config: CacheRestoreConfig{}, Command: CacheRestoreCommand},
+	{Config: CacheSaveConfig{}, Command: CacheSaveCommand},
+	{Config: EnvDiffConfig{}, Command: EnvDiffCommand},
 	{Config: EnvDumpConfig{}, Command: EnvDumpCommand},
+	{Config: EnvExportConfig{}, Command: EnvExportCommand},
 	{Config: EnvGetConfig{}, Command: EnvGetCommand},
+	{Config: EnvLintConfig{}, Command: EnvLintCommand},
 	{Config: EnvSetConfig{}, Command: EnvSetCommand},
 	{Config: EnvUnsetConfig{}, Command: EnvUnsetCommand},
 	{Config: GitCredentialsHelperConfig{}, Command: GitCredentialsHelperCommand},
@@ -34,17 +41,22 @@ var commandConfigPairs = []configCommandPair{
 	{Config: LockDoneConfig{}, Command: LockDoneCommand},
 	{Config: LockGetConfig{}, Command: LockGetCommand},
 	{Config: LockReleaseConfig{}, Command: LockReleaseCommand},
+	{Config: LockStatusConfig{}, Command: LockStatusCommand},
 	{Config: MetaDataExistsConfig{}, Command: MetaDataExistsCommand},
 	{Config: MetaDataGetConfig{}, Command: MetaDataGetCommand},
 	{Config: MetaDataKeysConfig{}, Command: MetaDataKeysCommand},
 	{Config: MetaDataSetConfig{}, Command: MetaDataSetCommand},
 	{Config: OIDCTokenConfig{}, Command: OIDCRequestTokenCommand},
+	{Config: PauseConfig{}, Command: PauseCommand},
+	{Config: PipelineRenderConfig{}, Command: PipelineRenderCommand},
 	{Config: PipelineUploadConfig{}, Command: PipelineUploadCommand},
 	{Config: RedactorAddConfig{}, Command: RedactorAddCommand},
+	{Config: ResumeConfig{}, Command: ResumeCommand},
 	{Config: SecretGetConfig{}, Command: SecretGetCommand},
 	{Config: StepCancelConfig{}, Command: StepCancelCommand},
 	{Config: StepGetConfig{}, Command: StepGetCommand},
 	{Config: StepUpdateConfig{}, Command: StepUpdateCommand},
+	{Config: TestCollectorUploadConfig{}, Command: TestCollectorUploadCommand},
 	{Config: ToolKeygenConfig{}, Command: ToolKeygenCommand},
 	{Config: ToolSignConfig{}, Command: ToolSignCommand},
 }
@@ -0,0 +1,184 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/shellscript"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/urfave/cli"
+	"golang.org/x/exp/maps"
+)
+
+const envDiffHelpDescription = `Usage:
+
+    buildkite-agent env diff [options] <before> <after>
+
+Description:
+
+Compares two files written by ′buildkite-agent env dump′, and prints the
+variables that were added, changed, or removed between them.
+
+With ′--format bash′, ′fish′, or ′powershell′, it instead prints statements
+that make the same changes in that shell, quoted so that the shell can ′eval′
+them safely. Variables whose names the shell can't express are skipped with a
+warning.
+
+Examples:
+
+    $ buildkite-agent env dump > before.json
+    $ source ./setup.sh
+    $ buildkite-agent env dump > after.json
+    $ buildkite-agent env diff before.json after.json
+    Added:
+      DEPLOY_ENV="production"
+    Changed:
+      PATH: "/usr/bin" → "/opt/tool/bin:/usr/bin"
+
+    $ buildkite-agent env diff --format fish before.json after.json | source`
+
+type EnvDiffConfig struct {
+	Before string `cli:"arg:0" label:"before" validate:"required"`
+	After  string `cli:"arg:1" label:"after" validate:"required"`
+	Format string `cli:"format"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var EnvDiffCommand = cli.Command{
+	Name:        "diff",
+	Usage:       "Compare two environment dumps",
+	Description: envDiffHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "format",
+			Usage:  "Output format: text, json, json-pretty, or a shell to write statements for: bash, fish, or powershell",
+			EnvVar: "BUILDKITE_AGENT_ENV_DIFF_FORMAT",
+			Value:  "text",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) error {
+		_, cfg, l, _, done := setupLoggerAndConfig[EnvDiffConfig](context.Background(), c)
+		defer done()
+
+		before, err := readEnvDump(cfg.Before)
+		if err != nil {
+			return err
+		}
+		after, err := readEnvDump(cfg.After)
+		if err != nil {
+			return err
+		}
+
+		return printEnvDiff(c.App.Writer, l, cfg.Format, after.Diff(before))
+	},
+}
+
+// envDiffReport is the JSON form of the differences between two environments.
+type envDiffReport struct {
+	Added   map[string]string        `json:"added"`
+	Changed map[string]envDiffChange `json:"changed"`
+	Removed []string                 `json:"removed"`
+}
+
+type envDiffChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// readEnvDump reads a JSON object of variables, as written by env dump.
+func readEnvDump(path string) (*env.Environment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading environment dump: %w", err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing environment dump %s, it must be a JSON object of string values: %w", path, err)
+	}
+	return env.FromMap(m), nil
+}
+
+func printEnvDiff(w io.Writer, l logger.Logger, format string, diff env.Diff) error {
+	removed := maps.Keys(diff.Removed)
+	slices.Sort(removed)
+
+	switch format {
+	case "text":
+		// Handled below
+
+	case "json", "json-pretty":
+		enc := json.NewEncoder(w)
+		if format == "json-pretty" {
+			enc.SetIndent("", "  ")
+		}
+		report := envDiffReport{
+			Added:   diff.Added,
+			Changed: make(map[string]envDiffChange, len(diff.Changed)),
+			Removed: removed,
+		}
+		for name, pair := range diff.Changed {
+			report.Changed[name] = envDiffChange{Old: pair.Old, New: pair.New}
+		}
+		return enc.Encode(report)
+
+	default:
+		dialect, err := shellscript.ParseDialect(format)
+		if err != nil {
+			return fmt.Errorf("invalid format %q, must be text, json, json-pretty, or one of %q", format, shellscript.Dialects)
+		}
+		set := make(map[string]string, len(diff.Added)+len(diff.Changed))
+		for name, v := range diff.Added {
+			set[name] = v
+		}
+		for name, pair := range diff.Changed {
+			set[name] = pair.New
+		}
+		return writeEnvScript(w, l, dialect, set, removed)
+	}
+
+	var b strings.Builder
+	if len(diff.Added) > 0 {
+		b.WriteString("Added:\n")
+		added := maps.Keys(diff.Added)
+		slices.Sort(added)
+		for _, name := range added {
+			fmt.Fprintf(&b, "  %s=%q\n", name, diff.Added[name])
+		}
+	}
+	if len(diff.Changed) > 0 {
+		b.WriteString("Changed:\n")
+		changed := maps.Keys(diff.Changed)
+		slices.Sort(changed)
+		for _, name := range changed {
+			pair := diff.Changed[name]
+			fmt.Fprintf(&b, "  %s: %q → %q\n", name, pair.Old, pair.New)
+		}
+	}
+	if len(removed) > 0 {
+		b.WriteString("Removed:\n")
+		for _, name := range removed {
+			fmt.Fprintf(&b, "  %s\n", name)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
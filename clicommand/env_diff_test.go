@@ -0,0 +1,69 @@
+package clicommand
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrintEnvDiff(t *testing.T) {
+	t.Parallel()
+
+	before := env.FromMap(map[string]string{"PATH": "/usr/bin", "OLD": "gone", "SAME": "same"})
+	after := env.FromMap(map[string]string{"PATH": "/opt/bin:/usr/bin", "NEW": "it's new", "SAME": "same"})
+	diff := after.Diff(before)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want: `Added:
+  NEW="it's new"
+Changed:
+  PATH: "/usr/bin" → "/opt/bin:/usr/bin"
+Removed:
+  OLD
+`,
+		},
+		{
+			format: "json",
+			want:   `{"added":{"NEW":"it's new"},"changed":{"PATH":{"old":"/usr/bin","new":"/opt/bin:/usr/bin"}},"removed":["OLD"]}` + "\n",
+		},
+		{
+			format: "bash",
+			want: `unset OLD
+export NEW='it'\''s new'
+export PATH='/opt/bin:/usr/bin'
+`,
+		},
+		{
+			format: "fish",
+			want: `set -e OLD
+set -gx NEW 'it\'s new'
+set -gx PATH '/opt/bin:/usr/bin'
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			t.Parallel()
+
+			var b strings.Builder
+			if err := printEnvDiff(&b, logger.Discard, test.format, diff); err != nil {
+				t.Fatalf("printEnvDiff() error = %v", err)
+			}
+			if diff := cmp.Diff(b.String(), test.want); diff != "" {
+				t.Errorf("printEnvDiff() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	if err := printEnvDiff(&strings.Builder{}, logger.Discard, "tcsh", diff); err == nil {
+		t.Errorf("printEnvDiff(format = tcsh) error = nil, want an error")
+	}
+}
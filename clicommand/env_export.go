@@ -0,0 +1,135 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/shellscript"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/urfave/cli"
+	"golang.org/x/exp/maps"
+)
+
+const envExportHelpDescription = `Usage:
+
+    buildkite-agent env export [options] [variables]
+
+Description:
+
+Prints statements that set the environment of the current process (or just
+the named variables) in another shell, quoted so that the shell can ′eval′
+them safely. This lets a hook written for one shell pass its environment to a
+hook or script written for another.
+
+The supported shells are bash (which also suits sh and zsh), fish, and
+powershell. Variables whose names the shell can't express are skipped with a
+warning.
+
+Examples:
+
+    $ eval "$(buildkite-agent env export DEPLOY_ENV RELEASE_VERSION)"
+    $ buildkite-agent env export --shell fish | source
+    PS> buildkite-agent env export --shell powershell | Out-String | Invoke-Expression`
+
+type EnvExportConfig struct {
+	Shell string `cli:"shell"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var EnvExportCommand = cli.Command{
+	Name:        "export",
+	Usage:       "Print the environment as statements for a shell to evaluate",
+	Description: envExportHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "shell",
+			Usage:  "The shell to write statements for: bash, fish, or powershell",
+			EnvVar: "BUILDKITE_AGENT_ENV_EXPORT_SHELL",
+			Value:  "bash",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) error {
+		_, cfg, l, _, done := setupLoggerAndConfig[EnvExportConfig](context.Background(), c)
+		defer done()
+
+		dialect, err := shellscript.ParseDialect(cfg.Shell)
+		if err != nil {
+			return err
+		}
+
+		environ := env.FromSlice(os.Environ())
+		set := environ.Dump()
+		notFound := false
+
+		// Filter by any args.
+		if len(c.Args()) > 0 {
+			set = make(map[string]string, len(c.Args()))
+			for _, name := range c.Args() {
+				v, ok := environ.Get(name)
+				if !ok {
+					notFound = true
+					l.Warn("%q is not set", name)
+					continue
+				}
+				set[name] = v
+			}
+		}
+
+		if err := writeEnvScript(c.App.Writer, l, dialect, set, nil); err != nil {
+			return err
+		}
+		if notFound {
+			return &SilentExitError{code: 1}
+		}
+		return nil
+	},
+}
+
+// writeEnvScript writes statements in the dialect that set the variables in
+// set, and remove those in unset, sorted by name. Variables the dialect can't
+// name are skipped with a warning.
+func writeEnvScript(w io.Writer, l logger.Logger, dialect shellscript.Dialect, set map[string]string, unset []string) error {
+	names := maps.Keys(set)
+	slices.Sort(names)
+	unset = slices.Clone(unset)
+	slices.Sort(unset)
+
+	for _, name := range unset {
+		stmt, err := shellscript.UnsetEnv(dialect, name)
+		if err != nil {
+			l.Warn("Skipping %s: %v", name, err)
+			continue
+		}
+		if _, err := fmt.Fprintln(w, stmt); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		stmt, err := shellscript.SetEnv(dialect, name, set[name])
+		if err != nil {
+			l.Warn("Skipping %s: %v", name, err)
+			continue
+		}
+		if _, err := fmt.Fprintln(w, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
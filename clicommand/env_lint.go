@@ -0,0 +1,321 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/internal/job"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/shell"
+	"github.com/urfave/cli"
+)
+
+const envLintHelpDescription = `Usage:
+
+    buildkite-agent env lint [options] <hook path>
+
+Description:
+
+Runs a hook the same way the job executor does, then prints the changes it
+made to the environment and the working directory, and fails if it changed
+any environment variables that hooks shouldn't.
+
+By default, hooks shouldn't change the variables that configure the agent
+(such as ′BUILDKITE_BUILD_PATH′), except those that the job executor allows
+hooks to change at run time (such as ′BUILDKITE_GIT_CLONE_FLAGS′). Use
+′--allow′ and ′--disallow′ to adjust this.
+
+The hook runs with the environment and working directory of this command, and
+its output is written to stderr. Only shell hooks (and PowerShell hooks) can
+change the environment, so other hooks are run but always pass.
+
+Like the job executor, values are only shown for variables that configure the
+agent, in case the others are secret.
+
+Example:
+
+    $ BUILDKITE_BUILD_PATH=/tmp/builds buildkite-agent env lint hooks/environment
+    Working directory: unchanged
+    Added:
+      DEPLOY_ENV
+    Changed:
+      BUILDKITE_BUILD_PATH: "/tmp/builds" → "/var/builds"
+    Disallowed changes:
+      BUILDKITE_BUILD_PATH
+    fatal: the hook made 1 disallowed environment change`
+
+type EnvLintConfig struct {
+	HookPath string   `cli:"arg:0" label:"hook path" validate:"required"`
+	Format   string   `cli:"format"`
+	Allow    []string `cli:"allow" normalize:"list"`
+	Disallow []string `cli:"disallow" normalize:"list"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var EnvLintCommand = cli.Command{
+	Name:        "lint",
+	Usage:       "Runs a hook and checks the changes it makes to the environment",
+	Description: envLintHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "format",
+			Usage:  "Output format: text or json",
+			EnvVar: "BUILDKITE_AGENT_ENV_LINT_FORMAT",
+			Value:  "text",
+		},
+		cli.StringSliceFlag{
+			Name:   "allow",
+			Value:  &cli.StringSlice{},
+			Usage:  "Environment variables the hook may change, even if they would otherwise be disallowed",
+			EnvVar: "BUILDKITE_AGENT_ENV_LINT_ALLOW",
+		},
+		cli.StringSliceFlag{
+			Name:   "disallow",
+			Value:  &cli.StringSlice{},
+			Usage:  "Additional environment variables the hook must not change",
+			EnvVar: "BUILDKITE_AGENT_ENV_LINT_DISALLOW",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) error {
+		ctx, cfg, _, _, done := setupLoggerAndConfig[EnvLintConfig](context.Background(), c)
+		defer done()
+
+		return lintHook(ctx, cfg, c.App.Writer, c.App.ErrWriter)
+	},
+}
+
+// envLintReport describes the changes a hook made.
+type envLintReport struct {
+	WorkingDir *envLintWorkingDir `json:"working_dir"`
+	Added      []envLintVar       `json:"added"`
+	Changed    []envLintChange    `json:"changed"`
+	Removed    []envLintVar       `json:"removed"`
+	Disallowed []string           `json:"disallowed"`
+}
+
+type envLintWorkingDir struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type envLintVar struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value,omitempty"`
+}
+
+type envLintChange struct {
+	Name string  `json:"name"`
+	Old  *string `json:"old,omitempty"`
+	New  *string `json:"new,omitempty"`
+}
+
+func lintHook(ctx context.Context, cfg EnvLintConfig, stdout, stderr io.Writer) error {
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("invalid format %q, must be text or json", cfg.Format)
+	}
+
+	hookType, err := hook.Type(cfg.HookPath)
+	if err != nil {
+		return fmt.Errorf("determining hook type: %w", err)
+	}
+
+	sh, err := shell.New(shell.WithStdout(stderr), shell.WithLogger(shell.NewWriterLogger(stderr, false, nil)))
+	if err != nil {
+		return err
+	}
+	beforeWd := sh.Getwd()
+
+	isPwsh := strings.EqualFold(filepath.Ext(cfg.HookPath), ".ps1")
+	if hookType != hook.TypeShell && !isPwsh {
+		// Like the executor, run other hooks directly. They can't change the
+		// environment of the job.
+		if err := sh.Command(cfg.HookPath).Run(ctx, shell.ShowPrompt(false)); err != nil {
+			return fmt.Errorf("running hook: %w", err)
+		}
+		return printEnvLint(stdout, cfg.Format, envLintReport{})
+	}
+
+	wrapper, err := hook.NewWrapper(hook.WithPath(cfg.HookPath))
+	if err != nil {
+		return fmt.Errorf("wrapping hook: %w", err)
+	}
+	defer wrapper.Close()
+
+	var cmd shell.Command
+	if isPwsh && runtime.GOOS != "windows" {
+		cmd = sh.Command("pwsh", "-file", wrapper.Path())
+	} else if cmd, err = sh.Script(wrapper.Path()); err != nil {
+		return err
+	}
+	if err := cmd.Run(ctx, shell.ShowPrompt(false)); err != nil {
+		return fmt.Errorf("running hook: %w", err)
+	}
+
+	changes, err := wrapper.Changes()
+	if err != nil {
+		if exitErr := new(hook.ExitError); errors.As(err, &exitErr) {
+			return errors.New("the hook exited early, so its changes to the environment couldn't be checked")
+		}
+		return fmt.Errorf("getting the hook's changes to the environment: %w", err)
+	}
+
+	afterWd, _ := changes.GetAfterWd()
+	report := lintEnvChanges(changes, beforeWd, afterWd, disallowedHookEnv(cfg.Allow, cfg.Disallow))
+	if err := printEnvLint(stdout, cfg.Format, report); err != nil {
+		return err
+	}
+
+	switch n := len(report.Disallowed); n {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("the hook made 1 disallowed environment change")
+	default:
+		return fmt.Errorf("the hook made %d disallowed environment changes", n)
+	}
+}
+
+// disallowedHookEnv returns the environment variables that hooks shouldn't
+// change: those that configure the agent, except those the executor reads at
+// run time, plus disallow, minus allow.
+func disallowedHookEnv(allow, disallow []string) map[string]bool {
+	disallowed := make(map[string]bool)
+	for name := range agent.ProtectedEnv {
+		disallowed[name] = true
+	}
+	for _, name := range job.ConfigEnvVars() {
+		delete(disallowed, name)
+	}
+	for _, name := range disallow {
+		disallowed[name] = true
+	}
+	for _, name := range allow {
+		delete(disallowed, name)
+	}
+	return disallowed
+}
+
+// lintEnvChanges describes the changes a hook made, and which of them change
+// disallowed variables. Values are only included for variables that configure
+// the agent.
+func lintEnvChanges(changes hook.EnvChanges, beforeWd, afterWd string, disallowed map[string]bool) envLintReport {
+	showValue := make(map[string]bool)
+	for name := range agent.ProtectedEnv {
+		showValue[name] = true
+	}
+	for _, name := range job.ConfigEnvVars() {
+		showValue[name] = true
+	}
+	value := func(name, v string) *string {
+		if !showValue[name] {
+			return nil
+		}
+		return &v
+	}
+
+	var report envLintReport
+	if afterWd != "" && afterWd != beforeWd {
+		report.WorkingDir = &envLintWorkingDir{Before: beforeWd, After: afterWd}
+	}
+
+	for name, v := range changes.Diff.Added {
+		report.Added = append(report.Added, envLintVar{Name: name, Value: value(name, v)})
+	}
+	for name, pair := range changes.Diff.Changed {
+		report.Changed = append(report.Changed, envLintChange{Name: name, Old: value(name, pair.Old), New: value(name, pair.New)})
+	}
+	for name := range changes.Diff.Removed {
+		report.Removed = append(report.Removed, envLintVar{Name: name})
+	}
+	slices.SortFunc(report.Added, func(a, b envLintVar) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(report.Changed, func(a, b envLintChange) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(report.Removed, func(a, b envLintVar) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, v := range report.Added {
+		if disallowed[v.Name] {
+			report.Disallowed = append(report.Disallowed, v.Name)
+		}
+	}
+	for _, c := range report.Changed {
+		if disallowed[c.Name] {
+			report.Disallowed = append(report.Disallowed, c.Name)
+		}
+	}
+	for _, v := range report.Removed {
+		if disallowed[v.Name] {
+			report.Disallowed = append(report.Disallowed, v.Name)
+		}
+	}
+	slices.Sort(report.Disallowed)
+
+	return report
+}
+
+func printEnvLint(w io.Writer, format string, report envLintReport) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	if report.WorkingDir == nil {
+		fmt.Fprintln(w, "Working directory: unchanged")
+	} else {
+		fmt.Fprintf(w, "Working directory: %q → %q\n", report.WorkingDir.Before, report.WorkingDir.After)
+	}
+
+	if len(report.Added) > 0 {
+		fmt.Fprintln(w, "Added:")
+		for _, v := range report.Added {
+			if v.Value != nil {
+				fmt.Fprintf(w, "  %s=%q\n", v.Name, *v.Value)
+			} else {
+				fmt.Fprintf(w, "  %s\n", v.Name)
+			}
+		}
+	}
+	if len(report.Changed) > 0 {
+		fmt.Fprintln(w, "Changed:")
+		for _, c := range report.Changed {
+			if c.Old != nil && c.New != nil {
+				fmt.Fprintf(w, "  %s: %q → %q\n", c.Name, *c.Old, *c.New)
+			} else {
+				fmt.Fprintf(w, "  %s\n", c.Name)
+			}
+		}
+	}
+	if len(report.Removed) > 0 {
+		fmt.Fprintln(w, "Removed:")
+		for _, v := range report.Removed {
+			fmt.Fprintf(w, "  %s\n", v.Name)
+		}
+	}
+	if len(report.Disallowed) > 0 {
+		fmt.Fprintln(w, "Disallowed changes:")
+		for _, name := range report.Disallowed {
+			fmt.Fprintf(w, "  %s\n", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package clicommand
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDisallowedHookEnv(t *testing.T) {
+	t.Parallel()
+
+	disallowed := disallowedHookEnv([]string{"BUILDKITE_BUILD_PATH"}, []string{"DEPLOY_ENV"})
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "BUILDKITE_HOOKS_PATH", want: true},       // agent configuration
+		{name: "BUILDKITE_GIT_CLONE_FLAGS", want: false}, // read by the executor at run time
+		{name: "BUILDKITE_BUILD_PATH", want: false},      // allowed
+		{name: "DEPLOY_ENV", want: true},                 // disallowed
+		{name: "FOO", want: false},
+	}
+	for _, test := range tests {
+		if got := disallowed[test.name]; got != test.want {
+			t.Errorf("disallowedHookEnv(...)[%q] = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLintEnvChanges(t *testing.T) {
+	t.Parallel()
+
+	changes := hook.EnvChanges{Diff: env.Diff{
+		Added: map[string]string{
+			"SECRET":                    "hunter2",
+			"BUILDKITE_GIT_CLONE_FLAGS": "-v",
+		},
+		Changed: map[string]env.DiffPair{
+			"BUILDKITE_BUILD_PATH": {Old: "/tmp/builds", New: "/var/builds"},
+		},
+		Removed: map[string]struct{}{
+			"BUILDKITE_HOOKS_PATH": {},
+		},
+	}}
+	disallowed := disallowedHookEnv(nil, nil)
+
+	report := lintEnvChanges(changes, "/work", "/work/src", disallowed)
+
+	var got strings.Builder
+	if err := printEnvLint(&got, "text", report); err != nil {
+		t.Fatalf("printEnvLint(text) error = %v", err)
+	}
+	want := `Working directory: "/work" → "/work/src"
+Added:
+  BUILDKITE_GIT_CLONE_FLAGS="-v"
+  SECRET
+Changed:
+  BUILDKITE_BUILD_PATH: "/tmp/builds" → "/var/builds"
+Removed:
+  BUILDKITE_HOOKS_PATH
+Disallowed changes:
+  BUILDKITE_BUILD_PATH
+  BUILDKITE_HOOKS_PATH
+`
+	if diff := cmp.Diff(got.String(), want); diff != "" {
+		t.Errorf("printEnvLint(text) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPrintEnvLintJSON(t *testing.T) {
+	t.Parallel()
+
+	changes := hook.EnvChanges{Diff: env.Diff{
+		Added: map[string]string{"SECRET": "hunter2"},
+	}}
+	report := lintEnvChanges(changes, "/work", "/work", disallowedHookEnv(nil, nil))
+
+	var got strings.Builder
+	if err := printEnvLint(&got, "json", report); err != nil {
+		t.Fatalf("printEnvLint(json) error = %v", err)
+	}
+	want := `{
+  "working_dir": null,
+  "added": [
+    {
+      "name": "SECRET"
+    }
+  ],
+  "changed": null,
+  "removed": null,
+  "disallowed": null
+}
+`
+	if diff := cmp.Diff(got.String(), want); diff != "" {
+		t.Errorf("printEnvLint(json) diff (-got +want):\n%s", diff)
+	}
+}
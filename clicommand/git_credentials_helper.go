@@ -2,14 +2,18 @@ package clicommand
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/urfave/cli"
 )
@@ -28,11 +32,19 @@ The credentials are returned in the git-credential format.
 This command will only work if the organization running the job has connected a Github app with Code Access enabled, and
 if the pipeline has this feature enabled. All hosted compute jobs automatically qualify for this feature.
 
+Within a job, tokens are cached by the job executor, and reused for later
+fetches of the same repository while they remain valid for at least
+--min-validity. If Buildkite doesn't say when a token expires, it is assumed to
+expire 30 minutes after it was issued, well within the hour that GitHub App
+tokens last. If git reports that a token didn't work, it is removed from the
+cache.
+
 This command is intended to be used as a git credential helper, and not called directly.`
 
 type GitCredentialsHelperConfig struct {
-	JobID  string `cli:"job-id" validate:"required"`
-	Action string `cli:"arg:0"`
+	JobID       string        `cli:"job-id" validate:"required"`
+	MinValidity time.Duration `cli:"min-validity"`
+	Action      string        `cli:"arg:0"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -46,6 +58,9 @@ type GitCredentialsHelperConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var GitCredentialsHelperCommand = cli.Command{
@@ -58,11 +73,20 @@ var GitCredentialsHelperCommand = cli.Command{
 			Usage:  "The job id to get credentials for",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		cli.DurationFlag{
+			Name:   "min-validity",
+			Usage:  "How long a cached token from an earlier fetch in the same job must remain valid for to be reused, rather than requesting a new token",
+			EnvVar: "BUILDKITE_GIT_CREDENTIALS_MIN_VALIDITY",
+			Value:  5 * time.Minute,
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		// DebugHTTPFlag, // Not present due to the possibility of leaking code access tokens to logs
 
 		// Global flags
@@ -78,12 +102,17 @@ var GitCredentialsHelperCommand = cli.Command{
 		defer done()
 
 		l.Debug("Action: %s", cfg.Action)
-		if cfg.Action != "get" {
-			// other actions are store and erase, which we don't support
+		if cfg.Action != "get" && cfg.Action != "erase" {
+			// the other action is store, which we don't need, as tokens are
+			// cached when they're requested
 			// see: https://git-scm.com/docs/gitcredentials#Documentation/gitcredentials.txt-codegetcode
 			return nil
 		}
 
+		if cfg.MinValidity < 0 {
+			return fmt.Errorf("min-validity %v must not be negative", cfg.MinValidity)
+		}
+
 		// ie, if the flags are from the command line rather than from the environment, which is how they should be passed
 		// to this process when it's called through the job executor
 		if os.Getenv("BUILDKITE_JOB_ID") == "" {
@@ -105,18 +134,66 @@ var GitCredentialsHelperCommand = cli.Command{
 
 		repo, err := parseGitURLFromCredentialInput(string(stdin))
 		if err != nil {
+			if cfg.Action == "erase" {
+				return nil
+			}
 			return handleAuthError(c, l, fmt.Errorf("failed to parse git URL from stdin: %w", err))
 		}
 
+		// Cache tokens in the job executor, if the Job API is available, so
+		// that every git invocation in the job doesn't need a new token
+		cacheKey := gitCredentialCacheKey(repo)
+		jobClient, err := jobapi.NewDefaultClient(ctx)
+		if err != nil {
+			l.Debug("Not caching git credentials, as the Job API isn't available: %v", err)
+		}
+
+		if cfg.Action == "erase" {
+			// git tells us when the credential we gave it was rejected, so
+			// don't give it out again
+			if jobClient != nil {
+				if err := jobClient.GitCredentialDelete(ctx, cacheKey); err != nil {
+					l.Warn("Couldn't remove cached git credential from the Job API: %v", err)
+				}
+			}
+			return nil
+		}
+
+		if jobClient != nil {
+			cached, err := jobClient.GitCredentialGet(ctx, cacheKey, cfg.MinValidity)
+			if err != nil {
+				l.Warn("Couldn't get cached git credential from the Job API: %v", err)
+			}
+			if cached != nil {
+				l.Debug("Using cached git credential, which expires at %v", cached.ExpiresAt)
+				printGitCredential(c.App.Writer, cached.Username, cached.Password)
+				return nil
+			}
+		}
+
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
-		tok, _, err := client.GenerateGithubCodeAccessToken(ctx, repo, cfg.JobID)
+		// Note: tokens aren't scoped to the repository, as the Agent API
+		// isn't known to support that, so the token may be able to access
+		// every repository the GitHub App installation can.
+		issuedAt := time.Now()
+		tok, _, err := client.GenerateGithubCodeAccessToken(ctx, cfg.JobID, &api.GithubCodeAccessTokenRequest{
+			RepoURL: repo,
+		})
 		if err != nil {
 			return handleAuthError(c, l, fmt.Errorf("failed to get github app credentials: %w", err))
 		}
 
-		fmt.Fprintln(c.App.Writer, "username=token")
-		fmt.Fprintln(c.App.Writer, "password="+tok)
-		fmt.Fprintln(c.App.Writer, "")
+		if jobClient != nil {
+			if err := jobClient.GitCredentialPut(ctx, cacheKey, &jobapi.GitCredential{
+				Username:  "token",
+				Password:  tok.Token,
+				ExpiresAt: gitCredentialExpiry(tok, issuedAt),
+			}); err != nil {
+				l.Warn("Couldn't cache git credential in the Job API: %v", err)
+			}
+		}
+
+		printGitCredential(c.App.Writer, "token", tok.Token)
 
 		l.Debug("Authentication successful!")
 
@@ -124,6 +201,38 @@ var GitCredentialsHelperCommand = cli.Command{
 	},
 }
 
+// Tokens whose expiry Buildkite doesn't give are cached for this long after
+// they were requested. GitHub App installation tokens last an hour, but
+// Buildkite may hand out a token it issued a while ago.
+const gitCredentialDefaultTTL = 30 * time.Minute
+
+// gitCredentialExpiry returns when a token requested at issuedAt should be
+// treated as expired.
+func gitCredentialExpiry(tok *api.GithubCodeAccessTokenResponse, issuedAt time.Time) time.Time {
+	if tok.ExpiresAt.IsZero() {
+		return issuedAt.Add(gitCredentialDefaultTTL)
+	}
+	return tok.ExpiresAt
+}
+
+// printGitCredential writes a username and password in the git-credential
+// format.
+func printGitCredential(w io.Writer, username, password string) {
+	fmt.Fprintln(w, "username="+username)
+	fmt.Fprintln(w, "password="+password)
+	fmt.Fprintln(w, "")
+}
+
+// gitCredentialCacheKey returns the key to cache credentials for the
+// repository under. git may or may not include the .git suffix in the path
+// depending on how the repository was specified, but they're the same
+// repository either way.
+func gitCredentialCacheKey(repo string) string {
+	repo = strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git")
+	sum := sha256.Sum256([]byte(repo))
+	return hex.EncodeToString(sum[:])
+}
+
 // handleAuthError is a helper function that logs an error and outputs a dummy password
 // git continues with clones etc even when the credential helper fails, so we should output something that will 100% cause
 // the clone to fail
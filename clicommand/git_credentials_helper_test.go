@@ -4,6 +4,9 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
 )
 
 func TestParseGitCredentialInput(t *testing.T) {
@@ -75,3 +78,43 @@ func TestParseGitCredentialInput(t *testing.T) {
 		})
 	}
 }
+
+func TestGitCredentialCacheKey(t *testing.T) {
+	t.Parallel()
+
+	repo := "https://github.com/buildkite/agent"
+	for _, same := range []string{
+		"https://github.com/buildkite/agent.git",
+		"https://github.com/buildkite/agent/",
+	} {
+		if got, want := gitCredentialCacheKey(same), gitCredentialCacheKey(repo); got != want {
+			t.Errorf("gitCredentialCacheKey(%q) = %q, want it to equal gitCredentialCacheKey(%q) = %q", same, got, repo, want)
+		}
+	}
+
+	for _, other := range []string{
+		"https://github.com/buildkite/elastic-ci-stack-for-aws",
+		"https://github.com/llamas/agent",
+		"https://ghe.example.com/buildkite/agent",
+	} {
+		if gitCredentialCacheKey(other) == gitCredentialCacheKey(repo) {
+			t.Errorf("gitCredentialCacheKey(%q) == gitCredentialCacheKey(%q), want them to differ", other, repo)
+		}
+	}
+}
+
+func TestGitCredentialExpiry(t *testing.T) {
+	t.Parallel()
+
+	issuedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	given := issuedAt.Add(time.Hour)
+	if got := gitCredentialExpiry(&api.GithubCodeAccessTokenResponse{ExpiresAt: given}, issuedAt); !got.Equal(given) {
+		t.Errorf("gitCredentialExpiry(expires_at = %v) = %v, want %v", given, got, given)
+	}
+
+	want := issuedAt.Add(gitCredentialDefaultTTL)
+	if got := gitCredentialExpiry(&api.GithubCodeAccessTokenResponse{}, issuedAt); !got.Equal(want) {
+		t.Errorf("gitCredentialExpiry(no expires_at) = %v, want %v", got, want)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/cliconfig"
+	"github.com/buildkite/agent/v3/internal/agenthttp"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/version"
@@ -39,7 +42,7 @@ var (
 	EndpointFlag = cli.StringFlag{
 		Name:   "endpoint",
 		Value:  DefaultEndpoint,
-		Usage:  "The Agent API endpoint",
+		Usage:  "The Agent API endpoint. Separate several endpoints (such as regional or proxied routes to the API) with commas to fail over between them when one can't be reached",
 		EnvVar: "BUILDKITE_AGENT_ENDPOINT",
 	}
 
@@ -49,6 +52,24 @@ var (
 		EnvVar: "BUILDKITE_NO_HTTP2",
 	}
 
+	ProxyURLFlag = cli.StringFlag{
+		Name:   "proxy-url",
+		Usage:  "The proxy to use for HTTP and HTTPS connections, such as ′http://proxy.example.com:3128′. It is also passed to jobs, git and other tools as HTTP_PROXY and HTTPS_PROXY. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables",
+		EnvVar: "BUILDKITE_PROXY_URL",
+	}
+
+	NoProxyFlag = cli.StringFlag{
+		Name:   "no-proxy",
+		Usage:  "A comma-separated list of hosts, domains and IP ranges to connect to without the proxy, in the same format as the NO_PROXY environment variable, which it is also passed to jobs as",
+		EnvVar: "BUILDKITE_NO_PROXY",
+	}
+
+	CACertFileFlag = cli.StringFlag{
+		Name:   "ca-cert-file",
+		Usage:  "Path to a file of PEM-encoded certificate authorities to trust instead of the system's for HTTPS connections. It is also passed to jobs as SSL_CERT_FILE, GIT_SSL_CAINFO and AWS_CA_BUNDLE",
+		EnvVar: "BUILDKITE_CA_CERT_FILE",
+	}
+
 	DebugFlag = cli.BoolFlag{
 		Name:   "debug",
 		Usage:  "Enable debug mode. Synonym for ′--log-level debug′. Takes precedence over ′--log-level′",
@@ -92,6 +113,13 @@ var (
 		EnvVar: "BUILDKITE_STRICT_SINGLE_HOOKS",
 	}
 
+	HookInterpretersFlag = cli.StringSliceFlag{
+		Name:   "hook-interpreters",
+		Value:  &cli.StringSlice{},
+		Usage:  "Interpreters for script hooks on Windows, and for hooks without a shebang elsewhere, by file extension, such as ′.py=python3′. These are added to the defaults: ′.ps1=pwsh′, ′.py=python′, and ′.rb=ruby′",
+		EnvVar: "BUILDKITE_HOOK_INTERPRETERS",
+	}
+
 	KubernetesExecFlag = cli.BoolFlag{
 		Name: "kubernetes-exec",
 		Usage: "This is intended to be used only by the Buildkite k8s stack " +
@@ -106,6 +134,46 @@ var (
 		EnvVar: "BUILDKITE_NO_MULTIPART_ARTIFACT_UPLOAD",
 	}
 
+	CheckoutRetryAttemptsFlag = cli.IntFlag{
+		Name:   "checkout-retry-attempts",
+		Value:  3,
+		Usage:  "How many times to try checking out the repository before giving up. Authentication and permission errors aren't retried",
+		EnvVar: "BUILDKITE_CHECKOUT_RETRY_ATTEMPTS",
+	}
+
+	CheckoutRetryBackoffFlag = cli.DurationFlag{
+		Name:   "checkout-retry-backoff",
+		Value:  2 * time.Second,
+		Usage:  "How long to wait before retrying a failed checkout. Checkouts that fail because of network or server errors wait exponentially longer each time, with some jitter",
+		EnvVar: "BUILDKITE_CHECKOUT_RETRY_BACKOFF",
+	}
+
+	SoftFailExitStatusFlag = cli.StringSliceFlag{
+		Name:   "soft-fail-exit-status",
+		Value:  &cli.StringSlice{},
+		Usage:  "Exit statuses of the command that make the step soft fail, for example ′2,3′. The step's soft_fail is replaced with the exit status the command exited with, which requires Buildkite to accept soft_fail changes to running steps. The job still exits with the command's exit status, which is also recorded in the build meta-data key ′buildkite:soft-fail:<job id>′",
+		EnvVar: "BUILDKITE_SOFT_FAIL_EXIT_STATUS",
+	}
+
+	SoftFailClassifierFlag = cli.StringFlag{
+		Name:   "soft-fail-classifier",
+		Value:  "",
+		Usage:  "A program to run when the command fails, with the job's environment including ′BUILDKITE_COMMAND_EXIT_STATUS′. If it exits with 0, the step is marked as soft failed, as with --soft-fail-exit-status",
+		EnvVar: "BUILDKITE_SOFT_FAIL_CLASSIFIER",
+	}
+
+	ArtifactEncryptionKeyFileFlag = cli.StringFlag{
+		Name:   "artifact-encryption-key-file",
+		Usage:  "Path to a file containing a 256-bit key (raw or base64) used to encrypt artifacts before upload, and to decrypt them on download",
+		EnvVar: "BUILDKITE_ARTIFACT_ENCRYPTION_KEY_FILE",
+	}
+
+	ArtifactEncryptionAWSKMSKeyFlag = cli.StringFlag{
+		Name:   "artifact-encryption-aws-kms-key",
+		Usage:  "The AWS KMS key ID, or key alias, used to generate data keys for encrypting artifacts before upload. Artifacts encrypted this way are decrypted on download using KMS",
+		EnvVar: "BUILDKITE_ARTIFACT_ENCRYPTION_AWS_KMS_KEY",
+	}
+
 	ExperimentsFlag = cli.StringSliceFlag{
 		Name:   "experiment",
 		Value:  &cli.StringSlice{},
@@ -259,6 +327,67 @@ func handleLogLevelFlag(l logger.Logger, cfg any) error {
 	return nil
 }
 
+// proxyEnvVars are the environment variables that tools (including Go's
+// net/http, git and curl) read the proxy from. Some only read the lowercase
+// forms, and others prefer them.
+var (
+	proxyEnvVars   = []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"}
+	noProxyEnvVars = []string{"NO_PROXY", "no_proxy"}
+	caCertEnvVars  = []string{"SSL_CERT_FILE", "GIT_SSL_CAINFO", "AWS_CA_BUNDLE"}
+)
+
+// handleNetworkFlags applies the ProxyURL, NoProxy and CACertFile config
+// fields, if present, to the HTTP clients in this process. It also exports
+// them to the environment, both as the variables that other tools read and as
+// the variables for the flags themselves, so that jobs (and the git and
+// buildkite-agent commands they run) reach the network the same way the agent
+// does.
+func handleNetworkFlags(l logger.Logger, cfg any) error {
+	var nc agenthttp.NetworkConfig
+	setenv := func(value, flagEnvVar string, envVars []string) {
+		for _, name := range envVars {
+			os.Setenv(name, value) //nolint:errcheck // only fails for invalid names
+		}
+		os.Setenv(flagEnvVar, value) //nolint:errcheck // only fails for invalid names
+	}
+
+	if proxyURL, err := reflections.GetField(cfg, "ProxyURL"); err == nil && proxyURL != "" {
+		nc.ProxyURL = proxyURL.(string)
+		if err := agenthttp.ValidateProxyURL(nc.ProxyURL); err != nil {
+			return err
+		}
+		// Different proxies for different tools is a recipe for confusion
+		for _, name := range proxyEnvVars {
+			if v := os.Getenv(name); v != "" && v != nc.ProxyURL {
+				l.Warn("Overriding %s with the proxy URL from --proxy-url", name)
+			}
+		}
+		setenv(nc.ProxyURL, ProxyURLFlag.EnvVar, proxyEnvVars)
+	}
+
+	if noProxy, err := reflections.GetField(cfg, "NoProxy"); err == nil && noProxy != "" {
+		nc.NoProxy = noProxy.(string)
+		setenv(nc.NoProxy, NoProxyFlag.EnvVar, noProxyEnvVars)
+	}
+
+	if caCertFile, err := reflections.GetField(cfg, "CACertFile"); err == nil && caCertFile != "" {
+		// Jobs run in a different working directory
+		path, err := filepath.Abs(caCertFile.(string))
+		if err != nil {
+			return err
+		}
+		if nc.RootCAs, err = agenthttp.LoadCACertFile(path); err != nil {
+			return err
+		}
+		setenv(path, CACertFileFlag.EnvVar, caCertEnvVars)
+	}
+
+	if nc == (agenthttp.NetworkConfig{}) {
+		return nil
+	}
+	return agenthttp.SetNetworkConfig(nc)
+}
+
 func UnsetConfigFromEnvironment(c *cli.Context) error {
 	flags := append(c.App.Flags, c.Command.Flags...)
 	for _, fl := range flags {
@@ -362,6 +491,10 @@ func setupLoggerAndConfig[T any](ctx context.Context, c *cli.Context, opts ...co
 		l.Warn("%s", warning)
 	}
 
+	if err := handleNetworkFlags(l, cfg); err != nil {
+		l.Fatal("%v", err)
+	}
+
 	// Setup any global configuration options
 	ctx, done = HandleGlobalFlags(ctx, l, cfg)
 	return ctx, cfg, l, loader.File, done
@@ -24,6 +24,11 @@ is no ordering guarantee of which one will be given the lock next.
 To prevent separate processes unlocking each other, the output from ′lock
 acquire′ should be stored, and passed to ′lock release′.
 
+If --ttl is given, the lock is released automatically once it has been held
+for that long, so that a process that crashes while holding the lock doesn't
+block other jobs forever. Choose a TTL longer than the critical section: once
+it expires, another process may acquire the lock, and ′lock release′ fails.
+
 Note that this subcommand is only available when an agent has been started
 with the ′agent-api′ experiment enabled.
 
@@ -32,7 +37,10 @@ Examples:
     #!/bin/bash
     token=$(buildkite-agent lock acquire llama)
     # your critical section here...
-    buildkite-agent lock release llama "${token}"`
+    buildkite-agent lock release llama "${token}"
+
+    #!/bin/bash
+    token=$(buildkite-agent lock acquire --ttl 10m llama)`
 
 type LockAcquireConfig struct {
 	// Common config options
@@ -40,6 +48,7 @@ type LockAcquireConfig struct {
 	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
 
 	LockWaitTimeout time.Duration `cli:"lock-wait-timeout"`
+	TTL             time.Duration `cli:"ttl"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -57,6 +66,11 @@ func lockAcquireFlags() []cli.Flag {
 				Usage:  "Sets a maximum duration to wait for a lock before giving up",
 				EnvVar: "BUILDKITE_LOCK_WAIT_TIMEOUT",
 			},
+			cli.DurationFlag{
+				Name:   "ttl",
+				Usage:  "Releases the lock automatically once it has been held for this long (default: never)",
+				EnvVar: "BUILDKITE_LOCK_TTL",
+			},
 		},
 		lockCommonFlags...,
 	)
@@ -96,7 +110,11 @@ func lockAcquireAction(c *cli.Context) error {
 		return fmt.Errorf(lockClientErrMessage, err)
 	}
 
-	token, err := client.Lock(ctx, key)
+	if cfg.TTL < 0 {
+		return fmt.Errorf("invalid --ttl %v, must not be negative", cfg.TTL)
+	}
+
+	token, err := client.LockWithTTL(ctx, key, cfg.TTL)
 	if err != nil {
 		return fmt.Errorf("could not acquire lock: %w", err)
 	}
@@ -0,0 +1,141 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buildkite/agent/v3/lock"
+	"github.com/urfave/cli"
+)
+
+const lockStatusHelpDescription = `Usage:
+
+    buildkite-agent lock status [options...]
+
+Description:
+
+Lists the locks that are currently held, along with their values (the tokens
+returned by ′lock acquire′, or the state of ′lock do′ sections), how long
+they have been held, and when they expire. This is useful for debugging jobs
+that are stuck waiting for a lock.
+
+Note that this subcommand is only available when an agent has been started
+with the ′agent-api′ experiment enabled.
+
+Examples:
+
+    $ buildkite-agent lock status
+    KEY    VALUE                                                    AGE  EXPIRES IN
+    llama  acquired(pid=1234,otp=00112233445566778899aabbccddeeff)  42s  9m18s
+
+    $ buildkite-agent lock status --format json | jq '.[] | select(.age_seconds > 600)'`
+
+type LockStatusConfig struct {
+	Format string `cli:"format"`
+
+	// Common config options
+	LockScope   string `cli:"lock-scope"`
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var LockStatusCommand = cli.Command{
+	Name:        "status",
+	Usage:       "Lists the locks held by the agent leader",
+	Description: lockStatusHelpDescription,
+	Flags: append(
+		append(globalFlags(), lockCommonFlags...),
+		cli.StringFlag{
+			Name:   "format",
+			Value:  "text",
+			Usage:  "The output format: ′text′ for a table, or ′json′",
+			EnvVar: "BUILDKITE_LOCK_STATUS_FORMAT",
+		},
+	),
+	Action: lockStatusAction,
+}
+
+// lockStatusResult is the JSON output of ′lock status′ for each lock.
+type lockStatusResult struct {
+	Key        string     `json:"key"`
+	Value      string     `json:"value"`
+	AcquiredAt time.Time  `json:"acquired_at"`
+	AgeSeconds float64    `json:"age_seconds"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+func lockStatusAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		fmt.Fprint(c.App.ErrWriter, lockStatusHelpDescription)
+		return &SilentExitError{code: 1}
+	}
+
+	ctx, cfg, _, _, done := setupLoggerAndConfig[LockStatusConfig](context.Background(), c)
+	defer done()
+
+	if cfg.LockScope != "machine" {
+		return errors.New("only 'machine' scope for locks is supported in this version.")
+	}
+
+	if cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("invalid --format %q, must be text or json", cfg.Format)
+	}
+
+	client, err := lock.NewClient(ctx, cfg.SocketsPath)
+	if err != nil {
+		return fmt.Errorf(lockClientErrMessage, err)
+	}
+
+	statuses, err := client.List(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't list locks: %w", err)
+	}
+
+	return printLockStatuses(c.App.Writer, cfg.Format, statuses, time.Now())
+}
+
+// printLockStatuses prints the locks as a table or JSON, with ages relative
+// to now.
+func printLockStatuses(w io.Writer, format string, statuses []lock.Status, now time.Time) error {
+	if format == "json" {
+		results := make([]lockStatusResult, 0, len(statuses))
+		for _, st := range statuses {
+			result := lockStatusResult{
+				Key:        st.Key,
+				Value:      st.Value,
+				AcquiredAt: st.AcquiredAt,
+				AgeSeconds: now.Sub(st.AcquiredAt).Seconds(),
+			}
+			if !st.ExpiresAt.IsZero() {
+				result.ExpiresAt = &st.ExpiresAt
+			}
+			results = append(results, result)
+		}
+		if err := json.NewEncoder(w).Encode(results); err != nil {
+			return fmt.Errorf("error marshalling JSON: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tAGE\tEXPIRES IN")
+	for _, st := range statuses {
+		expires := "never"
+		if !st.ExpiresAt.IsZero() {
+			expires = st.ExpiresAt.Sub(now).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", st.Key, st.Value, now.Sub(st.AcquiredAt).Round(time.Second), expires)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,40 @@
+package clicommand
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/lock"
+)
+
+func TestPrintLockStatuses(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []lock.Status{
+		{Key: "alpaca", Value: "done", AcquiredAt: now.Add(-time.Hour)},
+		{Key: "llama", Value: "Kuzco", AcquiredAt: now.Add(-42 * time.Second), ExpiresAt: now.Add(9 * time.Minute)},
+	}
+
+	var text strings.Builder
+	if err := printLockStatuses(&text, "text", statuses, now); err != nil {
+		t.Fatalf("printLockStatuses(text) error = %v", err)
+	}
+	wantText := "KEY     VALUE  AGE     EXPIRES IN\n" +
+		"alpaca  done   1h0m0s  never\n" +
+		"llama   Kuzco  42s     9m0s\n"
+	if got := text.String(); got != wantText {
+		t.Errorf("printLockStatuses(text) output = %q, want %q", got, wantText)
+	}
+
+	var js strings.Builder
+	if err := printLockStatuses(&js, "json", statuses, now); err != nil {
+		t.Fatalf("printLockStatuses(json) error = %v", err)
+	}
+	wantJSON := `[{"key":"alpaca","value":"done","acquired_at":"2024-01-01T11:00:00Z","age_seconds":3600},` +
+		`{"key":"llama","value":"Kuzco","acquired_at":"2024-01-01T11:59:18Z","age_seconds":42,"expires_at":"2024-01-01T12:09:00Z"}]` + "\n"
+	if got := js.String(); got != wantJSON {
+		t.Errorf("printLockStatuses(json) output = %q, want %q", got, wantJSON)
+	}
+}
@@ -27,6 +27,7 @@ type MetaDataExistsConfig struct {
 	Key   string `cli:"arg:0" label:"meta-data key" validate:"required"`
 	Job   string `cli:"job"`
 	Build string `cli:"build"`
+	Scope string `cli:"scope"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -40,6 +41,9 @@ type MetaDataExistsConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var MetaDataExistsCommand = cli.Command{
@@ -59,11 +63,15 @@ var MetaDataExistsCommand = cli.Command{
 			Usage:  "Which build should the meta-data be retrieved from. --build will take precedence over --job",
 			EnvVar: "BUILDKITE_METADATA_BUILD_ID",
 		},
+		metaDataScopeFlag,
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -78,6 +86,11 @@ var MetaDataExistsCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[MetaDataExistsConfig](ctx, c)
 		defer done()
 
+		var err error
+		if cfg.Scope, err = normaliseMetaDataScope(cfg.Scope); err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
@@ -95,7 +108,7 @@ var MetaDataExistsCommand = cli.Command{
 			roko.WithStrategy(roko.Constant(5*time.Second)),
 		)
 		exists, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) (*api.MetaDataExists, error) {
-			exists, resp, err := client.ExistsMetaData(ctx, scope, id, cfg.Key)
+			exists, resp, err := client.ExistsMetaData(ctx, scope, id, scopedMetaDataKey(cfg.Scope, cfg.Key))
 			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404) {
 				r.Break()
 			}
@@ -2,10 +2,14 @@ package clicommand
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/roko"
 	"github.com/urfave/cli"
 )
@@ -13,18 +17,33 @@ import (
 const metaDataGetHelpDescription = `Usage:
 
     buildkite-agent meta-data get <key> [options...]
+    buildkite-agent meta-data get --all --format json [options...]
 
 Description:
 
 Get data from a build's key/value store.
 
+With --format json, the value is printed as a JSON object of the key and its
+value. With --all, every key and value is printed as a JSON object. The keys
+are listed first, and then each value is fetched with a request of its own,
+several at a time.
+
+Jobs in a step with a key get keys from their step's namespace by default (see
+--scope). Use --scope build for keys shared by the whole build, or
+--scope step:<step key> for another step's keys.
+
 Example:
 
-    $ buildkite-agent meta-data get "foo"`
+    $ buildkite-agent meta-data get "foo"
+    $ buildkite-agent meta-data get "foo" --scope build
+    $ buildkite-agent meta-data get --all --format json > meta-data.json`
 
 type MetaDataGetConfig struct {
-	Key     string `cli:"arg:0" label:"meta-data key" validate:"required"`
+	Key     string `cli:"arg:0" label:"meta-data key"`
 	Default string `cli:"default"`
+	All     bool   `cli:"all"`
+	Format  string `cli:"format"`
+	Scope   string `cli:"scope"`
 	Job     string `cli:"job"`
 	Build   string `cli:"build"`
 
@@ -40,6 +59,9 @@ type MetaDataGetConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var MetaDataGetCommand = cli.Command{
@@ -52,6 +74,15 @@ var MetaDataGetCommand = cli.Command{
 			Value: "",
 			Usage: "If the meta-data value doesn't exist return this instead",
 		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "Get every key and value, instead of a single key. Requires --format json",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "",
+			Usage: "The output format: ′text′ for the raw value, or ′json′ for an object of keys and values (default: ′text′, or ′json′ with --all)",
+		},
 		cli.StringFlag{
 			Name:   "job",
 			Value:  "",
@@ -64,11 +95,15 @@ var MetaDataGetCommand = cli.Command{
 			Usage:  "Which build should the meta-data be retrieved from. --build will take precedence over --job",
 			EnvVar: "BUILDKITE_METADATA_BUILD_ID",
 		},
+		metaDataScopeFlag,
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -83,6 +118,27 @@ var MetaDataGetCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[MetaDataGetConfig](ctx, c)
 		defer done()
 
+		switch {
+		case cfg.All && cfg.Key != "":
+			return errors.New("a key can't be given with --all")
+		case cfg.All && cfg.Format == "":
+			cfg.Format = "json"
+		case cfg.All && cfg.Format != "json":
+			return errors.New("--all requires --format json")
+		case !cfg.All && cfg.Key == "":
+			return errors.New("a meta-data key is required, unless using --all")
+		case cfg.Format == "":
+			cfg.Format = "text"
+		}
+		if cfg.Format != "text" && cfg.Format != "json" {
+			return fmt.Errorf("invalid --format %q, must be text or json", cfg.Format)
+		}
+
+		var err error
+		if cfg.Scope, err = normaliseMetaDataScope(cfg.Scope); err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
@@ -96,12 +152,16 @@ var MetaDataGetCommand = cli.Command{
 			id = cfg.Build
 		}
 
+		if cfg.All {
+			return getAllMetaData(ctx, l, client, scope, id, cfg.Scope, c.App.Writer)
+		}
+
 		r := roko.NewRetrier(
 			roko.WithMaxAttempts(10),
 			roko.WithStrategy(roko.Constant(5*time.Second)),
 		)
 		metaData, resp, err := roko.DoFunc2(ctx, r, func(r *roko.Retrier) (*api.MetaData, *api.Response, error) {
-			metaData, resp, err := client.GetMetaData(ctx, scope, id, cfg.Key)
+			metaData, resp, err := client.GetMetaData(ctx, scope, id, scopedMetaDataKey(cfg.Scope, cfg.Key))
 			// Don't bother retrying if the response was one of these statuses
 			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
 				r.Break()
@@ -127,15 +187,106 @@ var MetaDataGetCommand = cli.Command{
 					cfg.Key,
 					cfg.Default,
 				)
-				fmt.Fprint(c.App.Writer, cfg.Default)
-				return nil
+				return printMetaData(c.App.Writer, cfg.Format, &api.MetaData{Key: cfg.Key, Value: cfg.Default})
 			}
 
 			return fmt.Errorf("failed to get meta-data: %w", err)
 		}
 
-		// TODO: in the next agent magor version, we should terminate with a newline using fmt.FPrintln
-		_, err = fmt.Fprint(c.App.Writer, metaData.Value)
-		return err
+		metaData.Key = cfg.Key
+		return printMetaData(c.App.Writer, cfg.Format, metaData)
 	},
 }
+
+func getAllMetaData(ctx context.Context, l logger.Logger, client *api.Client, scope, id, keyScope string, w io.Writer) error {
+	metaData, err := getMetaDataValues(ctx, l, client, scope, id, keyScope, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get meta-data: %w", err)
+	}
+
+	return printMetaData(w, "json", metaData...)
+}
+
+// getMetaDataValues gets the value of each meta-data key in keyScope for which
+// keep returns true, or of every key if keep is nil. There's no endpoint for
+// getting several values at once, so the keys are listed, and then each value
+// is fetched with a request of its own. If any value can't be fetched, none are
+// returned.
+func getMetaDataValues(ctx context.Context, l logger.Logger, client *api.Client, scope, id, keyScope string, keep func(key string) bool) ([]*api.MetaData, error) {
+	r := roko.NewRetrier(
+		roko.WithMaxAttempts(10),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	)
+	keys, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) ([]string, error) {
+		keys, resp, err := client.MetaDataKeys(ctx, scope, id)
+		// Don't bother retrying if the response was one of these statuses
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
+			r.Break()
+			return nil, err
+		}
+		if err != nil {
+			l.Warn("%s (%s)", err, r)
+			return nil, err
+		}
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var wanted []string
+	for _, key := range metaDataKeysInScope(keyScope, keys) {
+		if keep == nil || keep(key) {
+			wanted = append(wanted, key)
+		}
+	}
+
+	metaData := make([]*api.MetaData, len(wanted))
+	if err := forEachMetaData(len(wanted), func(i int) error {
+		key := wanted[i]
+		r := roko.NewRetrier(
+			roko.WithMaxAttempts(10),
+			roko.WithStrategy(roko.Constant(5*time.Second)),
+		)
+		m, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) (*api.MetaData, error) {
+			m, resp, err := client.GetMetaData(ctx, scope, id, scopedMetaDataKey(keyScope, key))
+			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404 || resp.StatusCode == 400) {
+				r.Break()
+				return nil, err
+			}
+			if err != nil {
+				l.Warn("%s (%s)", err, r)
+				return nil, err
+			}
+			return m, nil
+		})
+		if err != nil {
+			return fmt.Errorf("getting %q: %w", key, err)
+		}
+		m.Key = key
+		metaData[i] = m
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return metaData, nil
+}
+
+// printMetaData prints a meta-data value in text format, or any number of
+// meta-data keys and values as a JSON object.
+func printMetaData(w io.Writer, format string, metaData ...*api.MetaData) error {
+	if format == "json" {
+		values := make(map[string]string, len(metaData))
+		for _, m := range metaData {
+			values[m.Key] = m.Value
+		}
+		if err := json.NewEncoder(w).Encode(values); err != nil {
+			return fmt.Errorf("error marshalling JSON: %w", err)
+		}
+		return nil
+	}
+
+	// TODO: in the next agent magor version, we should terminate with a newline using fmt.FPrintln
+	_, err := fmt.Fprint(w, metaData[0].Value)
+	return err
+}
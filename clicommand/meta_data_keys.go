@@ -26,6 +26,7 @@ Example:
 type MetaDataKeysConfig struct {
 	Job   string `cli:"job"`
 	Build string `cli:"build"`
+	Scope string `cli:"scope"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -39,6 +40,9 @@ type MetaDataKeysConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var MetaDataKeysCommand = cli.Command{
@@ -58,11 +62,15 @@ var MetaDataKeysCommand = cli.Command{
 			Usage:  "Which build should the meta-data be retrieved from. --build will take precedence over --job",
 			EnvVar: "BUILDKITE_METADATA_BUILD_ID",
 		},
+		metaDataScopeFlag,
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -77,6 +85,11 @@ var MetaDataKeysCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[MetaDataKeysConfig](ctx, c)
 		defer done()
 
+		var err error
+		if cfg.Scope, err = normaliseMetaDataScope(cfg.Scope); err != nil {
+			return err
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
@@ -107,7 +120,7 @@ var MetaDataKeysCommand = cli.Command{
 			return fmt.Errorf("failed to find meta-data keys: %w", err)
 		}
 
-		for _, key := range keys {
+		for _, key := range metaDataKeysInScope(cfg.Scope, keys) {
 			fmt.Fprintf(c.App.Writer, "%s\n", key)
 		}
 
@@ -0,0 +1,65 @@
+package clicommand
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// metaDataStepScopePrefix is the prefix of meta-data scopes that namespace
+// keys to a single step, so that parallel steps can set the same key without
+// overwriting each other.
+const metaDataStepScopePrefix = "step:"
+
+// metaDataBuildScope is the scope of keys that are shared by the whole build.
+const metaDataBuildScope = "build"
+
+var metaDataScopeFlag = cli.StringFlag{
+	Name:   "scope",
+	Value:  "",
+	Usage:  "The namespace of the meta-data key: ′build′ for keys shared by the whole build, or ′step:<step key>′ for keys namespaced to a step. Defaults to the job's own step, for jobs in a step with a key, and otherwise to ′build′. Scoped keys are stored in the build's meta-data as ′<scope>:<key>′, so they're also listed, under that name, among the build's keys",
+	EnvVar: "BUILDKITE_METADATA_STEP_SCOPE",
+}
+
+// normaliseMetaDataScope checks that scope is the build-wide namespace (either
+// empty or "build") or a step scope, and returns it with the build-wide
+// namespace as empty.
+func normaliseMetaDataScope(scope string) (string, error) {
+	if scope == "" || scope == metaDataBuildScope {
+		return "", nil
+	}
+	key, ok := strings.CutPrefix(scope, metaDataStepScopePrefix)
+	if !ok || strings.TrimSpace(key) == "" {
+		return "", fmt.Errorf("invalid --scope %q, must be %s or in the form %s<step key>", scope, metaDataBuildScope, metaDataStepScopePrefix)
+	}
+	return scope, nil
+}
+
+// scopedMetaDataKey returns the build meta-data key that key is stored under
+// in scope.
+//
+// Note: the Buildkite API has no namespaces for meta-data, so a scope is only
+// a prefix of the key. Nothing stops a key without a scope from looking like
+// a scoped one.
+func scopedMetaDataKey(scope, key string) string {
+	if scope == "" {
+		return key
+	}
+	return scope + ":" + key
+}
+
+// metaDataKeysInScope returns those of the build's meta-data keys that are in
+// scope, without the scope's prefix. Every key is in the build-wide scope.
+func metaDataKeysInScope(scope string, keys []string) []string {
+	if scope == "" {
+		return keys
+	}
+	var scoped []string
+	for _, key := range keys {
+		if k, ok := strings.CutPrefix(key, scope+":"); ok && k != "" {
+			scoped = append(scoped, k)
+		}
+	}
+	return scoped
+}
@@ -2,14 +2,17 @@ package clicommand
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/roko"
 	"github.com/urfave/cli"
 )
@@ -17,6 +20,7 @@ import (
 const metaDataSetHelpDescription = `Usage:
 
     buildkite-agent meta-data set <key> [value] [options...]
+    buildkite-agent meta-data set --from-file <path> [options...]
 
 Description:
 
@@ -28,16 +32,29 @@ script output.
 The value must be a non-empty string, and strings containing only whitespace
 characters are not allowed.
 
+To set many keys at once, use --from-file with a JSON file containing an object
+of keys and string values. Each key is set with a request of its own, several
+at a time. If any fail, the rest are still set, and the command fails listing
+the keys that weren't.
+
+Jobs in a step with a key set keys in their step's namespace by default (see
+--scope), so that parallel steps can set the same key without overwriting each
+other. Use --scope build to set a key shared by the whole build.
+
 Example:
 
     $ buildkite-agent meta-data set "foo" "bar"
     $ buildkite-agent meta-data set "foo" < ./tmp/meta-data-value
-    $ ./script/meta-data-generator | buildkite-agent meta-data set "foo"`
+    $ ./script/meta-data-generator | buildkite-agent meta-data set "foo"
+    $ buildkite-agent meta-data set --from-file ./tmp/meta-data.json
+    $ buildkite-agent meta-data set "foo" "bar" --scope build`
 
 type MetaDataSetConfig struct {
-	Key   string `cli:"arg:0" label:"meta-data key" validate:"required"`
-	Value string `cli:"arg:1" label:"meta-data value"`
-	Job   string `cli:"job" validate:"required"`
+	Key      string `cli:"arg:0" label:"meta-data key"`
+	Value    string `cli:"arg:1" label:"meta-data value"`
+	FromFile string `cli:"from-file"`
+	Scope    string `cli:"scope"`
+	Job      string `cli:"job" validate:"required"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -51,6 +68,9 @@ type MetaDataSetConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var MetaDataSetCommand = cli.Command{
@@ -58,17 +78,26 @@ var MetaDataSetCommand = cli.Command{
 	Usage:       "Set data on a build",
 	Description: metaDataSetHelpDescription,
 	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "from-file",
+			Value: "",
+			Usage: "Set every key and value in this JSON file, instead of a single key",
+		},
 		cli.StringFlag{
 			Name:   "job",
 			Value:  "",
 			Usage:  "Which job's build should the meta-data be set on",
 			EnvVar: "BUILDKITE_JOB_ID",
 		},
+		metaDataScopeFlag,
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -83,47 +112,56 @@ var MetaDataSetCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[MetaDataSetConfig](ctx, c)
 		defer done()
 
-		// Read the value from STDIN if argument omitted entirely
-		if len(c.Args()) < 2 {
-			l.Info("Reading meta-data value from STDIN")
+		var err error
+		if cfg.Scope, err = normaliseMetaDataScope(cfg.Scope); err != nil {
+			return err
+		}
+
+		var metaData []*api.MetaData
+		if cfg.FromFile != "" {
+			if cfg.Key != "" {
+				return errors.New("a key can't be given with --from-file")
+			}
 
-			input, err := io.ReadAll(os.Stdin)
+			var err error
+			metaData, err = readMetaDataFile(cfg.FromFile)
 			if err != nil {
-				return fmt.Errorf("failed to read from STDIN: %w", err)
+				return err
+			}
+		} else {
+			if cfg.Key == "" {
+				return errors.New("a meta-data key is required, unless using --from-file")
+			}
+
+			// Read the value from STDIN if argument omitted entirely
+			if len(c.Args()) < 2 {
+				l.Info("Reading meta-data value from STDIN")
+
+				input, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read from STDIN: %w", err)
+				}
+				cfg.Value = string(input)
 			}
-			cfg.Value = string(input)
-		}
 
-		if strings.TrimSpace(cfg.Key) == "" {
-			return errors.New("key cannot be empty, or composed of only whitespace characters")
+			metaData = []*api.MetaData{{Key: cfg.Key, Value: cfg.Value}}
 		}
 
-		if strings.TrimSpace(cfg.Value) == "" {
-			return errors.New("value cannot be empty, or composed of only whitespace characters")
+		for _, m := range metaData {
+			if err := validateMetaData(m); err != nil {
+				return err
+			}
+			m.Key = scopedMetaDataKey(cfg.Scope, m.Key)
 		}
 
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
-		// Create the meta data to set
-		metaData := &api.MetaData{
-			Key:   cfg.Key,
-			Value: cfg.Value,
-		}
-
-		// Set the meta data
-		if err := roko.NewRetrier(
-			// 10x2 sec -> 2, 3, 5, 8, 13, 21, 34, 55, 89 seconds (total delay: 233 seconds)
-			roko.WithMaxAttempts(10),
-			roko.WithStrategy(roko.ExponentialSubsecond(2*time.Second)),
-		).DoWithContext(ctx, func(r *roko.Retrier) error {
-			resp, err := client.SetMetaData(ctx, cfg.Job, metaData)
-			if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404) {
-				r.Break()
-			}
-			if err != nil {
-				l.Warn("%s (%s)", err, r)
-				return err
+		// Set the meta data. There's no endpoint for setting several keys
+		// at once, so each key is set with a request of its own.
+		if err := forEachMetaData(len(metaData), func(i int) error {
+			if err := setMetaData(ctx, l, client, cfg.Job, metaData[i]); err != nil {
+				return fmt.Errorf("%q: %w", metaData[i].Key, err)
 			}
 			return nil
 		}); err != nil {
@@ -133,3 +171,60 @@ var MetaDataSetCommand = cli.Command{
 		return nil
 	},
 }
+
+// setMetaData sets a single meta-data key, retrying on failure.
+func setMetaData(ctx context.Context, l logger.Logger, client *api.Client, jobID string, m *api.MetaData) error {
+	return roko.NewRetrier(
+		// 10x2 sec -> 2, 3, 5, 8, 13, 21, 34, 55, 89 seconds (total delay: 233 seconds)
+		roko.WithMaxAttempts(10),
+		roko.WithStrategy(roko.ExponentialSubsecond(2*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		resp, err := client.SetMetaData(ctx, jobID, m)
+		if resp != nil && (resp.StatusCode == 401 || resp.StatusCode == 404) {
+			r.Break()
+		}
+		if err != nil {
+			l.Warn("%s (%s)", err, r)
+			return err
+		}
+		return nil
+	})
+}
+
+// readMetaDataFile reads meta-data from a JSON object of keys and values,
+// sorted by key.
+func readMetaDataFile(path string) ([]*api.MetaData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read meta-data file: %w", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse meta-data file %s, it must be a JSON object of string values: %w", path, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("meta-data file %s has no keys", path)
+	}
+
+	metaData := make([]*api.MetaData, 0, len(values))
+	for key, value := range values {
+		metaData = append(metaData, &api.MetaData{Key: key, Value: value})
+	}
+	slices.SortFunc(metaData, func(a, b *api.MetaData) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return metaData, nil
+}
+
+func validateMetaData(m *api.MetaData) error {
+	if strings.TrimSpace(m.Key) == "" {
+		return errors.New("key cannot be empty, or composed of only whitespace characters")
+	}
+
+	if strings.TrimSpace(m.Value) == "" {
+		return fmt.Errorf("value for key %q cannot be empty, or composed of only whitespace characters", m.Key)
+	}
+
+	return nil
+}
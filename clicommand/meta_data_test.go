@@ -0,0 +1,177 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadMetaDataFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "meta-data.json")
+	if err := os.WriteFile(path, []byte(`{"release": "v1.2.3", "deploy": "true"}`), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	got, err := readMetaDataFile(path)
+	if err != nil {
+		t.Fatalf("readMetaDataFile(%q) error = %v", path, err)
+	}
+
+	want := []*api.MetaData{
+		{Key: "deploy", Value: "true"},
+		{Key: "release", Value: "v1.2.3"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("readMetaDataFile(%q) diff (-got +want):\n%s", path, diff)
+	}
+}
+
+func TestReadMetaDataFileRejectsNonStrings(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "meta-data.json")
+	if err := os.WriteFile(path, []byte(`{"count": 3}`), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	if _, err := readMetaDataFile(path); err == nil {
+		t.Errorf("readMetaDataFile(%q) error = nil, want an error", path)
+	}
+}
+
+func TestGetAllMetaData(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		values := map[string]string{"deploy": "true", "release": "v1.2.3"}
+		switch req.URL.Path {
+		case "/builds/1/data/keys":
+			rw.Write([]byte(`["deploy", "release"]`)) //nolint:errcheck // test server
+
+		case "/builds/1/data/get":
+			var m api.MetaData
+			if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(rw).Encode(api.MetaData{Key: m.Key, Value: values[m.Key]}) //nolint:errcheck // test server
+
+		default:
+			http.Error(rw, `{"message": "Not Found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "agentaccesstoken",
+	})
+
+	var out strings.Builder
+	if err := getAllMetaData(context.Background(), logger.Discard, client, "build", "1", "", &out); err != nil {
+		t.Fatalf("getAllMetaData(build, 1) error = %v", err)
+	}
+
+	if got, want := out.String(), `{"deploy":"true","release":"v1.2.3"}`+"\n"; got != want {
+		t.Errorf("getAllMetaData(build, 1) output = %q, want %q", got, want)
+	}
+}
+
+func TestPrintMetaDataText(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	if err := printMetaData(&out, "text", &api.MetaData{Key: "release", Value: "v1.2.3"}); err != nil {
+		t.Fatalf("printMetaData(text) error = %v", err)
+	}
+	if got, want := out.String(), "v1.2.3"; got != want {
+		t.Errorf("printMetaData(text) output = %q, want %q", got, want)
+	}
+}
+
+func TestNormaliseMetaDataScope(t *testing.T) {
+	t.Parallel()
+
+	for scope, want := range map[string]string{
+		"":                "",
+		"build":           "",
+		"step:build":      "step:build",
+		"step:test-linux": "step:test-linux",
+	} {
+		got, err := normaliseMetaDataScope(scope)
+		if err != nil {
+			t.Errorf("normaliseMetaDataScope(%q) error = %v", scope, err)
+		}
+		if got != want {
+			t.Errorf("normaliseMetaDataScope(%q) = %q, want %q", scope, got, want)
+		}
+	}
+
+	for _, scope := range []string{"step:", "step: ", "builds", "job:1"} {
+		if _, err := normaliseMetaDataScope(scope); err == nil {
+			t.Errorf("normaliseMetaDataScope(%q) error = nil, want an error", scope)
+		}
+	}
+}
+
+func TestMetaDataKeysInScope(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{"release", "step:test-linux:result", "step:test-mac:result", "step:test-linux:"}
+
+	if diff := cmp.Diff(metaDataKeysInScope("", keys), keys); diff != "" {
+		t.Errorf("metaDataKeysInScope(\"\", keys) diff (-got +want):\n%s", diff)
+	}
+
+	got := metaDataKeysInScope("step:test-linux", keys)
+	if diff := cmp.Diff(got, []string{"result"}); diff != "" {
+		t.Errorf("metaDataKeysInScope(step:test-linux, keys) diff (-got +want):\n%s", diff)
+	}
+
+	if got, want := scopedMetaDataKey("step:test-linux", "result"), "step:test-linux:result"; got != want {
+		t.Errorf("scopedMetaDataKey(step:test-linux, result) = %q, want %q", got, want)
+	}
+}
+
+func TestForEachMetaDataReportsEveryFailure(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		done []int
+	)
+	err := forEachMetaData(20, func(i int) error {
+		mu.Lock()
+		done = append(done, i)
+		mu.Unlock()
+		if i%10 == 3 {
+			return fmt.Errorf("key %d: boom", i)
+		}
+		return nil
+	})
+
+	if got := len(done); got != 20 {
+		t.Errorf("forEachMetaData(20) called fn %d times, want 20", got)
+	}
+	if err == nil {
+		t.Fatalf("forEachMetaData(20) error = nil, want an error")
+	}
+	for _, want := range []string{"2 of 20 keys failed", "key 3: boom", "key 13: boom"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("forEachMetaData(20) error = %q, want it to contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package clicommand
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// There are no endpoints for setting or getting several meta-data keys in one
+// request, so commands that work with many keys make a request per key, this
+// many at a time.
+const metaDataWorkers = 8
+
+// forEachMetaData calls fn with each index up to n, from a bounded pool of
+// workers. Every key is tried, even if others fail, so that a failure reports
+// every key that couldn't be done, not just the first.
+func forEachMetaData(n int, fn func(i int) error) error {
+	indexes := make(chan int)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for range min(n, metaDataWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch {
+	case len(failed) == 0:
+		return nil
+	case n == 1:
+		return failed[0]
+	default:
+		return fmt.Errorf("%d of %d keys failed: %w", len(failed), n, errors.Join(failed...))
+	}
+}
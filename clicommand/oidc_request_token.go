@@ -2,11 +2,18 @@ package clicommand
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/roko"
 	"github.com/urfave/cli"
 )
@@ -16,8 +23,9 @@ type OIDCTokenConfig struct {
 	Lifetime int    `cli:"lifetime"`
 	Job      string `cli:"job"      validate:"required"`
 	// TODO: enumerate possible values, perhaps by adding a link to the documentation
-	Claims         []string `cli:"claim"           normalize:"list"`
-	AWSSessionTags []string `cli:"aws-session-tag" normalize:"list"`
+	Claims         []string      `cli:"claim"           normalize:"list"`
+	AWSSessionTags []string      `cli:"aws-session-tag" normalize:"list"`
+	MinValidity    time.Duration `cli:"min-validity"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -31,6 +39,9 @@ type OIDCTokenConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint"           validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 const (
@@ -51,7 +62,11 @@ Example:
     $ buildkite-agent oidc request-token --audience sts.amazonaws.com
 
 Requests and prints an OIDC token from Buildkite that claims the Job ID
-(amongst other things) and the audience "sts.amazonaws.com".`
+(amongst other things) and the audience "sts.amazonaws.com".
+
+Within a job, tokens are cached by the job executor, and a later request with
+the same options prints the cached token if it is still valid for at least
+′--min-validity′ (5 minutes by default), instead of requesting a new one.`
 )
 
 var OIDCRequestTokenCommand = cli.Command{
@@ -87,10 +102,20 @@ var OIDCRequestTokenCommand = cli.Command{
 			EnvVar: "BUILDKITE_OIDC_TOKEN_AWS_SESSION_TAGS",
 		},
 
+		cli.DurationFlag{
+			Name:   "min-validity",
+			Usage:  "How long a cached token from an earlier request in the same job must remain valid for to be reused, rather than requesting a new token",
+			EnvVar: "BUILDKITE_OIDC_TOKEN_MIN_VALIDITY",
+			Value:  5 * time.Minute,
+		},
+
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -110,6 +135,37 @@ var OIDCRequestTokenCommand = cli.Command{
 			return fmt.Errorf("lifetime %d must be a non-negative integer.", cfg.Lifetime)
 		}
 
+		if cfg.MinValidity < 0 {
+			return fmt.Errorf("min-validity %v must not be negative", cfg.MinValidity)
+		}
+
+		req := &api.OIDCTokenRequest{
+			Job:            cfg.Job,
+			Audience:       cfg.Audience,
+			Lifetime:       cfg.Lifetime,
+			Claims:         cfg.Claims,
+			AWSSessionTags: cfg.AWSSessionTags,
+		}
+
+		// Reuse a token from an earlier identical request in this job, if the
+		// Job API is available and it has one that is valid for long enough
+		cacheKey := oidcTokenCacheKey(req)
+		jobClient, err := jobapi.NewDefaultClient(ctx)
+		if err != nil {
+			l.Debug("Not caching OIDC tokens, as the Job API isn't available: %v", err)
+		}
+		if jobClient != nil {
+			cached, err := jobClient.OIDCTokenGet(ctx, cacheKey, cfg.MinValidity)
+			if err != nil {
+				l.Warn("Couldn't get cached OIDC token from the Job API: %v", err)
+			}
+			if cached != nil {
+				l.Debug("Using cached OIDC token, which expires at %v", cached.ExpiresAt)
+				_, _ = fmt.Fprintln(c.App.Writer, cached.Token)
+				return nil
+			}
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
@@ -119,14 +175,6 @@ var OIDCRequestTokenCommand = cli.Command{
 			roko.WithStrategy(roko.Exponential(backoffSeconds*time.Second, 0)),
 		)
 		token, err := roko.DoFunc(ctx, r, func(r *roko.Retrier) (*api.OIDCToken, error) {
-			req := &api.OIDCTokenRequest{
-				Job:            cfg.Job,
-				Audience:       cfg.Audience,
-				Lifetime:       cfg.Lifetime,
-				Claims:         cfg.Claims,
-				AWSSessionTags: cfg.AWSSessionTags,
-			}
-
 			token, resp, err := client.OIDCToken(ctx, req)
 			if resp != nil {
 				switch resp.StatusCode {
@@ -151,7 +199,46 @@ var OIDCRequestTokenCommand = cli.Command{
 			return err
 		}
 
+		if jobClient != nil {
+			if expiresAt, err := oidcTokenExpiry(token.Token); err != nil {
+				l.Debug("Not caching OIDC token: %v", err)
+			} else if err := jobClient.OIDCTokenPut(ctx, cacheKey, &jobapi.OIDCToken{Token: token.Token, ExpiresAt: expiresAt}); err != nil {
+				l.Warn("Couldn't cache OIDC token in the Job API: %v", err)
+			}
+		}
+
 		_, _ = fmt.Fprintln(c.App.Writer, token.Token)
 		return nil
 	},
 }
+
+// oidcTokenCacheKey returns the key to cache tokens for the request under.
+// Requests with the same key would receive equivalent tokens.
+func oidcTokenCacheKey(req *api.OIDCTokenRequest) string {
+	b, _ := json.Marshal(req) // can't fail: it only contains strings and ints
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// oidcTokenExpiry returns the expiry time of an OIDC token, from the exp claim.
+// The token isn't verified, as it came from the Buildkite API.
+func oidcTokenExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("token isn't a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding token payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("decoding token claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, errors.New("token has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,58 @@
+package clicommand
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+func TestOIDCTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+
+	got, err := oidcTokenExpiry(jwt(`{"aud":"sts.amazonaws.com","exp":1700000000}`))
+	if err != nil {
+		t.Fatalf("oidcTokenExpiry(token) error = %v", err)
+	}
+	if want := time.Unix(1700000000, 0); !got.Equal(want) {
+		t.Errorf("oidcTokenExpiry(token) = %v, want %v", got, want)
+	}
+
+	for _, token := range []string{
+		"not-a-jwt",
+		jwt(`{"aud":"sts.amazonaws.com"}`),
+		jwt(`not json`),
+		"a.!!!.c",
+	} {
+		if _, err := oidcTokenExpiry(token); err == nil {
+			t.Errorf("oidcTokenExpiry(%q) error = nil, want an error", token)
+		}
+	}
+}
+
+func TestOIDCTokenCacheKey(t *testing.T) {
+	t.Parallel()
+
+	req := &api.OIDCTokenRequest{Job: "job-1", Audience: "sts.amazonaws.com", Claims: []string{"organization_id"}}
+	same := &api.OIDCTokenRequest{Job: "job-1", Audience: "sts.amazonaws.com", Claims: []string{"organization_id"}}
+	if got, want := oidcTokenCacheKey(req), oidcTokenCacheKey(same); got != want {
+		t.Errorf("oidcTokenCacheKey(%v) = %q, want it to equal oidcTokenCacheKey(%v) = %q", req, got, same, want)
+	}
+
+	for _, other := range []*api.OIDCTokenRequest{
+		{Job: "job-2", Audience: "sts.amazonaws.com", Claims: []string{"organization_id"}},
+		{Job: "job-1", Audience: "llamas", Claims: []string{"organization_id"}},
+		{Job: "job-1", Audience: "sts.amazonaws.com", Lifetime: 60, Claims: []string{"organization_id"}},
+		{Job: "job-1", Audience: "sts.amazonaws.com"},
+		{Job: "job-1", Audience: "sts.amazonaws.com", Claims: []string{"organization_id"}, AWSSessionTags: []string{"organization_id"}},
+	} {
+		if oidcTokenCacheKey(other) == oidcTokenCacheKey(req) {
+			t.Errorf("oidcTokenCacheKey(%v) == oidcTokenCacheKey(%v), want them to differ", other, req)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/urfave/cli"
+)
+
+const pauseHelpDescription = `Usage:
+
+    buildkite-agent pause [options...]
+
+Description:
+
+Pauses job acceptance by the agents running on this machine. Jobs that are
+already running carry on until they finish, but no agent accepts another job
+until ′buildkite-agent resume′ is run. This is useful for host maintenance
+without restarting agents or losing the current job.
+
+Paused agents stay connected to Buildkite, but don't ask it for work.
+
+Note that this command is only available when the agents have been started
+with the ′agent-api′ experiment enabled. The pause is held by the leader agent,
+so it is lost if the leader agent stops.
+
+Example:
+
+    $ buildkite-agent pause`
+
+type PauseConfig struct {
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+// Flags used by the pause and resume commands.
+var pauseCommonFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "sockets-path",
+		Value:  defaultSocketsPath(),
+		Usage:  "Directory where the agent will place sockets",
+		EnvVar: "BUILDKITE_SOCKETS_PATH",
+	},
+}
+
+var PauseCommand = cli.Command{
+	Name:        "pause",
+	Usage:       "Pauses job acceptance by the agents on this machine",
+	Description: pauseHelpDescription,
+	Flags:       append(globalFlags(), pauseCommonFlags...),
+	Action: func(c *cli.Context) error {
+		ctx, cfg, l, _, done := setupLoggerAndConfig[PauseConfig](context.Background(), c)
+		defer done()
+
+		if err := setPaused(ctx, cfg.SocketsPath, true); err != nil {
+			return err
+		}
+		l.Info("Paused job acceptance. Running jobs will carry on until they finish.")
+		return nil
+	},
+}
+
+// setPaused pauses or resumes job acceptance using the leader agent's API.
+func setPaused(ctx context.Context, socketsPath string, paused bool) error {
+	client, err := agentapi.NewClient(ctx, agentapi.LeaderPath(socketsPath))
+	if err != nil {
+		return fmt.Errorf(lockClientErrMessage, err)
+	}
+	if err := client.SetPaused(ctx, paused); err != nil {
+		return fmt.Errorf("couldn't update pause state: %w", err)
+	}
+	return nil
+}
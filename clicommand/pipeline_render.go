@@ -0,0 +1,127 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/go-pipeline/warning"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+const pipelineRenderHelpDescription = `Usage:
+
+    buildkite-agent pipeline render [file] [options...]
+
+Description:
+
+Reads a pipeline in the same way as 'buildkite-agent pipeline upload', performs
+the same environment variable interpolation (including resolving
+BUILDKITE_COMMIT with git), and prints the normalised pipeline to stdout.
+Nothing is uploaded, so no agent access token is needed.
+
+This is useful for debugging dynamic pipelines locally, or for checking what a
+pipeline will look like in pre-merge CI checks. If no file is given, the
+pipeline is read from STDIN, or from the same default locations used by
+'buildkite-agent pipeline upload'.
+
+Example:
+
+    $ buildkite-agent pipeline render .buildkite/pipeline.yml
+    $ BUILDKITE_BRANCH=main buildkite-agent pipeline render --format json
+    $ ./script/dynamic_step_generator | buildkite-agent pipeline render`
+
+type PipelineRenderConfig struct {
+	FilePath        string `cli:"arg:0" label:"pipeline file"`
+	Format          string `cli:"format"`
+	NoInterpolation bool   `cli:"no-interpolation"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var PipelineRenderCommand = cli.Command{
+	Name:        "render",
+	Usage:       "Prints a pipeline after interpolation, without uploading it",
+	Description: pipelineRenderHelpDescription,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:   "format",
+			Usage:  "The form to output the pipeline in. Must be one of: json,yaml",
+			Value:  "yaml",
+			EnvVar: "BUILDKITE_PIPELINE_RENDER_FORMAT",
+		},
+		cli.BoolFlag{
+			Name:   "no-interpolation",
+			Usage:  "Skip variable interpolation, and only normalise the pipeline",
+			EnvVar: "BUILDKITE_PIPELINE_NO_INTERPOLATION",
+		},
+
+		// Global flags
+		NoColorFlag,
+		DebugFlag,
+		LogLevelFlag,
+		ExperimentsFlag,
+		ProfileFlag,
+	},
+	Action: func(c *cli.Context) error {
+		ctx := context.Background()
+		ctx, cfg, l, _, done := setupLoggerAndConfig[PipelineRenderConfig](ctx, c)
+		defer done()
+
+		var encode func(any) error
+		switch cfg.Format {
+		case "json":
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			encode = enc.Encode
+
+		case "yaml":
+			encode = yaml.NewEncoder(c.App.Writer).Encode
+
+		default:
+			return fmt.Errorf("unknown output format %q", cfg.Format)
+		}
+
+		input, filename, err := openPipelineInput(l, cfg.FilePath)
+		if err != nil {
+			return err
+		}
+		if input != os.Stdin {
+			defer input.Close()
+		}
+
+		environ := env.FromSlice(os.Environ())
+
+		if !cfg.NoInterpolation {
+			// resolve BUILDKITE_COMMIT based on the local git repo, exactly
+			// as pipeline upload would
+			resolveCommit(l, environ)
+		}
+
+		src := filename
+		if src == "" {
+			src = "(stdin)"
+		}
+
+		result, err := parseAndInterpolatePipeline(ctx, src, input, environ, cfg.NoInterpolation)
+		if err != nil {
+			w := warning.As(err)
+			if w == nil {
+				return err
+			}
+			l.Warn("There were some issues with the pipeline input - it might not upload successfully:\n%v", w)
+		}
+
+		// All logging happens to stderr, so the output can be used with
+		// other tools.
+		return encode(result)
+	},
+}
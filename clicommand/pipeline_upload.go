@@ -19,6 +19,7 @@ import (
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/awslib"
+	"github.com/buildkite/agent/v3/internal/bkgql"
 	awssigner "github.com/buildkite/agent/v3/internal/cryptosigner/aws"
 	"github.com/buildkite/agent/v3/internal/experiments"
 	"github.com/buildkite/agent/v3/internal/redact"
@@ -66,7 +67,14 @@ Example:
 
     $ buildkite-agent pipeline upload
     $ buildkite-agent pipeline upload my-custom-pipeline.yml
-    $ ./script/dynamic_step_generator | buildkite-agent pipeline upload`
+    $ ./script/dynamic_step_generator | buildkite-agent pipeline upload
+
+To warn about attributes that aren't recognised, such as a misspelled
+'commnad', use --warn-unknown. To fail the upload instead, such as to check the
+output of a pipeline generator, use --strict. With --strict-format json, the
+problems, if any, are also written to stdout as JSON:
+
+    $ ./script/dynamic_step_generator | buildkite-agent pipeline upload --dry-run --strict --strict-format json`
 
 type PipelineUploadConfig struct {
 	FilePath        string   `cli:"arg:0" label:"upload paths"`
@@ -74,9 +82,14 @@ type PipelineUploadConfig struct {
 	Job             string   `cli:"job"` // required, but not in dry-run mode
 	DryRun          bool     `cli:"dry-run"`
 	DryRunFormat    string   `cli:"format"`
+	DryRunDiff      bool     `cli:"diff"`
+	Build           string   `cli:"build"`
 	NoInterpolation bool     `cli:"no-interpolation"`
 	RedactedVars    []string `cli:"redacted-vars" normalize:"list"`
 	RejectSecrets   bool     `cli:"reject-secrets"`
+	WarnUnknown     bool     `cli:"warn-unknown"`
+	Strict          bool     `cli:"strict"`
+	StrictFormat    string   `cli:"strict-format"`
 
 	// Used for signing
 	JWKSFile         string `cli:"jwks-file"`
@@ -84,6 +97,10 @@ type PipelineUploadConfig struct {
 	SigningAWSKMSKey string `cli:"signing-aws-kms-key"`
 	DebugSigning     bool   `cli:"debug-signing"`
 
+	// Used to fetch the build's steps for --diff
+	GraphQLToken    string `cli:"graphql-token"`
+	GraphQLEndpoint string `cli:"graphql-endpoint"`
+
 	// Global flags
 	Debug       bool     `cli:"debug"`
 	LogLevel    string   `cli:"log-level"`
@@ -96,6 +113,9 @@ type PipelineUploadConfig struct {
 	AgentAccessToken string `cli:"agent-access-token"` // required, but not in dry-run mode
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var PipelineUploadCommand = cli.Command{
@@ -125,6 +145,28 @@ var PipelineUploadCommand = cli.Command{
 			Value:  "json",
 			EnvVar: "BUILDKITE_PIPELINE_UPLOAD_DRY_RUN_FORMAT",
 		},
+		cli.BoolFlag{
+			Name:   "diff",
+			Usage:  "In dry-run mode, compare the pipeline with the steps already in the build, and print the steps that would be added, removed, or changed instead of the pipeline. Requires ′graphql-token′",
+			EnvVar: "BUILDKITE_PIPELINE_UPLOAD_DRY_RUN_DIFF",
+		},
+		cli.StringFlag{
+			Name:   "build",
+			Value:  "",
+			Usage:  "The UUID of the build the pipeline is uploaded to. Used to find variables set with ′build set-env′, and to compare the pipeline with in dry-run diff mode",
+			EnvVar: "BUILDKITE_BUILD_ID",
+		},
+		cli.StringFlag{
+			Name:   "graphql-token",
+			Usage:  "A token for the Buildkite GraphQL API, used in dry-run diff mode to fetch the steps already in the build",
+			EnvVar: "BUILDKITE_GRAPHQL_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "graphql-endpoint",
+			Usage:  "The endpoint for the Buildkite GraphQL API. This is mostly useful for development purposes",
+			Value:  bkgql.DefaultEndpoint,
+			EnvVar: "BUILDKITE_GRAPHQL_ENDPOINT",
+		},
 		cli.BoolFlag{
 			Name:   "no-interpolation",
 			Usage:  "Skip variable interpolation into the pipeline prior to upload",
@@ -135,6 +177,22 @@ var PipelineUploadCommand = cli.Command{
 			Usage:  "When true, fail the pipeline upload early if the pipeline contains secrets",
 			EnvVar: "BUILDKITE_AGENT_PIPELINE_UPLOAD_REJECT_SECRETS",
 		},
+		cli.BoolFlag{
+			Name:   "warn-unknown",
+			Usage:  "Warn about attributes in the pipeline that aren't recognised, such as misspelled step attributes, or steps whose type can't be told",
+			EnvVar: "BUILDKITE_PIPELINE_UPLOAD_WARN_UNKNOWN",
+		},
+		cli.BoolFlag{
+			Name:   "strict",
+			Usage:  "Fail the pipeline upload if the pipeline has attributes that aren't recognised, such as misspelled step attributes, or steps whose type can't be told",
+			EnvVar: "BUILDKITE_PIPELINE_UPLOAD_STRICT",
+		},
+		cli.StringFlag{
+			Name:   "strict-format",
+			Usage:  "In strict mode, specifies how to report problems with the pipeline. With json, they are also written to stdout as JSON, even if there aren't any. Must be one of: text,json",
+			Value:  "text",
+			EnvVar: "BUILDKITE_PIPELINE_UPLOAD_STRICT_FORMAT",
+		},
 
 		// Note: changes to these environment variables need to be reflected in the environment created
 		// in the job runner. At the momenet, that's at agent/job_runner.go:500-507
@@ -163,6 +221,9 @@ var PipelineUploadCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -178,83 +239,17 @@ var PipelineUploadCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[PipelineUploadConfig](ctx, c)
 		defer done()
 
-		// Find the pipeline either from STDIN or the first argument
-		var input *os.File
-		var filename string
-
-		switch {
-		case cfg.FilePath != "":
-			l.Info("Reading pipeline config from %q", cfg.FilePath)
-
-			filename = filepath.Base(cfg.FilePath)
-			file, err := os.Open(cfg.FilePath)
-			if err != nil {
-				return fmt.Errorf("failed to read file: %w", err)
-			}
-			defer file.Close()
-			input = file
-
-		case stdin.IsReadable():
-			l.Info("Reading pipeline config from STDIN")
-
-			// Actually read the file from STDIN
-			input = os.Stdin
-
-		default:
-			l.Info("Searching for pipeline config...")
-
-			paths := []string{
-				"buildkite.yml",
-				"buildkite.yaml",
-				"buildkite.json",
-				filepath.FromSlash(".buildkite/pipeline.yml"),
-				filepath.FromSlash(".buildkite/pipeline.yaml"),
-				filepath.FromSlash(".buildkite/pipeline.json"),
-				filepath.FromSlash("buildkite/pipeline.yml"),
-				filepath.FromSlash("buildkite/pipeline.yaml"),
-				filepath.FromSlash("buildkite/pipeline.json"),
-			}
-
-			// Collect all the files that exist
-			exists := []string{}
-			for _, path := range paths {
-				if _, err := os.Stat(path); err == nil {
-					exists = append(exists, path)
-				}
-			}
-
-			// If more than 1 of the config files exist, throw an
-			// error. There can only be one!!
-			if len(exists) > 1 {
-				return fmt.Errorf("found multiple configuration files: %s. Please only have 1 configuration file present.", strings.Join(exists, ", "))
-			}
-			if len(exists) == 0 {
-				return fmt.Errorf("could not find a default pipeline configuration file. See `buildkite-agent pipeline upload --help` for more information.")
-			}
-
-			found := exists[0]
-
-			l.Info("Found config file %q", found)
-
-			// Read the default file
-			filename = path.Base(found)
-			file, err := os.Open(found)
-			if err != nil {
-				return fmt.Errorf("failed to read file %q: %w", found, err)
-			}
-			defer file.Close()
-			input = file
+		if cfg.StrictFormat != "text" && cfg.StrictFormat != "json" {
+			return fmt.Errorf("unknown strict-format %q", cfg.StrictFormat)
 		}
 
-		// Make sure the file actually has something in it
+		// Find the pipeline either from STDIN or the first argument
+		input, filename, err := openPipelineInput(l, cfg.FilePath)
+		if err != nil {
+			return err
+		}
 		if input != os.Stdin {
-			fi, err := input.Stat()
-			if err != nil {
-				return fmt.Errorf("couldn't stat pipeline configuration file %q: %w", input.Name(), err)
-			}
-			if fi.Size() == 0 {
-				return fmt.Errorf("pipeline file %q is empty", input.Name())
-			}
+			defer input.Close()
 		}
 
 		environ := env.FromSlice(os.Environ())
@@ -278,6 +273,50 @@ var PipelineUploadCommand = cli.Command{
 			l.Warn("There were some issues with the pipeline input - pipeline upload will proceed, but might not succeed:\n%v", w)
 		}
 
+		if cfg.Strict || cfg.WarnUnknown {
+			problems := checkPipelineAttributes(result)
+			if cfg.Strict && cfg.StrictFormat == "json" {
+				if err := writePipelineProblems(c.App.Writer, problems); err != nil {
+					return err
+				}
+			}
+
+			switch {
+			case len(problems) == 0:
+				// Nothing to report
+
+			case cfg.Strict:
+				for _, p := range problems {
+					l.Error("%s", p)
+				}
+				return fmt.Errorf("pipeline %q has %d unrecognised attributes or steps, and can't be uploaded in strict mode", src, len(problems))
+
+			default:
+				for _, p := range problems {
+					l.Warn("%s", p)
+				}
+				l.Warn("Pipeline %q has attributes or steps that aren't recognised, and may be ignored. To fail the upload instead, use --strict", src)
+			}
+		}
+
+		// Add the variables set with build set-env before searching for
+		// secrets and signing, so that they're checked and signed along with
+		// the rest of the env. Values are only fetched for the keys that
+		// build set-env made, and since steps may depend on them, the upload
+		// fails if they can't be.
+		if cfg.Build != "" && cfg.AgentAccessToken != "" {
+			client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+			metaData, err := getMetaDataValues(ctx, l, client, "build", cfg.Build, "", func(key string) bool {
+				return strings.HasPrefix(key, buildEnvMetaDataKeyPrefix)
+			})
+			if err != nil {
+				return fmt.Errorf("couldn't fetch variables set with build set-env: %w", err)
+			}
+			if added := addBuildEnv(result, metaData); len(added) > 0 {
+				l.Info("Adding variables set with build set-env to the pipeline env: %s", strings.Join(added, ", "))
+			}
+		}
+
 		if len(cfg.RedactedVars) > 0 {
 			// Secret detection uses the original environment, since
 			// Interpolate merges the pipeline's env block into `environ`.
@@ -324,6 +363,28 @@ var PipelineUploadCommand = cli.Command{
 			}
 		}
 
+		// In dry-run diff mode, compare the generated pipeline with the
+		// build instead.
+		if cfg.DryRun && cfg.DryRunDiff {
+			if cfg.GraphQLToken == "" {
+				return errors.New("missing graphql-token parameter, which is needed to fetch the steps already in the build")
+			}
+			if cfg.Build == "" {
+				return errors.New("missing build parameter. Usually this is set in the environment for a Buildkite job via BUILDKITE_BUILD_ID.")
+			}
+
+			l.Info("Comparing the pipeline with the steps in build %q", cfg.Build)
+			client := bkgql.NewClient(cfg.GraphQLEndpoint, cfg.GraphQLToken)
+			buildSteps, err := buildDiffSteps(ctx, client, cfg.Build)
+			if err != nil {
+				return err
+			}
+			if !cfg.Replace {
+				l.Info("Without --replace, steps are added after the current job, and steps already in the build are kept")
+			}
+			return diffSteps(buildSteps, uploadDiffSteps(result.Steps)).write(c.App.Writer)
+		}
+
 		// In dry-run mode we just output the generated pipeline to stdout.
 		if cfg.DryRun {
 			var encode func(any) error
@@ -381,6 +442,92 @@ var PipelineUploadCommand = cli.Command{
 	},
 }
 
+// openPipelineInput finds the pipeline to read: either the file at filePath,
+// STDIN, or one of the default pipeline files. It returns the open file and
+// its base name (empty for STDIN). If the returned file isn't os.Stdin, the
+// caller should close it.
+func openPipelineInput(l logger.Logger, filePath string) (*os.File, string, error) {
+	var input *os.File
+	var filename string
+
+	switch {
+	case filePath != "":
+		l.Info("Reading pipeline config from %q", filePath)
+
+		filename = filepath.Base(filePath)
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read file: %w", err)
+		}
+		input = file
+
+	case stdin.IsReadable():
+		l.Info("Reading pipeline config from STDIN")
+
+		// Actually read the file from STDIN
+		input = os.Stdin
+
+	default:
+		l.Info("Searching for pipeline config...")
+
+		paths := []string{
+			"buildkite.yml",
+			"buildkite.yaml",
+			"buildkite.json",
+			filepath.FromSlash(".buildkite/pipeline.yml"),
+			filepath.FromSlash(".buildkite/pipeline.yaml"),
+			filepath.FromSlash(".buildkite/pipeline.json"),
+			filepath.FromSlash("buildkite/pipeline.yml"),
+			filepath.FromSlash("buildkite/pipeline.yaml"),
+			filepath.FromSlash("buildkite/pipeline.json"),
+		}
+
+		// Collect all the files that exist
+		exists := []string{}
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				exists = append(exists, path)
+			}
+		}
+
+		// If more than 1 of the config files exist, throw an
+		// error. There can only be one!!
+		if len(exists) > 1 {
+			return nil, "", fmt.Errorf("found multiple configuration files: %s. Please only have 1 configuration file present.", strings.Join(exists, ", "))
+		}
+		if len(exists) == 0 {
+			return nil, "", fmt.Errorf("could not find a default pipeline configuration file. See `buildkite-agent pipeline upload --help` for more information.")
+		}
+
+		found := exists[0]
+
+		l.Info("Found config file %q", found)
+
+		// Read the default file
+		filename = path.Base(found)
+		file, err := os.Open(found)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read file %q: %w", found, err)
+		}
+		input = file
+	}
+
+	// Make sure the file actually has something in it
+	if input != os.Stdin {
+		fi, err := input.Stat()
+		if err != nil {
+			input.Close()
+			return nil, "", fmt.Errorf("couldn't stat pipeline configuration file %q: %w", input.Name(), err)
+		}
+		if fi.Size() == 0 {
+			input.Close()
+			return nil, "", fmt.Errorf("pipeline file %q is empty", input.Name())
+		}
+	}
+
+	return input, filename, nil
+}
+
 // resolveCommit resolves and replaces BUILDKITE_COMMIT with the resolved value.
 func resolveCommit(l logger.Logger, environ *env.Environment) {
 	commitRef, has := environ.Get("BUILDKITE_COMMIT")
@@ -544,11 +691,18 @@ func searchForSecrets(
 }
 
 func (cfg *PipelineUploadConfig) parseAndInterpolate(ctx context.Context, src string, input io.Reader, environ *env.Environment) (*pipeline.Pipeline, error) {
+	return parseAndInterpolatePipeline(ctx, src, input, environ, cfg.NoInterpolation)
+}
+
+// parseAndInterpolatePipeline parses the pipeline, then interpolates it with
+// the environment (unless noInterpolation is set) in the same way for every
+// command that processes pipelines.
+func parseAndInterpolatePipeline(ctx context.Context, src string, input io.Reader, environ *env.Environment, noInterpolation bool) (*pipeline.Pipeline, error) {
 	result, err := pipeline.Parse(input)
 	if err != nil && !warning.Is(err) {
 		return nil, fmt.Errorf("pipeline parsing of %q failed: %w", src, err)
 	}
-	if cfg.NoInterpolation {
+	if noInterpolation {
 		// Note that err may be nil or a non-nil warning from pipeline.Parse
 		return result, err
 	}
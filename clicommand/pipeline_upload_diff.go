@@ -0,0 +1,232 @@
+package clicommand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/buildkite/agent/v3/internal/bkgql"
+	"github.com/buildkite/go-pipeline"
+)
+
+// diffStep is the part of a step that pipeline upload --dry-run --diff
+// compares between the build and the upload.
+type diffStep struct {
+	Type    string // "command", "wait", "block", or "trigger"
+	Key     string
+	Label   string
+	Command string
+
+	// id matches a step in the build with one in the upload: its key if it
+	// has one, otherwise its type, label, and how many steps of the same
+	// type and label came before it.
+	id string
+}
+
+func (s diffStep) String() string {
+	str := s.Type
+	if s.Label != "" {
+		str += " " + strconv.Quote(s.Label)
+	}
+	if s.Key != "" {
+		str += " (key: " + s.Key + ")"
+	}
+	return str
+}
+
+// diffStepChange is a step that is in both the build and the upload, but
+// differs between them.
+type diffStepChange struct {
+	Build, Upload diffStep
+}
+
+// pipelineDiff describes how the steps of an upload differ from those already
+// in the build.
+type pipelineDiff struct {
+	Added     []diffStep
+	Removed   []diffStep
+	Changed   []diffStepChange
+	Unchanged int
+}
+
+// diffSteps compares the steps already in the build with the uploaded steps.
+func diffSteps(build, upload []diffStep) pipelineDiff {
+	assignDiffIDs(build)
+	assignDiffIDs(upload)
+
+	uploaded := make(map[string]diffStep, len(upload))
+	for _, s := range upload {
+		uploaded[s.id] = s
+	}
+	inBuild := make(map[string]bool, len(build))
+
+	var d pipelineDiff
+	for _, b := range build {
+		inBuild[b.id] = true
+		u, ok := uploaded[b.id]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, b)
+		case b.Label != u.Label || b.Command != u.Command:
+			d.Changed = append(d.Changed, diffStepChange{Build: b, Upload: u})
+		default:
+			d.Unchanged++
+		}
+	}
+	for _, u := range upload {
+		if !inBuild[u.id] {
+			d.Added = append(d.Added, u)
+		}
+	}
+	return d
+}
+
+// assignDiffIDs sets the id of each step.
+func assignDiffIDs(steps []diffStep) {
+	seen := make(map[string]int)
+	for i, s := range steps {
+		if s.Key != "" {
+			steps[i].id = "key:" + s.Key
+			continue
+		}
+		id := s.Type + ":" + s.Label
+		seen[id]++
+		steps[i].id = id + "#" + strconv.Itoa(seen[id])
+	}
+}
+
+// write prints the diff for a person to read.
+func (d pipelineDiff) write(w io.Writer) error {
+	for _, s := range d.Added {
+		if _, err := fmt.Fprintf(w, "+ %s\n", s); err != nil {
+			return err
+		}
+	}
+	for _, s := range d.Removed {
+		if _, err := fmt.Fprintf(w, "- %s\n", s); err != nil {
+			return err
+		}
+	}
+	for _, c := range d.Changed {
+		if _, err := fmt.Fprintf(w, "~ %s\n", c.Upload); err != nil {
+			return err
+		}
+		if c.Build.Label != c.Upload.Label {
+			if _, err := fmt.Fprintf(w, "    label: %q => %q\n", c.Build.Label, c.Upload.Label); err != nil {
+				return err
+			}
+		}
+		if c.Build.Command != c.Upload.Command {
+			if _, err := fmt.Fprintf(w, "    command: %q => %q\n", c.Build.Command, c.Upload.Command); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d added, %d removed, %d changed, %d unchanged\n", len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged)
+	return err
+}
+
+// uploadDiffSteps flattens the uploaded steps into diffSteps. Group steps
+// don't become jobs, so they are replaced by the steps within them.
+func uploadDiffSteps(steps pipeline.Steps) []diffStep {
+	var out []diffStep
+	for _, step := range steps {
+		switch s := step.(type) {
+		case *pipeline.CommandStep:
+			out = append(out, diffStep{Type: "command", Key: s.Key, Label: s.Label, Command: s.Command})
+
+		case *pipeline.WaitStep:
+			out = append(out, diffStep{Type: "wait", Key: contentsKey(s.Contents), Label: contentsString(s.Contents, "label", "name")})
+
+		case *pipeline.InputStep:
+			out = append(out, diffStep{Type: "block", Key: contentsKey(s.Contents), Label: contentsString(s.Contents, "block", "input", "label", "name")})
+
+		case *pipeline.TriggerStep:
+			out = append(out, diffStep{Type: "trigger", Key: contentsKey(s.Contents), Label: contentsString(s.Contents, "label", "name")})
+
+		case *pipeline.GroupStep:
+			out = append(out, uploadDiffSteps(s.Steps)...)
+		}
+	}
+	return out
+}
+
+// contentsKey returns the key of a step that is only modelled as a map.
+func contentsKey(contents map[string]any) string {
+	return contentsString(contents, "key", "id", "identifier")
+}
+
+// contentsString returns the first of the names that is a string in contents.
+func contentsString(contents map[string]any, names ...string) string {
+	for _, name := range names {
+		if s, ok := contents[name].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// buildDiffSteps fetches the steps already in the build from the GraphQL API.
+// Each step is listed once, however many jobs it has.
+func buildDiffSteps(ctx context.Context, client graphql.Client, buildUUID string) ([]diffStep, error) {
+	var (
+		steps []diffStep
+		seen  = make(map[string]bool)
+		after string
+	)
+	for {
+		resp, err := bkgql.GetBuildJobs(ctx, client, buildUUID, after)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch the jobs of build %q: %w", buildUUID, err)
+		}
+
+		for _, edge := range resp.Build.Jobs.Edges {
+			var (
+				step     diffStep
+				stepUUID string
+				retried  bool
+			)
+			switch n := edge.Node.(type) {
+			case *bkgql.GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand:
+				step = diffStep{Type: "command", Key: n.Step.Key, Label: n.Label, Command: n.Command}
+				stepUUID, retried = n.Step.Uuid, n.Retried
+
+			case *bkgql.GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait:
+				step = diffStep{Type: "wait", Key: n.Step.Key, Label: n.Label}
+				stepUUID, retried = n.Step.Uuid, n.Retried
+
+			case *bkgql.GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock:
+				step = diffStep{Type: "block", Key: n.Step.Key, Label: n.Label}
+				stepUUID, retried = n.Step.Uuid, n.Retried
+
+			case *bkgql.GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger:
+				step = diffStep{Type: "trigger", Key: n.Step.Key, Label: n.Label}
+				stepUUID, retried = n.Step.Uuid, n.Retried
+
+			default:
+				continue
+			}
+
+			// Retried jobs, and parallel and matrix jobs, share their step
+			// with other jobs.
+			if retried || seen[stepUUID] {
+				continue
+			}
+			seen[stepUUID] = true
+			steps = append(steps, step)
+		}
+
+		pageInfo := resp.Build.Jobs.PageInfo
+		if !pageInfo.HasNextPage {
+			break
+		}
+		after = pageInfo.EndCursor
+	}
+
+	// Jobs are listed most recently created first.
+	slices.Reverse(steps)
+	return steps, nil
+}
@@ -0,0 +1,118 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/bkgql"
+	"github.com/buildkite/go-pipeline"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSteps(t *testing.T) {
+	t.Parallel()
+
+	p, err := pipeline.Parse(strings.NewReader(`
+steps:
+  - key: lint
+    label: Lint
+    command: golangci-lint run
+  - wait
+  - group: Tests
+    steps:
+      - key: test
+        command: go test ./...
+  - block: Deploy?
+`))
+	if err != nil {
+		t.Fatalf("pipeline.Parse() error = %v", err)
+	}
+
+	build := []diffStep{
+		{Type: "command", Key: "upload", Label: "Upload", Command: "buildkite-agent pipeline upload"},
+		{Type: "command", Key: "lint", Label: "Lint", Command: "make lint"},
+		{Type: "wait"},
+		{Type: "block", Label: "Deploy?"},
+	}
+
+	var b strings.Builder
+	if err := diffSteps(build, uploadDiffSteps(p.Steps)).write(&b); err != nil {
+		t.Fatalf("pipelineDiff.write() error = %v", err)
+	}
+
+	want := `+ command (key: test)
+- command "Upload" (key: upload)
+~ command "Lint" (key: lint)
+    command: "make lint" => "golangci-lint run"
+1 added, 1 removed, 1 changed, 2 unchanged
+`
+	if diff := cmp.Diff(b.String(), want); diff != "" {
+		t.Errorf("diff output (-got +want):\n%s", diff)
+	}
+}
+
+func TestBuildDiffSteps(t *testing.T) {
+	t.Parallel()
+
+	pages := []string{
+		`{"data": {"build": {"jobs": {
+			"pageInfo": {"hasNextPage": true, "endCursor": "page2"},
+			"edges": [
+				{"node": {"__typename": "JobTypeCommand", "label": "Test", "command": "go test", "retried": false, "step": {"uuid": "s3", "key": "test"}}},
+				{"node": {"__typename": "JobTypeCommand", "label": "Test", "command": "go test", "retried": false, "step": {"uuid": "s3", "key": "test"}}},
+				{"node": {"__typename": "JobTypeWait", "label": null, "retried": false, "step": {"uuid": "s2", "key": null}}}
+			]
+		}}}}`,
+		`{"data": {"build": {"jobs": {
+			"pageInfo": {"hasNextPage": false, "endCursor": "page3"},
+			"edges": [
+				{"node": {"__typename": "JobTypeCommand", "label": "Upload", "command": "buildkite-agent pipeline upload", "retried": false, "step": {"uuid": "s1", "key": null}}},
+				{"node": {"__typename": "JobTypeCommand", "label": "Upload", "command": "buildkite-agent pipeline upload", "retried": true, "step": {"uuid": "s1", "key": null}}}
+			]
+		}}}}`,
+	}
+
+	var afters []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Variables struct {
+				UUID  string `json:"uuid"`
+				After string `json:"after"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if body.Variables.UUID != "build-uuid" {
+			t.Errorf("uuid = %q, want %q", body.Variables.UUID, "build-uuid")
+		}
+		afters = append(afters, body.Variables.After)
+		page := pages[0]
+		if body.Variables.After == "page2" {
+			page = pages[1]
+		}
+		_, _ = rw.Write([]byte(page))
+	}))
+	t.Cleanup(server.Close)
+
+	got, err := buildDiffSteps(context.Background(), bkgql.NewClient(server.URL, "token"), "build-uuid")
+	if err != nil {
+		t.Fatalf("buildDiffSteps() error = %v", err)
+	}
+
+	want := []diffStep{
+		{Type: "command", Label: "Upload", Command: "buildkite-agent pipeline upload"},
+		{Type: "wait"},
+		{Type: "command", Key: "test", Label: "Test", Command: "go test"},
+	}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(diffStep{})); diff != "" {
+		t.Errorf("buildDiffSteps() diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(afters, []string{"", "page2"}); diff != "" {
+		t.Errorf("after cursors diff (-got +want):\n%s", diff)
+	}
+}
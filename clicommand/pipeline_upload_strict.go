@@ -0,0 +1,260 @@
+package clicommand
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/buildkite/go-pipeline"
+	"github.com/buildkite/go-pipeline/ordered"
+)
+
+// The attributes of the pipeline, and of each type of step, that pipeline
+// upload recognises. Anything else is most likely misspelled, and would be
+// ignored by Buildkite.
+//
+// These are the properties of the pipeline and its steps in the Buildkite
+// pipeline schema (https://github.com/buildkite/pipeline-schema), along with
+// the aliases that go-pipeline accepts, such as "id" and "identifier" for
+// "key". They need to be kept in step with the schema, since an attribute
+// added there is reported here until it is added.
+var (
+	pipelineAttributes = []string{
+		"agents", "env", "image", "notify", "secrets", "steps",
+	}
+
+	commandStepAttributes = []string{
+		"agents", "allow_dependency_failure", "artifact_paths", "branches",
+		"cache", "cancel_on_build_failing", "command", "commands", "concurrency",
+		"concurrency_group", "concurrency_method", "depends_on", "env", "id",
+		"identifier", "if", "if_changed", "image", "key", "label", "matrix",
+		"name", "notify", "parallelism", "plugins", "priority", "retry",
+		"secrets", "signature", "skip", "soft_fail", "timeout_in_minutes", "type",
+	}
+
+	waitStepAttributes = []string{
+		"allow_dependency_failure", "branches", "continue_on_failure",
+		"depends_on", "id", "identifier", "if", "key", "label", "name", "type",
+		"wait", "waiter",
+	}
+
+	// Block and input steps are both input steps to go-pipeline.
+	inputStepAttributes = []string{
+		"allow_dependency_failure", "allowed_teams", "block", "blocked_state",
+		"branches", "depends_on", "fields", "id", "identifier", "if", "input",
+		"key", "label", "manual", "name", "prompt", "type",
+	}
+
+	triggerStepAttributes = []string{
+		"allow_dependency_failure", "async", "branches", "build", "depends_on",
+		"id", "identifier", "if", "if_changed", "key", "label", "name", "skip",
+		"soft_fail", "trigger", "type",
+	}
+
+	groupStepAttributes = []string{
+		"allow_dependency_failure", "depends_on", "group", "id", "identifier",
+		"if", "if_changed", "key", "label", "name", "notify", "steps", "type",
+	}
+)
+
+// pipelineProblem is something in the pipeline that pipeline upload doesn't
+// recognise. It's reported as JSON with --strict-format=json.
+type pipelineProblem struct {
+	// Path is where the problem is, such as steps[2].steps[0] for the first
+	// step in the group that is the third step. It's empty for the
+	// pipeline's top level.
+	Path string `json:"path"`
+
+	// Step is the step's key or label, if it has one.
+	Step string `json:"step,omitempty"`
+
+	// Attribute is the unknown attribute, if the problem is with one.
+	Attribute string `json:"attribute,omitempty"`
+
+	// Suggestion is a known attribute that Attribute may be a misspelling of.
+	Suggestion string `json:"suggestion,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (p pipelineProblem) String() string {
+	where := "the pipeline"
+	if p.Path != "" {
+		where = p.Path
+		if p.Step != "" {
+			where += " (" + strconv.Quote(p.Step) + ")"
+		}
+	}
+	return where + ": " + p.Message
+}
+
+// checkPipelineAttributes returns the attributes in the pipeline, and in each
+// of its steps, that aren't recognised, and the steps whose type can't be
+// told.
+func checkPipelineAttributes(p *pipeline.Pipeline) []pipelineProblem {
+	var problems []pipelineProblem
+	problems = append(problems, unknownAttributes("", "", p.RemainingFields, pipelineAttributes)...)
+	return append(problems, checkStepAttributes("steps", p.Steps)...)
+}
+
+// checkStepAttributes checks the attributes of steps, and of the steps in any
+// groups among them. prefix is the path to steps.
+func checkStepAttributes(prefix string, steps pipeline.Steps) []pipelineProblem {
+	var problems []pipelineProblem
+	for i, step := range steps {
+		path := fmt.Sprintf("%s[%d]", prefix, i)
+
+		switch s := step.(type) {
+		case *pipeline.CommandStep:
+			name := s.Key
+			if name == "" {
+				name = s.Label
+			}
+			problems = append(problems, unknownAttributes(path, name, s.RemainingFields, commandStepAttributes)...)
+
+		case *pipeline.WaitStep:
+			problems = append(problems, unknownAttributes(path, stepName(s.Contents), s.Contents, waitStepAttributes)...)
+
+		case *pipeline.InputStep:
+			problems = append(problems, unknownAttributes(path, stepName(s.Contents), s.Contents, inputStepAttributes)...)
+
+		case *pipeline.TriggerStep:
+			problems = append(problems, unknownAttributes(path, stepName(s.Contents), s.Contents, triggerStepAttributes)...)
+
+		case *pipeline.GroupStep:
+			name := s.Key
+			if name == "" && s.Group != nil {
+				name = *s.Group
+			}
+			problems = append(problems, unknownAttributes(path, name, s.RemainingFields, groupStepAttributes)...)
+			problems = append(problems, checkStepAttributes(path+".steps", s.Steps)...)
+
+		case *pipeline.UnknownStep:
+			problems = append(problems, checkUnknownStep(path, s)...)
+		}
+	}
+	return problems
+}
+
+// checkUnknownStep reports a step whose type couldn't be told, along with any
+// of its attributes that no type of step has, since one of those is likely to
+// be the misspelling that made its type unknowable.
+func checkUnknownStep(path string, s *pipeline.UnknownStep) []pipelineProblem {
+	var contents map[string]any
+	switch c := s.Contents.(type) {
+	case *ordered.MapSA:
+		contents = c.ToMap()
+	case map[string]any:
+		contents = c
+	default:
+		return []pipelineProblem{{
+			Path:    path,
+			Message: fmt.Sprintf("unknown type of step %v", c),
+		}}
+	}
+
+	var all []string
+	for _, attrs := range [][]string{commandStepAttributes, waitStepAttributes, inputStepAttributes, triggerStepAttributes, groupStepAttributes} {
+		all = append(all, attrs...)
+	}
+
+	name := stepName(contents)
+	problems := []pipelineProblem{{
+		Path:    path,
+		Step:    name,
+		Message: "can't tell what type of step this is",
+	}}
+	return append(problems, unknownAttributes(path, name, contents, all)...)
+}
+
+// unknownAttributes returns a problem for each key of fields that isn't in
+// known, sorted by attribute.
+func unknownAttributes(path, step string, fields map[string]any, known []string) []pipelineProblem {
+	var problems []pipelineProblem
+	for attr := range fields {
+		if slices.Contains(known, attr) {
+			continue
+		}
+		p := pipelineProblem{
+			Path:       path,
+			Step:       step,
+			Attribute:  attr,
+			Suggestion: suggestAttribute(attr, known),
+			Message:    fmt.Sprintf("unknown attribute %q", attr),
+		}
+		if p.Suggestion != "" {
+			p.Message += fmt.Sprintf(", did you mean %q?", p.Suggestion)
+		}
+		problems = append(problems, p)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Attribute < problems[j].Attribute
+	})
+	return problems
+}
+
+// stepName returns the key or label of a step that's only known as a map.
+func stepName(contents map[string]any) string {
+	for _, attr := range []string{"key", "id", "identifier", "label", "name", "block", "input", "trigger"} {
+		if s, ok := contents[attr].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// suggestAttribute returns the known attribute closest to attr, if it's close
+// enough that attr is likely to be a misspelling of it.
+func suggestAttribute(attr string, known []string) string {
+	best, bestDist := "", min(2, len(attr)/3)+1
+	for _, k := range known {
+		if d := editDistance(attr, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent bytes needed to turn a into b.
+func editDistance(a, b string) int {
+	// prev2, prev and cur are rows of the distances between prefixes of a
+	// and b.
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// writePipelineProblems writes the problems to w as JSON, for tools that
+// check the output of pipeline generators.
+func writePipelineProblems(w io.Writer, problems []pipelineProblem) error {
+	if problems == nil {
+		problems = []pipelineProblem{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Problems []pipelineProblem `json:"problems"`
+	}{problems})
+}
@@ -0,0 +1,144 @@
+package clicommand
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buildkite/go-pipeline"
+	"github.com/buildkite/go-pipeline/warning"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckPipelineAttributes(t *testing.T) {
+	t.Parallel()
+
+	p, err := pipeline.Parse(strings.NewReader(`
+agnets:
+  queue: default
+steps:
+  - key: lint
+    command: golangci-lint run
+    timeout_in_minute: 10
+  - label: Test
+    commnad: go test ./...
+  - wait: ~
+    continue_on_failure: true
+  - group: Deploy
+    if_changed: deploy/**
+    steps:
+      - block: Deploy?
+        promt: Are you sure?
+        allowed_teams: [deployers]
+      - trigger: deploy
+        asynch: true
+        if_changed: deploy/**
+`))
+	if err != nil && !warning.Is(err) {
+		t.Fatalf("pipeline.Parse() error = %v", err)
+	}
+
+	want := []pipelineProblem{
+		{
+			Attribute:  "agnets",
+			Suggestion: "agents",
+			Message:    `unknown attribute "agnets", did you mean "agents"?`,
+		},
+		{
+			Path:       "steps[0]",
+			Step:       "lint",
+			Attribute:  "timeout_in_minute",
+			Suggestion: "timeout_in_minutes",
+			Message:    `unknown attribute "timeout_in_minute", did you mean "timeout_in_minutes"?`,
+		},
+		{
+			Path:    "steps[1]",
+			Step:    "Test",
+			Message: "can't tell what type of step this is",
+		},
+		{
+			Path:       "steps[1]",
+			Step:       "Test",
+			Attribute:  "commnad",
+			Suggestion: "command",
+			Message:    `unknown attribute "commnad", did you mean "command"?`,
+		},
+		{
+			Path:       "steps[3].steps[0]",
+			Step:       "Deploy?",
+			Attribute:  "promt",
+			Suggestion: "prompt",
+			Message:    `unknown attribute "promt", did you mean "prompt"?`,
+		},
+		{
+			Path:       "steps[3].steps[1]",
+			Step:       "deploy",
+			Attribute:  "asynch",
+			Suggestion: "async",
+			Message:    `unknown attribute "asynch", did you mean "async"?`,
+		},
+	}
+	if diff := cmp.Diff(checkPipelineAttributes(p), want); diff != "" {
+		t.Errorf("checkPipelineAttributes(p) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestSuggestAttribute(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		attr, want string
+	}{
+		{attr: "commnad", want: "command"},
+		{attr: "artifacts_paths", want: "artifact_paths"},
+		{attr: "soft-fail", want: "soft_fail"},
+		{attr: "fi", want: ""},
+		{attr: "llamas", want: ""},
+	}
+	for _, test := range tests {
+		if got := suggestAttribute(test.attr, commandStepAttributes); got != test.want {
+			t.Errorf("suggestAttribute(%q, commandStepAttributes) = %q, want %q", test.attr, got, test.want)
+		}
+	}
+}
+
+func TestWritePipelineProblems(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	err := writePipelineProblems(&b, []pipelineProblem{{
+		Path:       "steps[1]",
+		Attribute:  "commnad",
+		Suggestion: "command",
+		Message:    `unknown attribute "commnad", did you mean "command"?`,
+	}})
+	if err != nil {
+		t.Fatalf("writePipelineProblems(...) error = %v", err)
+	}
+
+	want := `{
+  "problems": [
+    {
+      "path": "steps[1]",
+      "attribute": "commnad",
+      "suggestion": "command",
+      "message": "unknown attribute \"commnad\", did you mean \"command\"?"
+    }
+  ]
+}
+`
+	if diff := cmp.Diff(b.String(), want); diff != "" {
+		t.Errorf("writePipelineProblems(...) diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestWritePipelineProblemsWithoutProblems(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	if err := writePipelineProblems(&b, nil); err != nil {
+		t.Fatalf("writePipelineProblems(nil) error = %v", err)
+	}
+	if got, want := b.String(), "{\n  \"problems\": []\n}\n"; got != want {
+		t.Errorf("writePipelineProblems(nil) = %q, want %q", got, want)
+	}
+}
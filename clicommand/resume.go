@@ -0,0 +1,51 @@
+package clicommand
+
+import (
+	"context"
+
+	"github.com/urfave/cli"
+)
+
+const resumeHelpDescription = `Usage:
+
+    buildkite-agent resume [options...]
+
+Description:
+
+Resumes job acceptance by the agents running on this machine, after it was
+paused with ′buildkite-agent pause′.
+
+Note that this command is only available when the agents have been started
+with the ′agent-api′ experiment enabled.
+
+Example:
+
+    $ buildkite-agent resume`
+
+type ResumeConfig struct {
+	SocketsPath string `cli:"sockets-path" normalize:"filepath"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+}
+
+var ResumeCommand = cli.Command{
+	Name:        "resume",
+	Usage:       "Resumes job acceptance by the agents on this machine",
+	Description: resumeHelpDescription,
+	Flags:       append(globalFlags(), pauseCommonFlags...),
+	Action: func(c *cli.Context) error {
+		ctx, cfg, l, _, done := setupLoggerAndConfig[ResumeConfig](context.Background(), c)
+		defer done()
+
+		if err := setPaused(ctx, cfg.SocketsPath, false); err != nil {
+			return err
+		}
+		l.Info("Resumed job acceptance.")
+		return nil
+	},
+}
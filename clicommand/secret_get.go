@@ -2,9 +2,14 @@ package clicommand
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"strconv"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/internal/secrets"
 	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/urfave/cli"
 )
@@ -13,6 +18,10 @@ type SecretGetConfig struct {
 	Key           string `cli:"arg:0"`
 	Job           string `cli:"job" validate:"required"`
 	SkipRedaction bool   `cli:"skip-redaction"`
+	Format        string `cli:"format"`
+	File          bool   `cli:"file"`
+	FileMode      string `cli:"file-mode"`
+	SecretsDir    string `cli:"secrets-dir"`
 
 	// Global flags
 	Debug       bool     `cli:"debug"`
@@ -26,6 +35,9 @@ type SecretGetConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var SecretGetCommand = cli.Command{
@@ -42,12 +54,29 @@ specified in this command is the key's name defined for the secret in its
 cluster. The key's name is case insensitive in this command, and the
 key's value is automatically redacted in the build logs.
 
+If the secret's value is a reference to a secret held in HashiCorp Vault
+(′vault://<path>#<field>′) or AWS Secrets Manager
+(′aws-sm://<name or ARN>#<field>′), the referenced secret is fetched using the
+agent's ambient credentials (′VAULT_ADDR′ and ′VAULT_TOKEN′, or the default AWS
+credential chain), and printed instead.
+
 Examples:
 
 The following examples reference the same Buildkite secret ′key′:
 
     $ buildkite-agent secret get deploy_key
-    $ buildkite-agent secret get DEPLOY_KEY`,
+    $ buildkite-agent secret get DEPLOY_KEY
+
+To print the secret as a JSON object with its key and value:
+
+    $ buildkite-agent secret get deploy_key --format json
+
+Some tools only accept credentials as files (such as kubeconfigs, .npmrc files
+and private keys). To write the secret to a new file in the job's private
+secrets directory and print the file's path, use ′--file′. The directory and
+everything in it is removed when the job finishes:
+
+    $ export KUBECONFIG="$(buildkite-agent secret get kubeconfig --file)"`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:   "job",
@@ -59,11 +88,36 @@ The following examples reference the same Buildkite secret ′key′:
 			Usage:  "Skip redacting the retrieved secret from the logs. Then, the command will print the secret to the Job's logs if called directly.",
 			EnvVar: "BUILDKITE_AGENT_SECRET_GET_SKIP_SECRET_REDACTION",
 		},
+		cli.StringFlag{
+			Name:   "format",
+			Usage:  "Output format: plain, or json",
+			EnvVar: "BUILDKITE_AGENT_SECRET_GET_FORMAT",
+			Value:  "plain",
+		},
+		cli.BoolFlag{
+			Name:   "file",
+			Usage:  "Write the secret to a new file in the secrets directory, and print the file's path instead of the secret",
+			EnvVar: "BUILDKITE_AGENT_SECRET_GET_FILE",
+		},
+		cli.StringFlag{
+			Name:   "file-mode",
+			Usage:  "The permissions for the file written with --file, in octal",
+			EnvVar: "BUILDKITE_AGENT_SECRET_GET_FILE_MODE",
+			Value:  "0600",
+		},
+		cli.StringFlag{
+			Name:   "secrets-dir",
+			Usage:  "The directory to write files to with --file. Jobs have one that is removed when the job finishes",
+			EnvVar: "BUILDKITE_SECRETS_DIR",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -78,12 +132,37 @@ The following examples reference the same Buildkite secret ′key′:
 		ctx, cfg, l, _, done := setupLoggerAndConfig[SecretGetConfig](ctx, c)
 		defer done()
 
+		if cfg.Format != "plain" && cfg.Format != "json" {
+			return fmt.Errorf("invalid format: %s, must be one of %q", cfg.Format, []string{"plain", "json"})
+		}
+
+		var fileProcessor *secrets.FileProcessor
+		if cfg.File {
+			if cfg.SecretsDir == "" {
+				return errors.New("--file needs a secrets directory to write to, which is set by the agent during jobs, or with --secrets-dir")
+			}
+			mode, err := strconv.ParseUint(cfg.FileMode, 8, 32)
+			if err != nil || mode > 0o777 {
+				return fmt.Errorf("invalid file mode: %q, must be octal permissions such as 0600", cfg.FileMode)
+			}
+			fileProcessor = &secrets.FileProcessor{
+				Dir:     cfg.SecretsDir,
+				Pattern: "secret-*",
+				Mode:    fs.FileMode(mode),
+			}
+		}
+
 		agentClient := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 		secret, _, err := agentClient.GetSecret(ctx, &api.GetSecretRequest{Key: cfg.Key, JobID: cfg.Job})
 		if err != nil {
 			return err
 		}
 
+		secret.Value, err = secrets.Resolve(ctx, secrets.DefaultProcessors, secret.Value)
+		if err != nil {
+			return fmt.Errorf("secret %q: %w", cfg.Key, err)
+		}
+
 		jobClient, err := jobapi.NewDefaultClient(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to create Job API client: %w", err)
@@ -98,6 +177,25 @@ The following examples reference the same Buildkite secret ′key′:
 			}
 		}
 
+		if fileProcessor != nil {
+			path, err := fileProcessor.Process(ctx, secret.Value)
+			if err != nil {
+				return fmt.Errorf("writing secret %q to a file: %w", cfg.Key, err)
+			}
+			if cfg.Format == "json" {
+				return json.NewEncoder(c.App.Writer).Encode(struct {
+					Key  string `json:"key"`
+					Path string `json:"path"`
+				}{Key: secret.Key, Path: path})
+			}
+			_, err = fmt.Fprintln(c.App.Writer, path)
+			return err
+		}
+
+		if cfg.Format == "json" {
+			return json.NewEncoder(c.App.Writer).Encode(secret)
+		}
+
 		_, err = fmt.Fprintln(c.App.Writer, secret.Value)
 
 		return err
@@ -17,7 +17,7 @@ const stepGetHelpDescription = `Usage:
 Description:
 
 Retrieve the value of an attribute in a step. If no attribute is passed, the
-entire step will be returned.
+entire step will be returned as JSON.
 
 In the event a complex object is returned (an object or an array),
 you'll need to supply the --format option to tell the agent how it should
@@ -26,7 +26,7 @@ output the data (currently only JSON is supported).
 Example:
 
     $ buildkite-agent step get "label" --step "key"
-    $ buildkite-agent step get --format json
+    $ buildkite-agent step get --step "key"
     $ buildkite-agent step get "state" --step "my-other-step"`
 
 type StepGetConfig struct {
@@ -47,6 +47,9 @@ type StepGetConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var StepGetCommand = cli.Command{
@@ -77,6 +80,9 @@ var StepGetCommand = cli.Command{
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -90,6 +96,11 @@ var StepGetCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[StepGetConfig](context.Background(), c)
 		defer done()
 
+		// The whole step is an object, so it can only be output as JSON
+		if cfg.Attribute == "" && cfg.Format == "" {
+			cfg.Format = "json"
+		}
+
 		// Create the API client
 		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
 
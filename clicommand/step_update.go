@@ -1,13 +1,18 @@
 package clicommand
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/roko"
 	"github.com/urfave/cli"
 )
@@ -15,11 +20,18 @@ import (
 const stepUpdateHelpDescription = `Usage:
 
     buildkite-agent step update <attribute> <value> [options...]
+    buildkite-agent step update --patch <json patch> [options...]
 
 Description:
 
 Update an attribute of a step in the build
 
+To update several attributes at once, pass a JSON patch document (RFC 6902)
+with --patch instead of an attribute and value. Only ′add′ and ′replace′
+operations on top-level attributes (such as ′/label′) are supported. String
+values are used as they are, and any other values as JSON. The attributes are
+updated together in a single request.
+
 Note that step labels are used in commit status updates, so if you change the
 label of a running step, you may end up with an 'orphaned' status update
 under the old label, as well as new ones using the updated label.
@@ -35,12 +47,15 @@ Example:
     $ buildkite-agent step update "label" "New Label"
     $ buildkite-agent step update "label" " (add to end of label)" --append
     $ buildkite-agent step update "label" < ./tmp/some-new-label
-    $ ./script/label-generator | buildkite-agent step update "label"`
+    $ ./script/label-generator | buildkite-agent step update "label"
+    $ buildkite-agent step update --patch '[{"op": "replace", "path": "/label", "value": "New Label"}, {"op": "replace", "path": "/soft_fail", "value": true}]'
+    $ ./script/patch-generator | buildkite-agent step update --patch -`
 
 type StepUpdateConfig struct {
-	Attribute string `cli:"arg:0" label:"attribute" validate:"required"`
+	Attribute string `cli:"arg:0" label:"attribute"`
 	Value     string `cli:"arg:1" label:"value"`
 	Append    bool   `cli:"append"`
+	Patch     string `cli:"patch"`
 	StepOrKey string `cli:"step" validate:"required"`
 	Build     string `cli:"build"`
 
@@ -56,6 +71,9 @@ type StepUpdateConfig struct {
 	AgentAccessToken string `cli:"agent-access-token" validate:"required"`
 	Endpoint         string `cli:"endpoint" validate:"required"`
 	NoHTTP2          bool   `cli:"no-http2"`
+	ProxyURL         string `cli:"proxy-url"`
+	NoProxy          string `cli:"no-proxy"`
+	CACertFile       string `cli:"ca-cert-file" normalize:"filepath"`
 }
 
 var StepUpdateCommand = cli.Command{
@@ -80,11 +98,20 @@ var StepUpdateCommand = cli.Command{
 			Usage:  "Append to current attribute instead of replacing it",
 			EnvVar: "BUILDKITE_STEP_UPDATE_APPEND",
 		},
+		cli.StringFlag{
+			Name:   "patch",
+			Value:  "",
+			Usage:  "A JSON patch document of attributes to update, instead of a single attribute and value. Use ′-′ to read it from STDIN",
+			EnvVar: "BUILDKITE_STEP_UPDATE_PATCH",
+		},
 
 		// API Flags
 		AgentAccessTokenFlag,
 		EndpointFlag,
 		NoHTTP2Flag,
+		ProxyURLFlag,
+		NoProxyFlag,
+		CACertFileFlag,
 		DebugHTTPFlag,
 
 		// Global flags
@@ -98,52 +125,153 @@ var StepUpdateCommand = cli.Command{
 		ctx, cfg, l, _, done := setupLoggerAndConfig[StepUpdateConfig](context.Background(), c)
 		defer done()
 
-		// Read the value from STDIN if argument omitted entirely
-		if len(c.Args()) < 2 {
-			l.Info("Reading value from STDIN")
-
-			input, err := io.ReadAll(os.Stdin)
-			if err != nil {
-				return fmt.Errorf("failed to read from STDIN: %w", err)
+		var updates []*api.StepUpdate
+		switch {
+		case cfg.Patch != "":
+			if cfg.Attribute != "" {
+				return errors.New("an attribute can't be given as well as --patch")
+			}
+			if cfg.Append {
+				return errors.New("--append can't be used with --patch")
 			}
-			cfg.Value = string(input)
-		}
 
-		// Create the API client
-		client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
-
-		// Generate a UUID that will identify this change. We do this
-		// outside of the retry loop because we want this UUID to be
-		// the same for each attempt at updating the step.
-		idempotencyUUID := api.NewUUID()
-
-		// Create the value to update
-		update := &api.StepUpdate{
-			IdempotencyUUID: idempotencyUUID,
-			Build:           cfg.Build,
-			Attribute:       cfg.Attribute,
-			Value:           cfg.Value,
-			Append:          cfg.Append,
-		}
+			doc := []byte(cfg.Patch)
+			if cfg.Patch == "-" {
+				l.Info("Reading patch from STDIN")
 
-		// Post the change
-		if err := roko.NewRetrier(
-			roko.WithMaxAttempts(10),
-			roko.WithStrategy(roko.Constant(5*time.Second)),
-		).DoWithContext(ctx, func(r *roko.Retrier) error {
-			resp, err := client.StepUpdate(ctx, cfg.StepOrKey, update)
-			if resp != nil && (resp.StatusCode == 400 || resp.StatusCode == 401 || resp.StatusCode == 404) {
-				r.Break()
+				input, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read from STDIN: %w", err)
+				}
+				doc = input
 			}
-			if err != nil {
-				l.Warn("%s (%s)", err, r)
+
+			var err error
+			if updates, err = parseStepPatch(doc); err != nil {
 				return err
 			}
-			return nil
-		}); err != nil {
-			return fmt.Errorf("failed to change step: %w", err)
+
+		case cfg.Attribute == "":
+			return errors.New("missing attribute: pass an attribute and value, or --patch")
+
+		default:
+			// Read the value from STDIN if argument omitted entirely
+			if len(c.Args()) < 2 {
+				l.Info("Reading value from STDIN")
+
+				input, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("failed to read from STDIN: %w", err)
+				}
+				cfg.Value = string(input)
+			}
+
+			updates = []*api.StepUpdate{{
+				Attribute: cfg.Attribute,
+				Value:     cfg.Value,
+				Append:    cfg.Append,
+			}}
 		}
 
-		return nil
+		return updateStep(ctx, cfg, l, updates)
 	},
 }
+
+// updateStep applies the updates to the step. Several updates are sent as a
+// single batched request, so that either all of them are applied or none are.
+func updateStep(ctx context.Context, cfg StepUpdateConfig, l logger.Logger, updates []*api.StepUpdate) error {
+	// Create the API client
+	client := api.NewClient(l, loadAPIClientConfig(cfg, "AgentAccessToken"))
+
+	update := updates[0]
+	if len(updates) > 1 {
+		update = &api.StepUpdate{}
+		for _, u := range updates {
+			update.Updates = append(update.Updates, api.StepAttributeUpdate{
+				Attribute: u.Attribute,
+				Value:     u.Value,
+				Append:    u.Append,
+			})
+		}
+	}
+
+	// Generate a UUID that will identify this change. We do this
+	// outside of the retry loop because we want this UUID to be
+	// the same for each attempt at updating the step.
+	update.IdempotencyUUID = api.NewUUID()
+	update.Build = cfg.Build
+
+	// Post the change
+	if err := roko.NewRetrier(
+		roko.WithMaxAttempts(10),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		resp, err := client.StepUpdate(ctx, cfg.StepOrKey, update)
+		if resp != nil && (resp.StatusCode == 400 || resp.StatusCode == 401 || resp.StatusCode == 404) {
+			r.Break()
+		}
+		if err != nil {
+			l.Warn("%s (%s)", err, r)
+			return err
+		}
+		return nil
+	}); err != nil {
+		if len(updates) > 1 {
+			return fmt.Errorf("failed to change %d step attributes: %w", len(updates), err)
+		}
+		return fmt.Errorf("failed to change step attribute %q: %w", update.Attribute, err)
+	}
+
+	return nil
+}
+
+// stepPatchOperation is an operation in a JSON patch document (RFC 6902).
+type stepPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// parseStepPatch turns a JSON patch document into a step update for each
+// operation. The step update API sets one top-level attribute at a time, so
+// only "add" and "replace" operations on top-level attributes are supported.
+func parseStepPatch(doc []byte) ([]*api.StepUpdate, error) {
+	var ops []stepPatchOperation
+	if err := json.Unmarshal(doc, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("the JSON patch has no operations")
+	}
+
+	updates := make([]*api.StepUpdate, 0, len(ops))
+	for i, op := range ops {
+		if op.Op != "add" && op.Op != "replace" {
+			return nil, fmt.Errorf("JSON patch operation %d: unsupported op %q, must be \"add\" or \"replace\"", i, op.Op)
+		}
+
+		attribute, ok := strings.CutPrefix(op.Path, "/")
+		if !ok || attribute == "" || strings.Contains(attribute, "/") {
+			return nil, fmt.Errorf("JSON patch operation %d: path %q must name a top-level attribute, such as \"/label\"", i, op.Path)
+		}
+		attribute = strings.NewReplacer("~1", "/", "~0", "~").Replace(attribute)
+
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("JSON patch operation %d: missing value", i)
+		}
+		var value string
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, op.Value); err != nil {
+				return nil, fmt.Errorf("JSON patch operation %d: %w", i, err)
+			}
+			value = compact.String()
+		}
+
+		updates = append(updates, &api.StepUpdate{
+			Attribute: attribute,
+			Value:     value,
+		})
+	}
+	return updates, nil
+}
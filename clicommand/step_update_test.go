@@ -0,0 +1,101 @@
+package clicommand
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseStepPatch(t *testing.T) {
+	t.Parallel()
+
+	got, err := parseStepPatch([]byte(`[
+		{"op": "replace", "path": "/label", "value": "New Label"},
+		{"op": "add", "path": "/soft_fail", "value": true},
+		{"op": "replace", "path": "/retry", "value": {"automatic": {"limit": 2}}},
+		{"op": "replace", "path": "/a~1b", "value": "x"}
+	]`))
+	if err != nil {
+		t.Fatalf("parseStepPatch() error = %v", err)
+	}
+
+	want := []*api.StepUpdate{
+		{Attribute: "label", Value: "New Label"},
+		{Attribute: "soft_fail", Value: "true"},
+		{Attribute: "retry", Value: `{"automatic":{"limit":2}}`},
+		{Attribute: "a/b", Value: "x"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("parseStepPatch() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestParseStepPatchErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, doc := range []string{
+		`{"label": "not a patch"}`,
+		`[]`,
+		`[{"op": "remove", "path": "/label"}]`,
+		`[{"op": "replace", "path": "label", "value": "x"}]`,
+		`[{"op": "replace", "path": "/retry/automatic", "value": true}]`,
+		`[{"op": "replace", "path": "/label"}]`,
+	} {
+		if _, err := parseStepPatch([]byte(doc)); err == nil {
+			t.Errorf("parseStepPatch(%s) error = nil, want an error", doc)
+		}
+	}
+}
+
+func TestUpdateStep(t *testing.T) {
+	t.Parallel()
+
+	var got []api.StepUpdate
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut || req.URL.Path != "/steps/my-step" {
+			t.Errorf("request = %s %s, want PUT /steps/my-step", req.Method, req.URL.Path)
+		}
+		var update api.StepUpdate
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		got = append(got, update)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := StepUpdateConfig{
+		StepOrKey:        "my-step",
+		Build:            "1",
+		AgentAccessToken: "agentaccesstoken",
+		Endpoint:         server.URL,
+	}
+	updates := []*api.StepUpdate{
+		{Attribute: "label", Value: "New Label"},
+		{Attribute: "soft_fail", Value: "true"},
+	}
+	if err := updateStep(context.Background(), cfg, logger.NewBuffer(), updates); err != nil {
+		t.Fatalf("updateStep() error = %v", err)
+	}
+
+	want := []api.StepUpdate{{
+		Build: "1",
+		Updates: []api.StepAttributeUpdate{
+			{Attribute: "label", Value: "New Label"},
+			{Attribute: "soft_fail", Value: "true"},
+		},
+	}}
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(api.StepUpdate{}, "IdempotencyUUID")); diff != "" {
+		t.Errorf("step updates diff (-got +want):\n%s", diff)
+	}
+	if got[0].IdempotencyUUID == "" {
+		t.Errorf("idempotency UUID = %q, want a non-empty UUID", got[0].IdempotencyUUID)
+	}
+}
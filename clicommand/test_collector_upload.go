@@ -0,0 +1,144 @@
+package clicommand
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/internal/testanalytics"
+	"github.com/mattn/go-zglob"
+	"github.com/urfave/cli"
+)
+
+const testCollectorUploadHelpDescription = `Usage:
+
+    buildkite-agent test-collector upload [options...] <pattern>
+
+Description:
+
+Uploads test results to Buildkite Test Analytics. Test results can be JUnit
+XML files, or files in the Test Analytics JSON format. Each file is checked
+before it is uploaded, so that malformed results fail the step rather than
+being silently dropped.
+
+The pattern is a glob, and multiple patterns can be separated by a semicolon.
+The format of each file is worked out from its extension (.xml for JUnit, .json
+for JSON) unless --format is given.
+
+Results are uploaded with the test suite's API token, and linked to the
+current build and job using the BUILDKITE_* environment variables.
+
+Example:
+
+    $ export BUILDKITE_ANALYTICS_TOKEN=xxx
+    $ buildkite-agent test-collector upload "reports/**/*.xml"`
+
+type TestCollectorUploadConfig struct {
+	Pattern  string `cli:"arg:0" label:"test result file pattern" validate:"required"`
+	Format   string `cli:"format"`
+	Token    string `cli:"token" validate:"required"`
+	Endpoint string `cli:"analytics-endpoint"`
+
+	// Global flags
+	Debug       bool     `cli:"debug"`
+	LogLevel    string   `cli:"log-level"`
+	NoColor     bool     `cli:"no-color"`
+	Experiments []string `cli:"experiment" normalize:"list"`
+	Profile     string   `cli:"profile"`
+
+	// API config
+	DebugHTTP bool `cli:"debug-http"`
+	TraceHTTP bool `cli:"trace-http"`
+}
+
+var TestCollectorUploadCommand = cli.Command{
+	Name:        "upload",
+	Usage:       "Uploads test results to Buildkite Test Analytics",
+	Description: testCollectorUploadHelpDescription,
+	Flags: append(globalFlags(),
+		cli.StringFlag{
+			Name:   "format",
+			Value:  "",
+			Usage:  "The format of the test result files, one of junit or json (otherwise detected from each file's extension)",
+			EnvVar: "BUILDKITE_TEST_COLLECTOR_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Value:  "",
+			Usage:  "The Test Analytics suite API token",
+			EnvVar: "BUILDKITE_ANALYTICS_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "analytics-endpoint",
+			Value:  testanalytics.DefaultEndpoint,
+			Usage:  "The Test Analytics API endpoint",
+			EnvVar: "BUILDKITE_ANALYTICS_ENDPOINT",
+		},
+		DebugHTTPFlag,
+		TraceHTTPFlag,
+	),
+	Action: func(c *cli.Context) error {
+		ctx, cfg, l, _, done := setupLoggerAndConfig[TestCollectorUploadConfig](context.Background(), c)
+		defer done()
+
+		if cfg.Format != "" && !slices.Contains(testanalytics.Formats, cfg.Format) {
+			return fmt.Errorf("invalid --format %q, must be one of %s", cfg.Format, strings.Join(testanalytics.Formats, ", "))
+		}
+
+		files, err := testResultFiles(cfg.Pattern)
+		if err != nil {
+			return err
+		}
+
+		uploader := testanalytics.NewUploader(l, testanalytics.UploaderConfig{
+			Endpoint:  cfg.Endpoint,
+			Token:     cfg.Token,
+			RunEnv:    testanalytics.RunEnv(),
+			DebugHTTP: cfg.DebugHTTP,
+			TraceHTTP: cfg.TraceHTTP,
+		})
+
+		var errs []error
+		for _, file := range files {
+			format := cfg.Format
+			if format == "" {
+				if format, err = testanalytics.FormatForPath(file); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+			if err := uploader.UploadFile(ctx, file, format); err != nil {
+				l.Error("Failed to upload %s: %v", file, err)
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to upload some test results: %w", errors.Join(errs...))
+		}
+		return nil
+	},
+}
+
+// testResultFiles resolves semicolon-separated glob patterns into files.
+func testResultFiles(patterns string) ([]string, error) {
+	var files []string
+	for _, pattern := range strings.Split(patterns, ";") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := zglob.Glob(pattern)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("resolving glob %q: %w", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no test result files match %q", patterns)
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
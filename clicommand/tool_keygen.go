@@ -3,13 +3,17 @@ package clicommand
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"slices"
 
 	"github.com/buildkite/go-pipeline/jwkutil"
 	petname "github.com/dustinkirkland/golang-petname"
 	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
 	"github.com/urfave/cli"
 )
 
@@ -18,6 +22,8 @@ type ToolKeygenConfig struct {
 	KeyID           string `cli:"key-id"`
 	PrivateJWKSFile string `cli:"private-jwks-file" normalize:"filepath"`
 	PublicJWKSFile  string `cli:"public-jwks-file" normalize:"filepath"`
+	Rotate          bool   `cli:"rotate"`
+	PrintPublic     bool   `cli:"print-public"`
 
 	NoColor     bool     `cli:"no-color"`
 	Debug       bool     `cli:"debug"`
@@ -43,8 +49,23 @@ The pair is written as a JSON Web Key Set (JWKS) to two files, a private JWKS
 file and a public JWKS file. The private JWKS should be used as for signing,
 and the public JWKS for verification.
 
+To rotate keys, use ′--rotate′ with the existing private and public JWKS
+files. The new key pair is appended to each set, and the old keys are retained
+so that jobs signed with them can still be verified. Once the new key is in
+use, sign with it by passing its key ID to ′--jwks-key-id′.
+
+To print the public JWKS (for pasting into Buildkite), use ′--print-public′.
+
 For more information about JWS, see https://tools.ietf.org/html/rfc7515 and
-for information about JWKS, see https://tools.ietf.org/html/rfc7517`,
+for information about JWKS, see https://tools.ietf.org/html/rfc7517
+
+Examples:
+
+    $ buildkite-agent tool keygen --alg ES512 --key-id 2024-01 --print-public
+
+    $ buildkite-agent tool keygen --rotate --key-id 2024-06 \
+        --private-jwks-file ./private.json \
+        --public-jwks-file ./public.json`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
 			Name:   "alg",
@@ -66,6 +87,16 @@ for information about JWKS, see https://tools.ietf.org/html/rfc7517`,
 			EnvVar: "BUILDKITE_AGENT_KEYGEN_PUBLIC_JWKS_FILE",
 			Usage:  "The filename to write the public keyset to. Defaults to a name based on the key id in the current directory",
 		},
+		cli.BoolFlag{
+			Name:   "rotate",
+			EnvVar: "BUILDKITE_AGENT_KEYGEN_ROTATE",
+			Usage:  "Add the new key pair to the existing key sets in --private-jwks-file and --public-jwks-file, keeping the keys already in them",
+		},
+		cli.BoolFlag{
+			Name:   "print-public",
+			EnvVar: "BUILDKITE_AGENT_KEYGEN_PRINT_PUBLIC",
+			Usage:  "Print the public key set to stdout, after writing it",
+		},
 
 		// Global flags
 		NoColorFlag,
@@ -99,6 +130,22 @@ for information about JWKS, see https://tools.ietf.org/html/rfc7517`,
 			l.Fatal("Failed to generate key pair: %v", err)
 		}
 
+		if cfg.Rotate {
+			if cfg.PrivateJWKSFile == "" || cfg.PublicJWKSFile == "" {
+				l.Fatal("--rotate needs the existing key sets, passed with --private-jwks-file and --public-jwks-file")
+			}
+
+			l.Info("Adding key %s to private key set %s...", cfg.KeyID, cfg.PrivateJWKSFile)
+			if priv, err = appendKeys(cfg.PrivateJWKSFile, priv); err != nil {
+				l.Fatal("Failed to add to private key set: %v", err)
+			}
+
+			l.Info("Adding key %s to public key set %s...", cfg.KeyID, cfg.PublicJWKSFile)
+			if pub, err = appendKeys(cfg.PublicJWKSFile, pub); err != nil {
+				l.Fatal("Failed to add to public key set: %v", err)
+			}
+		}
+
 		if cfg.PrivateJWKSFile == "" {
 			cfg.PrivateJWKSFile = fmt.Sprintf("./%s-%s-private.json", cfg.Alg, cfg.KeyID)
 		}
@@ -107,13 +154,19 @@ for information about JWKS, see https://tools.ietf.org/html/rfc7517`,
 			cfg.PublicJWKSFile = fmt.Sprintf("./%s-%s-public.json", cfg.Alg, cfg.KeyID)
 		}
 
+		// When rotating, the files are expected to exist already
+		write := writeIfNotExists
+		if cfg.Rotate {
+			write = writeReplacing
+		}
+
 		l.Info("Writing private key set to %s...", cfg.PrivateJWKSFile)
 		pKey, err := json.Marshal(priv)
 		if err != nil {
 			l.Fatal("Failed to marshal private key: %v", err)
 		}
 
-		err = writeIfNotExists(cfg.PrivateJWKSFile, pKey)
+		err = write(cfg.PrivateJWKSFile, pKey)
 		if err != nil {
 			l.Fatal("Failed to write private key file: %v", err)
 		}
@@ -124,11 +177,19 @@ for information about JWKS, see https://tools.ietf.org/html/rfc7517`,
 			l.Fatal("Failed to marshal private key: %v", err)
 		}
 
-		err = writeIfNotExists(cfg.PublicJWKSFile, pubKey)
+		err = write(cfg.PublicJWKSFile, pubKey)
 		if err != nil {
 			l.Fatal("Failed to write private key file: %v", err)
 		}
 
+		if cfg.PrintPublic {
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(pub); err != nil {
+				l.Fatal("Failed to print public key set: %v", err)
+			}
+		}
+
 		l.Info("Done! Enjoy your new keys ^_^")
 	},
 }
@@ -140,3 +201,59 @@ func writeIfNotExists(filename string, data []byte) error {
 
 	return os.WriteFile(filename, data, 0o600)
 }
+
+// writeReplacing writes the file via a temporary file in the same directory,
+// which is synced and then renamed over the existing file, so that the
+// existing file is never left partially written. The existing file's mode is
+// kept.
+func writeReplacing(filename string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filename), ".keygen-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // Fails once renamed, which is fine
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// appendKeys returns the key set in filename with the keys from add appended.
+// Keys can't be added if their ID is already in the set.
+func appendKeys(filename string, add jwk.Set) (jwk.Set, error) {
+	set, err := jwk.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading key set: %w", err)
+	}
+
+	for i := range add.Len() {
+		key, _ := add.Key(i)
+		if _, exists := set.LookupKeyID(key.KeyID()); exists {
+			return nil, fmt.Errorf("key set %s already has a key with ID %q", filename, key.KeyID())
+		}
+		if err := set.AddKey(key); err != nil {
+			return nil, fmt.Errorf("adding key %q: %w", key.KeyID(), err)
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,96 @@
+package clicommand
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/go-pipeline/jwkutil"
+	"github.com/google/go-cmp/cmp"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+)
+
+func TestAppendKeys(t *testing.T) {
+	t.Parallel()
+
+	_, oldPub, err := jwkutil.NewKeyPair("old", jwa.EdDSA)
+	if err != nil {
+		t.Fatalf("jwkutil.NewKeyPair(old, EdDSA) error = %v", err)
+	}
+	_, newPub, err := jwkutil.NewKeyPair("new", jwa.ES512)
+	if err != nil {
+		t.Fatalf("jwkutil.NewKeyPair(new, ES512) error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "public.json")
+	b, err := json.Marshal(oldPub)
+	if err != nil {
+		t.Fatalf("json.Marshal(oldPub) error = %v", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	set, err := appendKeys(path, newPub)
+	if err != nil {
+		t.Fatalf("appendKeys(%q, newPub) error = %v", path, err)
+	}
+
+	var gotIDs []string
+	for i := range set.Len() {
+		key, _ := set.Key(i)
+		gotIDs = append(gotIDs, key.KeyID())
+	}
+	if diff := cmp.Diff(gotIDs, []string{"old", "new"}); diff != "" {
+		t.Errorf("appendKeys(%q, newPub) key IDs diff (-got +want):\n%s", path, diff)
+	}
+
+	// The combined set should survive a round trip through the file
+	b, err = json.Marshal(set)
+	if err != nil {
+		t.Fatalf("json.Marshal(set) error = %v", err)
+	}
+	if err := writeReplacing(path, b); err != nil {
+		t.Fatalf("writeReplacing(%q) error = %v", path, err)
+	}
+	reread, err := jwk.ReadFile(path)
+	if err != nil {
+		t.Fatalf("jwk.ReadFile(%q) error = %v", path, err)
+	}
+	if got, want := reread.Len(), 2; got != want {
+		t.Errorf("jwk.ReadFile(%q).Len() = %d, want %d", path, got, want)
+	}
+
+	if _, err := appendKeys(path, newPub); err == nil {
+		t.Errorf("appendKeys(%q, newPub) error = nil, want an error for a duplicate key ID", path)
+	}
+}
+
+func TestWriteReplacingKeepsMode(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "public.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatalf("os.Chmod(%q) error = %v", path, err)
+	}
+
+	if err := writeReplacing(path, []byte(`{"keys":[]}`)); err != nil {
+		t.Fatalf("writeReplacing(%q) error = %v", path, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", path, err)
+	}
+	if got, want := fi.Mode().Perm(), os.FileMode(0o644); got != want {
+		t.Errorf("after writeReplacing(%q), mode = %v, want %v", path, got, want)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != `{"keys":[]}` {
+		t.Errorf("os.ReadFile(%q) = %q, %v, want %q, nil", path, got, err, `{"keys":[]}`)
+	}
+}
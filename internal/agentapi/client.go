@@ -9,7 +9,12 @@ import (
 	"github.com/buildkite/agent/v3/internal/socket"
 )
 
-const lockAPIPrefix = "http://agent/api/leader/v0/lock/"
+const (
+	lockAPIPrefix  = "http://agent/api/leader/v0/lock/"
+	lockAllAPIPath = "http://agent/api/leader/v0/lock/all"
+	pauseAPIPath   = "http://agent/api/leader/v0/pause/"
+	tagsAPIPath    = "http://agent/api/leader/v0/tags/"
+)
 
 // Client is a client for the agent API socket.
 type Client struct {
@@ -55,11 +60,18 @@ func (c *Client) LockGet(ctx context.Context, key string) (string, error) {
 // value, or performs no modification. It returns the most up-to-date value for
 // the key, and reports whether the new value was written.
 func (c *Client) LockCompareAndSwap(ctx context.Context, key, old, new string) (string, bool, error) {
+	return c.LockCompareAndSwapTTL(ctx, key, old, new, 0)
+}
+
+// LockCompareAndSwapTTL is like LockCompareAndSwap, but if ttl is positive the
+// new value expires (reverting to empty) after that long.
+func (c *Client) LockCompareAndSwapTTL(ctx context.Context, key, old, new string, ttl time.Duration) (string, bool, error) {
 	uk := "?key=" + url.QueryEscape(key)
 
 	req := LockCASRequest{
 		Old: old,
 		New: new,
+		TTL: ttl,
 	}
 	var resp LockCASResponse
 	if err := c.sc.Do(ctx, "PATCH", lockAPIPrefix+uk, &req, &resp); err != nil {
@@ -67,3 +79,39 @@ func (c *Client) LockCompareAndSwap(ctx context.Context, key, old, new string) (
 	}
 	return resp.Value, resp.Swapped, nil
 }
+
+// LockList gets all the locks that are currently set.
+func (c *Client) LockList(ctx context.Context) ([]LockInfo, error) {
+	var resp LockListResponse
+	if err := c.sc.Do(ctx, "GET", lockAllAPIPath, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Locks, nil
+}
+
+// Paused reports whether job acceptance has been paused.
+func (c *Client) Paused(ctx context.Context) (bool, error) {
+	var resp PauseResponse
+	if err := c.sc.Do(ctx, "GET", pauseAPIPath, nil, &resp); err != nil {
+		return false, err
+	}
+	return resp.Paused, nil
+}
+
+// SetPaused pauses or resumes job acceptance by the agents using the server.
+func (c *Client) SetPaused(ctx context.Context, paused bool) error {
+	req := PauseRequest{Paused: paused}
+	var resp PauseResponse
+	return c.sc.Do(ctx, "PUT", pauseAPIPath, &req, &resp)
+}
+
+// SetTags replaces the static tags of the agent serving the socket, which
+// re-registers with Buildkite once idle. It returns all of the agent's tags.
+func (c *Client) SetTags(ctx context.Context, tags []string) ([]string, error) {
+	req := TagsRequest{Tags: tags}
+	var resp TagsResponse
+	if err := c.sc.Do(ctx, "PUT", tagsAPIPath, &req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tags, nil
+}
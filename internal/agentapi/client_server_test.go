@@ -2,6 +2,7 @@ package agentapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
 )
 
 var testSocketCounter uint32
@@ -29,11 +31,16 @@ func testLogger(t *testing.T) logger.Logger {
 }
 
 func testServerAndClient(t *testing.T, ctx context.Context) (*Server, *Client) {
+	t.Helper()
+	return testServerAndClientWithTags(t, ctx, nil)
+}
+
+func testServerAndClientWithTags(t *testing.T, ctx context.Context, tags TagSetter) (*Server, *Client) {
 	t.Helper()
 	sockPath, logger := testSocketPath(), testLogger(t)
-	svr, err := NewServer(sockPath, logger)
+	svr, err := NewServer(sockPath, logger, tags)
 	if err != nil {
-		t.Fatalf("NewServer(%q, logger) = error %v", sockPath, err)
+		t.Fatalf("NewServer(%q, logger, tags) = error %v", sockPath, err)
 	}
 	if err := svr.Start(); err != nil {
 		t.Fatalf("svr.Start() = %v", err)
@@ -110,3 +117,118 @@ func TestLockOperations(t *testing.T) {
 		t.Errorf("cli.LockGet(ctx, %q) = %q, want %q", key, got, want)
 	}
 }
+
+func TestLockList(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	if _, _, err := cli.LockCompareAndSwapTTL(ctx, "llama", "", "Kuzco", time.Hour); err != nil {
+		t.Fatalf("cli.LockCompareAndSwapTTL(ctx, llama, \"\", Kuzco, 1h) = error %v", err)
+	}
+	if _, _, err := cli.LockCompareAndSwap(ctx, "alpaca", "", "Pacha"); err != nil {
+		t.Fatalf("cli.LockCompareAndSwap(ctx, alpaca, \"\", Pacha) = error %v", err)
+	}
+
+	locks, err := cli.LockList(ctx)
+	if err != nil {
+		t.Fatalf("cli.LockList(ctx) = error %v", err)
+	}
+	if got, want := len(locks), 2; got != want {
+		t.Fatalf("len(cli.LockList(ctx)) = %d, want %d", got, want)
+	}
+	if got, want := locks[0].Key, "alpaca"; got != want {
+		t.Errorf("cli.LockList(ctx)[0].Key = %q, want %q", got, want)
+	}
+	if locks[0].ExpiresAt != nil {
+		t.Errorf("cli.LockList(ctx)[0].ExpiresAt = %v, want nil", locks[0].ExpiresAt)
+	}
+	if got, want := locks[1].Key, "llama"; got != want {
+		t.Errorf("cli.LockList(ctx)[1].Key = %q, want %q", got, want)
+	}
+	if locks[1].ExpiresAt == nil || !locks[1].ExpiresAt.After(locks[1].SetAt) {
+		t.Errorf("cli.LockList(ctx)[1].ExpiresAt = %v, want a time after %v", locks[1].ExpiresAt, locks[1].SetAt)
+	}
+}
+
+func TestPauseOperations(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	// Job acceptance shouldn't be paused before anything pauses it.
+	paused, err := cli.Paused(ctx)
+	if err != nil {
+		t.Errorf("cli.Paused(ctx) = error %v", err)
+	}
+	if paused {
+		t.Errorf("cli.Paused(ctx) = %t, want %t", paused, false)
+	}
+
+	for _, want := range []bool{true, true, false} {
+		if err := cli.SetPaused(ctx, want); err != nil {
+			t.Errorf("cli.SetPaused(ctx, %t) = %v", want, err)
+		}
+		paused, err := cli.Paused(ctx)
+		if err != nil {
+			t.Errorf("cli.Paused(ctx) = error %v", err)
+		}
+		if paused != want {
+			t.Errorf("after cli.SetPaused(ctx, %t), cli.Paused(ctx) = %t, want %t", want, paused, want)
+		}
+	}
+}
+
+type fakeTagSetter struct {
+	static, fetched []string
+}
+
+func (f *fakeTagSetter) SetTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, errors.New("no tags")
+	}
+	f.static = tags
+	return append(f.static, f.fetched...), nil
+}
+
+func TestSetTags(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	setter := &fakeTagSetter{fetched: []string{"hostname=llama"}}
+	svr, cli := testServerAndClientWithTags(t, ctx, setter)
+	t.Cleanup(func() { svr.Close() })
+
+	got, err := cli.SetTags(ctx, []string{"spot-termination=soon"})
+	if err != nil {
+		t.Errorf("cli.SetTags(ctx, [spot-termination=soon]) = error %v", err)
+	}
+	want := []string{"spot-termination=soon", "hostname=llama"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("cli.SetTags(ctx, [spot-termination=soon]) diff (-got +want):\n%s", diff)
+	}
+
+	if _, err := cli.SetTags(ctx, nil); err == nil {
+		t.Errorf("cli.SetTags(ctx, nil) = nil error, want the setter's error")
+	}
+}
+
+func TestSetTagsNotSupported(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	if _, err := cli.SetTags(ctx, []string{"llama=true"}); err == nil {
+		t.Errorf("cli.SetTags(ctx, [llama=true]) = nil error, want an error")
+	}
+}
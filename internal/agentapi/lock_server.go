@@ -28,6 +28,7 @@ func newLockServer(logger logger.Logger) *lockServer {
 func (s *lockServer) routes(r chi.Router) {
 	r.Get("/", s.getLock)
 	r.Patch("/", s.patchLock)
+	r.Get("/all", s.listLocks)
 }
 
 // getLock atomically retrieves the current lock value.
@@ -47,6 +48,16 @@ func (s *lockServer) getLock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// listLocks retrieves all the locks that are currently set.
+func (s *lockServer) listLocks(w http.ResponseWriter, r *http.Request) {
+	resp := &LockListResponse{
+		Locks: s.locks.list(),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
+
 // patchLock tries to atomically update the lock value.
 func (s *lockServer) patchLock(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
@@ -65,7 +76,7 @@ func (s *lockServer) patchLock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	v, ok := s.locks.cas(key, req.Old, req.New)
+	v, ok := s.locks.cas(key, req.Old, req.New, req.TTL)
 	resp := &LockCASResponse{
 		Value:   v,
 		Swapped: ok,
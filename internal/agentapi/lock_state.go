@@ -1,36 +1,100 @@
 package agentapi
 
-import "sync"
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
 
-// lockState is really just a concurrent map.
+// lockState is really just a concurrent map, with optional expiry.
 type lockState struct {
 	mu    sync.Mutex
-	locks map[string]string
+	locks map[string]lockEntry
+
+	// now is time.Now, except in tests.
+	now func() time.Time
+}
+
+// lockEntry is the value of a lock, and when it was set and expires.
+type lockEntry struct {
+	value     string
+	setAt     time.Time
+	expiresAt time.Time // zero if it never expires
 }
 
 // newLockState creates a new empty lockServer.
 func newLockState() *lockState {
 	return &lockState{
-		locks: make(map[string]string),
+		locks: make(map[string]lockEntry),
+		now:   time.Now,
 	}
 }
 
+// get retrieves the current entry for the key, removing it if it has expired.
+// s.mu must be held.
+func (s *lockState) get(key string) lockEntry {
+	e := s.locks[key]
+	if !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt) {
+		delete(s.locks, key)
+		return lockEntry{}
+	}
+	return e
+}
+
 // load atomically retrieves the current value for the lock.
 func (s *lockState) load(key string) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.locks[key]
+	return s.get(key).value
 }
 
 // cas atomically attempts to swap the old value for the key for a new
 // value. It reports whether the swap succeeded, returning the (new or existing)
-// value.
-func (s *lockState) cas(key, old, new string) (string, bool) {
+// value. If ttl is positive, the new value expires (reverting to empty) after
+// that long.
+func (s *lockState) cas(key, old, new string, ttl time.Duration) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.locks[key] == old {
-		s.locks[key] = new
+	e := s.get(key)
+	if e.value != old {
+		return e.value, false
+	}
+	if new == "" {
+		delete(s.locks, key)
 		return new, true
 	}
-	return s.locks[key], false
+	e = lockEntry{value: new, setAt: s.now()}
+	if ttl > 0 {
+		e.expiresAt = e.setAt.Add(ttl)
+	}
+	s.locks[key] = e
+	return new, true
+}
+
+// list atomically retrieves all the locks that are currently set, sorted by
+// key.
+func (s *lockState) list() []LockInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]LockInfo, 0, len(s.locks))
+	for key := range s.locks {
+		e := s.get(key)
+		if e.value == "" {
+			continue
+		}
+		info := LockInfo{
+			Key:   key,
+			Value: e.value,
+			SetAt: e.setAt,
+		}
+		if !e.expiresAt.IsZero() {
+			info.ExpiresAt = &e.expiresAt
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b LockInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return infos
 }
@@ -0,0 +1,55 @@
+package agentapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLockStateTTL(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s := newLockState()
+	s.now = func() time.Time { return now }
+
+	if _, ok := s.cas("llama", "", "Kuzco", time.Minute); !ok {
+		t.Fatalf("s.cas(llama, \"\", Kuzco, 1m) swapped = false, want true")
+	}
+	if _, ok := s.cas("alpaca", "", "Pacha", 0); !ok {
+		t.Fatalf("s.cas(alpaca, \"\", Pacha, 0) swapped = false, want true")
+	}
+
+	now = start.Add(30 * time.Second)
+	if got, want := s.load("llama"), "Kuzco"; got != want {
+		t.Errorf("after 30s, s.load(llama) = %q, want %q", got, want)
+	}
+
+	expiresAt := start.Add(time.Minute)
+	want := []LockInfo{
+		{Key: "alpaca", Value: "Pacha", SetAt: start},
+		{Key: "llama", Value: "Kuzco", SetAt: start, ExpiresAt: &expiresAt},
+	}
+	if diff := cmp.Diff(s.list(), want); diff != "" {
+		t.Errorf("after 30s, s.list() diff (-got +want):\n%s", diff)
+	}
+
+	now = start.Add(time.Minute)
+	if got, want := s.load("llama"), ""; got != want {
+		t.Errorf("after 1m, s.load(llama) = %q, want %q", got, want)
+	}
+	if got, want := s.load("alpaca"), "Pacha"; got != want {
+		t.Errorf("after 1m, s.load(alpaca) = %q, want %q", got, want)
+	}
+
+	// The original holder can no longer release the expired lock, but
+	// someone else can acquire it.
+	if _, ok := s.cas("llama", "Kuzco", "", 0); ok {
+		t.Errorf("after 1m, s.cas(llama, Kuzco, \"\", 0) swapped = true, want false")
+	}
+	if _, ok := s.cas("llama", "", "Yzma", 0); !ok {
+		t.Errorf("after 1m, s.cas(llama, \"\", Yzma, 0) swapped = false, want true")
+	}
+}
@@ -0,0 +1,62 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// pauseServer serves requests to pause and resume job acceptance by the agents
+// on this machine.
+type pauseServer struct {
+	logger logger.Logger
+	paused atomic.Bool
+}
+
+// newPauseServer creates a pauseServer, initially unpaused.
+func newPauseServer(logger logger.Logger) *pauseServer {
+	return &pauseServer{logger: logger}
+}
+
+// routes defines routes for the pauseServer.
+func (s *pauseServer) routes(r chi.Router) {
+	r.Get("/", s.getPause)
+	r.Put("/", s.putPause)
+}
+
+// getPause retrieves whether job acceptance is paused.
+func (s *pauseServer) getPause(w http.ResponseWriter, r *http.Request) {
+	s.writeResponse(w)
+}
+
+// putPause pauses or resumes job acceptance.
+func (s *pauseServer) putPause(w http.ResponseWriter, r *http.Request) {
+	var req PauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	if s.paused.Swap(req.Paused) != req.Paused {
+		if req.Paused {
+			s.logger.Info("Agent API: Job acceptance paused")
+		} else {
+			s.logger.Info("Agent API: Job acceptance resumed")
+		}
+	}
+	s.writeResponse(w)
+}
+
+func (s *pauseServer) writeResponse(w http.ResponseWriter) {
+	resp := &PauseResponse{Paused: s.paused.Load()}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
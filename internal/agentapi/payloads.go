@@ -16,6 +16,9 @@ type ValueResponse struct {
 type LockCASRequest struct {
 	Old string `json:"old"`
 	New string `json:"new"`
+
+	// If positive, the new value expires after this long.
+	TTL time.Duration `json:"ttl,omitempty"`
 }
 
 // LockCASResponse is the response body for the PATCH /lock/{key} endpoint.
@@ -23,3 +26,36 @@ type LockCASResponse struct {
 	Value   string `json:"value"`
 	Swapped bool   `json:"swapped"`
 }
+
+// LockInfo describes a lock that is currently set.
+type LockInfo struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value"`
+	SetAt     time.Time  `json:"set_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LockListResponse is the response body for the GET /lock/all endpoint.
+type LockListResponse struct {
+	Locks []LockInfo `json:"locks"`
+}
+
+// PauseRequest is the request body for the PUT /pause endpoint.
+type PauseRequest struct {
+	Paused bool `json:"paused"`
+}
+
+// PauseResponse is the response body for the /pause endpoints.
+type PauseResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// TagsRequest is the request body for the PUT /tags endpoint.
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagsResponse is the response body for the PUT /tags endpoint.
+type TagsResponse struct {
+	Tags []string `json:"tags"`
+}
@@ -25,6 +25,8 @@ func (s *Server) router(log logger.Logger) chi.Router {
 	r.Route("/api/leader/v0", func(r chi.Router) {
 		r.Get("/ping", pingHandler(log))
 		r.Route("/lock", s.lockSvr.routes)
+		r.Route("/pause", s.pauseSvr.routes)
+		r.Route("/tags", s.tagsSvr.routes)
 	})
 
 	return r
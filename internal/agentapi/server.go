@@ -9,14 +9,18 @@ import (
 type Server struct {
 	*socket.Server
 
-	lockSvr *lockServer
+	lockSvr  *lockServer
+	pauseSvr *pauseServer
+	tagsSvr  *tagsServer
 }
 
 // NewServer creates a new Agent API server that, when started, listens on the
-// socketPath.
-func NewServer(socketPath string, log logger.Logger) (*Server, error) {
+// socketPath. Requests to set the agent's tags are passed to tags, if not nil.
+func NewServer(socketPath string, log logger.Logger, tags TagSetter) (*Server, error) {
 	s := &Server{
-		lockSvr: newLockServer(log),
+		lockSvr:  newLockServer(log),
+		pauseSvr: newPauseServer(log),
+		tagsSvr:  &tagsServer{logger: log, setter: tags},
 	}
 	svr, err := socket.NewServer(socketPath, s.router(log))
 	if err != nil {
@@ -0,0 +1,60 @@
+package agentapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// TagSetter sets the tags of the agent serving the API.
+type TagSetter interface {
+	// SetTags replaces the agent's static tags (those from --tags), and
+	// returns all of its tags.
+	SetTags(tags []string) ([]string, error)
+}
+
+// tagsServer serves requests to set the agent's tags using a TagSetter.
+type tagsServer struct {
+	logger logger.Logger
+	setter TagSetter
+}
+
+// routes defines routes for the tagsServer.
+func (s *tagsServer) routes(r chi.Router) {
+	r.Put("/", s.putTags)
+}
+
+// putTags sets the agent's tags.
+func (s *tagsServer) putTags(w http.ResponseWriter, r *http.Request) {
+	if s.setter == nil {
+		if err := socket.WriteError(w, "this agent doesn't support setting tags", http.StatusNotImplemented); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	var req TagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if err := socket.WriteError(w, fmt.Sprintf("couldn't decode request body: %v", err), http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	tags, err := s.setter.SetTags(req.Tags)
+	if err != nil {
+		if err := socket.WriteError(w, err, http.StatusBadRequest); err != nil {
+			s.logger.Error("Agent API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	resp := &TagsResponse{Tags: tags}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Error("Agent API: couldn't encode response body: %v", err)
+	}
+}
@@ -76,6 +76,7 @@ func newTransport(conf *clientConfig) *http.Transport {
 	if conf.TLSConfig != nil {
 		transport.TLSClientConfig = conf.TLSConfig
 	}
+	applyNetworkConfig(transport, conf)
 
 	if conf.AllowHTTP2 {
 		// There is a bug in http2 on Linux regarding using dead connections.
@@ -0,0 +1,108 @@
+package agenthttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// NetworkConfig is process-wide configuration for how the clients created by
+// NewClient reach the network, such as from the --proxy-url, --no-proxy and
+// --ca-cert-file flags.
+type NetworkConfig struct {
+	// ProxyURL is the proxy for both HTTP and HTTPS requests. If empty, the
+	// proxy comes from the HTTP_PROXY and HTTPS_PROXY environment variables.
+	ProxyURL string
+
+	// NoProxy lists the hosts to connect to directly, in the same format as
+	// the NO_PROXY environment variable. If empty, NO_PROXY is used.
+	NoProxy string
+
+	// RootCAs are the certificate authorities to trust, instead of the
+	// system's. nil means the system's.
+	RootCAs *x509.CertPool
+}
+
+// network is guarded by transportCacheMu, as it affects the cached transports.
+var network NetworkConfig
+
+// SetNetworkConfig sets the network configuration for clients created by
+// NewClient from now on. It should be called before any clients are created.
+func SetNetworkConfig(nc NetworkConfig) error {
+	if nc.ProxyURL != "" {
+		if err := ValidateProxyURL(nc.ProxyURL); err != nil {
+			return err
+		}
+	}
+
+	transportCacheMu.Lock()
+	defer transportCacheMu.Unlock()
+	network = nc
+	clear(transportCache)
+	return nil
+}
+
+// ValidateProxyURL returns an error if the proxy URL isn't one that clients
+// can use.
+func ValidateProxyURL(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid proxy URL %q: the scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q: it has no host", u.Redacted())
+	}
+	return nil
+}
+
+// LoadCACertFile reads PEM-encoded certificate authorities from a file, for
+// use as NetworkConfig.RootCAs.
+func LoadCACertFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("reading CA certificates: " + path + " contains no PEM-encoded certificates")
+	}
+	return pool, nil
+}
+
+// applyNetworkConfig configures the transport with the network configuration,
+// which overrides the environment.
+func applyNetworkConfig(transport *http.Transport, conf *clientConfig) {
+	if network.ProxyURL != "" || network.NoProxy != "" {
+		pc := httpproxy.FromEnvironment()
+		if network.ProxyURL != "" {
+			pc.HTTPProxy = network.ProxyURL
+			pc.HTTPSProxy = network.ProxyURL
+		}
+		if network.NoProxy != "" {
+			pc.NoProxy = network.NoProxy
+		}
+		proxy := pc.ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+
+	// An explicit TLS config (primarily used for testing) wins
+	if network.RootCAs != nil && conf.TLSConfig == nil {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = network.RootCAs
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -28,6 +29,11 @@ type ArtifactoryDownloaderConfig struct {
 	// How many times should it retry the download before giving up
 	Retries int
 
+	// How many chunks to download in parallel, and whether partial downloads
+	// should be resumed. See DownloadConfig.
+	Concurrency int
+	Resume      bool
+
 	// If failed responses should be dumped to the log
 	DebugHTTP    bool
 	TraceHTTP    bool
@@ -50,12 +56,31 @@ func NewArtifactoryDownloader(l logger.Logger, c ArtifactoryDownloaderConfig) *A
 }
 
 func (d ArtifactoryDownloader) Start(ctx context.Context) error {
+	dl, err := d.download()
+	if err != nil {
+		return err
+	}
+	return dl.Start(ctx)
+}
+
+// Stream downloads the file and writes it to w. See Download.Stream.
+func (d ArtifactoryDownloader) Stream(ctx context.Context, w io.Writer) error {
+	dl, err := d.download()
+	if err != nil {
+		return err
+	}
+	return dl.Stream(ctx, w)
+}
+
+// download returns a regular download of the file, with the Artifactory
+// credentials.
+func (d ArtifactoryDownloader) download() (*Download, error) {
 	// Pull environment variables
 	stringURL := os.Getenv("BUILDKITE_ARTIFACTORY_URL")
 	username := os.Getenv("BUILDKITE_ARTIFACTORY_USER")
 	password := os.Getenv("BUILDKITE_ARTIFACTORY_PASSWORD")
 	if stringURL == "" || username == "" || password == "" {
-		return errors.New("Must set BUILDKITE_ARTIFACTORY_URL, BUILDKITE_ARTIFACTORY_USER, BUILDKITE_ARTIFACTORY_PASSWORD when using rt:// path")
+		return nil, errors.New("Must set BUILDKITE_ARTIFACTORY_URL, BUILDKITE_ARTIFACTORY_USER, BUILDKITE_ARTIFACTORY_PASSWORD when using rt:// path")
 	}
 
 	// create full URL
@@ -81,10 +106,12 @@ func (d ArtifactoryDownloader) Start(ctx context.Context) error {
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
 		Retries:     d.conf.Retries,
+		Concurrency: d.conf.Concurrency,
+		Resume:      d.conf.Resume,
 		Headers:     headers,
 		DebugHTTP:   d.conf.DebugHTTP,
 		TraceHTTP:   d.conf.TraceHTTP,
-	}).Start(ctx)
+	}), nil
 }
 
 func (d ArtifactoryDownloader) RepositoryFileLocation() string {
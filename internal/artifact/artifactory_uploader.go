@@ -8,18 +8,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/agenthttp"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/shellwords"
 )
 
 type ArtifactoryUploaderConfig struct {
@@ -52,10 +56,19 @@ type ArtifactoryUploader struct {
 	// The logger instance to use
 	logger logger.Logger
 
-	// Artifactory username
+	// Artifactory username. If it's empty, the password is used as an access
+	// token instead.
 	user string
 
-	// Artifactory password
+	// The command that prints the password or access token, which is run
+	// again when the password is rejected, so it can be rotated
+	credentialHelper []string
+
+	// Whether to try deploying each file by its checksum before uploading it
+	checksumDeploy bool
+
+	// Artifactory password or access token
+	mu       sync.Mutex
 	password string
 }
 
@@ -64,9 +77,20 @@ func NewArtifactoryUploader(l logger.Logger, c ArtifactoryUploaderConfig) (*Arti
 	stringURL := os.Getenv("BUILDKITE_ARTIFACTORY_URL")
 	username := os.Getenv("BUILDKITE_ARTIFACTORY_USER")
 	password := os.Getenv("BUILDKITE_ARTIFACTORY_PASSWORD")
+	credentialHelper, err := shellwords.Split(os.Getenv("BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER"))
+	if err != nil {
+		return nil, fmt.Errorf("splitting BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER into tokens: %w", err)
+	}
 	// authentication is not set
-	if stringURL == "" || username == "" || password == "" {
-		return nil, errors.New("Must set BUILDKITE_ARTIFACTORY_URL, BUILDKITE_ARTIFACTORY_USER, BUILDKITE_ARTIFACTORY_PASSWORD when using rt:// path")
+	if stringURL == "" || (len(credentialHelper) == 0 && (username == "" || password == "")) {
+		return nil, errors.New("Must set BUILDKITE_ARTIFACTORY_URL, and either BUILDKITE_ARTIFACTORY_USER and BUILDKITE_ARTIFACTORY_PASSWORD or BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER when using rt:// path")
+	}
+
+	checksumDeploy := false
+	if v := os.Getenv("BUILDKITE_ARTIFACTORY_CHECKSUM_DEPLOY"); v != "" {
+		if checksumDeploy, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("parsing BUILDKITE_ARTIFACTORY_CHECKSUM_DEPLOY: %w", err)
+		}
 	}
 
 	parsedURL, err := url.Parse(stringURL)
@@ -80,11 +104,13 @@ func NewArtifactoryUploader(l logger.Logger, c ArtifactoryUploaderConfig) (*Arti
 			agenthttp.WithAllowHTTP2(!c.DisableHTTP2),
 			agenthttp.WithNoTimeout,
 		),
-		iURL:       parsedURL,
-		Path:       path,
-		Repository: repo,
-		user:       username,
-		password:   password,
+		iURL:             parsedURL,
+		Path:             path,
+		Repository:       repo,
+		user:             username,
+		password:         password,
+		credentialHelper: credentialHelper,
+		checksumDeploy:   checksumDeploy,
 	}, nil
 }
 
@@ -123,62 +149,191 @@ func (u *artifactoryUploaderWork) Description() string {
 	return singleUnitDescription(u.artifact)
 }
 
-func (u *artifactoryUploaderWork) DoWork(context.Context) (*api.ArtifactPartETag, error) {
-	// Open file from filesystem
-	u.logger.Debug("Reading file %q", u.artifact.AbsolutePath)
-	f, err := os.Open(u.artifact.AbsolutePath)
+func (u *artifactoryUploaderWork) DoWork(ctx context.Context) (*api.ArtifactPartETag, error) {
+	checksums, err := artifactoryChecksums(u.artifact.AbsolutePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %q (%w)", u.artifact.AbsolutePath, err)
+		return nil, fmt.Errorf("failed to checksum file %q (%w)", u.artifact.AbsolutePath, err)
+	}
+
+	// Artifactory can deploy a file it already has a copy of from its
+	// checksums alone, so identical files don't have to be uploaded again.
+	if u.checksumDeploy {
+		res, err := u.put(ctx, checksums, true)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusNotFound {
+			u.logger.Debug("Deployed %q to %q by checksum", u.artifact.Path, u.URL(u.artifact))
+			return nil, checkResponse(res)
+		}
+		res.Body.Close()
 	}
 
 	// Upload the file to Artifactory.
 	u.logger.Debug("Uploading %q to %q", u.artifact.Path, u.URL(u.artifact))
-
-	req, err := http.NewRequest("PUT", u.URL(u.artifact), f)
-	req.SetBasicAuth(u.user, u.password)
+	res, err := u.put(ctx, checksums, false)
 	if err != nil {
 		return nil, err
 	}
+	return nil, checkResponse(res)
+}
 
-	md5Checksum, err := checksumFile(md5.New(), u.artifact.AbsolutePath)
-	if err != nil {
-		return nil, err
+// put uploads the file, or only its checksums if checksumDeploy is true. When
+// Artifactory is throttling requests, it waits as long as Artifactory asks
+// before trying again. When the password is rejected, it gets a new one from
+// the credential helper, if there is one, and tries again.
+func (u *artifactoryUploaderWork) put(ctx context.Context, checksums map[string]string, checksumDeploy bool) (*http.Response, error) {
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		password, err := u.credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// The client closes the file once it's been sent.
+		var body io.ReadCloser = http.NoBody
+		if !checksumDeploy {
+			u.logger.Debug("Reading file %q", u.artifact.AbsolutePath)
+			f, err := os.Open(u.artifact.AbsolutePath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open file %q (%w)", u.artifact.AbsolutePath, err)
+			}
+			body = f
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "PUT", u.URL(u.artifact), body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		if u.user != "" {
+			req.SetBasicAuth(u.user, password)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+password)
+		}
+		for name, value := range checksums {
+			req.Header.Set(name, value)
+		}
+		if checksumDeploy {
+			req.Header.Set("X-Checksum-Deploy", "true")
+		}
+
+		res, err := agenthttp.Do(u.logger, u.client, req,
+			agenthttp.WithDebugHTTP(u.conf.DebugHTTP),
+			agenthttp.WithTraceHTTP(u.conf.TraceHTTP),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case res.StatusCode == http.StatusUnauthorized && len(u.credentialHelper) > 0 && !refreshed:
+			res.Body.Close()
+			u.logger.Info("Artifactory rejected the credentials, getting new ones from the credential helper")
+			if err := u.refreshCredentials(ctx, password); err != nil {
+				return nil, err
+			}
+			refreshed = true
+
+		case (res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable) && attempt < artifactoryThrottledAttempts:
+			res.Body.Close()
+			wait := artifactoryRetryAfter(res.Header, attempt, time.Now())
+			u.logger.Warn("Artifactory responded with %s, trying again in %v", res.Status, wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+		default:
+			return res, nil
+		}
 	}
-	req.Header.Add("X-Checksum-MD5", md5Checksum)
+}
 
-	sha1Checksum, err := checksumFile(sha1.New(), u.artifact.AbsolutePath)
-	if err != nil {
-		return nil, err
+// How many times to try a request that Artifactory is throttling, the
+// longest to wait between tries when it doesn't say how long to wait, and the
+// longest to wait when it does.
+const (
+	artifactoryThrottledAttempts = 10
+	artifactoryMaxThrottledWait  = time.Minute
+	artifactoryMaxRetryAfter     = 5 * time.Minute
+)
+
+// artifactoryRetryAfter returns how long to wait before trying a throttled
+// request again. It's given by the Retry-After header, in seconds or as a
+// date, up to artifactoryMaxRetryAfter, or otherwise doubles with each
+// attempt.
+func artifactoryRetryAfter(h http.Header, attempt int, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			// Clamp the seconds before converting them, so that a huge
+			// value can't overflow.
+			return time.Duration(min(seconds, int(artifactoryMaxRetryAfter/time.Second))) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return min(max(t.Sub(now), 0), artifactoryMaxRetryAfter)
+		}
 	}
-	req.Header.Add("X-Checksum-SHA1", sha1Checksum)
+	return min(time.Second<<(attempt-1), artifactoryMaxThrottledWait)
+}
 
-	sha256Checksum, err := checksumFile(sha256.New(), u.artifact.AbsolutePath)
-	if err != nil {
-		return nil, err
+// credentials returns the password, running the credential helper to get it
+// if there isn't one yet.
+func (u *ArtifactoryUploader) credentials(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	password := u.password
+	u.mu.Unlock()
+	if password != "" {
+		return password, nil
+	}
+	if err := u.refreshCredentials(ctx, ""); err != nil {
+		return "", err
+	}
+	return u.credentials(ctx)
+}
+
+// refreshCredentials runs the credential helper to get a new password, unless
+// another upload already replaced the stale one.
+func (u *ArtifactoryUploader) refreshCredentials(ctx context.Context, stale string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.password != stale {
+		return nil
 	}
-	req.Header.Add("X-Checksum-SHA256", sha256Checksum)
 
-	res, err := agenthttp.Do(u.logger, u.client, req,
-		agenthttp.WithDebugHTTP(u.conf.DebugHTTP),
-		agenthttp.WithTraceHTTP(u.conf.TraceHTTP),
-	)
+	cmd := exec.CommandContext(ctx, u.credentialHelper[0], u.credentialHelper[1:]...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("running the Artifactory credential helper: %w", err)
 	}
-	return nil, checkResponse(res)
+	password := strings.TrimSpace(string(out))
+	if password == "" {
+		return errors.New("the Artifactory credential helper didn't print any credentials")
+	}
+	u.password = password
+	return nil
 }
 
-func checksumFile(hasher hash.Hash, path string) (string, error) {
+// artifactoryChecksums returns the checksum headers Artifactory verifies the
+// upload with, and deploys by checksum with.
+func artifactoryChecksums(path string) (map[string]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer f.Close()
-	if _, err := io.Copy(hasher, f); err != nil {
-		return "", err
-	}
 
-	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), f); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"X-Checksum-MD5":    fmt.Sprintf("%x", md5Hash.Sum(nil)),
+		"X-Checksum-SHA1":   fmt.Sprintf("%x", sha1Hash.Sum(nil)),
+		"X-Checksum-SHA256": fmt.Sprintf("%x", sha256Hash.Sum(nil)),
+	}, nil
 }
 
 func sha1File(path string) ([]byte, error) {
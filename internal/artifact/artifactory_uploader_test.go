@@ -1,7 +1,20 @@
 package artifact
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestParseArtifactoryDestination(t *testing.T) {
@@ -26,3 +39,144 @@ func TestParseArtifactoryDestination(t *testing.T) {
 		}
 	}
 }
+
+// artifactoryUploadTest uploads a file to a fake Artifactory that responds to
+// each request with handler.
+func artifactoryUploadTest(t *testing.T, env map[string]string, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	t.Setenv("BUILDKITE_ARTIFACTORY_URL", server.URL)
+	t.Setenv("BUILDKITE_ARTIFACTORY_USER", "")
+	t.Setenv("BUILDKITE_ARTIFACTORY_PASSWORD", "")
+	t.Setenv("BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER", "")
+	t.Setenv("BUILDKITE_ARTIFACTORY_CHECKSUM_DEPLOY", "")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	path := filepath.Join(t.TempDir(), "llamas.txt")
+	if err := os.WriteFile(path, []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	uploader, err := NewArtifactoryUploader(logger.Discard, ArtifactoryUploaderConfig{Destination: "rt://repo/builds"})
+	if err != nil {
+		t.Fatalf("NewArtifactoryUploader() error = %v", err)
+	}
+	work, err := uploader.CreateWork(&api.Artifact{Path: "llamas.txt", AbsolutePath: path})
+	if err != nil {
+		t.Fatalf("uploader.CreateWork() error = %v", err)
+	}
+	if _, err := work[0].DoWork(context.Background()); err != nil {
+		t.Errorf("work.DoWork() error = %v", err)
+	}
+}
+
+func TestArtifactoryUploaderWaitsWhenThrottled(t *testing.T) {
+	var bodies []string
+	artifactoryUploadTest(t, map[string]string{
+		"BUILDKITE_ARTIFACTORY_USER":     "carol-danvers",
+		"BUILDKITE_ARTIFACTORY_PASSWORD": "xxx",
+	}, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	if diff := cmp.Diff(bodies, []string{"llamas", "llamas", "llamas"}); diff != "" {
+		t.Errorf("request bodies diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestArtifactoryUploaderChecksumDeploy(t *testing.T) {
+	for _, known := range []bool{true, false} {
+		t.Run(fmt.Sprintf("known=%t", known), func(t *testing.T) {
+			var requests []string
+			artifactoryUploadTest(t, map[string]string{
+				"BUILDKITE_ARTIFACTORY_USER":            "carol-danvers",
+				"BUILDKITE_ARTIFACTORY_PASSWORD":        "xxx",
+				"BUILDKITE_ARTIFACTORY_CHECKSUM_DEPLOY": "true",
+			}, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				requests = append(requests, fmt.Sprintf("deploy=%s body=%s", r.Header.Get("X-Checksum-Deploy"), body))
+				if r.Header.Get("X-Checksum-SHA256") == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if r.Header.Get("X-Checksum-Deploy") == "true" && !known {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			want := []string{"deploy=true body="}
+			if !known {
+				want = append(want, "deploy= body=llamas")
+			}
+			if diff := cmp.Diff(requests, want); diff != "" {
+				t.Errorf("requests diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestArtifactoryUploaderRefreshesCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the credential helper is a shell command")
+	}
+
+	var auths []string
+	artifactoryUploadTest(t, map[string]string{
+		"BUILDKITE_ARTIFACTORY_PASSWORD":          "old-token",
+		"BUILDKITE_ARTIFACTORY_CREDENTIAL_HELPER": "echo new-token",
+	}, func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	if diff := cmp.Diff(auths, []string{"Bearer old-token", "Bearer new-token"}); diff != "" {
+		t.Errorf("Authorization headers diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestArtifactoryRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		retryAfter string
+		attempt    int
+		want       time.Duration
+	}{
+		{retryAfter: "7", attempt: 1, want: 7 * time.Second},
+		{retryAfter: now.Add(30 * time.Second).Format(http.TimeFormat), attempt: 1, want: 30 * time.Second},
+		{retryAfter: "", attempt: 1, want: time.Second},
+		{retryAfter: "soon", attempt: 3, want: 4 * time.Second},
+		{retryAfter: "", attempt: 9, want: time.Minute},
+		{retryAfter: "86400", attempt: 1, want: artifactoryMaxRetryAfter},
+		{retryAfter: "99999999999999999", attempt: 1, want: artifactoryMaxRetryAfter},
+		{retryAfter: now.Add(24 * time.Hour).Format(http.TimeFormat), attempt: 1, want: artifactoryMaxRetryAfter},
+	}
+	for _, test := range tests {
+		h := http.Header{}
+		if test.retryAfter != "" {
+			h.Set("Retry-After", test.retryAfter)
+		}
+		if got := artifactoryRetryAfter(h, test.attempt, now); got != test.want {
+			t.Errorf("artifactoryRetryAfter(Retry-After: %q, %d, now) = %v, want %v", test.retryAfter, test.attempt, got, test.want)
+		}
+	}
+}
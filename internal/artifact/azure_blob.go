@@ -12,15 +12,18 @@ import (
 	"github.com/buildkite/agent/v3/logger"
 )
 
-// The domain suffix for Azure Blob storage.
-const azureBlobHostSuffix = ".blob.core.windows.net"
+const (
+	// The domain suffix for Azure Blob storage.
+	azureBlobHostSuffix = ".blob.core.windows.net"
+
+	// The scheme for short-form Azure Blob storage locations, for example
+	// azblob://my-container/some/path. The storage account name is taken from
+	// BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT.
+	azureBlobScheme = "azblob://"
+)
 
 // NewAzureBlobClient creates a new Azure Blob Storage client.
 func NewAzureBlobClient(l logger.Logger, storageAccountName string) (*service.Client, error) {
-
-	// TODO: Other credential types?
-	// https://pkg.go.dev/github.com/Azure/azure-sdk-for-go/sdk/azidentity#readme-credential-types
-
 	if connStr := os.Getenv("BUILDKITE_AZURE_BLOB_CONNECTION_STRING"); connStr != "" {
 		l.Debug("Connecting to Azure Blob Storage using Connection String")
 		client, err := service.NewClientFromConnectionString(connStr, nil)
@@ -45,6 +48,32 @@ func NewAzureBlobClient(l logger.Logger, storageAccountName string) (*service.Cl
 		return client, nil
 	}
 
+	if sasToken := os.Getenv("BUILDKITE_AZURE_BLOB_SAS_TOKEN"); sasToken != "" {
+		l.Debug("Connecting to Azure Blob Storage using Shared Access Signature token")
+		client, err := service.NewClientWithNoCredential(url+"?"+strings.TrimPrefix(sasToken, "?"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("creating Azure Blob storage client with a SAS token: %w", err)
+		}
+		return client, nil
+	}
+
+	if clientID := os.Getenv("BUILDKITE_AZURE_BLOB_MANAGED_IDENTITY_CLIENT_ID"); clientID != "" {
+		l.Debug("Connecting to Azure Blob Storage using Managed Identity %s", clientID)
+		cred, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(clientID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating Azure managed identity credential: %w", err)
+		}
+		client, err := service.NewClient(url, cred, nil)
+		if err != nil {
+			return nil, fmt.Errorf("creating Azure Blob storage client with a managed identity credential: %w", err)
+		}
+		return client, nil
+	}
+
+	// The default credential includes the system-assigned managed identity,
+	// if there is one.
 	l.Debug("Connecting to Azure Blob Storage using Default Azure Credential")
 	cred, err := azidentity.NewDefaultAzureCredential(nil)
 	if err != nil {
@@ -80,11 +109,27 @@ func (l *AzureBlobLocation) String() string {
 }
 
 // ParseAzureBlobLocation parses a URL into an Azure Blob Storage location.
+// Either the full https://<account>.blob.core.windows.net/<container>/<path>
+// form, or the short azblob://<container>/<path> form, is accepted.
 func ParseAzureBlobLocation(loc string) (*AzureBlobLocation, error) {
 	u, err := url.Parse(loc)
 	if err != nil {
 		return nil, fmt.Errorf("parsing location: %w", err)
 	}
+	if u.Scheme+"://" == azureBlobScheme {
+		san := os.Getenv("BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT")
+		if san == "" {
+			return nil, fmt.Errorf("parsing location: %s locations require BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT to be set", azureBlobScheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("parsing location: want container name, got %q", loc)
+		}
+		return &AzureBlobLocation{
+			StorageAccountName: san,
+			ContainerName:      u.Host,
+			BlobPath:           strings.TrimPrefix(u.Path, "/"),
+		}, nil
+	}
 	if u.Scheme != "https" {
 		return nil, fmt.Errorf("parsing location: want https:// scheme, got %q", u.Scheme)
 	}
@@ -105,6 +150,9 @@ func ParseAzureBlobLocation(loc string) (*AzureBlobLocation, error) {
 
 // IsAzureBlobPath reports if the location is an Azure Blob Storage path.
 func IsAzureBlobPath(loc string) bool {
+	if strings.HasPrefix(loc, azureBlobScheme) {
+		return true
+	}
 	_, err := ParseAzureBlobLocation(loc)
 	return err == nil
 }
@@ -2,8 +2,12 @@ package artifact
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/buildkite/agent/v3/logger"
@@ -15,6 +19,7 @@ type AzureBlobDownloaderConfig struct {
 	Repository  string
 	Destination string
 	Retries     int
+	Concurrency int
 	DebugHTTP   bool
 	TraceHTTP   bool
 }
@@ -47,7 +52,14 @@ func (d *AzureBlobDownloader) Start(ctx context.Context) error {
 		return err
 	}
 
-	f, err := os.Create(d.conf.Path)
+	targetPath := targetPath(ctx, d.conf.Path, d.conf.Destination)
+
+	// Actual file permissions will be reduced by umask
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", targetPath, err)
+	}
+
+	f, err := os.Create(targetPath)
 	if err != nil {
 		return err
 	}
@@ -56,13 +68,16 @@ func (d *AzureBlobDownloader) Start(ctx context.Context) error {
 	fullPath := path.Join(loc.BlobPath, d.conf.Path)
 
 	// Show a nice message that we're starting to download the file
-	d.logger.Debug("Downloading %s to %s", loc.URL(d.conf.Path), d.conf.Path)
+	d.logger.Debug("Downloading %s to %s", loc.URL(d.conf.Path), targetPath)
 
 	opts := &azblob.DownloadFileOptions{
 		RetryReaderOptionsPerBlock: azblob.RetryReaderOptions{
 			MaxRetries: int32(d.conf.Retries),
 		},
 	}
+	if d.conf.Concurrency > 1 {
+		opts.Concurrency = uint16(min(d.conf.Concurrency, math.MaxUint16))
+	}
 	bc := client.NewContainerClient(loc.ContainerName).NewBlobClient(fullPath)
 	if _, err := bc.DownloadFile(ctx, f, opts); err != nil {
 		return err
@@ -70,3 +85,32 @@ func (d *AzureBlobDownloader) Start(ctx context.Context) error {
 
 	return f.Close()
 }
+
+// Stream downloads the file and writes it to w. Reads that fail part way
+// through are retried from where they left off.
+func (d *AzureBlobDownloader) Stream(ctx context.Context, w io.Writer) error {
+	loc, err := ParseAzureBlobLocation(d.conf.Repository)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewAzureBlobClient(d.logger, loc.StorageAccountName)
+	if err != nil {
+		return err
+	}
+
+	d.logger.Debug("Downloading %s", loc.URL(d.conf.Path))
+
+	bc := client.NewContainerClient(loc.ContainerName).NewBlobClient(path.Join(loc.BlobPath, d.conf.Path))
+	resp, err := bc.DownloadStream(ctx, nil)
+	if err != nil {
+		return err
+	}
+	body := resp.NewRetryReader(ctx, &azblob.RetryReaderOptions{
+		MaxRetries: int32(d.conf.Retries),
+	})
+	defer body.Close()
+
+	_, err = io.Copy(w, body)
+	return err
+}
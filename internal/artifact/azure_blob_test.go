@@ -129,3 +129,41 @@ func TestAzureBlobLocationURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAzureBlobLocation_ShortForm(t *testing.T) {
+	t.Setenv("BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT", "asdf")
+
+	input := "azblob://my-container/some-directory/blob.txt"
+	got, err := ParseAzureBlobLocation(input)
+	if err != nil {
+		t.Fatalf("ParseAzureBlobLocation(%q) error = %v", input, err)
+	}
+
+	want := &AzureBlobLocation{
+		StorageAccountName: "asdf",
+		ContainerName:      "my-container",
+		BlobPath:           "some-directory/blob.txt",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("parsed AzureBlobLocation diff (-got +want):\n%s", diff)
+	}
+
+	if got, want := got.URL(""), "https://asdf.blob.core.windows.net/my-container/some-directory/blob.txt"; got != want {
+		t.Errorf("AzureBlobLocation.URL(\"\") = %q, want %q", got, want)
+	}
+}
+
+func TestParseAzureBlobLocation_ShortFormWithoutAccount(t *testing.T) {
+	t.Setenv("BUILDKITE_AZURE_BLOB_STORAGE_ACCOUNT", "")
+
+	input := "azblob://my-container/blob.txt"
+	if _, err := ParseAzureBlobLocation(input); err == nil {
+		t.Errorf("ParseAzureBlobLocation(%q) error = %v, want non-nil error", input, err)
+	}
+
+	// It's still recognised as an Azure path, so that the error above is
+	// reported rather than an "invalid destination" error.
+	if !IsAzureBlobPath(input) {
+		t.Errorf("IsAzureBlobPath(%q) = false, want true", input)
+	}
+}
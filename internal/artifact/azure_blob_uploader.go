@@ -17,6 +17,7 @@ import (
 type AzureBlobUploaderConfig struct {
 	// The destination which includes the storage account name and the path.
 	// For example, "https://my-storage-account.blob.core.windows.net/my-container/my-virtual-directory/artifacts-go-here/"
+	// or "azblob://my-container/my-virtual-directory/artifacts-go-here/".
 	Destination string
 }
 
@@ -9,6 +9,15 @@ import (
 	"github.com/buildkite/roko"
 )
 
+const (
+	// How many artifacts are created on Buildkite in each request.
+	artifactBatchSize = 30
+
+	// How long an upload waits for a batch of artifacts to fill before
+	// creating the artifacts it has.
+	artifactBatchWait = time.Second
+)
+
 type BatchCreatorConfig struct {
 	// The ID of the Job that these artifacts belong to
 	JobID string
@@ -48,7 +57,7 @@ func NewArtifactBatchCreator(l logger.Logger, ac APIClient, c BatchCreatorConfig
 
 func (a *BatchCreator) Create(ctx context.Context) ([]*api.Artifact, error) {
 	length := len(a.conf.Artifacts)
-	chunks := 30
+	chunks := artifactBatchSize
 
 	// Split into the artifacts into chunks so we're not uploading a ton of
 	// files at once.
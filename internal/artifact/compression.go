@@ -0,0 +1,476 @@
+package artifact
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mattn/go-zglob"
+)
+
+// Compression formats for bundling artifacts into a single archive before
+// upload. Uploading one archive is much faster than uploading many small files
+// one request at a time.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// CompressionFormats are the supported compression formats.
+var CompressionFormats = []string{CompressionGzip, CompressionZstd}
+
+// The Content-Type of a bundle records its compression format, so that
+// downloads know to extract it.
+var bundleContentTypes = map[string]string{
+	CompressionGzip: "application/vnd.buildkite.artifact-bundle+gzip",
+	CompressionZstd: "application/vnd.buildkite.artifact-bundle+zstd",
+}
+
+var bundleExtensions = map[string]string{
+	CompressionGzip: ".tar.gz",
+	CompressionZstd: ".tar.zst",
+}
+
+// bundleFormat returns the compression format of a bundle with the given
+// Content-Type, or false if it isn't a bundle.
+func bundleFormat(contentType string) (string, bool) {
+	for format, ct := range bundleContentTypes {
+		if contentType == ct {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// bundlePath returns the artifact path for a bundle of files with the given
+// artifact paths. It's named after the directory they have in common, along
+// with id, so that bundles uploaded from the same directory don't replace each
+// other.
+func bundlePath(format, id string, paths []string) string {
+	var common []string
+	for i, p := range paths {
+		dir := strings.Split(path.Dir(filepath.ToSlash(p)), "/")
+		if i == 0 {
+			common = dir
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dir) && common[n] == dir[n] {
+			n++
+		}
+		common = common[:n]
+	}
+
+	name := path.Join(common...)
+	if name == "" || name == "." || name == "/" {
+		name = "artifacts"
+	}
+	return name + "-" + id + bundleExtensions[format]
+}
+
+// bundleQueries returns the search queries for the bundles that could hold
+// files matching query. Those are the bundles named after each directory that
+// the files could be in, up to the first directory with a wildcard in it, and
+// those named after no directory at all.
+func bundleQueries(query string) []string {
+	queries := []string{"artifacts-*.tar.*"}
+	parts := strings.Split(filepath.ToSlash(query), "/")
+	for i := 1; i < len(parts); i++ {
+		if strings.ContainsAny(parts[i-1], "*?[{") {
+			break
+		}
+		if dir := path.Join(parts[:i]...); dir != "" && dir != "." {
+			queries = append(queries, dir+"-*.tar.*")
+		}
+	}
+	return queries
+}
+
+// inBundleMatches reports whether the file with the given path in a bundle
+// matches query.
+//
+// Note: Buildkite matches queries against artifact paths itself, and this
+// only approximates it, with the same globbing as artifact upload.
+func inBundleMatches(query, name string) bool {
+	query = filepath.ToSlash(query)
+	if query == name {
+		return true
+	}
+	ok, _ := zglob.Match(query, name)
+	return ok
+}
+
+// bundleFile is a file to add to a bundle.
+type bundleFile struct {
+	// The path within the bundle
+	path string
+
+	// The path of the file to read
+	absolutePath string
+
+	// Whether to add the file as a symlink, if it is one, rather than the
+	// file it links to
+	symlink bool
+}
+
+// writeBundle writes a compressed tar archive of files to a new temporary file
+// in dir, and returns its path. The caller is responsible for removing it.
+func writeBundle(format, dir string, files []bundleFile) (string, error) {
+	out, err := os.CreateTemp(dir, "bundle.*"+bundleExtensions[format])
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	defer out.Close()
+
+	if err := writeBundleTo(format, out, files); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("closing bundle: %w", err)
+	}
+	return out.Name(), nil
+}
+
+func writeBundleTo(format string, w io.Writer, files []bundleFile) error {
+	var cw io.WriteCloser
+	switch format {
+	case CompressionGzip:
+		cw = gzip.NewWriter(w)
+
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("creating zstd writer: %w", err)
+		}
+		cw = zw
+
+	default:
+		return fmt.Errorf("unsupported compression format %q", format)
+	}
+
+	tw := tar.NewWriter(cw)
+	links := &hardLinks{bySize: make(map[int64][]linkedFile)}
+	for _, f := range files {
+		if err := addToBundle(tw, f, links); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("closing tar writer: %w", err)
+	}
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("closing %s writer: %w", format, err)
+	}
+	return nil
+}
+
+func addToBundle(tw *tar.Writer, f bundleFile, links *hardLinks) error {
+	if f.symlink {
+		fi, err := os.Lstat(f.absolutePath)
+		if err != nil {
+			return fmt.Errorf("reading file info for %s: %w", f.absolutePath, err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return addSymlinkToBundle(tw, f, fi)
+		}
+	}
+
+	in, err := os.Open(f.absolutePath)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", f.absolutePath, err)
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("reading file info for %s: %w", f.absolutePath, err)
+	}
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(f.path),
+		Size:     fi.Size(),
+		Mode:     int64(fi.Mode().Perm()),
+		ModTime:  fi.ModTime(),
+	}
+
+	// A hard link to a file that's already in the bundle is added as a link
+	// to it, rather than another copy of it.
+	if name, ok := links.find(fi); ok {
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = name
+		hdr.Size = 0
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("writing tar header for %s: %w", f.path, err)
+		}
+		return nil
+	}
+	links.add(hdr.Name, fi)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing tar header for %s: %w", f.path, err)
+	}
+	if _, err := io.Copy(tw, in); err != nil {
+		return fmt.Errorf("adding %s to bundle: %w", f.absolutePath, err)
+	}
+	return nil
+}
+
+func addSymlinkToBundle(tw *tar.Writer, f bundleFile, fi os.FileInfo) error {
+	target, err := os.Readlink(f.absolutePath)
+	if err != nil {
+		return fmt.Errorf("reading symlink %s: %w", f.absolutePath, err)
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     filepath.ToSlash(f.path),
+		Linkname: filepath.ToSlash(target),
+		Mode:     int64(fi.Mode().Perm()),
+		ModTime:  fi.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing tar header for %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// hardLinks remembers the files added to a bundle, so that hard links to them
+// can be recognised.
+type hardLinks struct {
+	bySize map[int64][]linkedFile
+}
+
+// linkedFile is a file in a bundle that might have other hard links to it.
+type linkedFile struct {
+	name string
+	fi   os.FileInfo
+}
+
+// find returns the name in the bundle of the same file as fi, if it has been
+// added.
+func (h *hardLinks) find(fi os.FileInfo) (string, bool) {
+	for _, f := range h.bySize[fi.Size()] {
+		if os.SameFile(f.fi, fi) {
+			return f.name, true
+		}
+	}
+	return "", false
+}
+
+// add remembers that fi has been added to the bundle as name.
+func (h *hardLinks) add(name string, fi os.FileInfo) {
+	h.bySize[fi.Size()] = append(h.bySize[fi.Size()], linkedFile{name: name, fi: fi})
+}
+
+// extractBundle extracts the files in the bundle at bundle into destination,
+// then removes the bundle. Files are placed as if they had been downloaded
+// individually.
+func extractBundle(ctx context.Context, format, bundle, destination string) error {
+	in, err := os.Open(bundle)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", bundle, err)
+	}
+	defer in.Close()
+
+	if err := extractArchive(ctx, format, in, destination, nil); err != nil {
+		return err
+	}
+
+	in.Close()
+	return os.Remove(bundle)
+}
+
+// archiveFormat returns the compression format of a tar archive with the given
+// path, or false if it isn't one. The format of an uncompressed tar archive is
+// empty.
+func archiveFormat(p string) (string, bool) {
+	switch {
+	case strings.HasSuffix(p, ".tar"):
+		return "", true
+	case strings.HasSuffix(p, ".tar.gz"), strings.HasSuffix(p, ".tgz"):
+		return CompressionGzip, true
+	case strings.HasSuffix(p, ".tar.zst"), strings.HasSuffix(p, ".tzst"):
+		return CompressionZstd, true
+	default:
+		return "", false
+	}
+}
+
+// extractArchive extracts the regular files, hard links and symlinks in the
+// tar archive read from in, compressed with format, into destination. Files
+// are placed as if they had been downloaded individually. If keep isn't nil,
+// only the files whose names it keeps are extracted.
+func extractArchive(ctx context.Context, format string, in io.Reader, destination string, keep func(name string) bool) error {
+	var r io.Reader
+	switch format {
+	case "":
+		r = in
+
+	case CompressionGzip:
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("reading gzip header: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+
+	case CompressionZstd:
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("creating zstd reader: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+
+	default:
+		return fmt.Errorf("unsupported compression format %q", format)
+	}
+
+	// Symlinks are made once everything else has been extracted, so that no
+	// file is written through one made by the archive. Nothing is written
+	// through a symlink that was already in destination either.
+	var symlinks []*tar.Header
+
+	// The files that weren't kept, which hard links can't be made to.
+	skipped := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if keep != nil && !keep(hdr.Name) {
+			skipped[hdr.Name] = true
+			continue
+		}
+		if hdr.Typeflag == tar.TypeLink && skipped[hdr.Linkname] {
+			return fmt.Errorf("can't extract %s, as it is a hard link to %s, which isn't being extracted", hdr.Name, hdr.Linkname)
+		}
+		target := targetPath(ctx, filepath.FromSlash(hdr.Name), destination)
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeLink, tar.TypeSymlink:
+			if err := checkExtractTarget(destination, target); err != nil {
+				return err
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if err := extractFile(tr, target, hdr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			existing := targetPath(ctx, filepath.FromSlash(hdr.Linkname), destination)
+			if err := checkExtractTarget(destination, existing); err != nil {
+				return err
+			}
+			if err := extractHardLink(existing, target); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			symlinks = append(symlinks, hdr)
+		}
+	}
+
+	for _, hdr := range symlinks {
+		target := targetPath(ctx, filepath.FromSlash(hdr.Name), destination)
+		if err := checkExtractTarget(destination, target); err != nil {
+			return err
+		}
+		if err := extractSymlink(target, destination, hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExtractTarget returns an error if target is outside destination, or if
+// any directory on the way to it from destination is a symlink, which could
+// lead outside destination.
+func checkExtractTarget(destination, target string) error {
+	rel, err := filepath.Rel(destination, target)
+	if err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("not extracting %s, as it is outside %s", target, destination)
+	}
+	dir := destination
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		if isSymlink(dir) {
+			return fmt.Errorf("not extracting %s, as %s is a symlink", target, dir)
+		}
+	}
+	return nil
+}
+
+// extractHardLink links target to the already extracted file at existing.
+func extractHardLink(existing, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", target, err)
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("replacing %s: %w", target, err)
+	}
+	if err := os.Link(existing, target); err != nil {
+		return fmt.Errorf("linking %s to %s: %w", target, existing, err)
+	}
+	return nil
+}
+
+// extractSymlink makes a symlink at target, as long as what it links to is
+// within destination.
+func extractSymlink(target, destination string, hdr *tar.Header) error {
+	linkname := filepath.FromSlash(hdr.Linkname)
+	if filepath.IsAbs(linkname) || path.IsAbs(hdr.Linkname) {
+		return fmt.Errorf("not extracting symlink %s to %s, as it is outside %s", target, hdr.Linkname, destination)
+	}
+	if err := checkExtractTarget(destination, filepath.Join(filepath.Dir(target), linkname)); err != nil {
+		return fmt.Errorf("not extracting symlink %s to %s: %w", target, hdr.Linkname, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", target, err)
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("replacing %s: %w", target, err)
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return fmt.Errorf("creating symlink %s: %w", target, err)
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", target, err)
+	}
+	// Replace a symlink, rather than writing to what it links to.
+	if isSymlink(target) {
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("replacing %s: %w", target, err)
+		}
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", target, err)
+	}
+	defer out.Close()
+
+	if err := writeSparse(out, r); err != nil {
+		return fmt.Errorf("extracting %s: %w", target, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,312 @@
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for _, format := range CompressionFormats {
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			src := t.TempDir()
+			files := map[string]string{
+				"log/a.log":        "llamas",
+				"log/nested/b.log": "alpacas",
+				"log/empty.log":    "",
+			}
+			var bundleFiles []bundleFile
+			for name, content := range files {
+				path := filepath.Join(src, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+					t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+					t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+				}
+				bundleFiles = append(bundleFiles, bundleFile{path: name, absolutePath: path})
+			}
+
+			bundle, err := writeBundle(format, t.TempDir(), bundleFiles)
+			if err != nil {
+				t.Fatalf("writeBundle(%q, ...) error = %v", format, err)
+			}
+
+			dest := t.TempDir()
+			if err := extractBundle(ctx, format, bundle, dest); err != nil {
+				t.Fatalf("extractBundle(ctx, %q, %q, %q) error = %v", format, bundle, dest, err)
+			}
+
+			for name, want := range files {
+				got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatalf("os.ReadFile(%q) error = %v", name, err)
+				}
+				if string(got) != want {
+					t.Errorf("extracted %q = %q, want %q", name, got, want)
+				}
+			}
+
+			if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+				t.Errorf("os.Stat(%q) error = %v, want the bundle to be removed", bundle, err)
+			}
+		})
+	}
+}
+
+func TestBundlePath(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format string
+		paths  []string
+		want   string
+	}{
+		{CompressionZstd, []string{"log/a.log", "log/nested/b.log"}, "log-1a2b3c4d.tar.zst"},
+		{CompressionGzip, []string{"tmp/log/a.log", "tmp/log/b.log"}, "tmp/log-1a2b3c4d.tar.gz"},
+		{CompressionZstd, []string{"log/a.log", "coverage/b.out"}, "artifacts-1a2b3c4d.tar.zst"},
+		{CompressionZstd, []string{"a.log"}, "artifacts-1a2b3c4d.tar.zst"},
+	}
+	for _, test := range tests {
+		if got := bundlePath(test.format, "1a2b3c4d", test.paths); got != test.want {
+			t.Errorf("bundlePath(%q, %q, %q) = %q, want %q", test.format, "1a2b3c4d", test.paths, got, test.want)
+		}
+	}
+}
+
+func TestBundleQueries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"a.log", []string{"artifacts-*.tar.*"}},
+		{"tmp/log/a.log", []string{"artifacts-*.tar.*", "tmp-*.tar.*", "tmp/log-*.tar.*"}},
+		{"tmp/*/a.log", []string{"artifacts-*.tar.*", "tmp-*.tar.*"}},
+		{"*.log", []string{"artifacts-*.tar.*"}},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(bundleQueries(test.query), test.want); diff != "" {
+			t.Errorf("bundleQueries(%q) diff (-got +want):\n%s", test.query, diff)
+		}
+	}
+}
+
+func TestExtractArchiveKeepsMatchingFiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src := t.TempDir()
+	var files []bundleFile
+	for _, name := range []string{"log/a.log", "log/b.txt", "log/nested/c.log"} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+		}
+		files = append(files, bundleFile{path: name, absolutePath: path})
+	}
+
+	var buf bytes.Buffer
+	if err := writeBundleTo(CompressionGzip, &buf, files); err != nil {
+		t.Fatalf("writeBundleTo(...) error = %v", err)
+	}
+
+	dest := t.TempDir()
+	keep := func(name string) bool { return inBundleMatches("log/*.log", name) }
+	if err := extractArchive(ctx, CompressionGzip, &buf, dest, keep); err != nil {
+		t.Fatalf("extractArchive(...) error = %v", err)
+	}
+
+	for name, want := range map[string]bool{"log/a.log": true, "log/b.txt": false, "log/nested/c.log": false} {
+		_, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if got := err == nil; got != want {
+			t.Errorf("after extracting, %s exists = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestBundleFormat(t *testing.T) {
+	t.Parallel()
+
+	if got, ok := bundleFormat(bundleContentTypes[CompressionZstd]); !ok || got != CompressionZstd {
+		t.Errorf("bundleFormat(zstd content type) = (%q, %t), want (%q, true)", got, ok, CompressionZstd)
+	}
+	if got, ok := bundleFormat("application/gzip"); ok {
+		t.Errorf("bundleFormat(%q) = (%q, true), want false", "application/gzip", got)
+	}
+}
+
+func TestBundleLinks(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	ctx := context.Background()
+	src := t.TempDir()
+	target := filepath.Join(src, "a.log")
+	if err := os.WriteFile(target, []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", target, err)
+	}
+	if err := os.Link(target, filepath.Join(src, "b.log")); err != nil {
+		t.Fatalf("os.Link(a.log, b.log) error = %v", err)
+	}
+	if err := os.Symlink("a.log", filepath.Join(src, "c.log")); err != nil {
+		t.Fatalf("os.Symlink(a.log, c.log) error = %v", err)
+	}
+
+	var files []bundleFile
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		files = append(files, bundleFile{path: "log/" + name, absolutePath: filepath.Join(src, name), symlink: true})
+	}
+	bundle, err := writeBundle(CompressionGzip, t.TempDir(), files)
+	if err != nil {
+		t.Fatalf("writeBundle(...) error = %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := extractBundle(ctx, CompressionGzip, bundle, dest); err != nil {
+		t.Fatalf("extractBundle(...) error = %v", err)
+	}
+
+	a, err := os.Stat(filepath.Join(dest, "log", "a.log"))
+	if err != nil {
+		t.Fatalf("os.Stat(a.log) error = %v", err)
+	}
+	b, err := os.Stat(filepath.Join(dest, "log", "b.log"))
+	if err != nil {
+		t.Fatalf("os.Stat(b.log) error = %v", err)
+	}
+	if !os.SameFile(a, b) {
+		t.Errorf("extracted b.log isn't a hard link to a.log")
+	}
+	if got, err := os.Readlink(filepath.Join(dest, "log", "c.log")); err != nil || got != "a.log" {
+		t.Errorf("os.Readlink(c.log) = (%q, %v), want (%q, nil)", got, err, "a.log")
+	}
+}
+
+func TestExtractMaliciousArchive(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+		// A symlink already in the destination, from its name to its target
+		existingLink []string
+	}{
+		{
+			name:    "absolute symlink",
+			entries: []*tar.Header{{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		},
+		{
+			name:    "relative symlink out of the destination",
+			entries: []*tar.Header{{Name: "dir/up", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+		},
+		{
+			name:    "hard link out of the destination",
+			entries: []*tar.Header{{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}},
+		},
+		{
+			name:         "write through a symlinked directory",
+			entries:      []*tar.Header{{Name: "sub/file", Typeflag: tar.TypeReg, Mode: 0o644}},
+			existingLink: []string{"sub", "OUTSIDE"},
+		},
+		{
+			name:         "symlink in a symlinked directory",
+			entries:      []*tar.Header{{Name: "sub/link", Typeflag: tar.TypeSymlink, Linkname: "file"}},
+			existingLink: []string{"sub", "OUTSIDE"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			outside := filepath.Join(root, "outside")
+			if err := os.Mkdir(outside, 0o777); err != nil {
+				t.Fatalf("os.Mkdir(%q) error = %v", outside, err)
+			}
+			dest := filepath.Join(root, "dest")
+			if err := os.Mkdir(dest, 0o777); err != nil {
+				t.Fatalf("os.Mkdir(%q) error = %v", dest, err)
+			}
+			if test.existingLink != nil {
+				name, target := test.existingLink[0], test.existingLink[1]
+				if target == "OUTSIDE" {
+					target = outside
+				}
+				if err := os.Symlink(target, filepath.Join(dest, name)); err != nil {
+					t.Fatalf("os.Symlink(%q, %q) error = %v", target, name, err)
+				}
+			}
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range test.entries {
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatalf("tw.WriteHeader(%q) error = %v", hdr.Name, err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("tw.Close() error = %v", err)
+			}
+
+			if err := extractArchive(ctx, "", &buf, dest, nil); err == nil {
+				t.Errorf("extractArchive(ctx, \"\", malicious archive, %q) error = nil, want an error", dest)
+			}
+			if entries, err := os.ReadDir(outside); err != nil || len(entries) > 0 {
+				t.Errorf("os.ReadDir(outside) = (%v, %v), want nothing written outside the destination", entries, err)
+			}
+		})
+	}
+}
+
+func TestWriteSparse(t *testing.T) {
+	t.Parallel()
+
+	data := bytes.Repeat([]byte{0}, 3*sparseBlockSize+10)
+	copy(data[sparseBlockSize:], "llamas")
+
+	path := filepath.Join(t.TempDir(), "sparse")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create(%q) error = %v", path, err)
+	}
+	defer f.Close()
+	if err := writeSparse(f, bytes.NewReader(data)); err != nil {
+		t.Fatalf("writeSparse(...) error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("f.Close() error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", path, err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("writeSparse wrote %d bytes that differ from the %d written", len(got), len(data))
+	}
+}
@@ -46,6 +46,14 @@ type DownloadConfig struct {
 	// Hexadecimal(SHA256(content)) used to verify the downloaded contents, if not empty
 	WantSHA256 string
 
+	// How many ranged chunks of the file to download in parallel. Values
+	// above 1 enable chunked downloads, if the server supports range requests.
+	Concurrency int
+
+	// Whether to keep partially downloaded files, and resume them on the next
+	// attempt (including from a later invocation). Implies chunked downloads.
+	Resume bool
+
 	// If failed responses should be dumped to the log
 	// Standard HTTP options.
 	DebugHTTP bool
@@ -123,16 +131,12 @@ func targetPath(ctx context.Context, dlPath, destPath string) string {
 	return filepath.Join(destPath, dlPath)
 }
 
-func (d Download) try(ctx context.Context) error {
-	targetPath := targetPath(ctx, d.conf.Path, d.conf.Destination)
-	targetDirectory, targetFile := filepath.Split(targetPath)
-
-	// Show a nice message that we're starting to download the file
-	d.logger.Debug("Downloading %s to %s", d.conf.URL, targetPath)
-
+// get performs a GET request for the download URL. If byteRange is not empty,
+// it is sent as the Range header.
+func (d Download) get(ctx context.Context, byteRange string) (*http.Response, error) {
 	request, err := http.NewRequestWithContext(ctx, "GET", d.conf.URL, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// If no user agent provided, use the agents default [eg buildkite-agent/3.63.0.8023 (linux; amd64)]
@@ -144,13 +148,39 @@ func (d Download) try(ctx context.Context) error {
 		request.Header.Add(k, v)
 	}
 
-	// Start by downloading the file
+	if byteRange != "" {
+		request.Header.Set("Range", byteRange)
+	}
+
 	response, err := agenthttp.Do(d.logger, d.client, request,
 		agenthttp.WithDebugHTTP(d.conf.DebugHTTP),
 		agenthttp.WithTraceHTTP(d.conf.TraceHTTP),
 	)
 	if err != nil {
-		return fmt.Errorf("Error while downloading %s (%T: %w)", d.conf.URL, err, err)
+		return nil, fmt.Errorf("Error while downloading %s (%T: %w)", d.conf.URL, err, err)
+	}
+	return response, nil
+}
+
+func (d Download) try(ctx context.Context) error {
+	targetPath := targetPath(ctx, d.conf.Path, d.conf.Destination)
+	targetDirectory, targetFile := filepath.Split(targetPath)
+
+	if d.conf.Concurrency > 1 || d.conf.Resume {
+		ok, err := d.tryChunked(ctx, targetPath)
+		if ok || err != nil {
+			return err
+		}
+		d.logger.Debug("Server doesn't support range requests for %s, downloading in one piece", d.conf.Path)
+	}
+
+	// Show a nice message that we're starting to download the file
+	d.logger.Debug("Downloading %s to %s", d.conf.URL, targetPath)
+
+	// Start by downloading the file
+	response, err := d.get(ctx, "")
+	if err != nil {
+		return err
 	}
 	defer response.Body.Close()
 
@@ -221,3 +251,65 @@ type downloadError struct {
 func (e *downloadError) Error() string {
 	return e.s
 }
+
+// Stream downloads the file and writes it to w, rather than to the
+// destination. Chunked downloads aren't used, as the file has to be written in
+// order. Failed attempts are only retried if nothing has been written to w.
+func (d Download) Stream(ctx context.Context, w io.Writer) error {
+	cw := &countingWriter{w: w}
+	return roko.NewRetrier(
+		roko.WithMaxAttempts(d.conf.Retries),
+		roko.WithStrategy(roko.Constant(5*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		if err := d.tryStream(ctx, cw); err != nil {
+			if cw.n > 0 {
+				// The start of the file has already been written, so it
+				// can't be downloaded again.
+				r.Break()
+			}
+			d.logger.Warn("Error trying to download %s (%s) %s", d.conf.URL, err, r)
+			return err
+		}
+		return nil
+	})
+}
+
+func (d Download) tryStream(ctx context.Context, w io.Writer) error {
+	d.logger.Debug("Downloading %s", d.conf.URL)
+
+	response, err := d.get(ctx, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 && response.StatusCode/100 != 3 {
+		return &downloadError{response.Status}
+	}
+
+	hash := sha256.New()
+	bytes, err := io.Copy(io.MultiWriter(hash, w), response.Body)
+	if err != nil {
+		return fmt.Errorf("copying data (%T: %w)", err, err)
+	}
+
+	gotSHA256 := hex.EncodeToString(hash.Sum(nil))
+	if d.conf.WantSHA256 != "" && gotSHA256 != d.conf.WantSHA256 {
+		return fmt.Errorf("checksum of downloaded content %s != uploaded checksum %s", gotSHA256, d.conf.WantSHA256)
+	}
+
+	d.logger.Info("Successfully downloaded %q %s with SHA256 %s", d.conf.Path, humanize.IBytes(uint64(bytes)), gotSHA256)
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
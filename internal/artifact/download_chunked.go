@@ -0,0 +1,290 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// The size of each ranged request made by a chunked download.
+const downloadChunkSize = 8 * 1024 * 1024
+
+// downloadResumeState is saved next to a partially downloaded file, so that
+// an interrupted download can continue where it left off.
+type downloadResumeState struct {
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunk_size"`
+	Done      []bool `json:"done"`
+}
+
+// tryChunked downloads the file as a number of ranged requests, possibly in
+// parallel, into a partial file next to the target. It returns false (and no
+// error) if the server doesn't support range requests, in which case the
+// caller should fall back to a regular download.
+func (d Download) tryChunked(ctx context.Context, targetPath string) (bool, error) {
+	// Probe for range support and the total size by asking for the first byte.
+	// (A HEAD request would be nicer, but pre-signed URLs are usually only
+	// valid for GET.)
+	size, err := d.probeSize(ctx)
+	if err != nil {
+		return false, err
+	}
+	if size < 0 {
+		return false, nil
+	}
+
+	partialPath := targetPath + ".partial"
+	statePath := partialPath + ".resume"
+
+	state := d.loadResumeState(statePath, partialPath, size)
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o777); err != nil {
+		return true, fmt.Errorf("creating directory for %s (%T: %w)", targetPath, err, err)
+	}
+
+	f, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return true, fmt.Errorf("opening partial file (%T: %w)", err, err)
+	}
+	defer f.Close()
+
+	cleanup := func() {
+		if d.conf.Resume {
+			return
+		}
+		f.Close()
+		os.Remove(partialPath)
+	}
+
+	if err := f.Truncate(size); err != nil {
+		cleanup()
+		return true, fmt.Errorf("resizing partial file (%T: %w)", err, err)
+	}
+
+	if err := d.downloadChunks(ctx, f, state, statePath); err != nil {
+		cleanup()
+		return true, err
+	}
+
+	// Hash the complete file.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return true, fmt.Errorf("seeking partial file (%T: %w)", err, err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		cleanup()
+		return true, fmt.Errorf("hashing partial file (%T: %w)", err, err)
+	}
+	gotSHA256 := hex.EncodeToString(hash.Sum(nil))
+
+	if d.conf.WantSHA256 != "" && gotSHA256 != d.conf.WantSHA256 {
+		// The partial file is bad, so there's no point resuming it.
+		f.Close()
+		os.Remove(partialPath)
+		os.Remove(statePath)
+		return true, fmt.Errorf("checksum of downloaded content %s != uploaded checksum %s", gotSHA256, d.conf.WantSHA256)
+	}
+
+	if err := f.Chmod(0o666 &^ umask); err != nil {
+		cleanup()
+		return true, fmt.Errorf("setting file permissions (%T: %w)", err, err)
+	}
+
+	if err := f.Close(); err != nil {
+		cleanup()
+		return true, fmt.Errorf("closing partial file (%T: %w)", err, err)
+	}
+
+	if err := os.Rename(partialPath, targetPath); err != nil {
+		cleanup()
+		return true, fmt.Errorf("renaming partial file to target (%T: %w)", err, err)
+	}
+	os.Remove(statePath)
+
+	d.logger.Info("Successfully downloaded %q %s with SHA256 %s", d.conf.Path, humanize.IBytes(uint64(size)), gotSHA256)
+
+	return true, nil
+}
+
+// probeSize returns the total size of the file, or -1 if the server doesn't
+// support range requests.
+func (d Download) probeSize(ctx context.Context) (int64, error) {
+	response, err := d.get(ctx, "bytes=0-0")
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body) //nolint:errcheck // draining is best-effort
+
+	if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Probably an empty file.
+		return -1, nil
+	}
+	if response.StatusCode/100 != 2 && response.StatusCode/100 != 3 {
+		return 0, &downloadError{response.Status}
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		return -1, nil
+	}
+
+	// Content-Range: bytes 0-0/12345
+	_, total, ok := strings.Cut(response.Header.Get("Content-Range"), "/")
+	if !ok || total == "*" {
+		return -1, nil
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size < 0 {
+		return -1, nil
+	}
+	return size, nil
+}
+
+// loadResumeState loads the saved state for a partial download, if resuming
+// is enabled and the saved state matches the file being downloaded.
+// Otherwise it returns fresh state.
+func (d Download) loadResumeState(statePath, partialPath string, size int64) *downloadResumeState {
+	chunks := (size + downloadChunkSize - 1) / downloadChunkSize
+	fresh := &downloadResumeState{
+		Size:      size,
+		ChunkSize: downloadChunkSize,
+		Done:      make([]bool, chunks),
+	}
+
+	if !d.conf.Resume {
+		return fresh
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return fresh
+	}
+	if _, err := os.Stat(partialPath); err != nil {
+		return fresh
+	}
+
+	var state downloadResumeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		d.logger.Warn("Ignoring unreadable resume file %s: %v", statePath, err)
+		return fresh
+	}
+	if state.Size != size || state.ChunkSize != downloadChunkSize || int64(len(state.Done)) != chunks {
+		d.logger.Info("Remote file for %s has changed, restarting download", d.conf.Path)
+		return fresh
+	}
+
+	done := 0
+	for _, ok := range state.Done {
+		if ok {
+			done++
+		}
+	}
+	d.logger.Info("Resuming download of %s (%d of %d chunks already downloaded)", d.conf.Path, done, len(state.Done))
+	return &state
+}
+
+// downloadChunks downloads the chunks not yet done into f, using up to
+// conf.Concurrency parallel requests.
+func (d Download) downloadChunks(ctx context.Context, f *os.File, state *downloadResumeState, statePath string) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	chunksCh := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for range max(d.conf.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunksCh {
+				if err := d.downloadChunk(ctx, f, state, i); err != nil {
+					cancel(err)
+					return
+				}
+
+				mu.Lock()
+				state.Done[i] = true
+				err := d.saveResumeState(state, statePath)
+				mu.Unlock()
+				if err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+
+sendLoop:
+	for i, done := range state.Done {
+		if done {
+			continue
+		}
+		select {
+		case chunksCh <- i:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(chunksCh)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+func (d Download) downloadChunk(ctx context.Context, f *os.File, state *downloadResumeState, i int) error {
+	start := int64(i) * state.ChunkSize
+	end := min(start+state.ChunkSize, state.Size) - 1
+	want := end - start + 1
+
+	response, err := d.get(ctx, fmt.Sprintf("bytes=%d-%d", start, end))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return &downloadError{response.Status}
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(f, start), io.LimitReader(response.Body, want))
+	if err != nil {
+		return fmt.Errorf("copying chunk %d to partial file (%T: %w)", i, err, err)
+	}
+	if n != want {
+		return fmt.Errorf("chunk %d: got %d bytes, want %d: %w", i, n, want, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (d Download) saveResumeState(state *downloadResumeState, statePath string) error {
+	if !d.conf.Resume {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding resume state: %w", err)
+	}
+	// Write the state to a temp file first so that a crash can't leave a
+	// truncated resume file behind.
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing resume state: %w", err)
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		return errors.Join(fmt.Errorf("saving resume state: %w", err), os.Remove(tmp))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func TestDownload_Chunked(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 2*downloadChunkSize+123)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	sum := sha256.Sum256(content)
+
+	var mu sync.Mutex
+	ranges := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges[r.Header.Get("Range")]++
+		mu.Unlock()
+		http.ServeContent(w, r, "llamas.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	err := NewDownload(logger.Discard, http.DefaultClient, DownloadConfig{
+		URL:         server.URL,
+		Path:        "llamas.bin",
+		Destination: dir,
+		Retries:     1,
+		WantSHA256:  hex.EncodeToString(sum[:]),
+		Concurrency: 3,
+	}).Start(context.Background())
+	if err != nil {
+		t.Fatalf("Download.Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "llamas.bin"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded content does not match")
+	}
+
+	// 1 probe + 3 chunks
+	if got, want := len(ranges), 4; got != want {
+		t.Errorf("len(ranges) = %d, want %d (ranges = %v)", got, want, ranges)
+	}
+}
+
+func TestDownload_ChunkedResume(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 3*downloadChunkSize)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+
+	// Fail requests for the second chunk until failing is switched off.
+	var mu sync.Mutex
+	failing := true
+	var requested []string
+	secondChunk := "bytes=8388608-16777215"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requested = append(requested, r.Header.Get("Range"))
+		if failing && r.Header.Get("Range") == secondChunk {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "alpacas.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	dl := NewDownload(logger.Discard, http.DefaultClient, DownloadConfig{
+		URL:         server.URL,
+		Path:        "alpacas.bin",
+		Destination: dir,
+		Retries:     1,
+		Resume:      true,
+	})
+
+	if err := dl.Start(context.Background()); err == nil {
+		t.Fatalf("Download.Start() error = nil, want an error from the failing chunk")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "alpacas.bin.partial.resume")); err != nil {
+		t.Fatalf("resume file missing after failed download: %v", err)
+	}
+
+	mu.Lock()
+	failing = false
+	requested = nil
+	mu.Unlock()
+
+	if err := dl.Start(context.Background()); err != nil {
+		t.Fatalf("Download.Start() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "alpacas.bin"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("downloaded content does not match")
+	}
+
+	// The first chunk was already downloaded, so only the probe, the second
+	// and the third chunks should have been requested.
+	for _, r := range requested {
+		if strings.HasPrefix(r, "bytes=0-8388607") {
+			t.Errorf("first chunk was downloaded again after resuming (requested = %v)", requested)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "alpacas.bin.partial.resume")); !os.IsNotExist(err) {
+		t.Errorf("resume file still exists after successful download: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -29,13 +30,34 @@ type DownloaderConfig struct {
 	// Whether to include artifacts from retried jobs in the search
 	IncludeRetriedJobs bool
 
+	// Only artifacts with all of these metadata keys and values are
+	// downloaded
+	Metadata map[string]string
+
 	// Where we'll be downloading artifacts to
 	Destination string
 
+	// If set, the one artifact found is written to Stdout instead of being
+	// downloaded to Destination
+	Stdout io.Writer
+
+	// Whether to extract tar archives into Destination as they are
+	// downloaded, instead of saving them
+	Extract bool
+
+	// How many chunks of each artifact to download in parallel
+	Concurrency int
+
+	// Whether to resume partially downloaded artifacts
+	Resume bool
+
 	// Standard HTTP options
 	DebugHTTP    bool
 	TraceHTTP    bool
 	DisableHTTP2 bool
+
+	// Used to decrypt artifacts that were encrypted before upload
+	Encryption *EncryptionConfig
 }
 
 type Downloader struct {
@@ -58,6 +80,10 @@ func NewDownloader(l logger.Logger, ac APIClient, c DownloaderConfig) Downloader
 }
 
 func (a *Downloader) Download(ctx context.Context) error {
+	if a.conf.Stdout != nil {
+		return a.downloadToStdout(ctx)
+	}
+
 	// Turn the download destination into an absolute path and confirm it exists
 	destination, _ := filepath.Abs(a.conf.Destination)
 	fileInfo, err := os.Stat(destination)
@@ -74,15 +100,85 @@ func (a *Downloader) Download(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	artifacts, err = FilterByMetadata(artifacts, a.conf.Metadata)
+	if err != nil {
+		return err
+	}
+
+	bundles, err := a.searchBundles(ctx, artifacts)
+	if err != nil {
+		return err
+	}
 
 	artifactCount := len(artifacts)
 
-	if artifactCount == 0 {
+	if artifactCount == 0 && len(bundles) == 0 {
 		return errors.New("No artifacts found for downloading")
 	}
 
 	a.logger.Info("Found %d artifacts. Starting to download to: %s", artifactCount, destination)
 
+	if err := a.DownloadArtifacts(ctx, artifacts, destination); err != nil {
+		return err
+	}
+	return a.extractFromBundles(ctx, bundles, destination)
+}
+
+// searchBundles returns the bundles uploaded with --compress that could hold
+// files matching the query, other than any of the artifacts that were found
+// themselves.
+func (a *Downloader) searchBundles(ctx context.Context, found []*api.Artifact) ([]*api.Artifact, error) {
+	artifacts, err := NewSearcher(a.logger, a.apiClient, a.conf.BuildID).
+		SearchPatterns(ctx, bundleQueries(a.conf.Query), a.conf.Step, "finished", a.conf.IncludeRetriedJobs, false)
+	if err != nil {
+		return nil, err
+	}
+	artifacts, err = FilterByMetadata(artifacts, a.conf.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(found))
+	for _, artifact := range found {
+		seen[artifact.ID] = true
+	}
+	var bundles []*api.Artifact
+	for _, artifact := range artifacts {
+		if _, ok := bundleFormat(artifact.ContentType); ok && !seen[artifact.ID] {
+			bundles = append(bundles, artifact)
+		}
+	}
+	return bundles, nil
+}
+
+// extractFromBundles downloads bundles, and extracts the files in them that
+// match the query into destination as they are downloaded.
+func (a *Downloader) extractFromBundles(ctx context.Context, bundles []*api.Artifact, destination string) error {
+	if len(bundles) == 0 {
+		return nil
+	}
+	s3Clients, err := a.generateS3Clients(bundles)
+	if err != nil {
+		return fmt.Errorf("failed to generate S3 clients for artifact download: %w", err)
+	}
+
+	keep := func(name string) bool {
+		return inBundleMatches(a.conf.Query, name)
+	}
+	for _, bundle := range bundles {
+		format, _ := bundleFormat(bundle.ContentType)
+		a.logger.Info("Extracting the files that match from bundle %s", bundle.Path)
+		dler := a.createDownloader(bundle, bundle.Path, "", s3Clients)
+		if err := a.streamExtract(ctx, dler, format, bundle.Path, destination, keep); err != nil {
+			return fmt.Errorf("Failed to download artifact: %w", err)
+		}
+	}
+	return nil
+}
+
+// DownloadArtifacts downloads a list of artifacts that have already been
+// found, into the destination directory.
+func (a *Downloader) DownloadArtifacts(ctx context.Context, artifacts []*api.Artifact, destination string) error {
 	p := pool.New(pool.MaxConcurrencyLimit)
 	errors := []error{}
 	s3Clients, err := a.generateS3Clients(artifacts)
@@ -101,10 +197,30 @@ func (a *Downloader) Download(ctx context.Context) error {
 
 			dler := a.createDownloader(artifact, path, destination, s3Clients)
 
+			if a.conf.Extract {
+				if format, ok := archiveFormat(path); ok {
+					if err := a.streamExtract(ctx, dler, format, path, destination, nil); err != nil {
+						a.logger.Error("Failed to download artifact: %s", err)
+
+						p.Lock()
+						errors = append(errors, err)
+						p.Unlock()
+					}
+					return
+				}
+			}
+
 			// If the downloaded encountered an error, lock
 			// the pool, collect it, then unlock the pool
 			// again.
-			if err := dler.Start(ctx); err != nil {
+			err := dler.Start(ctx)
+			if err == nil {
+				err = a.decrypt(ctx, targetPath(ctx, path, destination))
+			}
+			if err == nil {
+				err = a.extract(ctx, artifact, targetPath(ctx, path, destination), destination)
+			}
+			if err != nil {
 				a.logger.Error("Failed to download artifact: %s", err)
 
 				p.Lock()
@@ -123,6 +239,109 @@ func (a *Downloader) Download(ctx context.Context) error {
 	return nil
 }
 
+// downloadToStdout writes the one artifact that matches the query to Stdout,
+// decrypting it if needed, without saving it.
+func (a *Downloader) downloadToStdout(ctx context.Context) error {
+	artifacts, err := NewSearcher(a.logger, a.apiClient, a.conf.BuildID).
+		Search(ctx, a.conf.Query, a.conf.Step, a.conf.IncludeRetriedJobs, false)
+	if err != nil {
+		return err
+	}
+	artifacts, err = FilterByMetadata(artifacts, a.conf.Metadata)
+	if err != nil {
+		return err
+	}
+
+	switch len(artifacts) {
+	case 0:
+		return errors.New("No artifacts found for downloading")
+	case 1:
+	default:
+		return fmt.Errorf("Found %d artifacts, but only one can be written to stdout", len(artifacts))
+	}
+	artifact := artifacts[0]
+
+	path := artifact.Path
+	if runtime.GOOS != "windows" {
+		path = strings.Replace(path, `\`, `/`, -1)
+	}
+
+	s3Clients, err := a.generateS3Clients(artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to generate S3 clients for artifact download: %w", err)
+	}
+
+	// Bundles are written as they are, so they can be piped to tar.
+	a.logger.Info("Found 1 artifact. Writing %s to stdout", path)
+	return a.stream(ctx, a.createDownloader(artifact, path, "", s3Clients), a.conf.Stdout)
+}
+
+// streamExtract downloads a tar archive and extracts it into destination as
+// it is downloaded, so the archive itself is never saved. If keep isn't nil,
+// only the files whose names it keeps are extracted.
+func (a *Downloader) streamExtract(ctx context.Context, dler downloader, format, path, destination string, keep func(name string) bool) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := extractArchive(ctx, format, pr, destination, keep)
+		// Stop the download if extraction failed part way through.
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	err := a.stream(ctx, dler, pw)
+	pw.CloseWithError(err)
+	if extractErr := <-done; extractErr != nil && err == nil {
+		err = extractErr
+	}
+	if err != nil {
+		return fmt.Errorf("extracting %s: %w", path, err)
+	}
+	a.logger.Debug("Extracted %s", path)
+	return nil
+}
+
+// stream downloads an artifact with dler and writes it to w, decrypting it if
+// it was encrypted before it was uploaded.
+func (a *Downloader) stream(ctx context.Context, dler downloader, w io.Writer) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(dler.Stream(ctx, pw))
+	}()
+
+	err := decryptStream(ctx, a.conf.Encryption, w, pr)
+	// Stop the download if writing or decrypting failed part way through.
+	pr.CloseWithError(err)
+	return err
+}
+
+// decrypt transparently decrypts a downloaded artifact if it was encrypted
+// before it was uploaded.
+func (a *Downloader) decrypt(ctx context.Context, path string) error {
+	encrypted, err := DecryptFileInPlace(ctx, a.conf.Encryption, path)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", path, err)
+	}
+	if encrypted {
+		a.logger.Debug("Decrypted %s", path)
+	}
+	return nil
+}
+
+// extract transparently extracts a downloaded artifact if it is a bundle of
+// files that were compressed before they were uploaded.
+func (a *Downloader) extract(ctx context.Context, artifact *api.Artifact, path, destination string) error {
+	format, ok := bundleFormat(artifact.ContentType)
+	if !ok {
+		return nil
+	}
+	if err := extractBundle(ctx, format, path, destination); err != nil {
+		return fmt.Errorf("extracting %s: %w", path, err)
+	}
+	a.logger.Debug("Extracted %s bundle %s", format, path)
+	return nil
+}
+
 // We want to have as few S3 clients as possible, as creating them is kind of an expensive operation
 // But it's also theoretically possible that we'll have multiple artifacts with different S3 buckets, and each
 // S3Client only applies to one bucket, so we need to store the S3 clients in a map, one for each bucket
@@ -149,7 +368,11 @@ func (a *Downloader) generateS3Clients(artifacts []*api.Artifact) (map[string]*s
 }
 
 type downloader interface {
+	// Start downloads the artifact into its destination.
 	Start(context.Context) error
+
+	// Stream downloads the artifact and writes it to a writer.
+	Stream(context.Context, io.Writer) error
 }
 
 func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination string, s3Clients map[string]*s3.S3) downloader {
@@ -163,6 +386,8 @@ func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination
 			S3Path:      artifact.UploadDestination,
 			Destination: destination,
 			Retries:     5,
+			Concurrency: a.conf.Concurrency,
+			Resume:      a.conf.Resume,
 			DebugHTTP:   a.conf.DebugHTTP,
 			TraceHTTP:   a.conf.TraceHTTP,
 		})
@@ -173,6 +398,8 @@ func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination
 			Bucket:      artifact.UploadDestination,
 			Destination: destination,
 			Retries:     5,
+			Concurrency: a.conf.Concurrency,
+			Resume:      a.conf.Resume,
 			DebugHTTP:   a.conf.DebugHTTP,
 			TraceHTTP:   a.conf.TraceHTTP,
 		})
@@ -183,6 +410,8 @@ func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination
 			Repository:  artifact.UploadDestination,
 			Destination: destination,
 			Retries:     5,
+			Concurrency: a.conf.Concurrency,
+			Resume:      a.conf.Resume,
 			DebugHTTP:   a.conf.DebugHTTP,
 			TraceHTTP:   a.conf.TraceHTTP,
 		})
@@ -193,6 +422,7 @@ func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination
 			Repository:  artifact.UploadDestination,
 			Destination: destination,
 			Retries:     5,
+			Concurrency: a.conf.Concurrency,
 			DebugHTTP:   a.conf.DebugHTTP,
 			TraceHTTP:   a.conf.TraceHTTP,
 		})
@@ -207,6 +437,8 @@ func (a *Downloader) createDownloader(artifact *api.Artifact, path, destination
 			Path:        path,
 			Destination: destination,
 			Retries:     5,
+			Concurrency: a.conf.Concurrency,
+			Resume:      a.conf.Resume,
 			DebugHTTP:   a.conf.DebugHTTP,
 			TraceHTTP:   a.conf.TraceHTTP,
 		})
@@ -1,11 +1,15 @@
 package artifact
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/agent/v3/api"
@@ -17,6 +21,8 @@ func TestArtifactDownloaderConnectsToEndpoint(t *testing.T) {
 
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		switch req.URL.RequestURI() {
+		case "/builds/my-build/artifacts/search?query=artifacts-%2A.tar.%2A&state=finished":
+			fmt.Fprint(rw, `[]`)
 		case "/builds/my-build/artifacts/search?state=finished":
 			fmt.Fprintf(rw, `[{
 				"id": "4600ac5c-5a13-4e92-bb83-f86f218f7b32",
@@ -48,3 +54,189 @@ func TestArtifactDownloaderConnectsToEndpoint(t *testing.T) {
 		t.Errorf("d.Download() = %v", err)
 	}
 }
+
+// newArtifactServer returns a server that finds the artifacts whose path
+// matches the search query (or all of them, for "*"), and serves their
+// contents.
+func newArtifactServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/builds/my-build/artifacts/search" {
+			query := req.URL.Query().Get("query")
+			artifacts := []*api.Artifact{}
+			for path, content := range files {
+				if query == "*" || query == path {
+					artifacts = append(artifacts, &api.Artifact{
+						Path:     path,
+						FileSize: int64(len(content)),
+						URL:      "http://" + req.Host + "/download/" + path,
+					})
+				}
+			}
+			json.NewEncoder(rw).Encode(artifacts)
+			return
+		}
+		content, ok := files[strings.TrimPrefix(req.URL.Path, "/download/")]
+		if !ok {
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		rw.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestArtifactDownloaderWritesToStdout(t *testing.T) {
+	t.Parallel()
+
+	server := newArtifactServer(t, map[string][]byte{
+		"llamas.txt":  []byte("llamas\n"),
+		"alpacas.txt": []byte("alpacas\n"),
+	})
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	ctx := context.Background()
+	var stdout bytes.Buffer
+	d := NewDownloader(logger.Discard, ac, DownloaderConfig{
+		BuildID: "my-build",
+		Query:   "llamas.txt",
+		Stdout:  &stdout,
+	})
+	if err := d.Download(ctx); err != nil {
+		t.Fatalf("d.Download() error = %v", err)
+	}
+	if got, want := stdout.String(), "llamas\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+
+	d = NewDownloader(logger.Discard, ac, DownloaderConfig{
+		BuildID: "my-build",
+		Query:   "*",
+		Stdout:  &stdout,
+	})
+	if err := d.Download(ctx); err == nil {
+		t.Errorf("d.Download() with 2 artifacts error = nil, want an error")
+	}
+}
+
+func TestArtifactDownloaderExtractsArchives(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.log"), []byte("llamas"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(a.log) error = %v", err)
+	}
+	var archive bytes.Buffer
+	if err := writeBundleTo(CompressionZstd, &archive, []bundleFile{
+		{path: "logs/a.log", absolutePath: filepath.Join(src, "a.log")},
+	}); err != nil {
+		t.Fatalf("writeBundleTo(...) error = %v", err)
+	}
+
+	server := newArtifactServer(t, map[string][]byte{
+		"build.tar.zst": archive.Bytes(),
+		"notes.txt":     []byte("alpacas"),
+	})
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	dest := t.TempDir()
+	d := NewDownloader(logger.Discard, ac, DownloaderConfig{
+		BuildID:     "my-build",
+		Query:       "*",
+		Destination: dest,
+		Extract:     true,
+	})
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatalf("d.Download() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"logs/a.log": "llamas",
+		"notes.txt":  "alpacas",
+	} {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("os.ReadFile(%q) error = %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "build.tar.zst")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(build.tar.zst) error = %v, want the archive not to be saved", err)
+	}
+}
+
+func TestArtifactDownloaderLooksInsideBundles(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	for name, content := range map[string]string{"a.log": "llamas", "b.txt": "alpacas"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", name, err)
+		}
+	}
+	var bundle bytes.Buffer
+	if err := writeBundleTo(CompressionZstd, &bundle, []bundleFile{
+		{path: "logs/a.log", absolutePath: filepath.Join(src, "a.log")},
+		{path: "logs/b.txt", absolutePath: filepath.Join(src, "b.txt")},
+	}); err != nil {
+		t.Fatalf("writeBundleTo(...) error = %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/builds/my-build/artifacts/search":
+			artifacts := []*api.Artifact{}
+			if req.URL.Query().Get("query") == "logs-*.tar.*" {
+				artifacts = append(artifacts, &api.Artifact{
+					ID:          "bundle",
+					Path:        "logs-1a2b3c4d.tar.zst",
+					FileSize:    int64(bundle.Len()),
+					ContentType: bundleContentTypes[CompressionZstd],
+					URL:         "http://" + req.Host + "/download",
+				})
+			}
+			json.NewEncoder(rw).Encode(artifacts)
+		case "/download":
+			rw.Write(bundle.Bytes())
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	dest := t.TempDir()
+	d := NewDownloader(logger.Discard, ac, DownloaderConfig{
+		BuildID:     "my-build",
+		Query:       "logs/*.log",
+		Destination: dest,
+	})
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatalf("d.Download() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "logs", "a.log"))
+	if err != nil {
+		t.Fatalf("os.ReadFile(logs/a.log) error = %v", err)
+	}
+	if string(got) != "llamas" {
+		t.Errorf("logs/a.log = %q, want %q", got, "llamas")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "logs", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(logs/b.txt) error = %v, want it not to be extracted", err)
+	}
+}
@@ -0,0 +1,436 @@
+package artifact
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted artifacts have the following layout:
+//
+//	magic (8 bytes) | mode (1 byte) | wrapped key length (2 bytes, BE) |
+//	wrapped key | salt (32 bytes) | nonce prefix (7 bytes) |
+//	checksum (8 bytes) | segments...
+//
+// The magic starts with a byte that isn't valid in ASCII or UTF-8 text, and
+// the checksum is the start of the SHA-256 of the rest of the header, so a
+// file is only treated as encrypted if it has a whole, intact header. Plain
+// files that happen to start with the magic are left alone.
+//
+// Each segment is up to encryptionSegmentSize bytes of plaintext sealed with
+// AES-256-GCM. The nonce for each segment is the nonce prefix, a 4-byte
+// big-endian counter, and a final byte that is 1 for the last segment and 0
+// otherwise (the STREAM construction), which prevents segments from being
+// reordered or the stream being truncated. The header is used as additional
+// authenticated data for every segment.
+const (
+	encryptionMagic        = "\x89BKAEv1\n"
+	encryptionSegmentSize  = 64 * 1024
+	encryptionSaltSize     = 32
+	encryptionPrefixSize   = 7
+	encryptionChecksumSize = 8
+
+	// The largest possible header, with the longest wrapped key.
+	encryptionMaxHeaderSize = len(encryptionMagic) + 3 + 0xffff + encryptionSaltSize + encryptionPrefixSize + encryptionChecksumSize
+
+	encryptionModeKey byte = 1
+	encryptionModeKMS byte = 2
+)
+
+var (
+	// ErrEncryptionKeyRequired is returned when decrypting an artifact that was
+	// encrypted with a local key, but no key was provided.
+	ErrEncryptionKeyRequired = errors.New("artifact is encrypted, but no encryption key was provided")
+
+	errEncryptionTruncated = errors.New("encrypted artifact is truncated")
+)
+
+// KMSAPI is the subset of the AWS KMS client used for envelope encryption.
+type KMSAPI interface {
+	GenerateDataKey(context.Context, *kms.GenerateDataKeyInput, ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(context.Context, *kms.DecryptInput, ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// EncryptionConfig configures client-side encryption of artifacts. Exactly
+// one of Key or KMSKeyID should be set to encrypt; either (or both) may be set
+// to decrypt.
+type EncryptionConfig struct {
+	// A 256-bit key used to derive per-artifact encryption keys
+	Key []byte
+
+	// The ID or alias of an AWS KMS key used to generate per-artifact data keys
+	KMSKeyID string
+
+	// The KMS client to use. Required if KMSKeyID is set, or when decrypting
+	// artifacts that were encrypted with KMS.
+	KMS KMSAPI
+}
+
+// Enabled reports whether artifacts should be encrypted before upload.
+func (c *EncryptionConfig) Enabled() bool {
+	return c != nil && (len(c.Key) > 0 || c.KMSKeyID != "")
+}
+
+// LoadEncryptionKey reads a 256-bit key from a file. The file may contain
+// either the raw 32 bytes, or the key encoded as standard base64.
+func LoadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading encryption key file: %w", err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file must contain 32 raw bytes or base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// EncryptFile encrypts the file at src into a new temporary file within dir,
+// and returns the path to the encrypted file. The caller is responsible for
+// removing it.
+func EncryptFile(ctx context.Context, conf *EncryptionConfig, src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, filepath.Base(src)+".*.enc")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	defer out.Close()
+
+	if err := encrypt(ctx, conf, out, in); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("closing temp file: %w", err)
+	}
+	return out.Name(), nil
+}
+
+// DecryptFileInPlace decrypts the file at path if it is an encrypted artifact.
+// It reports whether the file was encrypted.
+func DecryptFileInPlace(ctx context.Context, conf *EncryptionConfig, path string) (bool, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer in.Close()
+
+	br := bufio.NewReaderSize(in, encryptionMaxHeaderSize)
+	if !isEncrypted(br) {
+		return false, nil
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.dec")
+	if err != nil {
+		return true, fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := decrypt(ctx, conf, out, br); err != nil {
+		return true, err
+	}
+
+	// Preserve the permissions of the downloaded file.
+	if fi, err := in.Stat(); err == nil {
+		if err := out.Chmod(fi.Mode().Perm()); err != nil {
+			return true, fmt.Errorf("setting file permissions: %w", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return true, fmt.Errorf("closing temp file: %w", err)
+	}
+	in.Close()
+
+	if err := os.Rename(out.Name(), path); err != nil {
+		return true, fmt.Errorf("renaming decrypted file: %w", err)
+	}
+	return true, nil
+}
+
+// decryptStream copies r to w, decrypting it if it is an encrypted artifact.
+func decryptStream(ctx context.Context, conf *EncryptionConfig, w io.Writer, r io.Reader) error {
+	br := bufio.NewReaderSize(r, encryptionMaxHeaderSize)
+	if !isEncrypted(br) {
+		_, err := io.Copy(w, br)
+		return err
+	}
+	return decrypt(ctx, conf, w, br)
+}
+
+// isEncrypted reports whether br starts with an encryption header with a
+// valid checksum, without consuming it. br must be able to buffer
+// encryptionMaxHeaderSize bytes.
+func isEncrypted(br *bufio.Reader) bool {
+	fixed, err := br.Peek(len(encryptionMagic) + 3)
+	if err != nil || string(fixed[:len(encryptionMagic)]) != encryptionMagic {
+		return false
+	}
+	wrappedLen := int(binary.BigEndian.Uint16(fixed[len(encryptionMagic)+1:]))
+	header, err := br.Peek(len(fixed) + wrappedLen + encryptionSaltSize + encryptionPrefixSize + encryptionChecksumSize)
+	if err != nil {
+		return false
+	}
+	body := header[:len(header)-encryptionChecksumSize]
+	return bytes.Equal(encryptionChecksum(body), header[len(body):])
+}
+
+// encryptionChecksum returns the checksum of the rest of a header.
+func encryptionChecksum(header []byte) []byte {
+	sum := sha256.Sum256(header)
+	return sum[:encryptionChecksumSize]
+}
+
+func encrypt(ctx context.Context, conf *EncryptionConfig, w io.Writer, r io.Reader) error {
+	var mode byte
+	var wrapped, secret []byte
+	switch {
+	case conf.KMSKeyID != "":
+		if conf.KMS == nil {
+			return errors.New("no KMS client configured for artifact encryption")
+		}
+		out, err := conf.KMS.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(conf.KMSKeyID),
+			KeySpec: types.DataKeySpecAes256,
+		})
+		if err != nil {
+			return fmt.Errorf("generating data key with KMS key %q: %w", conf.KMSKeyID, err)
+		}
+		mode, wrapped, secret = encryptionModeKMS, out.CiphertextBlob, out.Plaintext
+
+	case len(conf.Key) > 0:
+		mode, secret = encryptionModeKey, conf.Key
+
+	default:
+		return errors.New("no artifact encryption key configured")
+	}
+
+	if len(wrapped) > 0xffff {
+		return fmt.Errorf("wrapped data key is too long (%d bytes)", len(wrapped))
+	}
+
+	var header bytes.Buffer
+	header.WriteString(encryptionMagic)
+	header.WriteByte(mode)
+	binary.Write(&header, binary.BigEndian, uint16(len(wrapped))) //nolint:errcheck // bytes.Buffer never errors
+	header.Write(wrapped)
+	salt := make([]byte, encryptionSaltSize+encryptionPrefixSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+	header.Write(salt)
+	header.Write(encryptionChecksum(header.Bytes()))
+
+	aead, err := newSegmentAEAD(secret, salt[:encryptionSaltSize])
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	s := &segmenter{
+		aead:   aead,
+		prefix: salt[encryptionSaltSize:],
+		ad:     header.Bytes(),
+	}
+
+	// Read one segment ahead so that we know which segment is the last.
+	buf := make([]byte, encryptionSegmentSize)
+	next := make([]byte, encryptionSegmentSize)
+	n, err := io.ReadFull(r, buf)
+	for {
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			_, err = w.Write(s.seal(buf[:n], true))
+			return err
+		case err != nil:
+			return fmt.Errorf("reading plaintext: %w", err)
+		}
+
+		m, nerr := io.ReadFull(r, next)
+		if errors.Is(nerr, io.EOF) {
+			// buf was exactly the last segment.
+			_, err = w.Write(s.seal(buf[:n], true))
+			return err
+		}
+		if _, err := w.Write(s.seal(buf[:n], false)); err != nil {
+			return fmt.Errorf("writing ciphertext: %w", err)
+		}
+		buf, next = next, buf
+		n, err = m, nerr
+	}
+}
+
+func decrypt(ctx context.Context, conf *EncryptionConfig, w io.Writer, r io.Reader) error {
+	var header bytes.Buffer
+	tr := io.TeeReader(r, &header)
+
+	fixed := make([]byte, len(encryptionMagic)+3)
+	if _, err := io.ReadFull(tr, fixed); err != nil {
+		return errEncryptionTruncated
+	}
+	if string(fixed[:len(encryptionMagic)]) != encryptionMagic {
+		return errors.New("not an encrypted artifact")
+	}
+	mode := fixed[len(encryptionMagic)]
+	wrapped := make([]byte, binary.BigEndian.Uint16(fixed[len(encryptionMagic)+1:]))
+	if _, err := io.ReadFull(tr, wrapped); err != nil {
+		return errEncryptionTruncated
+	}
+	salt := make([]byte, encryptionSaltSize+encryptionPrefixSize)
+	if _, err := io.ReadFull(tr, salt); err != nil {
+		return errEncryptionTruncated
+	}
+	body := bytes.Clone(header.Bytes())
+	checksum := make([]byte, encryptionChecksumSize)
+	if _, err := io.ReadFull(tr, checksum); err != nil {
+		return errEncryptionTruncated
+	}
+	if !bytes.Equal(checksum, encryptionChecksum(body)) {
+		return errors.New("encrypted artifact header is corrupt")
+	}
+
+	var secret []byte
+	switch mode {
+	case encryptionModeKey:
+		if conf == nil || len(conf.Key) == 0 {
+			return ErrEncryptionKeyRequired
+		}
+		secret = conf.Key
+
+	case encryptionModeKMS:
+		if conf == nil || conf.KMS == nil {
+			return errors.New("artifact was encrypted with KMS, but no KMS client is available")
+		}
+		out, err := conf.KMS.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: wrapped})
+		if err != nil {
+			return fmt.Errorf("decrypting data key with KMS: %w", err)
+		}
+		secret = out.Plaintext
+
+	default:
+		return fmt.Errorf("unknown artifact encryption mode %d", mode)
+	}
+
+	aead, err := newSegmentAEAD(secret, salt[:encryptionSaltSize])
+	if err != nil {
+		return err
+	}
+
+	s := &segmenter{
+		aead:   aead,
+		prefix: salt[encryptionSaltSize:],
+		ad:     header.Bytes(),
+	}
+
+	segSize := encryptionSegmentSize + aead.Overhead()
+	buf := make([]byte, segSize)
+	next := make([]byte, segSize)
+	n, err := io.ReadFull(r, buf)
+	for {
+		last := false
+		var m int
+		var nerr error
+		switch {
+		case errors.Is(err, io.EOF):
+			return errEncryptionTruncated
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return fmt.Errorf("reading ciphertext: %w", err)
+		default:
+			m, nerr = io.ReadFull(r, next)
+			last = errors.Is(nerr, io.EOF)
+		}
+
+		plain, err := s.open(buf[:n], last)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(plain); err != nil {
+			return fmt.Errorf("writing plaintext: %w", err)
+		}
+		if last {
+			return nil
+		}
+		buf, next = next, buf
+		n, err = m, nerr
+	}
+}
+
+// newSegmentAEAD derives a per-artifact key from the secret and salt.
+func newSegmentAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(encryptionMagic)), key); err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmenter seals and opens consecutive segments of an encrypted artifact.
+type segmenter struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	ad      []byte
+	counter uint32
+}
+
+func (s *segmenter) nonce(last bool) []byte {
+	nonce := make([]byte, 0, s.aead.NonceSize())
+	nonce = append(nonce, s.prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, s.counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+func (s *segmenter) seal(plain []byte, last bool) []byte {
+	out := s.aead.Seal(nil, s.nonce(last), plain, s.ad)
+	s.counter++
+	return out
+}
+
+func (s *segmenter) open(ciphertext []byte, last bool) ([]byte, error) {
+	out, err := s.aead.Open(nil, s.nonce(last), ciphertext, s.ad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting segment %d: %w", s.counter, err)
+	}
+	s.counter++
+	return out, nil
+}
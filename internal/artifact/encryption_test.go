@@ -0,0 +1,217 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS "wraps" data keys by reversing them.
+type fakeKMS struct{}
+
+func (fakeKMS) GenerateDataKey(_ context.Context, _ *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{Plaintext: key, CiphertextBlob: reversed(key)}, nil
+}
+
+func (fakeKMS) Decrypt(_ context.Context, in *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: reversed(in.CiphertextBlob)}, nil
+}
+
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+func TestEncryptDecrypt_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("rand.Read(key) error = %v", err)
+	}
+
+	confs := map[string]*EncryptionConfig{
+		"key": {Key: key},
+		"kms": {KMSKeyID: "alias/test", KMS: fakeKMS{}},
+	}
+
+	sizes := []int{0, 1, encryptionSegmentSize - 1, encryptionSegmentSize, encryptionSegmentSize + 1, 3*encryptionSegmentSize + 17}
+
+	ctx := context.Background()
+	for name, conf := range confs {
+		for _, size := range sizes {
+			plain := make([]byte, size)
+			if _, err := rand.Read(plain); err != nil {
+				t.Fatalf("rand.Read(plain) error = %v", err)
+			}
+
+			var enc bytes.Buffer
+			if err := encrypt(ctx, conf, &enc, bytes.NewReader(plain)); err != nil {
+				t.Fatalf("%s/%d: encrypt() error = %v", name, size, err)
+			}
+
+			var dec bytes.Buffer
+			if err := decrypt(ctx, conf, &dec, &enc); err != nil {
+				t.Fatalf("%s/%d: decrypt() error = %v", name, size, err)
+			}
+
+			if !bytes.Equal(dec.Bytes(), plain) {
+				t.Errorf("%s/%d: decrypted content does not match plaintext", name, size)
+			}
+		}
+	}
+}
+
+func TestDecrypt_DetectsTampering(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := &EncryptionConfig{Key: bytes.Repeat([]byte{7}, 32)}
+	plain := bytes.Repeat([]byte("llamas"), encryptionSegmentSize)
+
+	var enc bytes.Buffer
+	if err := encrypt(ctx, conf, &enc, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	ciphertext := enc.Bytes()
+
+	// Truncate the final segment away. plain is an exact number of segments.
+	truncated := ciphertext[:len(ciphertext)-(encryptionSegmentSize+16)]
+	if err := decrypt(ctx, conf, &bytes.Buffer{}, bytes.NewReader(truncated)); err == nil {
+		t.Errorf("decrypt(truncated) error = nil, want an error")
+	}
+
+	// Flip a bit in the body.
+	flipped := bytes.Clone(ciphertext)
+	flipped[len(flipped)-1] ^= 1
+	if err := decrypt(ctx, conf, &bytes.Buffer{}, bytes.NewReader(flipped)); err == nil {
+		t.Errorf("decrypt(flipped) error = nil, want an error")
+	}
+
+	// Wrong key.
+	wrong := &EncryptionConfig{Key: bytes.Repeat([]byte{8}, 32)}
+	if err := decrypt(ctx, wrong, &bytes.Buffer{}, bytes.NewReader(ciphertext)); err == nil {
+		t.Errorf("decrypt(wrong key) error = nil, want an error")
+	}
+
+	// No key.
+	if err := decrypt(ctx, nil, &bytes.Buffer{}, bytes.NewReader(ciphertext)); !errors.Is(err, ErrEncryptionKeyRequired) {
+		t.Errorf("decrypt(no key) error = %v, want %v", err, ErrEncryptionKeyRequired)
+	}
+}
+
+func TestDecryptFileInPlace(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := &EncryptionConfig{Key: bytes.Repeat([]byte{1}, 32)}
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(src, []byte("hello world"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+
+	// An unencrypted file is left alone.
+	encrypted, err := DecryptFileInPlace(ctx, conf, src)
+	if err != nil {
+		t.Fatalf("DecryptFileInPlace(plain) error = %v", err)
+	}
+	if encrypted {
+		t.Errorf("DecryptFileInPlace(plain) encrypted = true, want false")
+	}
+
+	encPath, err := EncryptFile(ctx, conf, src, dir)
+	if err != nil {
+		t.Fatalf("EncryptFile() error = %v", err)
+	}
+
+	encrypted, err = DecryptFileInPlace(ctx, conf, encPath)
+	if err != nil {
+		t.Fatalf("DecryptFileInPlace(encrypted) error = %v", err)
+	}
+	if !encrypted {
+		t.Errorf("DecryptFileInPlace(encrypted) encrypted = false, want true")
+	}
+
+	got, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("decrypted content = %q, want %q", got, "hello world")
+	}
+}
+
+func TestDecryptStream(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := &EncryptionConfig{Key: bytes.Repeat([]byte{3}, 32)}
+	plain := []byte("llamas are great")
+
+	var enc bytes.Buffer
+	if err := encrypt(ctx, conf, &enc, bytes.NewReader(plain)); err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	for name, in := range map[string][]byte{
+		"encrypted": enc.Bytes(),
+		"plain":     plain,
+		"short":     plain[:2],
+	} {
+		var out bytes.Buffer
+		if err := decryptStream(ctx, conf, &out, bytes.NewReader(in)); err != nil {
+			t.Fatalf("%s: decryptStream() error = %v", name, err)
+		}
+		want := plain
+		if name == "short" {
+			want = plain[:2]
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: decryptStream() wrote %q, want %q", name, out.Bytes(), want)
+		}
+	}
+}
+
+func TestDecryptStreamLeavesLookalikesAlone(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := &EncryptionConfig{Key: bytes.Repeat([]byte{4}, 32)}
+
+	var enc bytes.Buffer
+	if err := encrypt(ctx, conf, &enc, bytes.NewReader([]byte("llamas"))); err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	// Plain files that start like an encrypted artifact, but don't have an
+	// intact header, aren't encrypted.
+	corrupt := bytes.Clone(enc.Bytes())
+	corrupt[len(encryptionMagic)+5] ^= 1
+	for name, plain := range map[string][]byte{
+		"magic only":     []byte(encryptionMagic + "and then some text"),
+		"corrupt header": corrupt,
+	} {
+		var out bytes.Buffer
+		if err := decryptStream(ctx, conf, &out, bytes.NewReader(plain)); err != nil {
+			t.Fatalf("%s: decryptStream() error = %v", name, err)
+		}
+		if !bytes.Equal(out.Bytes(), plain) {
+			t.Errorf("%s: decryptStream() wrote %q, want %q", name, out.Bytes(), plain)
+		}
+	}
+}
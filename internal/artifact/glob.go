@@ -0,0 +1,163 @@
+package artifact
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattn/go-zglob"
+)
+
+// Globs are artifact path patterns, split into the files to include and the
+// files to exclude.
+//
+// Artifact paths are separated by ArtifactPathDelimiter. Each can use * and **
+// wildcards, and {a,b} braces, which are expanded into a pattern for each
+// alternative. A path wrapped in !(...) (or just starting with !) excludes the
+// files it matches. A path to a directory, without wildcards, matches every
+// file within it, as if it were dir/**/*.
+type Globs struct {
+	Include []string
+	Exclude []string
+}
+
+// ParseGlobs parses artifact paths. Relative paths to directories are looked
+// up in wd.
+func ParseGlobs(paths, wd string) (*Globs, error) {
+	globs := &Globs{}
+	for _, p := range strings.Split(paths, ArtifactPathDelimiter) {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		exclude := false
+		if rest, ok := strings.CutPrefix(p, "!"); ok {
+			exclude = true
+			if strings.HasPrefix(rest, "(") && strings.HasSuffix(rest, ")") {
+				rest = rest[1 : len(rest)-1]
+			}
+			p = strings.TrimSpace(rest)
+			if p == "" {
+				return nil, fmt.Errorf("exclusion %q has no pattern", rest)
+			}
+		}
+
+		expanded, err := expandBraces(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range expanded {
+			pattern = directoryShorthand(pattern, wd)
+			if _, err := zglob.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+			}
+			if exclude {
+				globs.Exclude = append(globs.Exclude, pattern)
+			} else {
+				globs.Include = append(globs.Include, pattern)
+			}
+		}
+	}
+	return globs, nil
+}
+
+// Excludes reports whether the file at path, which is relative to wd (or
+// absolute), matches one of the exclusions.
+func (g *Globs) Excludes(path, wd string) bool {
+	for _, pattern := range g.Exclude {
+		candidate := path
+		switch {
+		case filepath.IsAbs(pattern) && !filepath.IsAbs(path):
+			candidate = filepath.Join(wd, path)
+		case !filepath.IsAbs(pattern) && filepath.IsAbs(path):
+			rel, err := filepath.Rel(wd, path)
+			if err != nil {
+				continue
+			}
+			candidate = rel
+		}
+		if ok, _ := zglob.Match(filepath.ToSlash(pattern), filepath.ToSlash(filepath.Clean(candidate))); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// directoryShorthand turns a pattern that is the path to a directory into one
+// that matches every file within it.
+func directoryShorthand(pattern, wd string) string {
+	if strings.ContainsAny(pattern, "*?[") {
+		return pattern
+	}
+	dir := pattern
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(wd, dir)
+	}
+	if !strings.HasSuffix(pattern, "/") && !isDir(dir) {
+		return pattern
+	}
+	return strings.TrimSuffix(filepath.ToSlash(pattern), "/") + "/**/*"
+}
+
+// expandBraces expands the first (outermost) {a,b} in pattern into a pattern
+// for each alternative, and so on for the rest, including nested braces.
+// Braces and commas escaped with a backslash aren't expanded, and are left
+// escaped for the glob library to match literally.
+func expandBraces(pattern string) ([]string, error) {
+	// Find the first brace, the matching close brace, and the commas at the
+	// top level within.
+	start, end, depth := -1, -1, 0
+	commas := []int{}
+	for i := 0; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '\\':
+			// Skip the escaped character. On Windows, where backslashes also
+			// separate paths, only braces can be escaped, as in zglob.
+			if filepath.Separator == '/' || (i+1 < len(pattern) && strings.IndexByte("{}", pattern[i+1]) >= 0) {
+				i++
+			}
+		case '{':
+			if start < 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unbalanced braces in %q", pattern)
+			}
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("unbalanced braces in %q", pattern)
+	}
+
+	prefix, suffix := pattern[:start], pattern[end+1:]
+	var alternatives []string
+	from := start + 1
+	for _, comma := range append(commas, end) {
+		alternatives = append(alternatives, pattern[from:comma])
+		from = comma + 1
+	}
+
+	var expanded []string
+	for _, alt := range alternatives {
+		more, err := expandBraces(prefix + alt + suffix)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, more...)
+	}
+	return expanded, nil
+}
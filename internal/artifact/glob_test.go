@@ -0,0 +1,90 @@
+package artifact
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandBraces(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "log/*.log", want: []string{"log/*.log"}},
+		{pattern: "{a,b}/*.log", want: []string{"a/*.log", "b/*.log"}},
+		{pattern: "dist/*.{js,map}", want: []string{"dist/*.js", "dist/*.map"}},
+		{pattern: "{a,b}/{c,d}", want: []string{"a/c", "a/d", "b/c", "b/d"}},
+		{pattern: "{a,b{c,d}}.txt", want: []string{"a.txt", "bc.txt", "bd.txt"}},
+		{pattern: "x{,.bak}", want: []string{"x", "x.bak"}},
+		{pattern: `literal\{a,b\}.txt`, want: []string{`literal\{a,b\}.txt`}},
+		{pattern: `{a,b\}}.txt`, want: []string{"a.txt", `b\}.txt`}},
+	}
+	for _, test := range tests {
+		got, err := expandBraces(test.pattern)
+		if err != nil {
+			t.Errorf("expandBraces(%q) error = %v", test.pattern, err)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("expandBraces(%q) diff (-got +want):\n%s", test.pattern, diff)
+		}
+	}
+
+	for _, pattern := range []string{"{a,b", "a,b}", "}{", `{a,b\}`} {
+		if _, err := expandBraces(pattern); err == nil {
+			t.Errorf("expandBraces(%q) error = nil, want an error", pattern)
+		}
+	}
+}
+
+func TestParseGlobs(t *testing.T) {
+	t.Parallel()
+
+	wd := t.TempDir()
+	if err := os.Mkdir(filepath.Join(wd, "coverage"), 0o777); err != nil {
+		t.Fatalf("os.Mkdir(coverage) error = %v", err)
+	}
+
+	got, err := ParseGlobs("dist/*.{js,css}; coverage ;!(dist/*.min.js);!tmp/;missing", wd)
+	if err != nil {
+		t.Fatalf("ParseGlobs(...) error = %v", err)
+	}
+	want := &Globs{
+		Include: []string{"dist/*.js", "dist/*.css", "coverage/**/*", "missing"},
+		Exclude: []string{"dist/*.min.js", "tmp/**/*"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ParseGlobs(...) diff (-got +want):\n%s", diff)
+	}
+
+	for _, paths := range []string{"!", "!()", "dist/{a,b"} {
+		if _, err := ParseGlobs(paths, wd); err == nil {
+			t.Errorf("ParseGlobs(%q) error = nil, want an error", paths)
+		}
+	}
+}
+
+func TestGlobsExcludes(t *testing.T) {
+	t.Parallel()
+
+	wd := filepath.FromSlash("/build")
+	globs := &Globs{Exclude: []string{"dist/**/*.map", "/build/tmp/*"}}
+
+	tests := map[string]bool{
+		"dist/app.js":                           false,
+		"dist/js/app.js.map":                    true,
+		filepath.FromSlash("/build/dist/a.map"): true,
+		"tmp/scratch":                           true,
+		"other/tmp/scratch":                     false,
+	}
+	for path, want := range tests {
+		if got := globs.Excludes(path, wd); got != want {
+			t.Errorf("globs.Excludes(%q, %q) = %t, want %t", path, wd, got, want)
+		}
+	}
+}
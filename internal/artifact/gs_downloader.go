@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/buildkite/agent/v3/logger"
@@ -24,6 +25,11 @@ type GSDownloaderConfig struct {
 	// How many times should it retry the download before giving up
 	Retries int
 
+	// How many chunks to download in parallel, and whether partial downloads
+	// should be resumed. See DownloadConfig.
+	Concurrency int
+	Resume      bool
+
 	// If failed responses should be dumped to the log
 	DebugHTTP bool
 	TraceHTTP bool
@@ -45,9 +51,28 @@ func NewGSDownloader(l logger.Logger, c GSDownloaderConfig) *GSDownloader {
 }
 
 func (d GSDownloader) Start(ctx context.Context) error {
-	client, err := newGoogleClient(ctx, storage.DevstorageReadOnlyScope)
+	dl, err := d.download(ctx)
+	if err != nil {
+		return err
+	}
+	return dl.Start(ctx)
+}
+
+// Stream downloads the file and writes it to w. See Download.Stream.
+func (d GSDownloader) Stream(ctx context.Context, w io.Writer) error {
+	dl, err := d.download(ctx)
+	if err != nil {
+		return err
+	}
+	return dl.Stream(ctx, w)
+}
+
+// download returns a regular download of the file, with an authenticated
+// client.
+func (d GSDownloader) download(ctx context.Context) (*Download, error) {
+	client, err := NewGoogleClient(ctx, storage.DevstorageReadOnlyScope)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error creating Google Cloud Storage client: %v", err))
+		return nil, errors.New(fmt.Sprintf("Error creating Google Cloud Storage client: %v", err))
 	}
 
 	url := "https://www.googleapis.com/storage/v1/b/" + d.BucketName() + "/o/" + escape(d.BucketFileLocation()) + "?alt=media"
@@ -58,9 +83,11 @@ func (d GSDownloader) Start(ctx context.Context) error {
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
 		Retries:     d.conf.Retries,
+		Concurrency: d.conf.Concurrency,
+		Resume:      d.conf.Resume,
 		DebugHTTP:   d.conf.DebugHTTP,
 		TraceHTTP:   d.conf.TraceHTTP,
-	}).Start(ctx)
+	}), nil
 }
 
 func (d GSDownloader) BucketFileLocation() string {
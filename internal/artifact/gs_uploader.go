@@ -44,7 +44,7 @@ type GSUploader struct {
 }
 
 func NewGSUploader(ctx context.Context, l logger.Logger, c GSUploaderConfig) (*GSUploader, error) {
-	client, err := newGoogleClient(ctx, storage.DevstorageFullControlScope)
+	client, err := NewGoogleClient(ctx, storage.DevstorageFullControlScope)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Error creating Google Cloud Storage client: %v", err))
 	}
@@ -77,7 +77,10 @@ func clientFromJSON(data []byte, scope string) (*http.Client, error) {
 	return conf.Client(oauth2.NoContext), nil
 }
 
-func newGoogleClient(ctx context.Context, scope string) (*http.Client, error) {
+// NewGoogleClient returns an HTTP client authenticated with Google Cloud using
+// BUILDKITE_GS_APPLICATION_CREDENTIALS_JSON, BUILDKITE_GS_APPLICATION_CREDENTIALS,
+// or the default credentials, in that order.
+func NewGoogleClient(ctx context.Context, scope string) (*http.Client, error) {
 	if os.Getenv("BUILDKITE_GS_APPLICATION_CREDENTIALS_JSON") != "" {
 		data := []byte(os.Getenv("BUILDKITE_GS_APPLICATION_CREDENTIALS_JSON"))
 		return clientFromJSON(data, scope)
@@ -0,0 +1,69 @@
+package artifact
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/buildkite/agent/v3/api"
+)
+
+// ParseMetadata parses key=value pairs into artifact metadata. Keys can't be
+// empty, and can only be given once.
+func ParseMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	metadata := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid metadata %q, must be in the form key=value", pair)
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, fmt.Errorf("metadata key %q is given more than once", key)
+		}
+		metadata[key] = value
+	}
+	return metadata, nil
+}
+
+// ErrNoArtifactMetadata is returned when filtering artifacts by metadata, but
+// none of them have any.
+//
+// Note: the metadata field of artifacts hasn't been confirmed against the
+// Buildkite API. A server that doesn't know it drops the metadata given on
+// upload, and never returns any, which would otherwise look like nothing
+// matched.
+var ErrNoArtifactMetadata = errors.New("none of the artifacts found have metadata to filter by, which Buildkite may not support")
+
+// FilterByMetadata returns the artifacts that have every key in filter, with
+// the same value. If there's a filter, and none of the artifacts have any
+// metadata at all, it returns ErrNoArtifactMetadata.
+func FilterByMetadata(artifacts []*api.Artifact, filter map[string]string) ([]*api.Artifact, error) {
+	if len(filter) == 0 {
+		return artifacts, nil
+	}
+	var matches []*api.Artifact
+	anyMetadata := false
+	for _, artifact := range artifacts {
+		anyMetadata = anyMetadata || len(artifact.Metadata) > 0
+		if hasMetadata(artifact, filter) {
+			matches = append(matches, artifact)
+		}
+	}
+	if len(artifacts) > 0 && !anyMetadata {
+		return nil, ErrNoArtifactMetadata
+	}
+	return matches, nil
+}
+
+func hasMetadata(artifact *api.Artifact, filter map[string]string) bool {
+	for key, value := range filter {
+		if v, ok := artifact.Metadata[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
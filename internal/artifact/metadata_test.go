@@ -0,0 +1,72 @@
+package artifact
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseMetadata(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseMetadata([]string{"variant=release", "arch=arm64", "flags=a=b", "empty="})
+	if err != nil {
+		t.Fatalf("ParseMetadata(...) error = %v", err)
+	}
+	want := map[string]string{"variant": "release", "arch": "arm64", "flags": "a=b", "empty": ""}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ParseMetadata(...) diff (-got +want):\n%s", diff)
+	}
+
+	for _, pairs := range [][]string{{"variant"}, {"=release"}, {"arch=arm64", "arch=amd64"}} {
+		if _, err := ParseMetadata(pairs); err == nil {
+			t.Errorf("ParseMetadata(%q) error = nil, want an error", pairs)
+		}
+	}
+}
+
+func TestFilterByMetadata(t *testing.T) {
+	t.Parallel()
+
+	artifacts := []*api.Artifact{
+		{Path: "release-arm64.tar.gz", Metadata: map[string]string{"variant": "release", "arch": "arm64"}},
+		{Path: "release-amd64.tar.gz", Metadata: map[string]string{"variant": "release", "arch": "amd64"}},
+		{Path: "debug-arm64.tar.gz", Metadata: map[string]string{"variant": "debug", "arch": "arm64"}},
+		{Path: "notes.txt"},
+	}
+
+	tests := []struct {
+		filter map[string]string
+		want   []string
+	}{
+		{filter: nil, want: []string{"release-arm64.tar.gz", "release-amd64.tar.gz", "debug-arm64.tar.gz", "notes.txt"}},
+		{filter: map[string]string{"variant": "release"}, want: []string{"release-arm64.tar.gz", "release-amd64.tar.gz"}},
+		{filter: map[string]string{"variant": "release", "arch": "arm64"}, want: []string{"release-arm64.tar.gz"}},
+		{filter: map[string]string{"variant": "profile"}, want: nil},
+	}
+	for _, test := range tests {
+		var got []string
+		filtered, err := FilterByMetadata(artifacts, test.filter)
+		if err != nil {
+			t.Errorf("FilterByMetadata(artifacts, %v) error = %v", test.filter, err)
+		}
+		for _, a := range filtered {
+			got = append(got, a.Path)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("FilterByMetadata(artifacts, %v) diff (-got +want):\n%s", test.filter, diff)
+		}
+	}
+}
+
+func TestFilterByMetadataWithoutAnyMetadata(t *testing.T) {
+	t.Parallel()
+
+	artifacts := []*api.Artifact{{Path: "release-arm64.tar.gz"}, {Path: "notes.txt"}}
+	filter := map[string]string{"variant": "release"}
+	if _, err := FilterByMetadata(artifacts, filter); !errors.Is(err, ErrNoArtifactMetadata) {
+		t.Errorf("FilterByMetadata(artifacts, %v) error = %v, want %v", filter, err, ErrNoArtifactMetadata)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -19,10 +20,17 @@ import (
 )
 
 const (
-	regionHintEnvVar = "BUILDKITE_S3_DEFAULT_REGION"
-	s3EndpointEnvVar = "BUILDKITE_S3_ENDPOINT"
+	regionHintEnvVar          = "BUILDKITE_S3_DEFAULT_REGION"
+	s3EndpointEnvVar          = "BUILDKITE_S3_ENDPOINT"
+	s3ForcePathStyleEnvVar    = "BUILDKITE_S3_FORCE_PATH_STYLE"
+	s3ChecksumAlgorithmEnvVar = "BUILDKITE_S3_CHECKSUM_ALGORITHM"
 )
 
+// The region used for S3-compatible servers (such as Cloudflare R2 or MinIO)
+// when none is configured. Such servers generally accept it, even when they
+// don't have regions.
+const s3CompatibleDefaultRegion = "us-east-1"
+
 type buildkiteEnvProvider struct {
 	retrieved bool
 }
@@ -95,15 +103,49 @@ func awsS3Session(region string, l logger.Logger) (*session.Session, error) {
 		// without subdomain support.
 
 		// AWS CLI does this by default when a custom endpoint is specified [1] so
-		// we will too.
+		// we will too, unless told otherwise.
 		// [1]: https://github.com/aws/aws-cli/blob/2.9.18/awscli/botocore/args.py#L414-L417
-		l.Debug("S3 session S3ForcePathStyle=true because custom Endpoint specified")
-		sess.Config.S3ForcePathStyle = aws.Bool(true)
+		forcePathStyle, err := s3ForcePathStyle()
+		if err != nil {
+			return nil, err
+		}
+		l.Debug("S3 session S3ForcePathStyle=%t because custom Endpoint specified", forcePathStyle)
+		sess.Config.S3ForcePathStyle = aws.Bool(forcePathStyle)
 	}
 
 	return sess, nil
 }
 
+// s3ForcePathStyle reports whether to use path-style addressing with a custom
+// endpoint. It defaults to true.
+func s3ForcePathStyle() (bool, error) {
+	v := os.Getenv(s3ForcePathStyleEnvVar)
+	if v == "" {
+		return true, nil
+	}
+	forcePathStyle, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: %w", s3ForcePathStyleEnvVar, v, err)
+	}
+	return forcePathStyle, nil
+}
+
+// s3ChecksumAlgorithm returns the checksum algorithm that uploads should use,
+// or an empty string if they shouldn't send a checksum header. Some
+// S3-compatible servers don't support every algorithm.
+func s3ChecksumAlgorithm() (string, error) {
+	v := os.Getenv(s3ChecksumAlgorithmEnvVar)
+	if v == "" || strings.EqualFold(v, "none") {
+		return "", nil
+	}
+	for _, algorithm := range s3.ChecksumAlgorithm_Values() {
+		if strings.EqualFold(v, algorithm) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s value %q, must be one of none, %s", s3ChecksumAlgorithmEnvVar, v, strings.Join(s3.ChecksumAlgorithm_Values(), ", "))
+}
+
 func sharedCredentialsProvider() credentials.Provider {
 	// If empty SDK will default to environment variable "AWS_PROFILE"
 	// or "default" if environment variable is also not set.
@@ -133,6 +175,16 @@ func NewS3Client(l logger.Logger, bucket string) (*s3.S3, error) {
 			return nil, fmt.Errorf("Could not load the AWS SDK config (%w)", err)
 		}
 
+		sess = session
+	} else if endpoint := os.Getenv(s3EndpointEnvVar); endpoint != "" {
+		// Finding the bucket's region asks AWS, which doesn't know about
+		// buckets on other S3-compatible servers.
+		l.Debug("Using region %q for custom endpoint %q", s3CompatibleDefaultRegion, endpoint)
+		session, err := awsS3Session(s3CompatibleDefaultRegion, l)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load the AWS SDK config (%w)", err)
+		}
+
 		sess = session
 	} else {
 		// Otherwise, use the current region (or a guess) to dynamically find
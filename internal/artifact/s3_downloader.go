@@ -3,6 +3,7 @@ package artifact
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -29,6 +30,11 @@ type S3DownloaderConfig struct {
 	// How many times should it retry the download before giving up
 	Retries int
 
+	// How many chunks to download in parallel, and whether partial downloads
+	// should be resumed. See DownloadConfig.
+	Concurrency int
+	Resume      bool
+
 	// If failed responses should be dumped to the log
 	DebugHTTP    bool
 	TraceHTTP    bool
@@ -51,8 +57,26 @@ func NewS3Downloader(l logger.Logger, c S3DownloaderConfig) *S3Downloader {
 }
 
 func (d S3Downloader) Start(ctx context.Context) error {
+	dl, err := d.download()
+	if err != nil {
+		return err
+	}
+	return dl.Start(ctx)
+}
+
+// Stream downloads the file and writes it to w. See Download.Stream.
+func (d S3Downloader) Stream(ctx context.Context, w io.Writer) error {
+	dl, err := d.download()
+	if err != nil {
+		return err
+	}
+	return dl.Stream(ctx, w)
+}
+
+// download returns a regular download of a pre-signed URL for the file.
+func (d S3Downloader) download() (*Download, error) {
 	if d.conf.S3Client == nil {
-		return fmt.Errorf("S3Downloader for %s: S3Client is nil", d.conf.S3Path)
+		return nil, fmt.Errorf("S3Downloader for %s: S3Client is nil", d.conf.S3Path)
 	}
 
 	req, _ := d.conf.S3Client.GetObjectRequest(&s3.GetObjectInput{
@@ -62,7 +86,7 @@ func (d S3Downloader) Start(ctx context.Context) error {
 
 	signedURL, err := req.Presign(time.Hour)
 	if err != nil {
-		return fmt.Errorf("error pre-signing request: %w", err)
+		return nil, fmt.Errorf("error pre-signing request: %w", err)
 	}
 
 	// We can now cheat and pass the URL onto our regular downloader
@@ -75,9 +99,11 @@ func (d S3Downloader) Start(ctx context.Context) error {
 		Path:        d.conf.Path,
 		Destination: d.conf.Destination,
 		Retries:     d.conf.Retries,
+		Concurrency: d.conf.Concurrency,
+		Resume:      d.conf.Resume,
 		DebugHTTP:   d.conf.DebugHTTP,
 		TraceHTTP:   d.conf.TraceHTTP,
-	}).Start(ctx)
+	}), nil
 }
 
 func (d S3Downloader) BucketFileLocation() string {
@@ -32,6 +32,9 @@ type S3Uploader struct {
 	// The s3 client to use
 	client *s3.S3
 
+	// The checksum algorithm to upload with, if any
+	checksumAlgorithm string
+
 	// The configuration
 	conf S3UploaderConfig
 
@@ -42,6 +45,11 @@ type S3Uploader struct {
 func NewS3Uploader(ctx context.Context, l logger.Logger, c S3UploaderConfig) (*S3Uploader, error) {
 	bucketName, bucketPath := ParseS3Destination(c.Destination)
 
+	checksumAlgorithm, err := s3ChecksumAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
 	r := roko.NewRetrier(
 		roko.WithMaxAttempts(10),
 		roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
@@ -58,11 +66,12 @@ func NewS3Uploader(ctx context.Context, l logger.Logger, c S3UploaderConfig) (*S
 	}
 
 	return &S3Uploader{
-		logger:     l,
-		conf:       c,
-		client:     s3Client,
-		BucketName: bucketName,
-		BucketPath: bucketPath,
+		logger:            l,
+		conf:              c,
+		client:            s3Client,
+		checksumAlgorithm: checksumAlgorithm,
+		BucketName:        bucketName,
+		BucketPath:        bucketPath,
 	}, nil
 }
 
@@ -80,6 +89,8 @@ func (u *S3Uploader) URL(artifact *api.Artifact) string {
 
 	if os.Getenv("BUILDKITE_S3_ACCESS_URL") != "" {
 		baseUrl = os.Getenv("BUILDKITE_S3_ACCESS_URL")
+	} else if endpoint := os.Getenv(s3EndpointEnvVar); endpoint != "" {
+		baseUrl = s3CompatibleBucketURL(endpoint, u.BucketName)
 	}
 
 	url, _ := url.Parse(baseUrl)
@@ -137,11 +148,33 @@ func (u *s3UploaderWork) DoWork(context.Context) (*api.ArtifactPartETag, error)
 	if u.serverSideEncryptionEnabled() {
 		params.ServerSideEncryption = aws.String("AES256")
 	}
+	if u.checksumAlgorithm != "" {
+		params.ChecksumAlgorithm = aws.String(u.checksumAlgorithm)
+	}
 
 	_, err = uploader.Upload(params)
 	return nil, err
 }
 
+// s3CompatibleBucketURL returns the URL of a bucket on an S3-compatible server
+// at endpoint, which may be a hostname or a URL, using the same addressing
+// style as uploads.
+func s3CompatibleBucketURL(endpoint, bucket string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+	if forcePathStyle, err := s3ForcePathStyle(); err == nil && !forcePathStyle {
+		u.Host = bucket + "." + u.Host
+		return u.String()
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket
+	return u.String()
+}
+
 func (u *S3Uploader) artifactPath(artifact *api.Artifact) string {
 	parts := []string{u.BucketPath, artifact.Path}
 
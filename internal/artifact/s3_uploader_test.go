@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/buildkite/agent/v3/api"
 	"github.com/stretchr/testify/require"
 )
 
@@ -88,3 +89,72 @@ func TestResolvePermission(t *testing.T) {
 		os.Unsetenv("BUILDKITE_S3_ACL")
 	}
 }
+
+func TestS3ChecksumAlgorithm(t *testing.T) {
+	for _, tc := range []struct {
+		value     string
+		want      string
+		shouldErr bool
+	}{
+		{"", "", false},
+		{"none", "", false},
+		{"CRC32", "CRC32", false},
+		{"crc32c", "CRC32C", false},
+		{"Sha1", "SHA1", false},
+		{"SHA256", "SHA256", false},
+		{"md5", "", true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv("BUILDKITE_S3_CHECKSUM_ALGORITHM", tc.value)
+			got, err := s3ChecksumAlgorithm()
+			if tc.shouldErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestS3UploaderURLWithCustomEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		endpoint       string
+		forcePathStyle string
+		want           string
+	}{
+		{
+			name:     "path style by default",
+			endpoint: "https://account.r2.cloudflarestorage.com",
+			want:     "https://account.r2.cloudflarestorage.com/my-bucket/foo/bar.txt",
+		},
+		{
+			name:     "host without scheme",
+			endpoint: "minio.example.com:9000/",
+			want:     "https://minio.example.com:9000/my-bucket/foo/bar.txt",
+		},
+		{
+			name:           "virtual hosted style",
+			endpoint:       "http://minio.example.com",
+			forcePathStyle: "false",
+			want:           "http://my-bucket.minio.example.com/foo/bar.txt",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("BUILDKITE_S3_ACCESS_URL", "")
+			t.Setenv("BUILDKITE_S3_ENDPOINT", tc.endpoint)
+			t.Setenv("BUILDKITE_S3_FORCE_PATH_STYLE", tc.forcePathStyle)
+
+			uploader := &S3Uploader{BucketName: "my-bucket"}
+			got := uploader.URL(&api.Artifact{Path: "foo/bar.txt"})
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestS3ForcePathStyleInvalid(t *testing.T) {
+	t.Setenv("BUILDKITE_S3_FORCE_PATH_STYLE", "sometimes")
+	_, err := s3ForcePathStyle()
+	require.Error(t, err)
+}
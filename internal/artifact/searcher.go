@@ -28,7 +28,37 @@ func NewSearcher(l logger.Logger, ac APIClient, buildID string) *Searcher {
 	}
 }
 
+// Search searches for finished artifacts matching query.
 func (a *Searcher) Search(ctx context.Context, query, scope string, includeRetriedJobs, includeDuplicates bool) ([]*api.Artifact, error) {
+	return a.SearchPatterns(ctx, []string{query}, scope, "finished", includeRetriedJobs, includeDuplicates)
+}
+
+// SearchPatterns searches for artifacts in the given state matching any of
+// the queries. An artifact matching more than one query is only returned once.
+func (a *Searcher) SearchPatterns(ctx context.Context, queries []string, scope, state string, includeRetriedJobs, includeDuplicates bool) ([]*api.Artifact, error) {
+	if len(queries) == 1 {
+		return a.search(ctx, queries[0], scope, state, includeRetriedJobs, includeDuplicates)
+	}
+
+	var results []*api.Artifact
+	seen := make(map[string]bool)
+	for _, query := range queries {
+		artifacts, err := a.search(ctx, query, scope, state, includeRetriedJobs, includeDuplicates)
+		if err != nil {
+			return nil, err
+		}
+		for _, artifact := range artifacts {
+			if artifact.ID != "" && seen[artifact.ID] {
+				continue
+			}
+			seen[artifact.ID] = true
+			results = append(results, artifact)
+		}
+	}
+	return results, nil
+}
+
+func (a *Searcher) search(ctx context.Context, query, scope, state string, includeRetriedJobs, includeDuplicates bool) ([]*api.Artifact, error) {
 	if scope == "" {
 		a.logger.Info("Searching for artifacts: \"%s\"", query)
 	} else {
@@ -44,7 +74,7 @@ func (a *Searcher) Search(ctx context.Context, query, scope string, includeRetri
 		artifacts, _, err := a.apiClient.SearchArtifacts(ctx, a.buildID, &api.ArtifactSearchOptions{
 			Query:              query,
 			Scope:              scope,
-			State:              "finished",
+			State:              state,
 			IncludeRetriedJobs: includeRetriedJobs,
 			IncludeDuplicates:  includeDuplicates,
 		})
@@ -55,3 +55,37 @@ func TestArtifactSearcherConnectsToEndpoint(t *testing.T) {
 		URL:          "http://example.com/download",
 	}}, artifacts)
 }
+
+func TestArtifactSearcherSearchPatterns(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.RequestURI() {
+		case "/builds/my-build/artifacts/search?query=%2A.txt&scope=tests&state=error":
+			fmt.Fprint(rw, `[{"id": "a", "path": "llamas.txt"}, {"id": "b", "path": "alpacas.txt"}]`)
+		case "/builds/my-build/artifacts/search?query=llamas.%2A&scope=tests&state=error":
+			fmt.Fprint(rw, `[{"id": "a", "path": "llamas.txt"}, {"id": "c", "path": "llamas.log"}]`)
+		default:
+			http.Error(rw, "Not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	s := NewSearcher(logger.Discard, ac, "my-build")
+
+	artifacts, err := s.SearchPatterns(context.Background(), []string{"*.txt", "llamas.*"}, "tests", "error", false, false)
+	if err != nil {
+		t.Fatalf("s.SearchPatterns(...) error = %v", err)
+	}
+
+	assert.Equal(t, []*api.Artifact{
+		{ID: "a", Path: "llamas.txt"},
+		{ID: "b", Path: "alpacas.txt"},
+		{ID: "c", Path: "llamas.log"},
+	}, artifacts)
+}
@@ -0,0 +1,59 @@
+package artifact
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Blocks of zeros this big are skipped over when extracting files, to make
+// holes in them.
+const sparseBlockSize = 64 * 1024
+
+// writeSparse copies r to f, seeking over blocks of zeros rather than writing
+// them, so that the holes in a sparse file are made again instead of being
+// filled in. Where the filesystem doesn't support holes, they read as zeros
+// all the same.
+func writeSparse(f *os.File, r io.Reader) error {
+	buf := make([]byte, sparseBlockSize)
+	var size int64
+	hole := false
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if isZeros(buf[:n]) {
+				if _, err := f.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+				hole = true
+			} else {
+				if _, err := f.Write(buf[:n]); err != nil {
+					return err
+				}
+				hole = false
+			}
+			size += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Seeking past the end doesn't make the file any longer.
+	if hole {
+		return f.Truncate(size)
+	}
+	return nil
+}
+
+func isZeros(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package artifact
+
+import "os"
+
+// allocatedSize isn't known on this platform.
+func allocatedSize(os.FileInfo) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,146 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+)
+
+// SyncPlan describes the differences between a local directory and the
+// artifacts that have already been uploaded.
+type SyncPlan struct {
+	// Local files (relative to the working directory) that are missing from,
+	// or differ from, the uploaded artifacts.
+	Upload []string
+
+	// Uploaded artifacts that are missing from, or differ from, the local
+	// directory.
+	Download []*api.Artifact
+
+	// The number of files that are identical locally and remotely.
+	Unchanged int
+}
+
+// localFile is a file found while walking the local directory.
+type localFile struct {
+	path               string
+	sha1sum, sha256sum string
+}
+
+// PlanSync compares the files within dir against the artifacts within scope
+// (typically a job ID) of the build, by path and checksum.
+func PlanSync(ctx context.Context, l logger.Logger, ac APIClient, buildID, scope, dir string) (*SyncPlan, error) {
+	local, err := hashLocalFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	query := path.Join(filepath.ToSlash(filepath.Clean(dir)), "**")
+	remote, err := NewSearcher(l, ac, buildID).Search(ctx, query, scope, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("searching for artifacts: %w", err)
+	}
+
+	prefix := filepath.ToSlash(filepath.Clean(dir)) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	// A path may have been uploaded more than once, in which case the newest
+	// upload is the one to compare against.
+	latest := make(map[string]*api.Artifact)
+	for _, artifact := range remote {
+		key := normaliseSyncPath(artifact.Path)
+		if !strings.HasPrefix(key, prefix) {
+			// The search query is a glob, so be careful about what it matched.
+			continue
+		}
+		if prev, ok := latest[key]; !ok || !artifact.CreatedAt.Before(prev.CreatedAt) {
+			latest[key] = artifact
+		}
+	}
+	keys := make([]string, 0, len(latest))
+	for key := range latest {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	plan := &SyncPlan{}
+	for _, key := range keys {
+		artifact := latest[key]
+		lf, ok := local[key]
+		switch {
+		case !ok:
+			plan.Download = append(plan.Download, artifact)
+		case artifact.Sha256Sum != "" && artifact.Sha256Sum == lf.sha256sum,
+			artifact.Sha256Sum == "" && artifact.Sha1Sum == lf.sha1sum:
+			plan.Unchanged++
+		default:
+			// Present on both sides but different. The local copy is the one
+			// being worked on, so it wins.
+			plan.Upload = append(plan.Upload, lf.path)
+		}
+	}
+
+	for key, lf := range local {
+		if latest[key] == nil {
+			plan.Upload = append(plan.Upload, lf.path)
+		}
+	}
+	slices.Sort(plan.Upload)
+
+	return plan, nil
+}
+
+// hashLocalFiles walks dir and hashes each regular file, keyed by its
+// normalised path.
+func hashLocalFiles(dir string) (map[string]localFile, error) {
+	files := make(map[string]localFile)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hash1, hash256 := sha1.New(), sha256.New()
+		if _, err := io.Copy(io.MultiWriter(hash1, hash256), f); err != nil {
+			return fmt.Errorf("reading contents of %s: %w", p, err)
+		}
+
+		files[normaliseSyncPath(p)] = localFile{
+			path:      p,
+			sha1sum:   fmt.Sprintf("%040x", hash1.Sum(nil)),
+			sha256sum: fmt.Sprintf("%064x", hash256.Sum(nil)),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// normaliseSyncPath converts a local or artifact path into a form that can be
+// compared, regardless of which OS uploaded the artifact.
+func normaliseSyncPath(p string) string {
+	return path.Clean(strings.ReplaceAll(filepath.ToSlash(p), `\`, "/"))
+}
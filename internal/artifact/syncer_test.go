@@ -0,0 +1,109 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanSync(t *testing.T) {
+	// Not parallel: artifact paths are relative to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd() error = %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("os.Chdir() error = %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck // best-effort restore
+
+	files := map[string]string{
+		"cache/same.txt":        "same",
+		"cache/changed.txt":     "new contents",
+		"cache/new.txt":         "brand new",
+		"cache/nested/same.txt": "also same",
+		"cache/sha1-only.txt":   "old artifact",
+		"elsewhere/ignored.txt": "not in the sync directory",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(name), err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", name, err)
+		}
+	}
+
+	sha256sum := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+
+	earlier, later := time.Now().Add(-time.Hour), time.Now()
+	remote := []*api.Artifact{
+		{Path: "cache/same.txt", Sha256Sum: sha256sum("same"), CreatedAt: later},
+		{Path: "cache/changed.txt", Sha256Sum: sha256sum("old contents")},
+		{Path: `cache\nested\same.txt`, Sha256Sum: sha256sum("also same")},
+		{Path: "cache/sha1-only.txt", Sha1Sum: "2f5e9a5c3b4d9f1e4c0f4b4f6a7c5b8d9e0a1b2c"},
+		{Path: "cache/remote-only.txt", Sha256Sum: sha256sum("remote")},
+		{Path: "cachet/not-a-match.txt", Sha256Sum: sha256sum("nope")},
+
+		// Paths uploaded more than once are compared against the newest
+		// upload, and only planned once.
+		{Path: "cache/same.txt", Sha256Sum: sha256sum("superseded"), CreatedAt: earlier},
+		{Path: "cache/changed.txt", Sha256Sum: sha256sum("older contents")},
+		{Path: "cache/remote-only.txt", Sha256Sum: sha256sum("remote again")},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/builds/my-build/artifacts/search" {
+			http.Error(rw, "Not found", http.StatusNotFound)
+			return
+		}
+		if got, want := req.URL.Query().Get("scope"), "my-job"; got != want {
+			http.Error(rw, fmt.Sprintf("scope = %q, want %q", got, want), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(rw).Encode(remote) //nolint:errcheck // test server
+	}))
+	t.Cleanup(server.Close)
+
+	ac := api.NewClient(logger.Discard, api.Config{
+		Endpoint: server.URL,
+		Token:    "llamasforever",
+	})
+
+	plan, err := PlanSync(context.Background(), logger.Discard, ac, "my-build", "my-job", "cache")
+	if err != nil {
+		t.Fatalf("PlanSync() error = %v", err)
+	}
+
+	wantUpload := []string{
+		filepath.Join("cache", "changed.txt"),
+		filepath.Join("cache", "new.txt"),
+		filepath.Join("cache", "sha1-only.txt"),
+	}
+	if diff := cmp.Diff(plan.Upload, wantUpload); diff != "" {
+		t.Errorf("plan.Upload diff (-got +want):\n%s", diff)
+	}
+
+	var gotDownload []string
+	for _, a := range plan.Download {
+		gotDownload = append(gotDownload, a.Path)
+	}
+	if diff := cmp.Diff(gotDownload, []string{"cache/remote-only.txt"}); diff != "" {
+		t.Errorf("plan.Download diff (-got +want):\n%s", diff)
+	}
+
+	if got, want := plan.Unchanged, 2; got != want {
+		t.Errorf("plan.Unchanged = %d, want %d", got, want)
+	}
+}
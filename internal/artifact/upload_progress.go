@@ -0,0 +1,74 @@
+package artifact
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/buildkite/agent/v3/api"
+	"github.com/dustin/go-humanize"
+)
+
+// How often the progress of an upload is logged.
+const uploadProgressInterval = 10 * time.Second
+
+// uploadProgress counts the files that have been found and uploaded, so that
+// the progress of a long upload can be reported.
+type uploadProgress struct {
+	start time.Time
+
+	found         atomic.Int64
+	foundBytes    atomic.Int64
+	finished      atomic.Bool // whether every file has been found
+	uploaded      atomic.Int64
+	uploadedBytes atomic.Int64
+}
+
+func newUploadProgress(start time.Time) *uploadProgress {
+	return &uploadProgress{start: start}
+}
+
+// add counts an artifact that has been found.
+func (p *uploadProgress) add(artifact *api.Artifact) {
+	p.found.Add(1)
+	p.foundBytes.Add(artifact.FileSize)
+}
+
+// finishFinding records that every file has been found.
+func (p *uploadProgress) finishFinding() {
+	p.finished.Store(true)
+}
+
+// upload counts an artifact that has been uploaded.
+func (p *uploadProgress) upload(artifact *api.Artifact) {
+	p.uploaded.Add(1)
+	p.uploadedBytes.Add(artifact.FileSize)
+}
+
+// report describes the progress of the upload at now, for example:
+//
+//	Uploaded 1,200 of 5,000 files (1.2 GiB of 4.8 GiB, 20 MiB/s, about 3m4s left)
+func (p *uploadProgress) report(now time.Time) string {
+	found, foundBytes := p.found.Load(), p.foundBytes.Load()
+	uploaded, uploadedBytes := p.uploaded.Load(), p.uploadedBytes.Load()
+
+	of := "files found so far"
+	if p.finished.Load() {
+		of = "files"
+	}
+
+	rate := 0.0
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = float64(uploadedBytes) / elapsed
+	}
+
+	details := fmt.Sprintf("%s of %s, %s/s",
+		humanize.IBytes(uint64(uploadedBytes)), humanize.IBytes(uint64(foundBytes)), humanize.IBytes(uint64(rate)))
+	// The time left can only be estimated once everything has been found.
+	if p.finished.Load() && rate > 0 {
+		left := time.Duration(float64(foundBytes-uploadedBytes) / rate * float64(time.Second))
+		details += fmt.Sprintf(", about %s left", left.Round(time.Second))
+	}
+
+	return fmt.Sprintf("Uploaded %s of %s %s (%s)", humanize.Comma(uploaded), humanize.Comma(found), of, details)
+}
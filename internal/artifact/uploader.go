@@ -32,6 +32,10 @@ const (
 	ArtifactFallbackMimeType = "binary/octet-stream"
 )
 
+// Files with at least this much of their size in holes are warned about, as
+// the holes are uploaded as zeros.
+const sparseWarnSize = 1024 * 1024
+
 type UploaderConfig struct {
 	// The ID of the Job
 	JobID string
@@ -39,12 +43,19 @@ type UploaderConfig struct {
 	// The path of the uploads
 	Paths string
 
+	// Files to upload, taken as they are rather than as glob patterns. If
+	// set, Paths is ignored
+	LiteralPaths []string
+
 	// Where we'll be uploading artifacts
 	Destination string
 
 	// A specific Content-Type to use for all artifacts
 	ContentType string
 
+	// Key/value tags to store with all artifacts
+	Metadata map[string]string
+
 	// Standard HTTP options.
 	DebugHTTP    bool
 	TraceHTTP    bool
@@ -56,8 +67,23 @@ type UploaderConfig struct {
 	// Whether to not upload symlinks
 	UploadSkipSymlinks bool
 
+	// Whether to bundle symlinks as symlinks, rather than the files they link
+	// to. Only bundles can hold symlinks, so Compression must be set
+	PreserveSymlinks bool
+
 	// Whether to allow multipart uploads to the BK-hosted bucket
 	AllowMultipart bool
+
+	// How many artifacts (or parts of artifacts) to upload in parallel. If
+	// zero, GOMAXPROCS is used
+	Concurrency int
+
+	// If set, artifacts are encrypted before they are uploaded
+	Encryption *EncryptionConfig
+
+	// If set, artifacts are bundled into a single archive compressed with
+	// this format (one of CompressionFormats) before they are uploaded
+	Compression string
 }
 
 type Uploader struct {
@@ -69,6 +95,12 @@ type Uploader struct {
 
 	// The APIClient that will be used when uploading jobs
 	apiClient APIClient
+
+	// Temporary directory holding encrypted or bundled copies of artifacts
+	tempDir string
+
+	// The progress of the last upload
+	progress *uploadProgress
 }
 
 func NewUploader(l logger.Logger, ac APIClient, c UploaderConfig) *Uploader {
@@ -80,6 +112,88 @@ func NewUploader(l logger.Logger, ac APIClient, c UploaderConfig) *Uploader {
 }
 
 func (a *Uploader) Upload(ctx context.Context) error {
+	if a.conf.PreserveSymlinks && a.conf.Compression == "" {
+		return errors.New("symlinks can only be preserved when bundling artifacts with compression")
+	}
+
+	if a.conf.Encryption.Enabled() || a.conf.Compression != "" {
+		dir, err := os.MkdirTemp("", "buildkite-artifacts")
+		if err != nil {
+			return fmt.Errorf("creating temporary directory for artifacts: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		a.tempDir = dir
+	}
+
+	// A bundle can only be made once every file has been found.
+	if a.conf.Compression != "" {
+		return a.uploadBundle(ctx)
+	}
+
+	// Determine what uploader to use
+	uploader, err := a.createUploader(ctx)
+	if err != nil {
+		return fmt.Errorf("creating uploader: %w", err)
+	}
+
+	// Files are found and checksummed, created on Buildkite in batches, and
+	// uploaded, all at the same time. Each stage waits for the next to catch
+	// up, so only a few artifacts are held in memory at once, however many
+	// files match.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	progress := newUploadProgress(time.Now())
+	a.progress = progress
+	foundCh := make(chan *api.Artifact)
+	createdCh := make(chan *api.Artifact, artifactBatchSize)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(foundCh)
+		if err := a.collectTo(ctx, foundCh); err != nil {
+			cancel(fmt.Errorf("collecting artifacts: %w", err))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(createdCh)
+		if err := a.createBatches(ctx, foundCh, createdCh, uploader, progress); err != nil {
+			cancel(err)
+		}
+	}()
+
+	stopReporting := a.reportProgress(ctx, progress)
+	err = a.upload(ctx, createdCh, uploader, progress)
+	stopReporting()
+	if err != nil {
+		cancel(fmt.Errorf("uploading artifacts: %w", err))
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+
+	if progress.found.Load() == 0 {
+		a.logger.Info("No files matched paths: %s", a.conf.Paths)
+	}
+	return nil
+}
+
+// uploadBundle bundles every file that matches into a single archive, and
+// uploads it.
+// UploadedBytes returns how many bytes the last upload uploaded.
+func (a *Uploader) UploadedBytes() int64 {
+	if a.progress == nil {
+		return 0
+	}
+	return a.progress.uploadedBytes.Load()
+}
+
+func (a *Uploader) uploadBundle(ctx context.Context) error {
 	// Create artifact structs for all the files we need to upload
 	artifacts, err := a.collect(ctx)
 	if err != nil {
@@ -93,37 +207,145 @@ func (a *Uploader) Upload(ctx context.Context) error {
 
 	a.logger.Info("Found %d files that match %q", len(artifacts), a.conf.Paths)
 
+	bundle, err := a.bundle(ctx, artifacts)
+	if err != nil {
+		return fmt.Errorf("bundling artifacts: %w", err)
+	}
+
 	// Determine what uploader to use
 	uploader, err := a.createUploader(ctx)
 	if err != nil {
 		return fmt.Errorf("creating uploader: %w", err)
 	}
+	bundle.URL = uploader.URL(bundle)
 
-	// Set the URLs of the artifacts based on the uploader
-	for _, artifact := range artifacts {
-		artifact.URL = uploader.URL(artifact)
-	}
-
-	// Batch-create the artifact records on Buildkite
+	// Create the artifact record on Buildkite
 	batchCreator := NewArtifactBatchCreator(a.logger, a.apiClient, BatchCreatorConfig{
 		JobID:                  a.conf.JobID,
-		Artifacts:              artifacts,
+		Artifacts:              []*api.Artifact{bundle},
 		UploadDestination:      a.conf.Destination,
 		CreateArtifactsTimeout: 10 * time.Second,
 		AllowMultipart:         a.conf.AllowMultipart,
 	})
-	artifacts, err = batchCreator.Create(ctx)
-	if err != nil {
+	if _, err := batchCreator.Create(ctx); err != nil {
 		return err
 	}
 
-	if err := a.upload(ctx, artifacts, uploader); err != nil {
+	createdCh := make(chan *api.Artifact, 1)
+	createdCh <- bundle
+	close(createdCh)
+
+	progress := newUploadProgress(time.Now())
+	a.progress = progress
+	progress.add(bundle)
+	progress.finishFinding()
+	if err := a.upload(ctx, createdCh, uploader, progress); err != nil {
 		return fmt.Errorf("uploading artifacts: %w", err)
 	}
 
 	return nil
 }
 
+// createBatches sets the URL of each artifact received from foundCh, creates
+// them on Buildkite in batches, and sends them on to createdCh. A batch that
+// hasn't filled within artifactBatchWait is created as it is, so that a slow
+// search doesn't hold up uploads.
+func (a *Uploader) createBatches(ctx context.Context, foundCh <-chan *api.Artifact, createdCh chan<- *api.Artifact, uploader workCreator, progress *uploadProgress) error {
+	var batch []*api.Artifact
+	var wait <-chan time.Time
+
+	create := func() error {
+		wait = nil
+		if len(batch) == 0 {
+			return nil
+		}
+		batchCreator := NewArtifactBatchCreator(a.logger, a.apiClient, BatchCreatorConfig{
+			JobID:                  a.conf.JobID,
+			Artifacts:              batch,
+			UploadDestination:      a.conf.Destination,
+			CreateArtifactsTimeout: 10 * time.Second,
+			AllowMultipart:         a.conf.AllowMultipart,
+		})
+		created, err := batchCreator.Create(ctx)
+		if err != nil {
+			return err
+		}
+		batch = nil
+
+		for _, artifact := range created {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case createdCh <- artifact:
+			}
+		}
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-wait:
+			if err := create(); err != nil {
+				return err
+			}
+
+		case artifact, open := <-foundCh:
+			if !open {
+				progress.finishFinding()
+				if n := progress.found.Load(); n > 0 {
+					a.logger.Info("Found %d files that match %q", n, a.conf.Paths)
+				}
+				return create()
+			}
+
+			// Set the URL of the artifact based on the uploader
+			artifact.URL = uploader.URL(artifact)
+			progress.add(artifact)
+
+			batch = append(batch, artifact)
+			if len(batch) >= artifactBatchSize {
+				if err := create(); err != nil {
+					return err
+				}
+				continue
+			}
+			if wait == nil {
+				wait = time.After(artifactBatchWait)
+			}
+		}
+	}
+}
+
+// reportProgress logs the progress of the upload every uploadProgressInterval
+// until the returned func is called.
+func (a *Uploader) reportProgress(ctx context.Context, progress *uploadProgress) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(uploadProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case now := <-ticker.C:
+				a.logger.Info("%s", progress.report(now))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
 func isSymlink(path string) bool {
 	fi, err := os.Lstat(path)
 	if err != nil {
@@ -132,6 +354,30 @@ func isSymlink(path string) bool {
 	return fi.Mode()&os.ModeSymlink != 0
 }
 
+// findSymlinkCycle returns the first symlink on the path to absolutePath that
+// links to a directory containing the symlink, and the directory it links to,
+// if there is one. Following such a symlink goes round in circles.
+func findSymlinkCycle(absolutePath string) (link, target string, err error) {
+	for dir := filepath.Dir(absolutePath); ; dir = filepath.Dir(dir) {
+		if isSymlink(dir) {
+			target, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				return "", "", err
+			}
+			parent, err := filepath.EvalSymlinks(filepath.Dir(dir))
+			if err != nil {
+				return "", "", err
+			}
+			if parent == target || strings.HasPrefix(parent, strings.TrimSuffix(target, string(filepath.Separator))+string(filepath.Separator)) {
+				return dir, target, nil
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return "", "", nil
+		}
+	}
+}
+
 func isDir(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -140,16 +386,40 @@ func isDir(path string) bool {
 	return fi.IsDir()
 }
 
+// collect returns artifacts for all the files that match.
 func (a *Uploader) collect(ctx context.Context) ([]*api.Artifact, error) {
+	artifactsCh := make(chan *api.Artifact)
+	done := make(chan struct{})
+	var artifacts []*api.Artifact
+	go func() {
+		defer close(done)
+		for artifact := range artifactsCh {
+			artifacts = append(artifacts, artifact)
+		}
+	}()
+
+	err := a.collectTo(ctx, artifactsCh)
+	close(artifactsCh)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// collectTo sends artifacts for the files that match to artifactsCh, as they
+// are found.
+func (a *Uploader) collectTo(ctx context.Context, artifactsCh chan<- *api.Artifact) error {
 	wd, err := os.Getwd()
 	if err != nil {
-		return nil, fmt.Errorf("getting working directory: %w", err)
+		return fmt.Errorf("getting working directory: %w", err)
 	}
 
 	ac := &artifactCollector{
-		Uploader:  a,
-		wd:        wd,
-		seenPaths: make(map[string]bool),
+		Uploader:    a,
+		wd:          wd,
+		seenPaths:   make(map[string]bool),
+		artifactsCh: artifactsCh,
 	}
 
 	filesCh := make(chan string)
@@ -159,6 +429,7 @@ func (a *Uploader) collect(ctx context.Context) ([]*api.Artifact, error) {
 	// workers will avoid slamming the runtime (as could happen with a
 	// goroutine per file).
 	wctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 	var wg sync.WaitGroup
 	for range runtime.GOMAXPROCS(0) {
 		wg.Add(1)
@@ -178,11 +449,7 @@ func (a *Uploader) collect(ctx context.Context) ([]*api.Artifact, error) {
 
 	wg.Wait()
 
-	if err := context.Cause(wctx); err != nil {
-		return nil, err
-	}
-
-	return ac.artifacts, nil
+	return context.Cause(wctx)
 }
 
 // artifactCollector processes glob patterns into files.
@@ -192,7 +459,8 @@ type artifactCollector struct {
 
 	mu        sync.Mutex
 	seenPaths map[string]bool
-	artifacts []*api.Artifact
+
+	artifactsCh chan<- *api.Artifact
 }
 
 // glob resolves the globs (patterns with * and ** in them).
@@ -200,16 +468,33 @@ func (a *Uploader) glob(ctx context.Context, filesCh chan<- string) error {
 	// glob is solely responsible for writing to the channel.
 	defer close(filesCh)
 
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	if a.conf.LiteralPaths != nil {
+		for _, path := range a.conf.LiteralPaths {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case filesCh <- path:
+			}
+		}
+		return nil
+	}
+
+	globs, err := ParseGlobs(a.conf.Paths, wd)
+	if err != nil {
+		return err
+	}
+
 	if experiments.IsEnabled(ctx, experiments.UseZZGlob) {
 		// New zzglob library. Do all globs at once with MultiGlob, which takes
 		// care of any necessary parallelism under the hood.
 		a.logger.Debug("Searching for %s", a.conf.Paths)
 		var patterns []*zzglob.Pattern
-		for _, globPath := range strings.Split(a.conf.Paths, ArtifactPathDelimiter) {
-			globPath := strings.TrimSpace(globPath)
-			if globPath == "" {
-				continue
-			}
+		for _, globPath := range globs.Include {
 			pattern, err := zzglob.Parse(globPath)
 			if err != nil {
 				return fmt.Errorf("invalid glob pattern %q: %w", globPath, err)
@@ -226,8 +511,16 @@ func (a *Uploader) glob(ctx context.Context, filesCh chan<- string) error {
 				a.logger.Warn("One of the glob patterns matched a directory: %s", path)
 				return nil
 			}
-			filesCh <- path
-			return nil
+			if globs.Excludes(path, wd) {
+				a.logger.Debug("Excluding %s", path)
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case filesCh <- path:
+				return nil
+			}
 		}
 		err := zzglob.MultiGlob(ctx, patterns, walkDirFunc, zzglob.TraverseSymlinks(a.conf.GlobResolveFollowSymlinks))
 		if err != nil {
@@ -243,11 +536,7 @@ func (a *Uploader) glob(ctx context.Context, filesCh chan<- string) error {
 		// Follow symbolic links for files & directories while expanding globs
 		globfunc = zglob.GlobFollowSymlinks
 	}
-	for _, globPath := range strings.Split(a.conf.Paths, ArtifactPathDelimiter) {
-		globPath := strings.TrimSpace(globPath)
-		if globPath == "" {
-			continue
-		}
+	for _, globPath := range globs.Include {
 		files, err := globfunc(globPath)
 		if errors.Is(err, os.ErrNotExist) {
 			a.logger.Info("File not found: %s", globPath)
@@ -257,7 +546,15 @@ func (a *Uploader) glob(ctx context.Context, filesCh chan<- string) error {
 			return fmt.Errorf("resolving glob %s: %w", globPath, err)
 		}
 		for _, path := range files {
-			filesCh <- path
+			if globs.Excludes(path, wd) {
+				a.logger.Debug("Excluding %s", path)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case filesCh <- path:
+			}
 		}
 	}
 	return nil
@@ -298,15 +595,33 @@ func (c *artifactCollector) worker(ctx context.Context, filesCh <-chan string) e
 			continue
 		}
 
+		symlink := isSymlink(absolutePath)
+		if symlink && c.conf.UploadSkipSymlinks {
+			c.logger.Debug("Skipping symlink %s", file)
+			continue
+		}
+		preserve := symlink && c.conf.PreserveSymlinks
+
 		// Ignore directories, we only want files
-		if isDir(absolutePath) {
+		if !preserve && isDir(absolutePath) {
 			c.logger.Debug("Skipping directory %s", file)
 			continue
 		}
 
-		if c.conf.UploadSkipSymlinks && isSymlink(absolutePath) {
-			c.logger.Debug("Skipping symlink %s", file)
-			continue
+		if symlink && !preserve {
+			if _, err := os.Stat(absolutePath); err != nil {
+				return fmt.Errorf("following symlink %s (it may be broken, or part of a cycle): %w", file, err)
+			}
+		}
+
+		if c.conf.GlobResolveFollowSymlinks {
+			link, target, err := findSymlinkCycle(absolutePath)
+			if err != nil {
+				return fmt.Errorf("checking %s for symlink cycles: %w", file, err)
+			}
+			if link != "" {
+				return fmt.Errorf("%s was found by following a symlink cycle: %s links to %s, which contains it", file, link, target)
+			}
 		}
 
 		// If a path is absolute, we need to make it relative to the root so that
@@ -332,19 +647,49 @@ func (c *artifactCollector) worker(ctx context.Context, filesCh <-chan string) e
 			path = filepath.ToSlash(path)
 		}
 
-		// Build an artifact object using the paths we have.
-		artifact, err := c.build(path, absolutePath)
-		if err != nil {
-			return fmt.Errorf("building artifact: %w", err)
+		// Build an artifact object using the paths we have. A preserved
+		// symlink is only ever bundled, so its target isn't read.
+		artifact := &api.Artifact{Path: path, AbsolutePath: absolutePath}
+		if !preserve {
+			artifact, err = c.build(ctx, path, absolutePath)
+			if err != nil {
+				return fmt.Errorf("building artifact: %w", err)
+			}
 		}
 
-		c.mu.Lock()
-		c.artifacts = append(c.artifacts, artifact)
-		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c.artifactsCh <- artifact:
+		}
 	}
 }
 
-func (a *Uploader) build(path string, absolutePath string) (*api.Artifact, error) {
+func (a *Uploader) build(ctx context.Context, path string, absolutePath string) (*api.Artifact, error) {
+	// Determine the Content-Type to send before any encryption changes the
+	// file name.
+	contentType := a.conf.ContentType
+
+	if contentType == "" {
+		extension := filepath.Ext(absolutePath)
+		contentType = mime.TypeByExtension(extension)
+
+		if contentType == "" {
+			contentType = ArtifactFallbackMimeType
+		}
+	}
+
+	// If encryption is enabled, the encrypted copy is what gets hashed and
+	// uploaded. Bundled files are encrypted as part of the bundle instead.
+	if a.conf.Encryption.Enabled() && a.conf.Compression == "" {
+		encryptedPath, err := EncryptFile(ctx, a.conf.Encryption, absolutePath, a.tempDir)
+		if err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w", absolutePath, err)
+		}
+		a.logger.Debug("Encrypted %s to %s", absolutePath, encryptedPath)
+		absolutePath = encryptedPath
+	}
+
 	// Open the file to hash its contents.
 	file, err := os.Open(absolutePath)
 	if err != nil {
@@ -362,15 +707,11 @@ func (a *Uploader) build(path string, absolutePath string) (*api.Artifact, error
 	sha1sum := fmt.Sprintf("%040x", hash1.Sum(nil))
 	sha256sum := fmt.Sprintf("%064x", hash256.Sum(nil))
 
-	// Determine the Content-Type to send
-	contentType := a.conf.ContentType
-
-	if contentType == "" {
-		extension := filepath.Ext(absolutePath)
-		contentType = mime.TypeByExtension(extension)
-
-		if contentType == "" {
-			contentType = ArtifactFallbackMimeType
+	// The holes in a sparse file are read, and uploaded, as zeros.
+	if fi, err := file.Stat(); err == nil {
+		if allocated, ok := allocatedSize(fi); ok && size-allocated >= sparseWarnSize && a.conf.Compression == "" {
+			a.logger.Warn("%s is a sparse file, and its %s of holes will be uploaded as zeros. Bundling it with --compress uploads them compressed, and they are recreated when the bundle is extracted",
+				path, humanize.IBytes(uint64(size-allocated)))
 		}
 	}
 
@@ -382,8 +723,48 @@ func (a *Uploader) build(path string, absolutePath string) (*api.Artifact, error
 		Sha1Sum:      sha1sum,
 		Sha256Sum:    sha256sum,
 		ContentType:  contentType,
+		Metadata:     a.conf.Metadata,
+	}
+
+	return artifact, nil
+}
+
+// bundle bundles the files of the artifacts into a single compressed archive,
+// which is encrypted if encryption is enabled, and returns an artifact for it.
+func (a *Uploader) bundle(ctx context.Context, artifacts []*api.Artifact) (*api.Artifact, error) {
+	files := make([]bundleFile, 0, len(artifacts))
+	paths := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		files = append(files, bundleFile{
+			path:         artifact.Path,
+			absolutePath: artifact.AbsolutePath,
+			symlink:      a.conf.PreserveSymlinks,
+		})
+		paths = append(paths, artifact.Path)
+	}
+
+	bundle, err := writeBundle(a.conf.Compression, a.tempDir, files)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.conf.Encryption.Enabled() {
+		encryptedPath, err := EncryptFile(ctx, a.conf.Encryption, bundle, a.tempDir)
+		if err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w", bundle, err)
+		}
+		bundle = encryptedPath
+	}
+
+	// A bundle is named with part of a UUID, so that it doesn't replace one
+	// uploaded earlier from the same directory.
+	artifact, err := a.build(ctx, bundlePath(a.conf.Compression, api.NewUUID()[:8], paths), bundle)
+	if err != nil {
+		return nil, err
 	}
+	artifact.ContentType = bundleContentTypes[a.conf.Compression]
 
+	a.logger.Info("Bundled %d files into %s (%s)", len(files), artifact.Path, humanize.IBytes(uint64(artifact.FileSize)))
 	return artifact, nil
 }
 
@@ -435,7 +816,7 @@ func (a *Uploader) createUploader(ctx context.Context) (_ workCreator, err error
 		})
 
 	default:
-		return nil, fmt.Errorf("invalid upload destination: '%v'. Only s3://*, gs://*, rt://*, or https://*.blob.core.windows.net destinations are allowed. Did you forget to surround your artifact upload pattern in double quotes?", a.conf.Destination)
+		return nil, fmt.Errorf("invalid upload destination: '%v'. Only s3://*, gs://*, rt://*, azblob://*, or https://*.blob.core.windows.net destinations are allowed. Did you forget to surround your artifact upload pattern in double quotes?", a.conf.Destination)
 	}
 }
 
@@ -462,9 +843,15 @@ type workUnit interface {
 	DoWork(context.Context) (*api.ArtifactPartETag, error)
 }
 
+// trackedWorkUnit is a work unit, and the tracker for its artifact.
+type trackedWorkUnit struct {
+	workUnit
+	tracker *artifactTracker
+}
+
 // workUnitResult is just a tuple (workUnit, partETag | error).
 type workUnitResult struct {
-	workUnit workUnit
+	trackedWorkUnit
 	partETag *api.ArtifactPartETag
 	err      error
 }
@@ -477,17 +864,16 @@ type artifactUploadWorker struct {
 	// Counts the worker goroutines.
 	wg sync.WaitGroup
 
-	// A tracker for every artifact.
-	// The map is written at the start of upload, and other goroutines only read
-	// afterwards.
-	trackers map[*api.Artifact]*artifactTracker
+	// The artifacts that have finished or errored, whose states haven't been
+	// sent to Buildkite yet. Only the state updater goroutine uses this.
+	completed []*artifactTracker
+
+	// How the upload is going.
+	progress *uploadProgress
 }
 
 // artifactTracker tracks the amount of work pending for an artifact.
 type artifactTracker struct {
-	// All the work for uploading this artifact.
-	work []workUnit
-
 	// Normally storing a context in a struct is a bad idea. It's explicitly
 	// called out in pkg.go.dev/context as a no-no (unless you are required to
 	// store a context for some reason like interface implementation or
@@ -511,9 +897,12 @@ type artifactTracker struct {
 	ctx    context.Context
 	cancel context.CancelCauseFunc
 
+	// The artifact being uploaded.
+	artifact *api.Artifact
+
 	// pendingWork is the number of incomplete units for this artifact.
-	// This is set once at the start of upload, and then decremented by the
-	// state updater goroutine as work units complete.
+	// This is set once when the artifact's work is created, and then
+	// decremented by the state updater goroutine as work units complete.
 	pendingWork int
 
 	// State that will be uploaded to BK when the artifact is finished or errored.
@@ -521,35 +910,16 @@ type artifactTracker struct {
 	api.ArtifactState
 }
 
-func (a *Uploader) upload(ctx context.Context, artifacts []*api.Artifact, uploader workCreator) error {
+// upload uploads the artifacts received from artifactsCh, which have already
+// been created on Buildkite, until it is closed.
+func (a *Uploader) upload(ctx context.Context, artifactsCh <-chan *api.Artifact, uploader workCreator, progress *uploadProgress) error {
 	worker := &artifactUploadWorker{
 		Uploader: a,
-		trackers: make(map[*api.Artifact]*artifactTracker),
-	}
-
-	// Create work and trackers for each artifact.
-	for _, artifact := range artifacts {
-		workUnits, err := uploader.CreateWork(artifact)
-		if err != nil {
-			a.logger.Error("Couldn't create upload workers for artifact %q: %v", artifact.Path, err)
-			return err
-		}
-
-		actx, acancel := context.WithCancelCause(ctx)
-		worker.trackers[artifact] = &artifactTracker{
-			ctx:         actx,
-			cancel:      acancel,
-			work:        workUnits,
-			pendingWork: len(workUnits),
-			ArtifactState: api.ArtifactState{
-				ID:        artifact.ID,
-				Multipart: len(workUnits) > 1,
-			},
-		}
+		progress: progress,
 	}
 
 	// unitsCh: work unit creation --(work unit to be run)--> multiple worker goroutines
-	unitsCh := make(chan workUnit)
+	unitsCh := make(chan trackedWorkUnit)
 	// resultsCh: multiple worker goroutines --(work unit result)--> state updater
 	resultsCh := make(chan workUnitResult)
 	// errCh: receives the final error from the status updater
@@ -560,22 +930,57 @@ func (a *Uploader) upload(ctx context.Context, artifacts []*api.Artifact, upload
 	go worker.stateUpdater(ctx, resultsCh, errCh)
 
 	// Worker goroutines that work on work units.
-	for range runtime.GOMAXPROCS(0) {
+	concurrency := a.conf.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	for range concurrency {
 		worker.wg.Add(1)
 		go worker.doWorkUnits(ctx, unitsCh, resultsCh)
 	}
 
-	// Send the work units for each artifact to the workers.
-	// This must happen after creating worker goroutines listening on workUnitsCh.
-	for _, tracker := range worker.trackers {
-		for _, workUnit := range tracker.work {
+	// Create work and a tracker for each artifact, and send the work units to
+	// the workers. This must happen after creating worker goroutines listening
+	// on unitsCh.
+	err := func() error {
+		for {
+			var artifact *api.Artifact
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case unitsCh <- workUnit:
+			case next, open := <-artifactsCh:
+				if !open {
+					return nil
+				}
+				artifact = next
+			}
+
+			workUnits, err := uploader.CreateWork(artifact)
+			if err != nil {
+				a.logger.Error("Couldn't create upload workers for artifact %q: %v", artifact.Path, err)
+				return err
+			}
+
+			actx, acancel := context.WithCancelCause(ctx)
+			tracker := &artifactTracker{
+				ctx:         actx,
+				cancel:      acancel,
+				artifact:    artifact,
+				pendingWork: len(workUnits),
+				ArtifactState: api.ArtifactState{
+					ID:        artifact.ID,
+					Multipart: len(workUnits) > 1,
+				},
+			}
+			for _, unit := range workUnits {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case unitsCh <- trackedWorkUnit{workUnit: unit, tracker: tracker}:
+				}
 			}
 		}
-	}
+	}()
 
 	// All work units have been sent to workers.
 	close(unitsCh)
@@ -592,7 +997,7 @@ func (a *Uploader) upload(ctx context.Context, artifacts []*api.Artifact, upload
 	a.logger.Debug("Uploads complete, waiting for upload status to be sent to Buildkite...")
 
 	// Wait for the statuses to finish uploading
-	if err := <-errCh; err != nil {
+	if err := errors.Join(err, <-errCh); err != nil {
 		return fmt.Errorf("errors uploading artifacts: %w", err)
 	}
 
@@ -601,7 +1006,7 @@ func (a *Uploader) upload(ctx context.Context, artifacts []*api.Artifact, upload
 	return nil
 }
 
-func (a *artifactUploadWorker) doWorkUnits(ctx context.Context, unitsCh <-chan workUnit, resultsCh chan<- workUnitResult) {
+func (a *artifactUploadWorker) doWorkUnits(ctx context.Context, unitsCh <-chan trackedWorkUnit, resultsCh chan<- workUnitResult) {
 	defer a.wg.Done()
 
 	for {
@@ -613,7 +1018,7 @@ func (a *artifactUploadWorker) doWorkUnits(ctx context.Context, unitsCh <-chan w
 			if !open {
 				return // Done
 			}
-			tracker := a.trackers[workUnit.Artifact()]
+			tracker := workUnit.tracker
 			// Show a nice message that we're starting to upload the file
 			a.logger.Info("Uploading %s", workUnit.Description())
 
@@ -644,7 +1049,7 @@ func (a *artifactUploadWorker) doWorkUnits(ctx context.Context, unitsCh <-chan w
 			case <-ctx.Done(): // Note: the main context, not the artifact tracker context
 				return
 
-			case resultsCh <- workUnitResult{workUnit: workUnit, partETag: partETag, err: err}:
+			case resultsCh <- workUnitResult{trackedWorkUnit: workUnit, partETag: partETag, err: err}:
 			}
 		}
 	}
@@ -661,6 +1066,7 @@ func (a *artifactUploadWorker) stateUpdater(ctx context.Context, resultsCh <-cha
 
 	// When this ticks, upload any pending artifact states as a batch.
 	updateTicker := time.NewTicker(1 * time.Second)
+	defer updateTicker.Stop()
 
 selectLoop:
 	for {
@@ -669,7 +1075,6 @@ selectLoop:
 			return
 
 		case <-updateTicker.C:
-			// Note: updateStates removes trackers for completed states.
 			if err := a.updateStates(ctx); err != nil {
 				errs = append(errs, err)
 			}
@@ -679,14 +1084,16 @@ selectLoop:
 				// No more input: we're done!
 				break selectLoop
 			}
-			artifact := result.workUnit.Artifact()
-			tracker := a.trackers[artifact]
+			tracker := result.tracker
 
 			if result.err != nil {
 				// The work unit failed, so the whole artifact upload has failed.
 				errs = append(errs, result.err)
-				tracker.State = "error"
-				a.logger.Debug("Artifact %s has entered state %s", tracker.ID, tracker.State)
+				if tracker.State == "" {
+					tracker.State = "error"
+					a.completed = append(a.completed, tracker)
+					a.logger.Debug("Artifact %s has entered state %s", tracker.ID, tracker.State)
+				}
 				continue
 			}
 
@@ -703,6 +1110,9 @@ selectLoop:
 			// No pending units remain, so the whole artifact is complete.
 			// Add it to the next batch of states to upload.
 			tracker.State = "finished"
+			tracker.cancel(nil)
+			a.completed = append(a.completed, tracker)
+			a.progress.upload(tracker.artifact)
 			a.logger.Debug("Artifact %s has entered state %s", tracker.ID, tracker.State)
 		}
 	}
@@ -714,33 +1124,21 @@ selectLoop:
 	return
 }
 
-// updateStates uploads terminal artifact states to Buildkite in a batch.
+// updateStates uploads the states of completed artifacts to Buildkite in a
+// batch.
 func (a *artifactUploadWorker) updateStates(ctx context.Context) error {
-	// Only upload states that are finished or error.
-	var statesToUpload []api.ArtifactState
-	var trackersToMarkSent []*artifactTracker
-	for _, tracker := range a.trackers {
-		switch tracker.State {
-		case "finished", "error":
-			// Only send these states.
-		default:
-			continue
-		}
-		// This artifact is complete, move it from a.trackers to statesToUpload.
-		statesToUpload = append(statesToUpload, tracker.ArtifactState)
-		trackersToMarkSent = append(trackersToMarkSent, tracker)
-	}
-
-	if len(statesToUpload) == 0 { // no news from the frontier
+	if len(a.completed) == 0 { // no news from the frontier
 		return nil
 	}
 
-	for _, state := range statesToUpload {
+	statesToUpload := make([]api.ArtifactState, 0, len(a.completed))
+	for _, tracker := range a.completed {
 		// Ensure ETags are in ascending order by part number.
 		// This is required by S3.
-		slices.SortFunc(state.MultipartETags, func(a, b api.ArtifactPartETag) int {
+		slices.SortFunc(tracker.MultipartETags, func(a, b api.ArtifactPartETag) int {
 			return cmp.Compare(a.PartNumber, b.PartNumber)
 		})
+		statesToUpload = append(statesToUpload, tracker.ArtifactState)
 	}
 
 	// Post the update
@@ -779,10 +1177,8 @@ func (a *artifactUploadWorker) updateStates(ctx context.Context) error {
 		return err
 	}
 
-	for _, tracker := range trackersToMarkSent {
-		// Don't send this state again.
-		tracker.State = "sent"
-	}
+	// Don't send these states again.
+	a.completed = nil
 	a.logger.Debug("Updated %d artifact states", len(statesToUpload))
 	return nil
 }
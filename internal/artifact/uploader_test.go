@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/api"
 	"github.com/buildkite/agent/v3/internal/experiments"
@@ -173,6 +177,31 @@ func TestCollectThatDoesntMatchAnyFiles(t *testing.T) {
 	assert.Equal(t, len(artifacts), 0)
 }
 
+func TestCollectLiteralPaths(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// Neither the glob characters nor the delimiter are special.
+	path := filepath.Join(t.TempDir(), "report[1];final.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+
+	uploader := NewUploader(logger.Discard, nil, UploaderConfig{
+		LiteralPaths: []string{path},
+	})
+
+	artifacts, err := uploader.collect(ctx)
+	if err != nil {
+		t.Fatalf("uploader.collect() error = %v", err)
+	}
+
+	if assert.Len(t, artifacts, 1) {
+		assert.Equal(t, path, artifacts[0].AbsolutePath)
+		assert.Equal(t, int64(5), artifacts[0].FileSize)
+	}
+}
+
 func TestCollectWithSomeGlobsThatDontMatchAnything(t *testing.T) {
 	t.Parallel()
 	ctx := context.Background()
@@ -607,3 +636,201 @@ func TestCollectMatchesUploadSymlinks_WithZZGlob(t *testing.T) {
 		paths,
 	)
 }
+
+// fakeAPIClient creates artifacts with sequential IDs, and records the states
+// they are updated to.
+type fakeAPIClient struct {
+	mu      sync.Mutex
+	batches []int
+	states  map[string]string
+}
+
+func (c *fakeAPIClient) CreateArtifacts(_ context.Context, _ string, batch *api.ArtifactBatch) (*api.ArtifactBatchCreateResponse, *api.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := &api.ArtifactBatchCreateResponse{}
+	for range batch.Artifacts {
+		resp.ArtifactIDs = append(resp.ArtifactIDs, fmt.Sprintf("artifact-%d", len(c.states)))
+		c.states[resp.ArtifactIDs[len(resp.ArtifactIDs)-1]] = "new"
+	}
+	c.batches = append(c.batches, len(batch.Artifacts))
+	return resp, nil, nil
+}
+
+func (c *fakeAPIClient) SearchArtifacts(context.Context, string, *api.ArtifactSearchOptions) ([]*api.Artifact, *api.Response, error) {
+	return nil, nil, nil
+}
+
+func (c *fakeAPIClient) UpdateArtifacts(_ context.Context, _ string, states []api.ArtifactState) (*api.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, state := range states {
+		c.states[state.ID] = state.State
+	}
+	return nil, nil
+}
+
+// fakeWorkCreator uploads each artifact in a single unit of work that does
+// nothing.
+type fakeWorkCreator struct{}
+
+func (fakeWorkCreator) URL(artifact *api.Artifact) string { return "fake://" + artifact.Path }
+
+func (fakeWorkCreator) CreateWork(artifact *api.Artifact) ([]workUnit, error) {
+	return []workUnit{fakeWorkUnit{artifact}}, nil
+}
+
+type fakeWorkUnit struct{ artifact *api.Artifact }
+
+func (u fakeWorkUnit) Artifact() *api.Artifact { return u.artifact }
+func (u fakeWorkUnit) Description() string     { return singleUnitDescription(u.artifact) }
+
+func (u fakeWorkUnit) DoWork(context.Context) (*api.ArtifactPartETag, error) { return nil, nil }
+
+func TestUploadPipeline(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := &fakeAPIClient{states: make(map[string]string)}
+	uploader := NewUploader(logger.Discard, client, UploaderConfig{JobID: "job", Concurrency: 4})
+	progress := newUploadProgress(time.Now())
+
+	const count = 2*artifactBatchSize + 5
+	foundCh := make(chan *api.Artifact)
+	createdCh := make(chan *api.Artifact)
+	go func() {
+		defer close(foundCh)
+		for i := range count {
+			foundCh <- &api.Artifact{Path: fmt.Sprintf("file-%d", i), FileSize: 10}
+		}
+	}()
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(createdCh)
+		errCh <- uploader.createBatches(ctx, foundCh, createdCh, fakeWorkCreator{}, progress)
+	}()
+
+	if err := uploader.upload(ctx, createdCh, fakeWorkCreator{}, progress); err != nil {
+		t.Fatalf("uploader.upload(...) error = %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("uploader.createBatches(...) error = %v", err)
+	}
+
+	if got, want := client.batches, []int{artifactBatchSize, artifactBatchSize, 5}; !slices.Equal(got, want) {
+		t.Errorf("created batches of %v artifacts, want %v", got, want)
+	}
+	if got := len(client.states); got != count {
+		t.Errorf("created %d artifacts, want %d", got, count)
+	}
+	for id, state := range client.states {
+		if state != "finished" {
+			t.Errorf("artifact %s state = %q, want finished", id, state)
+		}
+	}
+	if got, want := progress.uploaded.Load(), int64(count); got != want {
+		t.Errorf("progress.uploaded = %d, want %d", got, want)
+	}
+}
+
+func TestUploadProgressReport(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := newUploadProgress(start)
+	for range 4 {
+		p.add(&api.Artifact{FileSize: 1 << 20})
+	}
+	p.upload(&api.Artifact{FileSize: 1 << 20})
+
+	now := start.Add(10 * time.Second)
+	if got, want := p.report(now), "Uploaded 1 of 4 files found so far (1.0 MiB of 4.0 MiB, 102 KiB/s)"; got != want {
+		t.Errorf("p.report(now) = %q, want %q", got, want)
+	}
+
+	p.finishFinding()
+	if got, want := p.report(now), "Uploaded 1 of 4 files (1.0 MiB of 4.0 MiB, 102 KiB/s, about 30s left)"; got != want {
+		t.Errorf("p.report(now) = %q, want %q", got, want)
+	}
+}
+
+func TestUploadThatDoesntMatchAnyFiles(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeAPIClient{states: make(map[string]string)}
+	uploader := NewUploader(logger.Discard, client, UploaderConfig{
+		JobID: "job",
+		Paths: filepath.Join(t.TempDir(), "*.log"),
+	})
+	if err := uploader.Upload(context.Background()); err != nil {
+		t.Errorf("uploader.Upload() error = %v", err)
+	}
+	if len(client.batches) != 0 {
+		t.Errorf("created batches of %v artifacts, want none", client.batches)
+	}
+}
+
+func TestFindSymlinkCycle(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(a/b) error = %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "a", "b", "up")); err != nil {
+		t.Fatalf("os.Symlink(.., a/b/up) error = %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a", "b"), filepath.Join(dir, "across")); err != nil {
+		t.Fatalf("os.Symlink(a/b, across) error = %v", err)
+	}
+
+	link, _, err := findSymlinkCycle(filepath.Join(dir, "a", "b", "up", "b", "x.log"))
+	if err != nil {
+		t.Fatalf("findSymlinkCycle(a/b/up/b/x.log) error = %v", err)
+	}
+	if want := filepath.Join(dir, "a", "b", "up"); link != want {
+		t.Errorf("findSymlinkCycle(a/b/up/b/x.log) link = %q, want %q", link, want)
+	}
+
+	link, _, err = findSymlinkCycle(filepath.Join(dir, "across", "x.log"))
+	if err != nil || link != "" {
+		t.Errorf("findSymlinkCycle(across/x.log) = (%q, %v), want no cycle", link, err)
+	}
+}
+
+func TestUploadPreserveSymlinksRequiresCompression(t *testing.T) {
+	t.Parallel()
+
+	uploader := NewUploader(logger.Discard, &fakeAPIClient{}, UploaderConfig{
+		Paths:            "*.log",
+		PreserveSymlinks: true,
+	})
+	if err := uploader.Upload(context.Background()); err == nil {
+		t.Errorf("uploader.Upload() error = nil, want an error")
+	}
+}
+
+func TestCollectWithBracesExclusionsAndDirectories(t *testing.T) {
+	t.Parallel()
+
+	uploader := NewUploader(logger.Discard, nil, UploaderConfig{
+		Paths: "fixtures/{folder,gifs}/*.{jpg,gif};fixtures/this is a folder with a space;!(fixtures/gifs/*)",
+	})
+	artifacts, err := uploader.collect(context.Background())
+	if err != nil {
+		t.Fatalf("uploader.collect() error = %v", err)
+	}
+
+	var got []string
+	for _, a := range artifacts {
+		got = append(got, filepath.Base(a.Path))
+	}
+	slices.Sort(got)
+	if want := []string{"Commando.jpg", "The Terminator.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("collected %q, want %q", got, want)
+	}
+}
@@ -4,10 +4,469 @@ package bkgql
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 
 	"github.com/Khan/genqlient/graphql"
 )
 
+// GetBuildJobsBuild includes the requested fields of the GraphQL type Build.
+// The GraphQL type's documentation follows.
+//
+// A build from a pipeline
+type GetBuildJobsBuild struct {
+	Jobs GetBuildJobsBuildJobsJobConnection `json:"jobs"`
+}
+
+// GetJobs returns GetBuildJobsBuild.Jobs, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuild) GetJobs() GetBuildJobsBuildJobsJobConnection { return v.Jobs }
+
+// GetBuildJobsBuildJobsJobConnection includes the requested fields of the GraphQL type JobConnection.
+type GetBuildJobsBuildJobsJobConnection struct {
+	PageInfo GetBuildJobsBuildJobsJobConnectionPageInfo       `json:"pageInfo"`
+	Edges    []GetBuildJobsBuildJobsJobConnectionEdgesJobEdge `json:"edges"`
+}
+
+// GetPageInfo returns GetBuildJobsBuildJobsJobConnection.PageInfo, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnection) GetPageInfo() GetBuildJobsBuildJobsJobConnectionPageInfo {
+	return v.PageInfo
+}
+
+// GetEdges returns GetBuildJobsBuildJobsJobConnection.Edges, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnection) GetEdges() []GetBuildJobsBuildJobsJobConnectionEdgesJobEdge {
+	return v.Edges
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdge includes the requested fields of the GraphQL type JobEdge.
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdge struct {
+	Node GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob `json:"-"`
+}
+
+// GetNode returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdge.Node, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdge) GetNode() GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob {
+	return v.Node
+}
+
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdge) UnmarshalJSON(b []byte) error {
+
+	if string(b) == "null" {
+		return nil
+	}
+
+	var firstPass struct {
+		*GetBuildJobsBuildJobsJobConnectionEdgesJobEdge
+		Node json.RawMessage `json:"node"`
+		graphql.NoUnmarshalJSON
+	}
+	firstPass.GetBuildJobsBuildJobsJobConnectionEdgesJobEdge = v
+
+	err := json.Unmarshal(b, &firstPass)
+	if err != nil {
+		return err
+	}
+
+	{
+		dst := &v.Node
+		src := firstPass.Node
+		if len(src) != 0 && string(src) != "null" {
+			err = __unmarshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob(
+				src, dst)
+			if err != nil {
+				return fmt.Errorf(
+					"unable to unmarshal GetBuildJobsBuildJobsJobConnectionEdgesJobEdge.Node: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+type __premarshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdge struct {
+	Node json.RawMessage `json:"node"`
+}
+
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdge) MarshalJSON() ([]byte, error) {
+	premarshaled, err := v.__premarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(premarshaled)
+}
+
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdge) __premarshalJSON() (*__premarshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdge, error) {
+	var retval __premarshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdge
+
+	{
+
+		dst := &retval.Node
+		src := v.Node
+		var err error
+		*dst, err = __marshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob(
+			&src)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to marshal GetBuildJobsBuildJobsJobConnectionEdgesJobEdge.Node: %w", err)
+		}
+	}
+	return &retval, nil
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob includes the requested fields of the GraphQL interface Job.
+//
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob is implemented by the following types:
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait
+// The GraphQL type's documentation follows.
+//
+// Kinds of jobs that can exist on a build
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob interface {
+	implementsGraphQLInterfaceGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob()
+	// GetTypename returns the receiver's concrete GraphQL type-name (see interface doc for possible values).
+	GetTypename() string
+}
+
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock) implementsGraphQLInterfaceGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob() {
+}
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) implementsGraphQLInterfaceGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob() {
+}
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger) implementsGraphQLInterfaceGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob() {
+}
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait) implementsGraphQLInterfaceGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob() {
+}
+
+func __unmarshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob(b []byte, v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var tn struct {
+		TypeName string `json:"__typename"`
+	}
+	err := json.Unmarshal(b, &tn)
+	if err != nil {
+		return err
+	}
+
+	switch tn.TypeName {
+	case "JobTypeBlock":
+		*v = new(GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock)
+		return json.Unmarshal(b, *v)
+	case "JobTypeCommand":
+		*v = new(GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand)
+		return json.Unmarshal(b, *v)
+	case "JobTypeTrigger":
+		*v = new(GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger)
+		return json.Unmarshal(b, *v)
+	case "JobTypeWait":
+		*v = new(GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait)
+		return json.Unmarshal(b, *v)
+	case "":
+		return fmt.Errorf(
+			"response was missing Job.__typename")
+	default:
+		return fmt.Errorf(
+			`unexpected concrete type for GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob: "%v"`, tn.TypeName)
+	}
+}
+
+func __marshalGetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob(v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob) ([]byte, error) {
+
+	var typename string
+	switch v := (*v).(type) {
+	case *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock:
+		typename = "JobTypeBlock"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand:
+		typename = "JobTypeCommand"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger:
+		typename = "JobTypeTrigger"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait:
+		typename = "JobTypeWait"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait
+		}{typename, v}
+		return json.Marshal(result)
+	case nil:
+		return []byte("null"), nil
+	default:
+		return nil, fmt.Errorf(
+			`unexpected concrete type for GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJob: "%T"`, v)
+	}
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock includes the requested fields of the GraphQL type JobTypeBlock.
+// The GraphQL type's documentation follows.
+//
+// A type of job that requires a user to unblock it before proceeding in a build pipeline
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock struct {
+	Typename string `json:"__typename"`
+	// The label of this block step
+	Label string `json:"label"`
+	// If this job has been retried
+	Retried bool `json:"retried"`
+	// The step that defined this job. Some older jobs in the system may not have an associated step
+	Step GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput `json:"step"`
+}
+
+// GetTypename returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock.Typename, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock) GetTypename() string {
+	return v.Typename
+}
+
+// GetLabel returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock.Label, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock) GetLabel() string {
+	return v.Label
+}
+
+// GetRetried returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock.Retried, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock) GetRetried() bool {
+	return v.Retried
+}
+
+// GetStep returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock.Step, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlock) GetStep() GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput {
+	return v.Step
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput includes the requested fields of the GraphQL type StepInput.
+// The GraphQL type's documentation follows.
+//
+// An input step collects information from a user
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput struct {
+	// The UUID for this step
+	Uuid string `json:"uuid"`
+	// The user-defined key for this step
+	Key string `json:"key"`
+}
+
+// GetUuid returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput.Uuid, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput) GetUuid() string {
+	return v.Uuid
+}
+
+// GetKey returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput.Key, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeBlockStepStepInput) GetKey() string {
+	return v.Key
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand includes the requested fields of the GraphQL type JobTypeCommand.
+// The GraphQL type's documentation follows.
+//
+// A type of job that runs a command on an agent
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand struct {
+	Typename string `json:"__typename"`
+	// The label of the job
+	Label string `json:"label"`
+	// The command the job will run
+	Command string `json:"command"`
+	// If this job has been retried
+	Retried bool `json:"retried"`
+	// The step that defined this job. Some older jobs in the system may not have an associated step
+	Step GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand `json:"step"`
+}
+
+// GetTypename returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand.Typename, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) GetTypename() string {
+	return v.Typename
+}
+
+// GetLabel returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand.Label, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) GetLabel() string {
+	return v.Label
+}
+
+// GetCommand returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand.Command, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) GetCommand() string {
+	return v.Command
+}
+
+// GetRetried returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand.Retried, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) GetRetried() bool {
+	return v.Retried
+}
+
+// GetStep returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand.Step, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommand) GetStep() GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand {
+	return v.Step
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand includes the requested fields of the GraphQL type StepCommand.
+// The GraphQL type's documentation follows.
+//
+// A step in a build that runs a command on an agent
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand struct {
+	// The UUID for this step
+	Uuid string `json:"uuid"`
+	// The user-defined key for this step
+	Key string `json:"key"`
+}
+
+// GetUuid returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand.Uuid, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand) GetUuid() string {
+	return v.Uuid
+}
+
+// GetKey returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand.Key, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeCommandStepStepCommand) GetKey() string {
+	return v.Key
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger includes the requested fields of the GraphQL type JobTypeTrigger.
+// The GraphQL type's documentation follows.
+//
+// A type of job that triggers another build on a pipeline
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger struct {
+	Typename string `json:"__typename"`
+	// The label of this trigger step
+	Label string `json:"label"`
+	// If this job has been retried
+	Retried bool `json:"retried"`
+	// The step that defined this job. Some older jobs in the system may not have an associated step
+	Step GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger `json:"step"`
+}
+
+// GetTypename returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger.Typename, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger) GetTypename() string {
+	return v.Typename
+}
+
+// GetLabel returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger.Label, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger) GetLabel() string {
+	return v.Label
+}
+
+// GetRetried returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger.Retried, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger) GetRetried() bool {
+	return v.Retried
+}
+
+// GetStep returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger.Step, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTrigger) GetStep() GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger {
+	return v.Step
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger includes the requested fields of the GraphQL type StepTrigger.
+// The GraphQL type's documentation follows.
+//
+// A trigger step creates a build on another pipeline
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger struct {
+	// The UUID for this step
+	Uuid string `json:"uuid"`
+	// The user-defined key for this step
+	Key string `json:"key"`
+}
+
+// GetUuid returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger.Uuid, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger) GetUuid() string {
+	return v.Uuid
+}
+
+// GetKey returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger.Key, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeTriggerStepStepTrigger) GetKey() string {
+	return v.Key
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait includes the requested fields of the GraphQL type JobTypeWait.
+// The GraphQL type's documentation follows.
+//
+// A type of job that waits for all previous jobs to pass before proceeding the build pipeline
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait struct {
+	Typename string `json:"__typename"`
+	// The label of this wait step
+	Label string `json:"label"`
+	// If this job has been retried
+	Retried bool `json:"retried"`
+	// The step that defined this job. Some older jobs in the system may not have an associated step
+	Step GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait `json:"step"`
+}
+
+// GetTypename returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait.Typename, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait) GetTypename() string {
+	return v.Typename
+}
+
+// GetLabel returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait.Label, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait) GetLabel() string {
+	return v.Label
+}
+
+// GetRetried returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait.Retried, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait) GetRetried() bool {
+	return v.Retried
+}
+
+// GetStep returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait.Step, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWait) GetStep() GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait {
+	return v.Step
+}
+
+// GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait includes the requested fields of the GraphQL type StepWait.
+// The GraphQL type's documentation follows.
+//
+// A wait step waits for all previous steps to have successfully completed before allowing following jobs to continue
+type GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait struct {
+	// The UUID for this step
+	Uuid string `json:"uuid"`
+	// The user-defined key for this step
+	Key string `json:"key"`
+}
+
+// GetUuid returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait.Uuid, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait) GetUuid() string {
+	return v.Uuid
+}
+
+// GetKey returns GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait.Key, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionEdgesJobEdgeNodeJobTypeWaitStepStepWait) GetKey() string {
+	return v.Key
+}
+
+// GetBuildJobsBuildJobsJobConnectionPageInfo includes the requested fields of the GraphQL type PageInfo.
+// The GraphQL type's documentation follows.
+//
+// Information about pagination in a connection.
+type GetBuildJobsBuildJobsJobConnectionPageInfo struct {
+	// When paginating forwards, are there more items?
+	HasNextPage bool `json:"hasNextPage"`
+	// When paginating forwards, the cursor to continue.
+	EndCursor string `json:"endCursor"`
+}
+
+// GetHasNextPage returns GetBuildJobsBuildJobsJobConnectionPageInfo.HasNextPage, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionPageInfo) GetHasNextPage() bool { return v.HasNextPage }
+
+// GetEndCursor returns GetBuildJobsBuildJobsJobConnectionPageInfo.EndCursor, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsBuildJobsJobConnectionPageInfo) GetEndCursor() string { return v.EndCursor }
+
+// GetBuildJobsResponse is returned by GetBuildJobs on success.
+type GetBuildJobsResponse struct {
+	// Find a build
+	Build GetBuildJobsBuild `json:"build"`
+}
+
+// GetBuild returns GetBuildJobsResponse.Build, and is useful for accessing the field via an interface.
+func (v *GetBuildJobsResponse) GetBuild() GetBuildJobsBuild { return v.Build }
+
 // GetPipelinePipeline includes the requested fields of the GraphQL type Pipeline.
 // The GraphQL type's documentation follows.
 //
@@ -119,6 +578,18 @@ func (v *UpdatePipelineResponse) GetPipelineUpdate() UpdatePipelinePipelineUpdat
 	return v.PipelineUpdate
 }
 
+// __GetBuildJobsInput is used internally by genqlient
+type __GetBuildJobsInput struct {
+	Uuid  string `json:"uuid"`
+	After string `json:"after,omitempty"`
+}
+
+// GetUuid returns __GetBuildJobsInput.Uuid, and is useful for accessing the field via an interface.
+func (v *__GetBuildJobsInput) GetUuid() string { return v.Uuid }
+
+// GetAfter returns __GetBuildJobsInput.After, and is useful for accessing the field via an interface.
+func (v *__GetBuildJobsInput) GetAfter() string { return v.After }
+
 // __GetPipelineInput is used internally by genqlient
 type __GetPipelineInput struct {
 	OrgPipelineSlug string `json:"orgPipelineSlug"`
@@ -139,6 +610,86 @@ func (v *__UpdatePipelineInput) GetId() string { return v.Id }
 // GetYaml returns __UpdatePipelineInput.Yaml, and is useful for accessing the field via an interface.
 func (v *__UpdatePipelineInput) GetYaml() string { return v.Yaml }
 
+// The query or mutation executed by GetBuildJobs.
+const GetBuildJobs_Operation = `
+query GetBuildJobs ($uuid: ID!, $after: String) {
+	build(uuid: $uuid) {
+		jobs(first: 100, after: $after) {
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+			edges {
+				node {
+					__typename
+					... on JobTypeCommand {
+						label
+						command
+						retried
+						step {
+							uuid
+							key
+						}
+					}
+					... on JobTypeWait {
+						label
+						retried
+						step {
+							uuid
+							key
+						}
+					}
+					... on JobTypeBlock {
+						label
+						retried
+						step {
+							uuid
+							key
+						}
+					}
+					... on JobTypeTrigger {
+						label
+						retried
+						step {
+							uuid
+							key
+						}
+					}
+				}
+			}
+		}
+	}
+}
+`
+
+func GetBuildJobs(
+	ctx_ context.Context,
+	client_ graphql.Client,
+	uuid string,
+	after string,
+) (*GetBuildJobsResponse, error) {
+	req_ := &graphql.Request{
+		OpName: "GetBuildJobs",
+		Query:  GetBuildJobs_Operation,
+		Variables: &__GetBuildJobsInput{
+			Uuid:  uuid,
+			After: after,
+		},
+	}
+	var err_ error
+
+	var data_ GetBuildJobsResponse
+	resp_ := &graphql.Response{Data: &data_}
+
+	err_ = client_.MakeRequest(
+		ctx_,
+		req_,
+		resp_,
+	)
+
+	return &data_, err_
+}
+
 // The query or mutation executed by GetPipeline.
 const GetPipeline_Operation = `
 query GetPipeline ($orgPipelineSlug: ID!) {
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Cached paths are archived as a zstd-compressed tar file. The entries for
+// paths[i] are named "i/<base name of paths[i]>/...", so that each path can be
+// extracted next to where it will be restored, then moved into place.
+
+// writeArchive writes an archive of paths to w. Paths that don't exist are
+// skipped, and returned.
+func writeArchive(w io.Writer, paths []string) (missing []string, err error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd writer: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	for i, root := range paths {
+		if _, err := os.Lstat(root); errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, root)
+			continue
+		}
+
+		prefix := path.Join(strconv.Itoa(i), filepath.Base(root))
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			return addToArchive(tw, p, path.Join(prefix, filepath.ToSlash(rel)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("archiving %s: %w", root, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("closing tar writer: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("closing zstd writer: %w", err)
+	}
+	return missing, nil
+}
+
+func addToArchive(tw *tar.Writer, p, name string) error {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return err
+	}
+
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractArchive restores paths from the archive in r. dests maps the index of
+// each path in the archive to restore to where it's restored; other paths in
+// the archive are skipped. Each path is extracted into a temporary directory
+// beside its destination, and only replaces what's there once the whole
+// archive has been extracted, so a failed restore doesn't leave partially
+// restored files behind.
+func extractArchive(r io.Reader, dests map[int]string) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("creating zstd reader: %w", err)
+	}
+	defer zr.Close()
+
+	// Temporary directories to extract each path into, created as needed.
+	tempDirs := make(map[int]string)
+	defer func() {
+		for _, dir := range tempDirs {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+
+		index, name, ok := strings.Cut(strings.TrimSuffix(hdr.Name, "/"), "/")
+		i, err := strconv.Atoi(index)
+		if !ok || err != nil || i < 0 || !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("archive contains invalid path %q", hdr.Name)
+		}
+		dest, ok := dests[i]
+		if !ok {
+			continue
+		}
+
+		// Everything for a path is under its base name, and must stay there.
+		base, rel, _ := strings.Cut(name, "/")
+		if base != filepath.Base(dest) {
+			return fmt.Errorf("archive contains invalid path %q", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeSymlink && !linkStaysInside(rel, hdr.Linkname) {
+			return fmt.Errorf("archive contains symlink %q to %q, which is outside the cached path", hdr.Name, hdr.Linkname)
+		}
+
+		dir, ok := tempDirs[i]
+		if !ok {
+			parent := filepath.Dir(dest)
+			if err := os.MkdirAll(parent, 0o777); err != nil {
+				return fmt.Errorf("creating %s: %w", parent, err)
+			}
+			dir, err = os.MkdirTemp(parent, ".buildkite-cache-*")
+			if err != nil {
+				return fmt.Errorf("creating temporary directory: %w", err)
+			}
+			tempDirs[i] = dir
+		}
+
+		if err := extractEntry(tr, hdr, dir, filepath.FromSlash(name)); err != nil {
+			return err
+		}
+	}
+
+	// Everything was extracted, so move the paths into place.
+	for i, dir := range tempDirs {
+		dest := dests[i]
+		extracted := filepath.Join(dir, filepath.Base(dest))
+		if err := os.RemoveAll(dest); err != nil {
+			return fmt.Errorf("removing %s: %w", dest, err)
+		}
+		if err := os.Rename(extracted, dest); err != nil {
+			return fmt.Errorf("moving restored %s into place: %w", dest, err)
+		}
+	}
+	return nil
+}
+
+// linkStaysInside reports whether a symlink at rel, a slash-separated path
+// within a cached path ("" for the cached path itself), to target resolves to
+// somewhere within the cached path.
+func linkStaysInside(rel, target string) bool {
+	if rel == "" || path.IsAbs(target) || filepath.IsAbs(target) {
+		return false
+	}
+	resolved := path.Join(path.Dir(rel), filepath.ToSlash(target))
+	return resolved == "." || filepath.IsLocal(filepath.FromSlash(resolved))
+}
+
+// extractEntry extracts an entry to name within dir. Extracted symlinks could
+// otherwise redirect later entries outside dir, so nothing is written through
+// a symlink.
+func extractEntry(r io.Reader, hdr *tar.Header, dir, name string) error {
+	target := filepath.Join(dir, name)
+	if err := checkNoSymlinks(dir, name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", target, err)
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0o777); err != nil {
+			return fmt.Errorf("creating %s: %w", target, err)
+		}
+		return os.Chmod(target, mode)
+
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, target)
+
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", target, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, r); err != nil {
+			return fmt.Errorf("extracting %s: %w", target, err)
+		}
+		return f.Close()
+
+	default:
+		// Devices, FIFOs and so on aren't worth caching.
+		return nil
+	}
+}
+
+// checkNoSymlinks returns an error if name, or any directory on the way to it
+// from dir, is a symlink.
+func checkNoSymlinks(dir, name string) error {
+	p := dir
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("not extracting %s, as %s is a symlink", filepath.Join(dir, name), p)
+		}
+	}
+	return nil
+}
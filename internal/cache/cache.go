@@ -0,0 +1,215 @@
+// Package cache implements a content-addressable cache of files and
+// directories, such as dependencies, that can be saved at the end of one job
+// and restored at the start of another.
+//
+// Cached paths are archived together into a blob named after the checksum of
+// its contents, so identical caches are only stored once. Cache keys refer to
+// blobs through small manifests, which also record when the entry expires.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+)
+
+// manifest describes a cache entry.
+type manifest struct {
+	// The key of the blob holding the archived paths
+	Blob string `json:"blob"`
+
+	// The size of the blob in bytes
+	Size int64 `json:"size"`
+
+	// The paths that were saved, as they were given
+	Paths []string `json:"paths"`
+
+	// When the entry was saved
+	CreatedAt time.Time `json:"created_at"`
+
+	// When the entry expires, if it does
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Cache struct {
+	// The logger instance to use
+	logger logger.Logger
+
+	// Where cache entries are stored
+	store Store
+
+	// Returns the current time, for testing expiry
+	now func() time.Time
+}
+
+func New(l logger.Logger, store Store) *Cache {
+	return &Cache{
+		logger: l,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// Save archives paths and saves them under each of keys. The first key is the
+// primary key: if there is already an unexpired entry for it, nothing is
+// saved. The others are fallback keys, which are updated to refer to the new
+// entry. If ttl is non-zero, the entry expires after that long.
+func (c *Cache) Save(ctx context.Context, keys, paths []string, ttl time.Duration) error {
+	if len(keys) == 0 {
+		return errors.New("no cache keys were given")
+	}
+
+	if m, err := c.manifest(ctx, keys[0]); err == nil && !c.expired(m) {
+		c.logger.Info("Cache entry for %q already exists, not saving", keys[0])
+		return nil
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "buildkite-cache-*.tar.zst")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	missing, err := writeArchive(io.MultiWriter(f, h), paths)
+	if err != nil {
+		return err
+	}
+	for _, path := range missing {
+		c.logger.Warn("Not caching %s, as it doesn't exist", path)
+	}
+	if len(missing) == len(paths) {
+		return errors.New("none of the paths to cache exist")
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("finding archive size: %w", err)
+	}
+
+	m := manifest{
+		Blob:      fmt.Sprintf("blobs/%x.tar.zst", h.Sum(nil)),
+		Size:      size,
+		Paths:     paths,
+		CreatedAt: c.now().UTC(),
+	}
+	if ttl > 0 {
+		expiresAt := m.CreatedAt.Add(ttl)
+		m.ExpiresAt = &expiresAt
+	}
+
+	exists, err := c.store.Exists(ctx, m.Blob)
+	if err != nil {
+		return fmt.Errorf("checking for %s: %w", m.Blob, err)
+	}
+	if exists {
+		c.logger.Info("Identical cache contents are already saved")
+	} else {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewinding archive: %w", err)
+		}
+		c.logger.Info("Saving cache (%s)...", humanize.IBytes(uint64(size)))
+		if err := c.store.Put(ctx, m.Blob, f); err != nil {
+			return fmt.Errorf("saving %s: %w", m.Blob, err)
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshalling cache manifest: %w", err)
+	}
+	for _, key := range keys {
+		if err := c.store.Put(ctx, manifestKey(key), bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("saving cache key %q: %w", key, err)
+		}
+		c.logger.Info("Saved cache key %q", key)
+	}
+	return nil
+}
+
+// Restore restores paths from the first of keys that has an unexpired entry,
+// and returns that key. If none of them do, it returns an empty key: a cache
+// miss isn't an error. Only paths that were saved in the entry, as they were
+// given to Save, are restored. What the entry says was saved is never used as
+// where to restore to, as entries may be written by anyone with access to the
+// store.
+func (c *Cache) Restore(ctx context.Context, keys, paths []string) (string, error) {
+	for _, key := range keys {
+		m, err := c.manifest(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			c.logger.Debug("No cache entry for %q", key)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if c.expired(m) {
+			c.logger.Info("Cache entry for %q expired at %s", key, m.ExpiresAt.Format(time.RFC3339))
+			continue
+		}
+
+		dests := make(map[int]string)
+		for _, p := range paths {
+			i := slices.IndexFunc(m.Paths, func(saved string) bool {
+				return filepath.Clean(saved) == filepath.Clean(p)
+			})
+			if i < 0 {
+				c.logger.Warn("Not restoring %s, as the cache for %q doesn't have it", p, key)
+				continue
+			}
+			dests[i] = p
+		}
+
+		c.logger.Info("Restoring cache for %q (%s)...", key, humanize.IBytes(uint64(m.Size)))
+		blob, err := c.store.Open(ctx, m.Blob)
+		if err != nil {
+			return "", fmt.Errorf("opening %s: %w", m.Blob, err)
+		}
+		defer blob.Close()
+
+		if err := extractArchive(blob, dests); err != nil {
+			return "", fmt.Errorf("restoring cache for %q: %w", key, err)
+		}
+		return key, nil
+	}
+	return "", nil
+}
+
+func (c *Cache) manifest(ctx context.Context, key string) (*manifest, error) {
+	r, err := c.store.Open(ctx, manifestKey(key))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var m manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("reading cache manifest for %q: %w", key, err)
+	}
+	return &m, nil
+}
+
+func (c *Cache) expired(m *manifest) bool {
+	return m.ExpiresAt != nil && c.now().After(*m.ExpiresAt)
+}
+
+// manifestKey returns the store key for the manifest of a cache key. Cache
+// keys often contain branch names, which may contain slashes.
+func manifestKey(key string) string {
+	return "keys/" + url.PathEscape(key) + ".json"
+}
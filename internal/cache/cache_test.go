@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+// writeFiles creates files under dir, keyed by slash-separated path.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+			t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+		}
+	}
+}
+
+// readFiles returns the contents of the files under dir, keyed by
+// slash-separated path.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("filepath.WalkDir(%q) error = %v", dir, err)
+	}
+	return files
+}
+
+func newTestCache(t *testing.T) *Cache {
+	t.Helper()
+	store, err := newDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("newDirStore() error = %v", err)
+	}
+	return New(logger.Discard, store)
+}
+
+func TestSaveRestore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	src := t.TempDir()
+	want := map[string]string{
+		"node_modules/left-pad/index.js":     "module.exports = leftPad",
+		"node_modules/left-pad/package.json": "{}",
+		"vendor.txt":                         "vendored",
+	}
+	writeFiles(t, src, want)
+
+	paths := []string{filepath.Join(src, "node_modules"), filepath.Join(src, "vendor.txt")}
+	if err := c.Save(ctx, []string{"deps-abc", "deps-main"}, paths, 0); err != nil {
+		t.Fatalf("c.Save() error = %v", err)
+	}
+
+	// Make the restore replace what's there, rather than merging with it.
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatalf("os.RemoveAll(%q) error = %v", src, err)
+	}
+	writeFiles(t, src, map[string]string{"node_modules/stale.js": "stale"})
+
+	key, err := c.Restore(ctx, []string{"deps-def", "deps-main"}, paths)
+	if err != nil {
+		t.Fatalf("c.Restore() error = %v", err)
+	}
+	if got, want := key, "deps-main"; got != want {
+		t.Errorf("c.Restore() key = %q, want %q", got, want)
+	}
+	if diff := cmp.Diff(readFiles(t, src), want); diff != "" {
+		t.Errorf("restored files diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestRestoreMiss(t *testing.T) {
+	t.Parallel()
+	c := newTestCache(t)
+
+	key, err := c.Restore(context.Background(), []string{"nope"}, []string{"cache"})
+	if err != nil {
+		t.Fatalf("c.Restore() error = %v", err)
+	}
+	if key != "" {
+		t.Errorf("c.Restore() key = %q, want empty", key)
+	}
+}
+
+func TestRestoreExpired(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"cache/a.txt": "a"})
+	if err := c.Save(ctx, []string{"key"}, []string{filepath.Join(src, "cache")}, time.Hour); err != nil {
+		t.Fatalf("c.Save() error = %v", err)
+	}
+
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	key, err := c.Restore(ctx, []string{"key"}, []string{filepath.Join(src, "cache")})
+	if err != nil {
+		t.Fatalf("c.Restore() error = %v", err)
+	}
+	if key != "" {
+		t.Errorf("c.Restore() key = %q, want empty as the entry expired", key)
+	}
+}
+
+func TestSaveDeduplicatesContents(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"cache/a.txt": "a"})
+	paths := []string{filepath.Join(src, "cache")}
+
+	for _, key := range []string{"one", "two"} {
+		if err := c.Save(ctx, []string{key}, paths, 0); err != nil {
+			t.Fatalf("c.Save(%q) error = %v", key, err)
+		}
+	}
+
+	one, err := c.manifest(ctx, "one")
+	if err != nil {
+		t.Fatalf("c.manifest(one) error = %v", err)
+	}
+	two, err := c.manifest(ctx, "two")
+	if err != nil {
+		t.Fatalf("c.manifest(two) error = %v", err)
+	}
+	if one.Blob != two.Blob {
+		t.Errorf("blobs = %q and %q, want them to be the same", one.Blob, two.Blob)
+	}
+}
+
+func TestExpandKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.sum": "deps"})
+	t.Setenv("CACHE_TEST_BRANCH", "feature/llamas")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("os.Getwd() error = %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("os.Chdir() error = %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) }) //nolint:errcheck // best-effort restore
+
+	first, err := ExpandKey(`go-{{ env "CACHE_TEST_BRANCH" }}-{{ checksum "go.sum" }}`)
+	if err != nil {
+		t.Fatalf("ExpandKey() error = %v", err)
+	}
+
+	writeFiles(t, dir, map[string]string{"go.sum": "other deps"})
+	second, err := ExpandKey(`go-{{ env "CACHE_TEST_BRANCH" }}-{{ checksum "go.sum" }}`)
+	if err != nil {
+		t.Fatalf("ExpandKey() error = %v", err)
+	}
+	if first == second {
+		t.Errorf("ExpandKey() = %q before and after go.sum changed, want different keys", first)
+	}
+
+	if _, err := ExpandKey(`go-{{ checksum "missing.lock" }}`); err == nil {
+		t.Errorf("ExpandKey() with no matching files error = nil, want an error")
+	}
+}
+
+func TestRestoreOnlyGivenPaths(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	c := newTestCache(t)
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"a/a.txt": "a", "b/b.txt": "b"})
+	a, b := filepath.Join(src, "a"), filepath.Join(src, "b")
+	if err := c.Save(ctx, []string{"key"}, []string{a, b}, 0); err != nil {
+		t.Fatalf("c.Save() error = %v", err)
+	}
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatalf("os.RemoveAll(%q) error = %v", src, err)
+	}
+
+	if _, err := c.Restore(ctx, []string{"key"}, []string{b, filepath.Join(src, "c")}); err != nil {
+		t.Fatalf("c.Restore() error = %v", err)
+	}
+	if diff := cmp.Diff(readFiles(t, src), map[string]string{"b/b.txt": "b"}); diff != "" {
+		t.Errorf("restored files diff (-got +want):\n%s", diff)
+	}
+}
+
+// writeTestArchive writes an archive containing the entries to a buffer.
+func writeTestArchive(t *testing.T, entries []*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter() error = %v", err)
+	}
+	tw := tar.NewWriter(zw)
+	for _, hdr := range entries {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len("pwned"))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tw.WriteHeader(%q) error = %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte("pwned")); err != nil {
+				t.Fatalf("tw.Write() error = %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close() error = %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zw.Close() error = %v", err)
+	}
+	return &buf
+}
+
+func TestExtractArchiveRejectsEscapes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+	}{
+		{
+			name: "absolute symlink",
+			entries: []*tar.Header{
+				{Name: "0/cache/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "0/cache/passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			},
+		},
+		{
+			name: "relative symlink out of the cached path",
+			entries: []*tar.Header{
+				{Name: "0/cache/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "0/cache/dir/up", Typeflag: tar.TypeSymlink, Linkname: "../../outside"},
+			},
+		},
+		{
+			name: "cached path is a symlink",
+			entries: []*tar.Header{
+				{Name: "0/cache", Typeflag: tar.TypeSymlink, Linkname: "."},
+			},
+		},
+		{
+			name: "write through a symlinked directory",
+			entries: []*tar.Header{
+				{Name: "0/cache/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "0/cache/sub", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "0/cache/sub/file", Typeflag: tar.TypeReg, Mode: 0o644},
+			},
+		},
+		{
+			name: "overwrite a symlink",
+			entries: []*tar.Header{
+				{Name: "0/cache/", Typeflag: tar.TypeDir, Mode: 0o755},
+				{Name: "0/cache/file", Typeflag: tar.TypeSymlink, Linkname: "other"},
+				{Name: "0/cache/file", Typeflag: tar.TypeReg, Mode: 0o644},
+			},
+		},
+		{
+			name: "path outside the cached path",
+			entries: []*tar.Header{
+				{Name: "0/other/file", Typeflag: tar.TypeReg, Mode: 0o644},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			dest := filepath.Join(t.TempDir(), "cache")
+
+			err := extractArchive(writeTestArchive(t, test.entries), map[int]string{0: dest})
+			if err == nil {
+				t.Errorf("extractArchive() error = nil, want an error")
+			}
+			if _, err := os.Lstat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("os.Lstat(%q) error = %v, want %v", dest, err, os.ErrNotExist)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/mattn/go-zglob"
+)
+
+// ExpandKey expands a cache key template. Templates use Go template syntax,
+// with these functions:
+//
+//   - checksum, which returns the SHA-256 checksum of the contents of the
+//     files matching one or more glob patterns, such as
+//     {{ checksum "**/go.sum" }}
+//   - env, which returns the value of an environment variable, such as
+//     {{ env "BUILDKITE_BRANCH" }}
+func ExpandKey(tmpl string) (string, error) {
+	t, err := template.New("key").Option("missingkey=error").Funcs(template.FuncMap{
+		"checksum": checksum,
+		"env":      os.Getenv,
+	}).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parsing cache key %q: %w", tmpl, err)
+	}
+
+	var sb strings.Builder
+	if err := t.Execute(&sb, nil); err != nil {
+		return "", fmt.Errorf("expanding cache key %q: %w", tmpl, err)
+	}
+
+	key := strings.TrimSpace(sb.String())
+	if key == "" {
+		return "", fmt.Errorf("cache key %q expanded to nothing", tmpl)
+	}
+	return key, nil
+}
+
+// checksum hashes the names and contents of the files matching patterns, in
+// a stable order. It's an error for no files to match, as that is almost
+// always a mistake that would make every build share one cache entry.
+func checksum(patterns ...string) (string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := zglob.Glob(pattern)
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("resolving glob %q: %w", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no files match %q", patterns)
+	}
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	h := sha256.New()
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		if fi.IsDir() {
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		if err := hashFile(h, name); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildkite/agent/v3/logger"
+)
+
+// ErrNotFound is returned by a Store when there is no object at a key.
+var ErrNotFound = errors.New("not found")
+
+// Store is somewhere that cache objects are kept, such as an S3 bucket.
+// Objects are identified by slash-separated keys.
+type Store interface {
+	// Open returns the contents of the object at key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Exists reports whether there is an object at key.
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// Put stores the contents of r at key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// NewStore returns the Store at location, which is one of:
+//
+//   - s3://bucket/prefix, for an Amazon S3 bucket
+//   - gs://bucket/prefix, for a Google Cloud Storage bucket
+//   - file:///path, or a path, for a directory on local disk
+func NewStore(ctx context.Context, l logger.Logger, location string) (Store, error) {
+	switch {
+	case location == "":
+		return nil, errors.New("no cache backend was given")
+
+	case strings.HasPrefix(location, "s3://"):
+		return newS3Store(l, location)
+
+	case strings.HasPrefix(location, "gs://"):
+		return newGSStore(ctx, location)
+
+	default:
+		return newDirStore(strings.TrimPrefix(location, "file://"))
+	}
+}
+
+// dirStore stores objects as files within a directory. It can be used to
+// share a cache between agents on one machine, or over a network filesystem.
+type dirStore struct {
+	dir string
+}
+
+func newDirStore(dir string) (*dirStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving cache directory: %w", err)
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (s *dirStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *dirStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *dirStore) Exists(_ context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put writes to a temporary file first, so that concurrent readers never see
+// a partially written object.
+func (s *dirStore) Put(_ context.Context, key string, r io.Reader) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", path, err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", path, err)
+	}
+	return os.Rename(f.Name(), path)
+}
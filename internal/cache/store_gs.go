@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/buildkite/agent/v3/internal/artifact"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// gsStore stores objects in a Google Cloud Storage bucket. It is configured
+// with the same BUILDKITE_GS_* environment variables as artifact uploads.
+type gsStore struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+func newGSStore(ctx context.Context, location string) (*gsStore, error) {
+	client, err := artifact.NewGoogleClient(ctx, storage.DevstorageReadWriteScope)
+	if err != nil {
+		return nil, fmt.Errorf("creating Google Cloud Storage client: %w", err)
+	}
+	service, err := storage.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix := artifact.ParseGSDestination(location)
+	return &gsStore{
+		service: service,
+		bucket:  bucket,
+		prefix:  prefix,
+	}, nil
+}
+
+func (s *gsStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.service.Objects.Get(s.bucket, path.Join(s.prefix, key)).Context(ctx).Download()
+	if isGSNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *gsStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.service.Objects.Get(s.bucket, path.Join(s.prefix, key)).Context(ctx).Do()
+	if isGSNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *gsStore) Put(ctx context.Context, key string, r io.Reader) error {
+	object := &storage.Object{Name: path.Join(s.prefix, key)}
+	_, err := s.service.Objects.Insert(s.bucket, object).Context(ctx).Media(r).Do()
+	return err
+}
+
+func isGSNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
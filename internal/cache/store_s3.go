@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/buildkite/agent/v3/internal/artifact"
+	"github.com/buildkite/agent/v3/logger"
+)
+
+// s3Store stores objects in an S3 bucket. It is configured with the same
+// BUILDKITE_S3_* environment variables as artifact uploads.
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func newS3Store(l logger.Logger, location string) (*s3Store, error) {
+	bucket, prefix := artifact.ParseS3Destination(location)
+	client, err := artifact.NewS3Client(l, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("creating S3 client for bucket %s: %w", bucket, err)
+	}
+	return &s3Store{
+		client: client,
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+	})
+	if isS3NotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	uploader := s3manager.NewUploaderWithClient(s.client)
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(path.Join(s.prefix, key)),
+		Body:   r,
+	})
+	return err
+}
+
+// isS3NotFound reports whether err means that an object doesn't exist. HEAD
+// requests have no body, so their errors only have a status code.
+func isS3NotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() == http.StatusNotFound
+	}
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package housekeeping
+
+import "golang.org/x/sys/unix"
+
+// diskFree returns the space available to unprivileged users on the volume
+// containing path.
+func diskFree(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil //nolint:unconvert // field types vary by platform
+}
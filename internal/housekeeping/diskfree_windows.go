@@ -0,0 +1,19 @@
+//go:build windows
+
+package housekeeping
+
+import "golang.org/x/sys/windows"
+
+// diskFree returns the space available to the current user on the volume
+// containing path.
+func diskFree(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, &total, &totalFree); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
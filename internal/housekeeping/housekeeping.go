@@ -0,0 +1,486 @@
+// Package housekeeping keeps the disk usage of an agent's build directories and
+// git mirrors within configured limits, by removing the least recently used
+// checkouts and mirrors, and keeps git mirrors in shape with periodic
+// maintenance.
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/dustin/go-humanize"
+	"github.com/gofrs/flock"
+)
+
+// DefaultInterval is how often Run cleans up in the background.
+const DefaultInterval = 5 * time.Minute
+
+// Walking directories to total up their sizes can be slow, so size limits are
+// checked at most this often. Free space is cheap to check, so it is always
+// checked.
+const sizeCheckInterval = time.Minute
+
+// Config configures a Manager. A zero limit means no limit.
+type Config struct {
+	// The agent's build path. Checkouts are expected at
+	// BuildPath/<agent name>/<org slug>/<pipeline slug>. Only the checkouts
+	// of agents added with AddAgent are removed, since the build path may be
+	// shared with other agents on the host.
+	BuildPath string
+
+	// The agent's git mirrors path, if mirrors are enabled.
+	GitMirrorsPath string
+
+	// The maximum total size of the checkouts in BuildPath, in bytes.
+	BuildPathMaxSize uint64
+
+	// The maximum total size of the mirrors in GitMirrorsPath, in bytes.
+	GitMirrorsMaxSize uint64
+
+	// The minimum free space to maintain on the volumes containing BuildPath
+	// and GitMirrorsPath, in bytes.
+	DiskFreeMinimum uint64
+
+	// How often each mirror in GitMirrorsPath is maintained. Zero disables
+	// mirror maintenance.
+	GitMirrorsMaintenanceInterval time.Duration
+
+	// Mirrors smaller than this many bytes aren't maintained.
+	GitMirrorsMaintenanceMinSize uint64
+}
+
+// Enabled reports whether any limits are set, or mirror maintenance is
+// enabled.
+func (c Config) Enabled() bool {
+	return c.BuildPathMaxSize > 0 || c.GitMirrorsMaxSize > 0 || c.DiskFreeMinimum > 0 ||
+		c.GitMirrorsMaintenanceInterval > 0
+}
+
+// Manager removes checkouts and git mirrors when they exceed the configured
+// limits. It is safe for concurrent use by multiple agent workers.
+type Manager struct {
+	logger logger.Logger
+	conf   Config
+
+	// diskFree is swapped out in tests
+	diskFree func(path string) (uint64, error)
+
+	// cleanMu serializes calls to Clean, and guards lastSizeCheck. It is
+	// held while walking directories, so mu doesn't need to be.
+	cleanMu       sync.Mutex
+	lastSizeCheck time.Time
+
+	mu        sync.Mutex
+	inUse     map[string]int
+	agentDirs map[string]bool
+
+	// stopMaintenance cancels mirror maintenance that is in progress, if any.
+	stopMaintenance context.CancelFunc
+}
+
+// New returns a Manager for the given config.
+func New(l logger.Logger, conf Config) *Manager {
+	return &Manager{
+		logger:    l,
+		conf:      conf,
+		diskFree:  diskFree,
+		inUse:     make(map[string]int),
+		agentDirs: make(map[string]bool),
+	}
+}
+
+// AddAgent makes the checkouts of the named agent subject to removal. Other
+// directories in the build path are left alone.
+func (m *Manager) AddAgent(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.agentDirs[agentDir(name)] = true
+}
+
+// CheckoutDir returns the directory the executor will check out a job into,
+// given the agent's build path and name and the job's organization and
+// pipeline slugs. It must match the layout used by the executor.
+func CheckoutDir(buildPath, agentName, orgSlug, pipelineSlug string) string {
+	return filepath.Join(buildPath, agentDir(agentName), orgSlug, pipelineSlug)
+}
+
+// agentDir returns the name of the agent's directory in the build path.
+func agentDir(agentName string) string {
+	return regexp.MustCompile("[[:^alnum:]]").ReplaceAllString(agentName, "-")
+}
+
+// Use marks dir as being in use, so that it won't be removed until the
+// returned function is called. The returned function also marks dir as
+// recently used.
+func (m *Manager) Use(dir string) (release func()) {
+	dir = filepath.Clean(dir)
+
+	m.mu.Lock()
+	m.inUse[dir]++
+	if m.stopMaintenance != nil {
+		// Get out of the way of the job
+		m.stopMaintenance()
+	}
+	m.mu.Unlock()
+
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.inUse[dir]--
+		if m.inUse[dir] <= 0 {
+			delete(m.inUse, dir)
+		}
+
+		now := time.Now()
+		if err := os.Chtimes(dir, now, now); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			m.logger.Debug("Couldn't update modification time of %s: %v", dir, err)
+		}
+	}
+}
+
+// Run cleans up, and maintains mirrors while no jobs are running, every
+// interval until the context is done.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Clean(ctx); err != nil {
+				m.logger.Warn("Disk housekeeping failed: %v", err)
+			}
+			if err := m.Maintain(ctx); err != nil {
+				m.logger.Warn("Git mirror maintenance failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// entry is a checkout or mirror that could be removed.
+type entry struct {
+	path     string
+	lastUsed time.Time
+	size     uint64
+	mirror   bool
+	removed  bool
+}
+
+// Clean removes least recently used checkouts (of the agents added with
+// AddAgent) and mirrors until the configured limits are met, skipping any that
+// are in use. Mirrors are only removed while no checkouts are in use, as the
+// checkouts of running jobs may be borrowing objects from them.
+func (m *Manager) Clean(ctx context.Context) error {
+	if !m.conf.Enabled() {
+		return nil
+	}
+
+	m.cleanMu.Lock()
+	defer m.cleanMu.Unlock()
+
+	checkSizes := time.Since(m.lastSizeCheck) >= sizeCheckInterval &&
+		(m.conf.BuildPathMaxSize > 0 || m.conf.GitMirrorsMaxSize > 0)
+
+	checkFree := false
+	if m.conf.DiskFreeMinimum > 0 {
+		for _, root := range m.roots() {
+			free, err := m.diskFree(root)
+			if err != nil {
+				return fmt.Errorf("checking free space for %s: %w", root, err)
+			}
+			if free < m.conf.DiskFreeMinimum {
+				checkFree = true
+			}
+		}
+	}
+
+	if !checkSizes && !checkFree {
+		return nil
+	}
+
+	var checkouts []*entry
+	for _, dir := range m.ownAgentDirs() {
+		found, err := m.findEntries(dir, 2, false, checkSizes)
+		if err != nil {
+			return err
+		}
+		checkouts = append(checkouts, found...)
+	}
+	sortByLastUsed(checkouts)
+
+	var mirrors []*entry
+	if m.conf.GitMirrorsPath != "" {
+		var err error
+		if mirrors, err = m.findEntries(m.conf.GitMirrorsPath, 1, true, checkSizes); err != nil {
+			return err
+		}
+	}
+
+	if checkSizes {
+		m.lastSizeCheck = time.Now()
+
+		if limit := m.conf.BuildPathMaxSize; limit > 0 {
+			total := totalSize(checkouts)
+			for _, e := range checkouts {
+				if total <= limit || ctx.Err() != nil {
+					break
+				}
+				if m.remove(e, checkouts) {
+					total -= e.size
+				}
+			}
+		}
+
+		if limit := m.conf.GitMirrorsMaxSize; limit > 0 {
+			total := totalSize(mirrors)
+			for _, e := range mirrors {
+				if total <= limit || ctx.Err() != nil {
+					break
+				}
+				if m.remove(e, checkouts) {
+					total -= e.size
+				}
+			}
+		}
+	}
+
+	if checkFree {
+		all := append(slices.Clone(checkouts), mirrors...)
+		sortByLastUsed(all)
+		for _, e := range all {
+			if ctx.Err() != nil {
+				break
+			}
+			free, err := m.diskFree(e.path)
+			if err != nil {
+				return fmt.Errorf("checking free space for %s: %w", e.path, err)
+			}
+			if free >= m.conf.DiskFreeMinimum {
+				continue
+			}
+			m.remove(e, checkouts)
+		}
+
+		for _, root := range m.roots() {
+			if free, err := m.diskFree(root); err == nil && free < m.conf.DiskFreeMinimum {
+				m.logger.Warn("Only %s free for %s after housekeeping, below the minimum of %s",
+					humanize.IBytes(free), root, humanize.IBytes(m.conf.DiskFreeMinimum))
+			}
+		}
+	}
+
+	return ctx.Err()
+}
+
+// roots returns the paths whose volumes are subject to DiskFreeMinimum.
+func (m *Manager) roots() []string {
+	roots := []string{m.conf.BuildPath}
+	if m.conf.GitMirrorsPath != "" {
+		roots = append(roots, m.conf.GitMirrorsPath)
+	}
+	return roots
+}
+
+// ownAgentDirs returns the build path directories of the agents added with
+// AddAgent.
+func (m *Manager) ownAgentDirs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dirs := make([]string, 0, len(m.agentDirs))
+	for dir := range m.agentDirs {
+		dirs = append(dirs, filepath.Join(m.conf.BuildPath, dir))
+	}
+	slices.Sort(dirs)
+	return dirs
+}
+
+// remove removes e, unless it's in use, and reports whether it did. Removing a
+// mirror also removes the checkouts that borrow objects from it, since they
+// would no longer work without it. A mirror that checkouts of other agents
+// borrow from isn't removed.
+func (m *Manager) remove(e *entry, checkouts []*entry) bool {
+	// Holding mu keeps jobs from starting to use what is being removed.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.removeLocked(e, checkouts)
+}
+
+func (m *Manager) removeLocked(e *entry, checkouts []*entry) bool {
+	if e.removed {
+		return false
+	}
+
+	if !e.mirror {
+		if m.inUse[e.path] > 0 {
+			return false
+		}
+		m.logger.Info("Removing checkout %s (%s, last used %s)", e.path, humanize.IBytes(e.size), humanize.Time(e.lastUsed))
+		if err := os.RemoveAll(e.path); err != nil {
+			m.logger.Warn("Couldn't remove checkout %s: %v", e.path, err)
+			return false
+		}
+		e.removed = true
+		return true
+	}
+
+	if len(m.inUse) > 0 {
+		return false
+	}
+
+	unlock, ok := tryLockMirror(e.path)
+	if !ok {
+		m.logger.Debug("Not removing mirror %s as it is locked", e.path)
+		return false
+	}
+	defer unlock()
+
+	if other := m.otherBorrower(e.path); other != "" {
+		m.logger.Debug("Not removing mirror %s as %s borrows objects from it", e.path, other)
+		return false
+	}
+
+	for _, c := range checkouts {
+		if !c.removed && borrowsFrom(c.path, e.path) {
+			if !m.removeLocked(c, nil) {
+				return false
+			}
+		}
+	}
+
+	m.logger.Info("Removing git mirror %s (%s, last used %s)", e.path, humanize.IBytes(e.size), humanize.Time(e.lastUsed))
+	if err := os.RemoveAll(e.path); err != nil {
+		m.logger.Warn("Couldn't remove git mirror %s: %v", e.path, err)
+		return false
+	}
+	os.Remove(e.path + maintainedSuffix) //nolint:errcheck // it may never have been maintained
+	e.removed = true
+	return true
+}
+
+// otherBorrower returns a checkout in the build path that borrows objects from
+// the mirror and belongs to an agent that wasn't added with AddAgent, if
+// there is one.
+func (m *Manager) otherBorrower(mirror string) string {
+	matches, _ := filepath.Glob(filepath.Join(m.conf.BuildPath, "*", "*", "*"))
+	for _, path := range matches {
+		rel, err := filepath.Rel(m.conf.BuildPath, path)
+		if err != nil || m.agentDirs[strings.Split(filepath.ToSlash(rel), "/")[0]] {
+			continue
+		}
+		if borrowsFrom(path, mirror) {
+			return path
+		}
+	}
+	return ""
+}
+
+// tryLockMirror takes the same locks the executor holds while cloning and
+// updating the mirror (or adding worktrees of it), without waiting. It reports
+// whether it got them, and if so, returns a function to release them.
+func tryLockMirror(mirror string) (unlock func(), ok bool) {
+	var locks []*flock.Flock
+	unlock = func() {
+		for _, l := range locks {
+			l.Unlock() //nolint:errcheck // best-effort unlock
+		}
+	}
+	// shell.LockFile appends an "f".
+	for _, name := range []string{".clonelockf", ".updatelockf"} {
+		l := flock.New(mirror + name)
+		locked, err := l.TryLock()
+		if err != nil || !locked {
+			unlock()
+			return nil, false
+		}
+		locks = append(locks, l)
+	}
+	return unlock, true
+}
+
+// findEntries finds the directories exactly depth levels below root, ordered
+// from least to most recently used.
+func (m *Manager) findEntries(root string, depth int, mirror, withSizes bool) ([]*entry, error) {
+	pattern := root
+	for range depth {
+		pattern = filepath.Join(pattern, "*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("finding directories in %s: %w", root, err)
+	}
+
+	var entries []*entry
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		e := &entry{path: filepath.Clean(path), lastUsed: info.ModTime(), mirror: mirror}
+		if withSizes {
+			e.size = dirSize(path)
+		}
+		entries = append(entries, e)
+	}
+	sortByLastUsed(entries)
+	return entries, nil
+}
+
+// borrowsFrom reports whether the checkout in dir refers to objects in the
+// mirror, either as a clone using --reference, or as a worktree.
+func borrowsFrom(dir, mirror string) bool {
+	for _, name := range []string{
+		filepath.Join(".git", "objects", "info", "alternates"),
+		".git", // a file, for worktrees
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && strings.Contains(filepath.ToSlash(string(b)), filepath.ToSlash(mirror)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSize totals up the sizes of the files in dir. Errors are ignored, since
+// jobs may be changing things as we go.
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error { //nolint:errcheck // see above
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
+
+func totalSize(entries []*entry) uint64 {
+	var total uint64
+	for _, e := range entries {
+		if !e.removed {
+			total += e.size
+		}
+	}
+	return total
+}
+
+func sortByLastUsed(entries []*entry) {
+	slices.SortStableFunc(entries, func(a, b *entry) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+}
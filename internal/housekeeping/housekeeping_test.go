@@ -0,0 +1,284 @@
+package housekeeping
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// makeDir creates dir containing a file of the given size, last used the given
+// number of hours ago.
+func makeDir(t *testing.T, dir string, size int, hoursAgo int) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(%q) error = %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), make([]byte, size), 0o666); err != nil {
+		t.Fatalf("os.WriteFile error = %v", err)
+	}
+	mtime := time.Now().Add(-time.Duration(hoursAgo) * time.Hour)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", dir, err)
+	}
+}
+
+// remaining lists the directories under root at the given depth, ignoring
+// lock files.
+func remaining(t *testing.T, root string, depth int) []string {
+	t.Helper()
+	pattern := root + strings.Repeat(string(filepath.Separator)+"*", depth)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatalf("filepath.Glob(%q) error = %v", pattern, err)
+	}
+	var got []string
+	for _, m := range matches {
+		if info, err := os.Stat(m); err != nil || !info.IsDir() {
+			continue
+		}
+		rel, _ := filepath.Rel(root, m)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	return got
+}
+
+func TestCleanBuildPathMaxSize(t *testing.T) {
+	t.Parallel()
+
+	buildPath := t.TempDir()
+	makeDir(t, filepath.Join(buildPath, "agent-1", "org", "oldest"), 1000, 5)
+	makeDir(t, filepath.Join(buildPath, "agent-1", "org", "old"), 1000, 4)
+	makeDir(t, filepath.Join(buildPath, "agent-2", "org", "in-use"), 1000, 3)
+	makeDir(t, filepath.Join(buildPath, "agent-2", "org", "recent"), 1000, 1)
+
+	m := New(logger.Discard, Config{
+		BuildPath:        buildPath,
+		BuildPathMaxSize: 2500,
+	})
+	m.AddAgent("agent 1")
+	m.AddAgent("agent 2")
+	release := m.Use(CheckoutDir(buildPath, "agent 2", "org", "in-use"))
+	defer release()
+
+	if err := m.Clean(context.Background()); err != nil {
+		t.Fatalf("m.Clean() error = %v", err)
+	}
+
+	want := []string{"agent-2/org/in-use", "agent-2/org/recent"}
+	if diff := cmp.Diff(remaining(t, buildPath, 3), want); diff != "" {
+		t.Errorf("remaining checkouts diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCleanDiskFreeMinimumRemovesMirrorsAndBorrowingCheckouts(t *testing.T) {
+	t.Parallel()
+
+	buildPath, mirrorsPath := t.TempDir(), t.TempDir()
+
+	oldMirror := filepath.Join(mirrorsPath, "old-repo")
+	makeDir(t, oldMirror, 1000, 10)
+	makeDir(t, filepath.Join(mirrorsPath, "new-repo"), 1000, 1)
+
+	// This checkout borrows objects from the old mirror, so can't outlive it.
+	borrowing := filepath.Join(buildPath, "agent", "org", "borrowing")
+	makeDir(t, filepath.Join(borrowing, ".git", "objects", "info"), 0, 0)
+	alternates := filepath.Join(borrowing, ".git", "objects", "info", "alternates")
+	if err := os.WriteFile(alternates, []byte(oldMirror+"/objects\n"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", alternates, err)
+	}
+	makeDir(t, borrowing, 10, 2)
+	makeDir(t, filepath.Join(buildPath, "agent", "org", "independent"), 10, 3)
+
+	// Pretend there's enough free space once the old mirror is gone.
+	m := New(logger.Discard, Config{
+		BuildPath:       buildPath,
+		GitMirrorsPath:  mirrorsPath,
+		DiskFreeMinimum: 100,
+	})
+	m.AddAgent("agent")
+	m.diskFree = func(string) (uint64, error) {
+		if _, err := os.Stat(oldMirror); err == nil {
+			return 0, nil
+		}
+		return 1000, nil
+	}
+
+	if err := m.Clean(context.Background()); err != nil {
+		t.Fatalf("m.Clean() error = %v", err)
+	}
+
+	if diff := cmp.Diff(remaining(t, mirrorsPath, 1), []string{"new-repo"}); diff != "" {
+		t.Errorf("remaining mirrors diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(remaining(t, buildPath, 3), []string{"agent/org/independent"}); diff != "" {
+		t.Errorf("remaining checkouts diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCleanDoesNotRemoveMirrorsWhileCheckoutsInUse(t *testing.T) {
+	t.Parallel()
+
+	buildPath, mirrorsPath := t.TempDir(), t.TempDir()
+	makeDir(t, filepath.Join(mirrorsPath, "repo"), 1000, 10)
+	makeDir(t, filepath.Join(buildPath, "agent", "org", "pipeline"), 10, 1)
+
+	m := New(logger.Discard, Config{
+		BuildPath:         buildPath,
+		GitMirrorsPath:    mirrorsPath,
+		GitMirrorsMaxSize: 1,
+	})
+	m.AddAgent("agent")
+	release := m.Use(CheckoutDir(buildPath, "agent", "org", "pipeline"))
+	defer release()
+
+	if err := m.Clean(context.Background()); err != nil {
+		t.Fatalf("m.Clean() error = %v", err)
+	}
+
+	if diff := cmp.Diff(remaining(t, mirrorsPath, 1), []string{"repo"}); diff != "" {
+		t.Errorf("remaining mirrors diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestCleanLeavesOtherAgentsAlone(t *testing.T) {
+	t.Parallel()
+
+	buildPath, mirrorsPath := t.TempDir(), t.TempDir()
+	mirror := filepath.Join(mirrorsPath, "repo")
+	makeDir(t, mirror, 1000, 10)
+
+	// Another agent sharing the build path has a checkout that borrows objects
+	// from the mirror.
+	borrowing := filepath.Join(buildPath, "other-agent", "org", "pipeline")
+	makeDir(t, filepath.Join(borrowing, ".git", "objects", "info"), 0, 0)
+	alternates := filepath.Join(borrowing, ".git", "objects", "info", "alternates")
+	if err := os.WriteFile(alternates, []byte(mirror+"/objects\n"), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", alternates, err)
+	}
+	makeDir(t, borrowing, 1000, 5)
+	makeDir(t, filepath.Join(buildPath, "agent", "org", "pipeline"), 1000, 1)
+
+	m := New(logger.Discard, Config{
+		BuildPath:         buildPath,
+		GitMirrorsPath:    mirrorsPath,
+		BuildPathMaxSize:  1,
+		GitMirrorsMaxSize: 1,
+	})
+	m.AddAgent("agent")
+
+	if err := m.Clean(context.Background()); err != nil {
+		t.Fatalf("m.Clean() error = %v", err)
+	}
+
+	if diff := cmp.Diff(remaining(t, buildPath, 3), []string{"other-agent/org/pipeline"}); diff != "" {
+		t.Errorf("remaining checkouts diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(remaining(t, mirrorsPath, 1), []string{"repo"}); diff != "" {
+		t.Errorf("remaining mirrors diff (-got +want):\n%s", diff)
+	}
+}
+
+// makeMirror creates a git mirror in mirrorsPath of a new repository with a
+// commit, last used the given number of hours ago.
+func makeMirror(t *testing.T, mirrorsPath string, hoursAgo int) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	repo := t.TempDir()
+	mirror := filepath.Join(mirrorsPath, "repo")
+	for _, args := range [][]string{
+		{"-C", repo, "init", "--quiet"},
+		{"-C", repo, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "--quiet", "--allow-empty", "-m", "hello"},
+		{"clone", "--quiet", "--mirror", repo, mirror},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v error = %v\n%s", args, err, out)
+		}
+	}
+
+	mtime := time.Now().Add(-time.Duration(hoursAgo) * time.Hour)
+	if err := os.Chtimes(mirror, mtime, mtime); err != nil {
+		t.Fatalf("os.Chtimes(%q) error = %v", mirror, err)
+	}
+	return mirror
+}
+
+func TestMaintain(t *testing.T) {
+	t.Parallel()
+
+	buildPath, mirrorsPath := t.TempDir(), t.TempDir()
+	mirror := makeMirror(t, mirrorsPath, 10)
+	before, err := os.Stat(mirror)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", mirror, err)
+	}
+
+	m := New(logger.Discard, Config{
+		BuildPath:                     buildPath,
+		GitMirrorsPath:                mirrorsPath,
+		GitMirrorsMaintenanceInterval: time.Hour,
+	})
+	if err := m.Maintain(context.Background()); err != nil {
+		t.Fatalf("m.Maintain() error = %v", err)
+	}
+
+	if _, err := os.Stat(mirror + maintainedSuffix); err != nil {
+		t.Errorf("os.Stat(%q) error = %v, want the mirror to have been maintained", mirror+maintainedSuffix, err)
+	}
+	if _, err := os.Stat(filepath.Join(mirror, "packed-refs")); err != nil {
+		t.Errorf("os.Stat(packed-refs) error = %v, want git gc to have packed the refs", err)
+	}
+	after, err := os.Stat(mirror)
+	if err != nil {
+		t.Fatalf("os.Stat(%q) error = %v", mirror, err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("mirror modification time = %v, want unchanged %v", after.ModTime(), before.ModTime())
+	}
+	if m.dueForMaintenance(mirror) {
+		t.Errorf("m.dueForMaintenance(%q) = true after maintenance, want false", mirror)
+	}
+}
+
+func TestMaintainSkipsWhileCheckoutsInUseOrMirrorsLocked(t *testing.T) {
+	t.Parallel()
+
+	buildPath, mirrorsPath := t.TempDir(), t.TempDir()
+	mirror := makeMirror(t, mirrorsPath, 10)
+
+	m := New(logger.Discard, Config{
+		BuildPath:                     buildPath,
+		GitMirrorsPath:                mirrorsPath,
+		GitMirrorsMaintenanceInterval: time.Hour,
+	})
+
+	release := m.Use(CheckoutDir(buildPath, "agent", "org", "pipeline"))
+	if err := m.Maintain(context.Background()); err != nil {
+		t.Fatalf("m.Maintain() error = %v", err)
+	}
+	release()
+	if !m.dueForMaintenance(mirror) {
+		t.Errorf("m.dueForMaintenance(%q) = false after maintaining while a checkout was in use, want true", mirror)
+	}
+
+	unlock, ok := tryLockMirror(mirror)
+	if !ok {
+		t.Fatalf("tryLockMirror(%q) = false, want true", mirror)
+	}
+	defer unlock()
+	if err := m.Maintain(context.Background()); err != nil {
+		t.Fatalf("m.Maintain() error = %v", err)
+	}
+	if !m.dueForMaintenance(mirror) {
+		t.Errorf("m.dueForMaintenance(%q) = false after maintaining while the mirror was locked, want true", mirror)
+	}
+}
@@ -0,0 +1,142 @@
+package housekeeping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// maintainedSuffix is appended to a mirror's path to name the file whose
+// modification time records when the mirror was last maintained. It lives
+// next to the mirror (like the lock files) so that touching it doesn't make
+// the mirror look recently used.
+const maintainedSuffix = ".maintained"
+
+// Maintain runs maintenance on the mirrors that are due for it, while no jobs
+// are running. Maintenance prunes refs that no longer exist in the remote
+// repository, then runs git gc. It stops early, without error, if a job
+// starts.
+func (m *Manager) Maintain(ctx context.Context) error {
+	if m.conf.GitMirrorsMaintenanceInterval <= 0 || m.conf.GitMirrorsPath == "" {
+		return nil
+	}
+
+	mirrors, err := m.findEntries(m.conf.GitMirrorsPath, 1, true, false)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range mirrors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !m.dueForMaintenance(e.path) {
+			continue
+		}
+
+		m.mu.Lock()
+		if len(m.inUse) > 0 {
+			m.mu.Unlock()
+			m.logger.Debug("Postponing git mirror maintenance as a job is running")
+			return nil
+		}
+		mctx, cancel := context.WithCancel(ctx)
+		m.stopMaintenance = cancel
+		m.mu.Unlock()
+
+		err := m.maintainMirror(mctx, e)
+
+		m.mu.Lock()
+		m.stopMaintenance = nil
+		m.mu.Unlock()
+		stopped := mctx.Err() != nil && ctx.Err() == nil
+		cancel()
+
+		switch {
+		case stopped:
+			m.logger.Info("Stopped maintaining git mirror %s as a job started", e.path)
+			return nil
+		case err != nil:
+			m.logger.Warn("Couldn't maintain git mirror %s: %v", e.path, err)
+		}
+	}
+	return nil
+}
+
+// dueForMaintenance reports whether the mirror hasn't been maintained within
+// the maintenance interval, and is big enough to bother.
+func (m *Manager) dueForMaintenance(mirror string) bool {
+	info, err := os.Stat(mirror + maintainedSuffix)
+	if err == nil && time.Since(info.ModTime()) < m.conf.GitMirrorsMaintenanceInterval {
+		return false
+	}
+	if minSize := m.conf.GitMirrorsMaintenanceMinSize; minSize > 0 && dirSize(mirror) < minSize {
+		return false
+	}
+	return true
+}
+
+// maintainMirror prunes stale refs from the mirror and runs git gc on it,
+// holding the mirror locks so that the executor doesn't use the mirror at the
+// same time. Mirrors that are locked are skipped.
+func (m *Manager) maintainMirror(ctx context.Context, e *entry) error {
+	unlock, ok := tryLockMirror(e.path)
+	if !ok {
+		m.logger.Debug("Not maintaining mirror %s as it is locked", e.path)
+		return nil
+	}
+	defer unlock()
+
+	before := dirSize(e.path)
+	m.logger.Info("Maintaining git mirror %s (%s)", e.path, humanize.IBytes(before))
+
+	// Pruning needs to reach the remote, which may not be possible from here
+	// (e.g. if the agent's credentials are only available to jobs), but gc is
+	// worthwhile regardless.
+	if err := runGit(ctx, e.path, "remote", "prune", "origin"); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		m.logger.Warn("Couldn't prune stale refs from git mirror %s: %v", e.path, err)
+	}
+	if err := runGit(ctx, e.path, "gc", "--quiet"); err != nil {
+		return err
+	}
+
+	// Maintenance isn't use, so keep the mirror's place in the least recently
+	// used order.
+	if err := os.Chtimes(e.path, e.lastUsed, e.lastUsed); err != nil {
+		m.logger.Debug("Couldn't restore modification time of %s: %v", e.path, err)
+	}
+
+	now := time.Now()
+	stamp := e.path + maintainedSuffix
+	err := os.Chtimes(stamp, now, now)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.WriteFile(stamp, nil, 0o666)
+	}
+	if err != nil {
+		return fmt.Errorf("recording maintenance time: %w", err)
+	}
+
+	m.logger.Info("Finished maintaining git mirror %s (%s → %s)", e.path, humanize.IBytes(before), humanize.IBytes(dirSize(e.path)))
+	return nil
+}
+
+// runGit runs git with the given arguments in the mirror.
+func runGit(ctx context.Context, mirror string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--git-dir", mirror}, args...)...)
+	// There's nobody to answer a credential prompt
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -30,6 +30,12 @@ We'll continue to run your job, but you won't be able to use the Job API`)
 	if e.ExecutorConfig.Debug {
 		jobAPIOpts = append(jobAPIOpts, jobapi.WithDebug())
 	}
+	if e.ExecutorConfig.RunInPty {
+		jobAPIOpts = append(jobAPIOpts, jobapi.WithPTYResizer(e.shell.ResizePTY))
+	}
+	if len(e.ExecutorConfig.ProtectedEnv) > 0 {
+		jobAPIOpts = append(jobAPIOpts, jobapi.WithProtectedEnv(e.ExecutorConfig.ProtectedEnv))
+	}
 	srv, token, err := jobapi.NewServer(e.shell.Logger, socketPath, e.shell.Env, e.redactors, jobAPIOpts...)
 	if err != nil {
 		return cleanup, fmt.Errorf("creating job API server: %w", err)
@@ -54,12 +60,13 @@ We'll continue to run your job, but you won't be able to use the Job API`)
 		// an access token with a name ending in _TOKEN is *not* redacted.
 		// Conclusion: if the name matches, redact the Job API token.
 		// This depends on startJobAPI being called after setupRedactors.
-		e.redactors.Add(token)
+		e.redactors.Add(redact.Variants(token)...)
 	}
 
 	if err := srv.Start(); err != nil {
 		return cleanup, fmt.Errorf("starting Job API server: %w", err)
 	}
+	e.jobAPI = srv
 
 	return func() {
 		err = srv.Stop()
@@ -2,7 +2,9 @@ package job
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/buildkite/agent/v3/internal/artifact"
 	"github.com/buildkite/agent/v3/tracetools"
 )
 
@@ -62,6 +64,19 @@ func (e *Executor) uploadArtifacts(ctx context.Context) error {
 	defer func() { span.FinishWithError(err) }()
 
 	e.shell.Headerf("Uploading artifacts")
+
+	// Check the paths with the same matcher the upload uses, so that mistakes
+	// in them are reported against artifact_paths.
+	globs, err := artifact.ParseGlobs(e.AutomaticArtifactUploadPaths, e.shell.Getwd())
+	if err != nil {
+		err = fmt.Errorf("invalid artifact_paths: %w", err)
+		return err
+	}
+	if len(globs.Include) == 0 {
+		e.shell.Warningf("The artifact_paths %q only exclude files, so there's nothing to upload", e.AutomaticArtifactUploadPaths)
+		return nil
+	}
+
 	args := []string{"artifact", "upload", e.AutomaticArtifactUploadPaths}
 
 	// If blank, the upload destination is buildkite
@@ -0,0 +1,110 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/shell"
+)
+
+// How long on-cancel hooks may run for between them, unless
+// BUILDKITE_HOOK_TIMEOUT_ON_CANCEL or the hook timeout says otherwise. The
+// time comes out of the agent's cancel grace period.
+const defaultOnCancelHookTimeout = 5 * time.Second
+
+// runOnCancelHooks runs the global on-cancel hooks, then the local one, once
+// the job has been cancelled but before its command is interrupted. The job is
+// still running, so they are run in their own shell, sh, and their environment
+// changes are discarded.
+func (e *Executor) runOnCancelHooks(ctx context.Context, sh *shell.Shell) {
+	paths, err := e.getAllGlobalHookPaths("on-cancel")
+	if err != nil {
+		sh.Warningf("Couldn't find global on-cancel hooks: %v", err)
+	}
+	scopes := make([]string, len(paths))
+	for i := range paths {
+		scopes[i] = "global"
+	}
+
+	if e.onCancelLocalHooksEnabled() {
+		localPath, err := e.findHook(filepath.Join(sh.Getwd(), ".buildkite", "hooks"), "on-cancel")
+		switch {
+		case err == nil:
+			paths = append(paths, localPath)
+			scopes = append(scopes, "local")
+		case !errors.Is(err, os.ErrNotExist):
+			sh.Warningf("Couldn't find local on-cancel hook: %v", err)
+		}
+	}
+
+	if len(paths) == 0 {
+		return
+	}
+
+	timeout := e.hookTimeout("on-cancel")
+	if timeout == 0 {
+		timeout = defaultOnCancelHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for i, path := range paths {
+		hookName := scopes[i] + " on-cancel"
+		sh.Headerf("Running %s hook", hookName)
+
+		if err := runOnCancelHook(ctx, sh, scopes[i], path); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				sh.Errorf("The %s hook was stopped because the on-cancel hooks ran for longer than their timeout of %v", hookName, timeout)
+				return
+			}
+			sh.Errorf("The %s hook failed: %v", hookName, err)
+		}
+	}
+}
+
+func runOnCancelHook(ctx context.Context, sh *shell.Shell, scope, path string) error {
+	hookType, err := hook.Type(path)
+	if err != nil {
+		return err
+	}
+
+	// Shell hooks are usually sourced by a wrapper, so they needn't be
+	// executable. There's no environment to capture here, so run them with
+	// Bash (or sh) directly instead.
+	var script shell.Command
+	if hookType == hook.TypeShell && runtime.GOOS != "windows" {
+		interpreter, err := sh.AbsolutePath("bash")
+		if err != nil {
+			if interpreter, err = sh.AbsolutePath("sh"); err != nil {
+				return fmt.Errorf("finding a shell to run the hook: %w", err)
+			}
+		}
+		script = sh.Command(interpreter, path)
+	} else if script, err = sh.Script(path); err != nil {
+		return fmt.Errorf("preparing hook script: %w", err)
+	}
+
+	environ := env.New()
+	environ.Set("BUILDKITE_HOOK_PHASE", "on-cancel")
+	environ.Set("BUILDKITE_HOOK_PATH", path)
+	environ.Set("BUILDKITE_HOOK_SCOPE", scope)
+
+	return script.Run(ctx, shell.WithExtraEnv(environ))
+}
+
+// onCancelLocalHooksEnabled reports whether local hooks may run, checking the
+// same settings as executeLocalHook without warning when they can't.
+func (e *Executor) onCancelLocalHooksEnabled() bool {
+	if !e.ExecutorConfig.LocalHooksEnabled {
+		return false
+	}
+	noLocalHooks, _ := e.shell.Env.Get("BUILDKITE_NO_LOCAL_HOOKS")
+	return noLocalHooks != "true" && noLocalHooks != "1"
+}
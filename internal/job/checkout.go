@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -56,6 +60,14 @@ func (e *Executor) configureHTTPSInsteadOfSSH(ctx context.Context) error {
 func (e *Executor) removeCheckoutDir() error {
 	checkoutPath, _ := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
 
+	// Unmounting discards everything in an ephemeral build directory, and
+	// the mount point can't be removed until then
+	if e.ephemeralMount == checkoutPath {
+		if err := e.unmountEphemeralBuildDir(); err != nil {
+			return err
+		}
+	}
+
 	// on windows, sometimes removing large dirs can fail for various reasons
 	// for instance having files open
 	// see https://github.com/golang/go/issues/20841
@@ -88,6 +100,14 @@ func (e *Executor) createCheckoutDir() error {
 		}
 	}
 
+	if e.EphemeralBuildDir && e.ephemeralMount == "" {
+		e.shell.Commentf("Mounting an ephemeral build directory at %q", checkoutPath)
+		if err := mountEphemeral(checkoutPath); err != nil {
+			return fmt.Errorf("mounting ephemeral build directory: %w", err)
+		}
+		e.ephemeralMount = checkoutPath
+	}
+
 	if e.shell.Getwd() != checkoutPath {
 		if err := e.shell.Chdir(checkoutPath); err != nil {
 			return err
@@ -97,6 +117,27 @@ func (e *Executor) createCheckoutDir() error {
 	return nil
 }
 
+// checkAllowedRepository returns an error if the repository doesn't match any
+// of AllowedRepositories.
+func (e *Executor) checkAllowedRepository() error {
+	if len(e.AllowedRepositories) == 0 {
+		return nil
+	}
+
+	for _, pattern := range e.AllowedRepositories {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("Failed to parse allowed repository pattern %q: %w", pattern, err)
+		}
+		if re.MatchString(e.Repository) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Repository %s is not allowed on this agent, as it has no match in %s",
+		e.Repository, e.AllowedRepositories)
+}
+
 // CheckoutPhase creates the build directory and makes sure we're running the
 // build at the right commit.
 func (e *Executor) CheckoutPhase(ctx context.Context) error {
@@ -140,8 +181,19 @@ func (e *Executor) CheckoutPhase(ctx context.Context) error {
 		return err
 	}
 
+	// Check the repository before any checkout, including one done by a
+	// checkout hook. It's checked here, rather than at the start of the job,
+	// as pre-checkout hooks may have changed it.
+	if !e.SkipCheckout && e.ExecutorConfig.Repository != "" {
+		if err = e.checkAllowedRepository(); err != nil {
+			return err
+		}
+	}
+
 	// There can only be one checkout hook, either plugin or global, in that order
 	switch {
+	case e.SkipCheckout:
+		e.shell.Commentf("Skipping checkout, BUILDKITE_SKIP_CHECKOUT is set")
 	case e.hasPluginHook("checkout"):
 		if err := e.executePluginHook(ctx, "checkout", e.pluginCheckouts); err != nil {
 			return err
@@ -156,11 +208,16 @@ func (e *Executor) CheckoutPhase(ctx context.Context) error {
 			break
 		}
 
+		var v vcs
+		if v, err = e.vcs(); err != nil {
+			return err
+		}
+
 		if err := roko.NewRetrier(
-			roko.WithMaxAttempts(3),
-			roko.WithStrategy(roko.Constant(2*time.Second)),
+			roko.WithMaxAttempts(max(e.CheckoutRetryAttempts, 1)),
+			roko.WithStrategy(roko.Constant(e.CheckoutRetryBackoff)),
 		).DoWithContext(ctx, func(r *roko.Retrier) error {
-			err := e.defaultCheckoutPhase(ctx)
+			err := v.checkout(ctx)
 			if err == nil {
 				return nil
 			}
@@ -186,10 +243,25 @@ func (e *Executor) CheckoutPhase(ctx context.Context) error {
 				r.Break()
 
 			default:
+				ge := new(gitError)
+				isGitError := errors.As(err, &ge)
+
+				// Retrying won't fix credentials, but network and server
+				// problems are often fixed by waiting a little longer each time.
+				switch {
+				case isGitError && ge.Failure == gitFailureAuth:
+					e.shell.Warningf("Checkout failed because of an authentication or permissions problem, so it won't be retried: %s", err)
+					r.Break()
+					return err
+
+				case isGitError && ge.Failure == gitFailureTransient:
+					r.SetNextInterval(checkoutRetryInterval(e.CheckoutRetryBackoff, r.AttemptCount()))
+				}
+
 				e.shell.Warningf("Checkout failed! %s (%s)", err, r)
 
 				// Specifically handle git errors
-				if ge := new(gitError); errors.As(err, &ge) {
+				if isGitError {
 					switch ge.Type {
 					// These types can fail because of corrupted checkouts
 					case gitErrorClean, gitErrorCleanSubmodules, gitErrorClone,
@@ -224,9 +296,13 @@ func (e *Executor) CheckoutPhase(ctx context.Context) error {
 		}
 	}
 
-	err = e.sendCommitToBuildkite(ctx)
-	if err != nil {
-		e.shell.OptionalWarningf("git-commit-resolution-failed", "Couldn't send commit information to Buildkite: %v", err)
+	// There's no repository to get commit information from if the checkout
+	// was skipped
+	if !e.SkipCheckout {
+		err = e.sendCommitToBuildkite(ctx)
+		if err != nil {
+			e.shell.OptionalWarningf("git-commit-resolution-failed", "Couldn't send commit information to Buildkite: %v", err)
+		}
 	}
 
 	// Store the current value of BUILDKITE_BUILD_CHECKOUT_PATH, so we can detect if
@@ -268,6 +344,139 @@ func hasGitSubmodules(sh *shell.Shell) bool {
 	return osutil.FileExists(filepath.Join(sh.Getwd(), ".gitmodules"))
 }
 
+// hasGitLFS returns true if the .gitattributes file in the working directory
+// routes any paths through the Git LFS filter.
+func hasGitLFS(sh *shell.Shell) bool {
+	attrs, err := os.ReadFile(filepath.Join(sh.Getwd(), ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(attrs), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if slices.Contains(strings.Fields(line), "filter=lfs") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutGitLFS fetches and checks out the Git LFS objects for the current
+// commit, if the repository uses Git LFS.
+func (e *Executor) checkoutGitLFS(ctx context.Context) error {
+	if !hasGitLFS(e.shell) {
+		return nil
+	}
+
+	if e.GitLFSSkip {
+		e.shell.Commentf("Git LFS detected, but skipping because BUILDKITE_GIT_LFS_SKIP is set")
+		return nil
+	}
+
+	if _, err := e.shell.AbsolutePath("git-lfs"); err != nil {
+		e.shell.OptionalWarningf("git-lfs-missing", "This repository uses Git LFS, but git-lfs couldn't be found: %v", err)
+		return nil
+	}
+
+	e.shell.Commentf("Git LFS detected")
+
+	// Install the LFS hooks and filters for this repository only, so that
+	// the agent doesn't need to modify the global git config
+	if err := e.shell.Command("git", "lfs", "install", "--local", "--force").Run(ctx); err != nil {
+		return fmt.Errorf("installing git lfs: %w", err)
+	}
+
+	// Fetching talks to the LFS server, so it's the step that can fail
+	// transiently
+	if err := roko.NewRetrier(
+		roko.WithMaxAttempts(3),
+		roko.WithStrategy(roko.Constant(2*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		err := e.shell.Command("git", "lfs", "fetch", "origin", "HEAD").Run(ctx)
+		if err != nil {
+			e.shell.Warningf("Failed to fetch Git LFS objects: %v (%s)", err, r)
+		}
+		return err
+	}); err != nil {
+		return fmt.Errorf("fetching git lfs objects: %w", err)
+	}
+
+	if err := e.shell.Command("git", "lfs", "checkout").Run(ctx); err != nil {
+		return fmt.Errorf("checking out git lfs objects: %w", err)
+	}
+
+	return nil
+}
+
+// gitCloneWithBundle clones the repository like gitClone, but if GitCloneBundle
+// is set, seeds the clone from the bundle first, so that only the objects that
+// aren't in the bundle are fetched from the repository. If the bundle can't be
+// used, the repository is cloned as usual.
+func (e *Executor) gitCloneWithBundle(ctx context.Context, sh *shell.Shell, gitCloneFlags, repository, dir string) error {
+	bundle := e.GitCloneBundle
+	if bundle == "" {
+		return gitClone(ctx, sh, gitCloneFlags, repository, dir)
+	}
+
+	// Bundle URIs are downloaded by git itself, which carries on with a
+	// normal clone if the download fails.
+	if strings.Contains(bundle, "://") {
+		sh.Commentf("Cloning using the bundle at %s", bundle)
+		err := gitClone(ctx, sh, fmt.Sprintf("--bundle-uri %q %s", bundle, gitCloneFlags), repository, dir)
+		// git exits with 129 for unknown options
+		if shell.ExitCode(err) != 129 {
+			return err
+		}
+		sh.Warningf("This version of git doesn't support bundle URIs (git 2.38 or later is needed), so cloning without the bundle")
+		return gitClone(ctx, sh, gitCloneFlags, repository, dir)
+	}
+
+	// Otherwise, clone from the bundle file, then point the clone back at the
+	// repository, so the rest can be fetched from it.
+	sh.Commentf("Cloning from the bundle at %s", bundle)
+	if err := gitClone(ctx, sh, gitCloneFlags, bundle, dir); err != nil {
+		sh.Warningf("Couldn't clone from the bundle at %s, so cloning without it: %v", bundle, err)
+		return gitClone(ctx, sh, gitCloneFlags, repository, dir)
+	}
+	if err := sh.Command("git", "-C", dir, "remote", "set-url", "origin", repository).Run(ctx); err != nil {
+		return fmt.Errorf("setting origin after cloning from bundle: %w", err)
+	}
+	return nil
+}
+
+// maxCheckoutRetryInterval limits how long checkoutRetryInterval waits before
+// retrying.
+const maxCheckoutRetryInterval = time.Minute
+
+// checkoutRetryInterval returns how long to wait before retrying a checkout
+// that failed because of a network or server problem: the backoff doubled for
+// each attempt so far, up to a minute (or the backoff, if that's longer), plus
+// up to one backoff (or a minute, if that's shorter) of jitter so that agents
+// don't all retry at once.
+func checkoutRetryInterval(backoff time.Duration, attempts int) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	// Double one attempt at a time, so that no number of attempts or length
+	// of backoff can overflow.
+	limit := max(backoff, maxCheckoutRetryInterval)
+	interval := backoff
+	for range attempts {
+		if interval >= limit/2 {
+			interval = limit
+			break
+		}
+		interval *= 2
+	}
+	jitter := rand.N(min(backoff, maxCheckoutRetryInterval))
+	if interval > math.MaxInt64-jitter {
+		return math.MaxInt64
+	}
+	return interval + jitter
+}
+
 func hasGitCommit(ctx context.Context, sh *shell.Shell, gitDir string, commit string) bool {
 	// Resolve commit to an actual commit object
 	output, err := sh.Command("git", "--git-dir", gitDir, "rev-parse", commit+"^{commit}").RunAndCaptureStdout(ctx, shell.ShowStderr(false))
@@ -300,21 +509,10 @@ func (e *Executor) updateGitMirror(ctx context.Context, repository string) (stri
 
 	e.shell.Chdir(e.ExecutorConfig.GitMirrorsPath)
 
-	lockTimeout := time.Second * time.Duration(e.GitMirrorsLockTimeout)
-
-	if e.Debug {
-		e.shell.Commentf("Acquiring mirror repository clone lock")
-	}
-
 	// Lock the mirror dir to prevent concurrent clones
-	cloneCtx, canc := context.WithTimeout(ctx, lockTimeout)
-	defer canc()
-	mirrorCloneLock, err := e.shell.LockFile(cloneCtx, mirrorDir+".clonelock")
+	mirrorCloneLock, err := e.lockMirror(ctx, mirrorDir, "clone")
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return "", ErrTimedOutAcquiringLock{Name: "clone", Err: err}
-		}
-		return "", fmt.Errorf("unable to acquire clone lock: %w", err)
+		return "", err
 	}
 	defer mirrorCloneLock.Unlock()
 
@@ -322,7 +520,11 @@ func (e *Executor) updateGitMirror(ctx context.Context, repository string) (stri
 	if !osutil.FileExists(mirrorDir) {
 		e.shell.Commentf("Cloning a mirror of the repository to %q", mirrorDir)
 		flags := "--mirror " + e.GitCloneMirrorFlags
-		if err := gitClone(ctx, e.shell, flags, repository, mirrorDir); err != nil {
+		clone := gitClone
+		if isMainRepository {
+			clone = e.gitCloneWithBundle
+		}
+		if err := clone(ctx, e.shell, flags, repository, mirrorDir); err != nil {
 			e.shell.Commentf("Removing mirror dir %q due to failed clone", mirrorDir)
 			if err := os.RemoveAll(mirrorDir); err != nil {
 				e.shell.Errorf("Failed to remove \"%s\" (%s)", mirrorDir, err)
@@ -344,19 +546,11 @@ func (e *Executor) updateGitMirror(ctx context.Context, repository string) (stri
 		}
 	}
 
-	if e.Debug {
-		e.shell.Commentf("Acquiring mirror repository update lock")
-	}
-
-	// Lock the mirror dir to prevent concurrent updates
-	updateCtx, canc := context.WithTimeout(ctx, lockTimeout)
-	defer canc()
-	mirrorUpdateLock, err := e.shell.LockFile(updateCtx, mirrorDir+".updatelock")
+	// Lock the mirror dir to prevent concurrent updates. This lock also
+	// guards adding and removing worktrees of the mirror.
+	mirrorUpdateLock, err := e.lockMirror(ctx, mirrorDir, "update")
 	if err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return "", ErrTimedOutAcquiringLock{Name: "update", Err: err}
-		}
-		return "", fmt.Errorf("unable to acquire update lock: %w", err)
+		return "", err
 	}
 	defer mirrorUpdateLock.Unlock()
 
@@ -423,6 +617,131 @@ func (e *Executor) updateGitMirror(ctx context.Context, repository string) (stri
 	return mirrorDir, nil
 }
 
+// lockMirror acquires the named lock (e.g. "clone" or "update") on the mirror
+// in mirrorDir, waiting up to GitMirrorsLockTimeout seconds.
+func (e *Executor) lockMirror(ctx context.Context, mirrorDir, name string) (shell.Unlocker, error) {
+	if e.Debug {
+		e.shell.Commentf("Acquiring mirror repository %s lock", name)
+	}
+
+	lockCtx, canc := context.WithTimeout(ctx, time.Second*time.Duration(e.GitMirrorsLockTimeout))
+	defer canc()
+	lock, err := e.shell.LockFile(lockCtx, mirrorDir+"."+name+"lock")
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, ErrTimedOutAcquiringLock{Name: name, Err: err}
+		}
+		return nil, fmt.Errorf("unable to acquire %s lock: %w", name, err)
+	}
+	return lock, nil
+}
+
+// checkoutGitWorktree checks out the job as a worktree of the mirror in
+// mirrorDir, so that the checkout shares the mirror's object store rather than
+// fetching objects of its own.
+func (e *Executor) checkoutGitWorktree(ctx context.Context, mirrorDir string) error {
+	checkoutPath, _ := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
+
+	// The refs needed were fetched into the mirror by updateGitMirror, which
+	// mirrors refs/* as-is.
+	ref := e.Commit
+	if ref == "HEAD" {
+		ref = "refs/heads/" + e.Branch
+		if e.PullRequest != "false" && strings.Contains(e.PipelineProvider, "github") {
+			ref = fmt.Sprintf("refs/pull/%s/head", e.PullRequest)
+		}
+	}
+	if !gitCheckRefFormat(ref) {
+		return fmt.Errorf("%q %w", ref, errInvalidRef)
+	}
+
+	lock, err := e.lockMirror(ctx, mirrorDir, "update")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// Whatever is at the checkout path (a full clone, or a worktree left
+	// behind by a job that didn't get to tear down) has to go, and the mirror
+	// needs to forget about any worktree that was there.
+	if osutil.FileExists(checkoutPath) {
+		if err := e.removeCheckoutDir(); err != nil {
+			return err
+		}
+	}
+	if err := e.shell.Command("git", "--git-dir", mirrorDir, "worktree", "prune").Run(ctx); err != nil {
+		return fmt.Errorf("pruning worktrees: %w", err)
+	}
+
+	// Add the worktree within the ephemeral build directory, rather than
+	// mounting it over the worktree afterwards
+	if e.EphemeralBuildDir {
+		if err := e.createCheckoutDir(); err != nil {
+			return err
+		}
+	}
+
+	e.shell.Commentf("Adding a worktree of the mirror at %q", checkoutPath)
+	if err := e.shell.Command("git", "--git-dir", mirrorDir, "worktree", "add", "--force", "--detach", checkoutPath, ref).Run(ctx); err != nil {
+		return fmt.Errorf("adding worktree for %q: %w", ref, err)
+	}
+	e.gitWorktreeMirror = mirrorDir
+
+	return e.createCheckoutDir()
+}
+
+// removeGitWorktree removes the worktree added by checkoutGitWorktree, if any.
+func (e *Executor) removeGitWorktree(ctx context.Context) error {
+	if e.gitWorktreeMirror == "" {
+		return nil
+	}
+	checkoutPath, _ := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
+
+	lock, err := e.lockMirror(ctx, e.gitWorktreeMirror, "update")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// If the worktree is already gone (e.g. it was in an ephemeral build
+	// directory), the mirror just needs to forget about it
+	if !osutil.FileExists(filepath.Join(checkoutPath, ".git")) {
+		if err := e.shell.Command("git", "--git-dir", e.gitWorktreeMirror, "worktree", "prune").Run(ctx); err != nil {
+			return fmt.Errorf("pruning worktrees: %w", err)
+		}
+		e.gitWorktreeMirror = ""
+		return nil
+	}
+
+	if err := e.shell.Command("git", "--git-dir", e.gitWorktreeMirror, "worktree", "remove", "--force", checkoutPath).Run(ctx); err != nil {
+		return fmt.Errorf("removing worktree %q: %w", checkoutPath, err)
+	}
+	e.gitWorktreeMirror = ""
+	return nil
+}
+
+// unmountEphemeralBuildDir unmounts the ephemeral build directory, if any,
+// discarding its contents.
+func (e *Executor) unmountEphemeralBuildDir() error {
+	if e.ephemeralMount == "" {
+		return nil
+	}
+
+	// Step out of the mount, so it isn't busy
+	if wd := e.shell.Getwd(); wd == e.ephemeralMount || strings.HasPrefix(wd, e.ephemeralMount+string(filepath.Separator)) {
+		if err := e.shell.Chdir(filepath.Dir(e.ephemeralMount)); err != nil {
+			return err
+		}
+	}
+
+	e.shell.Commentf("Discarding ephemeral build directory %q", e.ephemeralMount)
+	if err := unmountEphemeral(e.ephemeralMount); err != nil {
+		return err
+	}
+	e.ephemeralMount = ""
+	return nil
+}
+
 type ErrTimedOutAcquiringLock struct {
 	Name string
 	Err  error
@@ -494,7 +813,8 @@ func (e *Executor) getOrUpdateMirrorDir(ctx context.Context, repository string)
 }
 
 // defaultCheckoutPhase is called by the CheckoutPhase if no global or plugin checkout
-// hook exists. It performs the default checkout on the Repository provided in the config
+// hook exists and the VCS is git. It performs the default checkout on the Repository
+// provided in the config
 func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 	span, _ := tracetools.StartSpanFromContext(ctx, "repo-checkout", e.ExecutorConfig.TracingBackend)
 	span.AddAttributes(map[string]string{
@@ -509,6 +829,21 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 		addRepositoryHostToSSHKnownHosts(ctx, e.shell, e.Repository)
 	}
 
+	if e.GitLFSSkip {
+		// Stop the LFS filter (if installed globally) from downloading objects
+		// during clone and checkout. It's only for the checkout, so the
+		// environment is put back afterwards for the rest of the job.
+		prev, had := e.shell.Env.Get("GIT_LFS_SKIP_SMUDGE")
+		e.shell.Env.Set("GIT_LFS_SKIP_SMUDGE", "1")
+		defer func() {
+			if had {
+				e.shell.Env.Set("GIT_LFS_SKIP_SMUDGE", prev)
+			} else {
+				e.shell.Env.Remove("GIT_LFS_SKIP_SMUDGE")
+			}
+		}()
+	}
+
 	var mirrorDir string
 
 	// If we can, get a mirror of the git repository to use for reference later
@@ -522,100 +857,18 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 		e.shell.Env.Set("BUILDKITE_REPO_MIRROR", mirrorDir)
 	}
 
-	// Make sure the build directory exists and that we change directory into it
-	if err := e.createCheckoutDir(); err != nil {
-		return fmt.Errorf("creating checkout dir: %w", err)
-	}
-
-	gitCloneFlags := e.GitCloneFlags
-	if mirrorDir != "" {
-		gitCloneFlags += fmt.Sprintf(" --reference %q", mirrorDir)
-	}
-
-	// Does the git directory exist?
-	existingGitDir := filepath.Join(e.shell.Getwd(), ".git")
-	if osutil.FileExists(existingGitDir) {
-		// Update the origin of the repository so we can gracefully handle
-		// repository renames
-		if _, err := e.updateRemoteURL(ctx, "", e.Repository); err != nil {
-			return fmt.Errorf("setting origin: %w", err)
+	if e.GitMirrorsWorktree && mirrorDir != "" && e.RefSpec == "" {
+		if err := e.checkoutGitWorktree(ctx, mirrorDir); err != nil {
+			return fmt.Errorf("checking out git worktree: %w", err)
 		}
 	} else {
-		if err := gitClone(ctx, e.shell, gitCloneFlags, e.Repository, "."); err != nil {
-			return fmt.Errorf("cloning git repository: %w", err)
-		}
-	}
-
-	// Git clean prior to checkout, we do this even if submodules have been
-	// disabled to ensure previous submodules are cleaned up
-	if hasGitSubmodules(e.shell) {
-		if err := gitCleanSubmodules(ctx, e.shell, e.GitCleanFlags); err != nil {
-			return fmt.Errorf("cleaning git submodules: %w", err)
-		}
-	}
-
-	if err := gitClean(ctx, e.shell, e.GitCleanFlags); err != nil {
-		return fmt.Errorf("cleaning git repository: %w", err)
-	}
-
-	gitFetchFlags := e.GitFetchFlags
-
-	switch {
-	case e.RefSpec != "":
-		// If a refspec is provided then use it instead.
-		// For example, `refs/not/a/head`
-		e.shell.Commentf("Fetch and checkout custom refspec")
-		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", e.RefSpec); err != nil {
-			return fmt.Errorf("fetching refspec %q: %w", e.RefSpec, err)
-		}
-
-	case e.PullRequest != "false" && strings.Contains(e.PipelineProvider, "github"):
-		// GitHub has a special ref which lets us fetch a pull request head, whether
-		// or not it's a current head in this repository or a fork. See:
-		// https://help.github.com/articles/checking-out-pull-requests-locally/#modifying-an-inactive-pull-request-locally
-		e.shell.Commentf("Fetch and checkout pull request head from GitHub")
-		refspec := fmt.Sprintf("refs/pull/%s/head", e.PullRequest)
-
-		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", refspec); err != nil {
-			return fmt.Errorf("fetching PR refspec %q: %w", refspec, err)
-		}
-
-		gitFetchHead, _ := e.shell.Command("git", "rev-parse", "FETCH_HEAD").RunAndCaptureStdout(ctx)
-		e.shell.Commentf("FETCH_HEAD is now `%s`", gitFetchHead)
-
-		if e.Commit != "HEAD" {
-			// If we know the commit, also fetch it directly. The commit might not be in the history of `refspec` if there
-			// have been force pushes to the pull request, so this ensures we have it.
-			if err := gitFetchCommitWithFallback(ctx, e.shell, gitFetchFlags, e.Commit); err != nil {
-				return err
-			}
-		}
-
-	case e.Commit == "HEAD":
-		// If the commit is "HEAD" then we can't do a commit-specific fetch and will
-		// need to fetch the remote head and checkout the fetched head explicitly.
-		e.shell.Commentf("Fetch and checkout remote branch HEAD commit")
-		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", e.Branch); err != nil {
-			return fmt.Errorf("fetching branch %q: %w", e.Branch, err)
-		}
-
-	default:
-		// Otherwise fetch and checkout the commit directly.
-		if err := gitFetchCommitWithFallback(ctx, e.shell, gitFetchFlags, e.Commit); err != nil {
+		if err := e.cloneAndCheckout(ctx, mirrorDir); err != nil {
 			return err
 		}
 	}
 
-	gitCheckoutFlags := e.GitCheckoutFlags
-
-	if e.Commit == "HEAD" {
-		if err := gitCheckout(ctx, e.shell, gitCheckoutFlags, "FETCH_HEAD"); err != nil {
-			return fmt.Errorf("checking out FETCH_HEAD: %w", err)
-		}
-	} else {
-		if err := gitCheckout(ctx, e.shell, gitCheckoutFlags, e.Commit); err != nil {
-			return fmt.Errorf("checking out commit %q: %w", e.Commit, err)
-		}
+	if err := e.checkoutGitLFS(ctx); err != nil {
+		return err
 	}
 
 	gitSubmodules := false
@@ -650,11 +903,51 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 			args = append(args, "-c", config)
 		}
 
+		// Credentials go in the environment rather than -c, so that they're
+		// also used for cloning and updating mirrors of the submodules
+		credentialConfig, err := gitCredentialConfig(e.GitSubmoduleCredentials)
+		if err != nil {
+			return fmt.Errorf("configuring git submodule credentials: %w", err)
+		}
+		defer addGitConfigEnv(e.shell.Env, credentialConfig)()
+
+		cloneFlagRules, err := parseGitURLRules(e.GitSubmoduleCloneFlags)
+		if err != nil {
+			return fmt.Errorf("parsing git submodule clone flags: %w", err)
+		}
+
 		// Checking for submodule repositories
 		submoduleRepos, err := gitEnumerateSubmoduleURLs(ctx, e.shell)
 		if err != nil {
 			e.shell.Warningf("Failed to enumerate git submodules: %v", err)
 		} else {
+			// When some submodules have their own clone flags, each
+			// submodule is updated separately
+			var submodules []gitSubmodule
+			if len(cloneFlagRules) > 0 {
+				if submodules, err = gitEnumerateSubmodules(ctx, e.shell); err != nil {
+					return fmt.Errorf("enumerating git submodules: %w", err)
+				}
+			}
+			// updateScope returns the arguments that limit git submodule
+			// update to the submodules at the URL, with their clone flags.
+			updateScope := func(url string) ([]string, error) {
+				if len(submodules) == 0 {
+					return nil, nil
+				}
+				scope, err := gitSubmoduleCloneFlags(cloneFlagRules, url)
+				if err != nil {
+					return nil, fmt.Errorf("parsing git submodule clone flags for %s: %w", url, err)
+				}
+				scope = append(scope, "--")
+				for _, sm := range submodules {
+					if sm.url == url {
+						scope = append(scope, sm.path)
+					}
+				}
+				return scope, nil
+			}
+
 			mirrorSubmodules := e.ExecutorConfig.GitMirrorsPath != ""
 			for _, repository := range submoduleRepos {
 				submoduleArgs := append([]string(nil), args...)
@@ -693,6 +986,12 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 					submoduleArgs = append(submoduleArgs, "submodule", "update", "--init", "--recursive", "--force")
 				}
 
+				scope, err := updateScope(repository)
+				if err != nil {
+					return err
+				}
+				submoduleArgs = append(submoduleArgs, scope...)
+
 				if err := e.shell.Command("git", submoduleArgs...).Run(ctx); err != nil {
 					return fmt.Errorf("updating submodules: %w", err)
 				}
@@ -700,8 +999,21 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 
 			if !mirrorSubmodules {
 				args = append(args, "submodule", "update", "--init", "--recursive", "--force")
-				if err := e.shell.Command("git", args...).Run(ctx); err != nil {
-					return fmt.Errorf("updating submodules: %w", err)
+				if len(submodules) == 0 {
+					if err := e.shell.Command("git", args...).Run(ctx); err != nil {
+						return fmt.Errorf("updating submodules: %w", err)
+					}
+				}
+				for _, sm := range submodules {
+					flags, err := gitSubmoduleCloneFlags(cloneFlagRules, sm.url)
+					if err != nil {
+						return fmt.Errorf("parsing git submodule clone flags for %s: %w", sm.url, err)
+					}
+					submoduleArgs := append(slices.Clone(args), flags...)
+					submoduleArgs = append(submoduleArgs, "--", sm.path)
+					if err := e.shell.Command("git", submoduleArgs...).Run(ctx); err != nil {
+						return fmt.Errorf("updating submodule %s: %w", sm.path, err)
+					}
 				}
 			}
 
@@ -741,12 +1053,120 @@ func (e *Executor) defaultCheckoutPhase(ctx context.Context) error {
 	return nil
 }
 
+// cloneAndCheckout clones the repository into the checkout directory (or
+// updates an existing clone), using the mirror as a reference if there is one,
+// then fetches and checks out the commit.
+func (e *Executor) cloneAndCheckout(ctx context.Context, mirrorDir string) error {
+	// Make sure the build directory exists and that we change directory into it
+	if err := e.createCheckoutDir(); err != nil {
+		return fmt.Errorf("creating checkout dir: %w", err)
+	}
+
+	gitCloneFlags := e.GitCloneFlags
+	if mirrorDir != "" {
+		gitCloneFlags += fmt.Sprintf(" --reference %q", mirrorDir)
+	}
+
+	// Does the git directory exist?
+	existingGitDir := filepath.Join(e.shell.Getwd(), ".git")
+	if osutil.FileExists(existingGitDir) {
+		// Update the origin of the repository so we can gracefully handle
+		// repository renames
+		if _, err := e.updateRemoteURL(ctx, "", e.Repository); err != nil {
+			return fmt.Errorf("setting origin: %w", err)
+		}
+	} else {
+		if err := e.gitCloneWithBundle(ctx, e.shell, gitCloneFlags, e.Repository, "."); err != nil {
+			return fmt.Errorf("cloning git repository: %w", err)
+		}
+	}
+
+	// Git clean prior to checkout, we do this even if submodules have been
+	// disabled to ensure previous submodules are cleaned up
+	if hasGitSubmodules(e.shell) {
+		if err := gitCleanSubmodules(ctx, e.shell, e.GitCleanFlags); err != nil {
+			return fmt.Errorf("cleaning git submodules: %w", err)
+		}
+	}
+
+	if err := gitClean(ctx, e.shell, e.GitCleanFlags); err != nil {
+		return fmt.Errorf("cleaning git repository: %w", err)
+	}
+
+	gitFetchFlags := e.GitFetchFlags
+
+	switch {
+	case e.RefSpec != "":
+		// If a refspec is provided then use it instead.
+		// For example, `refs/not/a/head`
+		e.shell.Commentf("Fetch and checkout custom refspec")
+		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", e.RefSpec); err != nil {
+			return fmt.Errorf("fetching refspec %q: %w", e.RefSpec, err)
+		}
+
+	case e.PullRequest != "false" && strings.Contains(e.PipelineProvider, "github"):
+		// GitHub has a special ref which lets us fetch a pull request head, whether
+		// or not it's a current head in this repository or a fork. See:
+		// https://help.github.com/articles/checking-out-pull-requests-locally/#modifying-an-inactive-pull-request-locally
+		e.shell.Commentf("Fetch and checkout pull request head from GitHub")
+		refspec := fmt.Sprintf("refs/pull/%s/head", e.PullRequest)
+
+		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", refspec); err != nil {
+			return fmt.Errorf("fetching PR refspec %q: %w", refspec, err)
+		}
+
+		gitFetchHead, _ := e.shell.Command("git", "rev-parse", "FETCH_HEAD").RunAndCaptureStdout(ctx)
+		e.shell.Commentf("FETCH_HEAD is now `%s`", gitFetchHead)
+
+		if e.Commit != "HEAD" {
+			// If we know the commit, also fetch it directly. The commit might not be in the history of `refspec` if there
+			// have been force pushes to the pull request, so this ensures we have it.
+			if err := gitFetchCommitWithFallback(ctx, e.shell, gitFetchFlags, e.Commit); err != nil {
+				return err
+			}
+		}
+
+	case e.Commit == "HEAD":
+		// If the commit is "HEAD" then we can't do a commit-specific fetch and will
+		// need to fetch the remote head and checkout the fetched head explicitly.
+		e.shell.Commentf("Fetch and checkout remote branch HEAD commit")
+		if err := gitFetch(ctx, e.shell, gitFetchFlags, "origin", e.Branch); err != nil {
+			return fmt.Errorf("fetching branch %q: %w", e.Branch, err)
+		}
+
+	default:
+		// Otherwise fetch and checkout the commit directly.
+		if err := gitFetchCommitWithFallback(ctx, e.shell, gitFetchFlags, e.Commit); err != nil {
+			return err
+		}
+	}
+
+	gitCheckoutFlags := e.GitCheckoutFlags
+
+	if e.Commit == "HEAD" {
+		if err := gitCheckout(ctx, e.shell, gitCheckoutFlags, "FETCH_HEAD"); err != nil {
+			return fmt.Errorf("checking out FETCH_HEAD: %w", err)
+		}
+	} else {
+		if err := gitCheckout(ctx, e.shell, gitCheckoutFlags, e.Commit); err != nil {
+			return fmt.Errorf("checking out commit %q: %w", e.Commit, err)
+		}
+	}
+
+	return nil
+}
+
 func gitFetchCommitWithFallback(ctx context.Context, shell *shell.Shell, gitFetchFlags, commit string) error {
 	err := gitFetch(ctx, shell, gitFetchFlags, "origin", commit)
 	if err == nil {
 		return nil // it worked
 	}
 	if gerr := new(gitError); errors.As(err, &gerr) {
+		// if we couldn't talk to the remote, fetching everything won't help
+		if gerr.Failure != gitFailureUnknown {
+			return fmt.Errorf("fetching commit %q: %w", commit, err)
+		}
+
 		// if we fail in a way that means the repository is corrupt, we should bail
 		switch gerr.Type {
 		case gitErrorFetchRetryClean, gitErrorFetchBadObject:
@@ -786,43 +1206,33 @@ const CommitMetadataKey = "buildkite:git:commit"
 // to buildkite, which uses this info to display commit info in the UI eg in the title for the build
 // note that we bail early if the key already exists, as we don't want to overwrite it
 func (e *Executor) sendCommitToBuildkite(ctx context.Context) error {
-	e.shell.Commentf("Checking to see if git commit information needs to be sent to Buildkite...")
-	cmd := e.shell.Command("buildkite-agent", "meta-data", "exists", CommitMetadataKey)
+	v, err := e.vcs()
+	if err != nil {
+		return err
+	}
+
+	e.shell.Commentf("Checking to see if commit information needs to be sent to Buildkite...")
+	cmd := e.shell.Command("buildkite-agent", "meta-data", "exists", "--scope", "build", CommitMetadataKey)
 	if err := cmd.Run(ctx); err == nil {
 		// Command exited 0, ie the key exists, so we don't need to send it again
-		e.shell.Commentf("Git commit information has already been sent to Buildkite")
+		e.shell.Commentf("Commit information has already been sent to Buildkite")
 		return nil
 	}
 
-	e.shell.Commentf("Sending Git commit information back to Buildkite")
-	// Format:
-	//
-	// commit 0123456789abcdef0123456789abcdef01234567
-	// abbrev-commit 0123456789
-	// Author: John Citizen <john@example.com>
-	//
-	//    Subject of the commit message
-	//
-	//    Body of the commit message, which
-	//    may span multiple lines.
-	gitArgs := []string{
-		"--no-pager",
-		"log",
-		"-1",
-		e.Commit,
-		"-s", // --no-patch was introduced in v1.8.4 in 2013, but e.g. CentOS 7 isn't there yet
-		"--no-color",
-		"--format=commit %H%nabbrev-commit %h%nAuthor: %an <%ae>%n%n%w(0,4,4)%B",
-	}
-	out, err := e.shell.Command("git", gitArgs...).RunAndCaptureStdout(ctx)
+	out, err := v.commitInfo(ctx)
 	if err != nil {
-		return fmt.Errorf("getting git commit information: %w", err)
+		return err
+	}
+	if out == "" {
+		e.shell.Commentf("There's no commit information to send to Buildkite")
+		return nil
 	}
 
+	e.shell.Commentf("Sending commit information back to Buildkite")
 	stdin := strings.NewReader(out)
-	cmd = e.shell.CloneWithStdin(stdin).Command("buildkite-agent", "meta-data", "set", CommitMetadataKey)
+	cmd = e.shell.CloneWithStdin(stdin).Command("buildkite-agent", "meta-data", "set", "--scope", "build", CommitMetadataKey)
 	if err := cmd.Run(ctx); err != nil {
-		return fmt.Errorf("sending git commit information to Buildkite: %w", err)
+		return fmt.Errorf("sending commit information to Buildkite: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,55 @@
+package job
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/internal/redact"
+	"golang.org/x/exp/maps"
+)
+
+// commandEnvFilter returns which env vars to pass to the command phase, based
+// on the CommandEnvAllow and CommandEnvDeny patterns, or nil if every variable
+// is passed. Hooks aren't filtered, so they can still use variables (like the
+// agent access token) that the command can't.
+func (e *Executor) commandEnvFilter() func(name string) bool {
+	if len(e.CommandEnvAllow) == 0 && len(e.CommandEnvDeny) == 0 {
+		return nil
+	}
+
+	// Report bad patterns once, rather than for every variable.
+	for _, patterns := range [][]string{e.CommandEnvAllow, e.CommandEnvDeny} {
+		if _, err := redact.MatchAny(patterns, ""); err != nil {
+			e.shell.Warningf("Some command env patterns couldn't be used: %v", err)
+		}
+	}
+
+	keep := func(name string) bool {
+		return commandEnvKept(e.CommandEnvAllow, e.CommandEnvDeny, name)
+	}
+
+	var removed []string
+	for _, name := range maps.Keys(e.shell.Env.Dump()) {
+		if !keep(name) {
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) > 0 {
+		slices.Sort(removed)
+		e.shell.Commentf("Removing environment variables from the command: %s", strings.Join(removed, ", "))
+	}
+	return keep
+}
+
+// commandEnvKept reports whether the env var name should be passed to the
+// command phase. If there are allow patterns, name must match one of them, and
+// it mustn't match any deny pattern.
+func commandEnvKept(allow, deny []string, name string) bool {
+	if len(allow) > 0 {
+		if matched, _ := redact.MatchAny(allow, name); !matched {
+			return false
+		}
+	}
+	matched, _ := redact.MatchAny(deny, name)
+	return !matched
+}
@@ -0,0 +1,63 @@
+package job
+
+import "testing"
+
+func TestCommandEnvKept(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		want        map[string]bool
+	}{
+		{
+			name: "deny only",
+			deny: []string{"BUILDKITE_AGENT_ACCESS_TOKEN", "*_SECRET"},
+			want: map[string]bool{
+				"BUILDKITE_AGENT_ACCESS_TOKEN": false,
+				"DATABASE_SECRET":              false,
+				"BUILDKITE_JOB_ID":             true,
+				"PATH":                         true,
+			},
+		},
+		{
+			name:  "allow only",
+			allow: []string{"BUILDKITE_*", "PATH"},
+			want: map[string]bool{
+				"BUILDKITE_AGENT_ACCESS_TOKEN": true,
+				"BUILDKITE_JOB_ID":             true,
+				"PATH":                         true,
+				"HOME":                         false,
+			},
+		},
+		{
+			name:  "deny wins over allow",
+			allow: []string{"BUILDKITE_*"},
+			deny:  []string{"BUILDKITE_AGENT_ACCESS_TOKEN"},
+			want: map[string]bool{
+				"BUILDKITE_AGENT_ACCESS_TOKEN": false,
+				"BUILDKITE_JOB_ID":             true,
+				"HOME":                         false,
+			},
+		},
+		{
+			name: "bad patterns are ignored",
+			deny: []string{"[", "TOKEN"},
+			want: map[string]bool{
+				"TOKEN": false,
+				"[":     true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			for name, want := range test.want {
+				if got := commandEnvKept(test.allow, test.deny, name); got != want {
+					t.Errorf("commandEnvKept(%q, %q, %q) = %t, want %t", test.allow, test.deny, name, got, want)
+				}
+			}
+		})
+	}
+}
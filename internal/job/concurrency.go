@@ -0,0 +1,55 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent/v3/lock"
+)
+
+// localConcurrencyGroupLockPrefix is prefixed to the local concurrency group
+// to make the key of the lock held while the command phase runs.
+const localConcurrencyGroupLockPrefix = "buildkite-local-concurrency-group:"
+
+// How long releasing the local concurrency group lock may take. The job may
+// have been cancelled by then, so this doesn't use the job's context.
+const localConcurrencyGroupReleaseTimeout = 10 * time.Second
+
+// acquireLocalConcurrencyGroup waits until no other job on this host is in the
+// same BUILDKITE_LOCAL_CONCURRENCY_GROUP, using a lock held by the agent API
+// leader. The returned func releases the group. If the job isn't in a group,
+// it does nothing.
+//
+// The lock is held with the job's token, so that if the job executor exits
+// without releasing it, such as when it's killed, the agent running the job
+// releases it when the job finishes.
+func (e *Executor) acquireLocalConcurrencyGroup(ctx context.Context) (release func(), err error) {
+	group, _ := e.shell.Env.Get("BUILDKITE_LOCAL_CONCURRENCY_GROUP")
+	if group == "" {
+		return func() {}, nil
+	}
+
+	e.shell.Headerf("Waiting for local concurrency group %q", group)
+
+	client, err := lock.NewClient(ctx, e.SocketsPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to the agent API for local concurrency group %q (is the agent-api experiment enabled?): %w", group, err)
+	}
+
+	key := localConcurrencyGroupLockPrefix + group
+	start := time.Now()
+	token := lock.JobToken(e.JobID)
+	if err := client.LockAs(ctx, key, token, 0); err != nil {
+		return nil, fmt.Errorf("couldn't acquire local concurrency group %q: %w", group, err)
+	}
+	e.shell.Commentf("Acquired local concurrency group %q after %v", group, time.Since(start).Round(time.Millisecond))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), localConcurrencyGroupReleaseTimeout)
+		defer cancel()
+		if err := client.Unlock(ctx, key, token); err != nil {
+			e.shell.Warningf("Couldn't release local concurrency group %q: %v", group, err)
+		}
+	}, nil
+}
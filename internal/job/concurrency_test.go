@@ -0,0 +1,78 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/agentapi"
+	"github.com/buildkite/agent/v3/internal/shell"
+	"github.com/buildkite/agent/v3/logger"
+)
+
+func TestAcquireLocalConcurrencyGroup(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	// Socket paths are limited in length, so don't use t.TempDir.
+	socketsPath, err := os.MkdirTemp("", "lcg")
+	if err != nil {
+		t.Fatalf("os.MkdirTemp() error = %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketsPath) }) //nolint:errcheck // Best-effort cleanup
+
+	svr, err := agentapi.NewServer(agentapi.LeaderPath(socketsPath), logger.Discard, nil)
+	if err != nil {
+		t.Fatalf("agentapi.NewServer() error = %v", err)
+	}
+	if err := svr.Start(); err != nil {
+		t.Fatalf("svr.Start() error = %v", err)
+	}
+	t.Cleanup(func() { svr.Close() }) //nolint:errcheck // Best-effort cleanup
+
+	newExecutor := func() *Executor {
+		e := New(ExecutorConfig{SocketsPath: socketsPath})
+		e.shell = shell.NewTestShell(t)
+		e.shell.Env.Set("BUILDKITE_LOCAL_CONCURRENCY_GROUP", "gpu")
+		return e
+	}
+
+	first, second := newExecutor(), newExecutor()
+
+	release, err := first.acquireLocalConcurrencyGroup(ctx)
+	if err != nil {
+		t.Fatalf("first.acquireLocalConcurrencyGroup() error = %v", err)
+	}
+
+	// The second job waits while the first holds the group.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer waitCancel()
+	if _, err := second.acquireLocalConcurrencyGroup(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second.acquireLocalConcurrencyGroup() while held error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	release()
+
+	release, err = second.acquireLocalConcurrencyGroup(ctx)
+	if err != nil {
+		t.Fatalf("second.acquireLocalConcurrencyGroup() after release error = %v", err)
+	}
+	release()
+}
+
+func TestAcquireLocalConcurrencyGroup_NoGroup(t *testing.T) {
+	t.Parallel()
+
+	// Without a group, the agent API isn't needed.
+	e := New(ExecutorConfig{SocketsPath: "/does/not/exist"})
+	e.shell = shell.NewTestShell(t)
+
+	release, err := e.acquireLocalConcurrencyGroup(context.Background())
+	if err != nil {
+		t.Fatalf("e.acquireLocalConcurrencyGroup() error = %v", err)
+	}
+	release()
+}
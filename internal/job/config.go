@@ -33,6 +33,13 @@ type ExecutorConfig struct {
 	// The repository that needs to be cloned
 	Repository string `env:"BUILDKITE_REPO"`
 
+	// The version control system used to check out the repository when
+	// there's no checkout hook. Empty means git
+	VCS string `env:"BUILDKITE_VCS"`
+
+	// Patterns of repositories that are allowed to be checked out
+	AllowedRepositories []string
+
 	// The commit being built
 	Commit string
 
@@ -51,6 +58,9 @@ type ExecutorConfig struct {
 	// Should git submodules be checked out
 	GitSubmodules bool
 
+	// Should fetching and checking out Git LFS objects be skipped
+	GitLFSSkip bool `env:"BUILDKITE_GIT_LFS_SKIP"`
+
 	// If the commit was part of a pull request, this will container the PR number
 	PullRequest string
 
@@ -72,12 +82,20 @@ type ExecutorConfig struct {
 	// Should the executor remove an existing checkout before running the job
 	CleanCheckout bool `env:"BUILDKITE_CLEAN_CHECKOUT"`
 
+	// Should the checkout be skipped entirely. The checkout directory is still
+	// created, so that later phases have a working directory
+	SkipCheckout bool `env:"BUILDKITE_SKIP_CHECKOUT"`
+
 	// Flags to pass to "git checkout" command
 	GitCheckoutFlags string `env:"BUILDKITE_GIT_CHECKOUT_FLAGS"`
 
 	// Flags to pass to "git clone" command
 	GitCloneFlags string `env:"BUILDKITE_GIT_CLONE_FLAGS"`
 
+	// A bundle URI, or the path to a bundle file, to seed new clones of the
+	// repository from before fetching the rest from the repository itself
+	GitCloneBundle string `env:"BUILDKITE_GIT_CLONE_BUNDLE"`
+
 	// Flags to pass to "git fetch" command
 	GitFetchFlags string `env:"BUILDKITE_GIT_FETCH_FLAGS"`
 
@@ -90,18 +108,79 @@ type ExecutorConfig struct {
 	// Config key=value pairs to pass to "git" when submodule init commands are invoked
 	GitSubmoduleCloneConfig []string `env:"BUILDKITE_GIT_SUBMODULE_CLONE_CONFIG" normalize:"list"`
 
+	// Flags to pass to "git submodule update" for submodules, as
+	// <url prefix>=<flags> rules. The first matching rule applies
+	GitSubmoduleCloneFlags []string `env:"BUILDKITE_GIT_SUBMODULE_CLONE_FLAGS" normalize:"list"`
+
+	// Tokens to use for submodules, as <url>=[<username>:]<env var> rules
+	GitSubmoduleCredentials []string `env:"BUILDKITE_GIT_SUBMODULE_CREDENTIALS" normalize:"list"`
+
 	// Whether or not to run the hooks/commands in a PTY
 	RunInPty bool
 
+	// The size of the PTY, or 0 for the default size
+	PTYRows uint16
+	PTYCols uint16
+
+	// The format of job output: "text", or "json" for JSON lines
+	JobLogFormat string
+
 	// Are arbitrary commands allowed to be executed
 	CommandEval bool
 
+	// The sandbox to run the command phase in ("bwrap"), or empty for none
+	CommandSandbox string
+
+	// Paths the command sandbox can access even if they would otherwise be
+	// hidden
+	CommandSandboxAllowPaths []string
+
+	// Patterns of env var names to pass to the command phase. If empty, all
+	// env vars are passed, except those matching CommandEnvDeny
+	CommandEnvAllow []string
+
+	// Patterns of env var names not to pass to the command phase
+	CommandEnvDeny []string
+
+	// How many times to retry the command if it fails, without retrying the
+	// whole job
+	CommandRetryAttempts int
+
+	// The exit statuses of the command that are retried. If empty, any
+	// non-zero exit status is retried
+	CommandRetryExitStatuses []int
+
+	// The exit statuses of the command that mark the step as soft failed
+	SoftFailExitStatuses []int
+
+	// A program that's run when the command fails, and exits with 0 if the
+	// step should be marked as soft failed
+	SoftFailClassifier string
+
+	// Patterns of env var names that the pipeline and hooks can't override
+	ProtectedEnv []string
+
+	// Fail the job, rather than warning, when something tries to override a
+	// protected env var
+	ProtectedEnvEnforce bool
+
 	// Are plugins enabled?
 	PluginsEnabled bool
 
 	// Should we always force a fresh clone of plugins, even if we have a local checkout?
 	PluginsAlwaysCloneFresh bool `env:"BUILDKITE_PLUGINS_ALWAYS_CLONE_FRESH"`
 
+	// Path to a file recording the commit each plugin version resolved to
+	PluginLockFile string
+
+	// Whether plugin versions may resolve to a different commit than the one
+	// recorded in the plugin lock file
+	PluginsAllowDrift bool
+
+	// Patterns of plugins that are allowed to be used, each optionally followed
+	// by @<sha> to require matching plugins to resolve to that SHA
+	AllowedPlugins []string
+
 	// Whether to validate plugin configuration
 	PluginValidation bool
 
@@ -111,6 +190,10 @@ type ExecutorConfig struct {
 	// Should we enforce that only one checkout and one command hook are run?
 	StrictSingleHooks bool
 
+	// Interpreters for script hooks on Windows, and for hooks without a
+	// shebang elsewhere, by lowercase file extension
+	HookInterpreters map[string]string
+
 	// Path where the builds will be run
 	BuildPath string
 
@@ -123,9 +206,22 @@ type ExecutorConfig struct {
 	// Seconds to wait before allowing git mirror clone lock to be acquired
 	GitMirrorsLockTimeout int
 
+	// How many times to try the checkout before giving up
+	CheckoutRetryAttempts int
+
+	// How long to wait before retrying the checkout. Checkouts that fail
+	// because of network or server problems back off exponentially from this.
+	CheckoutRetryBackoff time.Duration
+
 	// Skip updating the Git mirror before using it
 	GitMirrorsSkipUpdate bool `env:"BUILDKITE_GIT_MIRRORS_SKIP_UPDATE"`
 
+	// Check out the job as a worktree of the Git mirror, rather than cloning
+	GitMirrorsWorktree bool `env:"BUILDKITE_GIT_MIRRORS_WORKTREE"`
+
+	// Mount a tmpfs over the checkout directory, discarded at the end of the job
+	EphemeralBuildDir bool
+
 	// Path to the buildkite-agent binary
 	BinPath string
 
@@ -153,12 +249,26 @@ type ExecutorConfig struct {
 	// Phases to execute, defaults to all phases
 	Phases []string
 
+	// How to report the duration of each phase once the job finishes
+	// ("meta-data" or "annotation"), or empty to not report them
+	PhaseTimings string `env:"BUILDKITE_PHASE_TIMINGS"`
+
+	// Whether to upload a bundle of diagnostics (processes, disk usage, recent
+	// system logs and the redacted environment) as artifacts if the job is
+	// cancelled, times out, or has a hook time out
+	DiagnosticsBundle bool `env:"BUILDKITE_DIAGNOSTICS_BUNDLE"`
+
 	// What signal to use for command cancellation
 	CancelSignal process.Signal
 
 	// Amount of time to wait between sending the CancelSignal and SIGKILL to the process groups
 	// that the executor starts. The subprocesses should use this time to clean up after themselves.
-	SignalGracePeriod time.Duration
+	// Hooks can shorten it, in whole seconds, but not lengthen it.
+	SignalGracePeriod time.Duration `env:"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"`
+
+	// Maximum time each hook (other than command hooks) may run for, or 0 for
+	// no limit. BUILDKITE_HOOK_TIMEOUT_<HOOK> can shorten it for a single hook.
+	HookTimeout time.Duration
 
 	// List of environment variable globs to redact from job output
 	RedactedVars []string
@@ -169,6 +279,10 @@ type ExecutorConfig struct {
 	// Service name to use when reporting traces.
 	TracingServiceName string
 
+	// Maximum number of bytes of each hook's stdout and stderr to record on
+	// the hook's span, or 0 to not record hook output.
+	TracingHookOutputLimit int
+
 	// Encoding (within base64) for the trace context environment variable.
 	TraceContextCodec tracetools.Codec
 
@@ -179,10 +293,27 @@ type ExecutorConfig struct {
 	KubernetesExec        bool
 	KubernetesContainerID int
 
+	// Prefix for each line of this container's output in the job log
+	KubernetesLogPrefix string
+
 	// The warnings that have been disabled by the user
 	DisabledWarnings []string
 }
 
+// ConfigEnvVars returns the names of the environment variables that
+// ReadFromEnvironment reads, which hooks can change to reconfigure the
+// executor at run time.
+func ConfigEnvVars() []string {
+	var names []string
+	fields := reflect.TypeOf(ExecutorConfig{})
+	for i := range fields.NumField() {
+		if tag := fields.Field(i).Tag.Get("env"); tag != "" {
+			names = append(names, tag)
+		}
+	}
+	return names
+}
+
 // ReadFromEnvironment reads configuration from the Environment, returns a map
 // of the env keys that changed and the new values
 func (c *ExecutorConfig) ReadFromEnvironment(environ *env.Environment) map[string]string {
@@ -219,6 +350,28 @@ func (c *ExecutorConfig) ReadFromEnvironment(environ *env.Environment) map[strin
 				}
 				v.SetBool(newBool)
 				changed[tag] = newStr
+			case reflect.Int64:
+				if v.Type() != reflect.TypeOf(time.Duration(0)) {
+					log.Printf("warning: job.ExecutorConfig.ReadFromEnvironment does not support %v for %s", v.Type(), tag)
+					break
+				}
+				// Durations are given in whole seconds, and can only be
+				// shortened, so a job can't outlast the agent's limits
+				seconds, err := strconv.Atoi(newStr)
+				if err != nil || seconds < 0 {
+					log.Printf("warning: cannot parse %s=%s as a number of seconds, ignoring", tag, newStr)
+					break
+				}
+				newDuration := time.Duration(seconds) * time.Second
+				if newDuration == time.Duration(v.Int()) {
+					break
+				}
+				if newDuration > time.Duration(v.Int()) {
+					log.Printf("warning: %s=%s is longer than the current %v, ignoring", tag, newStr, time.Duration(v.Int()))
+					break
+				}
+				v.SetInt(int64(newDuration))
+				changed[tag] = newStr
 			default:
 				log.Printf("warning: job.ExecutorConfig.ReadFromEnvironment does not support %v for %s", v.Kind(), tag)
 			}
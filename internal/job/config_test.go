@@ -2,6 +2,7 @@ package job
 
 import (
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/env"
 	"github.com/google/go-cmp/cmp"
@@ -80,3 +81,30 @@ func TestReadFromEnvironmentIgnoresMalformedBooleans(t *testing.T) {
 		t.Errorf("config.PluginsAlwaysCloneFresh = %t, want %t", got, want)
 	}
 }
+
+func TestReadFromEnvironmentOnlyShortensSignalGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	config := &ExecutorConfig{SignalGracePeriod: 9 * time.Second}
+
+	changes := config.ReadFromEnvironment(env.FromSlice([]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS=60"}))
+	if len(changes) != 0 {
+		t.Errorf("changes = %v, want none", changes)
+	}
+	if got, want := config.SignalGracePeriod, 9*time.Second; got != want {
+		t.Errorf("config.SignalGracePeriod = %v, want %v", got, want)
+	}
+
+	changes = config.ReadFromEnvironment(env.FromSlice([]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS=nope"}))
+	if len(changes) != 0 {
+		t.Errorf("changes = %v, want none", changes)
+	}
+
+	changes = config.ReadFromEnvironment(env.FromSlice([]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS=3"}))
+	if diff := cmp.Diff(changes, map[string]string{"BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS": "3"}); diff != "" {
+		t.Errorf("config.ReadFromEnvironment(environ) diff (-got +want):\n%s", diff)
+	}
+	if got, want := config.SignalGracePeriod, 3*time.Second; got != want {
+		t.Errorf("config.SignalGracePeriod = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// commandContainerRuntimes are the container runtimes that can run the
+// command phase when BUILDKITE_COMMAND_CONTAINER is set, in order of
+// preference. Podman's CLI is compatible with Docker's for everything we use.
+var commandContainerRuntimes = []string{"docker", "podman"}
+
+// hostOnlyEnv are environment variables that describe the host rather than
+// the job, and so aren't passed through to the command container.
+var hostOnlyEnv = []string{
+	"HOME",
+	"HOSTNAME",
+	"LOGNAME",
+	"OLDPWD",
+	"PATH",
+	"PWD",
+	"SHELL",
+	"TMPDIR",
+	"USER",
+}
+
+// commandContainer is a container started to run the command, which is
+// removed during tear down.
+type commandContainer struct {
+	runtime string
+	name    string
+}
+
+// containerCommand returns the command that runs cmd inside a container
+// created from image, with the checkout mounted at the same path and the job
+// environment passed through. Each container gets its own name, so that the
+// command can be run again (or a previous run of the job left behind) without
+// the names conflicting. The containers are removed during tear down.
+func (e *Executor) containerCommand(image string, cmd []string) ([]string, error) {
+	if e.CommandSandbox != "" {
+		return nil, fmt.Errorf("BUILDKITE_COMMAND_CONTAINER can't be combined with the %q command sandbox", e.CommandSandbox)
+	}
+
+	runtime, err := e.commandContainerRuntime()
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("generating command container name: %w", err)
+	}
+	jobID, _ := e.shell.Env.Get("BUILDKITE_JOB_ID")
+	name := fmt.Sprintf("buildkite_%s_command_%x", jobID, suffix)
+
+	// Only the names are passed, so that the values are taken from the
+	// runtime's own environment and don't appear in process listings.
+	var envNames []string
+	for k := range e.shell.Env.Dump() {
+		if !slices.Contains(hostOnlyEnv, k) {
+			envNames = append(envNames, k)
+		}
+	}
+	slices.Sort(envNames)
+
+	e.commandContainers = append(e.commandContainers, commandContainer{runtime: runtime, name: name})
+
+	args := containerRunArgs(name, image, e.shell.Getwd(), envNames)
+	return append(append([]string{runtime}, args...), cmd...), nil
+}
+
+// commandContainerRuntime returns the path to the container runtime to use,
+// which is either BUILDKITE_COMMAND_CONTAINER_RUNTIME or the first runtime
+// that's installed.
+func (e *Executor) commandContainerRuntime() (string, error) {
+	if runtime, ok := e.shell.Env.Get("BUILDKITE_COMMAND_CONTAINER_RUNTIME"); ok && runtime != "" {
+		if !slices.Contains(commandContainerRuntimes, runtime) {
+			return "", fmt.Errorf("invalid BUILDKITE_COMMAND_CONTAINER_RUNTIME %q. Only %s are allowed.", runtime, strings.Join(commandContainerRuntimes, " or "))
+		}
+		path, err := e.shell.AbsolutePath(runtime)
+		if err != nil {
+			return "", fmt.Errorf("BUILDKITE_COMMAND_CONTAINER_RUNTIME is %q, but it isn't installed: %w", runtime, err)
+		}
+		return path, nil
+	}
+
+	for _, runtime := range commandContainerRuntimes {
+		if path, err := e.shell.AbsolutePath(runtime); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("BUILDKITE_COMMAND_CONTAINER requires %s to be installed", strings.Join(commandContainerRuntimes, " or "))
+}
+
+// containerRunArgs returns the arguments to `docker run` (or `podman run`)
+// that run a command in a container created from image, with workDir mounted
+// and used as the working directory, and the named environment variables
+// passed through.
+func containerRunArgs(name, image, workDir string, envNames []string) []string {
+	args := []string{
+		"run",
+		"--name", name,
+		"--init",
+		"--volume", workDir + ":" + workDir,
+		"--workdir", workDir,
+	}
+	for _, envName := range envNames {
+		args = append(args, "--env", envName)
+	}
+	return append(args, image)
+}
+
+// removeCommandContainers removes the containers that ran the command, if
+// there were any.
+func (e *Executor) removeCommandContainers(ctx context.Context) error {
+	if len(e.commandContainers) == 0 {
+		return nil
+	}
+	e.shell.Printf("~~~ Cleaning up command container")
+	var errs []error
+	for _, c := range e.commandContainers {
+		if err := e.shell.Command(c.runtime, "rm", "--force", "--volumes", c.name).Run(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
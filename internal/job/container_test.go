@@ -0,0 +1,26 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestContainerRunArgs(t *testing.T) {
+	t.Parallel()
+
+	got := containerRunArgs("buildkite_1234_command_0a1b2c3d", "alpine:3", "/builds/pipeline", []string{"BUILDKITE", "BUILDKITE_JOB_ID"})
+	want := []string{
+		"run",
+		"--name", "buildkite_1234_command_0a1b2c3d",
+		"--init",
+		"--volume", "/builds/pipeline:/builds/pipeline",
+		"--workdir", "/builds/pipeline",
+		"--env", "BUILDKITE",
+		"--env", "BUILDKITE_JOB_ID",
+		"alpine:3",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("containerRunArgs diff (-got +want):\n%s", diff)
+	}
+}
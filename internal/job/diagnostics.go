@@ -0,0 +1,236 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/shell"
+)
+
+// diagnosticsBundleDir is the directory the diagnostics bundle is uploaded
+// from, so it prefixes the artifact path of each file in the bundle.
+const diagnosticsBundleDir = "buildkite-diagnostics"
+
+// How long collecting and uploading the diagnostics bundle may take, in total.
+// It's collected after the pre-exit hooks, within what's left of the grace
+// period, so this keeps it from holding up the rest of the teardown.
+const diagnosticsBundleTimeout = 30 * time.Second
+
+// How long each command in the diagnostics bundle may run for. The bundle is
+// collected during the signal grace period, so it can't take long.
+const diagnosticsCommandTimeout = 5 * time.Second
+
+// diagnosticsCommand is a command whose output is saved to a file in the
+// diagnostics bundle.
+type diagnosticsCommand struct {
+	file string
+	name string
+	args []string
+}
+
+// diagnosticsCommands returns the commands to collect process, disk, and
+// recent system log information on goos.
+func diagnosticsCommands(goos string) []diagnosticsCommand {
+	switch goos {
+	case "windows":
+		return []diagnosticsCommand{
+			{file: "processes.txt", name: "tasklist", args: []string{"/v"}},
+			{file: "disk.txt", name: "powershell", args: []string{"-NoProfile", "-Command", "Get-PSDrive -PSProvider FileSystem | Format-Table -AutoSize"}},
+			{file: "system-log.txt", name: "powershell", args: []string{"-NoProfile", "-Command", "Get-EventLog -LogName System -Newest 200 | Format-List"}},
+		}
+	case "linux":
+		return []diagnosticsCommand{
+			{file: "processes.txt", name: "ps", args: []string{"auxww"}},
+			{file: "disk.txt", name: "df", args: []string{"-h"}},
+			{file: "system-log.txt", name: "journalctl", args: []string{"--no-pager", "-n", "200"}},
+		}
+	case "darwin":
+		return []diagnosticsCommand{
+			{file: "processes.txt", name: "ps", args: []string{"auxww"}},
+			{file: "disk.txt", name: "df", args: []string{"-h"}},
+			{file: "system-log.txt", name: "log", args: []string{"show", "--last", "2m", "--style", "compact"}},
+		}
+	default:
+		return []diagnosticsCommand{
+			{file: "processes.txt", name: "ps", args: []string{"auxww"}},
+			{file: "disk.txt", name: "df", args: []string{"-h"}},
+		}
+	}
+}
+
+// diagnosticsReason returns why a diagnostics bundle should be uploaded for the
+// job, or "" if it shouldn't.
+func (e *Executor) diagnosticsReason() string {
+	e.cancelMu.Lock()
+	cancelled := e.cancelled
+	e.cancelMu.Unlock()
+
+	switch {
+	case cancelled:
+		return "the job was cancelled or timed out"
+	case e.hookTimedOut.Load():
+		return "a hook timed out"
+	default:
+		return ""
+	}
+}
+
+// uploadDiagnosticsBundle collects a diagnostics bundle describing the state of
+// the host, and uploads it as artifacts under diagnosticsBundleDir. phase is the
+// phase the job was in when it was stopped.
+func (e *Executor) uploadDiagnosticsBundle(ctx context.Context, reason, phase string) error {
+	e.shell.Headerf("Uploading diagnostics bundle")
+	e.shell.Commentf("Collecting diagnostics because %s", reason)
+
+	tempDir, err := os.MkdirTemp("", "buildkite-diagnostics-")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir) //nolint:errcheck // Best-effort cleanup
+
+	dir := filepath.Join(tempDir, diagnosticsBundleDir)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return fmt.Errorf("creating diagnostics directory: %w", err)
+	}
+	if err := e.writeDiagnosticsBundle(ctx, dir, reason, phase); err != nil {
+		return err
+	}
+
+	args := []string{"artifact", "upload", diagnosticsBundleDir + "/*"}
+
+	// If blank, the upload destination is buildkite
+	if e.ArtifactUploadDestination != "" {
+		args = append(args, e.ArtifactUploadDestination)
+	}
+
+	// Artifact paths are relative to the working directory, so upload from
+	// the temporary directory (in a copy of the shell, to leave the job's
+	// working directory alone).
+	sh := e.shell.CloneWithStdin(nil)
+	if err := sh.Chdir(tempDir); err != nil {
+		return err
+	}
+	if err := sh.Command("buildkite-agent", args...).Run(ctx); err != nil {
+		return fmt.Errorf("uploading diagnostics bundle: %w", err)
+	}
+	return nil
+}
+
+// writeDiagnosticsBundle writes the files of the diagnostics bundle to dir.
+// Everything written is redacted the same way as the job log.
+func (e *Executor) writeDiagnosticsBundle(ctx context.Context, dir, reason, phase string) error {
+	if err := e.writeDiagnosticsFile(dir, "summary.txt", func(w io.Writer) error {
+		return e.writeDiagnosticsSummary(w, reason, phase, time.Now())
+	}); err != nil {
+		return err
+	}
+
+	if err := e.writeDiagnosticsFile(dir, "env.txt", func(w io.Writer) error {
+		return writeRedactedEnv(w, e.shell.Env, e.RedactedVars)
+	}); err != nil {
+		return err
+	}
+
+	for _, c := range diagnosticsCommands(runtime.GOOS) {
+		if err := e.writeDiagnosticsFile(dir, c.file, func(w io.Writer) error {
+			_, err := io.WriteString(w, e.runDiagnosticsCommand(ctx, c))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiagnosticsFile creates the named file in dir, and redacts everything
+// write writes to it.
+func (e *Executor) writeDiagnosticsFile(dir, name string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", name, err)
+	}
+	defer f.Close() //nolint:errcheck // Closed again below to check the error
+
+	var needles []string
+	if e.redactors != nil {
+		needles = e.redactors.Needles()
+	}
+	redactor := replacer.New(f, needles, redact.Redact)
+	if err := write(redactor); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := redactor.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// writeDiagnosticsSummary describes why the bundle was collected, and how long
+// each phase of the job took up until now.
+func (e *Executor) writeDiagnosticsSummary(w io.Writer, reason, phase string, now time.Time) error {
+	var b strings.Builder
+	jobID, _ := e.shell.Env.Get("BUILDKITE_JOB_ID")
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&b, "Job:      %s\n", jobID)
+	fmt.Fprintf(&b, "Host:     %s\n", hostname)
+	fmt.Fprintf(&b, "Time:     %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Reason:   %s\n", reason)
+	if phase != "" {
+		fmt.Fprintf(&b, "Phase:    %s\n", phase)
+	}
+
+	if len(e.phaseTimer.timings) > 0 {
+		b.WriteString("\nPhase timings:\n")
+		for _, pt := range e.phaseTimer.timings {
+			fmt.Fprintf(&b, "  %-12s %v\n", pt.name, pt.duration.Round(time.Millisecond))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeRedactedEnv writes the environment sorted by name, with the values of
+// variables matching redactedVars replaced, whatever their length.
+func writeRedactedEnv(w io.Writer, environ *env.Environment, redactedVars []string) error {
+	pairs := environ.DumpPairs()
+	slices.SortFunc(pairs, func(a, b env.Pair) int { return strings.Compare(a.Name, b.Name) })
+
+	var b strings.Builder
+	for _, p := range pairs {
+		value := p.Value
+		if matched, err := redact.MatchAny(redactedVars, p.Name); err != nil || matched {
+			value = "[REDACTED]"
+		}
+		fmt.Fprintf(&b, "%s=%q\n", p.Name, value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// runDiagnosticsCommand runs c, and returns its output for the bundle. Since
+// the bundle is best-effort, failures are noted in the output rather than
+// returned.
+func (e *Executor) runDiagnosticsCommand(ctx context.Context, c diagnosticsCommand) string {
+	if _, err := e.shell.AbsolutePath(c.name); err != nil {
+		return fmt.Sprintf("%s is not available: %v\n", c.name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsCommandTimeout)
+	defer cancel()
+
+	out, err := e.shell.Command(c.name, c.args...).RunAndCaptureStdout(ctx, shell.ShowStderr(false))
+	if err != nil {
+		out += fmt.Sprintf("\n%s %s failed: %v\n", c.name, strings.Join(c.args, " "), err)
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteRedactedEnv(t *testing.T) {
+	t.Parallel()
+
+	environ := env.FromMap(map[string]string{
+		"BUILDKITE_JOB_ID": "1111-2222",
+		"MY_SECRET":        "hunter2",
+		"API_TOKEN":        "abc",
+		"PATH":             "/usr/bin",
+	})
+
+	var b strings.Builder
+	if err := writeRedactedEnv(&b, environ, []string{"*_SECRET", "*_TOKEN"}); err != nil {
+		t.Fatalf("writeRedactedEnv() error = %v", err)
+	}
+
+	want := `API_TOKEN="[REDACTED]"
+BUILDKITE_JOB_ID="1111-2222"
+MY_SECRET="[REDACTED]"
+PATH="/usr/bin"
+`
+	if diff := cmp.Diff(b.String(), want); diff != "" {
+		t.Errorf("writeRedactedEnv() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestWriteDiagnosticsFileRedacts(t *testing.T) {
+	t.Parallel()
+
+	e := &Executor{
+		redactors: replacer.NewMux(replacer.New(io.Discard, []string{"supersecretvalue"}, redact.Redact)),
+	}
+
+	dir := t.TempDir()
+	if err := e.writeDiagnosticsFile(dir, "processes.txt", func(w io.Writer) error {
+		_, err := io.WriteString(w, "root 1 deploy --password=supersecretvalue\n")
+		return err
+	}); err != nil {
+		t.Fatalf("e.writeDiagnosticsFile() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "processes.txt"))
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if want := "root 1 deploy --password=[REDACTED]\n"; string(got) != want {
+		t.Errorf("processes.txt = %q, want %q", got, want)
+	}
+}
+
+func TestDiagnosticsReason(t *testing.T) {
+	t.Parallel()
+
+	e := New(ExecutorConfig{})
+	if got := e.diagnosticsReason(); got != "" {
+		t.Errorf("before cancelling, e.diagnosticsReason() = %q, want empty", got)
+	}
+
+	e.hookTimedOut.Store(true)
+	if got, want := e.diagnosticsReason(), "a hook timed out"; got != want {
+		t.Errorf("after a hook timeout, e.diagnosticsReason() = %q, want %q", got, want)
+	}
+
+	if err := e.Cancel(); err != nil {
+		t.Fatalf("e.Cancel() error = %v", err)
+	}
+	if got, want := e.diagnosticsReason(), "the job was cancelled or timed out"; got != want {
+		t.Errorf("after cancelling, e.diagnosticsReason() = %q, want %q", got, want)
+	}
+}
@@ -27,7 +27,10 @@ func hasDeprecatedDockerIntegration(sh *shell.Shell) bool {
 	return false
 }
 
-func runDeprecatedDockerIntegration(ctx context.Context, sh *shell.Shell, cmd []string) error {
+// runDeprecatedDockerIntegration runs cmd with docker or docker-compose. The
+// opts apply to running the docker or docker-compose command that runs cmd,
+// but not to building images.
+func runDeprecatedDockerIntegration(ctx context.Context, sh *shell.Shell, cmd []string, opts ...shell.RunCommandOpt) error {
 	var warnNotSet = func(k1, k2 string) {
 		sh.Warningf("%s is set, but without %s, which it requires. You should be able to safely remove this from your pipeline.", k1, k2)
 	}
@@ -35,11 +38,11 @@ func runDeprecatedDockerIntegration(ctx context.Context, sh *shell.Shell, cmd []
 	switch {
 	case sh.Env.Exists("BUILDKITE_DOCKER_COMPOSE_CONTAINER"):
 		sh.Warningf("BUILDKITE_DOCKER_COMPOSE_CONTAINER is set, which is deprecated in Agent v3 and will be removed in v4. Consider using the :docker: docker-compose plugin instead at https://github.com/buildkite-plugins/docker-compose-buildkite-plugin.")
-		return runDockerComposeCommand(ctx, sh, cmd)
+		return runDockerComposeCommand(ctx, sh, cmd, opts...)
 
 	case sh.Env.Exists("BUILDKITE_DOCKER"):
 		sh.Warningf("BUILDKITE_DOCKER is set, which is deprecated in Agent v3 and will be removed in v4. Consider using the docker plugin instead at https://github.com/buildkite-plugins/docker-buildkite-plugin.")
-		return runDockerCommand(ctx, sh, cmd)
+		return runDockerCommand(ctx, sh, cmd, opts...)
 
 	case sh.Env.Exists("BUILDKITE_DOCKER_COMPOSE_FILE"):
 		warnNotSet("BUILDKITE_DOCKER_COMPOSE_FILE", "BUILDKITE_DOCKER_COMPOSE_CONTAINER")
@@ -84,7 +87,7 @@ func tearDownDeprecatedDockerIntegration(ctx context.Context, sh *shell.Shell) e
 
 // runDockerCommand executes a command inside a docker container that is built as needed
 // Ported from https://github.com/buildkite/agent/blob/2b8f1d569b659e07de346c0e3ae7090cb98e49ba/templates/bootstrap.sh#L439
-func runDockerCommand(ctx context.Context, sh *shell.Shell, cmd []string) error {
+func runDockerCommand(ctx context.Context, sh *shell.Shell, cmd []string, opts ...shell.RunCommandOpt) error {
 	jobId, _ := sh.Env.Get("BUILDKITE_JOB_ID")
 	dockerContainer := fmt.Sprintf("buildkite_%s_container", jobId)
 	dockerImage := fmt.Sprintf("buildkite_%s_image", jobId)
@@ -105,7 +108,7 @@ func runDockerCommand(ctx context.Context, sh *shell.Shell, cmd []string) error
 
 	sh.Headerf(":docker: Running command (in Docker container)")
 	shCmd = sh.Command("docker", append([]string{"run", "--name", dockerContainer, dockerImage}, cmd...)...)
-	if err := shCmd.Run(ctx); err != nil {
+	if err := shCmd.Run(ctx, opts...); err != nil {
 		return err
 	}
 
@@ -114,7 +117,7 @@ func runDockerCommand(ctx context.Context, sh *shell.Shell, cmd []string) error
 
 // runDockerComposeCommand executes a command with docker-compose
 // Ported from https://github.com/buildkite/agent/blob/2b8f1d569b659e07de346c0e3ae7090cb98e49ba/templates/bootstrap.sh#L462
-func runDockerComposeCommand(ctx context.Context, sh *shell.Shell, cmd []string) error {
+func runDockerComposeCommand(ctx context.Context, sh *shell.Shell, cmd []string, opts ...shell.RunCommandOpt) error {
 	composeContainer, _ := sh.Env.Get("BUILDKITE_DOCKER_COMPOSE_CONTAINER")
 	jobId, _ := sh.Env.Get("BUILDKITE_JOB_ID")
 
@@ -136,10 +139,17 @@ func runDockerComposeCommand(ctx context.Context, sh *shell.Shell, cmd []string)
 	}
 
 	sh.Headerf(":docker: Running command (in Docker Compose container)")
-	return runDockerCompose(ctx, sh, projectName, append([]string{"run", composeContainer}, cmd...)...)
+	args := dockerComposeArgs(sh, projectName, append([]string{"run", composeContainer}, cmd...)...)
+	return sh.Command("docker-compose", args...).Run(ctx, opts...)
 }
 
 func runDockerCompose(ctx context.Context, sh *shell.Shell, projectName string, commandArgs ...string) error {
+	return sh.Command("docker-compose", dockerComposeArgs(sh, projectName, commandArgs...)...).Run(ctx)
+}
+
+// dockerComposeArgs returns the arguments to docker-compose to run
+// commandArgs in the project.
+func dockerComposeArgs(sh *shell.Shell, projectName string, commandArgs ...string) []string {
 	args := []string{}
 
 	composeFile, _ := sh.Env.Get("BUILDKITE_DOCKER_COMPOSE_FILE")
@@ -160,6 +170,5 @@ func runDockerCompose(ctx context.Context, sh *shell.Shell, projectName string,
 		args = append(args, "--verbose")
 	}
 
-	args = append(args, commandArgs...)
-	return sh.Command("docker-compose", args...).Run(ctx)
+	return append(args, commandArgs...)
 }
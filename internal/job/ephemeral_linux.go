@@ -0,0 +1,16 @@
+package job
+
+import "golang.org/x/sys/unix"
+
+// mountEphemeral mounts a tmpfs over dir, so that nothing written within dir
+// outlives the mount.
+func mountEphemeral(dir string) error {
+	return unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755")
+}
+
+// unmountEphemeral unmounts the tmpfs at dir, discarding its contents. The
+// unmount is lazy, so that any stray processes still using dir don't stop the
+// job from finishing.
+func unmountEphemeral(dir string) error {
+	return unix.Unmount(dir, unix.MNT_DETACH)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package job
+
+import "errors"
+
+var errEphemeralUnsupported = errors.New("ephemeral build directories are only supported on Linux")
+
+func mountEphemeral(string) error {
+	return errEphemeralUnsupported
+}
+
+func unmountEphemeral(string) error {
+	return errEphemeralUnsupported
+}
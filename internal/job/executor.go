@@ -17,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/agent/v3/internal/shellscript"
 	"github.com/buildkite/agent/v3/internal/tempfile"
+	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/agent/v3/kubernetes"
 	"github.com/buildkite/agent/v3/process"
 	"github.com/buildkite/agent/v3/tracetools"
@@ -52,12 +54,40 @@ type Executor struct {
 	// Plugins to use
 	plugins []*plugin.Plugin
 
+	// SHAs that plugins must resolve to, according to AllowedPlugins
+	pluginSHAs map[*plugin.Plugin][]string
+
 	// Plugin checkouts from the plugin phases
 	pluginCheckouts []*pluginCheckout
 
 	// Directories to clean up at end of job execution
 	cleanupDirs []string
 
+	// Private directory for secret files written during the job, if any
+	secretsDir string
+
+	// The mirror that the checkout is a worktree of, if any
+	gitWorktreeMirror string
+
+	// The checkout directory that a tmpfs was mounted over, if any
+	ephemeralMount string
+
+	// The containers that ran the command, if BUILDKITE_COMMAND_CONTAINER is set
+	commandContainers []commandContainer
+
+	// Encodes job output as JSON lines, if JobLogFormat is "json"
+	jsonLog *shell.JSONLines
+
+	// The Job API server, if it's running
+	jobAPI *jobapi.Server
+
+	// How long each phase of the job took, for PhaseTimings
+	phaseTimer phaseTimer
+
+	// Whether a hook was stopped for running past its timeout, for
+	// DiagnosticsBundle
+	hookTimedOut atomic.Bool
+
 	// A channel to track cancellation
 	cancelMu  sync.Mutex
 	cancelCh  chan struct{}
@@ -98,7 +128,10 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	if e.KubernetesExec {
 		tempLog.Commentf("Using Kubernetes support")
 
-		socket := &kubernetes.Client{ID: e.KubernetesContainerID}
+		socket := &kubernetes.Client{
+			ID:        e.KubernetesContainerID,
+			LogPrefix: e.KubernetesLogPrefix,
+		}
 		if err := e.kubernetesSetup(ctx, environ, socket); err != nil {
 			e.shell.Errorf("Failed to start kubernetes socket client: %v", err)
 			return 1
@@ -115,6 +148,19 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 		}()
 	}
 
+	if e.JobLogFormat == "json" {
+		// Encode all output as JSON lines on stdout. This happens after
+		// redaction, so secrets can't hide behind JSON escaping.
+		e.jsonLog = shell.NewJSONLines(stdout)
+		stdout, stderr = e.jsonLog.Stream("stdout"), e.jsonLog.Stream("stderr")
+		tempLog = shell.NewWriterLogger(stderr, false, e.DisabledWarnings)
+
+		defer func() {
+			_ = e.redactors.Flush()
+			_ = e.jsonLog.Flush()
+		}()
+	}
+
 	// setup the redactors here once and for the life of the executor
 	// they will be flushed at the end of each hook
 	preRedactedStdout, preRedactedLogger := e.setupRedactors(tempLog, environ, stdout, stderr)
@@ -127,6 +173,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 			shell.WithLogger(preRedactedLogger), // shell -> logger -> redactor -> real stderr
 			shell.WithInterruptSignal(e.ExecutorConfig.CancelSignal),
 			shell.WithPTY(e.ExecutorConfig.RunInPty),
+			shell.WithPTYSize(e.ExecutorConfig.PTYRows, e.ExecutorConfig.PTYCols),
 			shell.WithStdout(preRedactedStdout), // shell -> redactor -> real stdout
 			shell.WithSignalGracePeriod(e.ExecutorConfig.SignalGracePeriod),
 			shell.WithTraceContextCodec(e.TraceContextCodec),
@@ -149,7 +196,29 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	defer graceCancel()
 	go func() {
 		<-e.cancelCh
-		e.shell.Commentf("Received cancellation signal, interrupting")
+		e.shell.Commentf("Received cancellation signal")
+
+		// Give on-cancel hooks their chance to look at the job before it's
+		// interrupted. The job is still running in e.shell, so they get a
+		// shell of their own.
+		opts := []shell.NewShellOpt{
+			shell.WithDebug(e.ExecutorConfig.Debug),
+			shell.WithEnv(e.shell.Env.Copy()),
+			shell.WithLogger(preRedactedLogger),
+			shell.WithInterruptSignal(e.ExecutorConfig.CancelSignal),
+			shell.WithStdout(preRedactedStdout),
+			shell.WithSignalGracePeriod(e.ExecutorConfig.SignalGracePeriod),
+		}
+		if dir, ok := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH"); ok && osutil.FileExists(dir) {
+			opts = append(opts, shell.WithWD(dir))
+		}
+		if sh, err := shell.New(opts...); err != nil {
+			e.shell.Warningf("Couldn't create a shell for on-cancel hooks: %v", err)
+		} else {
+			e.runOnCancelHooks(ctx, sh)
+		}
+
+		e.shell.Commentf("Interrupting the job")
 		cancel()
 	}()
 
@@ -172,12 +241,39 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	defer func() {
 		// We strive to let the executor tear-down happen whether or not the job
 		// (and thus ctx) is cancelled, so it can run during the grace period.
+		stoppedIn := e.phaseTimer.current
+		e.setPhase("teardown")
+
 		if err := e.tearDown(graceCtx); err != nil {
 			e.shell.Errorf("Error tearing down job executor: %v", err)
 
 			// this gets passed back via the named return
 			exitCode = shell.ExitCode(err)
 		}
+
+		// Collect diagnostics after the pre-exit hooks, so that they get
+		// the grace period first, and within a limit of their own.
+		if e.DiagnosticsBundle {
+			if reason := e.diagnosticsReason(); reason != "" {
+				diagCtx, cancel := context.WithTimeout(graceCtx, diagnosticsBundleTimeout)
+				if err := e.uploadDiagnosticsBundle(diagCtx, reason, stoppedIn); err != nil {
+					e.shell.Warningf("Couldn't upload diagnostics bundle: %v", err)
+				}
+				cancel()
+			}
+		}
+
+		if e.PhaseTimings != "" {
+			if err := ValidatePhaseTimings(e.PhaseTimings); err != nil {
+				e.shell.Warningf("Not recording phase timings: %v", err)
+			} else if err := e.recordPhaseTimings(graceCtx); err != nil {
+				e.shell.Warningf("Couldn't record phase timings: %v", err)
+			}
+		}
+
+		if err := e.recordPhaseMetrics(); err != nil {
+			e.shell.Warningf("Couldn't record phase metrics: %v", err)
+		}
 	}()
 
 	if env, ok := e.shell.Env.Get("BUILDKITE_USE_GITHUB_APP_GIT_CREDENTIALS"); ok && env == "true" {
@@ -202,6 +298,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	}
 
 	// Initialize the environment, a failure here will still call the tearDown
+	e.setPhase("setup")
 	if err = e.setUp(ctx); err != nil {
 		e.shell.Errorf("Error setting up job executor: %v", err)
 		return shell.ExitCode(err)
@@ -211,6 +308,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	var phaseErr error
 
 	if e.includePhase("plugin") {
+		e.setPhase("plugin")
 		phaseErr = e.preparePlugins()
 
 		if phaseErr == nil {
@@ -219,6 +317,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	}
 
 	if phaseErr == nil && e.includePhase("checkout") {
+		e.setPhase("checkout")
 		phaseErr = e.CheckoutPhase(ctx)
 	} else {
 		checkoutDir, exists := e.shell.Env.Get("BUILDKITE_BUILD_CHECKOUT_PATH")
@@ -228,10 +327,12 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	}
 
 	if phaseErr == nil && e.includePhase("plugin") {
+		e.setPhase("plugin")
 		phaseErr = e.VendoredPluginPhase(ctx)
 	}
 
 	if phaseErr == nil && e.includePhase("command") {
+		e.setPhase("command")
 		var commandErr error
 		phaseErr, commandErr = e.CommandPhase(ctx)
 		/*
@@ -261,6 +362,7 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 		// Only upload artifacts as part of the command phase.
 		// The artifacts might be relevant for debugging job timeouts, so it can
 		// run during the grace period.
+		e.phaseTimer.start("artifact", time.Now())
 		if err := e.artifactPhase(graceCtx); err != nil {
 			e.shell.Errorf("%v", err)
 
@@ -291,6 +393,25 @@ func (e *Executor) Run(ctx context.Context) (exitCode int) {
 	return exitStatusCode
 }
 
+// setPhase records the job phase in JSON job logs and the Job API, and starts
+// timing it.
+func (e *Executor) setPhase(phase string) {
+	e.phaseTimer.start(phase, time.Now())
+	if e.jsonLog != nil {
+		e.jsonLog.SetPhase(phase)
+	}
+	if e.jobAPI != nil {
+		e.jobAPI.StartPhase(phase)
+	}
+}
+
+// setLogHook records the running hook in JSON job logs.
+func (e *Executor) setLogHook(hook string) {
+	if e.jsonLog != nil {
+		e.jsonLog.SetHook(hook)
+	}
+}
+
 func (e *Executor) includePhase(phase string) bool {
 	if len(e.Phases) == 0 {
 		return true
@@ -367,6 +488,9 @@ func (e *Executor) executeHook(ctx context.Context, hookCfg HookConfig) error {
 		return nil
 	}
 
+	e.setLogHook(hookName)
+	defer e.setLogHook("")
+
 	e.shell.Headerf("Running %s hook", hookName)
 
 	hookType, err := hook.Type(hookCfg.Path)
@@ -374,42 +498,128 @@ func (e *Executor) executeHook(ctx context.Context, hookCfg HookConfig) error {
 		return fmt.Errorf("determining hook type for %q hook: %w", hookName, err)
 	}
 
+	timeout := e.hookTimeout(hookCfg.Name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var runOpts []shell.RunCommandOpt
+	var output *hookOutput
+	if e.TracingBackend != tracetools.BackendNone && e.TracingHookOutputLimit > 0 {
+		output = e.newHookOutput(e.TracingHookOutputLimit)
+		runOpts = append(runOpts, output.runOpt())
+	}
+
+	err = e.runHook(ctx, hookName, hookType, hookCfg, runOpts...)
+
+	if output != nil {
+		output.addTo(span)
+		if err == nil || shell.IsExitError(err) {
+			span.AddAttributes(map[string]string{"hook.exit_status": strconv.Itoa(shell.ExitCode(err))})
+		}
+	}
+
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		e.shell.Errorf("The %s hook was stopped because it ran for longer than its timeout of %v", hookName, timeout)
+		e.hookTimedOut.Store(true)
+		err = fmt.Errorf("%s hook timed out after %v: %w", hookName, timeout, err)
+	}
+	return err
+}
+
+// hookTimeout returns how long the named hook may run for, or 0 for no limit.
+// BUILDKITE_HOOK_TIMEOUT_<NAME> (e.g. BUILDKITE_HOOK_TIMEOUT_PRE_COMMAND) can
+// shorten the agent's hook timeout for that hook, but not lengthen it, as the
+// job's env can set it. Command hooks run the job's command, so they're only
+// limited by their own variable.
+func (e *Executor) hookTimeout(name string) time.Duration {
+	timeout := e.HookTimeout
+	if name == "command" {
+		timeout = 0
+	}
+
+	key := "BUILDKITE_HOOK_TIMEOUT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	v, _ := e.shell.Env.Get(key)
+	if v == "" {
+		return timeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		e.shell.Warningf("Ignoring %s=%q, which isn't a valid duration such as 5m", key, v)
+		return timeout
+	}
+	if timeout > 0 && (d == 0 || d > timeout) {
+		e.shell.Warningf("Ignoring %s=%q, which is longer than the agent's hook timeout of %v", key, v, timeout)
+		return timeout
+	}
+	return d
+}
+
+// runHook runs the hook according to its type.
+// runOpts are passed to the hook's shell.Command.Run.
+func (e *Executor) runHook(ctx context.Context, hookName, hookType string, hookCfg HookConfig, runOpts ...shell.RunCommandOpt) error {
+	// Check for an interpreter by extension first, as a hook without a shebang
+	// (such as a .py hook) would otherwise be treated as a shell script
+	if interpreter := e.extensionInterpreter(hookType, hookCfg.Path); interpreter != "" {
+		if err := e.runUnwrappedHook(ctx, hookName, hookCfg, interpreter, runOpts...); err != nil {
+			return fmt.Errorf("running %q script hook: %w", hookName, err)
+		}
+		return nil
+	}
+
 	switch hookType {
 	case hook.TypeScript:
 		if runtime.GOOS == "windows" {
 			// We use shebangs to figure out how to run scripts, and Windows has no way to interpret a shebang
 			// ie, on linux, we can just point the OS to a file of some sort and say "run that", and as part of that it will try to
-			// read a shebang, and run the script using the interpreter specified. Windows can't do this, so we can't run scripts
-			// directly on Windows
-
-			// Potentially there's something that we could do with file extensions, but that's a bit of a minefield, and would
-			// probably mean that we have to have a list of blessed hook runtimes on windows only... or something.
+			// read a shebang, and run the script using the interpreter specified. Windows can't do this, so instead we
+			// choose the interpreter by the hook's file extension.
+			ext := strings.ToLower(filepath.Ext(hookCfg.Path))
+			interpreter := e.HookInterpreters[ext]
+			if interpreter == "" {
+				sheb, _ := shellscript.ShebangLine(hookCfg.Path) // we know this won't error because it must have a shebang to be a script
+
+				err := fmt.Errorf(`when trying to run the hook at %q, the agent found that it was a script with a shebang that isn't for a shellscripting language - in this case, %q.
+Windows has no way of interpreting a shebang, so hooks of this kind need a file extension with an interpreter configured (see the hook-interpreters agent option)`, hookCfg.Path, sheb)
+				return err
+			}
 
-			// Regardless: not supported right now, or potentially ever.
-			sheb, _ := shellscript.ShebangLine(hookCfg.Path) // we know this won't error because it must have a shebang to be a script
+			// Other extensions with an interpreter were run above, so this is a
+			// PowerShell hook. It can be wrapped, so that its environment changes
+			// are captured.
+			if err := e.runWrappedShellScriptHook(ctx, hookName, hookCfg, interpreter, runOpts...); err != nil {
+				return fmt.Errorf("running %q PowerShell hook: %w", hookName, err)
+			}
+			return nil
+		}
 
-			err := fmt.Errorf(`when trying to run the hook at %q, the agent found that it was a script with a shebang that isn't for a shellscripting language - in this case, %q.
-Hooks of this kind are unfortunately not supported on Windows, as we have no way of interpreting a shebang on Windows`, hookCfg.Path, sheb)
-			return err
+		// PowerShell hooks with a shebang can still be wrapped, so that their environment changes are captured
+		if strings.EqualFold(filepath.Ext(hookCfg.Path), ".ps1") {
+			if err := e.runWrappedShellScriptHook(ctx, hookName, hookCfg, "", runOpts...); err != nil {
+				return fmt.Errorf("running %q PowerShell hook: %w", hookName, err)
+			}
+			return nil
 		}
 
 		// It's a script, and we can rely on the OS to figure out how to run it (because we're not on windows), so run it
 		// directly without wrapping
-		if err := e.runUnwrappedHook(ctx, hookName, hookCfg); err != nil {
+		if err := e.runUnwrappedHook(ctx, hookName, hookCfg, "", runOpts...); err != nil {
 			return fmt.Errorf("running %q script hook: %w", hookName, err)
 		}
 
 		return nil
 	case hook.TypeBinary:
 		// It's a binary, so we'll just run it directly, no wrapping needed or possible
-		if err := e.runUnwrappedHook(ctx, hookName, hookCfg); err != nil {
+		if err := e.runUnwrappedHook(ctx, hookName, hookCfg, "", runOpts...); err != nil {
 			return fmt.Errorf("running %q binary hook: %w", hookName, err)
 		}
 
 		return nil
 	case hook.TypeShell:
 		// It's definitely a shell script, wrap it so that we can snaffle the changed environment variables
-		if err := e.runWrappedShellScriptHook(ctx, hookName, hookCfg); err != nil {
+		if err := e.runWrappedShellScriptHook(ctx, hookName, hookCfg, "", runOpts...); err != nil {
 			return fmt.Errorf("running %q shell hook: %w", hookName, err)
 		}
 
@@ -419,14 +629,40 @@ Hooks of this kind are unfortunately not supported on Windows, as we have no way
 	}
 }
 
-func (e *Executor) runUnwrappedHook(ctx context.Context, hookName string, hookCfg HookConfig) error {
+// extensionInterpreter returns the interpreter for the hook's extension, if it
+// should be run with one. On Windows, which can't use a shebang, that's any
+// script hook. Elsewhere, it's only a hook without a shebang, as the shebang
+// names the interpreter the hook was written for. PowerShell hooks are left to
+// runHook, so that they can be wrapped.
+func (e *Executor) extensionInterpreter(hookType, path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	interpreter := e.HookInterpreters[ext]
+	if interpreter == "" || ext == ".ps1" || hookType == hook.TypeBinary {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		return interpreter
+	}
+	if sheb, err := shellscript.ShebangLine(path); err != nil || sheb != "" {
+		return ""
+	}
+	return interpreter
+}
+
+// runUnwrappedHook runs the hook directly, or with the interpreter if one is
+// given.
+func (e *Executor) runUnwrappedHook(ctx context.Context, hookName string, hookCfg HookConfig, interpreter string, runOpts ...shell.RunCommandOpt) error {
 	environ := hookCfg.Env.Copy()
 
 	environ.Set("BUILDKITE_HOOK_PHASE", hookCfg.Name)
 	environ.Set("BUILDKITE_HOOK_PATH", hookCfg.Path)
 	environ.Set("BUILDKITE_HOOK_SCOPE", hookCfg.Scope)
 
-	return e.shell.Command(hookCfg.Path).Run(ctx, shell.WithExtraEnv(environ))
+	cmd := e.shell.Command(hookCfg.Path)
+	if interpreter != "" {
+		cmd = e.shell.Command(interpreter, hookCfg.Path)
+	}
+	return cmd.Run(ctx, append([]shell.RunCommandOpt{shell.WithExtraEnv(environ)}, runOpts...)...)
 }
 
 func logOpenedHookInfo(l shell.Logger, debug bool, hookName, path string) {
@@ -479,7 +715,11 @@ func logMissingHookInfo(l shell.Logger, hookName, wrapperPath string) {
 	l.Errorf("The %s hook failed to run - perhaps the script interpreter %q is missing", hookName, interpreter)
 }
 
-func (e *Executor) runWrappedShellScriptHook(ctx context.Context, hookName string, hookCfg HookConfig) error {
+// runWrappedShellScriptHook runs the hook in a wrapper that captures its
+// environment changes. The wrapper is run with the interpreter if one is given
+// (only PowerShell interpreters can run a PowerShell hook's wrapper), otherwise
+// the shell chooses.
+func (e *Executor) runWrappedShellScriptHook(ctx context.Context, hookName string, hookCfg HookConfig, interpreter string, runOpts ...shell.RunCommandOpt) error {
 	defer e.redactors.Flush()
 
 	script, err := hook.NewWrapper(hook.WithPath(hookCfg.Path))
@@ -489,6 +729,15 @@ func (e *Executor) runWrappedShellScriptHook(ctx context.Context, hookName strin
 	}
 	defer script.Close()
 
+	// PowerShell hooks are run with the job's PowerShell if it has one. Outside
+	// of Windows there's no powershell.exe to fall back to, so use pwsh.
+	if interpreter == "" && strings.EqualFold(filepath.Ext(hookCfg.Path), ".ps1") {
+		interpreter = e.powershell()
+		if interpreter == "" && runtime.GOOS != "windows" {
+			interpreter = "pwsh"
+		}
+	}
+
 	cleanHookPath := hookCfg.Path
 
 	// Show a relative path if we can
@@ -519,12 +768,15 @@ func (e *Executor) runWrappedShellScriptHook(ctx context.Context, hookName strin
 		// (which acquires open file descriptors of the parent process) and
 		// writing an executable (the script wrapper).
 		// See https://github.com/golang/go/issues/22315.
-		script, err := e.shell.Script(script.Path())
-		if err != nil {
-			r.Break()
-			return err
+		cmd := e.shell.Command(interpreter, "-file", script.Path())
+		if interpreter == "" {
+			var err error
+			if cmd, err = e.shell.Script(script.Path()); err != nil {
+				r.Break()
+				return err
+			}
 		}
-		err = script.Run(ctx, shell.ShowPrompt(false), shell.WithExtraEnv(hookCfg.Env))
+		err = cmd.Run(ctx, append([]shell.RunCommandOpt{shell.ShowPrompt(false), shell.WithExtraEnv(hookCfg.Env)}, runOpts...)...)
 		if errors.Is(err, syscall.ETXTBSY) {
 			return err
 		}
@@ -582,7 +834,11 @@ func (e *Executor) runWrappedShellScriptHook(ctx context.Context, hookName strin
 		}
 	} else {
 		// Hook exited successfully (and not early!) We have an environment and
-		// wd change we can apply to our subsequent phases
+		// wd change we can apply to our subsequent phases, except to protected
+		// variables
+		if err := e.enforceProtectedEnv(hookName, &changes.Diff); err != nil {
+			return err
+		}
 		e.applyEnvironmentChanges(changes)
 	}
 
@@ -614,13 +870,18 @@ func (e *Executor) applyEnvironmentChanges(changes hook.EnvChanges) {
 	}
 
 	for _, pair := range toRedact {
-		e.redactors.Add(pair.Value)
+		e.redactors.Add(redact.Variants(pair.Value)...)
 	}
 
 	// First, let see any of the environment variables are supposed
 	// to change the job configuration at run time.
 	executorConfigEnvChanges := e.ExecutorConfig.ReadFromEnvironment(e.shell.Env)
 
+	// Commands run from now on get the new signal grace period
+	if _, ok := executorConfigEnvChanges["BUILDKITE_SIGNAL_GRACE_PERIOD_SECONDS"]; ok {
+		shell.WithSignalGracePeriod(e.SignalGracePeriod)(e.shell)
+	}
+
 	// Print out the env vars that changed. As we go through each
 	// one, we'll determine if it was a special environment variable
 	// that has changed the executor configuration at runtime.
@@ -654,12 +915,12 @@ func (e *Executor) applyEnvironmentChanges(changes hook.EnvChanges) {
 }
 
 func (e *Executor) hasGlobalHook(name string) bool {
-	_, err := hook.Find(e.HooksPath, name)
+	_, err := e.findHook(e.HooksPath, name)
 	if err == nil {
 		return true
 	}
 	for _, additional := range e.AdditionalHooksPaths {
-		_, err := hook.Find(additional, name)
+		_, err := e.findHook(additional, name)
 		if err == nil {
 			return true
 		}
@@ -670,7 +931,7 @@ func (e *Executor) hasGlobalHook(name string) bool {
 // find all matching paths for the specified hook
 func (e *Executor) getAllGlobalHookPaths(name string) ([]string, error) {
 	hooks := []string{}
-	p, err := hook.Find(e.HooksPath, name)
+	p, err := e.findHook(e.HooksPath, name)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return []string{}, err
@@ -680,7 +941,7 @@ func (e *Executor) getAllGlobalHookPaths(name string) ([]string, error) {
 	}
 
 	for _, additional := range e.AdditionalHooksPaths {
-		p, err = hook.Find(additional, name)
+		p, err = e.findHook(additional, name)
 		// as this is an additional hook, don't fail if there's a problem here
 		if err == nil {
 			hooks = append(hooks, p)
@@ -712,7 +973,38 @@ func (e *Executor) executeGlobalHook(ctx context.Context, name string) error {
 // Returns the absolute path to a local hook, or os.ErrNotExist if none is found
 func (e *Executor) localHookPath(name string) (string, error) {
 	dir := filepath.Join(e.shell.Getwd(), ".buildkite", "hooks")
-	return hook.Find(dir, name)
+	return e.findHook(dir, name)
+}
+
+// findHook finds a hook in the directory, including (on Windows) hooks with an
+// extension that has an interpreter.
+func (e *Executor) findHook(dir, name string) (string, error) {
+	exts := make([]string, 0, len(e.HookInterpreters))
+	for ext := range e.HookInterpreters {
+		exts = append(exts, ext)
+	}
+	slices.Sort(exts)
+	p, err := hook.Find(dir, name, exts...)
+	if err == nil || runtime.GOOS == "windows" || e.powershell() == "" {
+		return p, err
+	}
+	// Windows already looks for .ps1 hooks. Elsewhere, look for them if the
+	// job is run with PowerShell.
+	return hook.Find(dir, name+".ps1")
+}
+
+// powershell returns the PowerShell executable from the job's shell, or an
+// empty string if the job isn't run with PowerShell.
+func (e *Executor) powershell() string {
+	interpreter, err := shellwords.Split(e.Shell)
+	if err != nil || len(interpreter) == 0 {
+		return ""
+	}
+	switch strings.TrimSuffix(strings.ToLower(filepath.Base(interpreter[0])), ".exe") {
+	case "pwsh", "powershell":
+		return interpreter[0]
+	}
+	return ""
 }
 
 func (e *Executor) hasLocalHook(name string) bool {
@@ -823,6 +1115,10 @@ func (e *Executor) setUp(ctx context.Context) error {
 		}
 
 		e.shell.Printf("^^^ +++")
+
+		if e.ProtectedEnvEnforce {
+			return fmt.Errorf("the pipeline tried to set protected environment variables: %s", strings.ReplaceAll(ignored, ",", ", "))
+		}
 	}
 
 	if e.Debug {
@@ -839,6 +1135,13 @@ func (e *Executor) setUp(ctx context.Context) error {
 	// Disable any interactive Git/SSH prompting
 	e.shell.Env.Set("GIT_TERMINAL_PROMPT", "0")
 
+	// Give secrets written to files (with `buildkite-agent secret get --file`)
+	// somewhere private to live, which is removed when the job finishes
+	if e.secretsDir, err = os.MkdirTemp("", "buildkite-secrets-"); err != nil {
+		return fmt.Errorf("creating secrets directory: %w", err)
+	}
+	e.shell.Env.Set("BUILDKITE_SECRETS_DIR", e.secretsDir)
+
 	// It's important to do this before checking out plugins, in case you want
 	// to use the global environment hook to whitelist the plugins that are
 	// allowed to be used.
@@ -852,6 +1155,16 @@ func (e *Executor) tearDown(ctx context.Context) error {
 	var err error
 	defer func() { span.FinishWithError(err) }()
 
+	// Secret files must not outlive the job, even if a pre-exit hook fails
+	defer e.removeSecretsDir()
+
+	// Nor should command containers
+	defer func() {
+		if err := e.removeCommandContainers(ctx); err != nil {
+			e.shell.Warningf("Failed to remove command container: %v", err)
+		}
+	}()
+
 	// In vanilla agent usage, there's always a command phase.
 	// But over in agent-stack-k8s, which splits the agent phases among
 	// containers (the checkout phase happens in a separate container to the
@@ -873,10 +1186,18 @@ func (e *Executor) tearDown(ctx context.Context) error {
 	}
 
 	// Support deprecated BUILDKITE_DOCKER* env vars
-	if hasDeprecatedDockerIntegration(e.shell) {
+	if len(e.commandContainers) == 0 && hasDeprecatedDockerIntegration(e.shell) {
 		return tearDownDeprecatedDockerIntegration(ctx, e.shell)
 	}
 
+	if err := e.unmountEphemeralBuildDir(); err != nil {
+		e.shell.Warningf("Failed to discard ephemeral build directory: %v", err)
+	}
+
+	if err := e.removeGitWorktree(ctx); err != nil {
+		e.shell.Warningf("Failed to remove git worktree: %v", err)
+	}
+
 	for _, dir := range e.cleanupDirs {
 		if err = os.RemoveAll(dir); err != nil {
 			e.shell.Warningf("Failed to remove dir %s: %v", dir, err)
@@ -886,6 +1207,18 @@ func (e *Executor) tearDown(ctx context.Context) error {
 	return nil
 }
 
+// removeSecretsDir removes the directory of secret files, if there is one.
+func (e *Executor) removeSecretsDir() {
+	if e.secretsDir == "" {
+		return
+	}
+	if err := os.RemoveAll(e.secretsDir); err != nil {
+		e.shell.Warningf("Failed to remove secrets dir %s: %v", e.secretsDir, err)
+		return
+	}
+	e.secretsDir = ""
+}
+
 // runPreCommandHooks runs the pre-command hooks and adds tracing spans.
 func (e *Executor) runPreCommandHooks(ctx context.Context) (err error) {
 	spanName := e.implementationSpecificSpanName("pre-command", "pre-command hooks")
@@ -916,6 +1249,38 @@ func (e *Executor) runCommand(ctx context.Context) error {
 	}
 }
 
+// runCommandWithRetries runs the command, and runs it again each time it fails
+// in a way CommandRetryExitStatuses allows, up to CommandRetryAttempts times.
+// Retrying just the command is quicker than retrying the whole job.
+func (e *Executor) runCommandWithRetries(ctx context.Context) error {
+	attempts := max(e.CommandRetryAttempts, 0) + 1
+	for attempt := 1; ; attempt++ {
+		if attempts > 1 {
+			e.shell.Env.Set("BUILDKITE_COMMAND_ATTEMPT", strconv.Itoa(attempt))
+		}
+		if attempt > 1 {
+			e.shell.Headerf("Retrying the command (attempt %d of %d)", attempt, attempts)
+		}
+
+		err := e.runCommand(ctx)
+		if attempt == attempts || ctx.Err() != nil || !isRetryableCommandError(err, e.CommandRetryExitStatuses) {
+			return err
+		}
+		e.shell.Warningf("The command exited with status %d, retrying", shell.ExitCode(err))
+	}
+}
+
+// isRetryableCommandError reports whether the command failing with err can be
+// retried. Commands that exit with a status in exitStatuses, or with any
+// non-zero status if exitStatuses is empty, can be. Commands that are killed
+// by a signal, or that couldn't be run, can't be.
+func isRetryableCommandError(err error, exitStatuses []int) bool {
+	if err == nil || !shell.IsExitError(err) || shell.IsExitSignaled(err) {
+		return false
+	}
+	return len(exitStatuses) == 0 || slices.Contains(exitStatuses, shell.ExitCode(err))
+}
+
 // runPostCommandHooks runs the post-command hooks and adds tracing spans.
 func (e *Executor) runPostCommandHooks(ctx context.Context) (err error) {
 	spanName := e.implementationSpecificSpanName("post-command", "post-command hooks")
@@ -940,6 +1305,15 @@ func (e *Executor) CommandPhase(ctx context.Context) (hookErr error, commandErr
 		span.FinishWithError(hookErr)
 	}()
 
+	// Wait for other jobs on this host in the same local concurrency group,
+	// and hold the group until the post-command hooks have finished.
+	release, err := e.acquireLocalConcurrencyGroup(ctx)
+	if err != nil {
+		e.shell.Errorf("%v", err)
+		return err, nil
+	}
+	defer release()
+
 	// Run postCommandHooks, even if there is an error from the command, but not if there is an
 	// error from the pre-command hooks. Note: any post-command hook error will be returned.
 	defer func() {
@@ -959,7 +1333,7 @@ func (e *Executor) CommandPhase(ctx context.Context) (hookErr error, commandErr
 	}
 
 	// Run the command
-	commandErr = e.runCommand(ctx)
+	commandErr = e.runCommandWithRetries(ctx)
 
 	// Save the command exit status to the env so hooks + plugins can access it. If there is no
 	// error this will be zero. It's used to set the exit code later, so it's important
@@ -991,6 +1365,7 @@ func (e *Executor) CommandPhase(ctx context.Context) (hookErr error, commandErr
 		return nil, nil
 	case isExitError && !isExitSignaled:
 		e.shell.Errorf("The command exited with status %d", shell.ExitCode(commandErr))
+		e.softFailIfClassified(ctx, shell.ExitCode(commandErr))
 		return nil, commandErr
 	default:
 		e.shell.Errorf("%s", commandErr)
@@ -1113,12 +1488,19 @@ func (e *Executor) defaultCommandPhase(ctx context.Context) error {
 		cmdToExec = e.Command
 	}
 
+	image, _ := e.shell.Env.Get("BUILDKITE_COMMAND_CONTAINER")
+
+	var envFilterOpts []shell.RunCommandOpt
+	if keep := e.commandEnvFilter(); keep != nil {
+		envFilterOpts = append(envFilterOpts, shell.WithEnvFilter(keep))
+	}
+
 	// Support deprecated BUILDKITE_DOCKER* env vars
-	if hasDeprecatedDockerIntegration(e.shell) {
+	if image == "" && hasDeprecatedDockerIntegration(e.shell) {
 		if e.Debug {
 			e.shell.Commentf("Detected deprecated docker environment variables")
 		}
-		err = runDeprecatedDockerIntegration(ctx, e.shell, []string{cmdToExec})
+		err = runDeprecatedDockerIntegration(ctx, e.shell, []string{cmdToExec}, envFilterOpts...)
 		return err
 	}
 
@@ -1126,13 +1508,27 @@ func (e *Executor) defaultCommandPhase(ctx context.Context) error {
 	cmd = append(cmd, interpreter...)
 	cmd = append(cmd, cmdToExec)
 
+	if image != "" {
+		e.shell.Commentf("Running command in a container from %s", image)
+		cmd, err = e.containerCommand(image, cmd)
+		if err != nil {
+			return err
+		}
+	} else if e.CommandSandbox != "" {
+		cmd, err = e.sandboxCommand(cmd)
+		if err != nil {
+			return err
+		}
+	}
+
 	if e.Debug {
 		e.shell.Promptf("%s", process.FormatCommand(cmd[0], cmd[1:]))
 	} else {
 		e.shell.Promptf("%s", cmdToExec)
 	}
 
-	err = e.shell.Command(cmd[0], cmd[1:]...).Run(ctx, shell.ShowPrompt(false))
+	runOpts := append([]shell.RunCommandOpt{shell.ShowPrompt(false)}, envFilterOpts...)
+	err = e.shell.Command(cmd[0], cmd[1:]...).Run(ctx, runOpts...)
 	return err
 }
 
@@ -1222,7 +1618,7 @@ func (e *Executor) setupRedactors(log shell.Logger, environ *env.Environment, st
 
 	needles := make([]string, 0, len(varsToRedact))
 	for _, pair := range varsToRedact {
-		needles = append(needles, pair.Value)
+		needles = append(needles, redact.Variants(pair.Value)...)
 	}
 
 	stdoutRedactor := replacer.New(stdout, needles, redact.Redact)
@@ -1230,10 +1626,19 @@ func (e *Executor) setupRedactors(log shell.Logger, environ *env.Environment, st
 	loggerRedactor := replacer.New(stderr, needles, redact.Redact)
 	e.redactors.Append(loggerRedactor)
 
-	logger := shell.NewWriterLogger(loggerRedactor, true, e.DisabledWarnings)
+	logger := shell.NewWriterLogger(loggerRedactor, e.jsonLog == nil, e.DisabledWarnings)
 	return stdoutRedactor, logger
 }
 
+const (
+	// How often to tell the agent container that this container is alive.
+	kubernetesHeartbeatInterval = time.Second
+
+	// How long the agent container can go without responding before the job
+	// is stopped.
+	kubernetesRunnerLostTimeout = 30 * time.Second
+)
+
 func (e *Executor) kubernetesSetup(ctx context.Context, environ *env.Environment, k8sAgentSocket *kubernetes.Client) error {
 	rtr := roko.NewRetrier(
 		roko.WithMaxAttempts(7),
@@ -1298,10 +1703,23 @@ func (e *Executor) kubernetesSetup(ctx context.Context, environ *env.Environment
 	}
 
 	// Proceed when ready
+	if len(regResp.DependsOn) > 0 {
+		e.shell.Commentf("Waiting for containers %v to finish", regResp.DependsOn)
+	}
 	if err := k8sAgentSocket.Await(ctx, kubernetes.RunStateStart); err != nil {
 		return fmt.Errorf("error waiting for client to become ready: %w", err)
 	}
 
+	go func() {
+		// If the agent container disappears, stop running the job rather
+		// than carrying on with no way to report the result.
+		err := k8sAgentSocket.Heartbeat(ctx, kubernetesHeartbeatInterval, kubernetesRunnerLostTimeout)
+		if err != nil {
+			e.shell.Errorf("Stopping the job: %v", err)
+			e.Cancel()
+		}
+	}()
+
 	go func() {
 		// If the k8s client is interrupted because the "server" agent is
 		// stopped or unreachable, we should stop running the job.
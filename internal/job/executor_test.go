@@ -2,10 +2,19 @@ package job
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
+	"github.com/buildkite/agent/v3/internal/job/hook"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"github.com/buildkite/agent/v3/internal/replacer"
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/agent/v3/tracetools"
+	"github.com/google/go-cmp/cmp"
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/opentracer"
@@ -85,3 +94,149 @@ func TestStartTracing_Datadog(t *testing.T) {
 	assert.Equal(t, spanImpl.Span, opentracing.SpanFromContext(ctx))
 	stopper()
 }
+
+func TestRunUnwrappedHookWithInterpreter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var gotLog [][]string
+	e := New(ExecutorConfig{})
+	e.shell = shell.NewTestShell(t, shell.WithDryRun(true), shell.WithCommandLog(&gotLog))
+
+	// Any interpreter will do, since the command isn't actually run
+	absoluteGit, err := e.shell.AbsolutePath("git")
+	if err != nil {
+		t.Fatalf("e.shell.AbsolutePath(git) = %v", err)
+	}
+
+	hookPath := filepath.Join(t.TempDir(), "pre-command.py")
+	if err := os.WriteFile(hookPath, []byte("#!/usr/bin/env python3\nprint('llamas')\n"), 0o755); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", hookPath, err)
+	}
+
+	hookCfg := HookConfig{Scope: "global", Name: "pre-command", Path: hookPath}
+	if err := e.runUnwrappedHook(ctx, "global pre-command", hookCfg, "git"); err != nil {
+		t.Fatalf("e.runUnwrappedHook(ctx, global pre-command, hookCfg, git) = %v", err)
+	}
+
+	wantLog := [][]string{{absoluteGit, hookPath}}
+	if diff := cmp.Diff(gotLog, wantLog); diff != "" {
+		t.Errorf("executed commands diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestPowershell(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		shell, want string
+	}{
+		{shell: "/bin/bash -e -c", want: ""},
+		{shell: "CMD.EXE /S /C", want: ""},
+		{shell: "pwsh -Command", want: "pwsh"},
+		{shell: "/usr/local/bin/pwsh -Command", want: "/usr/local/bin/pwsh"},
+		{shell: "PowerShell.exe -Command", want: "PowerShell.exe"},
+		{shell: "", want: ""},
+	}
+
+	for _, test := range tests {
+		e := New(ExecutorConfig{Shell: test.shell})
+		if got := e.powershell(); got != test.want {
+			t.Errorf("Executor{Shell: %q}.powershell() = %q, want %q", test.shell, got, test.want)
+		}
+	}
+}
+
+func TestFindHookFindsPowershellHooksWhenShellIsPowershell(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows always looks for .ps1 hooks")
+	}
+
+	dir := t.TempDir()
+	hookPath := filepath.Join(dir, "environment.ps1")
+	if err := os.WriteFile(hookPath, []byte("$env:LLAMAS = \"rock\"\n"), 0o755); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", hookPath, err)
+	}
+
+	e := New(ExecutorConfig{Shell: "/bin/bash -e -c"})
+	if got, err := e.findHook(dir, "environment"); err == nil {
+		t.Errorf("Executor{Shell: bash}.findHook(dir, environment) = %q, want error", got)
+	}
+
+	e = New(ExecutorConfig{Shell: "pwsh -Command"})
+	got, err := e.findHook(dir, "environment")
+	if err != nil {
+		t.Fatalf("Executor{Shell: pwsh}.findHook(dir, environment) error = %v", err)
+	}
+	if got != hookPath {
+		t.Errorf("Executor{Shell: pwsh}.findHook(dir, environment) = %q, want %q", got, hookPath)
+	}
+}
+
+type recordingSpan struct {
+	tracetools.NoopSpan
+	events []recordedEvent
+}
+
+type recordedEvent struct {
+	Name       string
+	Attributes map[string]string
+}
+
+func (s *recordingSpan) AddEvent(name string, attributes map[string]string) {
+	s.events = append(s.events, recordedEvent{Name: name, Attributes: attributes})
+}
+
+func TestHookOutputIsRedactedAndTruncated(t *testing.T) {
+	t.Parallel()
+
+	e := New(ExecutorConfig{})
+	e.redactors.Append(replacer.New(io.Discard, []string{"hunter2"}, redact.Redact))
+
+	output := e.newHookOutput(20)
+	fmt.Fprint(output.stdoutRedactor, "the password is hunter2, don't tell anyone")
+	fmt.Fprint(output.stderrRedactor, "hunter2")
+
+	span := &recordingSpan{}
+	output.addTo(span)
+
+	want := []recordedEvent{
+		{Name: "hook.stdout", Attributes: map[string]string{"output": "], don't tell anyone", "truncated": "true"}},
+		{Name: "hook.stderr", Attributes: map[string]string{"output": "[REDACTED]", "truncated": "false"}},
+	}
+	if diff := cmp.Diff(span.events, want); diff != "" {
+		t.Errorf("span events diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestRunHookWithoutShebangUsesExtensionInterpreter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	var gotLog [][]string
+	e := New(ExecutorConfig{HookInterpreters: map[string]string{".py": "git"}})
+	e.shell = shell.NewTestShell(t, shell.WithDryRun(true), shell.WithCommandLog(&gotLog))
+
+	// Any interpreter will do, since the command isn't actually run
+	absoluteGit, err := e.shell.AbsolutePath("git")
+	if err != nil {
+		t.Fatalf("e.shell.AbsolutePath(git) = %v", err)
+	}
+
+	hookPath := filepath.Join(t.TempDir(), "pre-command.py")
+	if err := os.WriteFile(hookPath, []byte("print('llamas')\n"), 0o755); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", hookPath, err)
+	}
+
+	hookCfg := HookConfig{Scope: "global", Name: "pre-command", Path: hookPath}
+	if err := e.runHook(ctx, "global pre-command", hook.TypeShell, hookCfg); err != nil {
+		t.Fatalf("e.runHook(ctx, global pre-command, %q, hookCfg) = %v", hook.TypeShell, err)
+	}
+
+	wantLog := [][]string{{absoluteGit, hookPath}}
+	if diff := cmp.Diff(gotLog, wantLog); diff != "" {
+		t.Errorf("executed commands diff (-got +want):\n%s", diff)
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/shellwords"
 )
@@ -29,20 +32,101 @@ const (
 	gitErrorCleanSubmodules
 )
 
+// Kinds of failure of git commands that talk to a remote, judging by their
+// output.
+const (
+	gitFailureUnknown = iota
+
+	// Network errors, timeouts and server errors, which are worth retrying
+	gitFailureTransient
+
+	// Authentication and permission errors, which aren't
+	gitFailureAuth
+)
+
 var (
 	errNoHostname = errors.New("no hostname found")
 	errInvalidRef = errors.New("is not a valid git ref format")
 )
 
+// Output from git (or ssh, or curl within git) that shows a remote operation
+// failed because of a network or server problem.
+var gitTransientFailureMessages = []string{
+	"early EOF",
+	"The remote end hung up unexpectedly",
+	"unexpected disconnect while reading sideband packet",
+	"RPC failed",
+	"Could not resolve host",
+	"Could not resolve hostname",
+	"Connection timed out",
+	"Operation timed out",
+	"Connection reset by peer",
+	"Connection refused",
+	"Failed to connect to",
+	"The requested URL returned error: 429",
+	"The requested URL returned error: 500",
+	"The requested URL returned error: 502",
+	"The requested URL returned error: 503",
+	"The requested URL returned error: 504",
+}
+
+// Output from git (or ssh, or curl within git) that shows a remote operation
+// failed because of missing or bad credentials. Some of these are also shown
+// for repositories that don't exist, which isn't worth retrying either.
+var gitAuthFailureMessages = []string{
+	"Authentication failed",
+	"could not read Username",
+	"could not read Password",
+	"Permission denied (publickey",
+	"Host key verification failed",
+	"Repository not found",
+	"The requested URL returned error: 401",
+	"The requested URL returned error: 403",
+}
+
 type gitError struct {
 	error
 	Type int
+
+	// For commands that talk to a remote, the kind of failure
+	Failure int
 }
 
 func (e *gitError) Unwrap() error {
 	return e.error
 }
 
+// gitRemoteSmells returns the output to search for with shell.WithStringSearch
+// to find out why a git command that talks to a remote failed, with
+// gitRemoteFailure.
+func gitRemoteSmells() map[string]bool {
+	smelt := make(map[string]bool, len(gitTransientFailureMessages)+len(gitAuthFailureMessages))
+	for _, msg := range gitTransientFailureMessages {
+		smelt[msg] = false
+	}
+	for _, msg := range gitAuthFailureMessages {
+		smelt[msg] = false
+	}
+	return smelt
+}
+
+// gitRemoteFailure returns the kind of failure found by gitRemoteSmells.
+// Authentication errors take precedence, as they're often followed by the
+// remote end hanging up.
+func gitRemoteFailure(smelt map[string]bool) int {
+	for _, msg := range gitAuthFailureMessages {
+		if smelt[msg] {
+			return gitFailureAuth
+		}
+	}
+	for _, msg := range gitTransientFailureMessages {
+		if smelt[msg] {
+			return gitFailureTransient
+		}
+	}
+	return gitFailureUnknown
+}
+
 func gitCheckout(ctx context.Context, sh *shell.Shell, gitCheckoutFlags, reference string) error {
 	individualCheckoutFlags, err := shellwords.Split(gitCheckoutFlags)
 	if err != nil {
@@ -86,8 +170,9 @@ func gitClone(ctx context.Context, sh *shell.Shell, gitCloneFlags, repository, d
 	commandArgs = append(commandArgs, individualCloneFlags...)
 	commandArgs = append(commandArgs, "--", repository, dir)
 
-	if err := sh.Command("git", commandArgs...).Run(ctx); err != nil {
-		return &gitError{error: err, Type: gitErrorClone}
+	smelt := gitRemoteSmells()
+	if err := sh.Command("git", commandArgs...).Run(ctx, shell.WithStringSearch(smelt)); err != nil {
+		return &gitError{error: err, Type: gitErrorClone, Failure: gitRemoteFailure(smelt)}
 	}
 
 	return nil
@@ -152,13 +237,14 @@ func gitFetch(
 	const badObject = "fatal: bad object"
 	const badReference = "fatal: couldn't find remote ref"
 	const badReferencePreGit221 = "fatal: Couldn't find remote ref"
-	smelt := map[string]bool{
-		badObject:             false,
-		badReference:          false,
-		badReferencePreGit221: false,
-	}
+	smelt := gitRemoteSmells()
+	smelt[badObject] = false
+	smelt[badReference] = false
+	smelt[badReferencePreGit221] = false
 
 	if err := sh.Command("git", commandArgs...).Run(ctx, shell.WithStringSearch(smelt)); err != nil {
+		failure := gitRemoteFailure(smelt)
+
 		// "fatal: bad object" can happen when the local repo in the checkout
 		// directory is corrupted, not just the remote or the mirror.
 		// When using git mirrors, the existing checkout directory might have a
@@ -166,12 +252,18 @@ func gitFetch(
 		// no longer contains it (for whatever reason).
 		// See the NOTE under --shared at https://git-scm.com/docs/git-clone.
 		if smelt[badObject] {
-			return &gitError{error: err, Type: gitErrorFetchBadObject}
+			return &gitError{error: err, Type: gitErrorFetchBadObject, Failure: failure}
 		}
 
 		// "fatal: [Cc]ouldn't find remote ref" can happen when just the short commit hash is given.
 		if smelt[badReference] || smelt[badReferencePreGit221] {
-			return &gitError{error: err, Type: gitErrorFetchBadReference}
+			return &gitError{error: err, Type: gitErrorFetchBadReference, Failure: failure}
+		}
+
+		// Network and authentication errors don't mean the checkout is
+		// corrupted, so there's no need to clean it before retrying.
+		if failure != gitFailureUnknown {
+			return &gitError{error: err, Type: gitErrorFetch, Failure: failure}
 		}
 
 		// 128 is extremely broad, but it seems permissions errors, network unreachable errors etc,
@@ -214,6 +306,177 @@ func gitEnumerateSubmoduleURLs(ctx context.Context, sh *shell.Shell) ([]string,
 	return urls, nil
 }
 
+// gitSubmodule is a submodule listed in .gitmodules.
+type gitSubmodule struct {
+	name, path, url string
+}
+
+// gitEnumerateSubmodules returns the submodules listed in .gitmodules, in the
+// order they're listed.
+func gitEnumerateSubmodules(ctx context.Context, sh *shell.Shell) ([]gitSubmodule, error) {
+	// The output is in the same form as for gitEnumerateSubmoduleURLs
+	output, err := sh.Command("git", "config", "--file", ".gitmodules", "--null", "--get-regexp", `submodule\..+\.(path|url)`).RunAndCaptureStdout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var submodules []gitSubmodule
+	for _, line := range strings.Split(strings.TrimRight(output, "\x00"), "\x00") {
+		key, value, ok := strings.Cut(line, "\n")
+		if !ok {
+			return nil, fmt.Errorf("Failed to parse .gitmodules line %q", line)
+		}
+		// Names can contain dots, but keys can't
+		name, field := strings.TrimPrefix(key, "submodule."), ""
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name, field = name[:i], name[i+1:]
+		}
+
+		i := slices.IndexFunc(submodules, func(sm gitSubmodule) bool { return sm.name == name })
+		if i < 0 {
+			i = len(submodules)
+			submodules = append(submodules, gitSubmodule{name: name})
+		}
+		switch field {
+		case "path":
+			submodules[i].path = value
+		case "url":
+			submodules[i].url = value
+		}
+	}
+	return submodules, nil
+}
+
+// gitURLRule is a value that applies to repositories whose URLs start with
+// prefix.
+type gitURLRule struct {
+	prefix, value string
+}
+
+// parseGitURLRules parses rules of the form <url prefix>=<value>.
+func parseGitURLRules(rules []string) ([]gitURLRule, error) {
+	var parsed []gitURLRule
+	for _, rule := range rules {
+		if rule == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(rule, "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("%q should be of the form <url prefix>=<value>", rule)
+		}
+		parsed = append(parsed, gitURLRule{prefix: prefix, value: value})
+	}
+	return parsed, nil
+}
+
+// gitSubmoduleCloneFlags returns the flags of the first rule that matches the
+// submodule URL, split into arguments.
+func gitSubmoduleCloneFlags(rules []gitURLRule, url string) ([]string, error) {
+	for _, rule := range rules {
+		if strings.HasPrefix(url, rule.prefix) {
+			return shellwords.Split(rule.value)
+		}
+	}
+	return nil, nil
+}
+
+var (
+	gitCredentialUsernamePattern = regexp.MustCompile(`^[\w.@+-]+$`)
+	envVarNamePattern            = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// gitCredentialConfig returns git config (for passing with -c) that has git
+// use tokens from environment variables for HTTPS URLs, given rules of the
+// form <url>=[<username>:]<environment variable>. The username defaults to
+// x-access-token. As with git's own credential config, a URL with a path
+// only matches repositories at that path, and a URL without one matches every
+// repository on the host. The tokens are read when git needs them, so they
+// don't appear in the config or the job log.
+func gitCredentialConfig(rules []string) ([]string, error) {
+	parsed, err := parseGitURLRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var config []string
+	for _, rule := range parsed {
+		username, envVar, ok := strings.Cut(rule.value, ":")
+		if !ok {
+			username, envVar = "x-access-token", rule.value
+		}
+		if !gitCredentialUsernamePattern.MatchString(username) {
+			return nil, fmt.Errorf("invalid username %q in git credentials for %s", username, rule.prefix)
+		}
+		if !envVarNamePattern.MatchString(envVar) {
+			return nil, fmt.Errorf("invalid environment variable name %q in git credentials for %s", envVar, rule.prefix)
+		}
+
+		helper := fmt.Sprintf(`!f() { test "$1" = get && echo username=%s && echo "password=$%s"; }; f`, username, envVar)
+		config = append(config,
+			// An empty helper stops git from trying any helpers configured
+			// before this one (such as the global one) first
+			"credential."+rule.prefix+".helper=",
+			"credential."+rule.prefix+".helper="+helper,
+		)
+	}
+	return config, nil
+}
+
+// addGitConfigEnv adds config (of the form key=value) to environ as
+// GIT_CONFIG_COUNT, GIT_CONFIG_KEY_<n> and GIT_CONFIG_VALUE_<n>, after any
+// config already there, so that git commands run with environ use it (with
+// git 2.31 or later). It returns a function that undoes this.
+func addGitConfigEnv(environ *env.Environment, config []string) (undo func()) {
+	if len(config) == 0 {
+		return func() {}
+	}
+
+	oldCount, hadCount := environ.Get("GIT_CONFIG_COUNT")
+	n, err := strconv.Atoi(oldCount)
+	if err != nil || n < 0 {
+		n = 0
+	}
+	start := n
+
+	for _, c := range config {
+		key, value, _ := strings.Cut(c, "=")
+		environ.Set(fmt.Sprintf("GIT_CONFIG_KEY_%d", n), key)
+		environ.Set(fmt.Sprintf("GIT_CONFIG_VALUE_%d", n), value)
+		n++
+	}
+	environ.Set("GIT_CONFIG_COUNT", strconv.Itoa(n))
+
+	return func() {
+		for i := start; i < n; i++ {
+			environ.Remove(fmt.Sprintf("GIT_CONFIG_KEY_%d", i))
+			environ.Remove(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+		}
+		if hadCount {
+			environ.Set("GIT_CONFIG_COUNT", oldCount)
+		} else {
+			environ.Remove("GIT_CONFIG_COUNT")
+		}
+	}
+}
+
+// ValidateGitSubmoduleConfig returns an error if the per-submodule clone flags
+// or credentials can't be parsed.
+func ValidateGitSubmoduleConfig(cloneFlags, credentials []string) error {
+	rules, err := parseGitURLRules(cloneFlags)
+	if err != nil {
+		return fmt.Errorf("invalid git submodule clone flags: %w", err)
+	}
+	for _, rule := range rules {
+		if _, err := shellwords.Split(rule.value); err != nil {
+			return fmt.Errorf("invalid git submodule clone flags for %s: %w", rule.prefix, err)
+		}
+	}
+	if _, err := gitCredentialConfig(credentials); err != nil {
+		return fmt.Errorf("invalid git submodule credentials: %w", err)
+	}
+	return nil
+}
+
 func gitRevParseInWorkingDirectory(ctx context.Context, sh *shell.Shell, workingDirectory string, extraRevParseArgs ...string) (string, error) {
 	gitDirectory := filepath.Join(workingDirectory, ".git")
 
@@ -3,9 +3,14 @@ package job
 import (
 	"context"
 	"errors"
+	"math"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/google/go-cmp/cmp"
 )
@@ -306,3 +311,233 @@ func TestGitFetch(t *testing.T) {
 		t.Errorf("executed commands diff (-got +want):\n%s", diff)
 	}
 }
+
+func TestHasGitLFS(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		attributes string
+		want       bool
+	}{
+		{
+			name: "no .gitattributes",
+			want: false,
+		},
+		{
+			name:       "no lfs filters",
+			attributes: "*.go text eol=lf\n",
+			want:       false,
+		},
+		{
+			name:       "lfs filter",
+			attributes: "*.go text eol=lf\n*.psd filter=lfs diff=lfs merge=lfs -text\n",
+			want:       true,
+		},
+		{
+			name:       "commented out lfs filter",
+			attributes: "# *.psd filter=lfs diff=lfs merge=lfs -text\n",
+			want:       false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			if test.attributes != "" {
+				if err := os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte(test.attributes), 0o600); err != nil {
+					t.Fatalf("os.WriteFile(.gitattributes) error = %v", err)
+				}
+			}
+
+			sh := shell.NewTestShell(t)
+			if err := sh.Chdir(dir); err != nil {
+				t.Fatalf("sh.Chdir(%q) error = %v", dir, err)
+			}
+
+			if got := hasGitLFS(sh); got != test.want {
+				t.Errorf("hasGitLFS(sh) = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGitRemoteFailure(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		output []string
+		want   int
+	}{
+		{
+			name:   "unknown",
+			output: nil,
+			want:   gitFailureUnknown,
+		},
+		{
+			name:   "early EOF",
+			output: []string{"fatal: early EOF"},
+			want:   gitFailureTransient,
+		},
+		{
+			name:   "server error",
+			output: []string{"The requested URL returned error: 503"},
+			want:   gitFailureTransient,
+		},
+		{
+			name:   "bad credentials",
+			output: []string{"fatal: Authentication failed for 'https://github.com/buildkite/agent.git/'"},
+			want:   gitFailureAuth,
+		},
+		{
+			name:   "bad credentials, then hung up",
+			output: []string{"Permission denied (publickey).", "The remote end hung up unexpectedly"},
+			want:   gitFailureAuth,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Simulate what shell.WithStringSearch does with the output
+			smelt := gitRemoteSmells()
+			for msg := range smelt {
+				for _, line := range test.output {
+					if strings.Contains(line, msg) {
+						smelt[msg] = true
+					}
+				}
+			}
+
+			if got := gitRemoteFailure(smelt); got != test.want {
+				t.Errorf("gitRemoteFailure(smelt for %q) = %d, want %d", test.output, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckoutRetryInterval(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		backoff  time.Duration
+		attempts int
+		min, max time.Duration
+	}{
+		{backoff: 0, attempts: 3, min: 0, max: 0},
+		{backoff: 2 * time.Second, attempts: 0, min: 2 * time.Second, max: 4 * time.Second},
+		{backoff: 2 * time.Second, attempts: 2, min: 8 * time.Second, max: 10 * time.Second},
+		{backoff: 2 * time.Second, attempts: 10, min: time.Minute, max: time.Minute + 2*time.Second},
+		{backoff: 2 * time.Minute, attempts: 10, min: 2 * time.Minute, max: 3 * time.Minute},
+		{backoff: 2 * time.Second, attempts: 1000, min: time.Minute, max: time.Minute + 2*time.Second},
+		{backoff: math.MaxInt64 / 2, attempts: 64, min: math.MaxInt64 / 2, max: math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		got := checkoutRetryInterval(test.backoff, test.attempts)
+		if got < test.min || got > test.max {
+			t.Errorf("checkoutRetryInterval(%v, %d) = %v, want between %v and %v", test.backoff, test.attempts, got, test.min, test.max)
+		}
+	}
+}
+
+func TestGitSubmoduleCloneFlags(t *testing.T) {
+	t.Parallel()
+
+	rules, err := parseGitURLRules([]string{
+		"https://github.com/org/big-repo=--depth 1 --single-branch",
+		"",
+		"https://github.com/org/=--filter=blob:none",
+	})
+	if err != nil {
+		t.Fatalf("parseGitURLRules() error = %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "https://github.com/org/big-repo.git", want: []string{"--depth", "1", "--single-branch"}},
+		{url: "https://github.com/org/other.git", want: []string{"--filter=blob:none"}},
+		{url: "https://gitlab.example.com/org/repo.git", want: nil},
+	}
+	for _, test := range tests {
+		got, err := gitSubmoduleCloneFlags(rules, test.url)
+		if err != nil {
+			t.Errorf("gitSubmoduleCloneFlags(rules, %q) error = %v", test.url, err)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("gitSubmoduleCloneFlags(rules, %q) diff (-got +want):\n%s", test.url, diff)
+		}
+	}
+
+	if _, err := parseGitURLRules([]string{"--depth 1"}); err == nil {
+		t.Errorf("parseGitURLRules(%q) = nil error, want error", "--depth 1")
+	}
+}
+
+func TestGitCredentialConfig(t *testing.T) {
+	t.Parallel()
+
+	got, err := gitCredentialConfig([]string{
+		"https://gitlab.example.com=oauth2:GITLAB_TOKEN",
+		"https://github.com/org/repo.git=GITHUB_TOKEN",
+	})
+	if err != nil {
+		t.Fatalf("gitCredentialConfig() error = %v", err)
+	}
+	want := []string{
+		"credential.https://gitlab.example.com.helper=",
+		`credential.https://gitlab.example.com.helper=!f() { test "$1" = get && echo username=oauth2 && echo "password=$GITLAB_TOKEN"; }; f`,
+		"credential.https://github.com/org/repo.git.helper=",
+		`credential.https://github.com/org/repo.git.helper=!f() { test "$1" = get && echo username=x-access-token && echo "password=$GITHUB_TOKEN"; }; f`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("gitCredentialConfig() diff (-got +want):\n%s", diff)
+	}
+
+	for _, rule := range []string{
+		"https://gitlab.example.com=TOKEN; rm -rf /",
+		"https://gitlab.example.com=us'er:TOKEN",
+	} {
+		if _, err := gitCredentialConfig([]string{rule}); err == nil {
+			t.Errorf("gitCredentialConfig(%q) = nil error, want error", rule)
+		}
+	}
+}
+
+func TestAddGitConfigEnv(t *testing.T) {
+	t.Parallel()
+
+	environ := env.FromSlice([]string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=core.autocrlf",
+		"GIT_CONFIG_VALUE_0=false",
+	})
+	before := environ.Copy()
+
+	undo := addGitConfigEnv(environ, []string{"credential.https://example.com.helper=", "a.b=c=d"})
+
+	want := map[string]string{
+		"GIT_CONFIG_COUNT":   "3",
+		"GIT_CONFIG_KEY_0":   "core.autocrlf",
+		"GIT_CONFIG_VALUE_0": "false",
+		"GIT_CONFIG_KEY_1":   "credential.https://example.com.helper",
+		"GIT_CONFIG_VALUE_1": "",
+		"GIT_CONFIG_KEY_2":   "a.b",
+		"GIT_CONFIG_VALUE_2": "c=d",
+	}
+	if diff := cmp.Diff(environ.Dump(), want); diff != "" {
+		t.Errorf("environment after addGitConfigEnv diff (-got +want):\n%s", diff)
+	}
+
+	undo()
+	if diff := cmp.Diff(environ.Dump(), before.Dump()); diff != "" {
+		t.Errorf("environment after undo diff (-got +want):\n%s", diff)
+	}
+}
@@ -8,17 +8,20 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/buildkite/agent/v3/internal/osutil"
 	"github.com/buildkite/agent/v3/internal/shell"
 )
 
 // Find returns the absolute path to the best matching hook file in a path, or
-// os.ErrNotExist if none is found
-func Find(hookDir string, name string) (string, error) {
+// os.ErrNotExist if none is found. On Windows, hooks with any of the extra
+// extensions (such as those with an interpreter) are also found.
+func Find(hookDir string, name string, extraExts ...string) (string, error) {
 	if runtime.GOOS == "windows" {
 		// check for windows types first
-		if p, err := shell.LookPath(name, hookDir, ".BAT;.CMD;.PS1;.EXE"); err == nil {
+		exts := append([]string{".BAT", ".CMD", ".PS1", ".EXE"}, extraExts...)
+		if p, err := shell.LookPath(name, hookDir, strings.Join(exts, ";")); err == nil {
 			return p, nil
 		}
 	}
@@ -0,0 +1,33 @@
+package hook
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// DefaultInterpreters are the interpreters for script hooks on Windows, by
+// file extension. Windows can't run a script using its shebang line, so script
+// hooks are run with the interpreter for their extension instead. Elsewhere,
+// hooks without a shebang are.
+var DefaultInterpreters = map[string]string{
+	".ps1": "pwsh",
+	".py":  "python",
+	".rb":  "ruby",
+}
+
+// ParseInterpreters parses interpreter mappings of the form ".py=python3", and
+// returns them merged over DefaultInterpreters. Extensions are case-insensitive.
+func ParseInterpreters(mappings []string) (map[string]string, error) {
+	interpreters := maps.Clone(DefaultInterpreters)
+	for _, m := range mappings {
+		ext, interpreter, ok := strings.Cut(m, "=")
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		interpreter = strings.TrimSpace(interpreter)
+		if !ok || !strings.HasPrefix(ext, ".") || len(ext) < 2 || interpreter == "" {
+			return nil, fmt.Errorf("invalid hook interpreter %q, must be of the form .ext=interpreter", m)
+		}
+		interpreters[ext] = interpreter
+	}
+	return interpreters, nil
+}
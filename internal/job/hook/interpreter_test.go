@@ -0,0 +1,35 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseInterpreters(t *testing.T) {
+	t.Parallel()
+
+	got, err := ParseInterpreters([]string{".py=python3", ".JS = node"})
+	if err != nil {
+		t.Fatalf("ParseInterpreters() error = %v", err)
+	}
+	want := map[string]string{
+		".ps1": "pwsh",
+		".py":  "python3",
+		".rb":  "ruby",
+		".js":  "node",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ParseInterpreters() diff (-got +want):\n%s", diff)
+	}
+
+	if DefaultInterpreters[".py"] != "python" {
+		t.Errorf("ParseInterpreters() modified DefaultInterpreters: %v", DefaultInterpreters)
+	}
+
+	for _, bad := range []string{"py=python", ".py", ".py=", "=python", ".=python"} {
+		if _, err := ParseInterpreters([]string{bad}); err == nil {
+			t.Errorf("ParseInterpreters([%q]) error = nil, want an error", bad)
+		}
+	}
+}
@@ -77,10 +77,11 @@ func TestHookType(t *testing.T) {
 		},
 	}
 
+	binDir := t.TempDir()
 	for _, operatingSystem := range []string{"linux", "darwin", "windows"} {
 		for _, arch := range []string{"amd64", "arm64"} {
 			binaryName := fmt.Sprintf("test-binary-%s-%s", operatingSystem, arch)
-			binaryPath := filepath.Join(os.TempDir(), binaryName)
+			binaryPath := filepath.Join(binDir, binaryName)
 			sourcePath := hookFixture(rootDir)
 
 			cmd := exec.Command("go", "build", "-o", binaryPath, sourcePath)
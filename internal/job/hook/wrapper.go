@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"text/template"
 
 	"github.com/buildkite/agent/v3/env"
@@ -45,15 +46,15 @@ SET BUILDKITE_HOOK_WORKING_DIR=%CD%
 EXIT %BUILDKITE_HOOK_EXIT_STATUS%`
 
 	powershellWrapper = `$ErrorActionPreference = "STOP"
-{{.AgentBinary}} env dump | Set-Content "{{.BeforeEnvFileName}}"
-. {{.PathToHook}}
+& {{pwshQuote .AgentBinary}} env dump | Set-Content {{pwshQuote .BeforeEnvFileName}}
+. {{pwshQuote .PathToHook}}
 if ($LASTEXITCODE -eq $null) {
   $Env:BUILDKITE_HOOK_EXIT_STATUS = 0
 } else {
   $Env:BUILDKITE_HOOK_EXIT_STATUS = $LASTEXITCODE
 }
 $Env:BUILDKITE_HOOK_WORKING_DIR = $PWD | Select-Object -ExpandProperty Path
-{{.AgentBinary}} env dump | Set-Content "{{.AfterEnvFileName}}"
+& {{pwshQuote .AgentBinary}} env dump | Set-Content {{pwshQuote .AfterEnvFileName}}
 exit $Env:BUILDKITE_HOOK_EXIT_STATUS`
 
 	posixShellWrapper = `{{if .ShebangLine}}{{.ShebangLine}}
@@ -68,7 +69,7 @@ exit $BUILDKITE_HOOK_EXIT_STATUS`
 
 var (
 	batchWrapperTmpl      = template.Must(template.New("batch").Parse(batchWrapper))
-	powershellWrapperTmpl = template.Must(template.New("pwsh").Parse(powershellWrapper))
+	powershellWrapperTmpl = template.Must(template.New("pwsh").Funcs(template.FuncMap{"pwshQuote": pwshQuote}).Parse(powershellWrapper))
 	posixShellWrapperTmpl = template.Must(template.New("bash").Parse(posixShellWrapper))
 
 	ErrNoHookPath = errors.New("hook path was not provided")
@@ -170,11 +171,16 @@ func NewWrapper(opts ...WrapperOpt) (*Wrapper, error) {
 	// the wrapper won't work. We do support ruby (and other interpreted) hooks via polyglot hooks
 	// (see: https://github.com/buildkite/agent/pull/2040),
 	// but they should never be wrapped, and if they have been, something has gone wrong.
-	if shebang != "" && !shellscript.IsPOSIXShell(shebang) {
+	//
+	// PowerShell hooks are the exception: they may have a shebang (so they can
+	// be run directly on Linux and macOS), but the shebang is only a comment to
+	// PowerShell, and they are wrapped with a PowerShell wrapper on any OS.
+	isPwshHook := strings.EqualFold(filepath.Ext(wrap.hookPath), ".ps1")
+	if shebang != "" && !shellscript.IsPOSIXShell(shebang) && !isPwshHook {
 		return nil, fmt.Errorf("scriptwrapper tried to wrap hook with invalid shebang: %q", shebang)
 	}
 
-	var isPOSIXHook, isPwshHook bool
+	var isPOSIXHook bool
 
 	scriptFileName := "hook-script-wrapper"
 	isWindows := wrap.os == "windows"
@@ -182,8 +188,7 @@ func NewWrapper(opts ...WrapperOpt) (*Wrapper, error) {
 	// we use bash hooks for scripts with no extension, otherwise on windows
 	// we probably need a .bat extension
 	switch {
-	case filepath.Ext(wrap.hookPath) == ".ps1":
-		isPwshHook = true
+	case isPwshHook:
 		scriptFileName += ".ps1"
 
 	case filepath.Ext(wrap.hookPath) == "":
@@ -195,10 +200,10 @@ func NewWrapper(opts ...WrapperOpt) (*Wrapper, error) {
 
 	var templateType TemplateType
 	switch {
-	case isWindows && !isPOSIXHook && !isPwshHook:
-		templateType = BatchTemplateType
-	case isWindows && isPwshHook:
+	case isPwshHook:
 		templateType = PowershellTemplateType
+	case isWindows && !isPOSIXHook:
+		templateType = BatchTemplateType
 	default:
 		templateType = PosixShellTemplateType
 	}
@@ -263,6 +268,12 @@ func NewWrapper(opts ...WrapperOpt) (*Wrapper, error) {
 	return wrap, nil
 }
 
+// pwshQuote quotes s as a PowerShell string literal. In single quotes, nothing
+// is expanded, and a single quote is written as two.
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 // WriteHookWrapper will write a hook wrapper script to a temporary file with the same extension as,
 // `hookWrapperName`. It will return the name of the temporary file. The file will be executable.
 // It will be created from the template specified by `templateType` with data from `input`.
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/buildkite/agent/v3/env"
@@ -216,6 +217,46 @@ func TestScriptWrapperFailsOnHookWithInvalidShebang(t *testing.T) {
 	assert.Error(t, err, `scriptwrapper tried to wrap hook with invalid shebang: "#!/usr/bin/env ruby"`)
 }
 
+func TestPowershellHookIsWrappedWithPowershellOnAnyOS(t *testing.T) {
+	t.Parallel()
+
+	hookFilename := writeTestHook(t, "hook.ps1", `#!/usr/bin/env pwsh
+$env:LLAMAS = "rock"
+`)
+	wrapper, err := hook.NewWrapper(hook.WithPath(hookFilename), hook.WithOS("linux"))
+	assert.NilError(t, err, "failed to create hook wrapper: %v", err)
+	t.Cleanup(wrapper.Close)
+
+	assert.Equal(t, filepath.Ext(wrapper.Path()), ".ps1")
+
+	contents, err := os.ReadFile(wrapper.Path())
+	assert.NilError(t, err, "os.ReadFile(%q) = %v", wrapper.Path(), err)
+
+	absHookPath, err := filepath.Abs(hookFilename)
+	assert.NilError(t, err, "filepath.Abs(%q) = %v", hookFilename, err)
+
+	wantLine := `. '` + absHookPath + `'`
+	assert.Assert(t, strings.Contains(string(contents), wantLine), "wrapper %q does not contain %q:\n%s", wrapper.Path(), wantLine, contents)
+}
+
+func TestPowershellWrapperQuotesPaths(t *testing.T) {
+	t.Parallel()
+
+	path, err := hook.WriteHookWrapper(hook.PowershellTemplateType, hook.WrapperTemplateInput{
+		AgentBinary:       "/opt/buildkite-agent",
+		BeforeEnvFileName: "/tmp/before",
+		AfterEnvFileName:  "/tmp/after",
+		PathToHook:        "/hooks/$HOME/it's `here`.ps1",
+	}, t.TempDir(), "hook-script-wrapper.ps1")
+	assert.NilError(t, err, "hook.WriteHookWrapper(...) = %v", err)
+
+	contents, err := os.ReadFile(path)
+	assert.NilError(t, err, "os.ReadFile(%q) = %v", path, err)
+
+	wantLine := ". '/hooks/$HOME/it''s `here`.ps1'"
+	assert.Assert(t, strings.Contains(string(contents), wantLine), "wrapper %q does not contain %q:\n%s", path, wantLine, contents)
+}
+
 func writeTestHook(t *testing.T, fileName, content string) string {
 	t.Helper()
 
@@ -29,7 +29,7 @@ func TestArtifactsUploadAfterCommand(t *testing.T) {
 	// Mock out the artifact calls
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 	agent.
 		Expect("artifact", "upload", "llamas.txt").
@@ -58,7 +58,7 @@ func TestArtifactsUploadAfterCommandFails(t *testing.T) {
 	// Mock out the artifact calls
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 	agent.
 		Expect("artifact", "upload", "llamas.txt").
@@ -93,7 +93,7 @@ func TestArtifactsUploadAfterCommandHookFails(t *testing.T) {
 	// Mock out the artifact calls
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 	agent.
 		Expect("artifact", "upload", "llamas.txt").
@@ -105,3 +105,30 @@ func TestArtifactsUploadAfterCommandHookFails(t *testing.T) {
 
 	tester.CheckMocks(t)
 }
+
+func TestArtifactsUploadWithInvalidPathsFails(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+
+	// The paths are rejected before artifact upload is run
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+	agent.
+		Expect("artifact", "upload", bintest.MatchAny()).
+		NotCalled()
+
+	if err := tester.Run(t, "BUILDKITE_ARTIFACT_PATHS=llamas.{txt"); err == nil {
+		t.Fatalf("tester.Run(BUILDKITE_ARTIFACT_PATHS=llamas.{txt) = %v, want non-nil error", err)
+	}
+
+	tester.CheckMocks(t)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -53,8 +54,8 @@ func TestCheckingOutGitHubPullRequests_WithGitMirrors(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -99,8 +100,8 @@ func TestWithResolvingCommitExperiment_WithGitMirrors(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -143,12 +144,111 @@ func TestCheckingOutLocalGitProject_WithGitMirrors(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
 
+func TestCheckingOutLocalGitProjectAsWorktree_WithGitMirrors(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	if err := tester.EnableGitMirrors(); err != nil {
+		t.Fatalf("EnableGitMirrors() error = %v", err)
+	}
+
+	env := []string{
+		"BUILDKITE_GIT_CLONE_MIRROR_FLAGS=--config pack.threads=35",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_MIRRORS_WORKTREE=true",
+	}
+
+	// Actually execute git commands, but with expectations
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	// But assert which ones are called
+	git.ExpectAll([][]any{
+		{"clone", "--mirror", "--config", "pack.threads=35", "--", tester.Repo.Path, matchSubDir(tester.GitMirrorsDir)},
+		{"--git-dir", matchSubDir(tester.GitMirrorsDir), "worktree", "prune"},
+		{"--git-dir", matchSubDir(tester.GitMirrorsDir), "worktree", "add", "--force", "--detach", tester.CheckoutDir(), "refs/heads/main"},
+		{"clean", "-fdq"},
+		{"--no-pager", "log", "-1", "HEAD", "-s", "--no-color", gitShowFormatArg},
+		{"--git-dir", matchSubDir(tester.GitMirrorsDir), "worktree", "remove", "--force", tester.CheckoutDir()},
+	})
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+
+	if _, err := os.Stat(tester.CheckoutDir()); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) error = %v, want not exist", tester.CheckoutDir(), err)
+	}
+}
+
+func TestCheckingOutToEphemeralBuildDir_WithGitMirrors(t *testing.T) {
+	t.Parallel()
+
+	// Mounting a tmpfs needs Linux, and the privileges to mount things
+	probe := t.TempDir()
+	if runtime.GOOS != "linux" || exec.Command("mount", "-t", "tmpfs", "tmpfs", probe).Run() != nil {
+		t.Skip("can't mount a tmpfs")
+	}
+	if err := exec.Command("umount", probe).Run(); err != nil {
+		t.Fatalf("umount %q error = %v", probe, err)
+	}
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	if err := tester.EnableGitMirrors(); err != nil {
+		t.Fatalf("EnableGitMirrors() error = %v", err)
+	}
+
+	tester.MustMock(t, "git").PassthroughToLocalCommand().Expect().AtLeastOnce().WithAnyArguments()
+
+	// Leave something behind in the checkout, which should be discarded
+	tester.ExpectGlobalHook("command").Once().AndCallFunc(func(c *bintest.Call) {
+		if err := os.WriteFile(filepath.Join(c.Dir, "llamas.txt"), []byte("so many llamas"), 0o600); err != nil {
+			fmt.Fprintf(c.Stderr, "os.WriteFile(llamas.txt) error = %v\n", err) //nolint:errcheck // test helper
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t, "BUILDKITE_EPHEMERAL_BUILD_DIR=true")
+
+	entries, err := os.ReadDir(tester.CheckoutDir())
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) error = %v", tester.CheckoutDir(), err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("checkout directory has %d entries after the job, want 0", len(entries))
+	}
+
+	mirrors, err := os.ReadDir(tester.GitMirrorsDir)
+	if err != nil {
+		t.Fatalf("os.ReadDir(%q) error = %v", tester.GitMirrorsDir, err)
+	}
+	if len(mirrors) == 0 {
+		t.Errorf("git mirrors directory is empty after the job, want the mirror to be kept")
+	}
+}
+
 func TestCheckingOutLocalGitProjectWithSubmodules_WithGitMirrors(t *testing.T) {
 	t.Parallel()
 
@@ -215,8 +315,8 @@ func TestCheckingOutLocalGitProjectWithSubmodules_WithGitMirrors(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -281,8 +381,8 @@ func TestCheckingOutLocalGitProjectWithSubmodulesDisabled_WithGitMirrors(t *test
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -325,8 +425,8 @@ func TestCheckingOutShallowCloneOfLocalGitProject_WithGitMirrors(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -345,8 +445,8 @@ func TestCheckingOutSetsCorrectGitMetadataAndSendsItToBuildkite_WithGitMirrors(t
 	}
 
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t)
 }
@@ -467,7 +567,7 @@ func TestCleaningAnExistingCheckout_WithGitMirrors(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t)
@@ -497,7 +597,7 @@ func TestForcingACleanCheckout_WithGitMirrors(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t, "BUILDKITE_CLEAN_CHECKOUT=true")
@@ -532,7 +632,7 @@ func TestCheckoutOnAnExistingRepositoryWithoutAGitFolder_WithGitMirrors(t *testi
 
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t)
@@ -715,8 +815,8 @@ func TestGitMirrorEnv(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 
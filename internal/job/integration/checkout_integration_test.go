@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync/atomic"
@@ -65,8 +66,8 @@ func TestWithResolvingCommitExperiment(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -104,8 +105,8 @@ func TestCheckingOutLocalGitProject(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -170,8 +171,76 @@ func TestCheckingOutLocalGitProjectWithSubmodules(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutLocalGitProjectWithSubmoduleCloneFlags(t *testing.T) {
+	t.Parallel()
+
+	// Git for windows seems to struggle with local submodules in the temp dir
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	submoduleRepo, err := createTestGitRespository()
+	if err != nil {
+		t.Fatalf("createTestGitRepository() error = %v", err)
+	}
+	defer submoduleRepo.Close()
+
+	out, err := tester.Repo.Execute("-c", "protocol.file.allow=always", "submodule", "add", submoduleRepo.Path)
+	if err != nil {
+		t.Fatalf("tester.Repo.Execute(submodule, add, %q) error = %v\nout = %s", submoduleRepo.Path, err, out)
+	}
+
+	out, err = tester.Repo.Execute("commit", "-am", "Add example submodule")
+	if err != nil {
+		t.Fatalf(`tester.Repo.Execute(commit, -am, "Add example submodule") error = %v\nout = %s`, err, out)
+	}
+
+	env := []string{
+		"BUILDKITE_GIT_CLONE_FLAGS=-v",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_FETCH_FLAGS=-v",
+		"BUILDKITE_GIT_SUBMODULE_CLONE_CONFIG=protocol.file.allow=always",
+		"BUILDKITE_GIT_SUBMODULE_CLONE_FLAGS=" + submoduleRepo.Path + "=--no-single-branch",
+	}
+
+	// Actually execute git commands, but with expectations
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	// But assert which ones are called
+	git.ExpectAll([][]any{
+		{"clone", "-v", "--", tester.Repo.Path, "."},
+		{"clean", "-fdq"},
+		{"submodule", "foreach", "--recursive", "git clean -fdq"},
+		{"fetch", "-v", "--", "origin", "main"},
+		{"checkout", "-f", "FETCH_HEAD"},
+		{"submodule", "sync", "--recursive"},
+		{"config", "--file", ".gitmodules", "--null", "--get-regexp", "submodule\\..+\\.url"},
+		{"config", "--file", ".gitmodules", "--null", "--get-regexp", "submodule\\..+\\.(path|url)"},
+		{"-c", "protocol.file.allow=always", "submodule", "update", "--init", "--recursive", "--force", "--no-single-branch", "--", filepath.Base(submoduleRepo.Path)},
+		{"submodule", "foreach", "--recursive", "git reset --hard"},
+		{"clean", "-fdq"},
+		{"submodule", "foreach", "--recursive", "git clean -fdq"},
+		{"--no-pager", "log", "-1", "HEAD", "-s", "--no-color", gitShowFormatArg},
+	})
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -231,8 +300,8 @@ func TestCheckingOutLocalGitProjectWithSubmodulesDisabled(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -270,8 +339,8 @@ func TestCheckingOutShallowCloneOfLocalGitProject(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -315,8 +384,8 @@ func TestCheckingOutLocalGitProjectWithShortCommitHash(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	env := []string{
 		fmt.Sprintf("BUILDKITE_COMMIT=%s", shortCommitHash),
@@ -593,8 +662,8 @@ func TestCheckoutErrorIsRetried(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -655,8 +724,8 @@ func TestFetchErrorIsRetried(t *testing.T) {
 
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t, env...)
 }
@@ -671,8 +740,8 @@ func TestCheckingOutSetsCorrectGitMetadataAndSendsItToBuildkite(t *testing.T) {
 	defer tester.Close()
 
 	agent := tester.MockAgent(t)
-	agent.Expect("meta-data", "exists", job.CommitMetadataKey).AndExitWith(1)
-	agent.Expect("meta-data", "set", job.CommitMetadataKey).WithStdin(commitPattern)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
 
 	tester.RunAndCheck(t)
 }
@@ -777,7 +846,7 @@ func TestCleaningAnExistingCheckout(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t)
@@ -803,7 +872,7 @@ func TestForcingACleanCheckout(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t, "BUILDKITE_CLEAN_CHECKOUT=true")
@@ -834,7 +903,7 @@ func TestCheckoutOnAnExistingRepositoryWithoutAGitFolder(t *testing.T) {
 
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	tester.RunAndCheck(t)
@@ -894,6 +963,162 @@ func TestCheckoutRetriesOnCloneFailure(t *testing.T) {
 	tester.RunAndCheck(t)
 }
 
+func TestCheckoutRetriesOnTransientCloneFailure(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatalf("exec.LookPath(git) error = %v", err)
+	}
+
+	git := tester.MustMock(t, "git")
+	git.Expect().
+		WithMatcherFunc(matchGitSubcommand("clone")).
+		AndWriteToStderr("error: RPC failed; curl 18 transfer closed with outstanding read data remaining\nfatal: early EOF\n").
+		AndExitWith(128).
+		Once()
+	git.Expect().WithAnyArguments().AtLeastOnce().AndPassthroughToLocalCommand(realGit)
+
+	tester.RunAndCheck(t, "BUILDKITE_CHECKOUT_RETRY_BACKOFF=10ms")
+}
+
+func TestCheckoutDoesNotRetryOnAuthFailure(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	realGit, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatalf("exec.LookPath(git) error = %v", err)
+	}
+
+	git := tester.MustMock(t, "git")
+	git.Expect().
+		WithMatcherFunc(matchGitSubcommand("clone")).
+		AndWriteToStderr("fatal: Authentication failed for 'https://github.com/buildkite/agent.git/'\n").
+		AndExitWith(128).
+		Once()
+	git.Expect().WithAnyArguments().Min(0).Max(bintest.InfiniteTimes).AndPassthroughToLocalCommand(realGit)
+
+	if err := tester.Run(t, "BUILDKITE_CHECKOUT_RETRY_BACKOFF=10ms"); err == nil {
+		t.Fatalf("tester.Run(t) = %v, want non-nil error", err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCheckingOutLocalGitProjectFromBundle(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	bundle := filepath.Join(t.TempDir(), "repo.bundle")
+	if out, err := tester.Repo.Execute("bundle", "create", bundle, "--all"); err != nil {
+		t.Fatalf("tester.Repo.Execute(bundle, create, %q, --all) error = %v\n%s", bundle, err, out)
+	}
+
+	env := []string{
+		"BUILDKITE_GIT_CLONE_FLAGS=-v",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_FETCH_FLAGS=-v",
+		"BUILDKITE_GIT_CLONE_BUNDLE=" + bundle,
+	}
+
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	// The clone comes from the bundle, and the rest is fetched from the
+	// repository.
+	git.ExpectAll([][]any{
+		{"clone", "-v", "--", bundle, "."},
+		{"-C", ".", "remote", "set-url", "origin", tester.Repo.Path},
+		{"clean", "-fdq"},
+		{"fetch", "-v", "--", "origin", "main"},
+		{"checkout", "-f", "FETCH_HEAD"},
+		{"clean", "-fdq"},
+		{"--no-pager", "log", "-1", "HEAD", "-s", "--no-color", gitShowFormatArg},
+	})
+
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutLocalGitProjectWithMissingBundle(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	bundle := filepath.Join(t.TempDir(), "missing.bundle")
+
+	env := []string{
+		"BUILDKITE_GIT_CLONE_FLAGS=-v",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_FETCH_FLAGS=-v",
+		"BUILDKITE_GIT_CLONE_BUNDLE=" + bundle,
+	}
+
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	// Cloning from the bundle fails, so the repository is cloned instead.
+	git.ExpectAll([][]any{
+		{"clone", "-v", "--", bundle, "."},
+		{"clone", "-v", "--", tester.Repo.Path, "."},
+		{"clean", "-fdq"},
+		{"fetch", "-v", "--", "origin", "main"},
+		{"checkout", "-f", "FETCH_HEAD"},
+		{"clean", "-fdq"},
+		{"--no-pager", "log", "-1", "HEAD", "-s", "--no-color", gitShowFormatArg},
+	})
+
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+}
+
+// matchGitSubcommand matches git invocations of the given subcommand, after
+// any global options such as -c.
+func matchGitSubcommand(subcommand string) func(arg ...string) bintest.ArgumentsMatchResult {
+	return func(arg ...string) bintest.ArgumentsMatchResult {
+		for i := 0; i < len(arg); i++ {
+			if arg[i] == "-c" {
+				i++
+				continue
+			}
+			if arg[i] == subcommand {
+				return bintest.ArgumentsMatchResult{IsMatch: true, MatchCount: len(arg)}
+			}
+			break
+		}
+		return bintest.ArgumentsMatchResult{Explanation: fmt.Sprintf("args %q aren't git %s", arg, subcommand)}
+	}
+}
+
 func TestCheckoutDoesNotRetryOnHookFailure(t *testing.T) {
 	t.Parallel()
 
@@ -956,6 +1181,108 @@ func TestRepositorylessCheckout(t *testing.T) {
 	tester.RunAndCheck(t)
 }
 
+func TestSkipCheckout(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.MustMock(t, "git").Expect().NotCalled()
+
+	tester.ExpectGlobalHook("pre-checkout").Once()
+	tester.ExpectGlobalHook("checkout").NotCalled()
+	tester.ExpectGlobalHook("post-checkout").Once()
+	tester.ExpectGlobalHook("pre-command").Once().AndCallFunc(func(c *bintest.Call) {
+		if got, want := c.Dir, tester.CheckoutDir(); got != want {
+			fmt.Fprintf(c.Stderr, "pre-command working directory = %q, want %q\n", got, want) //nolint:errcheck // test helper
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+	tester.ExpectGlobalHook("post-command").Once()
+	tester.ExpectGlobalHook("pre-exit").Once()
+
+	tester.RunAndCheck(t, "BUILDKITE_SKIP_CHECKOUT=true")
+}
+
+func TestCheckingOutAllowedRepository(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	env := []string{
+		"BUILDKITE_GIT_CLONE_FLAGS=-v",
+		"BUILDKITE_GIT_CLEAN_FLAGS=-fdq",
+		"BUILDKITE_GIT_FETCH_FLAGS=-v",
+		"BUILDKITE_ALLOWED_REPOSITORIES=^https://github.com/buildkite/.*$,^" + regexp.QuoteMeta(tester.Repo.Path) + "$",
+	}
+
+	git := tester.
+		MustMock(t, "git").
+		PassthroughToLocalCommand()
+
+	git.ExpectAll([][]any{
+		{"clone", "-v", "--", tester.Repo.Path, "."},
+		{"clean", "-fdq"},
+		{"fetch", "-v", "--", "origin", "main"},
+		{"checkout", "-f", "FETCH_HEAD"},
+		{"clean", "-fdq"},
+		{"--no-pager", "log", "-1", "HEAD", "-s", "--no-color", gitShowFormatArg},
+	})
+
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutDisallowedRepository(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.MustMock(t, "git").Expect().NotCalled()
+	tester.ExpectGlobalHook("command").NotCalled()
+
+	env := []string{"BUILDKITE_ALLOWED_REPOSITORIES=^https://github.com/buildkite/.*$"}
+	if err := tester.Run(t, env...); err == nil {
+		t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+	}
+	tester.CheckMocks(t)
+}
+
+func TestCheckoutHookWithDisallowedRepository(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("checkout").NotCalled()
+	tester.ExpectGlobalHook("command").NotCalled()
+
+	env := []string{"BUILDKITE_ALLOWED_REPOSITORIES=^https://github.com/buildkite/.*$"}
+	if err := tester.Run(t, env...); err == nil {
+		t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+	}
+	tester.CheckMocks(t)
+}
+
 type subDirMatcher struct {
 	dir string
 }
@@ -0,0 +1,149 @@
+package integration
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildkite/agent/v3/internal/job"
+	"github.com/buildkite/bintest/v3"
+)
+
+func TestCheckingOutMercurialProject(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	env := []string{
+		"BUILDKITE_VCS=hg",
+		"BUILDKITE_REPO=https://hg.example.com/project",
+	}
+
+	hg := tester.MustMock(t, "hg")
+	hg.ExpectAll([][]any{
+		{"clone", "--noupdate", "--", "https://hg.example.com/project", "."},
+		{"update", "--clean", "--rev", "main"},
+		{"--config", "extensions.purge=", "purge", "--all"},
+	})
+	hg.Expect("log", "--rev", ".", "--template", bintest.MatchAny()).
+		AndWriteToStdout("commit 0123456789abcdef\nabbrev-commit 0123456789ab\nAuthor: Example Human <legit@example.com>\n\n    hello world\n")
+
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).WithStdin(commitPattern)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutPerforceProject(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	env := []string{
+		"BUILDKITE_VCS=p4",
+		"BUILDKITE_REPO=//depot/project",
+		"BUILDKITE_COMMIT=1234",
+	}
+
+	const client = "buildkite-test-agent-test-test-project"
+	p4 := tester.MustMock(t, "p4")
+	p4.Expect("client", "-i").
+		WithStdin(bintest.MatchPattern(`(?m)^Client: ` + client + `$(?s).*^\t"//depot/project/\.\.\." "//` + client + `/\.\.\."$`))
+	p4.Expect("-c", client, "sync", "//depot/project/...@1234")
+	p4.Expect("-c", client, "clean", "//depot/project/...")
+	p4.Expect("-c", client, "changes", "-m1", "-l", "//depot/project/...#have").
+		AndWriteToStdout("Change 1234 on 2024/01/01 by legit@" + client + "\n\n\thello world\n")
+
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+	agent.Expect("meta-data", "set", "--scope", "build", job.CommitMetadataKey).
+		WithStdin(bintest.MatchPattern(`\Acommit 1234\nabbrev-commit 1234\nAuthor: legit\n\n    hello world\n\z`))
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestCheckingOutTarball(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tarball := filepath.Join(t.TempDir(), "project-1234.tar.gz")
+	writeTarGz(t, tarball, map[string]string{
+		"project-1234/hello.txt":     "hello world\n",
+		"project-1234/src/main.go":   "package main\n",
+		"project-1234/.buildkite/ok": "",
+	})
+
+	// Something left over from a previous job, which should be removed
+	if err := os.MkdirAll(tester.CheckoutDir(), 0o777); err != nil {
+		t.Fatalf("os.MkdirAll(%q) error = %v", tester.CheckoutDir(), err)
+	}
+	if err := os.WriteFile(filepath.Join(tester.CheckoutDir(), "stale.txt"), nil, 0o666); err != nil {
+		t.Fatalf("os.WriteFile(stale.txt) error = %v", err)
+	}
+
+	env := []string{
+		"BUILDKITE_VCS=tar",
+		"BUILDKITE_REPO=" + filepath.Join(filepath.Dir(tarball), "project-{commit}.tar.gz"),
+		"BUILDKITE_COMMIT=1234",
+	}
+
+	// There's no commit information to send
+	agent := tester.MockAgent(t)
+	agent.Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).AndExitWith(1)
+
+	tester.RunAndCheck(t, env...)
+
+	for _, name := range []string{"hello.txt", filepath.Join("src", "main.go")} {
+		if _, err := os.Stat(filepath.Join(tester.CheckoutDir(), name)); err != nil {
+			t.Errorf("os.Stat(%q) error = %v, want it to have been extracted", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tester.CheckoutDir(), "stale.txt")); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(stale.txt) error = %v, want it to have been removed", err)
+	}
+}
+
+// writeTarGz writes a gzipped tarball of the files to path.
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("os.Create(%q) error = %v", path, err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tw.WriteHeader(%q) error = %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("tw.Write(%q) error = %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close() error = %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gz.Close() error = %v", err)
+	}
+}
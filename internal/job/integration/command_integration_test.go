@@ -1,11 +1,20 @@
 package integration
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent/v3/internal/job"
+	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/bintest/v3"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestMultilineCommandRunUnderBatch(t *testing.T) {
@@ -54,7 +63,7 @@ func TestPreExitHooksRunsAfterCommandFails(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	preExitFunc := func(c *bintest.Call) {
@@ -73,3 +82,194 @@ func TestPreExitHooksRunsAfterCommandFails(t *testing.T) {
 
 	tester.CheckMocks(t)
 }
+
+func TestCommandIsRetried(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	var attempts []string
+	tester.ExpectGlobalHook("command").Exactly(2).AndCallFunc(func(c *bintest.Call) {
+		attempts = append(attempts, c.GetEnv("BUILDKITE_COMMAND_ATTEMPT"))
+		if len(attempts) == 1 {
+			c.Exit(3)
+			return
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t, "BUILDKITE_COMMAND_RETRY_ATTEMPTS=2", "BUILDKITE_COMMAND_RETRY_EXIT_STATUS=3")
+
+	if diff := cmp.Diff(attempts, []string{"1", "2"}); diff != "" {
+		t.Errorf("BUILDKITE_COMMAND_ATTEMPT of each attempt diff (-got +want):\n%s", diff)
+	}
+	if !strings.Contains(tester.Output, "Retrying the command (attempt 2 of 3)") {
+		t.Errorf("tester.Output = %q, want it to contain a header for the second attempt", tester.Output)
+	}
+}
+
+func TestCommandIsNotRetriedForOtherExitStatuses(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").Once().AndExitWith(1)
+
+	if err := tester.Run(t, "BUILDKITE_COMMAND_RETRY_ATTEMPTS=2", "BUILDKITE_COMMAND_RETRY_EXIT_STATUS=3"); err == nil {
+		t.Fatalf("tester.Run(t, ...) = %v, want non-nil error", err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCommandExitStatusSoftFailsStep(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+	agent.
+		Expect("meta-data", "set", "--scope", "build", job.SoftFailMetaDataKeyPrefix+"1111-1111-1111-1111").
+		WithStdin("3").
+		AndExitWith(0)
+	agent.
+		Expect("step", "update", "--patch", `[{"op":"replace","path":"/soft_fail","value":[{"exit_status":3}]}]`).
+		AndExitWith(0)
+
+	tester.ExpectGlobalHook("command").Once().AndExitWith(3)
+
+	err = tester.Run(t, "BUILDKITE_SOFT_FAIL_EXIT_STATUS=2,3")
+	if got, want := shell.ExitCode(err), 3; got != want {
+		t.Errorf("tester.Run(t, BUILDKITE_SOFT_FAIL_EXIT_STATUS=2,3) exit code = %d, want %d", got, want)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestJobLogFormatJSON(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").Once().AndCallFunc(func(c *bintest.Call) {
+		fmt.Fprintln(c.Stdout, "+++ Running llamas") //nolint:errcheck // test helper
+		fmt.Fprintln(c.Stdout, "llamas are great")   //nolint:errcheck // test helper
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t, "BUILDKITE_JOB_LOG_FORMAT=json")
+
+	var lines []shell.JSONLine
+	for _, s := range strings.Split(strings.TrimSpace(tester.Output), "\n") {
+		// The tester enables debug logging from the bootstrap command itself,
+		// which isn't job output
+		if !strings.HasPrefix(s, "{") {
+			continue
+		}
+		var l shell.JSONLine
+		if err := json.Unmarshal([]byte(s), &l); err != nil {
+			t.Fatalf("json.Unmarshal(%q) error = %v", s, err)
+		}
+		if l.Time.IsZero() {
+			t.Errorf("line %q has no timestamp", s)
+		}
+		if l.SinceStart < l.SincePrevious {
+			t.Errorf("line %q has since_start_ms < since_previous_ms", s)
+		}
+		l.Time, l.SinceStart, l.SincePrevious = time.Time{}, 0, 0
+		lines = append(lines, l)
+	}
+
+	want := []shell.JSONLine{
+		{Type: "group", Stream: "stdout", Phase: "command", Hook: "global command", Group: "Running llamas", Expanded: true, Line: "+++ Running llamas"},
+		{Type: "output", Stream: "stdout", Phase: "command", Hook: "global command", Group: "Running llamas", Line: "llamas are great"},
+	}
+	for _, w := range want {
+		if !slices.Contains(lines, w) {
+			t.Errorf("JSON job log is missing line %+v", w)
+		}
+	}
+
+	header := shell.JSONLine{Type: "group", Stream: "stderr", Phase: "checkout", Group: "Preparing working directory", Line: "~~~ Preparing working directory"}
+	if !slices.Contains(lines, header) {
+		t.Errorf("JSON job log is missing line %+v", header)
+	}
+}
+
+func TestSecretsDirIsRemovedAfterJob(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	var secretsDir string
+	tester.ExpectGlobalHook("command").Once().AndCallFunc(func(c *bintest.Call) {
+		secretsDir = c.GetEnv("BUILDKITE_SECRETS_DIR")
+		if secretsDir == "" {
+			fmt.Fprintln(c.Stderr, "BUILDKITE_SECRETS_DIR is not set") //nolint:errcheck // test helper
+			c.Exit(1)
+			return
+		}
+		if err := os.WriteFile(filepath.Join(secretsDir, "kubeconfig"), []byte("llamas"), 0o600); err != nil {
+			fmt.Fprintf(c.Stderr, "os.WriteFile() error = %v\n", err) //nolint:errcheck // test helper
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t)
+
+	if _, err := os.Stat(secretsDir); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q) error = %v, want the secrets dir to have been removed", secretsDir, err)
+	}
+}
+
+func TestPhaseTimingsAreRecorded(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+	agent.
+		Expect("meta-data", "set", "--scope", "build", job.PhaseTimingsMetaDataKeyPrefix+"1111-1111-1111-1111").
+		WithStdin(bintest.MatchPattern(`\A\{"artifact":[0-9.]+,"checkout":[0-9.]+,"command":[0-9.]+,"plugin":[0-9.]+,"setup":[0-9.]+,"teardown":[0-9.]+\}\z`)).
+		AndExitWith(0)
+	agent.
+		Expect("annotate", "--style", "info", "--context", "buildkite-timings-1111-1111-1111-1111").
+		WithStdin(bintest.MatchPattern(`(?ms)\A\*\*Job 1111-1111-1111-1111\*\* took .*^\| command \| `)).
+		AndExitWith(0)
+
+	tester.RunAndCheck(t, "BUILDKITE_PHASE_TIMINGS=annotation")
+}
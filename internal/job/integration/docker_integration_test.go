@@ -1,7 +1,10 @@
 package integration
 
 import (
+	"fmt"
+	"regexp"
 	"runtime"
+	"slices"
 	"testing"
 
 	"github.com/buildkite/agent/v3/internal/job"
@@ -27,7 +30,7 @@ func TestRunningCommandWithDocker(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -50,6 +53,48 @@ func TestRunningCommandWithDocker(t *testing.T) {
 	tester.RunAndCheck(t, env...)
 }
 
+func TestRunningCommandWithDockerFiltersCommandEnv(t *testing.T) {
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+
+	env := []string{
+		"BUILDKITE_DOCKER=llamas",
+		"BUILDKITE_COMMAND_ENV_DENY=LLAMA_SECRET",
+		"LLAMA_SECRET=hunter2",
+	}
+
+	jobId := "1111-1111-1111-1111"
+	imageId := "buildkite_" + jobId + "_image"
+	containerId := "buildkite_" + jobId + "_container"
+
+	docker := tester.MustMock(t, "docker")
+	docker.ExpectAll([][]any{
+		{"build", "-f", "Dockerfile", "-t", imageId, "."},
+		{"rm", "-f", "-v", containerId},
+	})
+
+	docker.Expect("run", "--name", containerId, imageId, argumentForCommand("true")).
+		AndCallFunc(func(c *bintest.Call) {
+			if got := c.GetEnv("LLAMA_SECRET"); got != "" {
+				t.Errorf("LLAMA_SECRET = %q, want it to be removed", got)
+			}
+			c.Exit(0)
+		})
+
+	expectCommandHooks("0", t, tester)
+
+	tester.RunAndCheck(t, env...)
+}
+
 func TestRunningCommandWithDockerAndCustomDockerfile(t *testing.T) {
 	tester, err := NewExecutorTester(mainCtx)
 	if err != nil {
@@ -60,7 +105,7 @@ func TestRunningCommandWithDockerAndCustomDockerfile(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -94,7 +139,7 @@ func TestRunningFailingCommandWithDocker(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -133,7 +178,7 @@ func TestRunningCommandWithDockerCompose(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -166,7 +211,7 @@ func TestRunningFailingCommandWithDockerCompose(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -206,7 +251,7 @@ func TestRunningCommandWithDockerComposeAndExtraConfig(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -240,7 +285,7 @@ func TestRunningCommandWithDockerComposeAndBuildAll(t *testing.T) {
 	// Mock out the meta-data calls to the agent after checkout
 	agent := tester.MockAgent(t)
 	agent.
-		Expect("meta-data", "exists", job.CommitMetadataKey).
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 		AndExitWith(0)
 
 	env := []string{
@@ -255,6 +300,169 @@ func TestRunningCommandWithDockerComposeAndBuildAll(t *testing.T) {
 	tester.RunAndCheck(t, env...)
 }
 
+func TestRunningCommandInCommandContainer(t *testing.T) {
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewExecutorTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+
+	env := []string{
+		"BUILDKITE_COMMAND_CONTAINER=alpine:3",
+		"BUILDKITE_DOCKER=llamas", // ignored in favour of BUILDKITE_COMMAND_CONTAINER
+	}
+
+	checkoutDir := tester.CheckoutDir()
+
+	docker := tester.MustMock(t, "docker")
+	docker.Expect().
+		WithMatcherFunc(matchContainerRun(checkoutDir, "alpine:3")).
+		AndCallFunc(func(c *bintest.Call) {
+			if got, want := c.GetEnv("BUILDKITE_JOB_ID"), "1111-1111-1111-1111"; got != want {
+				t.Errorf("c.GetEnv(BUILDKITE_JOB_ID) = %q, want %q", got, want)
+			}
+			c.Exit(0)
+		}).
+		Once()
+	docker.Expect().WithMatcherFunc(matchContainerRm).Once()
+
+	expectCommandHooks("0", t, tester)
+
+	tester.RunAndCheck(t, env...)
+}
+
+func TestRunningFailingCommandInCommandContainer(t *testing.T) {
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewExecutorTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+
+	env := []string{
+		"BUILDKITE_COMMAND_CONTAINER=alpine:3",
+		"BUILDKITE_COMMAND_CONTAINER_RUNTIME=podman",
+	}
+
+	podman := tester.MustMock(t, "podman")
+	podman.Expect().
+		WithMatcherFunc(matchContainerRun(tester.CheckoutDir(), "alpine:3")).
+		AndExitWith(3)
+	podman.Expect().WithMatcherFunc(matchContainerRm).Once()
+
+	expectCommandHooks("3", t, tester)
+
+	if err = tester.Run(t, env...); err == nil {
+		t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+func TestCommandContainerRemovedWhenPreExitHookFails(t *testing.T) {
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewExecutorTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	// Mock out the meta-data calls to the agent after checkout
+	agent := tester.MockAgent(t)
+	agent.
+		Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
+		AndExitWith(0)
+
+	env := []string{"BUILDKITE_COMMAND_CONTAINER=alpine:3"}
+
+	docker := tester.MustMock(t, "docker")
+	docker.Expect().
+		WithMatcherFunc(matchContainerRun(tester.CheckoutDir(), "alpine:3")).
+		AndExitWith(0)
+	docker.Expect().WithMatcherFunc(matchContainerRm).Once()
+
+	tester.ExpectGlobalHook("pre-command").Once()
+	tester.ExpectLocalHook("pre-command").Once()
+	tester.ExpectGlobalHook("post-command").Once()
+	tester.ExpectLocalHook("post-command").Once()
+	tester.ExpectGlobalHook("pre-exit").Once().AndExitWith(1)
+	tester.ExpectLocalHook("pre-exit").NotCalled()
+
+	if err = tester.Run(t, env...); err == nil {
+		t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+	}
+
+	tester.CheckMocks(t)
+}
+
+// commandContainerName matches the names given to command containers for the
+// test job.
+var commandContainerName = regexp.MustCompile(`^buildkite_1111-1111-1111-1111_command_[0-9a-f]{8}$`)
+
+// matchContainerRm matches the arguments to `docker rm` that remove a command
+// container.
+func matchContainerRm(arg ...string) bintest.ArgumentsMatchResult {
+	if len(arg) != 4 || !slices.Equal(arg[:3], []string{"rm", "--force", "--volumes"}) || !commandContainerName.MatchString(arg[3]) {
+		return bintest.ArgumentsMatchResult{Explanation: fmt.Sprintf("args %q don't remove a command container", arg)}
+	}
+	return bintest.ArgumentsMatchResult{IsMatch: true, MatchCount: len(arg)}
+}
+
+// matchContainerRun matches the arguments to `docker run` that run the
+// command in a container with the checkout mounted, and the job environment
+// (but not the PATH) passed through.
+func matchContainerRun(checkoutDir, image string) func(arg ...string) bintest.ArgumentsMatchResult {
+	return func(arg ...string) bintest.ArgumentsMatchResult {
+		mismatch := func(format string, v ...any) bintest.ArgumentsMatchResult {
+			return bintest.ArgumentsMatchResult{Explanation: fmt.Sprintf(format, v...)}
+		}
+
+		if len(arg) < 3 || arg[0] != "run" || arg[1] != "--name" || !commandContainerName.MatchString(arg[2]) {
+			return mismatch("args %q don't start with run --name and a command container name", arg)
+		}
+		arg = arg[3:]
+
+		prefix := []string{
+			"--init",
+			"--volume", checkoutDir + ":" + checkoutDir,
+			"--workdir", checkoutDir,
+		}
+		if len(arg) < len(prefix) || !slices.Equal(arg[:len(prefix)], prefix) {
+			return mismatch("args %q don't start with %q", arg, prefix)
+		}
+
+		imageIdx := slices.Index(arg, image)
+		if imageIdx < 0 || imageIdx == len(arg)-1 {
+			return mismatch("args %q don't contain image %q followed by a command", arg, image)
+		}
+
+		envArgs := arg[len(prefix):imageIdx]
+		for i := 0; i < len(envArgs); i += 2 {
+			if envArgs[i] != "--env" || i+1 == len(envArgs) {
+				return mismatch("args %q aren't all --env NAME pairs", envArgs)
+			}
+		}
+		if !slices.Contains(envArgs, "BUILDKITE_JOB_ID") {
+			return mismatch("args %q don't pass through BUILDKITE_JOB_ID", envArgs)
+		}
+		if slices.Contains(envArgs, "PATH") {
+			return mismatch("args %q pass through PATH", envArgs)
+		}
+
+		return bintest.ArgumentsMatchResult{IsMatch: true, MatchCount: len(arg)}
+	}
+}
+
 func expectCommandHooks(exitStatus string, t *testing.T, tester *ExecutorTester) {
 	tester.ExpectGlobalHook("pre-command").Once()
 	tester.ExpectLocalHook("pre-command").Once()
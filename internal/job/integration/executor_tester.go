@@ -268,7 +268,7 @@ func (e *ExecutorTester) Run(t *testing.T, env ...string) error {
 	if !e.HasMock("buildkite-agent") {
 		agent := e.MockAgent(t)
 		agent.
-			Expect("meta-data", "exists", job.CommitMetadataKey).
+			Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 			Optionally().
 			AndExitWith(0)
 	}
@@ -431,7 +431,7 @@ func TestPreExitHooksFireAfterHookFailures(t *testing.T) {
 
 			if tc.expectCheckout {
 				agent.
-					Expect("meta-data", "exists", job.CommitMetadataKey).
+					Expect("meta-data", "exists", "--scope", "build", job.CommitMetadataKey).
 					Once().
 					AndExitWith(0)
 			}
@@ -561,6 +561,46 @@ func TestPreExitHooksFireAfterCancel(t *testing.T) {
 	tester.CheckMocks(t)
 }
 
+func TestOnCancelHooksFireBeforeInterrupt(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip()
+	}
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewExecutorTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("on-cancel").Once()
+	tester.ExpectLocalHook("on-cancel").Once()
+	tester.ExpectGlobalHook("pre-exit").Once()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		if err := tester.Run(t, "BUILDKITE_COMMAND=sleep 5"); err == nil {
+			t.Errorf(`tester.Run(t, "BUILDKITE_COMMAND=sleep 5") = %v, want non-nil error`, err)
+		}
+	}()
+
+	time.Sleep(time.Millisecond * 500)
+	tester.Cancel()
+	wg.Wait()
+
+	tester.CheckMocks(t)
+
+	// The on-cancel hooks run before the command is interrupted
+	output := tester.Output
+	if onCancel, interrupt := strings.Index(output, "Running global on-cancel hook"), strings.Index(output, "Interrupting the job"); onCancel < 0 || interrupt < onCancel {
+		t.Errorf("on-cancel hook output at %d, interruption at %d, want the hook first\n%s", onCancel, interrupt, output)
+	}
+}
+
 func TestPolyglotScriptHooksCanBeRun(t *testing.T) {
 	t.Parallel()
 
@@ -645,3 +685,56 @@ func TestPolyglotBinaryHooksCanBeRun(t *testing.T) {
 		t.Fatalf("tester.Output %s does not contain expected output: %q", tester.Output, "hi there from golang 🌊")
 	}
 }
+
+func TestHookTimeout(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Not implemented for windows yet")
+	}
+
+	tests := []struct {
+		name string
+		env  []string
+	}{
+		{name: "agent hook timeout", env: []string{"BUILDKITE_HOOK_TIMEOUT=1s"}},
+		{name: "per-hook override", env: []string{"BUILDKITE_HOOK_TIMEOUT=1h", "BUILDKITE_HOOK_TIMEOUT_PRE_COMMAND=1s"}},
+		{name: "per-hook override can't lengthen it", env: []string{"BUILDKITE_HOOK_TIMEOUT=1s", "BUILDKITE_HOOK_TIMEOUT_PRE_COMMAND=1h"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			tester, err := NewExecutorTester(mainCtx)
+			if err != nil {
+				t.Fatalf("NewBootstrapTester() error = %v", err)
+			}
+			defer tester.Close()
+
+			script := []string{
+				"#!/bin/bash",
+				"sleep 60",
+			}
+			if err := os.WriteFile(filepath.Join(tester.HooksDir, "pre-command"), []byte(strings.Join(script, "\n")), 0o700); err != nil {
+				t.Fatalf("os.WriteFile(pre-command, script, 0o700) = %v", err)
+			}
+
+			tester.ExpectGlobalHook("command").NotCalled()
+
+			start := time.Now()
+			if err := tester.Run(t, test.env...); err == nil {
+				t.Fatalf("tester.Run(t, %q) = %v, want non-nil error", test.env, err)
+			}
+			if elapsed := time.Since(start); elapsed > 30*time.Second {
+				t.Errorf("tester.Run(t, %q) took %v, want the hook to be stopped after its timeout", test.env, elapsed)
+			}
+
+			if want := "ran for longer than its timeout of 1s"; !strings.Contains(tester.Output, want) {
+				t.Errorf("tester.Output does not contain %q:\n%s", want, tester.Output)
+			}
+
+			tester.CheckMocks(t)
+		})
+	}
+}
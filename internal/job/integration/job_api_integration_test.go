@@ -12,6 +12,7 @@ import (
 
 	"github.com/buildkite/agent/v3/jobapi"
 	"github.com/buildkite/bintest/v3"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestBootstrapRunsJobAPI(t *testing.T) {
@@ -144,3 +145,53 @@ func TestBootstrapRunsJobAPI(t *testing.T) {
 
 	tester.RunAndCheck(t)
 }
+
+func TestJobAPIReportsJobInfo(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").Once().AndCallFunc(func(c *bintest.Call) {
+		client, err := jobapi.NewClient(mainCtx, c.GetEnv("BUILDKITE_AGENT_JOB_API_SOCKET"), c.GetEnv("BUILDKITE_AGENT_JOB_API_TOKEN"))
+		if err != nil {
+			t.Errorf("jobapi.NewClient() error = %v", err)
+			c.Exit(1)
+			return
+		}
+
+		job, err := client.JobGet(mainCtx)
+		if err != nil {
+			t.Errorf("client.JobGet() error = %v", err)
+			c.Exit(1)
+			return
+		}
+
+		if got, want := job.ID, c.GetEnv("BUILDKITE_JOB_ID"); got != want {
+			t.Errorf("job.ID = %q, want %q", got, want)
+		}
+		if got, want := job.StepKey, "llamas"; got != want {
+			t.Errorf("job.StepKey = %q, want %q", got, want)
+		}
+		if got, want := job.RetryCount, 1; got != want {
+			t.Errorf("job.RetryCount = %d, want %d", got, want)
+		}
+		if got, want := job.Phase, "command"; got != want {
+			t.Errorf("job.Phase = %q, want %q", got, want)
+		}
+
+		var phases []string
+		for _, p := range job.Phases {
+			phases = append(phases, p.Name)
+		}
+		if diff := cmp.Diff(phases, []string{"setup", "plugin", "checkout", "plugin", "command"}); diff != "" {
+			t.Errorf("job.Phases names diff (-got +want):\n%s", diff)
+		}
+		c.Exit(0)
+	})
+
+	tester.RunAndCheck(t, "BUILDKITE_STEP_KEY=llamas", "BUILDKITE_RETRY_COUNT=1")
+}
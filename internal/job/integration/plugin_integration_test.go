@@ -495,6 +495,251 @@ func TestModifiedPluginWithForcePull(t *testing.T) {
 	tester2.RunAndCheck(t, env...)
 }
 
+func TestPrePluginAndPostPluginHooks(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	hooks := map[string][]string{
+		"environment": {"#!/bin/bash", "export OSTRICH_EGGS=quite_large"},
+	}
+	if runtime.GOOS == "windows" {
+		hooks = map[string][]string{
+			"environment.bat": {"@echo off", "set OSTRICH_EGGS=quite_large"},
+		}
+	}
+	p := createTestPlugin(t, hooks)
+
+	json, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("testPlugin.ToJSON() error = %v", err)
+	}
+
+	// The pre-plugin hook sees where the plugin was checked out, before the
+	// plugin's environment hook has run
+	tester.ExpectGlobalHook("pre-plugin").Once().AndCallFunc(func(c *bintest.Call) {
+		paths := c.GetEnv("BUILDKITE_PLUGIN_CHECKOUT_PATHS")
+		if _, err := os.Stat(filepath.Join(paths, "hooks")); err != nil {
+			fmt.Fprintf(c.Stderr, "BUILDKITE_PLUGIN_CHECKOUT_PATHS = %q, want the plugin checkout: %v\n", paths, err)
+			c.Exit(1)
+			return
+		}
+		if got := c.GetEnv("OSTRICH_EGGS"); got != "" {
+			fmt.Fprintf(c.Stderr, "OSTRICH_EGGS = %q before the plugin environment hook ran\n", got)
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+	tester.ExpectGlobalHook("post-plugin").Once().AndCallFunc(func(c *bintest.Call) {
+		if err := bintest.ExpectEnv(t, c.Env, "OSTRICH_EGGS=quite_large"); err != nil {
+			fmt.Fprintf(c.Stderr, "%v\n", err)
+			c.Exit(1)
+			return
+		}
+		c.Exit(0)
+	})
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+
+	tester.RunAndCheck(t, "BUILDKITE_PLUGINS="+json)
+}
+
+func TestPrePluginHookCanRejectPlugins(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("NewBootstrapTester() error = %v", err)
+	}
+	defer tester.Close()
+
+	hooks := map[string][]string{
+		"environment": {"#!/bin/bash", "echo plugin environment hook ran"},
+	}
+	if runtime.GOOS == "windows" {
+		hooks = map[string][]string{
+			"environment.bat": {"@echo off", "echo plugin environment hook ran"},
+		}
+	}
+	p := createTestPlugin(t, hooks)
+
+	json, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("testPlugin.ToJSON() error = %v", err)
+	}
+
+	tester.ExpectGlobalHook("pre-plugin").Once().AndExitWith(1)
+	tester.ExpectGlobalHook("post-plugin").NotCalled()
+	tester.ExpectGlobalHook("command").NotCalled()
+
+	env := []string{"BUILDKITE_PLUGINS=" + json}
+	if err := tester.Run(t, env...); err == nil {
+		t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+	}
+	tester.CheckMocks(t)
+
+	if strings.Contains(tester.Output, "plugin environment hook ran") {
+		t.Errorf("plugin environment hook ran after the pre-plugin hook failed")
+	}
+}
+
+// With a plugin lock file, a plugin version that resolves to a different commit
+// than it did the first time should fail the job, unless drift is allowed.
+func TestPluginLockFileDetectsDrift(t *testing.T) {
+	t.Parallel()
+
+	pluginsDir, err := os.MkdirTemp("", "bootstrap-plugins")
+	if err != nil {
+		t.Fatalf(`os.MkdirTemp("", "bootstrap-plugins") error = %v`, err)
+	}
+	lockFile := filepath.Join(pluginsDir, "plugins.lock")
+
+	hooks := map[string][]string{
+		"environment": {"#!/bin/bash", "export OSTRICH_EGGS=quite_large"},
+	}
+	if runtime.GOOS == "windows" {
+		hooks = map[string][]string{
+			"environment.bat": {"@echo off", "set OSTRICH_EGGS=quite_large"},
+		}
+	}
+	p := createTestPlugin(t, hooks)
+	p.gitRepository.CreateBranch("something-moving")
+	p.versionTag = "something-moving"
+
+	json, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("testPlugin.ToJSON() error = %v", err)
+	}
+
+	newTester := func() *ExecutorTester {
+		tester, err := NewExecutorTester(mainCtx)
+		if err != nil {
+			t.Fatalf("NewExecutorTester() error = %v", err)
+		}
+		tester.PluginsDir = pluginsDir
+		tester.Env = replacePluginPathInEnv(tester.Env, pluginsDir)
+		tester.Env = append(tester.Env,
+			"BUILDKITE_PLUGIN_LOCK_FILE="+lockFile,
+			"BUILDKITE_PLUGINS_ALWAYS_CLONE_FRESH=true",
+		)
+		return tester
+	}
+
+	// The first run records the commit
+	tester := newTester()
+	defer tester.Close()
+	tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+	tester.RunAndCheck(t, "BUILDKITE_PLUGINS="+json)
+
+	// Move the branch
+	hooks["environment"] = []string{"#!/bin/bash", "export OSTRICH_EGGS=huge_actually"}
+	if runtime.GOOS == "windows" {
+		hooks["environment.bat"] = []string{"@echo off", "set OSTRICH_EGGS=huge_actually"}
+	}
+	modifyTestPlugin(t, hooks, p)
+
+	// The second run fails, without running the command
+	tester2 := newTester()
+	defer tester2.Close()
+	tester2.ExpectGlobalHook("command").NotCalled()
+	if err := tester2.Run(t, "BUILDKITE_PLUGINS="+json); err == nil {
+		t.Fatalf("tester2.Run() error = nil, want non-nil error")
+	}
+	tester2.CheckMocks(t)
+
+	// The third run allows drift, and updates the lock file
+	tester3 := newTester()
+	defer tester3.Close()
+	tester3.ExpectGlobalHook("command").Once().AndExitWith(0)
+	tester3.RunAndCheck(t, "BUILDKITE_PLUGINS="+json, "BUILDKITE_PLUGINS_ALLOW_DRIFT=true")
+
+	// And the fourth run is happy with the new commit
+	tester4 := newTester()
+	defer tester4.Close()
+	tester4.ExpectGlobalHook("command").Once().AndExitWith(0)
+	tester4.RunAndCheck(t, "BUILDKITE_PLUGINS="+json)
+}
+
+func TestAllowedPlugins(t *testing.T) {
+	t.Parallel()
+
+	hooks := map[string][]string{
+		"environment": {"#!/bin/bash", "export OSTRICH_EGGS=quite_large"},
+	}
+	if runtime.GOOS == "windows" {
+		hooks = map[string][]string{
+			"environment.bat": {"@echo off", "set OSTRICH_EGGS=quite_large"},
+		}
+	}
+	p := createTestPlugin(t, hooks)
+	commit := strings.TrimSpace(p.versionTag)
+
+	json, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("testPlugin.ToJSON() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		allowedPlugins string
+		wantAllowed    bool
+	}{
+		{
+			name:           "not matching any pattern",
+			allowedPlugins: "^github.com/buildkite-plugins/.*$",
+			wantAllowed:    false,
+		},
+		{
+			name:           "matching a pattern",
+			allowedPlugins: "^github.com/buildkite-plugins/.*$,^file://.*$",
+			wantAllowed:    true,
+		},
+		{
+			name:           "matching a pattern pinned to another commit",
+			allowedPlugins: "^file://.*$@" + strings.Repeat("0", 40),
+			wantAllowed:    false,
+		},
+		{
+			name:           "matching a pattern pinned to its commit",
+			allowedPlugins: "^file://.*$@" + commit,
+			wantAllowed:    true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tester, err := NewExecutorTester(mainCtx)
+			if err != nil {
+				t.Fatalf("NewExecutorTester() error = %v", err)
+			}
+			defer tester.Close()
+
+			env := []string{
+				"BUILDKITE_PLUGINS=" + json,
+				"BUILDKITE_ALLOWED_PLUGINS=" + tc.allowedPlugins,
+			}
+
+			if tc.wantAllowed {
+				tester.ExpectGlobalHook("command").Once().AndExitWith(0)
+				tester.RunAndCheck(t, env...)
+				return
+			}
+
+			tester.ExpectGlobalHook("command").NotCalled()
+			if err := tester.Run(t, env...); err == nil {
+				t.Fatalf("tester.Run(t, %v) = %v, want non-nil error", env, err)
+			}
+			tester.CheckMocks(t)
+		})
+	}
+}
+
 type testPlugin struct {
 	*gitRepository
 
@@ -1,7 +1,9 @@
 package integration
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -34,6 +36,42 @@ func TestRedactorRedactsAgentToken(t *testing.T) {
 	}
 }
 
+func TestRedactorRedactsEncodedAgentToken(t *testing.T) {
+	t.Parallel()
+
+	tester, err := NewExecutorTester(mainCtx)
+	if err != nil {
+		t.Fatalf("setting up executor tester: %v", err)
+	}
+	defer tester.Close()
+
+	tester.ExpectGlobalHook("command").AndCallFunc(func(c *bintest.Call) {
+		token := c.GetEnv("BUILDKITE_AGENT_ACCESS_TOKEN")
+		basic := base64.StdEncoding.EncodeToString([]byte("agent:" + token))
+		fmt.Fprintf(c.Stderr, "Authorization: Basic %s\n", basic)
+		fmt.Fprintf(c.Stderr, "https://example.com/?token=%s\n", url.QueryEscape(token))
+		c.Exit(0)
+	})
+
+	err = tester.Run(t)
+	if err != nil {
+		t.Fatalf("running executor tester: %v", err)
+	}
+
+	token := "test-token-please-ignore"
+	for _, leaked := range []string{
+		base64.StdEncoding.EncodeToString([]byte("agent:" + token)),
+		url.QueryEscape(token),
+	} {
+		if strings.Contains(tester.Output, leaked) {
+			t.Errorf("expected %q to be redacted, but it wasn't. Full output: %s", leaked, tester.Output)
+		}
+	}
+	if !strings.Contains(tester.Output, "Authorization: Basic ") {
+		t.Errorf("expected the authorization header to be printed. Full output: %s", tester.Output)
+	}
+}
+
 func TestRedactorDoesNotRedactAgentToken_WhenNotInRedactedVars(t *testing.T) {
 	t.Parallel()
 
@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/buildkite/agent/v3/agent/plugin"
-	"github.com/buildkite/agent/v3/internal/job/hook"
 	"github.com/buildkite/agent/v3/internal/osutil"
 	"github.com/buildkite/roko"
 )
@@ -60,9 +60,72 @@ func (e *Executor) preparePlugins() error {
 		e.shell.Commentf("Parsed %d plugins", len(e.plugins))
 	}
 
+	return e.checkAllowedPlugins()
+}
+
+// checkAllowedPlugins returns an error if any of the plugins aren't allowed by
+// AllowedPlugins, and records the SHAs that the allowed plugins must resolve
+// to. Plugin archives and OCI plugins must be pinned to an allowed checksum
+// up front, since they aren't git repositories.
+func (e *Executor) checkAllowedPlugins() error {
+	if len(e.AllowedPlugins) == 0 {
+		return nil
+	}
+
+	allowlist, err := plugin.ParseAllowlist(e.AllowedPlugins)
+	if err != nil {
+		return fmt.Errorf("Failed to parse allowed plugins: %w", err)
+	}
+
+	e.pluginSHAs = make(map[*plugin.Plugin][]string)
+	for _, p := range e.plugins {
+		shas, err := allowlist.Check(p)
+		if err != nil {
+			return fmt.Errorf("Plugin %s is not allowed on this agent: %w", p.Label(), err)
+		}
+		if len(shas) == 0 {
+			continue
+		}
+
+		switch {
+		case p.Vendored:
+			return fmt.Errorf("Plugin %s must resolve to one of %s, which can't be checked for vendored plugins",
+				p.Label(), strings.Join(shas, ", "))
+
+		case p.IsArchive() || p.IsOCI():
+			if _, digest, ok := p.Checksum(); !ok || !slices.Contains(shas, digest) {
+				return fmt.Errorf("Plugin %s must be pinned to one of the checksums sha256:%s",
+					p.Label(), strings.Join(shas, ", sha256:"))
+			}
+		}
+		e.pluginSHAs[p] = shas
+	}
+
 	return nil
 }
 
+// verifyPluginCommit checks the commit that the plugin checked out in dir
+// resolved to, against the allowed plugins and the plugin lock file.
+func (e *Executor) verifyPluginCommit(ctx context.Context, p *plugin.Plugin, dir string) error {
+	shas := e.pluginSHAs[p]
+	if len(shas) == 0 && e.PluginLockFile == "" {
+		return nil
+	}
+
+	head, err := gitRevParseInWorkingDirectory(ctx, e.shell, dir, "HEAD")
+	if err != nil {
+		return fmt.Errorf("resolving plugin commit: %w", err)
+	}
+	commit := strings.TrimSpace(head)
+
+	if len(shas) > 0 && !slices.Contains(shas, commit) {
+		return fmt.Errorf("plugin %q resolved to commit %s, but this agent only allows %s",
+			p.Label(), commit, strings.Join(shas, ", "))
+	}
+
+	return e.checkPluginLock(ctx, p, commit)
+}
+
 func (e *Executor) validatePluginCheckout(ctx context.Context, checkout *pluginCheckout) error {
 	if !e.ExecutorConfig.PluginValidation {
 		return nil
@@ -136,8 +199,9 @@ func (e *Executor) PluginPhase(ctx context.Context) error {
 	// Store the checkouts for future use
 	e.pluginCheckouts = checkouts
 
-	// Now we can run plugin environment hooks too
-	return e.executePluginHook(ctx, "environment", checkouts)
+	// Now we can run plugin environment hooks too, surrounded by the hooks that
+	// let the agent inspect plugins before any of their code runs
+	return e.executePluginEnvironmentHooks(ctx, checkouts, e.executeGlobalHook)
 }
 
 // VendoredPluginPhase is where plugins that are included in the
@@ -189,8 +253,43 @@ func (e *Executor) VendoredPluginPhase(ctx context.Context) error {
 	// Finally append our vendored checkouts to the rest for subsequent hooks
 	e.pluginCheckouts = append(e.pluginCheckouts, vendoredCheckouts...)
 
-	// Now we can run plugin environment hooks too
-	return e.executePluginHook(ctx, "environment", vendoredCheckouts)
+	// Now we can run plugin environment hooks too. The repository has been
+	// checked out by now, so local pre-plugin and post-plugin hooks run too.
+	return e.executePluginEnvironmentHooks(ctx, vendoredCheckouts, e.executeGlobalHook, e.executeLocalHook)
+}
+
+// executePluginEnvironmentHooks runs the pre-plugin hooks, the plugin
+// environment hooks, and then the post-plugin hooks, for the given checkouts.
+// The pre-plugin hooks can audit the plugins, which are listed in
+// BUILDKITE_PLUGIN_CHECKOUT_PATHS, and fail the job before any plugin code
+// runs.
+func (e *Executor) executePluginEnvironmentHooks(ctx context.Context, checkouts []*pluginCheckout, hookFuncs ...func(context.Context, string) error) error {
+	if len(checkouts) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(checkouts))
+	for _, c := range checkouts {
+		paths = append(paths, c.CheckoutDir)
+	}
+	e.shell.Env.Set("BUILDKITE_PLUGIN_CHECKOUT_PATHS", strings.Join(paths, string(filepath.ListSeparator)))
+
+	for _, f := range hookFuncs {
+		if err := f(ctx, "pre-plugin"); err != nil {
+			return err
+		}
+	}
+
+	if err := e.executePluginHook(ctx, "environment", checkouts); err != nil {
+		return err
+	}
+
+	for _, f := range hookFuncs {
+		if err := f(ctx, "post-plugin"); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Hook types that we should only run one of, but a long-standing bug means that
@@ -208,7 +307,7 @@ func (e *Executor) executePluginHook(ctx context.Context, name string, checkouts
 	hookTypeSeen := make(map[string]bool)
 
 	for i, p := range checkouts {
-		hookPath, err := hook.Find(p.HooksDir, name)
+		hookPath, err := e.findHook(p.HooksDir, name)
 		if errors.Is(err, os.ErrNotExist) {
 			continue // this plugin does not implement this hook
 		}
@@ -271,7 +370,7 @@ func (e *Executor) executePluginHook(ctx context.Context, name string, checkouts
 // If any plugin has a hook by this name
 func (e *Executor) hasPluginHook(name string) bool {
 	for _, p := range e.pluginCheckouts {
-		if _, err := hook.Find(p.HooksDir, name); err == nil {
+		if _, err := e.findHook(p.HooksDir, name); err == nil {
 			return true
 		}
 	}
@@ -333,6 +432,13 @@ func (e *Executor) checkoutPlugin(ctx context.Context, p *plugin.Plugin) (*plugi
 		}
 	}
 
+	if p.IsArchive() || p.IsOCI() {
+		if err := e.downloadPlugin(ctx, p, checkout); err != nil {
+			return nil, err
+		}
+		return checkout, nil
+	}
+
 	if osutil.FileExists(pluginGitDirectory) {
 		// It'd be nice to show the current commit of the plugin, so
 		// let's figure that out.
@@ -343,6 +449,9 @@ func (e *Executor) checkoutPlugin(ctx context.Context, p *plugin.Plugin) (*plugi
 			e.shell.Commentf("Plugin %q already checked out (%s)", p.Label(), strings.TrimSpace(headCommit))
 		}
 
+		if err := e.verifyPluginCommit(ctx, p, pluginDirectory); err != nil {
+			return nil, err
+		}
 		return checkout, nil
 	}
 
@@ -403,6 +512,13 @@ func (e *Executor) checkoutPlugin(ctx context.Context, p *plugin.Plugin) (*plugi
 		}
 	}
 
+	// Check the lock before moving the checkout into place, so that a drifted
+	// plugin isn't left behind for the next job to find.
+	if err := e.verifyPluginCommit(ctx, p, tempDir); err != nil {
+		os.RemoveAll(tempDir) //nolint:errcheck // Best-effort cleanup
+		return nil, err
+	}
+
 	e.shell.Commentf("Moving temporary plugin directory to final location")
 	err = os.Rename(tempDir, pluginDirectory)
 	if err != nil {
@@ -0,0 +1,419 @@
+package job
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/agent/plugin"
+	"github.com/buildkite/agent/v3/internal/osutil"
+	"github.com/buildkite/roko"
+)
+
+// Media types of OCI manifests and layers that plugins can be stored as.
+var (
+	ociManifestMediaTypes = []string{
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}
+	ociLayerMediaTypes = []string{
+		"application/vnd.oci.image.layer.v1.tar+gzip",
+		"application/vnd.docker.image.rootfs.diff.tar.gzip",
+		"application/vnd.buildkite.plugin.v1.tar+gzip",
+	}
+)
+
+// downloadPlugin downloads a plugin archive or OCI artifact, verifies it, and
+// extracts it into the checkout directory.
+func (e *Executor) downloadPlugin(ctx context.Context, p *plugin.Plugin, checkout *pluginCheckout) error {
+	if osutil.FileExists(checkout.CheckoutDir) {
+		e.shell.Commentf("Plugin %q already downloaded", p.Label())
+		return nil
+	}
+
+	_, digest, pinned := p.Checksum()
+	if p.IsArchive() && !pinned {
+		return fmt.Errorf("plugin archives must be pinned to a checksum, e.g. %s#sha256:<checksum>", p.Location)
+	}
+
+	e.shell.Commentf("Plugin %q will be downloaded to %q", p.Label(), checkout.CheckoutDir)
+
+	id, err := p.Identifier()
+	if err != nil {
+		return err
+	}
+
+	archive, err := os.CreateTemp(e.PluginsPath, id+"-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name()) //nolint:errcheck // best-effort cleanup
+	defer archive.Close()
+
+	err = roko.NewRetrier(
+		roko.WithMaxAttempts(3),
+		roko.WithStrategy(roko.Constant(2*time.Second)),
+	).DoWithContext(ctx, func(r *roko.Retrier) error {
+		if err := archive.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if p.IsOCI() {
+			digest, err = fetchOCIPlugin(ctx, http.DefaultClient, p, archive)
+		} else {
+			err = fetchPluginArchive(ctx, http.DefaultClient, p.ArchiveURL(), digest, archive)
+		}
+		if err != nil {
+			e.shell.Warningf("Failed to download plugin %q: %v (%s)", p.Label(), err, r)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	e.shell.Commentf("Verified plugin %q has checksum sha256:%s", p.Label(), digest)
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tempDir, err := os.MkdirTemp(e.PluginsPath, id)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir) //nolint:errcheck // best-effort cleanup after a failure
+
+	if err := extractTarGz(archive, tempDir); err != nil {
+		return fmt.Errorf("extracting plugin: %w", err)
+	}
+
+	e.shell.Commentf("Moving temporary plugin directory to final location")
+	return os.Rename(tempDir, checkout.CheckoutDir)
+}
+
+// fetchPluginArchive downloads the archive at rawURL into dst, and checks that
+// its SHA-256 checksum is wantDigest.
+func fetchPluginArchive(ctx context.Context, client *http.Client, rawURL, wantDigest string, dst io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", redactURL(req.URL), resp.Status)
+	}
+
+	return copyAndVerify(dst, resp.Body, wantDigest)
+}
+
+// fetchOCIPlugin downloads the single layer of the plugin's OCI manifest into
+// dst, and returns the manifest digest that was verified. If the plugin is
+// pinned to a digest, the manifest must match it.
+func fetchOCIPlugin(ctx context.Context, client *http.Client, p *plugin.Plugin, dst io.Writer) (string, error) {
+	registry, repository, reference, err := p.OCIReference()
+	if err != nil {
+		return "", err
+	}
+
+	reg := &ociRegistry{
+		client:     client,
+		base:       "https://" + registry,
+		repository: repository,
+	}
+	if p.Authentication != "" {
+		user, pass, _ := strings.Cut(p.Authentication, ":")
+		reg.user, _ = url.PathUnescape(user)
+		reg.pass, _ = url.PathUnescape(pass)
+	}
+
+	resp, err := reg.get(ctx, "/manifests/"+reference, ociManifestMediaTypes...)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", fmt.Errorf("reading manifest: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	manifestDigest := hex.EncodeToString(sum[:])
+	if _, want, pinned := p.Checksum(); pinned && manifestDigest != want {
+		return "", fmt.Errorf("manifest checksum mismatch: got sha256:%s, want sha256:%s", manifestDigest, want)
+	}
+
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", fmt.Errorf("parsing manifest: %w", err)
+	}
+	if len(manifest.Layers) != 1 {
+		return "", fmt.Errorf("plugin manifest must have exactly one layer, found %d", len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+	if !slices.Contains(ociLayerMediaTypes, layer.MediaType) {
+		return "", fmt.Errorf("unsupported plugin layer media type %q", layer.MediaType)
+	}
+	algorithm, layerDigest, _ := strings.Cut(layer.Digest, ":")
+	if algorithm != "sha256" {
+		return "", fmt.Errorf("unsupported layer digest %q", layer.Digest)
+	}
+
+	blob, err := reg.get(ctx, "/blobs/"+layer.Digest)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Body.Close()
+
+	if err := copyAndVerify(dst, blob.Body, layerDigest); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+// ociRegistry is a minimal client for pulling from an OCI distribution API,
+// supporting anonymous or basic-authenticated bearer tokens.
+type ociRegistry struct {
+	client     *http.Client
+	base       string
+	repository string
+	user, pass string
+	token      string
+}
+
+func (r *ociRegistry) get(ctx context.Context, path string, accept ...string) (*http.Response, error) {
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.base+"/v2/"+r.repository+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		switch {
+		case r.token != "":
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		case r.user != "":
+			req.SetBasicAuth(r.user, r.pass)
+		}
+		return r.client.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && r.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := r.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = do(); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s/v2/%s%s: %s", r.base, r.repository, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// authenticate fetches a bearer token according to a WWW-Authenticate
+// challenge.
+func (r *ociRegistry) authenticate(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("unsupported registry authentication challenge %q", challenge)
+	}
+
+	attrs := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+		attrs[k] = strings.Trim(v, `"`)
+	}
+	if attrs["realm"] == "" {
+		return fmt.Errorf("registry authentication challenge has no realm: %q", challenge)
+	}
+
+	u, err := url.Parse(attrs["realm"])
+	if err != nil {
+		return fmt.Errorf("parsing registry token realm: %w", err)
+	}
+	q := u.Query()
+	if attrs["service"] != "" {
+		q.Set("service", attrs["service"])
+	}
+	scope := attrs["scope"]
+	if scope == "" {
+		scope = "repository:" + r.repository + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.pass)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching registry token: %s", resp.Status)
+	}
+
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("parsing registry token: %w", err)
+	}
+	r.token = tok.Token
+	if r.token == "" {
+		r.token = tok.AccessToken
+	}
+	if r.token == "" {
+		return errors.New("registry returned an empty token")
+	}
+	return nil
+}
+
+// copyAndVerify copies src to dst, and checks the SHA-256 checksum of what
+// was copied is wantDigest (in hex).
+func copyAndVerify(dst io.Writer, src io.Reader, wantDigest string) error {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != wantDigest {
+		return fmt.Errorf("checksum mismatch: got sha256:%s, want sha256:%s", got, wantDigest)
+	}
+	return nil
+}
+
+// extractTarGz extracts a gzipped tarball (of a plugin, or of a checkout) into
+// dir. If every entry is within a single top-level directory (as with GitHub
+// release tarballs), that directory is stripped.
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	extractDir, err := os.MkdirTemp(dir, ".extract")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(extractDir) //nolint:errcheck // emptied below on success
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("archive entry %q is outside the directory it's extracted into", hdr.Name)
+		}
+		target := filepath.Join(extractDir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o777); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+				return err
+			}
+			// Keep the executable bits, as hooks need them
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fs.FileMode(hdr.Mode)&0o777|0o600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+
+		default:
+			// Links and devices aren't needed for plugins, and links could
+			// point outside the directory.
+			continue
+		}
+	}
+
+	// Strip a single top-level directory
+	root := extractDir
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(root, entries[0].Name())
+		if entries, err = os.ReadDir(root); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(root, entry.Name()), filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactURL returns u as a string without any credentials.
+func redactURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	return c.String()
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// makeTarGz returns a gzipped tarball of the given files.
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("tw.WriteHeader(%q) error = %v", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("tw.Write error = %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tw.Close() error = %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gz.Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchPluginArchive(t *testing.T) {
+	t.Parallel()
+
+	archive := makeTarGz(t, map[string]string{"plugin/hooks/command": "echo hello"})
+	sum := sha256.Sum256(archive)
+	digest := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive) //nolint:errcheck // test server
+	}))
+	t.Cleanup(server.Close)
+
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := fetchPluginArchive(ctx, server.Client(), server.URL+"/plugin.tar.gz", digest, &buf); err != nil {
+		t.Fatalf("fetchPluginArchive() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := extractTarGz(&buf, dir); err != nil {
+		t.Fatalf("extractTarGz() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "hooks", "command"))
+	if err != nil {
+		t.Fatalf("os.ReadFile(hooks/command) error = %v", err)
+	}
+	if diff := cmp.Diff(string(got), "echo hello"); diff != "" {
+		t.Errorf("hooks/command diff (-got +want):\n%s", diff)
+	}
+
+	wrong := strings.Repeat("0", 64)
+	err = fetchPluginArchive(ctx, server.Client(), server.URL+"/plugin.tar.gz", wrong, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("fetchPluginArchive(wrong digest) error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestExtractTarGzRejectsPathTraversal(t *testing.T) {
+	t.Parallel()
+
+	archive := makeTarGz(t, map[string]string{"../escaped": "nope"})
+	dir := filepath.Join(t.TempDir(), "plugin")
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		t.Fatalf("os.Mkdir(%q) error = %v", dir, err)
+	}
+
+	if err := extractTarGz(bytes.NewReader(archive), dir); err == nil {
+		t.Errorf("extractTarGz(../escaped) error = nil, want error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..", "escaped")); err == nil {
+		t.Errorf("extractTarGz(../escaped) wrote a file outside the directory")
+	}
+}
@@ -0,0 +1,117 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buildkite/agent/v3/agent/plugin"
+)
+
+// pluginLock is the content of the plugin lock file. It maps each pipeline
+// ("org-slug/pipeline-slug") to the commits that its plugins (by label, e.g.
+// "github.com/buildkite-plugins/docker-buildkite-plugin#v5.0.0") resolved to
+// when they were first checked out.
+type pluginLock map[string]map[string]string
+
+// checkPluginLock checks that the plugin resolved to the same commit as it did
+// the first time this pipeline used it, and records the commit if this is the
+// first time. This stops a plugin's tag or branch being moved to different
+// code without anyone noticing.
+func (e *Executor) checkPluginLock(ctx context.Context, p *plugin.Plugin, commit string) error {
+	if e.PluginLockFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(e.PluginLockFile), 0o777); err != nil {
+		return err
+	}
+
+	// Multiple agent workers may share the same lock file
+	lockCtx, canc := context.WithTimeout(ctx, time.Minute)
+	defer canc()
+	unlocker, err := e.shell.LockFile(lockCtx, e.PluginLockFile+".lock")
+	if err != nil {
+		return fmt.Errorf("unable to acquire plugin lock file lock: %w", err)
+	}
+	defer unlocker.Unlock() //nolint:errcheck // Best-effort unlock
+
+	lock, err := readPluginLock(e.PluginLockFile)
+	if err != nil {
+		return err
+	}
+
+	pipeline := e.OrganizationSlug + "/" + e.PipelineSlug
+	label := p.Label()
+
+	switch locked, ok := lock[pipeline][label]; {
+	case !ok:
+		e.shell.Commentf("Recording plugin %q at commit %s in %s", label, commit, e.PluginLockFile)
+
+	case locked == commit:
+		if e.Debug {
+			e.shell.Commentf("Plugin %q matches locked commit %s", label, commit)
+		}
+		return nil
+
+	case e.PluginsAllowDrift:
+		e.shell.Warningf("Plugin %q was locked to commit %s, but now resolves to %s. Updating %s as drift is allowed",
+			label, locked, commit, e.PluginLockFile)
+
+	default:
+		return fmt.Errorf("plugin %q was locked to commit %s, but now resolves to %s. "+
+			"If this change is expected, remove the plugin from %s or start the agent with --plugins-allow-drift",
+			label, locked, commit, e.PluginLockFile)
+	}
+
+	if lock[pipeline] == nil {
+		lock[pipeline] = make(map[string]string)
+	}
+	lock[pipeline][label] = commit
+	return writePluginLock(e.PluginLockFile, lock)
+}
+
+// readPluginLock reads the plugin lock file at path. A missing file is
+// treated as empty.
+func readPluginLock(path string) (pluginLock, error) {
+	lock := make(pluginLock)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin lock file: %w", err)
+	}
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return nil, fmt.Errorf("parsing plugin lock file %s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// writePluginLock replaces the plugin lock file at path with lock.
+func writePluginLock(path string, lock pluginLock) error {
+	b, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck // Fails once renamed into place
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
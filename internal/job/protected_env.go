@@ -0,0 +1,52 @@
+package job
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"golang.org/x/exp/maps"
+)
+
+// protectedEnvChanges returns the names of the variables changed in diff that
+// match the ProtectedEnv patterns, sorted.
+func (e *Executor) protectedEnvChanges(diff env.Diff) []string {
+	if len(e.ProtectedEnv) == 0 {
+		return nil
+	}
+
+	names := maps.Keys(diff.Added)
+	names = append(names, maps.Keys(diff.Changed)...)
+	names = append(names, maps.Keys(diff.Removed)...)
+
+	var protected []string
+	for _, name := range names {
+		if matched, _ := redact.MatchAny(e.ProtectedEnv, name); matched {
+			protected = append(protected, name)
+		}
+	}
+	slices.Sort(protected)
+	return protected
+}
+
+// enforceProtectedEnv stops a hook from changing protected variables. If
+// ProtectedEnvEnforce is set it returns an error, otherwise it warns and
+// removes the changes from diff.
+func (e *Executor) enforceProtectedEnv(hookName string, diff *env.Diff) error {
+	protected := e.protectedEnvChanges(*diff)
+	if len(protected) == 0 {
+		return nil
+	}
+
+	if e.ProtectedEnvEnforce {
+		return fmt.Errorf("the %s hook tried to change protected environment variables: %s", hookName, strings.Join(protected, ", "))
+	}
+
+	for _, name := range protected {
+		e.shell.Warningf("Ignored the %s hook's change to protected environment variable %s", hookName, name)
+		diff.Remove(name)
+	}
+	return nil
+}
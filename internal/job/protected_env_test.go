@@ -0,0 +1,69 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/shell"
+	"github.com/google/go-cmp/cmp"
+)
+
+func protectedEnvTestDiff() env.Diff {
+	return env.Diff{
+		Added:   map[string]string{"AWS_REGION": "us-east-1", "LLAMAS": "yes"},
+		Changed: map[string]env.DiffPair{"DEPLOY_TARGET": {Old: "staging", New: "production"}},
+		Removed: map[string]struct{}{"AWS_PROFILE": {}},
+	}
+}
+
+func TestEnforceProtectedEnv_Warn(t *testing.T) {
+	t.Parallel()
+
+	e := New(ExecutorConfig{ProtectedEnv: []string{"AWS_*", "DEPLOY_TARGET"}})
+	e.shell = shell.NewTestShell(t)
+
+	diff := protectedEnvTestDiff()
+	if err := e.enforceProtectedEnv("global environment", &diff); err != nil {
+		t.Fatalf("e.enforceProtectedEnv() error = %v", err)
+	}
+
+	want := env.Diff{
+		Added:   map[string]string{"LLAMAS": "yes"},
+		Changed: map[string]env.DiffPair{},
+		Removed: map[string]struct{}{},
+	}
+	if d := cmp.Diff(diff, want); d != "" {
+		t.Errorf("diff after e.enforceProtectedEnv() (-got +want):\n%s", d)
+	}
+}
+
+func TestEnforceProtectedEnv_Enforce(t *testing.T) {
+	t.Parallel()
+
+	e := New(ExecutorConfig{ProtectedEnv: []string{"AWS_*", "DEPLOY_TARGET"}, ProtectedEnvEnforce: true})
+	e.shell = shell.NewTestShell(t)
+
+	diff := protectedEnvTestDiff()
+	err := e.enforceProtectedEnv("global environment", &diff)
+	if err == nil {
+		t.Fatal("e.enforceProtectedEnv() error = nil, want an error")
+	}
+	if got, want := err.Error(), "the global environment hook tried to change protected environment variables: AWS_PROFILE, AWS_REGION, DEPLOY_TARGET"; got != want {
+		t.Errorf("e.enforceProtectedEnv() error = %q, want %q", got, want)
+	}
+}
+
+func TestEnforceProtectedEnv_NoPatterns(t *testing.T) {
+	t.Parallel()
+
+	e := New(ExecutorConfig{ProtectedEnvEnforce: true})
+	e.shell = shell.NewTestShell(t)
+
+	diff := protectedEnvTestDiff()
+	if err := e.enforceProtectedEnv("global environment", &diff); err != nil {
+		t.Fatalf("e.enforceProtectedEnv() error = %v", err)
+	}
+	if d := cmp.Diff(diff, protectedEnvTestDiff()); d != "" {
+		t.Errorf("diff after e.enforceProtectedEnv() (-got +want):\n%s", d)
+	}
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// CommandSandboxBubblewrap runs the command phase with bubblewrap (bwrap),
+// which uses Linux namespaces to hide parts of the filesystem from it.
+const CommandSandboxBubblewrap = "bwrap"
+
+// ValidateCommandSandbox checks that sandbox is a supported command sandbox,
+// or empty for none.
+func ValidateCommandSandbox(sandbox string) error {
+	switch sandbox {
+	case "":
+		return nil
+	case CommandSandboxBubblewrap:
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("the %q command sandbox is only supported on Linux", sandbox)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid command sandbox %q. Only 'bwrap' is allowed.", sandbox)
+	}
+}
+
+// sandboxCommand wraps cmd so that it runs in the command sandbox, without
+// access to the agent's configuration, hooks, plugins, sockets (including the
+// Job API socket), git mirrors or env files, except for any paths that the
+// agent allows explicitly.
+func (e *Executor) sandboxCommand(cmd []string) ([]string, error) {
+	if err := ValidateCommandSandbox(e.CommandSandbox); err != nil {
+		return nil, err
+	}
+
+	bwrap, err := exec.LookPath("bwrap")
+	if err != nil {
+		return nil, fmt.Errorf("the %q command sandbox requires bubblewrap to be installed: %w", e.CommandSandbox, err)
+	}
+
+	hidden := []string{
+		e.HooksPath,
+		e.PluginsPath,
+		e.SocketsPath,
+		e.GitMirrorsPath,
+		e.PluginLockFile,
+	}
+	hidden = append(hidden, e.AdditionalHooksPaths...)
+	for _, name := range []string{
+		"BUILDKITE_CONFIG_PATH",
+		"BUILDKITE_AGENT_JOB_API_SOCKET",
+		"BUILDKITE_ENV_FILE",
+		"BUILDKITE_ENV_JSON_FILE",
+	} {
+		if path, ok := e.shell.Env.Get(name); ok {
+			hidden = append(hidden, path)
+		}
+	}
+
+	args := bubblewrapArgs(e.shell.Getwd(), hidden, e.CommandSandboxAllowPaths)
+	return append(append([]string{bwrap}, args...), cmd...), nil
+}
+
+// bubblewrapArgs returns the arguments to bwrap that run a command in
+// workDir, with a read-only view of the filesystem except for workDir, a
+// private /tmp, and the allowed paths. The hidden paths are replaced with
+// empty directories or files.
+func bubblewrapArgs(workDir string, hidden, allowed []string) []string {
+	args := []string{
+		"--die-with-parent",
+		"--unshare-pid",
+		"--unshare-ipc",
+		"--unshare-uts",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+
+	// bwrap can't hide paths that don't exist, and doesn't need to.
+	for _, path := range hidden {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			args = append(args, "--tmpfs", path)
+		} else {
+			args = append(args, "--ro-bind", os.DevNull, path)
+		}
+	}
+
+	// Binding paths after hiding them makes them visible again.
+	args = append(args, "--bind", workDir, workDir)
+	for _, path := range allowed {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		args = append(args, "--bind", path, path)
+	}
+
+	return append(args, "--chdir", workDir, "--")
+}
@@ -0,0 +1,69 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBubblewrapArgs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "builds", "pipeline")
+	hooksDir := filepath.Join(dir, "hooks")
+	socketsDir := filepath.Join(dir, "sockets")
+	configFile := filepath.Join(dir, "buildkite-agent.cfg")
+	for _, d := range []string{workDir, hooksDir, socketsDir} {
+		if err := os.MkdirAll(d, 0o777); err != nil {
+			t.Fatalf("os.MkdirAll(%q) error = %v", d, err)
+		}
+	}
+	if err := os.WriteFile(configFile, []byte("token=xxx\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", configFile, err)
+	}
+
+	hidden := []string{hooksDir, "", configFile, filepath.Join(dir, "missing"), socketsDir}
+	allowed := []string{socketsDir, filepath.Join(dir, "also-missing")}
+
+	got := bubblewrapArgs(workDir, hidden, allowed)
+	want := []string{
+		"--die-with-parent",
+		"--unshare-pid",
+		"--unshare-ipc",
+		"--unshare-uts",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--tmpfs", hooksDir,
+		"--ro-bind", os.DevNull, configFile,
+		"--tmpfs", socketsDir,
+		"--bind", workDir, workDir,
+		"--bind", socketsDir, socketsDir,
+		"--chdir", workDir,
+		"--",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("bubblewrapArgs(%q, %q, %q) diff (-got +want):\n%s", workDir, hidden, allowed, diff)
+	}
+}
+
+func TestValidateCommandSandbox(t *testing.T) {
+	t.Parallel()
+
+	if err := ValidateCommandSandbox(""); err != nil {
+		t.Errorf("ValidateCommandSandbox(%q) error = %v", "", err)
+	}
+	if err := ValidateCommandSandbox("nsjail"); err == nil {
+		t.Errorf("ValidateCommandSandbox(%q) error = nil, want an error", "nsjail")
+	}
+
+	err := ValidateCommandSandbox(CommandSandboxBubblewrap)
+	if gotErr, wantErr := err != nil, runtime.GOOS != "linux"; gotErr != wantErr {
+		t.Errorf("ValidateCommandSandbox(%q) error = %v, want error: %t", CommandSandboxBubblewrap, err, wantErr)
+	}
+}
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/buildkite/agent/v3/internal/shell"
+)
+
+// SoftFailMetaDataKeyPrefix is prefixed to the job ID to make the key of the
+// build meta-data that the command's exit status is recorded in, when the
+// executor soft fails the job.
+const SoftFailMetaDataKeyPrefix = "buildkite:soft-fail:"
+
+// softFailIfClassified soft fails the job if the command's exit status is one
+// of SoftFailExitStatuses, or if the SoftFailClassifier says it should be.
+// Problems soft failing the job are only warned about, as the job has failed
+// either way.
+func (e *Executor) softFailIfClassified(ctx context.Context, exitStatus int) {
+	soft, err := e.isSoftFail(ctx, exitStatus)
+	if err != nil {
+		e.shell.Warningf("Couldn't run the soft fail classifier: %v", err)
+		return
+	}
+	if !soft {
+		return
+	}
+
+	e.shell.Warningf("Exit status %d is a soft failure on this agent, so the step will be marked as soft failed", exitStatus)
+	if err := e.softFail(ctx, exitStatus); err != nil {
+		e.shell.Warningf("Couldn't soft fail the job: %v", err)
+	}
+}
+
+// isSoftFail reports whether the command exiting with exitStatus should soft
+// fail the job. The SoftFailClassifier is run with the job's environment,
+// including BUILDKITE_COMMAND_EXIT_STATUS, and exits with 0 if it should.
+func (e *Executor) isSoftFail(ctx context.Context, exitStatus int) (bool, error) {
+	if slices.Contains(e.SoftFailExitStatuses, exitStatus) {
+		return true, nil
+	}
+	if e.SoftFailClassifier == "" || ctx.Err() != nil {
+		return false, nil
+	}
+
+	e.shell.Commentf("Running the soft fail classifier %s", e.SoftFailClassifier)
+	err := e.shell.Command(e.SoftFailClassifier).Run(ctx, shell.ShowPrompt(false))
+	switch {
+	case err == nil:
+		return true, nil
+	case shell.IsExitError(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// softFail records the command's exit status in build meta-data, and makes the
+// step soft fail with that exit status. The job still exits with the command's
+// exit status, so the failure is reported, but it doesn't fail the build.
+//
+// Note: this relies on Buildkite honouring a change to soft_fail made while
+// the job is running, which it only does for jobs that haven't finished yet.
+// The step's soft_fail is replaced rather than added to, and only this exit
+// status is made a soft failure, so other jobs of the step (such as retries, or
+// other parallel jobs) that exit with it will soft fail too.
+func (e *Executor) softFail(ctx context.Context, exitStatus int) error {
+	jobID, _ := e.shell.Env.Get("BUILDKITE_JOB_ID")
+	cmd := e.shell.CloneWithStdin(strings.NewReader(strconv.Itoa(exitStatus))).Command("buildkite-agent", "meta-data", "set", "--scope", "build", SoftFailMetaDataKeyPrefix+jobID)
+	if err := cmd.Run(ctx); err != nil {
+		return fmt.Errorf("recording the exit status in meta-data: %w", err)
+	}
+
+	patch := fmt.Sprintf(`[{"op":"replace","path":"/soft_fail","value":[{"exit_status":%d}]}]`, exitStatus)
+	if err := e.shell.Command("buildkite-agent", "step", "update", "--patch", patch).Run(ctx); err != nil {
+		return fmt.Errorf("marking the step as soft failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/metrics"
+)
+
+// Ways the executor can report how long each phase of the job took.
+const (
+	// PhaseTimingsMetaData records the phase timings in build meta-data.
+	PhaseTimingsMetaData = "meta-data"
+
+	// PhaseTimingsAnnotation records the phase timings in build meta-data,
+	// and also shows them in a build annotation.
+	PhaseTimingsAnnotation = "annotation"
+)
+
+// PhaseTimingsMetaDataKeyPrefix is prefixed to the job ID to make the key of
+// the build meta-data that the job's phase timings are recorded in. The value
+// is a JSON object of phase names to durations in seconds.
+const PhaseTimingsMetaDataKeyPrefix = "buildkite:timings:"
+
+// ValidatePhaseTimings returns an error if the phase timings option isn't
+// supported.
+func ValidatePhaseTimings(phaseTimings string) error {
+	switch phaseTimings {
+	case "", PhaseTimingsMetaData, PhaseTimingsAnnotation:
+		return nil
+	default:
+		return fmt.Errorf("unsupported phase timings %q, must be %q or %q", phaseTimings, PhaseTimingsMetaData, PhaseTimingsAnnotation)
+	}
+}
+
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// phaseTimer keeps track of how long each phase of the job takes. Phases that
+// happen more than once (such as the plugin phase) are added together.
+type phaseTimer struct {
+	current string
+	started time.Time
+	timings []phaseTiming // in the order each phase first started
+}
+
+// start finishes the current phase, if any, and starts the named phase.
+func (t *phaseTimer) start(phase string, now time.Time) {
+	t.stop(now)
+	t.current, t.started = phase, now
+}
+
+// stop finishes the current phase, if any.
+func (t *phaseTimer) stop(now time.Time) {
+	if t.current == "" {
+		return
+	}
+	i := slices.IndexFunc(t.timings, func(pt phaseTiming) bool { return pt.name == t.current })
+	if i < 0 {
+		i = len(t.timings)
+		t.timings = append(t.timings, phaseTiming{name: t.current})
+	}
+	t.timings[i].duration += now.Sub(t.started)
+	t.current = ""
+}
+
+// metaData returns the phase timings as a JSON object of phase names to
+// durations in seconds, rounded to milliseconds.
+func (t *phaseTimer) metaData() (string, error) {
+	seconds := make(map[string]float64, len(t.timings))
+	for _, pt := range t.timings {
+		seconds[pt.name] = math.Round(pt.duration.Seconds()*1000) / 1000
+	}
+	out, err := json.Marshal(seconds)
+	return string(out), err
+}
+
+// annotation returns a Markdown table of the phase timings.
+func (t *phaseTimer) annotation(label string) string {
+	var total time.Duration
+	for _, pt := range t.timings {
+		total += pt.duration
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** took %v\n\n", label, total.Round(time.Millisecond))
+	b.WriteString("| Phase | Duration | Share |\n")
+	b.WriteString("| --- | ---: | ---: |\n")
+	for _, pt := range t.timings {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(pt.duration) / float64(total)
+		}
+		fmt.Fprintf(&b, "| %s | %v | %.0f%% |\n", pt.name, pt.duration.Round(time.Millisecond), share)
+	}
+	return b.String()
+}
+
+// recordPhaseTimings records the phase timings in build meta-data, and shows
+// them in an annotation if PhaseTimings is PhaseTimingsAnnotation. It should
+// be called once the job has finished.
+func (e *Executor) recordPhaseTimings(ctx context.Context) error {
+	e.phaseTimer.stop(time.Now())
+	if len(e.phaseTimer.timings) == 0 {
+		return nil
+	}
+
+	jobID, _ := e.shell.Env.Get("BUILDKITE_JOB_ID")
+	value, err := e.phaseTimer.metaData()
+	if err != nil {
+		return fmt.Errorf("encoding phase timings: %w", err)
+	}
+	cmd := e.shell.CloneWithStdin(strings.NewReader(value)).Command("buildkite-agent", "meta-data", "set", "--scope", "build", PhaseTimingsMetaDataKeyPrefix+jobID)
+	if err := cmd.Run(ctx); err != nil {
+		return fmt.Errorf("recording phase timings in meta-data: %w", err)
+	}
+
+	if e.PhaseTimings != PhaseTimingsAnnotation {
+		return nil
+	}
+
+	label, _ := e.shell.Env.Get("BUILDKITE_LABEL")
+	if label == "" {
+		label = "Job " + jobID
+	}
+	body := e.phaseTimer.annotation(label)
+	cmd = e.shell.CloneWithStdin(strings.NewReader(body)).Command("buildkite-agent", "annotate",
+		"--style", "info",
+		"--context", "buildkite-timings-"+jobID,
+	)
+	if err := cmd.Run(ctx); err != nil {
+		return fmt.Errorf("annotating the build with phase timings: %w", err)
+	}
+	return nil
+}
+
+// recordPhaseMetrics appends the phase durations to the job's metrics file, so
+// that the agent reports them once the job finishes. It does nothing if the
+// agent isn't collecting metrics.
+func (e *Executor) recordPhaseMetrics() error {
+	e.phaseTimer.stop(time.Now())
+	for _, pt := range e.phaseTimer.timings {
+		if err := metrics.AppendJobTiming("jobs.phase.duration", pt.duration, metrics.Tags{"phase": pt.name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
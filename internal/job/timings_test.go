@@ -0,0 +1,60 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPhaseTimer(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	var timer phaseTimer
+	timer.start("setup", at(0))
+	timer.start("plugin", at(1*time.Second))
+	timer.start("checkout", at(1500*time.Millisecond))
+	timer.start("plugin", at(4*time.Second))
+	timer.start("command", at(4250*time.Millisecond))
+	timer.start("artifact", at(8*time.Second))
+	timer.start("teardown", at(9*time.Second))
+	timer.stop(at(10 * time.Second))
+
+	gotMetaData, err := timer.metaData()
+	if err != nil {
+		t.Fatalf("timer.metaData() error = %v", err)
+	}
+	wantMetaData := `{"artifact":1,"checkout":2.5,"command":3.75,"plugin":0.75,"setup":1,"teardown":1}`
+	if diff := cmp.Diff(gotMetaData, wantMetaData); diff != "" {
+		t.Errorf("timer.metaData() diff (-got +want):\n%s", diff)
+	}
+
+	wantAnnotation := "**:pipeline: Test** took 10s\n\n" +
+		"| Phase | Duration | Share |\n" +
+		"| --- | ---: | ---: |\n" +
+		"| setup | 1s | 10% |\n" +
+		"| plugin | 750ms | 8% |\n" +
+		"| checkout | 2.5s | 25% |\n" +
+		"| command | 3.75s | 38% |\n" +
+		"| artifact | 1s | 10% |\n" +
+		"| teardown | 1s | 10% |\n"
+	if diff := cmp.Diff(timer.annotation(":pipeline: Test"), wantAnnotation); diff != "" {
+		t.Errorf("timer.annotation() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestValidatePhaseTimings(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []string{"", "meta-data", "annotation"} {
+		if err := ValidatePhaseTimings(v); err != nil {
+			t.Errorf("ValidatePhaseTimings(%q) = %v, want nil", v, err)
+		}
+	}
+	if err := ValidatePhaseTimings("statsd"); err == nil {
+		t.Errorf("ValidatePhaseTimings(%q) = nil, want error", "statsd")
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"os"
 	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/buildkite/agent/v3/env"
+	"github.com/buildkite/agent/v3/internal/redact"
+	"github.com/buildkite/agent/v3/internal/replacer"
+	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/agent/v3/tracetools"
 	"github.com/buildkite/agent/v3/version"
 	"github.com/opentracing/opentracing-go"
@@ -297,3 +301,67 @@ func (e *Executor) implementationSpecificSpanName(otelName, ddName string) strin
 		return otelName
 	}
 }
+
+// hookOutput records the tail of a hook's stdout and stderr, redacted, so it
+// can be added to the hook's span as events.
+type hookOutput struct {
+	stdout, stderr                 *tailBuffer
+	stdoutRedactor, stderrRedactor *replacer.Replacer
+}
+
+// newHookOutput returns a hookOutput that keeps up to limit bytes of each of
+// stdout and stderr. Output is redacted with the needles that are current when
+// it is created.
+func (e *Executor) newHookOutput(limit int) *hookOutput {
+	needles := e.redactors.Needles()
+	o := &hookOutput{
+		stdout: &tailBuffer{limit: limit},
+		stderr: &tailBuffer{limit: limit},
+	}
+	o.stdoutRedactor = replacer.New(o.stdout, needles, redact.Redact)
+	o.stderrRedactor = replacer.New(o.stderr, needles, redact.Redact)
+	return o
+}
+
+// runOpt returns an option for shell.Command.Run that copies output to o.
+func (o *hookOutput) runOpt() shell.RunCommandOpt {
+	return shell.CopyOutput(o.stdoutRedactor, o.stderrRedactor)
+}
+
+// addTo adds the recorded output to the span as events, one for each of
+// stdout and stderr that had any output.
+func (o *hookOutput) addTo(span tracetools.Span) {
+	for _, stream := range []struct {
+		name     string
+		redactor *replacer.Replacer
+		buf      *tailBuffer
+	}{
+		{name: "stdout", redactor: o.stdoutRedactor, buf: o.stdout},
+		{name: "stderr", redactor: o.stderrRedactor, buf: o.stderr},
+	} {
+		_ = stream.redactor.Flush()
+		if len(stream.buf.buf) == 0 {
+			continue
+		}
+		span.AddEvent("hook."+stream.name, map[string]string{
+			"output":    strings.ToValidUTF8(string(stream.buf.buf), "�"),
+			"truncated": strconv.FormatBool(stream.buf.truncated),
+		})
+	}
+}
+
+// tailBuffer is an io.Writer that keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
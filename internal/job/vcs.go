@@ -0,0 +1,295 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent/v3/internal/osutil"
+)
+
+// Version control systems that the default checkout supports, as selected by
+// BUILDKITE_VCS.
+const (
+	VCSGit       = "git"
+	VCSMercurial = "hg"
+	VCSPerforce  = "p4"
+	VCSTarball   = "tar"
+)
+
+// vcs checks out the repository into the checkout directory, when there's no
+// checkout hook. CheckoutPhase takes care of retrying failed checkouts.
+type vcs interface {
+	// checkout gets the repository at the job's commit into the checkout
+	// directory, which is the shell's working directory.
+	checkout(ctx context.Context) error
+
+	// commitInfo describes the checked out commit in the format stored in
+	// CommitMetadataKey, or returns "" if the VCS can't describe it.
+	commitInfo(ctx context.Context) (string, error)
+}
+
+// vcses maps each supported value of BUILDKITE_VCS to a constructor for it.
+var vcses = map[string]func(*Executor) vcs{
+	VCSGit:       func(e *Executor) vcs { return gitVCS{e} },
+	VCSMercurial: func(e *Executor) vcs { return mercurialVCS{e} },
+	VCSPerforce:  func(e *Executor) vcs { return perforceVCS{e} },
+	VCSTarball:   func(e *Executor) vcs { return tarballVCS{e} },
+}
+
+// ValidateVCS returns an error if the version control system isn't supported.
+// Empty means git.
+func ValidateVCS(name string) error {
+	if _, ok := vcses[name]; ok || name == "" {
+		return nil
+	}
+	names := make([]string, 0, len(vcses))
+	for n := range vcses {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return fmt.Errorf("unsupported VCS %q, must be one of %s", name, strings.Join(names, ", "))
+}
+
+// vcs returns the version control system selected by BUILDKITE_VCS.
+func (e *Executor) vcs() (vcs, error) {
+	if err := ValidateVCS(e.VCS); err != nil {
+		return nil, err
+	}
+	if e.VCS == "" {
+		return vcses[VCSGit](e), nil
+	}
+	return vcses[e.VCS](e), nil
+}
+
+// gitVCS checks out git repositories, using mirrors, bundles, submodules and
+// LFS as configured.
+type gitVCS struct{ e *Executor }
+
+func (v gitVCS) checkout(ctx context.Context) error {
+	return v.e.defaultCheckoutPhase(ctx)
+}
+
+func (v gitVCS) commitInfo(ctx context.Context) (string, error) {
+	// Format:
+	//
+	// commit 0123456789abcdef0123456789abcdef01234567
+	// abbrev-commit 0123456789
+	// Author: John Citizen <john@example.com>
+	//
+	//    Subject of the commit message
+	//
+	//    Body of the commit message, which
+	//    may span multiple lines.
+	gitArgs := []string{
+		"--no-pager",
+		"log",
+		"-1",
+		v.e.Commit,
+		"-s", // --no-patch was introduced in v1.8.4 in 2013, but e.g. CentOS 7 isn't there yet
+		"--no-color",
+		"--format=commit %H%nabbrev-commit %h%nAuthor: %an <%ae>%n%n%w(0,4,4)%B",
+	}
+	out, err := v.e.shell.Command("git", gitArgs...).RunAndCaptureStdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting git commit information: %w", err)
+	}
+	return out, nil
+}
+
+// mercurialVCS checks out Mercurial repositories. The commit can be a
+// changeset ID, or HEAD for the tip of the branch (or bookmark).
+type mercurialVCS struct{ e *Executor }
+
+func (v mercurialVCS) checkout(ctx context.Context) error {
+	sh := v.e.shell
+
+	if osutil.FileExists(filepath.Join(sh.Getwd(), ".hg")) {
+		if err := sh.Command("hg", "pull", "--", v.e.Repository).Run(ctx); err != nil {
+			return fmt.Errorf("pulling mercurial repository: %w", err)
+		}
+	} else {
+		if err := sh.Command("hg", "clone", "--noupdate", "--", v.e.Repository, ".").Run(ctx); err != nil {
+			return fmt.Errorf("cloning mercurial repository: %w", err)
+		}
+	}
+
+	rev := v.e.Commit
+	if rev == "" || rev == "HEAD" {
+		rev = v.e.Branch
+	}
+	if rev == "" {
+		rev = "tip"
+	}
+	if err := sh.Command("hg", "update", "--clean", "--rev", rev).Run(ctx); err != nil {
+		return fmt.Errorf("updating to %q: %w", rev, err)
+	}
+
+	// purge is bundled with Mercurial, but older versions need it enabled
+	if err := sh.Command("hg", "--config", "extensions.purge=", "purge", "--all").Run(ctx); err != nil {
+		return fmt.Errorf("cleaning mercurial working directory: %w", err)
+	}
+	return nil
+}
+
+func (v mercurialVCS) commitInfo(ctx context.Context) (string, error) {
+	out, err := v.e.shell.Command("hg", "log", "--rev", ".",
+		"--template", `commit {node}\nabbrev-commit {node|short}\nAuthor: {author}\n\n{indent(desc, "    ", "    ")}\n`,
+	).RunAndCaptureStdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting mercurial commit information: %w", err)
+	}
+	return out, nil
+}
+
+// perforceVCS syncs a Perforce depot path (the repository, such as
+// //depot/project) into a client workspace rooted at the checkout directory.
+// The commit can be a changelist number, or HEAD for the latest revision. The
+// server and credentials come from the usual P4PORT, P4USER and P4TICKETS
+// (or P4PASSWD) environment variables.
+type perforceVCS struct{ e *Executor }
+
+// perforceClientNameRE matches characters that shouldn't be in client names.
+var perforceClientNameRE = regexp.MustCompile(`[^[:alnum:]._-]`)
+
+// client returns the name of the client workspace to sync into, which is
+// P4CLIENT if set, or else unique to the agent and pipeline.
+func (v perforceVCS) client() string {
+	if client, ok := v.e.shell.Env.Get("P4CLIENT"); ok && client != "" {
+		return client
+	}
+	name := strings.Join([]string{"buildkite", v.e.AgentName, v.e.OrganizationSlug, v.e.PipelineSlug}, "-")
+	return perforceClientNameRE.ReplaceAllString(name, "-")
+}
+
+// depotPath returns the repository as a depot path that includes everything
+// beneath it.
+func (v perforceVCS) depotPath() string {
+	if strings.HasSuffix(v.e.Repository, "...") {
+		return v.e.Repository
+	}
+	return strings.TrimSuffix(v.e.Repository, "/") + "/..."
+}
+
+// perforceClientSpec returns the spec for a client workspace that maps the
+// depot path into root.
+func perforceClientSpec(client, root, depotPath string) string {
+	return fmt.Sprintf("Client: %s\n\nRoot: %s\n\nOptions: allwrite clobber nocompress unlocked nomodtime rmdir\n\nLineEnd: local\n\nView:\n\t\"%s\" \"//%s/...\"\n",
+		client, root, depotPath, client)
+}
+
+func (v perforceVCS) checkout(ctx context.Context) error {
+	sh := v.e.shell
+	client, depotPath := v.client(), v.depotPath()
+
+	spec := perforceClientSpec(client, sh.Getwd(), depotPath)
+	if err := sh.CloneWithStdin(strings.NewReader(spec)).Command("p4", "client", "-i").Run(ctx); err != nil {
+		return fmt.Errorf("creating perforce client workspace %q: %w", client, err)
+	}
+
+	rev := "#head"
+	if v.e.Commit != "" && v.e.Commit != "HEAD" {
+		rev = "@" + v.e.Commit
+	}
+	if err := sh.Command("p4", "-c", client, "sync", depotPath+rev).Run(ctx); err != nil {
+		return fmt.Errorf("syncing %s%s: %w", depotPath, rev, err)
+	}
+
+	// Revert local changes, and remove files that aren't in the depot
+	if err := sh.Command("p4", "-c", client, "clean", depotPath).Run(ctx); err != nil {
+		return fmt.Errorf("cleaning perforce client workspace %q: %w", client, err)
+	}
+	return nil
+}
+
+// perforceChangeRE matches the first line of `p4 changes -l`.
+var perforceChangeRE = regexp.MustCompile(`^Change (\d+) on \S+ by ([^@\s]+)@`)
+
+func (v perforceVCS) commitInfo(ctx context.Context) (string, error) {
+	out, err := v.e.shell.Command("p4", "-c", v.client(), "changes", "-m1", "-l", v.depotPath()+"#have").RunAndCaptureStdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting perforce changelist information: %w", err)
+	}
+	return perforceCommitInfo(out)
+}
+
+// perforceCommitInfo converts the output of `p4 changes -l` for a single
+// changelist into the format stored in CommitMetadataKey.
+func perforceCommitInfo(changes string) (string, error) {
+	header, desc, _ := strings.Cut(changes, "\n")
+	m := perforceChangeRE.FindStringSubmatch(header)
+	if m == nil {
+		return "", fmt.Errorf("unexpected output from p4 changes: %q", header)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "commit %s\nabbrev-commit %s\nAuthor: %s\n\n", m[1], m[1], m[2])
+	for _, line := range strings.Split(strings.Trim(desc, "\n"), "\n") {
+		if line = strings.TrimPrefix(line, "\t"); line != "" {
+			b.WriteString("    " + line)
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// tarballVCS downloads a gzipped tarball (the repository, as a URL or a local
+// path) and extracts it into the checkout directory, replacing what was
+// there. If the repository contains {commit}, it's replaced with the commit.
+// If every file is within a single top-level directory, it is stripped.
+type tarballVCS struct{ e *Executor }
+
+func (v tarballVCS) checkout(ctx context.Context) error {
+	sh := v.e.shell
+	source := strings.ReplaceAll(v.e.Repository, "{commit}", v.e.Commit)
+
+	var r io.Reader
+	if strings.Contains(source, "://") && !strings.HasPrefix(source, "file://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return err
+		}
+		sh.Commentf("Downloading %s", redactURL(req.URL))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("downloading tarball: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("downloading tarball: GET %s: %s", redactURL(req.URL), resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return fmt.Errorf("opening tarball: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dir := sh.Getwd()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("emptying checkout directory: %w", err)
+		}
+	}
+
+	sh.Commentf("Extracting tarball into %s", dir)
+	if err := extractTarGz(r, dir); err != nil {
+		return fmt.Errorf("extracting tarball: %w", err)
+	}
+	return nil
+}
+
+func (tarballVCS) commitInfo(context.Context) (string, error) { return "", nil }
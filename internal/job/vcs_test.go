@@ -0,0 +1,45 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateVCS(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []string{"", "git", "hg", "p4", "tar"} {
+		if err := ValidateVCS(v); err != nil {
+			t.Errorf("ValidateVCS(%q) = %v, want nil", v, err)
+		}
+	}
+	if err := ValidateVCS("svn"); err == nil {
+		t.Errorf("ValidateVCS(%q) = nil, want error", "svn")
+	}
+}
+
+func TestPerforceCommitInfo(t *testing.T) {
+	t.Parallel()
+
+	changes := "Change 1234 on 2024/01/01 by legit@buildkite-agent\n\n" +
+		"\tFix the frobnicator\n" +
+		"\t\n" +
+		"\tIt was frobnicating backwards.\n\n"
+
+	got, err := perforceCommitInfo(changes)
+	if err != nil {
+		t.Fatalf("perforceCommitInfo(%q) error = %v", changes, err)
+	}
+	want := "commit 1234\nabbrev-commit 1234\nAuthor: legit\n\n" +
+		"    Fix the frobnicator\n" +
+		"\n" +
+		"    It was frobnicating backwards.\n"
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("perforceCommitInfo(%q) diff (-got +want):\n%s", changes, diff)
+	}
+
+	if _, err := perforceCommitInfo("Path '//depot/...' is not under client's root\n"); err == nil {
+		t.Errorf("perforceCommitInfo(unexpected output) = nil error, want error")
+	}
+}
@@ -2,9 +2,13 @@
 package redact
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"path"
 	"slices"
+	"strings"
 
 	"github.com/buildkite/agent/v3/env"
 )
@@ -76,3 +80,89 @@ func Vars(patterns []string, environment []env.Pair) (matched []env.Pair, short
 
 	return matched, short, nil
 }
+
+// Variants returns the value along with the other forms it might appear in
+// within log output: without surrounding whitespace, line by line (for
+// PEM-encoded values such as private keys), base64 (standard and URL-safe),
+// URL-encoded, and escaped as a JSON string. Each should be redacted alongside
+// the value.
+func Variants(value string) []string {
+	variants := []string{value}
+	add := func(v string) {
+		if len(v) >= LengthMin && !slices.Contains(variants, v) {
+			variants = append(variants, v)
+		}
+	}
+
+	// Shells (and plenty of other tools) drop trailing newlines, so the value
+	// is redacted without surrounding whitespace. The encodings below are only
+	// of the trimmed value, as values are usually trimmed before they are
+	// encoded.
+	trimmed := strings.TrimSpace(value)
+	add(trimmed)
+
+	// PEM-encoded keys and certificates are often printed with each line
+	// indented or prefixed, so redact each of their lines individually. Other
+	// multi-line values aren't split, as their lines can be common enough
+	// (such as a line of a config file) to redact unrelated output. PEM
+	// boundary lines aren't secret, and would hide which kind of key is being
+	// printed.
+	if isPEM(trimmed) {
+		for _, line := range strings.Split(trimmed, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "-----BEGIN ") || strings.HasPrefix(line, "-----END ") {
+				continue
+			}
+			add(line)
+		}
+	}
+
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+		for _, v := range base64Variants(enc, trimmed) {
+			add(v)
+		}
+	}
+
+	add(url.QueryEscape(trimmed))
+	add(url.PathEscape(trimmed))
+
+	// json.Marshal escapes HTML characters, which json.Encoder can be told not to
+	var sb strings.Builder
+	jenc := json.NewEncoder(&sb)
+	jenc.SetEscapeHTML(false)
+	if err := jenc.Encode(trimmed); err == nil {
+		add(strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(sb.String(), `"`), "\n"), `"`))
+	}
+	if b, err := json.Marshal(trimmed); err == nil {
+		add(strings.TrimSuffix(strings.TrimPrefix(string(b), `"`), `"`))
+	}
+
+	return variants
+}
+
+// isPEM reports whether value looks like PEM-encoded data, such as a private
+// key.
+func isPEM(value string) bool {
+	return strings.Contains(value, "-----BEGIN ") && strings.Contains(value, "-----END ")
+}
+
+// base64Variants returns the parts of the base64 encoding of value that don't
+// depend on the bytes around it. Because base64 encodes 3 bytes at a time, the
+// encoding of a value embedded in a longer string (such as "user:password" in
+// a basic auth header) depends on where it starts, so there is one variant for
+// each of the 3 possible alignments.
+func base64Variants(enc *base64.Encoding, value string) []string {
+	variants := make([]string, 0, 3)
+	for offset := range 3 {
+		encoded := enc.EncodeToString([]byte(strings.Repeat("\x00", offset) + value))
+
+		// Drop the characters that encode any of the offset bytes, and the
+		// final character if it would also encode whatever follows the value.
+		start := (offset*8 + 5) / 6
+		end := (offset + len(value)) * 8 / 6
+		if start < end {
+			variants = append(variants, encoded[start:end])
+		}
+	}
+	return variants
+}
@@ -32,6 +32,15 @@ func (m *Mux) Add(needles ...string) {
 	}
 }
 
+// Needles returns the current needles. All replacers in the mux share the same
+// needles, so they are taken from the first.
+func (m *Mux) Needles() []string {
+	if len(m.underlying) == 0 {
+		return nil
+	}
+	return m.underlying[0].Needles()
+}
+
 // Append adds a replacer to the Mux.
 func (m *Mux) Append(r *Replacer) {
 	m.underlying = append(m.underlying, r)
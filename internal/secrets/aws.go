@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/buildkite/agent/v3/internal/awslib"
+)
+
+// AWSSecretsManagerProcessor resolves references to secrets in AWS Secrets
+// Manager, of the form "aws-sm://<secret id>#<field>", where the secret ID is
+// the secret's name or ARN. The field is optional: without it the whole secret
+// string is used, and with it the secret string is parsed as a JSON object
+// and the field's value is used. For example:
+//
+//	aws-sm://arn:aws:secretsmanager:us-east-1:123456789012:secret:ci-AbCdEf#deploy_token
+//
+// Credentials come from the default AWS credential chain. The region comes
+// from the ARN if there is one, otherwise from the environment or instance
+// metadata.
+type AWSSecretsManagerProcessor struct {
+	// NewClient returns a Secrets Manager client for the region. If nil, a
+	// client using the default credential chain is created.
+	NewClient func(region string) (secretsmanageriface.SecretsManagerAPI, error)
+}
+
+// Process fetches the secret from AWS Secrets Manager.
+func (p *AWSSecretsManagerProcessor) Process(ctx context.Context, ref string) (string, error) {
+	// Secret names can't contain "#", but ARNs end with the name, so split on
+	// the last one
+	id, name := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		id, name = ref[:i], ref[i+1:]
+	}
+	if id == "" {
+		return "", errors.New("missing secret ID")
+	}
+
+	region := ""
+	if a, err := arn.Parse(id); err == nil {
+		region = a.Region
+	} else if region, err = awslib.Region(); err != nil {
+		return "", fmt.Errorf("finding AWS region: %w", err)
+	}
+
+	newClient := p.NewClient
+	if newClient == nil {
+		newClient = newSecretsManagerClient
+	}
+	client, err := newClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %s has a binary value, which isn't supported", id)
+	}
+
+	if name == "" {
+		return *out.SecretString, nil
+	}
+	var object map[string]any
+	if err := json.Unmarshal([]byte(*out.SecretString), &object); err != nil {
+		return "", fmt.Errorf("secret %s isn't a JSON object, so it has no field %q", id, name)
+	}
+	return field(object, name)
+}
+
+func newSecretsManagerClient(region string) (secretsmanageriface.SecretsManagerAPI, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	return secretsmanager.New(sess), nil
+}
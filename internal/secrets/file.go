@@ -0,0 +1,58 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// FileProcessor writes secrets to files, for tools that only accept
+// credentials by path (such as kubeconfigs, .npmrc files and private keys).
+// It implements Processor, but the input to Process is the secret itself and
+// the output is the path of the file it was written to.
+type FileProcessor struct {
+	// Dir is the directory to create files in. Jobs should use a directory
+	// that is removed when the job finishes.
+	Dir string
+
+	// Pattern is the pattern for file names, as for os.CreateTemp.
+	Pattern string
+
+	// Mode is the permissions for each file. If zero, files are only readable
+	// and writable by their owner (0o600).
+	Mode fs.FileMode
+}
+
+// Process writes secret to a new file, and returns the file's path.
+func (p *FileProcessor) Process(_ context.Context, secret string) (string, error) {
+	if p.Dir == "" {
+		return "", errors.New("no directory to write secret files in")
+	}
+
+	f, err := os.CreateTemp(p.Dir, p.Pattern)
+	if err != nil {
+		return "", err
+	}
+
+	mode := p.Mode
+	if mode == 0 {
+		mode = 0o600
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name()) //nolint:errcheck // Best-effort cleanup
+		return "", err
+	}
+
+	if _, err := f.WriteString(secret); err != nil {
+		f.Close()
+		os.Remove(f.Name()) //nolint:errcheck // Best-effort cleanup
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name()) //nolint:errcheck // Best-effort cleanup
+		return "", err
+	}
+	return f.Name(), nil
+}
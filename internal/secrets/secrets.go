@@ -0,0 +1,76 @@
+// Package secrets resolves Buildkite secrets whose values refer to secrets
+// held in another secret store, such as HashiCorp Vault or AWS Secrets Manager.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Processor resolves a reference to a secret held in a secret store.
+type Processor interface {
+	// Process returns the secret that ref refers to. ref is the part of the
+	// reference after the scheme, e.g. "kv/data/foo#token" for the reference
+	// "vault://kv/data/foo#token".
+	Process(ctx context.Context, ref string) (string, error)
+}
+
+// DefaultProcessors are the processors used by Resolve, by URL scheme. They
+// use ambient credentials: the usual Vault environment variables, and the
+// default AWS credential chain.
+var DefaultProcessors = map[string]Processor{
+	"vault":  &VaultProcessor{},
+	"aws-sm": &AWSSecretsManagerProcessor{},
+}
+
+// Resolve returns the secret that value refers to, if it is a reference with
+// a scheme in processors (such as "vault://kv/data/foo#token"). Otherwise value
+// is the secret itself, and is returned unchanged.
+func Resolve(ctx context.Context, processors map[string]Processor, value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, "://")
+	if !ok {
+		return value, nil
+	}
+	p, ok := processors[scheme]
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := p.Process(ctx, ref)
+	if err != nil {
+		// Don't include the whole value, in case it isn't a reference after all
+		return "", fmt.Errorf("resolving %s:// secret reference: %w", scheme, err)
+	}
+	return secret, nil
+}
+
+// field returns the value of the named field in a JSON object. If name is
+// empty, the object must have exactly one field.
+func field(object map[string]any, name string) (string, error) {
+	if name == "" {
+		if len(object) != 1 {
+			return "", fmt.Errorf("secret has %d fields, so the reference must name one after #", len(object))
+		}
+		for k := range object {
+			name = k
+		}
+	}
+
+	v, ok := object[name]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", name)
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	default:
+		// Secrets are strings, so anything else is returned as JSON
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,169 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+func TestResolveVault(t *testing.T) {
+	t.Parallel()
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Vault-Token"), "s.llamas"; got != want {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/ci":
+			fmt.Fprint(w, `{"data":{"data":{"deploy_token":"hunter2","port":8080},"metadata":{"version":3}}}`)
+		case "/v1/secret/ci":
+			fmt.Fprint(w, `{"data":{"deploy_token":"hunter3"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(svr.Close)
+
+	env := map[string]string{"VAULT_ADDR": svr.URL, "VAULT_TOKEN": "s.llamas"}
+	processors := map[string]Processor{
+		"vault": &VaultProcessor{Client: svr.Client(), Getenv: func(k string) string { return env[k] }},
+	}
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "vault://kv/data/ci#deploy_token", want: "hunter2"},
+		{value: "vault://kv/data/ci#port", want: "8080"},
+		{value: "vault://secret/ci", want: "hunter3"},
+		{value: "vault://kv/data/ci", wantErr: true},
+		{value: "vault://kv/data/ci#nope", wantErr: true},
+		{value: "vault://kv/data/missing#deploy_token", wantErr: true},
+		{value: "not-a-reference", want: "not-a-reference"},
+		{value: "https://example.com", want: "https://example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Resolve(context.Background(), processors, tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Resolve(%q) error = %v, wantErr = %t", tc.value, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValueWithContext(_ aws.Context, in *secretsmanager.GetSecretValueInput, _ ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	s, ok := f.secrets[*in.SecretId]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", *in.SecretId)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(s)}, nil
+}
+
+func TestResolveAWSSecretsManager(t *testing.T) {
+	t.Parallel()
+
+	const arn = "arn:aws:secretsmanager:ap-southeast-2:123456789012:secret:ci-AbCdEf"
+	fake := &fakeSecretsManager{secrets: map[string]string{
+		arn: `{"deploy_token":"hunter2"}`,
+	}}
+
+	var regions []string
+	processors := map[string]Processor{
+		"aws-sm": &AWSSecretsManagerProcessor{
+			NewClient: func(region string) (secretsmanageriface.SecretsManagerAPI, error) {
+				regions = append(regions, region)
+				return fake, nil
+			},
+		},
+	}
+
+	ctx := context.Background()
+
+	got, err := Resolve(ctx, processors, "aws-sm://"+arn+"#deploy_token")
+	if err != nil {
+		t.Fatalf("Resolve(aws-sm://%s#deploy_token) error = %v", arn, err)
+	}
+	if want := "hunter2"; got != want {
+		t.Errorf("Resolve(aws-sm://%s#deploy_token) = %q, want %q", arn, got, want)
+	}
+
+	got, err = Resolve(ctx, processors, "aws-sm://"+arn)
+	if err != nil {
+		t.Fatalf("Resolve(aws-sm://%s) error = %v", arn, err)
+	}
+	if want := `{"deploy_token":"hunter2"}`; got != want {
+		t.Errorf("Resolve(aws-sm://%s) = %q, want %q", arn, got, want)
+	}
+
+	if _, err := Resolve(ctx, processors, "aws-sm://"+arn+"#nope"); err == nil {
+		t.Errorf("Resolve(aws-sm://%s#nope) error = nil, want an error", arn)
+	}
+
+	for _, r := range regions {
+		if r != "ap-southeast-2" {
+			t.Errorf("NewClient(%q), want region from ARN ap-southeast-2", r)
+		}
+	}
+}
+
+func TestFileProcessor(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	p := &FileProcessor{Dir: dir, Pattern: "kubeconfig-*", Mode: 0o640}
+
+	path, err := p.Process(context.Background(), "apiVersion: v1\nkind: Config\n")
+	if err != nil {
+		t.Fatalf("p.Process() error = %v", err)
+	}
+	if got := filepath.Dir(path); got != dir {
+		t.Errorf("filepath.Dir(p.Process()) = %q, want %q", got, dir)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "kubeconfig-") {
+		t.Errorf("filepath.Base(p.Process()) = %q, want prefix kubeconfig-", filepath.Base(path))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile(%q) error = %v", path, err)
+	}
+	if got, want := string(b), "apiVersion: v1\nkind: Config\n"; got != want {
+		t.Errorf("secret file contents = %q, want %q", got, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("os.Stat(%q) error = %v", path, err)
+		}
+		if got, want := fi.Mode().Perm(), fs.FileMode(0o640); got != want {
+			t.Errorf("secret file mode = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VaultProcessor resolves references to secrets in HashiCorp Vault, of the
+// form "vault://<path>#<field>", e.g. "vault://kv/data/ci#deploy_token". Both
+// version 1 and version 2 KV secrets engines are supported.
+//
+// The Vault address, token and namespace are read from VAULT_ADDR,
+// VAULT_TOKEN (or ~/.vault-token) and VAULT_NAMESPACE, as the vault CLI does.
+type VaultProcessor struct {
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Getenv looks up environment variables. If nil, os.Getenv is used.
+	Getenv func(string) string
+}
+
+// Process fetches the secret from Vault.
+func (v *VaultProcessor) Process(ctx context.Context, ref string) (string, error) {
+	path, name, _ := strings.Cut(ref, "#")
+	if path == "" {
+		return "", errors.New("missing secret path")
+	}
+
+	getenv := v.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	addr := getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken(getenv)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return "", fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reading %s from Vault: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding Vault response: %w", err)
+	}
+
+	// The KV version 2 engine nests the secret inside data.data, alongside
+	// data.metadata
+	data := body.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return field(data, name)
+}
+
+// vaultToken returns the token from VAULT_TOKEN, or from the token helper
+// file written by "vault login".
+func vaultToken(getenv func(string) string) (string, error) {
+	if token := getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("VAULT_TOKEN is not set")
+	}
+	b, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+	if err != nil {
+		return "", errors.New("VAULT_TOKEN is not set, and there is no ~/.vault-token")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -5,3 +5,10 @@ import "time"
 func Round(d time.Duration) time.Duration {
 	return round(d)
 }
+
+// SetNow replaces the clock, and restarts the job at now().
+func (j *JSONLines) SetNow(now func() time.Time) {
+	j.now = now
+	j.start = now()
+	j.prev = j.start
+}
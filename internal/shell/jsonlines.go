@@ -0,0 +1,177 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// jsonLinesMaxLine is the length at which a line without a newline is written
+// out anyway, so that output like progress bars can't grow without bound.
+const jsonLinesMaxLine = 64 * 1024
+
+// groupRegexp matches Buildkite group headers: "~~~" and "---" start a
+// collapsed group, "+++" starts an expanded group.
+var groupRegexp = regexp.MustCompile(`^(~~~|---|\+\+\+) (.*)$`)
+
+// JSONLine is one line of job output, as written by JSONLines.
+type JSONLine struct {
+	// Time is when the line was written.
+	Time time.Time `json:"ts"`
+
+	// SinceStart and SincePrevious are how long after the JSONLines was
+	// created (when the job executor started), and after the previous line,
+	// the line was written, in milliseconds. They
+	// are measured with the monotonic clock, so changes to the system clock
+	// don't affect them.
+	SinceStart    int64 `json:"since_start_ms"`
+	SincePrevious int64 `json:"since_previous_ms"`
+
+	// Type is "output" for ordinary output, "group" for a group header, or
+	// "expand" for a "^^^ +++" line that expands the previous group.
+	Type string `json:"type"`
+
+	// Stream is "stdout" for output from commands and hooks, or "stderr" for
+	// messages from the job executor itself.
+	Stream string `json:"stream"`
+
+	// Phase and Hook are the job phase and hook that were running, if any.
+	Phase string `json:"phase,omitempty"`
+	Hook  string `json:"hook,omitempty"`
+
+	// Group is the name of the group the line belongs to. For group headers,
+	// it is the name of the new group.
+	Group string `json:"group,omitempty"`
+
+	// Expanded reports whether a group header starts an expanded group.
+	Expanded bool `json:"expanded,omitempty"`
+
+	// Line is the text of the line, without the trailing newline.
+	Line string `json:"line"`
+}
+
+// JSONLines encodes job output as JSON lines. Each stream returned by Stream
+// splits its output into lines, and each line is written to the underlying
+// writer as a JSONLine, annotated with the current phase, hook and group.
+type JSONLines struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	now   func() time.Time
+	start time.Time
+	prev  time.Time
+	phase string
+	hook  string
+	group string
+
+	streams []*jsonLinesStream
+}
+
+// NewJSONLines returns a JSONLines that writes to w.
+func NewJSONLines(w io.Writer) *JSONLines {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	now := time.Now()
+	return &JSONLines{enc: enc, now: time.Now, start: now, prev: now}
+}
+
+// SetPhase sets the phase recorded for subsequent lines.
+func (j *JSONLines) SetPhase(phase string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.phase = phase
+}
+
+// SetHook sets the hook recorded for subsequent lines. An empty hook means
+// that no hook is running.
+func (j *JSONLines) SetHook(hook string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.hook = hook
+}
+
+// Stream returns a writer for the named stream.
+func (j *JSONLines) Stream(name string) io.Writer {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := &jsonLinesStream{parent: j, name: name}
+	j.streams = append(j.streams, s)
+	return s
+}
+
+// Flush writes out any incomplete lines remaining in each stream.
+func (j *JSONLines) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range j.streams {
+		if s.buf.Len() == 0 {
+			continue
+		}
+		line := s.buf.String()
+		s.buf.Reset()
+		if err := j.writeLine(s.name, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLine encodes a single line. j.mu must be held.
+func (j *JSONLines) writeLine(stream, line string) error {
+	now := j.now()
+	l := JSONLine{
+		Time:          now.UTC(),
+		SinceStart:    now.Sub(j.start).Milliseconds(),
+		SincePrevious: now.Sub(j.prev).Milliseconds(),
+		Type:          "output",
+		Stream:        stream,
+		Phase:         j.phase,
+		Hook:          j.hook,
+		Line:          line,
+	}
+	j.prev = now
+
+	if m := groupRegexp.FindStringSubmatch(line); m != nil {
+		j.group = m[2]
+		l.Type = "group"
+		l.Expanded = m[1] == "+++"
+	} else if line == "^^^ +++" {
+		l.Type = "expand"
+	}
+	l.Group = j.group
+
+	return j.enc.Encode(l)
+}
+
+// jsonLinesStream is a single stream of a JSONLines.
+type jsonLinesStream struct {
+	parent *JSONLines
+	name   string
+	buf    bytes.Buffer
+}
+
+func (s *jsonLinesStream) Write(p []byte) (int, error) {
+	j := s.parent
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s.buf.Write(p)
+	for {
+		b := s.buf.Bytes()
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if len(b) < jsonLinesMaxLine {
+				return len(p), nil
+			}
+			i = len(b)
+		}
+
+		line := string(bytes.TrimSuffix(b[:i], []byte("\r")))
+		s.buf.Next(min(i+1, len(b)))
+		if err := j.writeLine(s.name, line); err != nil {
+			return len(p), err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package shell_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/shell"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONLines(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	buf := &bytes.Buffer{}
+	j := shell.NewJSONLines(buf)
+	j.SetNow(func() time.Time { return ts })
+
+	stdout, stderr := j.Stream("stdout"), j.Stream("stderr")
+	logger := shell.NewWriterLogger(stderr, false, nil)
+
+	j.SetPhase("checkout")
+	logger.Headerf("Preparing working directory")
+	j.SetHook("global pre-checkout")
+	fmt.Fprint(stdout, "hello ")
+	fmt.Fprint(stdout, "world\r\n+++ Tests\n")
+	j.SetHook("")
+	logger.Errorf("oh no")
+	fmt.Fprint(stdout, "no newline")
+
+	if err := j.Flush(); err != nil {
+		t.Fatalf("j.Flush() error = %v", err)
+	}
+
+	var got []shell.JSONLine
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var l shell.JSONLine
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("dec.Decode() error = %v", err)
+		}
+		got = append(got, l)
+	}
+
+	want := []shell.JSONLine{
+		{Time: ts, Type: "group", Stream: "stderr", Phase: "checkout", Group: "Preparing working directory", Line: "~~~ Preparing working directory"},
+		{Time: ts, Type: "output", Stream: "stdout", Phase: "checkout", Hook: "global pre-checkout", Group: "Preparing working directory", Line: "hello world"},
+		{Time: ts, Type: "group", Stream: "stdout", Phase: "checkout", Hook: "global pre-checkout", Group: "Tests", Expanded: true, Line: "+++ Tests"},
+		{Time: ts, Type: "output", Stream: "stderr", Phase: "checkout", Group: "Tests", Line: "🚨 Error: oh no"},
+		{Time: ts, Type: "expand", Stream: "stderr", Phase: "checkout", Group: "Tests", Line: "^^^ +++"},
+		{Time: ts, Type: "output", Stream: "stdout", Phase: "checkout", Group: "Tests", Line: "no newline"},
+	}
+
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("JSONLines output diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestJSONLinesDeltas(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	buf := &bytes.Buffer{}
+	j := shell.NewJSONLines(buf)
+	j.SetNow(func() time.Time { return ts })
+	stdout := j.Stream("stdout")
+
+	for _, d := range []time.Duration{1500 * time.Millisecond, 0, 250 * time.Millisecond} {
+		ts = ts.Add(d)
+		fmt.Fprintln(stdout, "line")
+	}
+
+	type deltas struct{ SinceStart, SincePrevious int64 }
+	var got []deltas
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var l shell.JSONLine
+		if err := dec.Decode(&l); err != nil {
+			t.Fatalf("dec.Decode() error = %v", err)
+		}
+		got = append(got, deltas{l.SinceStart, l.SincePrevious})
+	}
+
+	want := []deltas{{1500, 1500}, {1500, 0}, {1750, 250}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("JSONLines deltas diff (-got +want):\n%s", diff)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"syscall"
@@ -64,6 +65,9 @@ type Shell struct {
 	// Whether the shell is a PTY.
 	pty bool
 
+	// The size of the PTY, as rows<<16 | cols, or 0 for the default size.
+	ptySize atomic.Uint32
+
 	// Amount of time to wait between sending the InterruptSignal and SIGKILL
 	signalGracePeriod time.Duration
 
@@ -96,6 +100,11 @@ func WithPTY(pty bool) NewShellOpt               { return func(s *Shell) { s.pty
 func WithStdout(w io.Writer) NewShellOpt         { return func(s *Shell) { s.stdout = w } }
 func WithWD(wd string) NewShellOpt               { return func(s *Shell) { s.wd = wd } }
 
+// WithPTYSize sets the size of the PTY that commands are run in.
+func WithPTYSize(rows, cols uint16) NewShellOpt {
+	return func(s *Shell) { s.ptySize.Store(uint32(rows)<<16 | uint32(cols)) }
+}
+
 func WithInterruptSignal(sig process.Signal) NewShellOpt {
 	return func(s *Shell) { s.interruptSignal = sig }
 }
@@ -215,6 +224,19 @@ func (s *Shell) Interrupt() { s.proc.Load().Interrupt() }
 // Terminate terminates the running process, if there is one.
 func (s *Shell) Terminate() { s.proc.Load().Terminate() }
 
+// ResizePTY changes the size of the PTY of the running process, if there is
+// one, and of the processes run after it.
+func (s *Shell) ResizePTY(rows, cols uint16) error {
+	if !s.pty {
+		return errors.New("the shell doesn't run commands in a PTY")
+	}
+	s.ptySize.Store(uint32(rows)<<16 | uint32(cols))
+	if err := s.proc.Load().Resize(rows, cols); err != nil && !errors.Is(err, process.ErrNoPTY) {
+		return err
+	}
+	return nil
+}
+
 // Returns the WaitStatus of the shell's process.
 //
 // The shell must have started at least one process.
@@ -406,6 +428,17 @@ func (c Command) Run(ctx context.Context, opts ...RunCommandOpt) error {
 		cmdCfg.Env = environ.ToSlice()
 	}
 
+	// Remove any env vars the filter doesn't keep. The shell's environment
+	// started as the current process's, so it replaces it, rather than the
+	// removed vars coming back from it.
+	if cfg.envFilter != nil {
+		cmdCfg.Env = slices.DeleteFunc(cmdCfg.Env, func(kv string) bool {
+			name, _, ok := env.Split(kv)
+			return ok && !cfg.envFilter(name)
+		})
+		cmdCfg.ReplaceEnv = true
+	}
+
 	// By default, PTY and stdout are whatever the shell is configured with.
 	pty := c.shell.pty
 	stdout := c.shell.stdout
@@ -425,6 +458,14 @@ func (c Command) Run(ctx context.Context, opts ...RunCommandOpt) error {
 		stderr = io.Discard
 	}
 
+	// If the output is being copied, also write it to the copies.
+	if cfg.copyStdout != nil {
+		stdout = io.MultiWriter(stdout, cfg.copyStdout)
+	}
+	if cfg.copyStderr != nil {
+		stderr = io.MultiWriter(stderr, cfg.copyStderr)
+	}
+
 	// If we're performing a string search, wrap the current stdout and stderr
 	// in olfactors, and report which ones were detected through the map.
 	if cfg.smells != nil {
@@ -466,7 +507,10 @@ type runConfig struct {
 	showPrompt    bool
 	showStderr    bool
 	extraEnv      *env.Environment
+	envFilter     func(name string) bool
 	smells        map[string]bool
+	copyStdout    io.Writer
+	copyStderr    io.Writer
 }
 
 // RunCommandOpt is the type of functional options that can be passed to
@@ -489,6 +533,20 @@ func ShowPrompt(show bool) RunCommandOpt { return func(c *runConfig) { c.showPro
 // WithExtraEnv can be used to set additional env vars for this run.
 func WithExtraEnv(e *env.Environment) RunCommandOpt { return func(c *runConfig) { c.extraEnv = e } }
 
+// WithEnvFilter removes the env vars that keep returns false for from the
+// environment of this run, after any extra env vars are merged in. The
+// shell's own environment is left alone.
+func WithEnvFilter(keep func(name string) bool) RunCommandOpt {
+	return func(c *runConfig) { c.envFilter = keep }
+}
+
+// CopyOutput causes the stdout and stderr streams of the process to also be
+// written to the given writers (either may be nil). When the command is run in
+// a PTY, stderr is combined with stdout, so it is all copied to stdout.
+func CopyOutput(stdout, stderr io.Writer) RunCommandOpt {
+	return func(c *runConfig) { c.copyStdout, c.copyStderr = stdout, stderr }
+}
+
 // WithStringSearch causes both the stdout and stderr streams of the process to
 // be searched for strings. (This does not require capturing either stream in
 // full.) After the process is finished, the map can be inspected to see which
@@ -555,6 +613,9 @@ func (s *Shell) executeCommand(ctx context.Context, cmdCfg process.Config, stdou
 	}
 
 	cmdCfg.PTY = pty
+	if size := s.ptySize.Load(); size != 0 {
+		cmdCfg.PTYRows, cmdCfg.PTYCols = uint16(size>>16), uint16(size)
+	}
 	cmdCfg.Stdout = stdout
 	cmdCfg.Stderr = stderr
 
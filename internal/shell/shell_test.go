@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/buildkite/agent/v3/env"
 	"github.com/buildkite/agent/v3/internal/replacer"
 	"github.com/buildkite/agent/v3/internal/shell"
 	"github.com/buildkite/bintest/v3"
@@ -510,6 +511,34 @@ func TestRunWithoutPrompt(t *testing.T) {
 	}
 }
 
+func TestRunWithCopyOutput(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh to write to stderr")
+	}
+
+	out := &bytes.Buffer{}
+	sh, err := shell.New(shell.WithStdout(out), shell.WithPTY(false))
+	if err != nil {
+		t.Fatalf("shell.New() error = %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := sh.Command("sh", "-c", "echo llamas; echo alpacas >&2")
+	if err := cmd.Run(ctx, shell.ShowPrompt(false), shell.CopyOutput(&stdout, &stderr)); err != nil {
+		t.Fatalf("sh.Command(sh, -c, ...).Run(ctx, ShowPrompt(false), CopyOutput(...)) = %v", err)
+	}
+
+	if got, want := stdout.String(), "llamas\n"; got != want {
+		t.Errorf("copied stdout = %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "alpacas\n"; got != want {
+		t.Errorf("copied stderr = %q, want %q", got, want)
+	}
+}
+
 func TestRound(t *testing.T) {
 	t.Parallel()
 
@@ -547,3 +576,98 @@ func TestRound(t *testing.T) {
 		})
 	}
 }
+
+func TestRunWithEnvFilter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh to print the environment")
+	}
+
+	environ := env.FromSlice(os.Environ())
+	environ.Set("LLAMAS", "kept")
+	environ.Set("SECRET_ALPACAS", "removed")
+
+	sh, err := shell.New(shell.WithEnv(environ), shell.WithPTY(false))
+	if err != nil {
+		t.Fatalf("shell.New() error = %v", err)
+	}
+
+	keep := func(name string) bool { return !strings.HasPrefix(name, "SECRET_") }
+	cmd := sh.Command("sh", "-c", `echo "${LLAMAS}:${SECRET_ALPACAS}:${SECRET_EXTRA}"`)
+	extra := env.FromMap(map[string]string{"SECRET_EXTRA": "removed too"})
+
+	var got string
+	if err := cmd.Run(ctx, shell.ShowPrompt(false), shell.WithExtraEnv(extra), shell.WithEnvFilter(keep), shell.CaptureStdout(&got)); err != nil {
+		t.Fatalf("sh.Command(sh, -c, ...).Run(ctx, ..., WithEnvFilter(keep), ...) = %v", err)
+	}
+	if want := "kept::"; got != want {
+		t.Errorf("filtered command output = %q, want %q", got, want)
+	}
+
+	// The shell's own environment is unchanged.
+	if v, _ := sh.Env.Get("SECRET_ALPACAS"); v != "removed" {
+		t.Errorf("sh.Env.Get(SECRET_ALPACAS) = %q, want %q", v, "removed")
+	}
+}
+
+func TestRunWithEnvFilterRemovesProcessEnv(t *testing.T) {
+	ctx := context.Background()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh to print the environment")
+	}
+
+	// The shell's environment starts as the process's, which mustn't bring
+	// back the vars that are removed.
+	t.Setenv("SECRET_FROM_PROCESS", "removed")
+	sh, err := shell.New(shell.WithPTY(false))
+	if err != nil {
+		t.Fatalf("shell.New() error = %v", err)
+	}
+
+	keep := func(name string) bool { return !strings.HasPrefix(name, "SECRET_") }
+	var got string
+	if err := sh.Command("sh", "-c", `echo "${SECRET_FROM_PROCESS}"`).Run(ctx, shell.ShowPrompt(false), shell.WithEnvFilter(keep), shell.CaptureStdout(&got)); err != nil {
+		t.Fatalf("sh.Command(sh, -c, ...).Run(ctx, ..., WithEnvFilter(keep), ...) = %v", err)
+	}
+	if got != "" {
+		t.Errorf("filtered command output = %q, want %q", got, "")
+	}
+}
+
+func TestRunWithPTYSize(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("PTY not supported on windows")
+	}
+
+	out := &bytes.Buffer{}
+	sh := newShellForTest(t, shell.WithPTY(true), shell.WithPTYSize(40, 120), shell.WithStdout(out))
+
+	if err := sh.Command("stty", "size").Run(ctx, shell.ShowPrompt(false)); err != nil {
+		t.Fatalf(`sh.Command("stty", "size").Run(ctx) = %v`, err)
+	}
+	if got, want := strings.TrimSpace(out.String()), "40 120"; got != want {
+		t.Errorf("stty size output = %q, want %q", got, want)
+	}
+
+	// Resizing with no process running changes the size of later processes
+	if err := sh.ResizePTY(50, 200); err != nil {
+		t.Fatalf("sh.ResizePTY(50, 200) = %v", err)
+	}
+	out.Reset()
+	if err := sh.Command("stty", "size").Run(ctx, shell.ShowPrompt(false)); err != nil {
+		t.Fatalf(`sh.Command("stty", "size").Run(ctx) = %v`, err)
+	}
+	if got, want := strings.TrimSpace(out.String()), "50 200"; got != want {
+		t.Errorf("stty size output after resizing = %q, want %q", got, want)
+	}
+
+	if err := newShellForTest(t, shell.WithPTY(false)).ResizePTY(50, 200); err == nil {
+		t.Errorf("ResizePTY(50, 200) on a shell without a PTY error = nil, want an error")
+	}
+}
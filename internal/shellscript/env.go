@@ -0,0 +1,104 @@
+package shellscript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Dialect is a shell language that changes to environment variables can be
+// written in.
+type Dialect string
+
+const (
+	Bash       Dialect = "bash"
+	Fish       Dialect = "fish"
+	PowerShell Dialect = "powershell"
+)
+
+// Dialects lists the supported dialects.
+var Dialects = []Dialect{Bash, Fish, PowerShell}
+
+// Bash and fish can only name variables with letters, digits and underscores.
+var posixNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseDialect returns the dialect named by s.
+func ParseDialect(s string) (Dialect, error) {
+	for _, d := range Dialects {
+		if string(d) == s {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported shell %q, must be one of %q", s, Dialects)
+}
+
+// SetEnv returns a statement that sets and exports the environment variable
+// name to value. It returns an error if the dialect can't name the variable.
+func SetEnv(d Dialect, name, value string) (string, error) {
+	switch d {
+	case Bash:
+		if !posixNameRE.MatchString(name) {
+			return "", fmt.Errorf("%q is not a valid variable name in %s", name, d)
+		}
+		return "export " + name + "=" + quoteBash(value), nil
+
+	case Fish:
+		if !posixNameRE.MatchString(name) {
+			return "", fmt.Errorf("%q is not a valid variable name in %s", name, d)
+		}
+		return "set -gx " + name + " " + quoteFish(value), nil
+
+	case PowerShell:
+		return "Set-Item -LiteralPath " + quotePowerShell("Env:"+name) + " -Value " + quotePowerShell(value), nil
+
+	default:
+		return "", fmt.Errorf("unsupported shell %q", d)
+	}
+}
+
+// UnsetEnv returns a statement that removes the environment variable name. It
+// returns an error if the dialect can't name the variable.
+func UnsetEnv(d Dialect, name string) (string, error) {
+	switch d {
+	case Bash:
+		if !posixNameRE.MatchString(name) {
+			return "", fmt.Errorf("%q is not a valid variable name in %s", name, d)
+		}
+		return "unset " + name, nil
+
+	case Fish:
+		if !posixNameRE.MatchString(name) {
+			return "", fmt.Errorf("%q is not a valid variable name in %s", name, d)
+		}
+		return "set -e " + name, nil
+
+	case PowerShell:
+		return "Remove-Item -LiteralPath " + quotePowerShell("Env:"+name) + " -ErrorAction SilentlyContinue", nil
+
+	default:
+		return "", fmt.Errorf("unsupported shell %q", d)
+	}
+}
+
+// quoteBash single-quotes s. Nothing is special inside single quotes, so
+// single quotes are ended, escaped, and started again.
+func quoteBash(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteFish single-quotes s. Fish allows \' and \\ inside single quotes.
+func quoteFish(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// quotePowerShell single-quotes s. PowerShell escapes a single quote by
+// doubling it, and also treats the typographic single quotes as quotes.
+func quotePowerShell(s string) string {
+	return "'" + strings.NewReplacer(
+		"'", "''",
+		"‘", "‘‘",
+		"’", "’’",
+		"‚", "‚‚",
+		"‛", "‛‛",
+	).Replace(s) + "'"
+}
@@ -0,0 +1,78 @@
+package shellscript
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestSetEnv(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		dialect     Dialect
+		name, value string
+		want        string
+	}{
+		{Bash, "LLAMA", "Kuzco", `export LLAMA='Kuzco'`},
+		{Bash, "QUOTES", `it's "$HOME"`, `export QUOTES='it'\''s "$HOME"'`},
+		{Fish, "QUOTES", `it's a \ back`, `set -gx QUOTES 'it\'s a \\ back'`},
+		{PowerShell, "QUOTES", "it's ’curly’", `Set-Item -LiteralPath 'Env:QUOTES' -Value 'it''s ’’curly’’'`},
+		{PowerShell, "ProgramFiles(x86)", `C:\Program Files (x86)`, `Set-Item -LiteralPath 'Env:ProgramFiles(x86)' -Value 'C:\Program Files (x86)'`},
+	}
+	for _, test := range tests {
+		got, err := SetEnv(test.dialect, test.name, test.value)
+		if err != nil {
+			t.Errorf("SetEnv(%q, %q, %q) error = %v", test.dialect, test.name, test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("SetEnv(%q, %q, %q) = %q, want %q", test.dialect, test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestSetEnvInvalidName(t *testing.T) {
+	t.Parallel()
+
+	for _, d := range []Dialect{Bash, Fish} {
+		if _, err := SetEnv(d, "ProgramFiles(x86)", "x"); err == nil {
+			t.Errorf("SetEnv(%q, %q, %q) error = nil, want an error", d, "ProgramFiles(x86)", "x")
+		}
+		if _, err := UnsetEnv(d, "1LLAMA"); err == nil {
+			t.Errorf("UnsetEnv(%q, %q) error = nil, want an error", d, "1LLAMA")
+		}
+	}
+}
+
+func TestSetEnvRoundTripBash(t *testing.T) {
+	t.Parallel()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+
+	value := "it's \"$HOME\" `date` \\ \n!x"
+	stmt, err := SetEnv(Bash, "LLAMA", value)
+	if err != nil {
+		t.Fatalf("SetEnv() error = %v", err)
+	}
+	out, err := exec.Command(bash, "-c", stmt+`; printf '%s' "$LLAMA"`).Output()
+	if err != nil {
+		t.Fatalf("running %q: %v", stmt, err)
+	}
+	if string(out) != value {
+		t.Errorf("after %q, LLAMA = %q, want %q", stmt, out, value)
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	t.Parallel()
+
+	if got, err := ParseDialect("fish"); err != nil || got != Fish {
+		t.Errorf("ParseDialect(%q) = %q, %v, want %q, nil", "fish", got, err, Fish)
+	}
+	if _, err := ParseDialect("tcsh"); err == nil {
+		t.Errorf("ParseDialect(%q) error = nil, want an error", "tcsh")
+	}
+}
@@ -0,0 +1,77 @@
+// Package testanalytics uploads test results to Buildkite Test Analytics.
+package testanalytics
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of test result files that Test Analytics accepts.
+const (
+	FormatJUnit = "junit"
+	FormatJSON  = "json"
+)
+
+// Formats are the supported test result formats.
+var Formats = []string{FormatJUnit, FormatJSON}
+
+// FormatForPath guesses the format of a test result file from its extension.
+func FormatForPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		return FormatJUnit, nil
+	case ".json":
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("can't tell the format of %s from its extension, use --format", path)
+	}
+}
+
+// countJUnit checks that r contains JUnit XML, and returns how many test
+// cases it has.
+func countJUnit(r io.Reader) (int, error) {
+	dec := xml.NewDecoder(r)
+	count := 0
+	sawRoot := false
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("parsing JUnit XML: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !sawRoot {
+			if start.Name.Local != "testsuites" && start.Name.Local != "testsuite" {
+				return 0, fmt.Errorf("parsing JUnit XML: root element is <%s>, want <testsuites> or <testsuite>", start.Name.Local)
+			}
+			sawRoot = true
+		}
+		if start.Name.Local == "testcase" {
+			count++
+		}
+	}
+	if !sawRoot {
+		return 0, errors.New("parsing JUnit XML: no <testsuites> or <testsuite> element")
+	}
+	return count, nil
+}
+
+// parseJSON checks that data is a Test Analytics JSON array of test results,
+// and returns the results.
+func parseJSON(data []byte) ([]json.RawMessage, error) {
+	var results []json.RawMessage
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("parsing JSON test results: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,199 @@
+package testanalytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/agenthttp"
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/version"
+	"github.com/buildkite/roko"
+)
+
+// DefaultEndpoint is the Test Analytics API.
+const DefaultEndpoint = "https://analytics-api.buildkite.com/v1"
+
+// Test Analytics accepts at most this many JSON test results in one upload.
+const maxJSONResultsPerUpload = 5000
+
+// UploaderConfig configures an Uploader.
+type UploaderConfig struct {
+	// The Test Analytics API endpoint
+	Endpoint string
+
+	// The test suite's API token
+	Token string
+
+	// Describes the build that the tests ran in
+	RunEnv map[string]string
+
+	// Standard HTTP options
+	DebugHTTP bool
+	TraceHTTP bool
+}
+
+type Uploader struct {
+	// The upload config
+	conf UploaderConfig
+
+	// The logger instance to use
+	logger logger.Logger
+
+	// The HTTP client to upload with
+	client *http.Client
+}
+
+func NewUploader(l logger.Logger, c UploaderConfig) *Uploader {
+	if c.Endpoint == "" {
+		c.Endpoint = DefaultEndpoint
+	}
+	return &Uploader{
+		conf:   c,
+		logger: l,
+		client: agenthttp.NewClient(agenthttp.WithTimeout(5 * time.Minute)),
+	}
+}
+
+// RunEnv returns the run environment for the current Buildkite job, which
+// Test Analytics uses to link test results to the build.
+func RunEnv() map[string]string {
+	env := map[string]string{
+		"CI":        "buildkite",
+		"collector": "buildkite-agent",
+		"version":   version.Version(),
+	}
+	for field, name := range map[string]string{
+		"key":        "BUILDKITE_BUILD_ID",
+		"number":     "BUILDKITE_BUILD_NUMBER",
+		"job_id":     "BUILDKITE_JOB_ID",
+		"branch":     "BUILDKITE_BRANCH",
+		"commit_sha": "BUILDKITE_COMMIT",
+		"message":    "BUILDKITE_MESSAGE",
+		"url":        "BUILDKITE_BUILD_URL",
+	} {
+		if value := os.Getenv(name); value != "" {
+			env[field] = value
+		}
+	}
+	return env
+}
+
+// UploadFile checks that the test result file at path is in format, then
+// uploads it. Large JSON files are split across several uploads.
+func (u *Uploader) UploadFile(ctx context.Context, path, format string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	switch format {
+	case FormatJUnit:
+		count, err := countJUnit(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		u.logger.Info("Uploading %d test results from %s", count, path)
+		return u.upload(ctx, FormatJUnit, filepath.Base(path), data)
+
+	case FormatJSON:
+		results, err := parseJSON(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		u.logger.Info("Uploading %d test results from %s", len(results), path)
+		for start := 0; start < len(results); start += maxJSONResultsPerUpload {
+			batch := results[start:min(start+maxJSONResultsPerUpload, len(results))]
+			data, err := json.Marshal(batch)
+			if err != nil {
+				return fmt.Errorf("marshalling test results: %w", err)
+			}
+			if err := u.upload(ctx, FormatJSON, filepath.Base(path), data); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported test result format %q, must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// uploadResponse is the part of the Test Analytics response that we use.
+type uploadResponse struct {
+	RunURL string `json:"run_url"`
+}
+
+func (u *Uploader) upload(ctx context.Context, format, filename string, data []byte) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("format", format); err != nil {
+		return err
+	}
+	for key, value := range u.conf.RunEnv {
+		if err := mw.WriteField("run_env["+key+"]", value); err != nil {
+			return err
+		}
+	}
+	fw, err := mw.CreateFormFile("data", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	r := roko.NewRetrier(
+		roko.WithMaxAttempts(5),
+		roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
+	)
+	return r.DoWithContext(ctx, func(r *roko.Retrier) error {
+		req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(u.conf.Endpoint, "/")+"/uploads", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			r.Break()
+			return err
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", fmt.Sprintf("Token token=%q", u.conf.Token))
+		req.Header.Set("User-Agent", version.UserAgent())
+
+		resp, err := agenthttp.Do(u.logger, u.client, req,
+			agenthttp.WithDebugHTTP(u.conf.DebugHTTP),
+			agenthttp.WithTraceHTTP(u.conf.TraceHTTP),
+		)
+		if err != nil {
+			u.logger.Warn("%s (%s)", err, r)
+			return err
+		}
+		defer resp.Body.Close()
+
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if resp.StatusCode >= 300 {
+			err := fmt.Errorf("Test Analytics responded with %s: %s", resp.Status, bytes.TrimSpace(respBody))
+			// Only server errors are worth retrying.
+			if resp.StatusCode < 500 {
+				r.Break()
+				return err
+			}
+			u.logger.Warn("%s (%s)", err, r)
+			return err
+		}
+
+		var ur uploadResponse
+		if err := json.Unmarshal(respBody, &ur); err == nil && ur.RunURL != "" {
+			u.logger.Info("Uploaded test results: %s", ur.RunURL)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,218 @@
+package testanalytics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent/v3/logger"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeTestAnalytics records the uploads it receives.
+type fakeTestAnalytics struct {
+	mu      sync.Mutex
+	uploads []fakeUpload
+}
+
+type fakeUpload struct {
+	format string
+	runEnv map[string]string
+	data   string
+}
+
+func (f *fakeTestAnalytics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/uploads" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if got, want := r.Header.Get("Authorization"), `Token token="suite-token"`; got != want {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upload := fakeUpload{
+		format: r.FormValue("format"),
+		runEnv: make(map[string]string),
+	}
+	for key, values := range r.MultipartForm.Value {
+		if name, ok := strings.CutPrefix(key, "run_env["); ok {
+			upload.runEnv[strings.TrimSuffix(name, "]")] = values[0]
+		}
+	}
+	file, _, err := r.FormFile("data")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(file)
+	upload.data = string(data)
+
+	f.mu.Lock()
+	f.uploads = append(f.uploads, upload)
+	f.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+	io.WriteString(w, `{"run_url": "https://buildkite.com/test-runs/1"}`) //nolint:errcheck // test server
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o666); err != nil {
+		t.Fatalf("os.WriteFile(%q) error = %v", path, err)
+	}
+	return path
+}
+
+func TestUploadJUnit(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeTestAnalytics{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	junit := `<?xml version="1.0"?>
+<testsuites>
+  <testsuite name="llamas">
+    <testcase name="spits"/>
+    <testcase name="hums"><failure message="quietly"/></testcase>
+  </testsuite>
+</testsuites>`
+	path := writeFile(t, "junit.xml", junit)
+
+	runEnv := map[string]string{"CI": "buildkite", "key": "build-uuid"}
+	u := NewUploader(logger.Discard, UploaderConfig{
+		Endpoint: server.URL + "/v1",
+		Token:    "suite-token",
+		RunEnv:   runEnv,
+	})
+	if err := u.UploadFile(context.Background(), path, FormatJUnit); err != nil {
+		t.Fatalf("u.UploadFile(%q, junit) error = %v", path, err)
+	}
+
+	want := []fakeUpload{{format: "junit", runEnv: runEnv, data: junit}}
+	if diff := cmp.Diff(fake.uploads, want, cmp.AllowUnexported(fakeUpload{})); diff != "" {
+		t.Errorf("uploads diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestUploadJSONInBatches(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeTestAnalytics{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	results := make([]map[string]string, maxJSONResultsPerUpload+1)
+	for i := range results {
+		results[i] = map[string]string{"name": "test"}
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatalf("json.Marshal(results) error = %v", err)
+	}
+	path := writeFile(t, "results.json", string(data))
+
+	u := NewUploader(logger.Discard, UploaderConfig{
+		Endpoint: server.URL + "/v1",
+		Token:    "suite-token",
+	})
+	if err := u.UploadFile(context.Background(), path, FormatJSON); err != nil {
+		t.Fatalf("u.UploadFile(%q, json) error = %v", path, err)
+	}
+
+	if got, want := len(fake.uploads), 2; got != want {
+		t.Fatalf("len(uploads) = %d, want %d", got, want)
+	}
+	for i, want := range []int{maxJSONResultsPerUpload, 1} {
+		var batch []json.RawMessage
+		if err := json.Unmarshal([]byte(fake.uploads[i].data), &batch); err != nil {
+			t.Fatalf("json.Unmarshal(uploads[%d].data) error = %v", i, err)
+		}
+		if got := len(batch); got != want {
+			t.Errorf("len(uploads[%d] results) = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestUploadRejectsInvalidFiles(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeTestAnalytics{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u := NewUploader(logger.Discard, UploaderConfig{
+		Endpoint: server.URL + "/v1",
+		Token:    "suite-token",
+	})
+
+	for _, tc := range []struct {
+		name, content, format string
+	}{
+		{"not-junit.xml", "<html></html>", FormatJUnit},
+		{"broken.xml", "<testsuites><testsuite>", FormatJUnit},
+		{"not-an-array.json", `{"name": "test"}`, FormatJSON},
+	} {
+		path := writeFile(t, tc.name, tc.content)
+		if err := u.UploadFile(context.Background(), path, tc.format); err == nil {
+			t.Errorf("u.UploadFile(%q, %q) error = nil, want an error", tc.name, tc.format)
+		}
+	}
+
+	if len(fake.uploads) != 0 {
+		t.Errorf("len(uploads) = %d, want 0", len(fake.uploads))
+	}
+}
+
+func TestUploadDoesNotRetryClientErrors(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeTestAnalytics{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u := NewUploader(logger.Discard, UploaderConfig{
+		Endpoint: server.URL + "/v1",
+		Token:    "wrong-token",
+	})
+	path := writeFile(t, "junit.xml", "<testsuite/>")
+	if err := u.UploadFile(context.Background(), path, FormatJUnit); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("u.UploadFile(%q, junit) error = %v, want a 401 error", path, err)
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	t.Parallel()
+
+	for path, want := range map[string]string{
+		"junit.xml":          FormatJUnit,
+		"reports/TEST-A.XML": FormatJUnit,
+		"results.json":       FormatJSON,
+	} {
+		got, err := FormatForPath(path)
+		if err != nil {
+			t.Errorf("FormatForPath(%q) error = %v", path, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("FormatForPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	if _, err := FormatForPath("results.txt"); err == nil {
+		t.Errorf("FormatForPath(%q) error = nil, want an error", "results.txt")
+	}
+}
@@ -3,8 +3,11 @@ package jobapi
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/buildkite/agent/v3/internal/socket"
 )
@@ -12,6 +15,10 @@ import (
 const (
 	envURL        = "http://job/api/current-job/v0/env"
 	redactionsURL = "http://job/api/current-job/v0/redactions"
+	jobURL        = "http://job/api/current-job/v0/job"
+	ptySizeURL    = "http://job/api/current-job/v0/pty-size"
+	oidcTokensURL = "http://job/api/current-job/v0/oidc-tokens"
+	gitCredsURL   = "http://job/api/current-job/v0/git-credentials"
 )
 
 var (
@@ -102,3 +109,68 @@ func (c *Client) RedactionCreate(ctx context.Context, text string) (string, erro
 	}
 	return resp.Redacted, nil
 }
+
+// JobGet gets information about the job from the job executor.
+func (c *Client) JobGet(ctx context.Context) (*JobGetResponse, error) {
+	var resp JobGetResponse
+	if err := c.client.Do(ctx, http.MethodGet, jobURL, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PTYResize changes the size of the PTY that the job's commands run in.
+func (c *Client) PTYResize(ctx context.Context, rows, cols uint16) error {
+	req := PTYSize{Rows: rows, Cols: cols}
+	return c.client.Do(ctx, http.MethodPut, ptySizeURL, &req, nil)
+}
+
+// OIDCTokenGet gets a cached OIDC token that is valid for at least
+// minValidity. If there isn't one, it returns nil and no error.
+func (c *Client) OIDCTokenGet(ctx context.Context, key string, minValidity time.Duration) (*OIDCToken, error) {
+	u := fmt.Sprintf("%s/%s?min_validity=%d", oidcTokensURL, url.PathEscape(key), int(minValidity.Seconds()))
+	var resp OIDCToken
+	if err := c.client.Do(ctx, http.MethodGet, u, nil, &resp); err != nil {
+		var apiErr socket.APIErr
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OIDCTokenPut caches an OIDC token in the job executor until it expires.
+func (c *Client) OIDCTokenPut(ctx context.Context, key string, token *OIDCToken) error {
+	u := fmt.Sprintf("%s/%s", oidcTokensURL, url.PathEscape(key))
+	return c.client.Do(ctx, http.MethodPut, u, token, nil)
+}
+
+// GitCredentialGet gets a cached git credential that is valid for at least
+// minValidity. If there isn't one, it returns nil and no error.
+func (c *Client) GitCredentialGet(ctx context.Context, key string, minValidity time.Duration) (*GitCredential, error) {
+	u := fmt.Sprintf("%s/%s?min_validity=%d", gitCredsURL, url.PathEscape(key), int(minValidity.Seconds()))
+	var resp GitCredential
+	if err := c.client.Do(ctx, http.MethodGet, u, nil, &resp); err != nil {
+		var apiErr socket.APIErr
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GitCredentialPut caches a git credential in the job executor until it
+// expires.
+func (c *Client) GitCredentialPut(ctx context.Context, key string, cred *GitCredential) error {
+	u := fmt.Sprintf("%s/%s", gitCredsURL, url.PathEscape(key))
+	return c.client.Do(ctx, http.MethodPut, u, cred, nil)
+}
+
+// GitCredentialDelete removes a cached git credential from the job executor,
+// if there is one.
+func (c *Client) GitCredentialDelete(ctx context.Context, key string) error {
+	u := fmt.Sprintf("%s/%s", gitCredsURL, url.PathEscape(key))
+	return c.client.Do(ctx, http.MethodDelete, u, nil, nil)
+}
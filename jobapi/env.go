@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/buildkite/agent/v3/agent"
+	"github.com/buildkite/agent/v3/internal/redact"
 	"github.com/buildkite/agent/v3/internal/socket"
 	"golang.org/x/exp/maps"
 )
@@ -35,7 +36,7 @@ func (s *Server) patchEnv(w http.ResponseWriter, r *http.Request) {
 
 	added := make([]string, 0, len(req.Env))
 	updated := make([]string, 0, len(req.Env))
-	protected := checkProtected(maps.Keys(req.Env))
+	protected := s.checkProtected(maps.Keys(req.Env))
 
 	if len(protected) > 0 {
 		err := socket.WriteError(
@@ -105,7 +106,7 @@ func (s *Server) deleteEnv(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	protected := checkProtected(req.Keys)
+	protected := s.checkProtected(req.Keys)
 	if len(protected) > 0 {
 		err := socket.WriteError(
 			w,
@@ -137,11 +138,15 @@ func (s *Server) deleteEnv(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func checkProtected(candidates []string) []string {
+func (s *Server) checkProtected(candidates []string) []string {
 	protected := make([]string, 0, len(candidates))
 	for _, c := range candidates {
 		if _, ok := agent.ProtectedEnv[c]; ok {
 			protected = append(protected, c)
+			continue
+		}
+		if matched, _ := redact.MatchAny(s.protectedEnv, c); matched {
+			protected = append(protected, c)
 		}
 	}
 	return protected
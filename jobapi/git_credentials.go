@@ -0,0 +1,88 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/go-chi/chi/v5"
+)
+
+// getGitCredential returns a cached git credential, if there is one that is
+// valid for at least the min_validity query parameter (in seconds).
+func (s *Server) getGitCredential(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	minValidity, err := parseMinValidity(r)
+	if err != nil {
+		if err := socket.WriteError(w, err, http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	cred, ok := s.gitCredentials[key]
+	if ok && !time.Now().Before(cred.ExpiresAt) {
+		delete(s.gitCredentials, key)
+	}
+	s.mtx.Unlock()
+
+	if !ok || time.Until(cred.ExpiresAt) < minValidity {
+		if err := socket.WriteError(w, "no cached git credential", http.StatusNotFound); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(cred); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
+
+// putGitCredential caches a git credential until it expires.
+func (s *Server) putGitCredential(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	cred := &GitCredential{}
+	if err := json.NewDecoder(r.Body).Decode(cred); err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if cred.Password == "" || cred.ExpiresAt.IsZero() {
+		if err := socket.WriteError(w, errors.New("password and expires_at are required"), http.StatusUnprocessableEntity); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	if s.gitCredentials == nil {
+		s.gitCredentials = make(map[string]GitCredential)
+	}
+	s.gitCredentials[key] = *cred
+	s.mtx.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(cred); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
+
+// deleteGitCredential forgets a cached git credential, such as one that git
+// has reported doesn't work.
+func (s *Server) deleteGitCredential(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	s.mtx.Lock()
+	delete(s.gitCredentials, key)
+	s.mtx.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// StartPhase records that the job has moved on to a new phase, finishing the
+// previous one. Phases are reported by the GET /job endpoint.
+func (s *Server) StartPhase(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now().UTC()
+	if n := len(s.phases); n > 0 && s.phases[n-1].FinishedAt == nil {
+		s.phases[n-1].FinishedAt = &now
+	}
+	s.phases = append(s.phases, PhaseTiming{Name: name, StartedAt: now})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, _ *http.Request) {
+	s.mtx.RLock()
+	jobID, _ := s.environ.Get("BUILDKITE_JOB_ID")
+	stepKey, _ := s.environ.Get("BUILDKITE_STEP_KEY")
+	commit, _ := s.environ.Get("BUILDKITE_COMMIT")
+	branch, _ := s.environ.Get("BUILDKITE_BRANCH")
+	retryCount, _ := s.environ.Get("BUILDKITE_RETRY_COUNT")
+	phases := slices.Clone(s.phases)
+	s.mtx.RUnlock()
+
+	resp := JobGetResponse{
+		ID:      jobID,
+		StepKey: stepKey,
+		Commit:  commit,
+		Branch:  branch,
+		Phases:  phases,
+	}
+	resp.RetryCount, _ = strconv.Atoi(retryCount)
+	if n := len(phases); n > 0 && phases[n-1].FinishedAt == nil {
+		resp.Phase = phases[n-1].Name
+	}
+	if resp.Phases == nil {
+		resp.Phases = []PhaseTiming{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+	"github.com/go-chi/chi/v5"
+)
+
+// getOIDCToken returns a cached OIDC token, if there is one that is valid for
+// at least the min_validity query parameter (in seconds).
+func (s *Server) getOIDCToken(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	minValidity, err := parseMinValidity(r)
+	if err != nil {
+		if err := socket.WriteError(w, err, http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	token, ok := s.oidcTokens[key]
+	if ok && !time.Now().Before(token.ExpiresAt) {
+		delete(s.oidcTokens, key)
+	}
+	s.mtx.Unlock()
+
+	if !ok || time.Until(token.ExpiresAt) < minValidity {
+		if err := socket.WriteError(w, "no cached OIDC token", http.StatusNotFound); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(token); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
+
+// putOIDCToken caches an OIDC token until it expires.
+func (s *Server) putOIDCToken(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	token := &OIDCToken{}
+	if err := json.NewDecoder(r.Body).Decode(token); err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if token.Token == "" || token.ExpiresAt.IsZero() {
+		if err := socket.WriteError(w, errors.New("token and expires_at are required"), http.StatusUnprocessableEntity); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	s.mtx.Lock()
+	if s.oidcTokens == nil {
+		s.oidcTokens = make(map[string]OIDCToken)
+	}
+	s.oidcTokens[key] = *token
+	s.mtx.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(token); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
+
+// parseMinValidity parses the min_validity query parameter (in seconds), which
+// defaults to zero.
+func parseMinValidity(r *http.Request) (time.Duration, error) {
+	mv := r.URL.Query().Get("min_validity")
+	if mv == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(mv)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid min_validity %q", mv)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
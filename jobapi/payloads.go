@@ -2,6 +2,7 @@ package jobapi
 
 import (
 	"sort"
+	"time"
 
 	"github.com/buildkite/agent/v3/internal/socket"
 )
@@ -49,6 +50,24 @@ func (e EnvDeleteResponse) Normalize() {
 	sort.Strings(e.Deleted)
 }
 
+// JobGetResponse is the response body for the GET /job endpoint
+type JobGetResponse struct {
+	ID         string        `json:"id"`
+	StepKey    string        `json:"step_key"`
+	Commit     string        `json:"commit"`
+	Branch     string        `json:"branch"`
+	RetryCount int           `json:"retry_count"`
+	Phase      string        `json:"phase"` // The phase currently running, if any
+	Phases     []PhaseTiming `json:"phases"`
+}
+
+// PhaseTiming is the timing of a phase of the job, as part of JobGetResponse
+type PhaseTiming struct {
+	Name       string     `json:"name"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"` // nil if the phase is still running
+}
+
 // RedactionCreateRequest is the request body for the POST /redactions endpoint
 type RedactionCreateRequest struct {
 	Redact string `json:"redact"`
@@ -58,3 +77,25 @@ type RedactionCreateRequest struct {
 type RedactionCreateResponse struct {
 	Redacted string `json:"redacted"`
 }
+
+// PTYSize is the request and response body for the PUT /pty-size endpoint
+type PTYSize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// OIDCToken is the request body for the PUT /oidc-tokens/{key} endpoint, and
+// the response body for the GET and PUT /oidc-tokens/{key} endpoints
+type OIDCToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GitCredential is the request body for the PUT /git-credentials/{key}
+// endpoint, and the response body for the GET and PUT /git-credentials/{key}
+// endpoints
+type GitCredential struct {
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,47 @@
+package jobapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/buildkite/agent/v3/internal/socket"
+)
+
+// putPTYSize resizes the PTY that the job's commands run in. The running
+// command, if any, gets a SIGWINCH, and later commands start at the new size.
+func (s *Server) putPTYSize(w http.ResponseWriter, r *http.Request) {
+	size := &PTYSize{}
+	if err := json.NewDecoder(r.Body).Decode(size); err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("failed to decode request body: %w", err), http.StatusBadRequest); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+	if size.Rows == 0 || size.Cols == 0 {
+		if err := socket.WriteError(w, errors.New("rows and cols are required"), http.StatusUnprocessableEntity); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	if s.resizePTY == nil {
+		if err := socket.WriteError(w, "the job's commands aren't run in a PTY", http.StatusNotFound); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	if err := s.resizePTY(size.Rows, size.Cols); err != nil {
+		if err := socket.WriteError(w, fmt.Errorf("resizing PTY: %w", err), http.StatusInternalServerError); err != nil {
+			s.Logger.Errorf("Job API: couldn't write error: %v", err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(size); err != nil {
+		s.Logger.Errorf("Job API: couldn't encode or write response: %v", err)
+	}
+}
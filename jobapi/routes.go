@@ -31,6 +31,17 @@ func (s *Server) router() chi.Router {
 		r.Delete("/env", s.deleteEnv)
 
 		r.Post("/redactions", s.createRedaction)
+
+		r.Get("/job", s.getJob)
+
+		r.Put("/pty-size", s.putPTYSize)
+
+		r.Get("/oidc-tokens/{key}", s.getOIDCToken)
+		r.Put("/oidc-tokens/{key}", s.putOIDCToken)
+
+		r.Get("/git-credentials/{key}", s.getGitCredential)
+		r.Put("/git-credentials/{key}", s.putGitCredential)
+		r.Delete("/git-credentials/{key}", s.deleteGitCredential)
 	})
 
 	return r
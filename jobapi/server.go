@@ -22,6 +22,22 @@ func WithDebug() ServerOpts {
 	}
 }
 
+// WithPTYResizer lets the job resize the PTY its commands run in, by calling
+// resize.
+func WithPTYResizer(resize func(rows, cols uint16) error) ServerOpts {
+	return func(s *Server) {
+		s.resizePTY = resize
+	}
+}
+
+// WithProtectedEnv stops the job from changing env vars with names matching
+// the patterns, as well as those in agent.ProtectedEnv.
+func WithProtectedEnv(patterns []string) ServerOpts {
+	return func(s *Server) {
+		s.protectedEnv = patterns
+	}
+}
+
 // Server is a Job API server. It provides an HTTP API with which to interact with the job currently running in the buildkite agent
 // and allows jobs to introspect and mutate their own state
 type Server struct {
@@ -30,9 +46,24 @@ type Server struct {
 	Logger     shell.Logger
 	debug      bool
 
+	// Resizes the PTY the job's commands run in, if they run in one
+	resizePTY func(rows, cols uint16) error
+
+	// Patterns of env var names the job can't change
+	protectedEnv []string
+
 	mtx       sync.RWMutex
 	environ   *env.Environment
 	redactors *replacer.Mux
+	phases    []PhaseTiming
+
+	// OIDC tokens cached by `buildkite-agent oidc request-token`, by a key
+	// derived from the token request
+	oidcTokens map[string]OIDCToken
+
+	// Git credentials cached by `buildkite-agent git-credentials-helper`, by a
+	// key derived from the repository
+	gitCredentials map[string]GitCredential
 
 	token   string
 	sockSvr *socket.Server
@@ -36,12 +36,12 @@ func testEnviron() *env.Environment {
 	return e
 }
 
-func testServer(t *testing.T, e *env.Environment, mux *replacer.Mux) (*jobapi.Server, string, error) {
+func testServer(t *testing.T, e *env.Environment, mux *replacer.Mux, opts ...jobapi.ServerOpts) (*jobapi.Server, string, error) {
 	sockName, err := jobapi.NewSocketPath(os.TempDir())
 	if err != nil {
 		return nil, "", fmt.Errorf("creating socket path: %w", err)
 	}
-	return jobapi.NewServer(shell.TestingLogger{T: t}, sockName, e, mux)
+	return jobapi.NewServer(shell.TestingLogger{T: t}, sockName, e, mux, opts...)
 }
 
 func testSocketClient(socketPath string) *http.Client {
@@ -301,6 +301,20 @@ func TestPatchEnv(t *testing.T) {
 			},
 			expectedEnv: testEnviron().Dump(), // ie no changes
 		},
+		{
+			name: "setting variables matching protected-env patterns returns a 422",
+			requestBody: &jobapi.EnvUpdateRequestPayload{
+				Env: map[string]*string{
+					"VOLCANO_COTOPAXI": pt("active"),
+					"MOUNTAIN":         pt("antisana"),
+				},
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError: &jobapi.ErrorResponse{
+				Error: "the following environment variables are protected, and cannot be modified: [VOLCANO_COTOPAXI]",
+			},
+			expectedEnv: testEnviron().Dump(), // ie no changes
+		},
 	}
 
 	for _, c := range cases {
@@ -308,7 +322,7 @@ func TestPatchEnv(t *testing.T) {
 			t.Parallel()
 
 			environ := testEnviron()
-			srv, token, err := testServer(t, environ, replacer.NewMux())
+			srv, token, err := testServer(t, environ, replacer.NewMux(), jobapi.WithProtectedEnv([]string{"VOLCANO_*"}))
 			if err != nil {
 				t.Fatalf("creating server: %v", err)
 			}
@@ -401,6 +415,240 @@ func TestGetEnv(t *testing.T) {
 	})
 }
 
+func TestGetJob(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	env := testEnviron()
+	env.Set("BUILDKITE_JOB_ID", "b1f0a6e4-7e1c-4c8b-9b1a-6d2a1f3c5e7d")
+	env.Set("BUILDKITE_STEP_KEY", "volcanoes")
+	env.Set("BUILDKITE_COMMIT", "1234567890abcdef1234567890abcdef12345678")
+	env.Set("BUILDKITE_BRANCH", "main")
+	env.Set("BUILDKITE_RETRY_COUNT", "2")
+
+	srv, token, err := testServer(t, env, replacer.NewMux())
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	defer func() {
+		if err := srv.Stop(); err != nil {
+			t.Fatalf("stopping server: %v", err)
+		}
+	}()
+
+	client, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	got, err := client.JobGet(ctx)
+	if err != nil {
+		t.Fatalf("client.JobGet(ctx) error = %v", err)
+	}
+	want := &jobapi.JobGetResponse{
+		ID:         "b1f0a6e4-7e1c-4c8b-9b1a-6d2a1f3c5e7d",
+		StepKey:    "volcanoes",
+		Commit:     "1234567890abcdef1234567890abcdef12345678",
+		Branch:     "main",
+		RetryCount: 2,
+		Phases:     []jobapi.PhaseTiming{},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("client.JobGet(ctx) diff (-got +want):\n%s", diff)
+	}
+
+	srv.StartPhase("checkout")
+	srv.StartPhase("command")
+
+	got, err = client.JobGet(ctx)
+	if err != nil {
+		t.Fatalf("client.JobGet(ctx) error = %v", err)
+	}
+	if got.Phase != "command" {
+		t.Errorf("client.JobGet(ctx).Phase = %q, want %q", got.Phase, "command")
+	}
+	if len(got.Phases) != 2 {
+		t.Fatalf("len(client.JobGet(ctx).Phases) = %d, want 2", len(got.Phases))
+	}
+	checkout, command := got.Phases[0], got.Phases[1]
+	if checkout.Name != "checkout" || checkout.FinishedAt == nil || checkout.FinishedAt.Before(checkout.StartedAt) {
+		t.Errorf("checkout phase = %+v, want a finished checkout phase", checkout)
+	}
+	if command.Name != "command" || command.FinishedAt != nil || command.StartedAt.Before(checkout.StartedAt) {
+		t.Errorf("command phase = %+v, want a running command phase", command)
+	}
+}
+
+func TestOIDCTokenCache(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	defer func() {
+		if err := srv.Stop(); err != nil {
+			t.Fatalf("stopping server: %v", err)
+		}
+	}()
+
+	client, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	got, err := client.OIDCTokenGet(ctx, "llamas", 0)
+	if err != nil {
+		t.Fatalf("client.OIDCTokenGet(ctx, llamas, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.OIDCTokenGet(ctx, llamas, 0) = %v, want nil before any token is cached", got)
+	}
+
+	cached := &jobapi.OIDCToken{
+		Token:     "eyJhbGciOiJSUzI1NiJ9.llamas.signature",
+		ExpiresAt: time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second),
+	}
+	if err := client.OIDCTokenPut(ctx, "llamas", cached); err != nil {
+		t.Fatalf("client.OIDCTokenPut(ctx, llamas, %v) error = %v", cached, err)
+	}
+
+	got, err = client.OIDCTokenGet(ctx, "llamas", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("client.OIDCTokenGet(ctx, llamas, 5m) error = %v", err)
+	}
+	if diff := cmp.Diff(got, cached); diff != "" {
+		t.Errorf("client.OIDCTokenGet(ctx, llamas, 5m) diff (-got +want):\n%s", diff)
+	}
+
+	// The token isn't valid for long enough, so it needs refreshing
+	got, err = client.OIDCTokenGet(ctx, "llamas", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("client.OIDCTokenGet(ctx, llamas, 15m) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.OIDCTokenGet(ctx, llamas, 15m) = %v, want nil", got)
+	}
+
+	got, err = client.OIDCTokenGet(ctx, "alpacas", 0)
+	if err != nil {
+		t.Fatalf("client.OIDCTokenGet(ctx, alpacas, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.OIDCTokenGet(ctx, alpacas, 0) = %v, want nil for a different key", got)
+	}
+
+	expired := &jobapi.OIDCToken{Token: "expired", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := client.OIDCTokenPut(ctx, "expired", expired); err != nil {
+		t.Fatalf("client.OIDCTokenPut(ctx, expired, %v) error = %v", expired, err)
+	}
+	got, err = client.OIDCTokenGet(ctx, "expired", 0)
+	if err != nil {
+		t.Fatalf("client.OIDCTokenGet(ctx, expired, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.OIDCTokenGet(ctx, expired, 0) = %v, want nil for an expired token", got)
+	}
+}
+
+func TestGitCredentialCache(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, token, err := testServer(t, testEnviron(), replacer.NewMux())
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	defer func() {
+		if err := srv.Stop(); err != nil {
+			t.Fatalf("stopping server: %v", err)
+		}
+	}()
+
+	client, err := jobapi.NewClient(ctx, srv.SocketPath, token)
+	if err != nil {
+		t.Fatalf("jobapi.NewClient(ctx, %q, token) error = %v", srv.SocketPath, err)
+	}
+
+	got, err := client.GitCredentialGet(ctx, "llamas", 0)
+	if err != nil {
+		t.Fatalf("client.GitCredentialGet(ctx, llamas, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.GitCredentialGet(ctx, llamas, 0) = %v, want nil before any credential is cached", got)
+	}
+
+	cached := &jobapi.GitCredential{
+		Username:  "token",
+		Password:  "ghs_llamas",
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	if err := client.GitCredentialPut(ctx, "llamas", cached); err != nil {
+		t.Fatalf("client.GitCredentialPut(ctx, llamas, %v) error = %v", cached, err)
+	}
+
+	got, err = client.GitCredentialGet(ctx, "llamas", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("client.GitCredentialGet(ctx, llamas, 5m) error = %v", err)
+	}
+	if diff := cmp.Diff(got, cached); diff != "" {
+		t.Errorf("client.GitCredentialGet(ctx, llamas, 5m) diff (-got +want):\n%s", diff)
+	}
+
+	// The credential isn't valid for long enough, so it needs refreshing
+	got, err = client.GitCredentialGet(ctx, "llamas", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("client.GitCredentialGet(ctx, llamas, 2h) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.GitCredentialGet(ctx, llamas, 2h) = %v, want nil", got)
+	}
+
+	got, err = client.GitCredentialGet(ctx, "alpacas", 0)
+	if err != nil {
+		t.Fatalf("client.GitCredentialGet(ctx, alpacas, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.GitCredentialGet(ctx, alpacas, 0) = %v, want nil for a different key", got)
+	}
+
+	if err := client.GitCredentialDelete(ctx, "llamas"); err != nil {
+		t.Fatalf("client.GitCredentialDelete(ctx, llamas) error = %v", err)
+	}
+	got, err = client.GitCredentialGet(ctx, "llamas", 0)
+	if err != nil {
+		t.Fatalf("client.GitCredentialGet(ctx, llamas, 0) error = %v", err)
+	}
+	if got != nil {
+		t.Errorf("client.GitCredentialGet(ctx, llamas, 0) = %v, want nil after deleting it", got)
+	}
+
+	// Deleting something that isn't cached is fine
+	if err := client.GitCredentialDelete(ctx, "alpacas"); err != nil {
+		t.Errorf("client.GitCredentialDelete(ctx, alpacas) error = %v", err)
+	}
+
+	if err := client.GitCredentialPut(ctx, "forever", &jobapi.GitCredential{Password: "ghs_forever"}); err == nil {
+		t.Errorf("client.GitCredentialPut(ctx, forever, credential without expiry) error = nil, want an error")
+	}
+}
+
 func TestCreateRedaction(t *testing.T) {
 	t.Parallel()
 
@@ -544,3 +792,77 @@ func TestNoLogging(t *testing.T) {
 	logs := logBuf.String()
 	assert.Assert(t, logs == "", "logs: %q", logs)
 }
+
+func TestPutPTYSize(t *testing.T) {
+	t.Parallel()
+
+	sockName, err := jobapi.NewSocketPath(os.TempDir())
+	assert.NilError(t, err)
+
+	var got jobapi.PTYSize
+	resize := func(rows, cols uint16) error {
+		got = jobapi.PTYSize{Rows: rows, Cols: cols}
+		return nil
+	}
+	srv, token, err := jobapi.NewServer(shell.TestingLogger{T: t}, sockName, env.New(), replacer.NewMux(), jobapi.WithPTYResizer(resize))
+	assert.NilError(t, err)
+
+	assert.NilError(t, srv.Start())
+	t.Cleanup(func() {
+		assert.NilError(t, srv.Stop())
+	})
+
+	cases := []apiTestCase[jobapi.PTYSize, jobapi.PTYSize]{
+		{
+			name:                 "resize",
+			requestBody:          &jobapi.PTYSize{Rows: 50, Cols: 200},
+			expectedStatus:       http.StatusOK,
+			expectedResponseBody: &jobapi.PTYSize{Rows: 50, Cols: 200},
+		},
+		{
+			name:           "missing cols",
+			requestBody:    &jobapi.PTYSize{Rows: 50},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedError:  &jobapi.ErrorResponse{Error: "rows and cols are required"},
+		},
+	}
+
+	client := testSocketClient(srv.SocketPath)
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		assert.NilError(t, json.NewEncoder(buf).Encode(c.requestBody))
+
+		req, err := http.NewRequest(http.MethodPut, "http://job/api/current-job/v0/pty-size", buf)
+		assert.NilError(t, err)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		testAPI(t, env.New(), req, client, c)
+	}
+
+	if want := (jobapi.PTYSize{Rows: 50, Cols: 200}); got != want {
+		t.Errorf("resized PTY to %+v, want %+v", got, want)
+	}
+}
+
+func TestPutPTYSizeWithoutPTY(t *testing.T) {
+	t.Parallel()
+
+	srv, token, err := testServer(t, env.New(), replacer.NewMux())
+	assert.NilError(t, err)
+
+	assert.NilError(t, srv.Start())
+	t.Cleanup(func() {
+		assert.NilError(t, srv.Stop())
+	})
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, json.NewEncoder(buf).Encode(jobapi.PTYSize{Rows: 50, Cols: 200}))
+	req, err := http.NewRequest(http.MethodPut, "http://job/api/current-job/v0/pty-size", buf)
+	assert.NilError(t, err)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	testAPI(t, env.New(), req, testSocketClient(srv.SocketPath), apiTestCase[jobapi.PTYSize, jobapi.PTYSize]{
+		expectedStatus: http.StatusNotFound,
+		expectedError:  &jobapi.ErrorResponse{Error: "the job's commands aren't run in a PTY"},
+	})
+}
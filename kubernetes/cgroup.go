@@ -0,0 +1,125 @@
+package kubernetes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCgroupRoot = "/sys/fs/cgroup"
+
+// ContainerStats is an RPC message containing resource usage statistics for a
+// container, read from its cgroup when it exits.
+type ContainerStats struct {
+	// The most memory used by the container at any one time, or 0 if unknown
+	PeakMemoryBytes uint64
+
+	// The total CPU time used by the container
+	CPUTime time.Duration
+
+	// How many processes in the container were killed for running out of
+	// memory
+	OOMKills int
+}
+
+// ReadCgroupStats reads the resource usage of the cgroup mounted at root,
+// which may be a cgroup v2 unified hierarchy or a set of cgroup v1
+// controllers.
+func ReadCgroupStats(root string) (*ContainerStats, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return readCgroupV2Stats(root)
+	}
+	return readCgroupV1Stats(root)
+}
+
+func readCgroupV2Stats(root string) (*ContainerStats, error) {
+	stats := &ContainerStats{}
+
+	cpu, err := readCgroupKeyedFile(filepath.Join(root, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUTime = time.Duration(cpu["usage_usec"]) * time.Microsecond
+
+	// memory.peak was added in Linux 5.19.
+	peak, err := readCgroupValue(filepath.Join(root, "memory.peak"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	stats.PeakMemoryBytes = peak
+
+	events, err := readCgroupKeyedFile(filepath.Join(root, "memory.events"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	stats.OOMKills = int(events["oom_kill"])
+
+	return stats, nil
+}
+
+func readCgroupV1Stats(root string) (*ContainerStats, error) {
+	stats := &ContainerStats{}
+
+	cpu, err := readCgroupValue(filepath.Join(root, "cpuacct", "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUTime = time.Duration(cpu)
+
+	peak, err := readCgroupValue(filepath.Join(root, "memory", "memory.max_usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	stats.PeakMemoryBytes = peak
+
+	// oom_kill was added to memory.oom_control in Linux 4.13.
+	oom, err := readCgroupKeyedFile(filepath.Join(root, "memory", "memory.oom_control"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	stats.OOMKills = int(oom["oom_kill"])
+
+	return stats, nil
+}
+
+// readCgroupValue reads a cgroup file containing a single number.
+func readCgroupValue(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// readCgroupKeyedFile reads a cgroup file containing lines of keys and
+// numbers, such as cpu.stat.
+func readCgroupKeyedFile(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,65 @@
+package kubernetes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCgroupFiles creates a fake cgroup filesystem.
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o666))
+	}
+	return root
+}
+
+func TestReadCgroupStatsV2(t *testing.T) {
+	t.Parallel()
+
+	root := writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory pids\n",
+		"cpu.stat":           "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+		"memory.peak":        "268435456\n",
+		"memory.events":      "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+	})
+
+	stats, err := ReadCgroupStats(root)
+	require.NoError(t, err)
+	require.Equal(t, &ContainerStats{
+		PeakMemoryBytes: 256 << 20,
+		CPUTime:         1500 * time.Millisecond,
+		OOMKills:        1,
+	}, stats)
+}
+
+func TestReadCgroupStatsV1(t *testing.T) {
+	t.Parallel()
+
+	root := writeCgroupFiles(t, map[string]string{
+		"cpuacct/cpuacct.usage":            "2000000000\n",
+		"memory/memory.max_usage_in_bytes": "1048576\n",
+		"memory/memory.oom_control":        "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n",
+	})
+
+	stats, err := ReadCgroupStats(root)
+	require.NoError(t, err)
+	require.Equal(t, &ContainerStats{
+		PeakMemoryBytes: 1 << 20,
+		CPUTime:         2 * time.Second,
+	}, stats)
+}
+
+func TestReadCgroupStatsMissing(t *testing.T) {
+	t.Parallel()
+
+	_, err := ReadCgroupStats(t.TempDir())
+	require.Error(t, err)
+}
@@ -3,6 +3,7 @@ package kubernetes
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/rpc"
 	"time"
 
@@ -13,6 +14,14 @@ type Client struct {
 	ID         int
 	SocketPath string
 
+	// If set, each line of the container's output is prefixed with this in
+	// the job log.
+	LogPrefix string
+
+	// Where the container's cgroup is mounted, for reporting its resource
+	// usage when it exits. Defaults to /sys/fs/cgroup.
+	CgroupRoot string
+
 	client *rpc.Client
 }
 
@@ -40,6 +49,11 @@ func (c *Client) Connect(ctx context.Context) (*RegisterResponse, error) {
 	if err := c.client.Call("Runner.Register", c.ID, &resp); err != nil {
 		return nil, err
 	}
+	if c.LogPrefix != "" {
+		if err := c.client.Call("Runner.SetLogPrefix", LogPrefix{ID: c.ID, Prefix: c.LogPrefix}, nil); err != nil {
+			return nil, err
+		}
+	}
 	return &resp, nil
 }
 
@@ -47,9 +61,16 @@ func (c *Client) Exit(exitStatus int) error {
 	if c.client == nil {
 		return errNotConnected
 	}
+	if c.CgroupRoot == "" {
+		c.CgroupRoot = defaultCgroupRoot
+	}
+	// Resource usage is only informational, so if the cgroup can't be read,
+	// exit without it.
+	stats, _ := ReadCgroupStats(c.CgroupRoot)
 	return c.client.Call("Runner.Exit", ExitCode{
 		ID:         c.ID,
 		ExitStatus: exitStatus,
+		Stats:      stats,
 	}, nil)
 }
 
@@ -61,12 +82,50 @@ func (c *Client) Write(p []byte) (int, error) {
 	n := len(p)
 	err := c.client.Call("Runner.WriteLogs", Logs{
 		Data: p,
+		ID:   c.ID,
 	}, nil)
 	return n, err
 }
 
 var ErrInterrupt = errors.New("interrupt signal received")
 
+// ErrRunnerLost is returned by Heartbeat when the runner in the agent
+// container has stopped responding.
+var ErrRunnerLost = errors.New("lost contact with the agent container")
+
+// Heartbeat tells the runner that the client is still alive every interval,
+// until ctx is done. It returns ErrRunnerLost if the connection to the runner
+// closes, or the runner hasn't responded for longer than timeout (for example,
+// if the agent container disappeared), or nil once ctx is done. Whether the
+// job has finished or been interrupted is found out with Await.
+func (c *Client) Heartbeat(ctx context.Context, interval, timeout time.Duration) error {
+	if c.client == nil {
+		return errNotConnected
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	lastHeard := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+		}
+
+		err := c.client.Call("Runner.Heartbeat", c.ID, &Empty{})
+		switch {
+		case err == nil:
+			lastHeard = time.Now()
+		case errors.Is(err, rpc.ErrShutdown):
+			// The connection to the runner has closed.
+			return ErrRunnerLost
+		case time.Since(lastHeard) > timeout:
+			return fmt.Errorf("%w: %v", ErrRunnerLost, err)
+		}
+	}
+}
+
 func (c *Client) Await(ctx context.Context, desiredState RunState) error {
 	for {
 		select {
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// linearDependencies returns the default ordering of containers, where each
+// container starts after the one before it has exited.
+func linearDependencies(count int) map[int][]int {
+	deps := make(map[int][]int, count)
+	for id := 1; id < count; id++ {
+		deps[id] = []int{id - 1}
+	}
+	return deps
+}
+
+// ParseContainerDependencies parses the ordering of count containers from a
+// JSON object mapping container IDs to the IDs of the containers that must
+// exit before they start, for example {"1": [0], "2": [0]} to run containers
+// 1 and 2 in parallel after container 0. Containers that aren't mentioned
+// start straight away. An empty string means the default linear ordering.
+func ParseContainerDependencies(s string, count int) (map[int][]int, error) {
+	if s == "" {
+		return linearDependencies(count), nil
+	}
+
+	var raw map[string][]int
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("parsing container dependencies: %w", err)
+	}
+
+	deps := make(map[int][]int, len(raw))
+	for key, dependsOn := range raw {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing container dependencies: invalid container id %q", key)
+		}
+		slices.Sort(dependsOn)
+		deps[id] = slices.Compact(dependsOn)
+	}
+	if err := validateDependencies(deps, count); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// validateDependencies checks that the dependencies only refer to containers
+// that exist, and that they don't form a cycle (which would never start).
+func validateDependencies(deps map[int][]int, count int) error {
+	for id, dependsOn := range deps {
+		if id < 0 || id >= count {
+			return fmt.Errorf("container dependencies: unrecognized container id %d", id)
+		}
+		for _, dep := range dependsOn {
+			if dep < 0 || dep >= count {
+				return fmt.Errorf("container dependencies: container %d depends on unrecognized container id %d", id, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, count)
+	var visit func(id int) error
+	visit = func(id int) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("container dependencies: container %d depends on itself", id)
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		for _, dep := range deps[id] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[id] = visited
+		return nil
+	}
+	for id := range count {
+		if err := visit(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
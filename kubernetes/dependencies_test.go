@@ -0,0 +1,63 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContainerDependencies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		count int
+		want  map[int][]int
+	}{
+		{
+			name:  "default linear",
+			input: "",
+			count: 3,
+			want:  map[int][]int{1: {0}, 2: {1}},
+		},
+		{
+			name:  "fan out and in",
+			input: `{"1": [0], "2": [0], "3": [2, 1, 1]}`,
+			count: 4,
+			want:  map[int][]int{1: {0}, 2: {0}, 3: {1, 2}},
+		},
+		{
+			name:  "all parallel",
+			input: `{}`,
+			count: 2,
+			want:  map[int][]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseContainerDependencies(test.input, test.count)
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseContainerDependenciesErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{
+		`[0, 1]`,
+		`{"one": [0]}`,
+		`{"3": [0]}`,
+		`{"1": [5]}`,
+		`{"0": [0]}`,
+		`{"0": [2], "1": [0], "2": [1]}`,
+	} {
+		if _, err := ParseContainerDependencies(input, 3); err == nil {
+			t.Errorf("ParseContainerDependencies(%q, 3) error = nil, want an error", input)
+		}
+	}
+}
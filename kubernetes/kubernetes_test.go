@@ -3,11 +3,14 @@
 package kubernetes
 
 import (
+	"bytes"
 	"context"
 	"encoding/gob"
 	"net/rpc"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -53,6 +56,124 @@ func TestOrderedClients(t *testing.T) {
 	}
 }
 
+func TestParallelClients(t *testing.T) {
+	// Clients 1 and 2 run in parallel after client 0, then client 3 runs
+	// after both of them.
+	runner := newRunnerWithConfig(t, RunnerConfig{
+		ClientCount: 4,
+		DependsOn:   map[int][]int{1: {0}, 2: {0}, 3: {1, 2}},
+	})
+
+	var clients []*Client
+	for id := range 4 {
+		client := &Client{ID: id, SocketPath: runner.conf.SocketPath}
+		resp, err := client.Connect(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, runner.conf.DependsOn[id], resp.DependsOn)
+		t.Cleanup(client.Close)
+		clients = append(clients, client)
+	}
+
+	ctx := context.Background()
+	require.NoError(t, clients[0].Await(ctx, RunStateStart))
+	require.NoError(t, clients[1].Await(ctx, RunStateWait))
+	require.NoError(t, clients[2].Await(ctx, RunStateWait))
+
+	require.NoError(t, clients[0].Exit(0))
+	require.NoError(t, clients[1].Await(ctx, RunStateStart))
+	require.NoError(t, clients[2].Await(ctx, RunStateStart))
+	require.NoError(t, clients[3].Await(ctx, RunStateWait))
+
+	require.NoError(t, clients[2].Exit(0))
+	require.NoError(t, clients[3].Await(ctx, RunStateWait))
+
+	require.NoError(t, clients[1].Exit(0))
+	require.NoError(t, clients[3].Await(ctx, RunStateStart))
+
+	require.NoError(t, clients[3].Exit(0))
+	select {
+	case <-runner.Done():
+	default:
+		require.FailNow(t, "runner should be done when all clients have exited")
+	}
+}
+
+func TestLogPrefixes(t *testing.T) {
+	var logs syncBuffer
+	runner := newRunnerWithConfig(t, RunnerConfig{
+		ClientCount: 3,
+		Stdout:      &logs,
+	})
+
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath, LogPrefix: "[unit] "}
+	client1 := &Client{ID: 1, SocketPath: runner.conf.SocketPath, LogPrefix: "[lint] "}
+	client2 := &Client{ID: 2, SocketPath: runner.conf.SocketPath}
+	for _, client := range []*Client{client0, client1, client2} {
+		require.NoError(t, connect(client))
+		t.Cleanup(client.Close)
+	}
+
+	for _, write := range []struct {
+		client *Client
+		data   string
+	}{
+		{client0, "running "},
+		{client1, "linting...\nok\n"},
+		{client0, "tests\npassed"},
+		{client2, "no prefix\n"},
+	} {
+		_, err := write.client.Write([]byte(write.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, client0.Exit(0))
+
+	require.Equal(t, "[lint] linting...\n[lint] ok\n[unit] running tests\nno prefix\n[unit] passed\n", logs.String())
+}
+
+func TestPrefixLinesLeavesSectionHeaders(t *testing.T) {
+	t.Parallel()
+
+	got := prefixLines("[unit] ", []byte("--- Tests\nok\n+++ Failures\n^^^ +++\n~~~ Cleanup\n-- not a header\n"))
+	want := "--- Tests\n[unit] ok\n+++ Failures\n^^^ +++\n~~~ Cleanup\n[unit] -- not a header\n"
+	require.Equal(t, want, string(got))
+}
+
+func TestLogPrefixesBreakLongLines(t *testing.T) {
+	var logs syncBuffer
+	runner := newRunnerWithConfig(t, RunnerConfig{
+		ClientCount: 1,
+		Stdout:      &logs,
+	})
+
+	client := &Client{ID: 0, SocketPath: runner.conf.SocketPath, LogPrefix: "[unit] "}
+	require.NoError(t, connect(client))
+	t.Cleanup(client.Close)
+
+	long := strings.Repeat("x", maxPartialLineBytes+1)
+	_, err := client.Write([]byte(long))
+	require.NoError(t, err)
+
+	require.Equal(t, "[unit] "+long+"\n", logs.String())
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 func TestLivenessCheck(t *testing.T) {
 	runner := newRunner(t, 2)
 	socketPath := runner.conf.SocketPath
@@ -80,6 +201,66 @@ func TestLivenessCheck(t *testing.T) {
 	}
 }
 
+func TestHeartbeatKeepsClientAlive(t *testing.T) {
+	runner := newRunner(t, 1)
+
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath}
+	require.NoError(t, connect(client0))
+	t.Cleanup(client0.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	heartbeatErr := make(chan error, 1)
+	go func() {
+		heartbeatErr <- client0.Heartbeat(ctx, 100*time.Millisecond, 10*time.Second)
+	}()
+
+	// The runner's ClientLostTimeout is 2 seconds, but the client is still
+	// sending heartbeats.
+	time.Sleep(3 * time.Second)
+	select {
+	case <-runner.Done():
+		t.Fatalf("runner terminated while client was sending heartbeats")
+	default:
+	}
+
+	// Once the heartbeats stop, the client is lost.
+	cancel()
+	require.NoError(t, <-heartbeatErr)
+	select {
+	case <-runner.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for client0 to be declared lost and job terminated")
+	}
+	require.True(t, runner.AnyClientIn(StateLost))
+}
+
+func TestHeartbeatAfterTerminate(t *testing.T) {
+	runner := newRunner(t, 1)
+
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath}
+	require.NoError(t, connect(client0))
+	t.Cleanup(client0.Close)
+
+	require.NoError(t, runner.Terminate())
+
+	// The runner still answers heartbeats, and the client finds out about
+	// the termination from Await.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, client0.Heartbeat(ctx, 10*time.Millisecond, time.Second))
+	require.ErrorContains(t, client0.Await(context.Background(), RunStateInterrupt), rpc.ErrShutdown.Error())
+}
+
+func TestHeartbeatConnectionClosed(t *testing.T) {
+	runner := newRunner(t, 1)
+
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath}
+	require.NoError(t, connect(client0))
+	client0.Close()
+
+	require.ErrorIs(t, client0.Heartbeat(context.Background(), 10*time.Millisecond, time.Second), ErrRunnerLost)
+}
+
 func TestDuplicateClients(t *testing.T) {
 	runner := newRunner(t, 2)
 	socketPath := runner.conf.SocketPath
@@ -141,18 +322,48 @@ func TestTerminate(t *testing.T) {
 	require.ErrorContains(t, client0.Await(ctx, RunStateInterrupt), rpc.ErrShutdown.Error())
 }
 
+func TestWriteSummary(t *testing.T) {
+	runner := newRunner(t, 3)
+
+	cgroupRoot := writeCgroupFiles(t, map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"cpu.stat":           "usage_usec 1234567\n",
+		"memory.peak":        "536870912\n",
+		"memory.events":      "oom_kill 1\n",
+	})
+	client0 := &Client{ID: 0, SocketPath: runner.conf.SocketPath, CgroupRoot: cgroupRoot}
+	client1 := &Client{ID: 1, SocketPath: runner.conf.SocketPath, CgroupRoot: t.TempDir()}
+
+	require.NoError(t, connect(client0))
+	require.NoError(t, connect(client1))
+	require.False(t, runner.AnyClientOOMKilled())
+	require.NoError(t, client0.Exit(137))
+	require.NoError(t, client1.Exit(0))
+	require.True(t, runner.AnyClientOOMKilled())
+
+	var buf bytes.Buffer
+	require.NoError(t, runner.WriteSummary(&buf))
+	require.Equal(t, `~~~ Container summary
+Container 0: exited with status 137 (OOM killed 1 process), peak memory 512 MiB, CPU time 1.235s
+Container 1: exited with status 0, resource usage unknown
+Container 2: never connected
+`, buf.String())
+}
+
 func newRunner(t *testing.T, clientCount int) *Runner {
+	return newRunnerWithConfig(t, RunnerConfig{ClientCount: clientCount})
+}
+
+func newRunnerWithConfig(t *testing.T, conf RunnerConfig) *Runner {
 	tempDir, err := os.MkdirTemp("", t.Name())
 	require.NoError(t, err)
 	socketPath := filepath.Join(tempDir, "bk.sock")
 	t.Cleanup(func() {
 		os.RemoveAll(tempDir)
 	})
-	runner := NewRunner(logger.Discard, RunnerConfig{
-		SocketPath:        socketPath,
-		ClientCount:       clientCount,
-		ClientLostTimeout: 2 * time.Second,
-	})
+	conf.SocketPath = socketPath
+	conf.ClientLostTimeout = 2 * time.Second
+	runner := NewRunner(logger.Discard, conf)
 	runnerCtx, cancelRunner := context.WithCancel(context.Background())
 	go runner.Run(runnerCtx)
 	t.Cleanup(func() {
@@ -16,6 +16,7 @@ import (
 
 	"github.com/buildkite/agent/v3/logger"
 	"github.com/buildkite/agent/v3/process"
+	"github.com/dustin/go-humanize"
 )
 
 func init() {
@@ -30,6 +31,11 @@ type RunnerConfig struct {
 	Stdout, Stderr    io.Writer
 	Env               []string
 	ClientLostTimeout time.Duration
+
+	// DependsOn maps each client ID to the IDs of the clients that must exit
+	// before it can start (see ParseContainerDependencies). If nil, each
+	// client starts after the one before it has exited.
+	DependsOn map[int][]int
 }
 
 // NewRunner returns a runner, implementing the agent's jobRunner interface.
@@ -37,6 +43,15 @@ func NewRunner(l logger.Logger, c RunnerConfig) *Runner {
 	if c.SocketPath == "" {
 		c.SocketPath = defaultSocketPath
 	}
+	if c.Stdout == nil {
+		c.Stdout = io.Discard
+	}
+	if c.Stderr == nil {
+		c.Stderr = io.Discard
+	}
+	if c.DependsOn == nil {
+		c.DependsOn = linearDependencies(c.ClientCount)
+	}
 	clients := make([]*clientResult, c.ClientCount)
 	for i := range c.ClientCount {
 		clients[i] = &clientResult{}
@@ -70,6 +85,9 @@ type Runner struct {
 	server  *rpc.Server
 	mux     *http.ServeMux
 	clients []*clientResult
+
+	// Serialises writes to Stdout from different clients
+	logMu sync.Mutex
 }
 
 // Run runs the socket server.
@@ -126,6 +144,7 @@ func (r *Runner) livenessCheck(ctx context.Context) {
 				lhf := time.Since(client.LastHeardFrom)
 				if client.State == StateConnected && lhf > r.conf.ClientLostTimeout {
 					r.logger.Error("Container (ID %d) was last heard from %v ago; marking lost and self-terminating...", id, lhf)
+					fmt.Fprintf(r.conf.Stderr, "Container %d stopped sending heartbeats %v ago without exiting, so the job has failed. Perhaps the container was OOM-killed, or its node was evicted?\n", id, lhf.Round(time.Second))
 					client.State = StateLost
 					r.Terminate()
 				}
@@ -192,21 +211,157 @@ func (r *Runner) AnyClientIn(state ClientState) bool {
 	return false
 }
 
+// AnyClientOOMKilled reports whether any of the clients exited after some of
+// their processes were killed for running out of memory.
+func (r *Runner) AnyClientOOMKilled() bool {
+	for _, client := range r.clients {
+		client.mu.Lock()
+		oom := client.State == StateExited && client.Stats != nil && client.Stats.OOMKills > 0
+		client.mu.Unlock()
+
+		if oom {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSummary writes a section to the job log describing how each container
+// exited, and how much memory and CPU it used.
+func (r *Runner) WriteSummary(w io.Writer) error {
+	if len(r.clients) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("~~~ Container summary\n")
+	for id, client := range r.clients {
+		client.mu.Lock()
+		fmt.Fprintf(&buf, "Container %d: %s\n", id, client.summary())
+		client.mu.Unlock()
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
 // ==== sidecar api ====
 
 // Empty is an empty RPC message.
 type Empty struct{}
 
-// WriteLogs is called to pass logs on to Buildkite.
+// WriteLogs is called to pass logs on to Buildkite. If the client has a log
+// prefix, its output is written a whole line at a time, with each line
+// prefixed, so that the output of containers running in parallel doesn't get
+// mixed up.
 func (r *Runner) WriteLogs(args Logs, reply *Empty) error {
 	r.markStarted()
-	_, err := io.Copy(r.conf.Stdout, bytes.NewReader(args.Data))
+
+	var client *clientResult
+	if args.ID >= 0 && args.ID < len(r.clients) {
+		client = r.clients[args.ID]
+	}
+	if client == nil {
+		return r.writeLogs(args.Data)
+	}
+
+	client.mu.Lock()
+	prefix := client.LogPrefix
+	if prefix == "" {
+		client.mu.Unlock()
+		return r.writeLogs(args.Data)
+	}
+	client.partialLine = append(client.partialLine, args.Data...)
+	end := bytes.LastIndexByte(client.partialLine, '\n') + 1
+	lines := bytes.Clone(client.partialLine[:end])
+	client.partialLine = append(client.partialLine[:0], client.partialLine[end:]...)
+	if len(client.partialLine) > maxPartialLineBytes {
+		// Don't hold on to a line that never ends, such as a progress bar
+		// that only uses carriage returns. Break it instead.
+		lines = append(lines, client.partialLine...)
+		lines = append(lines, '\n')
+		client.partialLine = client.partialLine[:0]
+	}
+	client.mu.Unlock()
+
+	return r.writeLogs(prefixLines(prefix, lines))
+}
+
+// flushLogs writes any incomplete line that a client with a log prefix has
+// written.
+func (r *Runner) flushLogs(client *clientResult) error {
+	client.mu.Lock()
+	prefix, line := client.LogPrefix, client.partialLine
+	client.partialLine = nil
+	client.mu.Unlock()
+
+	if len(line) == 0 {
+		return nil
+	}
+	return r.writeLogs(prefixLines(prefix, append(line, '\n')))
+}
+
+func (r *Runner) writeLogs(data []byte) error {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+	_, err := io.Copy(r.conf.Stdout, bytes.NewReader(data))
 	return err
 }
 
+// maxPartialLineBytes is the most of an incomplete line that's held on to for
+// a client with a log prefix, before it's written as a line of its own.
+const maxPartialLineBytes = 64 * 1024
+
+// prefixLines adds prefix to the start of each line in data, which ends with
+// a newline. Lines that open a section of the log, such as "--- Tests", are
+// left as they are, since Buildkite only recognises them at the start of a
+// line.
+func prefixLines(prefix string, data []byte) []byte {
+	var buf bytes.Buffer
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n') + 1
+		if !isSectionHeader(data[:i]) {
+			buf.WriteString(prefix)
+		}
+		buf.Write(data[:i])
+		data = data[i:]
+	}
+	return buf.Bytes()
+}
+
+// isSectionHeader reports whether line is a log section header, such as
+// "~~~ Setup", "--- Tests" or "+++ Failures".
+func isSectionHeader(line []byte) bool {
+	for _, marker := range []string{"~~~ ", "--- ", "+++ ", "^^^ +++"} {
+		if bytes.HasPrefix(line, []byte(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
 // Logs is an RPC message that contains log data.
 type Logs struct {
 	Data []byte
+
+	// The ID of the client that wrote the logs
+	ID int
+}
+
+// SetLogPrefix is called by the client to prefix each line of its logs.
+func (r *Runner) SetLogPrefix(args LogPrefix, reply *Empty) error {
+	if args.ID < 0 || args.ID >= len(r.clients) {
+		return fmt.Errorf("unrecognized client id: %d", args.ID)
+	}
+	client := r.clients[args.ID]
+	client.mu.Lock()
+	client.LogPrefix = args.Prefix
+	client.mu.Unlock()
+	return nil
+}
+
+// LogPrefix is an RPC message that sets the log prefix for a client ID.
+type LogPrefix struct {
+	ID     int
+	Prefix string
 }
 
 // Exit is called when the client exits.
@@ -217,9 +372,14 @@ func (r *Runner) Exit(args ExitCode, reply *Empty) error {
 	client := r.clients[args.ID]
 	r.logger.Info("client %d exited with code %d", args.ID, args.ExitStatus)
 
+	if err := r.flushLogs(client); err != nil {
+		r.logger.Warn("Failed to write logs for client %d: %v", args.ID, err)
+	}
+
 	client.mu.Lock()
 	client.ExitStatus = args.ExitStatus
 	client.State = StateExited
+	client.Stats = args.Stats
 	client.mu.Unlock()
 
 	if args.ExitStatus != 0 {
@@ -247,6 +407,9 @@ func (r *Runner) Exit(args ExitCode, reply *Empty) error {
 type ExitCode struct {
 	ID         int
 	ExitStatus int
+
+	// The container's resource usage, if it could be read
+	Stats *ContainerStats
 }
 
 // Register is called when the client registers with the runner. The reply
@@ -271,6 +434,7 @@ func (r *Runner) Register(id int, reply *RegisterResponse) error {
 	client.State = StateConnected
 
 	reply.Env = r.conf.Env
+	reply.DependsOn = r.conf.DependsOn[id]
 	return nil
 }
 
@@ -278,6 +442,30 @@ func (r *Runner) Register(id int, reply *RegisterResponse) error {
 // needed to run.
 type RegisterResponse struct {
 	Env []string
+
+	// The IDs of the clients that must exit before this client can start
+	DependsOn []int
+}
+
+// Heartbeat is called periodically by the client while it is running, so that
+// the runner can tell if the container has disappeared (for example, if its
+// node was evicted) without waiting for the job to time out. It only tells the
+// client that the runner is still there: whether the job has finished or been
+// interrupted is left to Status.
+func (r *Runner) Heartbeat(id int, reply *Empty) error {
+	if id < 0 || id >= len(r.clients) {
+		return fmt.Errorf("unrecognized client id: %d", id)
+	}
+
+	client := r.clients[id]
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.State == StateNotYetConnected {
+		return fmt.Errorf("client id %d not registered", id)
+	}
+	client.LastHeardFrom = time.Now()
+	return nil
 }
 
 // Status is called by the client to check the status of the job, so that it can
@@ -302,19 +490,17 @@ func (r *Runner) Status(id int, reply *RunState) error {
 		return nil
 
 	default:
-		// First client should start first.
-		if id == 0 {
-			*reply = RunStateStart
-			return nil
-		}
-
-		// Client N can start after Client N-1 has exited.
-		client := r.clients[id-1]
-		client.mu.Lock()
-		defer client.mu.Unlock()
-		if client.State == StateExited {
-			*reply = RunStateStart
+		// A client can start once all the clients it depends on have exited.
+		for _, dep := range r.conf.DependsOn[id] {
+			client := r.clients[dep]
+			client.mu.Lock()
+			state := client.State
+			client.mu.Unlock()
+			if state != StateExited {
+				return nil
+			}
 		}
+		*reply = RunStateStart
 		return nil
 	}
 }
@@ -342,6 +528,11 @@ type clientResult struct {
 	ExitStatus    int
 	State         ClientState
 	LastHeardFrom time.Time
+	Stats         *ContainerStats
+	LogPrefix     string
+
+	// The end of the client's output, which doesn't end with a newline yet
+	partialLine []byte
 }
 
 type ClientState int
@@ -370,3 +561,38 @@ func (w waitStatus) Signal() syscall.Signal {
 func (w waitStatus) Signaled() bool {
 	return false
 }
+
+// summary describes how the client exited and its resource usage. c.mu must
+// be held.
+func (c *clientResult) summary() string {
+	var status string
+	switch c.State {
+	case StateNotYetConnected:
+		return "never connected"
+	case StateConnected:
+		return "still running"
+	case StateLost:
+		return "lost (stopped communicating without exiting)"
+	case StateExited:
+		status = fmt.Sprintf("exited with status %d", c.ExitStatus)
+	}
+
+	if c.Stats == nil {
+		return status + ", resource usage unknown"
+	}
+	if c.Stats.OOMKills > 0 {
+		status += fmt.Sprintf(" (OOM killed %d %s)", c.Stats.OOMKills, pluralize(c.Stats.OOMKills, "process", "processes"))
+	}
+	peak := "unknown"
+	if c.Stats.PeakMemoryBytes > 0 {
+		peak = humanize.IBytes(c.Stats.PeakMemoryBytes)
+	}
+	return fmt.Sprintf("%s, peak memory %s, CPU time %v", status, peak, c.Stats.CPUTime.Round(time.Millisecond))
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
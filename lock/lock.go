@@ -39,6 +39,36 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return c.client.LockGet(ctx, key)
 }
 
+// Status describes a lock that is currently held (or a do-once section in
+// progress or done).
+type Status struct {
+	Key        string
+	Value      string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time // zero if the lock doesn't expire
+}
+
+// List retrieves the state of all the locks that are currently held.
+func (c *Client) List(ctx context.Context) ([]Status, error) {
+	infos, err := c.client.LockList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(infos))
+	for _, info := range infos {
+		st := Status{
+			Key:        info.Key,
+			Value:      info.Value,
+			AcquiredAt: info.SetAt,
+		}
+		if info.ExpiresAt != nil {
+			st.ExpiresAt = *info.ExpiresAt
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
 // Locker returns a sync.Mutex-like object that uses the client to perform
 // locking. Any errors encountered by the client while locking or unlocking
 // (for example, the agent running the API stops running) will cause a panic
@@ -55,6 +85,13 @@ func (c *Client) Locker(key string) sync.Locker {
 // token or an error. The token must be passed to Unlock in order to unlock the
 // lock later on.
 func (c *Client) Lock(ctx context.Context, key string) (string, error) {
+	return c.LockWithTTL(ctx, key, 0)
+}
+
+// LockWithTTL is like Lock, but if ttl is positive the lock is released
+// automatically once it has been held for that long, so that a holder that
+// crashes doesn't keep it forever. After that, Unlock returns an error.
+func (c *Client) LockWithTTL(ctx context.Context, key string, ttl time.Duration) (string, error) {
 	// The token generation only has to avoid making the same token twice to
 	// prevent separate processes unlocking each other.
 	// Using crypto/rand to generate 16 bytes is possibly overkill - it's not a
@@ -65,19 +102,36 @@ func (c *Client) Lock(ctx context.Context, key string) (string, error) {
 	}
 	token := fmt.Sprintf("acquired(pid=%d,otp=%x)", os.Getpid(), otp)
 
+	if err := c.LockAs(ctx, key, token, ttl); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// JobToken returns the token to lock with LockAs on behalf of a job. When the
+// job finishes, the agent unlocks any locks still held with it, in case the
+// process that held them exited without unlocking them.
+func JobToken(jobID string) string {
+	return fmt.Sprintf("acquired(job=%s)", jobID)
+}
+
+// LockAs is like LockWithTTL, but locks with the given token instead of a new
+// one, so that the lock can also be unlocked by another process that knows the
+// token. The token must not be used by any other holder of the lock.
+func (c *Client) LockAs(ctx context.Context, key, token string, ttl time.Duration) error {
 	for {
-		_, done, err := c.client.LockCompareAndSwap(ctx, key, "", token)
+		_, done, err := c.client.LockCompareAndSwapTTL(ctx, key, "", token, ttl)
 		if err != nil {
-			return "", fmt.Errorf("cas: %w", err)
+			return fmt.Errorf("cas: %w", err)
 		}
 
 		if done {
-			return token, nil
+			return nil
 		}
 
 		// Not done.
 		if err := sleep(ctx, localSocketSleepDuration); err != nil {
-			return "", err
+			return err
 		}
 	}
 }
@@ -33,9 +33,9 @@ func testLogger(t *testing.T) logger.Logger {
 func testServerAndClient(t *testing.T, ctx context.Context) (*agentapi.Server, *Client) {
 	t.Helper()
 	sockPath, logger := testSocketPath(), testLogger(t)
-	svr, err := agentapi.NewServer(sockPath, logger)
+	svr, err := agentapi.NewServer(sockPath, logger, nil)
 	if err != nil {
-		t.Fatalf("NewServer(%q, logger) = error %v", sockPath, err)
+		t.Fatalf("NewServer(%q, logger, nil) = error %v", sockPath, err)
 	}
 	if err := svr.Start(); err != nil {
 		t.Fatalf("svr.Start() = %v", err)
@@ -84,6 +84,32 @@ func TestLockUnlock(t *testing.T) {
 	}
 }
 
+func TestLockAsJobToken(t *testing.T) {
+	t.Parallel()
+	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(canc)
+
+	svr, cli := testServerAndClient(t, ctx)
+	t.Cleanup(func() { svr.Close() })
+
+	token := JobToken("job-1")
+	if err := cli.LockAs(ctx, "llama", token, 0); err != nil {
+		t.Fatalf("Client.LockAs(ctx, llama, %q, 0) = %v", token, err)
+	}
+
+	// Someone else that knows the job can find and unlock it
+	statuses, err := cli.List(ctx)
+	if err != nil {
+		t.Fatalf("Client.List(ctx) error = %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Key != "llama" || statuses[0].Value != token {
+		t.Fatalf("Client.List(ctx) = %v, want llama held with %q", statuses, token)
+	}
+	if err := cli.Unlock(ctx, "llama", JobToken("job-1")); err != nil {
+		t.Errorf("Client.Unlock(ctx, llama, %q) = %v, want nil", token, err)
+	}
+}
+
 func TestLocker(t *testing.T) {
 	t.Parallel()
 	ctx, canc := context.WithTimeout(context.Background(), 10*time.Second)
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// JobFileEnv is the environment variable that names a job's metrics file.
+//
+// The job executor, and the buildkite-agent commands a job runs, are
+// subprocesses without a Collector of their own. When the agent is collecting
+// metrics, the job runner gives the job a file for them to append metrics to,
+// and reports what they recorded once the job finishes.
+const JobFileEnv = "BUILDKITE_JOB_METRICS_FILE"
+
+// Kinds of metric in a job's metrics file.
+const (
+	jobFileTiming = "timing" // with the value in nanoseconds
+	jobFileCount  = "count"
+)
+
+// jobFileRecord is a line of a job's metrics file.
+type jobFileRecord struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Value int64  `json:"value"`
+	Tags  Tags   `json:"tags,omitempty"`
+}
+
+// AppendJobTiming appends a timing to the job's metrics file, if the job has
+// one.
+func AppendJobTiming(name string, value time.Duration, tags Tags) error {
+	return appendJobFile(jobFileRecord{Name: name, Kind: jobFileTiming, Value: int64(value), Tags: tags})
+}
+
+// AppendJobCount appends a count to the job's metrics file, if the job has
+// one.
+func AppendJobCount(name string, value int64, tags Tags) error {
+	return appendJobFile(jobFileRecord{Name: name, Kind: jobFileCount, Value: value, Tags: tags})
+}
+
+func appendJobFile(rec jobFileRecord) error {
+	path := os.Getenv(JobFileEnv)
+	if path == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshalling metric: %w", err)
+	}
+
+	// Several processes may append at once, so each line is written in a
+	// single write to a file opened for appending.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("opening job metrics file: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing job metrics file: %w", err)
+	}
+	return f.Close()
+}
+
+// ReportJobFile reports the metrics in a job's metrics file to the scope.
+// Lines that can't be parsed are skipped, and counted in the returned error.
+func (s *Scope) ReportJobFile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening job metrics file: %w", err)
+	}
+	defer f.Close()
+
+	invalid := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec jobFileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Name == "" {
+			invalid++
+			continue
+		}
+		switch rec.Kind {
+		case jobFileTiming:
+			s.Timing(rec.Name, time.Duration(rec.Value), rec.Tags)
+		case jobFileCount:
+			s.Count(rec.Name, rec.Value, rec.Tags)
+		default:
+			invalid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading job metrics file: %w", err)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("skipped %d invalid lines in job metrics file", invalid)
+	}
+	return nil
+}
+
+// Enabled reports whether metrics sent to the scope are collected. A nil
+// Scope discards metrics.
+func (s *Scope) Enabled() bool {
+	return s != nil && (s.c.client != nil || s.c.meter != nil)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestJobFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job-metrics")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q, nil, 0o600) = %v", path, err)
+	}
+	t.Setenv(JobFileEnv, path)
+
+	if err := AppendJobTiming("jobs.phase.duration", 2*time.Second, Tags{"phase": "command"}); err != nil {
+		t.Fatalf("AppendJobTiming(...) = %v", err)
+	}
+	if err := AppendJobCount("artifacts.uploaded_bytes", 100, nil); err != nil {
+		t.Fatalf("AppendJobCount(...) = %v", err)
+	}
+	if err := AppendJobCount("artifacts.uploaded_bytes", 20, nil); err != nil {
+		t.Fatalf("AppendJobCount(...) = %v", err)
+	}
+
+	reader := sdkmetric.NewManualReader()
+	c := NewCollector(logger.Discard, CollectorConfig{})
+	c.startOTLP(reader)
+
+	if err := c.Scope(Tags{"queue": "default"}).ReportJobFile(path); err != nil {
+		t.Fatalf("ReportJobFile(%q) = %v", path, err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect(ctx, &rm) = %v", err)
+	}
+
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	sum, ok := got["buildkite.artifacts.uploaded_bytes"].(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 {
+		t.Fatalf("buildkite.artifacts.uploaded_bytes = %#v, want a Sum[int64] with one data point", got["buildkite.artifacts.uploaded_bytes"])
+	}
+	if dp := sum.DataPoints[0]; dp.Value != 120 {
+		t.Errorf("buildkite.artifacts.uploaded_bytes data point = %d, want 120", dp.Value)
+	}
+
+	wantAttrs := attribute.NewSet(attribute.String("queue", "default"), attribute.String("phase", "command"))
+	hist, ok := got["buildkite.jobs.phase.duration"].(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 {
+		t.Fatalf("buildkite.jobs.phase.duration = %#v, want a Histogram[float64] with one data point", got["buildkite.jobs.phase.duration"])
+	}
+	if dp := hist.DataPoints[0]; dp.Count != 1 || dp.Sum != 2000 || !dp.Attributes.Equals(&wantAttrs) {
+		t.Errorf("buildkite.jobs.phase.duration data point = (count %d, sum %v, %v), want (count 1, sum 2000, %v)", dp.Count, dp.Sum, dp.Attributes.Encoded(attribute.DefaultEncoder()), wantAttrs.Encoded(attribute.DefaultEncoder()))
+	}
+}
+
+func TestAppendJobFileWithoutFile(t *testing.T) {
+	t.Setenv(JobFileEnv, "")
+
+	if err := AppendJobCount("artifacts.uploaded_bytes", 1, nil); err != nil {
+		t.Errorf("AppendJobCount(...) without %s = %v, want nil", JobFileEnv, err)
+	}
+}
+
+func TestReportJobFileSkipsInvalidLines(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "job-metrics")
+	lines := `{"name":"jobs.phase.duration","kind":"timing","value":1}
+not json
+{"name":"jobs.phase.duration","kind":"gauge","value":1}
+`
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q, ...) = %v", path, err)
+	}
+
+	c := NewCollector(logger.Discard, CollectorConfig{})
+	c.startOTLP(sdkmetric.NewManualReader())
+
+	err := c.Scope(nil).ReportJobFile(path)
+	if err == nil || !strings.Contains(err.Error(), "skipped 2 invalid lines") {
+		t.Errorf("ReportJobFile(%q) = %v, want an error about 2 invalid lines", path, err)
+	}
+}
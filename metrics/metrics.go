@@ -1,10 +1,14 @@
-// Package metrics provides a wrapper around Datadog metrics collection.
+// Package metrics provides a wrapper around Datadog and OpenTelemetry (OTLP)
+// metrics collection.
 //
 // It is intended for internal use by buildkite-agent only.
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
@@ -12,6 +16,13 @@ import (
 
 	"github.com/DataDog/datadog-go/v5/statsd"
 	"github.com/buildkite/agent/v3/logger"
+	"github.com/buildkite/agent/v3/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 )
 
 const (
@@ -21,18 +32,31 @@ const (
 
 	// The default port for dogstatsd
 	defaultDogStatsdPort = 8125
+
+	// Prefix for OTLP metric names, matching the dogstatsd namespace
+	otlpNamespace = "buildkite."
+
+	// How long to wait for the final OTLP export when stopping
+	otlpShutdownTimeout = 10 * time.Second
 )
 
 type Collector struct {
 	config CollectorConfig
 	logger logger.Logger
 	client *statsd.Client
+
+	meterProvider *sdkmetric.MeterProvider
+	meter         metric.Meter
 }
 
 type CollectorConfig struct {
 	Datadog              bool
 	DatadogHost          string
 	DatadogDistributions bool
+
+	// OTLPEndpoint is the URL of an OTLP gRPC endpoint to export metrics to,
+	// such as http://localhost:4317. Metrics aren't exported if it is empty.
+	OTLPEndpoint string
 }
 
 func NewCollector(l logger.Logger, c CollectorConfig) *Collector {
@@ -61,15 +85,50 @@ func (c *Collector) Start() error {
 			return err
 		}
 	}
+
+	if c.config.OTLPEndpoint != "" {
+		u, err := url.Parse(c.config.OTLPEndpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("OTLP metrics endpoint %q is not a URL such as http://localhost:4317", c.config.OTLPEndpoint)
+		}
+
+		c.logger.Info("Starting OTLP metrics export to %s", c.config.OTLPEndpoint)
+
+		exporter, err := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithEndpointURL(c.config.OTLPEndpoint))
+		if err != nil {
+			return fmt.Errorf("creating OTLP metrics exporter: %w", err)
+		}
+		c.startOTLP(sdkmetric.NewPeriodicReader(exporter))
+	}
 	return nil
 }
 
+// startOTLP sets up the collector to record OTLP metrics to the reader.
+func (c *Collector) startOTLP(reader sdkmetric.Reader) {
+	c.meterProvider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("buildkite-agent"),
+			semconv.ServiceVersionKey.String(version.Version()),
+		)),
+	)
+	c.meter = c.meterProvider.Meter("buildkite-agent")
+}
+
 func (c *Collector) Stop() error {
+	var errs []error
 	if c.config.Datadog && c.client != nil {
 		c.logger.Info("Stopping metrics collection")
-		return c.client.Close()
+		errs = append(errs, c.client.Close())
 	}
-	return nil
+	if c.meterProvider != nil {
+		c.logger.Info("Stopping OTLP metrics export")
+		ctx, cancel := context.WithTimeout(context.Background(), otlpShutdownTimeout)
+		defer cancel()
+		errs = append(errs, c.meterProvider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
 }
 
 func (c *Collector) Scope(tags Tags) *Scope {
@@ -84,15 +143,29 @@ type Scope struct {
 	c    *Collector
 }
 
-// Timing sends timing information in milliseconds.
+// Timing sends timing information in milliseconds. A nil Scope discards it.
 func (s *Scope) Timing(name string, value time.Duration, tags ...Tags) {
-	if s.c.client == nil {
+	if s == nil || (s.c.client == nil && s.c.meter == nil) {
 		return
 	}
 
-	mergedTags := s.mergeTags(tags...).StringSlice()
+	merged := s.mergeTags(tags...)
+	mergedTags := merged.StringSlice()
 	s.c.logger.Debug("Metrics timing %s=%v %v", name, value, mergedTags)
 
+	if s.c.meter != nil {
+		h, err := s.c.meter.Float64Histogram(otlpNamespace+name, metric.WithUnit("ms"))
+		if err != nil {
+			s.c.logger.Error("Metrics timing failed: %v", err)
+		} else {
+			h.Record(context.Background(), float64(value.Milliseconds()), metric.WithAttributes(merged.attributes()...))
+		}
+	}
+
+	if s.c.client == nil {
+		return
+	}
+
 	var err error
 	if s.c.config.DatadogDistributions {
 		// Datadog recommends that, as distributions are a new distinct metric,
@@ -118,15 +191,30 @@ func (s *Scope) With(tags Tags) *Scope {
 	}
 }
 
-// Count tracks how many times something happened per second.
+// Count tracks how many times something happened per second. A nil Scope
+// discards it.
 func (s *Scope) Count(name string, value int64, tags ...Tags) {
-	if s.c.client == nil {
+	if s == nil || (s.c.client == nil && s.c.meter == nil) {
 		return
 	}
 
-	mergedTags := s.mergeTags(tags...).StringSlice()
+	merged := s.mergeTags(tags...)
+	mergedTags := merged.StringSlice()
 	s.c.logger.Debug("Metrics count %s=%v %v", name, value, mergedTags)
 
+	if s.c.meter != nil {
+		counter, err := s.c.meter.Int64Counter(otlpNamespace + name)
+		if err != nil {
+			s.c.logger.Error("Metrics count failed: %v", err)
+		} else {
+			counter.Add(context.Background(), value, metric.WithAttributes(merged.attributes()...))
+		}
+	}
+
+	if s.c.client == nil {
+		return
+	}
+
 	if err := s.c.client.Count(name, value, mergedTags, 1); err != nil {
 		s.c.logger.Error("Metrics count failed: %v", err)
 	}
@@ -158,6 +246,18 @@ func (tags Tags) StringSlice() []string {
 	return stringSlice
 }
 
+// attributes returns the tags as OpenTelemetry attributes, skipping empty ones
+// like StringSlice does.
+func (tags Tags) attributes() []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(tags))
+	for k, v := range tags {
+		if k != "" && v != "" {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+	}
+	return attrs
+}
+
 // Datadog allows '.', '_' and alphas only.
 // If we don't validate this here then the datadog error logs can fill up disk really quickly
 var nameRegex = regexp.MustCompile(`[^\._a-zA-Z0-9]+`)
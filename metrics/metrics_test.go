@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent/v3/logger"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTLPMetrics(t *testing.T) {
+	t.Parallel()
+
+	reader := sdkmetric.NewManualReader()
+	c := NewCollector(logger.Discard, CollectorConfig{})
+	c.startOTLP(reader)
+
+	scope := c.Scope(Tags{"queue": "default"}).With(Tags{"exit_code": "0"})
+	scope.Count("jobs.success", 1)
+	scope.Count("jobs.success", 2)
+	scope.Timing("jobs.duration.success", 1500*time.Millisecond)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("reader.Collect(ctx, &rm) = %v", err)
+	}
+
+	got := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = m.Data
+		}
+	}
+
+	wantAttrs := attribute.NewSet(attribute.String("queue", "default"), attribute.String("exit_code", "0"))
+
+	sum, ok := got["buildkite.jobs.success"].(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 {
+		t.Fatalf("buildkite.jobs.success = %#v, want a Sum[int64] with one data point", got["buildkite.jobs.success"])
+	}
+	if dp := sum.DataPoints[0]; dp.Value != 3 || !dp.Attributes.Equals(&wantAttrs) {
+		t.Errorf("buildkite.jobs.success data point = (%d, %v), want (3, %v)", dp.Value, dp.Attributes.Encoded(attribute.DefaultEncoder()), wantAttrs.Encoded(attribute.DefaultEncoder()))
+	}
+
+	hist, ok := got["buildkite.jobs.duration.success"].(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 {
+		t.Fatalf("buildkite.jobs.duration.success = %#v, want a Histogram[float64] with one data point", got["buildkite.jobs.duration.success"])
+	}
+	if dp := hist.DataPoints[0]; dp.Count != 1 || dp.Sum != 1500 || !dp.Attributes.Equals(&wantAttrs) {
+		t.Errorf("buildkite.jobs.duration.success data point = (count %d, sum %v), want (count 1, sum 1500)", dp.Count, dp.Sum)
+	}
+}
+
+func TestStartRejectsInvalidOTLPEndpoint(t *testing.T) {
+	t.Parallel()
+
+	c := NewCollector(logger.Discard, CollectorConfig{OTLPEndpoint: "localhost:4317"})
+	if err := c.Start(); err == nil {
+		t.Errorf("Collector{OTLPEndpoint: localhost:4317}.Start() = nil, want error")
+	}
+}
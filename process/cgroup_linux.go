@@ -0,0 +1,29 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// setupCgroup makes the process start in the cgroup at p.conf.CgroupPath. It
+// returns a func to call once the process has started.
+func (p *Process) setupCgroup() (func(), error) {
+	if p.conf.CgroupPath == "" {
+		return func() {}, nil
+	}
+
+	dir, err := os.Open(p.conf.CgroupPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening cgroup: %w", err)
+	}
+
+	if p.command.SysProcAttr == nil {
+		p.command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	p.command.SysProcAttr.UseCgroupFD = true
+	p.command.SysProcAttr.CgroupFD = int(dir.Fd())
+
+	p.logger.Debug("[Process] Starting process in cgroup %s", p.conf.CgroupPath)
+	return func() { _ = dir.Close() }, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package process
+
+import "errors"
+
+func (p *Process) setupCgroup() (func(), error) {
+	if p.conf.CgroupPath != "" {
+		return nil, errors.New("cgroups are only supported on Linux")
+	}
+	return func() {}, nil
+}
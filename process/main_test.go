@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/buildkite/agent/v3/process"
+	"golang.org/x/term"
 )
 
 // Invoked by `go test`, switch between helper and running tests based on env
@@ -80,6 +81,26 @@ func TestMain(m *testing.M) {
 		fmt.Printf("pid %d == pgid %d", pid, pgid)
 		os.Exit(0)
 
+	// prints the size of the terminal, and again when it changes
+	case "tester-pty-size":
+		cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%dx%d\n", rows, cols)
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			newCols, newRows, err := term.GetSize(int(os.Stdout.Fd()))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if newCols != cols || newRows != rows {
+				fmt.Printf("%dx%d\n", newRows, newCols)
+				os.Exit(0)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		log.Fatal("The terminal wasn't resized")
+
 	default:
 		os.Exit(m.Run())
 	}
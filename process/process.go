@@ -23,6 +23,10 @@ import (
 
 const (
 	termType = "xterm-256color"
+
+	// The size of the PTY, unless Config says otherwise
+	DefaultPTYRows = 100
+	DefaultPTYCols = 160
 )
 
 type Signal int
@@ -75,6 +79,8 @@ func ParseSignal(sig string) (Signal, error) {
 // Configuration for a Process
 type Config struct {
 	PTY               bool
+	PTYRows           uint16
+	PTYCols           uint16
 	Timestamp         bool
 	Path              string
 	Args              []string
@@ -85,6 +91,14 @@ type Config struct {
 	Dir               string
 	InterruptSignal   Signal
 	SignalGracePeriod time.Duration
+
+	// The cgroup v2 directory to start the process in, so that everything it
+	// starts is in the cgroup too. Only supported on Linux.
+	CgroupPath string
+
+	// Whether Env is the whole environment of the process, rather than being
+	// merged over the current process's environment.
+	ReplaceEnv bool
 }
 
 // Process is an operating system level process
@@ -99,6 +113,12 @@ type Process struct {
 	pid           int
 	started, done chan struct{}
 
+	// When the process was first interrupted, if it has been
+	interruptedAt time.Time
+
+	// The PTY the process is running in, if it is
+	pty *os.File
+
 	winJobHandle uintptr
 }
 
@@ -139,6 +159,13 @@ func (p *Process) Run(ctx context.Context) error {
 	// Setup the process to create a process group if supported
 	p.setupProcessGroup()
 
+	// Start the process in its cgroup, if it has one
+	closeCgroup, err := p.setupCgroup()
+	if err != nil {
+		return err
+	}
+	defer closeCgroup()
+
 	// Configure working dir and fail if it doesn't exist, otherwise
 	// we get confusing errors about fork/exec failing because the file
 	// doesn't exist
@@ -164,6 +191,9 @@ func (p *Process) Run(ctx context.Context) error {
 	// the top of the current one so the ENV from Buildkite and the agent
 	// take precedence over the agent
 	currentEnv := os.Environ()
+	if p.conf.ReplaceEnv {
+		currentEnv = nil
+	}
 	p.command.Env = append(currentEnv, p.conf.Env...)
 
 	var waitGroup sync.WaitGroup
@@ -175,13 +205,26 @@ func (p *Process) Run(ctx context.Context) error {
 		// Commands like tput expect a TERM value for a PTY
 		p.command.Env = append(p.command.Env, "TERM="+termType)
 
-		pty, err := StartPTY(p.command)
+		rows, cols := p.conf.PTYRows, p.conf.PTYCols
+		if rows == 0 {
+			rows = DefaultPTYRows
+		}
+		if cols == 0 {
+			cols = DefaultPTYCols
+		}
+
+		pty, err := StartPTY(p.command, rows, cols)
 		if err != nil {
 			return fmt.Errorf("error starting pty: %w", err)
 		}
 
 		// Make sure to close the pty at the end.
-		defer func() { _ = pty.Close() }()
+		defer func() {
+			p.mu.Lock()
+			p.pty = nil
+			p.mu.Unlock()
+			_ = pty.Close()
+		}()
 
 		if experiments.IsEnabled(ctx, experiments.PTYRaw) {
 			p.logger.Debug("[Process] Setting raw mode for PTY %s (fd:%d)", pty.Name(), pty.Fd())
@@ -194,6 +237,7 @@ func (p *Process) Run(ctx context.Context) error {
 
 		p.mu.Lock()
 		p.pid = p.command.Process.Pid
+		p.pty = pty
 		p.mu.Unlock()
 
 		// Signal waiting consumers in Started() by closing the started channel
@@ -284,6 +328,9 @@ func (p *Process) Run(ctx context.Context) error {
 	// exits with a zero exit status.
 	p.waitResult = p.command.Wait()
 
+	// Clean up anything the process left running
+	p.cleanupProcessGroup()
+
 	// Signal waiting consumers in Done() by closing the done channel
 	close(p.done)
 
@@ -313,8 +360,7 @@ func (p *Process) Run(ctx context.Context) error {
 	// Sometimes (in docker containers) io.Copy never seems to finish. This is a mega
 	// hack around it. If it doesn't finish after 1 second, just continue.
 	p.logger.Debug("[Process] Waiting for routines to finish")
-	err := timeoutWait(&waitGroup)
-	if err != nil {
+	if err := timeoutWait(&waitGroup); err != nil {
 		p.logger.Debug("[Process] Timed out waiting for wait group: (%T: %v)", err, err)
 	}
 
@@ -345,6 +391,25 @@ func (p *Process) Started() <-chan struct{} {
 	return d
 }
 
+// ErrNoPTY is returned by Resize when the process isn't running in a PTY.
+var ErrNoPTY = errors.New("process isn't running in a PTY")
+
+// Resize changes the size of the PTY the process is running in.
+func (p *Process) Resize(rows, cols uint16) error {
+	if p == nil {
+		return ErrNoPTY
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pty == nil {
+		return ErrNoPTY
+	}
+	p.logger.Debug("[Process] Resizing PTY to %d rows and %d columns", rows, cols)
+	return resizePTY(p.pty, rows, cols)
+}
+
 // Interrupt the process on platforms that support it, terminate otherwise
 func (p *Process) Interrupt() error {
 	if p == nil {
@@ -359,6 +424,10 @@ func (p *Process) Interrupt() error {
 		return nil
 	}
 
+	if p.interruptedAt.IsZero() {
+		p.interruptedAt = time.Now()
+	}
+
 	// interrupt the process (ctrl-c or SIGINT)
 	if err := p.interruptProcessGroup(); err != nil {
 		p.logger.Error("[Process] Failed to interrupt process %d: %v", p.pid, err)
@@ -1,8 +1,10 @@
 package process_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
 	"runtime"
@@ -116,6 +118,60 @@ func TestProcessOutputPTY_PTYRawExperiment(t *testing.T) {
 	assertProcessDoesntExist(t, p)
 }
 
+func TestProcessPTYSizeAndResize(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("PTY not supported on windows")
+	}
+
+	pr, pw := io.Pipe()
+	p := process.New(logger.Discard, process.Config{
+		Path:    os.Args[0],
+		Env:     []string{"TEST_MAIN=tester-pty-size"},
+		PTY:     true,
+		PTYRows: 40,
+		PTYCols: 120,
+		Stdout:  pw,
+	})
+
+	if err := p.Resize(50, 200); !errors.Is(err, process.ErrNoPTY) {
+		t.Errorf("p.Resize(50, 200) before Run error = %v, want %v", err, process.ErrNoPTY)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.Run(context.Background())
+		pw.Close()
+	}()
+
+	lines := bufio.NewScanner(pr)
+	if !lines.Scan() {
+		t.Fatalf("no output from process: %v", lines.Err())
+	}
+	if got, want := strings.TrimSpace(lines.Text()), "40x120"; got != want {
+		t.Errorf("initial size = %q, want %q", got, want)
+	}
+
+	if err := p.Resize(50, 200); err != nil {
+		t.Fatalf("p.Resize(50, 200) error = %v", err)
+	}
+	if !lines.Scan() {
+		t.Fatalf("no output from process after resizing: %v", lines.Err())
+	}
+	if got, want := strings.TrimSpace(lines.Text()), "50x200"; got != want {
+		t.Errorf("size after resizing = %q, want %q", got, want)
+	}
+
+	_, _ = io.Copy(io.Discard, pr)
+	if err := <-errs; err != nil {
+		t.Fatalf("p.Run() = %v", err)
+	}
+	if err := p.Resize(50, 200); !errors.Is(err, process.ErrNoPTY) {
+		t.Errorf("p.Resize(50, 200) after Run error = %v, want %v", err, process.ErrNoPTY)
+	}
+}
+
 func TestProcessInput(t *testing.T) {
 	t.Parallel()
 
@@ -10,11 +10,15 @@ import (
 	"github.com/creack/pty"
 )
 
-func StartPTY(c *exec.Cmd) (*os.File, error) {
+func StartPTY(c *exec.Cmd, rows, cols uint16) (*os.File, error) {
 	return pty.StartWithSize(c, &pty.Winsize{
-		Rows: 100,
-		Cols: 160,
+		Rows: rows,
+		Cols: cols,
 		X:    0, // unused
 		Y:    0, // unused
 	})
 }
+
+func resizePTY(f *os.File, rows, cols uint16) error {
+	return pty.Setsize(f, &pty.Winsize{Rows: rows, Cols: cols})
+}
@@ -6,6 +6,10 @@ import (
 	"os/exec"
 )
 
-func StartPTY(c *exec.Cmd) (*os.File, error) {
+func StartPTY(c *exec.Cmd, rows, cols uint16) (*os.File, error) {
 	return nil, errors.New("PTY is not supported on Windows")
 }
+
+func resizePTY(f *os.File, rows, cols uint16) error {
+	return errors.New("PTY is not supported on Windows")
+}
@@ -32,6 +32,10 @@ func (p *Process) postStart() error {
 	return nil
 }
 
+func (p *Process) cleanupProcessGroup() {
+	// a no-op on non-windows
+}
+
 func (p *Process) terminateProcessGroup() error {
 	// Note: terminateProcessGroup is called from within p.Terminate, which
 	// holds p.mu.
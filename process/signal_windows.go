@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -39,20 +40,53 @@ func newJobObject() (uintptr, error) {
 		return 0, err
 	}
 
+	if err := setJobObjectLimitFlags(handle, windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE); err != nil {
+		return 0, err
+	}
+
+	return uintptr(handle), nil
+}
+
+func setJobObjectLimitFlags(handle windows.Handle, flags uint32) error {
 	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
 		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
-			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+			LimitFlags: flags,
 		},
 	}
-	if _, err := windows.SetInformationJobObject(
+	_, err := windows.SetInformationJobObject(
 		handle,
 		windows.JobObjectExtendedLimitInformation,
 		uintptr(unsafe.Pointer(&info)),
-		uint32(unsafe.Sizeof(info))); err != nil {
+		uint32(unsafe.Sizeof(info)))
+	return err
+}
+
+// jobObjectBasicAccountingInformation is JOBOBJECT_BASIC_ACCOUNTING_INFORMATION,
+// which golang.org/x/sys/windows doesn't define.
+type jobObjectBasicAccountingInformation struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+// activeJobObjectProcesses returns how many processes in the job object are
+// still running.
+func activeJobObjectProcesses(handle windows.Handle) (uint32, error) {
+	var info jobObjectBasicAccountingInformation
+	if err := windows.QueryInformationJobObject(
+		handle,
+		windows.JobObjectBasicAccountingInformation,
+		uintptr(unsafe.Pointer(&info)),
+		uint32(unsafe.Sizeof(info)),
+		nil); err != nil {
 		return 0, err
 	}
-
-	return uintptr(handle), nil
+	return info.ActiveProcesses, nil
 }
 
 func (p *Process) postStart() error {
@@ -73,9 +107,68 @@ func (p *Process) postStart() error {
 	return nil
 }
 
+// cleanupProcessGroup is called once the process has exited. If it was
+// interrupted, anything it left running in the job object (such as node or
+// msbuild) gets the rest of the signal grace period to exit before the whole
+// job object is terminated. Otherwise anything left running is released from
+// the job object, so closing the job object doesn't kill it.
+func (p *Process) cleanupProcessGroup() {
+	p.mu.Lock()
+	handle := windows.Handle(p.winJobHandle)
+	interruptedAt := p.interruptedAt
+	p.mu.Unlock()
+
+	if handle == 0 {
+		return
+	}
+
+	defer func() {
+		p.mu.Lock()
+		p.winJobHandle = 0
+		p.mu.Unlock()
+		if err := windows.CloseHandle(handle); err != nil {
+			p.logger.Warn("[Process] Failed to close job object: %v", err)
+		}
+	}()
+
+	if interruptedAt.IsZero() {
+		if err := setJobObjectLimitFlags(handle, 0); err != nil {
+			p.logger.Warn("[Process] Failed to release processes from job object: %v", err)
+		}
+		return
+	}
+
+	deadline := interruptedAt.Add(p.conf.SignalGracePeriod)
+	for {
+		active, err := activeJobObjectProcesses(handle)
+		if err != nil {
+			p.logger.Warn("[Process] Failed to count processes in job object: %v", err)
+			break
+		}
+		if active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			p.logger.Warn("[Process] %d processes left running after the signal grace period, terminating them", active)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := windows.TerminateJobObject(handle, 1); err != nil {
+		p.logger.Error("[Process] Failed to terminate job object: %v", err)
+	}
+}
+
 func (p *Process) terminateProcessGroup() error {
-	p.logger.Debug("[Process] Terminating process tree by destroying job")
-	return windows.CloseHandle(windows.Handle(p.winJobHandle))
+	// Note: terminateProcessGroup is called from within p.Terminate, which
+	// holds p.mu.
+	if p.winJobHandle == 0 {
+		p.logger.Debug("[Process] No job object, killing process %d", p.pid)
+		return p.command.Process.Kill()
+	}
+	p.logger.Debug("[Process] Terminating process tree by terminating job object")
+	return windows.TerminateJobObject(windows.Handle(p.winJobHandle), 1)
 }
 
 func (p *Process) interruptProcessGroup() error {
@@ -2,9 +2,11 @@ package tracetools
 
 import (
 	"context"
+	"slices"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -49,6 +51,7 @@ func StartSpanFromContext(ctx context.Context, operation string, tracingBackend
 
 type Span interface {
 	AddAttributes(map[string]string)
+	AddEvent(name string, attributes map[string]string)
 	FinishWithError(error)
 	RecordError(error)
 }
@@ -68,6 +71,15 @@ func (s *OpenTracingSpan) AddAttributes(attributes map[string]string) {
 	}
 }
 
+// AddEvent logs the named event on the span, with the given attributes as log fields
+func (s *OpenTracingSpan) AddEvent(name string, attributes map[string]string) {
+	fields := []log.Field{log.Event(name)}
+	for _, k := range sortedKeys(attributes) {
+		fields = append(fields, log.String(k, attributes[k]))
+	}
+	s.Span.LogFields(fields...)
+}
+
 // FinishWithError adds error information to the OpenTracingSpan if error isn't nil, and records the span as having finished
 func (s *OpenTracingSpan) FinishWithError(err error) {
 	s.RecordError(err)
@@ -98,6 +110,15 @@ func (s *OpenTelemetrySpan) AddAttributes(attributes map[string]string) {
 	}
 }
 
+// AddEvent adds the named event to the OpenTelemetry span, with the given attributes
+func (s *OpenTelemetrySpan) AddEvent(name string, attributes map[string]string) {
+	attrs := make([]attribute.KeyValue, 0, len(attributes))
+	for _, k := range sortedKeys(attributes) {
+		attrs = append(attrs, attribute.String(k, attributes[k]))
+	}
+	s.Span.AddEvent(name, trace.WithAttributes(attrs...))
+}
+
 // FinishWithError adds error information to the OpenTelemetry span if error isn't nil, and records the span as having finished
 func (s *OpenTelemetrySpan) FinishWithError(err error) {
 	s.RecordError(err)
@@ -122,8 +143,20 @@ type NoopSpan struct{}
 // AddAttributes is a noop
 func (s *NoopSpan) AddAttributes(attributes map[string]string) {}
 
+// AddEvent is a noop
+func (s *NoopSpan) AddEvent(name string, attributes map[string]string) {}
+
 // FinishWithError is a noop
 func (s *NoopSpan) FinishWithError(err error) {}
 
 // RecordError is a noop
 func (s *NoopSpan) RecordError(err error) {}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
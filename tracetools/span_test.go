@@ -49,6 +49,7 @@ type TestOtelSpan struct {
 	finished       bool
 	err            error
 	events         []string
+	eventAttrs     [][]attribute.KeyValue
 	spanContext    trace.SpanContext
 	statusCode     codes.Code
 	statusDesc     string
@@ -76,8 +77,10 @@ func (t *TestOtelSpan) SetStatus(code codes.Code, description string) {
 	t.statusCode, t.statusDesc = code, description
 }
 
-func (t *TestOtelSpan) AddEvent(name string, _ ...trace.EventOption) {
+func (t *TestOtelSpan) AddEvent(name string, options ...trace.EventOption) {
 	t.events = append(t.events, name)
+	cfg := trace.NewEventConfig(options...)
+	t.eventAttrs = append(t.eventAttrs, cfg.Attributes())
 }
 
 func newTestOtelSpan() *OpenTelemetrySpan {
@@ -111,6 +114,29 @@ func TestAddAttributeToSpan_OpenTelemetry(t *testing.T) {
 	assert.Contains(t, implSpan.attributes, attribute.String("flavour", "bittersweet"))
 }
 
+func TestAddEvent_OpenTracing(t *testing.T) {
+	t.Parallel()
+
+	span := newTestOpenTracingSpan()
+	implSpan, ok := span.Span.(*TestOpenTracingSpan)
+	assert.True(t, ok)
+
+	span.AddEvent("hook.output", map[string]string{"stream": "stdout", "output": "llamas"})
+	assert.Equal(t, []log.Field{log.Event("hook.output"), log.String("output", "llamas"), log.String("stream", "stdout")}, implSpan.fields)
+}
+
+func TestAddEvent_OpenTelemetry(t *testing.T) {
+	t.Parallel()
+
+	span := newTestOtelSpan()
+	implSpan, ok := span.Span.(*TestOtelSpan)
+	assert.True(t, ok)
+
+	span.AddEvent("hook.output", map[string]string{"stream": "stdout", "output": "alpacas"})
+	assert.Equal(t, []string{"hook.output"}, implSpan.events)
+	assert.Equal(t, [][]attribute.KeyValue{{attribute.String("output", "alpacas"), attribute.String("stream", "stdout")}}, implSpan.eventAttrs)
+}
+
 func TestFinishWithError_OpenTracing(t *testing.T) {
 	t.Parallel()
 	err := errors.New("test error")